@@ -1,28 +1,68 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
 
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
 	"github.com/wailsapp/wails/v2/pkg/options/assetserver"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
 
 	"github.com/adrianliechti/bridge"
 	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/logging"
 	"github.com/adrianliechti/bridge/pkg/server"
 )
 
 func main() {
-	cfg, err := config.New()
+	opts := &config.Options{
+		LogFile: filepath.Join(config.DataDir(), "logs", "bridge.log"),
+	}
+
+	opts.AddFlags(flag.CommandLine)
+	flag.Parse()
+
+	if err := run(opts); err != nil {
+		showError(err)
+		os.Exit(1)
+	}
+}
+
+func run(opts *config.Options) (err error) {
+	logs, err := logging.Setup(opts.LogFile)
+
+	if err != nil {
+		return err
+	}
+
+	defer logs.Close()
+
+	cfg, err := config.New(opts)
 
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	if cfg.CrashReports {
+		logging.EnableCrashReports(filepath.Join(config.DataDir(), "crashes"))
 	}
 
+	defer func() {
+		if v := recover(); v != nil {
+			logging.Crash(v, "")
+			err = fmt.Errorf("unexpected panic: %v", v)
+		}
+	}()
+
 	mux, err := server.New(cfg)
 
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	options := &options.App{
@@ -38,9 +78,43 @@ func main() {
 				mux.ServeHTTP(w, r)
 			}),
 		},
+
+		OnShutdown: func(ctx context.Context) {
+			mux.Close()
+		},
 	}
 
-	if err := wails.Run(options); err != nil {
-		panic(err)
+	return wails.Run(options)
+}
+
+// showError presents a fatal error in a native dialog, as desktop users
+// usually have no terminal attached to see it.
+func showError(err error) {
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+
+	options := &options.App{
+		Title: "Bridge",
+
+		Width:  400,
+		Height: 200,
+
+		StartHidden: true,
+
+		AssetServer: &assetserver.Options{
+			Assets: bridge.DistFS,
+		},
+
+		OnStartup: func(ctx context.Context) {
+			runtime.MessageDialog(ctx, runtime.MessageDialogOptions{
+				Type: runtime.ErrorDialog,
+
+				Title:   "Bridge failed to start",
+				Message: err.Error(),
+			})
+
+			runtime.Quit(ctx)
+		},
 	}
+
+	wails.Run(options)
 }
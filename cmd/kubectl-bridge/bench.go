@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/bench"
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+
+	options := &config.Options{}
+	options.AddFlags(fs)
+
+	opts := bench.Options{}
+
+	fs.StringVar(&opts.Context, "context", "", "benchmark an existing kubernetes context (e.g. kind) instead of the fake API server")
+	fs.IntVar(&opts.Resources, "resources", 5000, "number of pods served by the fake API server")
+	fs.IntVar(&opts.Concurrency, "concurrency", 16, "number of parallel list clients")
+	fs.DurationVar(&opts.Duration, "duration", 10*time.Second, "duration of the list throughput phase")
+	fs.IntVar(&opts.Watchers, "watchers", 100, "number of concurrent watch streams")
+	fs.IntVar(&opts.Events, "events", 200, "number of events broadcast to the watchers")
+
+	fs.Parse(args)
+
+	// proxy logs would drown the report
+	log.SetOutput(io.Discard)
+
+	cfg, err := config.New(options)
+
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	result, err := bench.Run(ctx, cfg, opts)
+
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	printThroughput(w, "list", result.List)
+	printThroughput(w, "docker", result.Docker)
+
+	if result.WatchEvents > 0 {
+		fmt.Fprintf(w, "watch events\t%d\n", result.WatchEvents)
+		fmt.Fprintf(w, "watch latency\t%s\n", result.WatchLatency)
+	}
+
+	fmt.Fprintf(w, "heap peak\t%.1f MB\n", float64(result.HeapPeak)/1e6)
+	fmt.Fprintf(w, "total alloc\t%.1f MB\n", float64(result.TotalAlloc)/1e6)
+	fmt.Fprintf(w, "goroutines\t%d\n", result.Goroutines)
+
+	return w.Flush()
+}
+
+func printThroughput(w io.Writer, name string, t bench.Throughput) {
+	rate := t.Rate * float64(t.Bytes) / float64(max(t.Requests, 1)) / 1e6
+
+	fmt.Fprintf(w, "%s requests\t%d (%d failed)\n", name, t.Requests, t.Failures)
+	fmt.Fprintf(w, "%s throughput\t%.1f req/s, %.1f MB/s\n", name, t.Rate, rate)
+	fmt.Fprintf(w, "%s latency\t%s\n", name, t.Latency)
+}
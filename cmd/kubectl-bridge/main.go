@@ -3,42 +3,169 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"log"
 	"net"
+	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/logging"
+	"github.com/adrianliechti/bridge/pkg/mdns"
 	"github.com/adrianliechti/bridge/pkg/server"
 )
 
 func main() {
-	cfg, err := config.New()
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := runBench(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "store" {
+		if err := runStore(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	options := &config.Options{}
+	options.AddFlags(flag.CommandLine)
+
+	flag.Parse()
+
+	if err := run(options); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(options *config.Options) (err error) {
+	logs, err := logging.Setup(options.LogFile)
 
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	port, err := getFreePort("localhost", 8888)
+	defer logs.Close()
+
+	cfg, err := config.New(options)
 
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	if cfg.CrashReports {
+		logging.EnableCrashReports(filepath.Join(config.DataDir(), "crashes"))
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			logging.Crash(v, "")
+			err = fmt.Errorf("unexpected panic: %v", v)
+		}
+	}()
+
+	host := "localhost"
+
+	if options.Share {
+		// the bridge acts with the credentials of the local kubeconfig
+		if cfg.Auth == nil {
+			return errors.New("sharing the bridge requires auth in the config file")
+		}
+
+		host = ""
+	}
+
+	inCluster := cfg.Kubernetes != nil && cfg.Kubernetes.InCluster
+
+	if inCluster {
+		// the bridge is reached through a service of the cluster
+		host = ""
+
+		if cfg.Auth == nil {
+			log.Printf("the bridge acts with the credentials of its service account, without auth anyone reaching it does")
+		}
+	}
+
+	port, err := getFreePort(host, 8888)
+
+	if err != nil {
+		return err
 	}
 
 	srv, err := server.New(cfg)
 
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	url := fmt.Sprintf("http://localhost:%d", port)
-	addr := fmt.Sprintf("localhost:%d", port)
+	addr := fmt.Sprintf("%s:%d", host, port)
+
+	if inCluster {
+		fmt.Printf("Bridge is running in the cluster on port %d\n", port)
+	} else {
+		openBrowser(url)
+		fmt.Printf("Bridge is running at %s\n", url)
+	}
+
+	// on interrupt the server shuts down, which closes its tunnels and
+	// forwards, and the defers run
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
 
-	openBrowser(url)
-	fmt.Printf("Bridge is running at %s\n", url)
+	if options.Share {
+		fmt.Printf("Bridge is shared in the local network on port %d\n", port)
+
+		wg.Go(func() {
+			share(ctx, cfg, port)
+		})
+	}
+
+	err = srv.ListenAndServe(ctx, addr)
+
+	// the advertisement is withdrawn before the bridge exits
+	stop()
+	wg.Wait()
+
+	return err
+}
+
+// share advertises the bridge via mDNS until ctx is done. The advertisement
+// is withdrawn then, so the bridge disappears from other devices.
+func share(ctx context.Context, cfg *config.Config, port int) {
+	hostname, _ := os.Hostname()
+	hostname, _, _ = strings.Cut(hostname, ".")
+
+	service := mdns.Service{
+		Instance: "Bridge on " + hostname,
+		Service:  "_bridge._tcp",
+
+		Port: port,
+
+		Text: []string{
+			"path=/",
+			"auth=" + cfg.Auth.Type,
+		},
+	}
 
-	if err := srv.ListenAndServe(context.Background(), addr); err != nil {
-		panic(err)
+	if err := mdns.Advertise(ctx, service); err != nil {
+		log.Printf("failed to advertise the bridge: %v", err)
 	}
 }
 
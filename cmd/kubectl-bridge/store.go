@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/server"
+	"github.com/adrianliechti/bridge/pkg/store"
+)
+
+func runStore(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: kubectl-bridge store compact|export [-o file]|wipe -y")
+	}
+
+	switch args[0] {
+	case "compact":
+		before, after, err := store.Compact(filepath.Join(config.DataDir(), server.StoreFile))
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("compacted store from %.1f MB to %.1f MB\n", float64(before)/1e6, float64(after)/1e6)
+
+		return nil
+
+	case "export":
+		fs := flag.NewFlagSet("store export", flag.ExitOnError)
+		output := fs.String("o", "", "file to write the export to (default stdout)")
+
+		fs.Parse(args[1:])
+
+		db, err := server.OpenStore()
+
+		if err != nil {
+			return err
+		}
+
+		defer db.Close()
+
+		var w io.Writer = os.Stdout
+
+		if *output != "" {
+			f, err := os.OpenFile(*output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+
+			if err != nil {
+				return err
+			}
+
+			defer f.Close()
+
+			w = f
+		}
+
+		return db.Export(w)
+
+	case "wipe":
+		fs := flag.NewFlagSet("store wipe", flag.ExitOnError)
+		confirm := fs.Bool("y", false, "confirm deleting all local data")
+
+		fs.Parse(args[1:])
+
+		if !*confirm {
+			return errors.New("wiping deletes the store, its key, crash reports and caches; confirm with -y")
+		}
+
+		dir := config.DataDir()
+
+		// fails while a bridge is running and holding the store
+		if err := server.WipeData(); err != nil {
+			return err
+		}
+
+		if err := store.DeleteKey(dir); err != nil {
+			return err
+		}
+
+		fmt.Printf("wiped local data in %s\n", dir)
+
+		return nil
+	}
+
+	return fmt.Errorf("unknown store command %q", args[0])
+}
@@ -0,0 +1,139 @@
+// Package auth authenticates callers of the bridge in server mode.
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+var ErrUnauthorized = errors.New("unauthorized")
+
+// User is an authenticated caller.
+type User struct {
+	Name   string   `json:"name"`
+	Email  string   `json:"email,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// Provider authenticates requests. It returns ErrUnauthorized for requests
+// without valid credentials.
+type Provider interface {
+	Authenticate(r *http.Request) (*User, error)
+}
+
+// LoginProvider is a Provider with an interactive browser login, which ends
+// in a session cookie.
+type LoginProvider interface {
+	Provider
+
+	Login(w http.ResponseWriter, r *http.Request)
+	Callback(w http.ResponseWriter, r *http.Request)
+}
+
+// New returns the provider of an auth config.
+func New(cfg *config.AuthConfig) (Provider, error) {
+	secret := []byte(cfg.SessionSecret)
+
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+
+	sessions := &sessions{
+		secret: secret,
+		ttl:    cfg.SessionDuration(),
+	}
+
+	switch cfg.Type {
+	case "token":
+		return newTokenProvider(cfg.Tokens), nil
+
+	case "oidc":
+		return newOIDCProvider(cfg.OIDC, sessions), nil
+
+	case "github":
+		return newGitHubProvider(cfg.GitHub, sessions), nil
+	}
+
+	return nil, errors.New("unsupported auth type " + cfg.Type)
+}
+
+// bearerProtocolPrefix is the websocket subprotocol browsers use to pass a
+// bearer token, as they cannot set the Authorization header.
+const bearerProtocolPrefix = "base64url.bearer.authorization.k8s.io."
+
+// BearerToken returns the bearer token of a request, from the Authorization
+// header or the websocket subprotocol.
+func BearerToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+
+	for _, v := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(v, ",") {
+			if encoded, ok := strings.CutPrefix(strings.TrimSpace(p), bearerProtocolPrefix); ok {
+				token, err := base64.RawURLEncoding.DecodeString(encoded)
+
+				if err == nil {
+					return string(token)
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// StripBearerProtocol removes the bearer token subprotocol from a websocket
+// handshake.
+func StripBearerProtocol(h http.Header) {
+	values := h.Values("Sec-WebSocket-Protocol")
+
+	if len(values) == 0 {
+		return
+	}
+
+	var protocols []string
+
+	for _, v := range values {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" && !strings.HasPrefix(p, bearerProtocolPrefix) {
+				protocols = append(protocols, p)
+			}
+		}
+	}
+
+	h.Del("Sec-WebSocket-Protocol")
+
+	if len(protocols) > 0 {
+		h.Set("Sec-WebSocket-Protocol", strings.Join(protocols, ", "))
+	}
+}
+
+// allowed reports whether a user passes the allow lists; empty lists allow
+// everyone.
+func allowed(users, groups []string, user *User) bool {
+	if len(users) == 0 && len(groups) == 0 {
+		return true
+	}
+
+	if slices.ContainsFunc(users, func(u string) bool {
+		return strings.EqualFold(u, user.Name) || (user.Email != "" && strings.EqualFold(u, user.Email))
+	}) {
+		return true
+	}
+
+	for _, g := range user.Groups {
+		if slices.ContainsFunc(groups, func(v string) bool { return strings.EqualFold(v, g) }) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+// githubProvider logs browsers in with GitHub OAuth. The organizations of
+// the user become its groups.
+type githubProvider struct {
+	config   *config.GitHubConfig
+	sessions *sessions
+
+	oauth2 *oauth2.Config
+}
+
+func newGitHubProvider(cfg *config.GitHubConfig, sessions *sessions) *githubProvider {
+	scopes := []string{"read:user", "user:email"}
+
+	if len(cfg.Organizations) > 0 {
+		scopes = append(scopes, "read:org")
+	}
+
+	return &githubProvider{
+		config:   cfg,
+		sessions: sessions,
+
+		oauth2: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+
+			Endpoint: github.Endpoint,
+			Scopes:   scopes,
+		},
+	}
+}
+
+func (p *githubProvider) Authenticate(r *http.Request) (*User, error) {
+	return p.sessions.user(r)
+}
+
+func (p *githubProvider) Login(w http.ResponseWriter, r *http.Request) {
+	verifier := oauth2.GenerateVerifier()
+	state := p.sessions.startLogin(w, r, verifier)
+
+	http.Redirect(w, r, p.oauth2.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)), http.StatusFound)
+}
+
+func (p *githubProvider) Callback(w http.ResponseWriter, r *http.Request) {
+	state, ok := p.sessions.finishLogin(w, r)
+
+	if !ok {
+		http.Error(w, "invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	if e := r.URL.Query().Get("error"); e != "" {
+		http.Error(w, "login failed: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	token, err := p.oauth2.Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(state.Verifier))
+
+	if err != nil {
+		http.Error(w, "login failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := p.user(r.Context(), token)
+
+	if err != nil {
+		http.Error(w, "login failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if !allowed(p.config.Users, p.config.Organizations, user) {
+		http.Error(w, user.Name+" is not allowed to use the bridge", http.StatusForbidden)
+		return
+	}
+
+	p.sessions.create(w, r, user)
+
+	http.Redirect(w, r, state.Redirect, http.StatusFound)
+}
+
+func (p *githubProvider) user(ctx context.Context, token *oauth2.Token) (*User, error) {
+	client := p.oauth2.Client(ctx, token)
+
+	get := func(path string, out any) error {
+		resp, err := client.Get("https://api.github.com" + path)
+
+		if err != nil {
+			return err
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("github returned %s for %s", resp.Status, path)
+		}
+
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+
+	var info struct {
+		Login string `json:"login"`
+		Email string `json:"email"`
+	}
+
+	if err := get("/user", &info); err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		Name:  info.Login,
+		Email: info.Email,
+	}
+
+	if len(p.config.Organizations) > 0 {
+		var orgs []struct {
+			Login string `json:"login"`
+		}
+
+		if err := get("/user/orgs", &orgs); err != nil {
+			return nil, err
+		}
+
+		for _, o := range orgs {
+			user.Groups = append(user.Groups, o.Login)
+		}
+	}
+
+	return user, nil
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// oidcProvider logs browsers in with the authorization code flow and also
+// accepts ID tokens of the client as bearer tokens (e.g. from CLIs).
+type oidcProvider struct {
+	config   *config.OIDCConfig
+	sessions *sessions
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+func newOIDCProvider(cfg *config.OIDCConfig, sessions *sessions) *oidcProvider {
+	return &oidcProvider{
+		config:   cfg,
+		sessions: sessions,
+	}
+}
+
+// discover fetches the issuer metadata on first use, so an unreachable
+// issuer does not prevent the bridge from starting.
+func (p *oidcProvider) discover(ctx context.Context) (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider != nil {
+		return p.provider, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, p.config.Issuer)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover oidc issuer: %w", err)
+	}
+
+	p.provider = provider
+
+	return provider, nil
+}
+
+func (p *oidcProvider) oauth2(provider *oidc.Provider) *oauth2.Config {
+	scopes := p.config.Scopes
+
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "email"}
+	}
+
+	return &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+
+		Endpoint: provider.Endpoint(),
+		Scopes:   append([]string{oidc.ScopeOpenID}, scopes...),
+	}
+}
+
+func (p *oidcProvider) Authenticate(r *http.Request) (*User, error) {
+	if token := BearerToken(r); token != "" {
+		provider, err := p.discover(r.Context())
+
+		if err != nil {
+			return nil, err
+		}
+
+		return p.verify(r.Context(), provider, token)
+	}
+
+	return p.sessions.user(r)
+}
+
+func (p *oidcProvider) Login(w http.ResponseWriter, r *http.Request) {
+	provider, err := p.discover(r.Context())
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	verifier := oauth2.GenerateVerifier()
+	state := p.sessions.startLogin(w, r, verifier)
+
+	http.Redirect(w, r, p.oauth2(provider).AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)), http.StatusFound)
+}
+
+func (p *oidcProvider) Callback(w http.ResponseWriter, r *http.Request) {
+	state, ok := p.sessions.finishLogin(w, r)
+
+	if !ok {
+		http.Error(w, "invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	if e := r.URL.Query().Get("error"); e != "" {
+		http.Error(w, "login failed: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	provider, err := p.discover(r.Context())
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	token, err := p.oauth2(provider).Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(state.Verifier))
+
+	if err != nil {
+		http.Error(w, "login failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	idToken, _ := token.Extra("id_token").(string)
+
+	if idToken == "" {
+		http.Error(w, "login failed: no id token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := p.verify(r.Context(), provider, idToken)
+
+	if err != nil {
+		http.Error(w, "login failed: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	p.sessions.create(w, r, user)
+
+	http.Redirect(w, r, state.Redirect, http.StatusFound)
+}
+
+// verify checks an ID token and maps its claims to a user.
+func (p *oidcProvider) verify(ctx context.Context, provider *oidc.Provider, raw string) (*User, error) {
+	token, err := provider.Verifier(&oidc.Config{ClientID: p.config.ClientID}).Verify(ctx, raw)
+
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+
+	var claims map[string]any
+
+	if err := token.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		Name: token.Subject,
+	}
+
+	user.Email, _ = claims["email"].(string)
+
+	for _, key := range []string{"preferred_username", "email"} {
+		if v, ok := claims[key].(string); ok && v != "" {
+			user.Name = v
+			break
+		}
+	}
+
+	groupsClaim := p.config.GroupsClaim
+
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+
+	groups, _ := claims[groupsClaim].([]any)
+
+	for _, g := range groups {
+		if g, ok := g.(string); ok {
+			user.Groups = append(user.Groups, g)
+		}
+	}
+
+	if !allowed(p.config.AllowedUsers, p.config.AllowedGroups, user) {
+		return nil, fmt.Errorf("%s is not allowed to use the bridge", user.Name)
+	}
+
+	return user, nil
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookie = "bridge_session"
+	stateCookie   = "bridge_login"
+)
+
+// sessions issues signed session cookies carrying the user, so no server
+// side session state is needed.
+type sessions struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+type sessionData struct {
+	User    User      `json:"user"`
+	Expires time.Time `json:"expires"`
+}
+
+func (s *sessions) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *sessions) create(w http.ResponseWriter, r *http.Request, user *User) {
+	data, _ := json.Marshal(&sessionData{
+		User:    *user,
+		Expires: time.Now().Add(s.ttl),
+	})
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+
+	setCookie(w, r, sessionCookie, payload+"."+s.sign(payload), s.ttl)
+}
+
+func (s *sessions) user(r *http.Request) (*User, error) {
+	c, err := r.Cookie(sessionCookie)
+
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+
+	payload, signature, ok := strings.Cut(c.Value, ".")
+
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return nil, ErrUnauthorized
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+
+	var session sessionData
+
+	if err := json.Unmarshal(data, &session); err != nil || time.Now().After(session.Expires) {
+		return nil, ErrUnauthorized
+	}
+
+	return &session.User, nil
+}
+
+// Logout ends the session of a browser.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	setCookie(w, r, sessionCookie, "", -1)
+}
+
+// loginState is kept in a short-lived cookie during the login redirect to
+// protect against CSRF (state) and code interception (PKCE verifier).
+type loginState struct {
+	State    string `json:"state"`
+	Verifier string `json:"verifier"`
+	Redirect string `json:"redirect"`
+}
+
+func (s *sessions) startLogin(w http.ResponseWriter, r *http.Request, verifier string) string {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	redirect := r.URL.Query().Get("redirect")
+
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		redirect = "/"
+	}
+
+	state := &loginState{
+		State:    hex.EncodeToString(id),
+		Verifier: verifier,
+		Redirect: redirect,
+	}
+
+	data, _ := json.Marshal(state)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+
+	setCookie(w, r, stateCookie, payload+"."+s.sign(payload), 10*time.Minute)
+
+	return state.State
+}
+
+func (s *sessions) finishLogin(w http.ResponseWriter, r *http.Request) (*loginState, bool) {
+	c, err := r.Cookie(stateCookie)
+
+	if err != nil {
+		return nil, false
+	}
+
+	setCookie(w, r, stateCookie, "", -1)
+
+	payload, signature, ok := strings.Cut(c.Value, ".")
+
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return nil, false
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+
+	if err != nil {
+		return nil, false
+	}
+
+	var state loginState
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, false
+	}
+
+	if state.State == "" || r.URL.Query().Get("state") != state.State {
+		return nil, false
+	}
+
+	return &state, true
+}
+
+func setCookie(w http.ResponseWriter, r *http.Request, name, value string, ttl time.Duration) {
+	cookie := &http.Cookie{
+		Name:  name,
+		Value: value,
+		Path:  "/",
+
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if ttl < 0 {
+		cookie.MaxAge = -1
+	} else {
+		cookie.MaxAge = int(ttl.Seconds())
+	}
+
+	http.SetCookie(w, cookie)
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSessions(secret string) *sessions {
+	return &sessions{
+		secret: []byte(secret),
+		ttl:    time.Hour,
+	}
+}
+
+// sessionRequest returns a request carrying the cookies set by a response.
+func sessionRequest(w *httptest.ResponseRecorder, target string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+
+	return r
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	s := newTestSessions("secret")
+
+	w := httptest.NewRecorder()
+	s.create(w, httptest.NewRequest(http.MethodGet, "/", nil), &User{Name: "alice", Email: "alice@example.com", Groups: []string{"dev"}})
+
+	user, err := s.user(sessionRequest(w, "/"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Name != "alice" || user.Email != "alice@example.com" || len(user.Groups) != 1 || user.Groups[0] != "dev" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+}
+
+func TestSessionRejected(t *testing.T) {
+	s := newTestSessions("secret")
+
+	w := httptest.NewRecorder()
+	s.create(w, httptest.NewRequest(http.MethodGet, "/", nil), &User{Name: "alice"})
+
+	cookie := w.Result().Cookies()[0]
+	payload, signature, _ := strings.Cut(cookie.Value, ".")
+
+	forge := func(user string, expires time.Time) string {
+		data, _ := json.Marshal(&sessionData{User: User{Name: user}, Expires: expires})
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	expired := forge("alice", time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		name  string
+		value string
+		s     *sessions
+	}{
+		{"missing signature", payload, s},
+		{"tampered payload", forge("mallory", time.Now().Add(time.Hour)) + "." + signature, s},
+		{"tampered signature", payload + "." + signature[1:], s},
+		{"other secret", cookie.Value, newTestSessions("other")},
+		{"expired", expired + "." + s.sign(expired), s},
+		{"invalid payload", "%%%." + s.sign("%%%"), s},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: tt.value})
+
+			if _, err := tt.s.user(r); !errors.Is(err, ErrUnauthorized) {
+				t.Fatalf("error = %v, want %v", err, ErrUnauthorized)
+			}
+		})
+	}
+
+	if _, err := s.user(httptest.NewRequest(http.MethodGet, "/", nil)); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected a request without cookie to be unauthorized, got %v", err)
+	}
+}
+
+func TestLoginState(t *testing.T) {
+	s := newTestSessions("secret")
+
+	w := httptest.NewRecorder()
+	state := s.startLogin(w, httptest.NewRequest(http.MethodGet, "/auth/login?redirect=/contexts/dev", nil), "verifier")
+
+	login, ok := s.finishLogin(httptest.NewRecorder(), sessionRequest(w, "/auth/callback?state="+state))
+
+	if !ok {
+		t.Fatal("expected the login to finish")
+	}
+
+	if login.Verifier != "verifier" || login.Redirect != "/contexts/dev" {
+		t.Fatalf("unexpected login state %+v", login)
+	}
+
+	if _, ok := s.finishLogin(httptest.NewRecorder(), sessionRequest(w, "/auth/callback?state=other")); ok {
+		t.Fatal("expected a mismatching state to be rejected")
+	}
+
+	if _, ok := newTestSessions("other").finishLogin(httptest.NewRecorder(), sessionRequest(w, "/auth/callback?state="+state)); ok {
+		t.Fatal("expected a state signed with another secret to be rejected")
+	}
+}
+
+func TestLoginRedirect(t *testing.T) {
+	s := newTestSessions("secret")
+
+	tests := map[string]string{
+		"/contexts/dev":       "/contexts/dev",
+		"":                    "/",
+		"https://example.com": "/",
+		"//example.com":       "/",
+	}
+
+	for redirect, want := range tests {
+		w := httptest.NewRecorder()
+		state := s.startLogin(w, httptest.NewRequest(http.MethodGet, "/auth/login?redirect="+redirect, nil), "verifier")
+
+		login, ok := s.finishLogin(httptest.NewRecorder(), sessionRequest(w, "/auth/callback?state="+state))
+
+		if !ok {
+			t.Fatalf("%q: expected the login to finish", redirect)
+		}
+
+		if login.Redirect != want {
+			t.Errorf("%q: redirect = %q, want %q", redirect, login.Redirect, want)
+		}
+	}
+}
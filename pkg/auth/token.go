@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+// tokenProvider authenticates static bearer tokens.
+type tokenProvider struct {
+	tokens []config.AuthToken
+}
+
+func newTokenProvider(tokens []config.AuthToken) *tokenProvider {
+	return &tokenProvider{
+		tokens: tokens,
+	}
+}
+
+func (p *tokenProvider) Authenticate(r *http.Request) (*User, error) {
+	token := BearerToken(r)
+
+	if token == "" {
+		return nil, ErrUnauthorized
+	}
+
+	for _, t := range p.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+			return &User{
+				Name:   t.Name,
+				Groups: t.Groups,
+			}, nil
+		}
+	}
+
+	return nil, ErrUnauthorized
+}
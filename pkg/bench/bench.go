@@ -0,0 +1,379 @@
+package bench
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/dockertest"
+	"github.com/adrianliechti/bridge/pkg/server"
+)
+
+type Options struct {
+	// Context benchmarks an existing kubernetes context (e.g. kind or envtest)
+	// instead of the in-process fake API server
+	Context string
+
+	// Resources is the number of pods served by the fake API server
+	Resources int
+
+	// Concurrency is the number of parallel list clients
+	Concurrency int
+
+	// Duration of the list throughput phase
+	Duration time.Duration
+
+	// Watchers is the number of concurrent watch streams
+	Watchers int
+
+	// Events is the number of events broadcast to the watchers
+	Events int
+}
+
+type Result struct {
+	List   Throughput
+	Docker Throughput
+
+	WatchEvents  int64
+	WatchLatency Percentiles
+
+	HeapPeak   uint64
+	TotalAlloc uint64
+	Goroutines int
+}
+
+type Throughput struct {
+	Requests int64
+	Bytes    int64
+	Failures int64
+	Rate     float64
+
+	Latency Percentiles
+}
+
+type Percentiles struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+// Run benchmarks the bridge proxy against an in-process fake API server and
+// Docker daemon, measuring list throughput, watch fan-out latency and memory usage.
+func Run(ctx context.Context, cfg *config.Config, opts Options) (*Result, error) {
+	// the benchmark opens more concurrent streams than a single user may
+	cfg.Limits.MaxSessions = 0
+	cfg.Limits.MaxSessionsPerUser = 0
+
+	daemon := dockertest.NewServer(opts.Resources)
+	defer daemon.Close()
+
+	cfg.Docker = &config.DockerConfig{
+		Contexts: []config.DockerContext{
+			{
+				Name: "bridge-bench-docker",
+				Host: daemon.Host(),
+			},
+		},
+	}
+
+	srv, err := server.New(cfg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer srv.Close()
+
+	var upstream *fakeAPIServer
+
+	if opts.Context == "" {
+		upstream = newFakeAPIServer(opts.Resources)
+		defer upstream.Close()
+
+		opts.Context = "bridge-bench"
+
+		c, err := config.KubernetesContextFromToken(opts.Context, upstream.URL, "", nil, false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := srv.AddKubernetesContext(c); err != nil {
+			return nil, err
+		}
+	}
+
+	bridge := httptest.NewServer(srv)
+	defer bridge.Close()
+
+	result := &Result{}
+
+	monitorCtx, stopMonitor := context.WithCancel(ctx)
+	peak := monitorMemory(monitorCtx)
+
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	result.List = benchRequests(ctx, bridge.URL+"/contexts/"+opts.Context+"/api/v1/pods", opts)
+	result.Docker = benchRequests(ctx, bridge.URL+"/contexts/bridge-bench-docker/containers/json?all=1", opts)
+
+	if upstream != nil {
+		if err := benchWatch(ctx, bridge.URL, upstream, opts, result); err != nil {
+			stopMonitor()
+			return nil, err
+		}
+	}
+
+	stopMonitor()
+
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+
+	result.HeapPeak = <-peak
+	result.TotalAlloc = after.TotalAlloc - before.TotalAlloc
+	result.Goroutines = runtime.NumGoroutine()
+
+	return result, nil
+}
+
+func benchRequests(ctx context.Context, url string, opts Options) Throughput {
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	var mu sync.Mutex
+	var latencies []time.Duration
+
+	var wg sync.WaitGroup
+	var result Throughput
+
+	start := time.Now()
+
+	for range max(opts.Concurrency, 1) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				begin := time.Now()
+
+				n, err := get(ctx, url)
+
+				if ctx.Err() != nil {
+					return
+				}
+
+				if err != nil {
+					atomic.AddInt64(&result.Failures, 1)
+					continue
+				}
+
+				atomic.AddInt64(&result.Requests, 1)
+				atomic.AddInt64(&result.Bytes, n)
+
+				mu.Lock()
+				latencies = append(latencies, time.Since(begin))
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	result.Rate = float64(result.Requests) / time.Since(start).Seconds()
+	result.Latency = percentiles(latencies)
+
+	return result
+}
+
+func benchWatch(ctx context.Context, url string, upstream *fakeAPIServer, opts Options, result *Result) error {
+	if opts.Watchers <= 0 || opts.Events <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var latencies []time.Duration
+
+	var wg sync.WaitGroup
+
+	for range opts.Watchers {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/contexts/"+opts.Context+"/api/v1/pods?watch=true", nil)
+
+		if err != nil {
+			return err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("watch failed: %s", resp.Status)
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer resp.Body.Close()
+
+			scanner := bufio.NewScanner(resp.Body)
+			scanner.Buffer(nil, 1024*1024)
+
+			for received := 0; received < opts.Events && scanner.Scan(); received++ {
+				var event struct {
+					Object struct {
+						Metadata struct {
+							Annotations map[string]string `json:"annotations"`
+						} `json:"metadata"`
+					} `json:"object"`
+				}
+
+				if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+					continue
+				}
+
+				sent, _ := strconv.ParseInt(event.Object.Metadata.Annotations[sentAnnotation], 10, 64)
+
+				if sent == 0 {
+					continue
+				}
+
+				atomic.AddInt64(&result.WatchEvents, 1)
+
+				mu.Lock()
+				latencies = append(latencies, time.Since(time.Unix(0, sent)))
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// wait until all watches are established upstream
+	for upstream.watcherCount() < opts.Watchers {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	for range opts.Events {
+		upstream.broadcast()
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		cancel()
+		<-done
+	}
+
+	result.WatchLatency = percentiles(latencies)
+
+	return nil
+}
+
+func get(ctx context.Context, url string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	n, err := io.Copy(io.Discard, resp.Body)
+
+	if err != nil {
+		return n, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return n, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return n, nil
+}
+
+func monitorMemory(ctx context.Context) <-chan uint64 {
+	result := make(chan uint64, 1)
+
+	go func() {
+		var peak uint64
+		var stats runtime.MemStats
+
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			runtime.ReadMemStats(&stats)
+			peak = max(peak, stats.HeapAlloc)
+
+			select {
+			case <-ctx.Done():
+				result <- peak
+				return
+
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return result
+}
+
+func percentiles(values []time.Duration) Percentiles {
+	if len(values) == 0 {
+		return Percentiles{}
+	}
+
+	slices.Sort(values)
+
+	at := func(p float64) time.Duration {
+		return values[int(float64(len(values)-1)*p)]
+	}
+
+	return Percentiles{
+		P50: at(0.5),
+		P90: at(0.9),
+		P99: at(0.99),
+		Max: values[len(values)-1],
+	}
+}
+
+func (p Percentiles) String() string {
+	return fmt.Sprintf("p50=%s p90=%s p99=%s max=%s", p.P50, p.P90, p.P99, p.Max)
+}
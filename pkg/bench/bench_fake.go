@@ -0,0 +1,157 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// fakeAPIServer is a minimal in-process kubernetes API serving a pod list of
+// configurable size and a watch endpoint that broadcasts timestamped events.
+type fakeAPIServer struct {
+	*httptest.Server
+
+	list []byte
+
+	mu       sync.Mutex
+	watchers map[chan []byte]struct{}
+
+	resourceVersion int
+}
+
+const sentAnnotation = "bench/sent"
+
+func newFakeAPIServer(resources int) *fakeAPIServer {
+	f := &fakeAPIServer{
+		watchers: make(map[chan []byte]struct{}),
+	}
+
+	items := make([]any, 0, resources)
+
+	for i := range resources {
+		items = append(items, fakePod(i, ""))
+	}
+
+	f.list, _ = json.Marshal(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "PodList",
+		"metadata": map[string]any{
+			"resourceVersion": "1",
+		},
+		"items": items,
+	})
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /api/v1/pods", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") == "true" {
+			f.serveWatch(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(f.list)
+	})
+
+	f.Server = httptest.NewServer(mux)
+
+	return f
+}
+
+func (f *fakeAPIServer) serveWatch(w http.ResponseWriter, r *http.Request) {
+	ch := make(chan []byte, 1024)
+
+	f.mu.Lock()
+	f.watchers[ch] = struct{}{}
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.watchers, ch)
+		f.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	rc.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case data := <-ch:
+			w.Write(data)
+			rc.Flush()
+		}
+	}
+}
+
+func (f *fakeAPIServer) watcherCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.watchers)
+}
+
+// broadcast sends a MODIFIED event carrying the send time to all watchers.
+func (f *fakeAPIServer) broadcast() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.resourceVersion++
+
+	event, _ := json.Marshal(map[string]any{
+		"type":   "MODIFIED",
+		"object": fakePod(f.resourceVersion, strconv.FormatInt(time.Now().UnixNano(), 10)),
+	})
+
+	event = append(event, '\n')
+
+	for ch := range f.watchers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func fakePod(i int, sent string) map[string]any {
+	annotations := map[string]string{}
+
+	if sent != "" {
+		annotations[sentAnnotation] = sent
+	}
+
+	return map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]any{
+			"name":            fmt.Sprintf("pod-%d", i),
+			"namespace":       fmt.Sprintf("namespace-%d", i%20),
+			"resourceVersion": strconv.Itoa(i + 1),
+			"labels": map[string]string{
+				"app": fmt.Sprintf("app-%d", i%100),
+			},
+			"annotations": annotations,
+		},
+		"spec": map[string]any{
+			"nodeName": fmt.Sprintf("node-%d", i%50),
+			"containers": []any{
+				map[string]any{
+					"name":  "main",
+					"image": "nginx:1.27",
+				},
+			},
+		},
+		"status": map[string]any{
+			"phase": "Running",
+		},
+	}
+}
@@ -1,14 +1,144 @@
 package config
 
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
 type Config struct {
+	CrashReports bool
+
+	// KeepAliveInterval is the interval in which pinned contexts are pinged
+	KeepAliveInterval time.Duration
+
+	Limits LimitsConfig
+
+	// Retention prunes local data (audit, snapshots, history)
+	Retention RetentionConfig
+
+	// Transcripts records the output of exec and log sessions for download,
+	// if enabled in the config file (transcripts: true)
+	Transcripts bool
+
+	// FieldManager owns the fields of server-side applies
+	FieldManager string
+
+	// ProtectedNamespaces require a confirmation token for mutating operations
+	ProtectedNamespaces []string
+
+	// ReadOnlyNamespaces block all mutating operations
+	ReadOnlyNamespaces []string
+
+	// NonProductionContexts allow chaos experiments
+	NonProductionContexts []string
+
+	// Printers add computed columns and health to table lists
+	Printers []PrinterRule
+
+	// Auth authenticates callers in server mode
+	Auth *AuthConfig
+
+	// Impersonation lets callers act as other users on the Kubernetes APIs
+	Impersonation *ImpersonationConfig
+
+	// Cache serves hot list and get requests from memory
+	Cache *CacheConfig
+
+	// Helm configures the chart repositories of Helm installs
+	Helm *HelmConfig
+
+	// Talos enables node management of Talos clusters
+	Talos *TalosConfig
+
+	// Tunnels reach private endpoints of contexts through cloud tunnels
+	Tunnels []TunnelConfig
+
+	// Upstreams are bridges whose contexts are federated
+	Upstreams []UpstreamConfig
+
+	// Prometheus and Loki associate metrics and log endpoints with contexts
+	Prometheus []EndpointConfig
+	Loki       []EndpointConfig
+
 	OpenAI *OpenAIConfig
 
 	Docker     *DockerConfig
 	Kubernetes *KubernetesConfig
+
+	filter *ContextFilter
+	pinned []string
+
+	kubeconfigs []string
+	inCluster   bool
+}
+
+// LimitsConfig bounds the amount of data streamed through the proxies.
+// A zero value disables the respective limit.
+type LimitsConfig struct {
+	// MaxListLimit caps the page size of list requests (limit/continue)
+	MaxListLimit int64
+
+	// MaxLogTailLines is applied to log requests that request no tail or time window
+	MaxLogTailLines int64
+
+	// MaxResponseSize caps the size of non-streaming responses in bytes, logs
+	// exceeding it are trimmed to their last lines
+	MaxResponseSize int64
+
+	// MaxSessions caps concurrent streaming sessions (exec, logs, watches, forwards)
+	MaxSessions int
+
+	// MaxSessionsPerUser caps concurrent streaming sessions per caller
+	MaxSessionsPerUser int
+
+	// SessionIdleTimeout ends streaming sessions without any traffic
+	SessionIdleTimeout time.Duration
+
+	// MaxDisruptionsPerMinute caps pod deletions, evictions and rollout
+	// restarts per namespace and minute
+	MaxDisruptionsPerMinute int
+
+	// MaxStaleCacheSize caps the responses kept for offline contexts in
+	// bytes; zero disables the cache
+	MaxStaleCacheSize int64
+}
+
+type Options struct {
+	File    string
+	LogFile string
+
+	CrashReports bool
+
+	// Share listens on all interfaces and advertises the bridge via mDNS
+	Share bool
+
+	// Contexts and ExcludeContexts filter the kubeconfig and docker
+	// contexts by name (see BRIDGE_CONTEXTS and BRIDGE_EXCLUDE_CONTEXTS)
+	Contexts        []string
+	ExcludeContexts []string
+
+	// Kubeconfigs are merged in order instead of the files of KUBECONFIG
+	Kubeconfigs []string
+
+	// InCluster uses the service account of the pod instead of a kubeconfig
+	InCluster bool
 }
 
 type AuthInfo struct {
 	Bearer string
+
+	// User and Groups of callers authenticated in server mode
+	User   string
+	Groups []string
+
+	// ImpersonateUser and ImpersonateGroups are sent to the Kubernetes APIs
+	// instead of acting with the credentials of the context alone
+	ImpersonateUser   string
+	ImpersonateGroups []string
 }
 
 type OpenAIConfig struct {
@@ -17,12 +147,204 @@ type OpenAIConfig struct {
 	Model string
 }
 
-func New() (*Config, error) {
-	cfg := &Config{}
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.File, "config", o.File, "path to the bridge config file")
+	fs.StringVar(&o.LogFile, "log-file", o.LogFile, "path to a log file (rotated by size)")
+	fs.BoolVar(&o.CrashReports, "crash-reports", o.CrashReports, "write crash reports to the bridge data directory")
+	fs.BoolVar(&o.Share, "share", o.Share, "share the bridge in the local network and advertise it via mDNS (requires auth)")
+
+	fs.Func("contexts", "comma-separated list of kubeconfig and docker context patterns to include, e.g. dev-*,staging (default $BRIDGE_CONTEXTS)", func(s string) error {
+		o.Contexts = append(o.Contexts, splitList(s)...)
+		return nil
+	})
+
+	fs.Func("exclude-contexts", "comma-separated list of kubeconfig and docker context patterns to exclude (default $BRIDGE_EXCLUDE_CONTEXTS)", func(s string) error {
+		o.ExcludeContexts = append(o.ExcludeContexts, splitList(s)...)
+		return nil
+	})
+
+	fs.Func("kubeconfig", "path to a kubeconfig file, repeatable or a path list like KUBECONFIG (merged in order, the first file defining a context wins)", func(s string) error {
+		o.Kubeconfigs = append(o.Kubeconfigs, filepath.SplitList(s)...)
+		return nil
+	})
+
+	fs.BoolVar(&o.InCluster, "in-cluster", o.InCluster, "use the service account of the pod instead of a kubeconfig (the default in a cluster without kubeconfig)")
+}
+
+func New(options *Options) (*Config, error) {
+	if options == nil {
+		options = &Options{}
+	}
+
+	file, err := loadFile(options.File)
+
+	if err != nil {
+		return nil, err
+	}
+
+	filter := &ContextFilter{
+		Include: file.Contexts,
+		Exclude: file.ExcludeContexts,
+	}
+
+	// the environment overrides the config file, flags override both
+	if v := os.Getenv("BRIDGE_CONTEXTS"); v != "" {
+		filter.Include = splitList(v)
+	}
+
+	if v := os.Getenv("BRIDGE_EXCLUDE_CONTEXTS"); v != "" {
+		filter.Exclude = splitList(v)
+	}
+
+	if len(options.Contexts) > 0 {
+		filter.Include = options.Contexts
+	}
+
+	if len(options.ExcludeContexts) > 0 {
+		filter.Exclude = options.ExcludeContexts
+	}
+
+	kubeconfigs, err := kubeconfigFiles(options.Kubeconfigs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
+		CrashReports: options.CrashReports || file.CrashReports,
+
+		KeepAliveInterval: time.Minute,
+
+		Limits: LimitsConfig{
+			MaxListLimit:    file.MaxListLimit,
+			MaxLogTailLines: file.MaxLogTailLines,
+			MaxResponseSize: file.MaxResponseSize,
+
+			MaxSessions:        512,
+			MaxSessionsPerUser: 128,
+			SessionIdleTimeout: time.Hour,
+
+			MaxDisruptionsPerMinute: 20,
+
+			MaxStaleCacheSize: 64 << 20,
+		},
+
+		ProtectedNamespaces: file.ProtectedNamespaces,
+		ReadOnlyNamespaces:  file.ReadOnlyNamespaces,
+
+		NonProductionContexts: file.NonProductionContexts,
+
+		Printers: file.Printers,
+
+		Transcripts: file.Transcripts,
+
+		FieldManager: "bridge",
+
+		filter: filter,
+		pinned: file.PinnedContexts,
+
+		kubeconfigs: kubeconfigs,
+		inCluster:   options.InCluster,
+	}
+
+	if file.FieldManager != "" {
+		cfg.FieldManager = file.FieldManager
+	}
+
+	if file.MaxSessions != 0 {
+		cfg.Limits.MaxSessions = file.MaxSessions
+	}
+
+	if file.MaxSessionsPerUser != 0 {
+		cfg.Limits.MaxSessionsPerUser = file.MaxSessionsPerUser
+	}
+
+	if file.MaxDisruptionsPerMinute != 0 {
+		cfg.Limits.MaxDisruptionsPerMinute = file.MaxDisruptionsPerMinute
+	}
+
+	if file.MaxStaleCacheSize != nil {
+		if *file.MaxStaleCacheSize < 0 {
+			return nil, fmt.Errorf("invalid maxStaleCacheSize: %d", *file.MaxStaleCacheSize)
+		}
+
+		cfg.Limits.MaxStaleCacheSize = *file.MaxStaleCacheSize
+	}
+
+	if file.SessionIdleTimeout != "" {
+		d, err := time.ParseDuration(file.SessionIdleTimeout)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid sessionIdleTimeout: %w", err)
+		}
+
+		cfg.Limits.SessionIdleTimeout = d
+	}
+
+	if file.KeepAliveInterval != "" {
+		d, err := time.ParseDuration(file.KeepAliveInterval)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid keepAliveInterval: %w", err)
+		}
+
+		cfg.KeepAliveInterval = d
+	}
+
+	if err := applyRetentionConfig(cfg, file.Retention); err != nil {
+		return nil, err
+	}
+
+	if err := applyAuthConfig(cfg, file.Auth); err != nil {
+		return nil, err
+	}
+
+	if err := applyImpersonationConfig(cfg, file.Impersonation); err != nil {
+		return nil, err
+	}
+
+	if err := applyCacheConfig(cfg, file.Cache); err != nil {
+		return nil, err
+	}
+
+	if err := applyHelmConfig(cfg, file.Helm); err != nil {
+		return nil, err
+	}
+
+	if err := applyTalosConfig(cfg, file.Talos); err != nil {
+		return nil, err
+	}
+
+	if err := applyTunnelConfig(cfg, file.Tunnels); err != nil {
+		return nil, err
+	}
+
+	if err := applyUpstreamConfig(cfg, file.Upstreams); err != nil {
+		return nil, err
+	}
+
+	if err := applyEndpointConfig(cfg, file.Prometheus, file.Loki); err != nil {
+		return nil, err
+	}
 
 	applyOpenAIConfig(cfg)
 	applyDockerConfig(cfg)
-	applyKubernetesConfig(cfg)
+	// the bridge stays usable without kubeconfig, unless forced in-cluster
+	if err := applyKubernetesConfig(cfg); err != nil && cfg.inCluster {
+		return nil, err
+	}
 
 	return cfg, nil
 }
+
+func splitList(s string) []string {
+	var result []string
+
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// AuthConfig enables authentication for server mode, where the bridge is
+// shared with other users instead of running on localhost.
+type AuthConfig struct {
+	// Type is token, oidc or github
+	Type string `json:"type"`
+
+	// SessionSecret signs login sessions; a random secret is used if empty,
+	// which ends all sessions on restart
+	SessionSecret string `json:"sessionSecret,omitempty"`
+	SessionTTL    string `json:"sessionTTL,omitempty"`
+
+	Tokens []AuthToken   `json:"tokens,omitempty"`
+	OIDC   *OIDCConfig   `json:"oidc,omitempty"`
+	GitHub *GitHubConfig `json:"github,omitempty"`
+
+	sessionTTL time.Duration
+}
+
+// AuthToken is a static bearer token of a user.
+type AuthToken struct {
+	Name   string   `json:"name"`
+	Token  string   `json:"token"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+type OIDCConfig struct {
+	Issuer string `json:"issuer"`
+
+	ClientID     string `json:"clientID"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+	RedirectURL  string `json:"redirectURL"`
+
+	// Scopes in addition to openid, defaults to profile and email
+	Scopes []string `json:"scopes,omitempty"`
+
+	// GroupsClaim of the ID token, defaults to groups
+	GroupsClaim string `json:"groupsClaim,omitempty"`
+
+	// AllowedGroups and AllowedUsers (emails) restrict the login if set
+	AllowedGroups []string `json:"allowedGroups,omitempty"`
+	AllowedUsers  []string `json:"allowedUsers,omitempty"`
+}
+
+type GitHubConfig struct {
+	ClientID     string `json:"clientID"`
+	ClientSecret string `json:"clientSecret"`
+	RedirectURL  string `json:"redirectURL"`
+
+	// Organizations and Users (logins) restrict the login if set
+	Organizations []string `json:"organizations,omitempty"`
+	Users         []string `json:"users,omitempty"`
+}
+
+// SessionDuration returns the lifetime of login sessions.
+func (c *AuthConfig) SessionDuration() time.Duration {
+	if c.sessionTTL > 0 {
+		return c.sessionTTL
+	}
+
+	return 12 * time.Hour
+}
+
+// applyAuthConfig validates the auth section of the config file. Secrets may
+// reference environment variables (e.g. $OIDC_CLIENT_SECRET).
+func applyAuthConfig(cfg *Config, auth *AuthConfig) error {
+	if auth == nil || auth.Type == "" {
+		return nil
+	}
+
+	auth.SessionSecret = os.ExpandEnv(auth.SessionSecret)
+
+	if auth.SessionTTL != "" {
+		d, err := time.ParseDuration(auth.SessionTTL)
+
+		if err != nil {
+			return fmt.Errorf("invalid auth sessionTTL: %w", err)
+		}
+
+		auth.sessionTTL = d
+	}
+
+	switch auth.Type {
+	case "token":
+		if len(auth.Tokens) == 0 {
+			return errors.New("auth type token requires tokens")
+		}
+
+		for i := range auth.Tokens {
+			auth.Tokens[i].Token = os.ExpandEnv(auth.Tokens[i].Token)
+
+			if auth.Tokens[i].Name == "" || auth.Tokens[i].Token == "" {
+				return errors.New("auth tokens require a name and a token")
+			}
+		}
+
+	case "oidc":
+		if auth.OIDC == nil || auth.OIDC.Issuer == "" || auth.OIDC.ClientID == "" || auth.OIDC.RedirectURL == "" {
+			return errors.New("auth type oidc requires issuer, clientID and redirectURL")
+		}
+
+		auth.OIDC.ClientSecret = os.ExpandEnv(auth.OIDC.ClientSecret)
+
+	case "github":
+		if auth.GitHub == nil || auth.GitHub.ClientID == "" || auth.GitHub.ClientSecret == "" || auth.GitHub.RedirectURL == "" {
+			return errors.New("auth type github requires clientID, clientSecret and redirectURL")
+		}
+
+		auth.GitHub.ClientSecret = os.ExpandEnv(auth.GitHub.ClientSecret)
+
+	default:
+		return fmt.Errorf("unsupported auth type %q", auth.Type)
+	}
+
+	cfg.Auth = auth
+
+	return nil
+}
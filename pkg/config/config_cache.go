@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CacheConfig enables in-memory caches for hot list and get requests. Every
+// cached resource is listed once per context and kept current by a watch.
+type CacheConfig struct {
+	// Resources to cache as resource or resource.group, e.g. pods or
+	// deployments.apps
+	Resources []string `json:"resources"`
+
+	// IdleTimeout stops caches that served no request for a while
+	IdleTimeout string `json:"idleTimeout,omitempty"`
+
+	idleTimeout time.Duration
+}
+
+// Cached reports whether a resource of a group is cached.
+func (c *CacheConfig) Cached(group, resource string) bool {
+	name := resource
+
+	if group != "" {
+		name += "." + group
+	}
+
+	for _, r := range c.Resources {
+		if strings.EqualFold(r, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IdleDuration returns the time after which unused caches are stopped.
+func (c *CacheConfig) IdleDuration() time.Duration {
+	if c.idleTimeout > 0 {
+		return c.idleTimeout
+	}
+
+	return 10 * time.Minute
+}
+
+func applyCacheConfig(cfg *Config, cache *CacheConfig) error {
+	if cache == nil || len(cache.Resources) == 0 {
+		return nil
+	}
+
+	if cache.IdleTimeout != "" {
+		d, err := time.ParseDuration(cache.IdleTimeout)
+
+		if err != nil {
+			return fmt.Errorf("invalid cache idleTimeout: %w", err)
+		}
+
+		cache.idleTimeout = d
+	}
+
+	cfg.Cache = cache
+
+	return nil
+}
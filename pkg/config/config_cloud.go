@@ -0,0 +1,94 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+type CloudCluster struct {
+	Provider string
+
+	Name string
+
+	Region        string
+	Project       string
+	ResourceGroup string
+}
+
+// KubernetesContextFromCloud resolves credentials of a managed cluster using
+// the provider CLI (aws, az or gcloud) and the ambient cloud login.
+func KubernetesContextFromCloud(ctx context.Context, name string, cluster CloudCluster) (KubernetesContext, error) {
+	if cluster.Name == "" {
+		return KubernetesContext{}, errors.New("cluster name is required")
+	}
+
+	dir, err := os.MkdirTemp("", "bridge-kubeconfig-")
+
+	if err != nil {
+		return KubernetesContext{}, err
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config")
+
+	var cmd *exec.Cmd
+
+	switch cluster.Provider {
+	case "eks", "aws":
+		args := []string{"eks", "update-kubeconfig", "--name", cluster.Name, "--kubeconfig", path}
+
+		if cluster.Region != "" {
+			args = append(args, "--region", cluster.Region)
+		}
+
+		cmd = exec.CommandContext(ctx, "aws", args...)
+
+	case "aks", "azure":
+		if cluster.ResourceGroup == "" {
+			return KubernetesContext{}, errors.New("resource group is required for aks clusters")
+		}
+
+		cmd = exec.CommandContext(ctx, "az", "aks", "get-credentials", "--name", cluster.Name, "--resource-group", cluster.ResourceGroup, "--file", path)
+
+	case "gke", "gcp":
+		args := []string{"container", "clusters", "get-credentials", cluster.Name}
+
+		if cluster.Region != "" {
+			args = append(args, "--location", cluster.Region)
+		}
+
+		if cluster.Project != "" {
+			args = append(args, "--project", cluster.Project)
+		}
+
+		cmd = exec.CommandContext(ctx, "gcloud", args...)
+		cmd.Env = append(os.Environ(), "KUBECONFIG="+path)
+
+	default:
+		return KubernetesContext{}, fmt.Errorf("unsupported cloud provider: %q", cluster.Provider)
+	}
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return KubernetesContext{}, fmt.Errorf("failed to get cluster credentials: %w: %s", err, output)
+	}
+
+	// written by the cloud CLI, so its auth plugins are trusted
+	config, err := clientcmd.LoadFromFile(path)
+
+	if err != nil {
+		return KubernetesContext{}, err
+	}
+
+	if name == "" {
+		name = cluster.Name
+	}
+
+	return kubernetesContextFromConfig(name, config)
+}
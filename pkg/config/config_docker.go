@@ -1,6 +1,8 @@
 package config
 
 import (
+	"path/filepath"
+
 	"github.com/docker/cli/cli/config"
 	"github.com/docker/cli/cli/context/store"
 )
@@ -20,23 +22,41 @@ type DockerContext struct {
 }
 
 func applyDockerConfig(cfg *Config) error {
-	c, err := config.Load("")
+	d, err := cfg.LoadDocker()
 
 	if err != nil {
 		return err
 	}
 
+	cfg.Docker = d
+
+	return nil
+}
+
+// LoadDocker reads the contexts of the docker CLI, applying the context
+// filter.
+func (cfg *Config) LoadDocker() (*DockerConfig, error) {
+	c, err := config.Load("")
+
+	if err != nil {
+		return nil, err
+	}
+
 	s := store.New(config.ContextStoreDir(), store.Config{})
 
 	metadatas, err := s.List()
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	contexts := make([]DockerContext, 0)
 
 	for _, c := range metadatas {
+		if !cfg.filter.Allowed(c.Name) {
+			continue
+		}
+
 		context := DockerContext{
 			Name: c.Name,
 		}
@@ -60,11 +80,19 @@ func applyDockerConfig(cfg *Config) error {
 		contexts = append(contexts, context)
 	}
 
-	cfg.Docker = &DockerConfig{
+	result := &DockerConfig{
 		Contexts: contexts,
+	}
 
-		CurrentContext: c.CurrentContext,
+	if cfg.filter.Allowed(c.CurrentContext) {
+		result.CurrentContext = c.CurrentContext
 	}
 
-	return nil
+	return result, nil
+}
+
+// DockerFiles returns the docker CLI config file and the directory holding
+// the metadata of the docker contexts, one subdirectory per context.
+func DockerFiles() (string, string) {
+	return filepath.Join(config.Dir(), config.ConfigFileName), filepath.Join(config.ContextStoreDir(), "meta")
 }
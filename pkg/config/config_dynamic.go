@@ -0,0 +1,133 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// KubernetesContextFromKubeconfig creates a context from a kubeconfig blob
+// of a caller or a cluster, which must not run commands or read files on
+// the bridge host. The current context of the blob is used, or its only
+// context if none is set. An empty name keeps the context name of the
+// kubeconfig.
+func KubernetesContextFromKubeconfig(name string, data []byte) (KubernetesContext, error) {
+	config, err := clientcmd.Load(data)
+
+	if err != nil {
+		return KubernetesContext{}, err
+	}
+
+	if err := validateDynamicKubeconfig(config); err != nil {
+		return KubernetesContext{}, err
+	}
+
+	return kubernetesContextFromConfig(name, config)
+}
+
+// kubernetesContextFromConfig creates a context from a trusted kubeconfig,
+// e.g. one written by the CLI of a cloud provider, which may use plugins.
+func kubernetesContextFromConfig(name string, config *clientcmdapi.Config) (KubernetesContext, error) {
+	contextName := config.CurrentContext
+
+	if contextName == "" && len(config.Contexts) == 1 {
+		for n := range config.Contexts {
+			contextName = n
+		}
+	}
+
+	if _, ok := config.Contexts[contextName]; !ok {
+		return KubernetesContext{}, errors.New("kubeconfig has no current context")
+	}
+
+	if name == "" {
+		name = contextName
+	}
+
+	clientConfig := clientcmd.NewNonInteractiveClientConfig(*config, contextName, &clientcmd.ConfigOverrides{}, nil)
+
+	// resolve once to fail early on invalid credentials or server settings
+	if _, err := clientConfig.ClientConfig(); err != nil {
+		return KubernetesContext{}, err
+	}
+
+	return KubernetesContext{
+		Name: name,
+
+		Dynamic: true,
+
+		Config: func(ctx context.Context, auth *AuthInfo) (*rest.Config, error) {
+			return clientConfig.ClientConfig()
+		},
+	}, nil
+}
+
+// validateDynamicKubeconfig rejects kubeconfigs posted by callers that would
+// run commands (exec and auth provider plugins) or read files on the bridge
+// host; only inline credentials and certificates are accepted.
+func validateDynamicKubeconfig(config *clientcmdapi.Config) error {
+	for name, c := range config.Clusters {
+		if c.CertificateAuthority != "" {
+			return fmt.Errorf("cluster %q: certificate-authority files are not supported, use certificate-authority-data", name)
+		}
+	}
+
+	for name, a := range config.AuthInfos {
+		switch {
+		case a.Exec != nil:
+			return fmt.Errorf("user %q: exec plugins are not supported", name)
+
+		case a.AuthProvider != nil:
+			return fmt.Errorf("user %q: auth providers are not supported", name)
+
+		case a.ClientCertificate != "":
+			return fmt.Errorf("user %q: client-certificate files are not supported, use client-certificate-data", name)
+
+		case a.ClientKey != "":
+			return fmt.Errorf("user %q: client-key files are not supported, use client-key-data", name)
+
+		case a.TokenFile != "":
+			return fmt.Errorf("user %q: token files are not supported, use token", name)
+		}
+	}
+
+	return nil
+}
+
+// KubernetesContextFromToken creates a context for an API server reachable
+// with a static bearer token.
+func KubernetesContextFromToken(name, server, token string, caData []byte, insecure bool) (KubernetesContext, error) {
+	if name == "" {
+		return KubernetesContext{}, errors.New("context name is required")
+	}
+
+	if server == "" {
+		return KubernetesContext{}, errors.New("server is required")
+	}
+
+	if len(caData) > 0 && insecure {
+		return KubernetesContext{}, fmt.Errorf("certificate authority and insecure mode are mutually exclusive")
+	}
+
+	return KubernetesContext{
+		Name: name,
+
+		Dynamic: true,
+
+		Config: func(ctx context.Context, auth *AuthInfo) (*rest.Config, error) {
+			return &rest.Config{
+				Host:        server,
+				BearerToken: token,
+
+				TLSClientConfig: rest.TLSClientConfig{
+					CAData:   caData,
+					Insecure: insecure,
+				},
+			}, nil
+		},
+	}, nil
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKubernetesContextFromKubeconfig(t *testing.T) {
+	kubeconfig := func(cluster, user string) string {
+		return `apiVersion: v1
+kind: Config
+clusters:
+- name: c
+  cluster:
+    server: https://127.0.0.1:6443
+` + cluster + `
+users:
+- name: u
+  user:
+` + user + `
+contexts:
+- name: dev
+  context:
+    cluster: c
+    user: u
+current-context: dev
+`
+	}
+
+	tests := []struct {
+		name    string
+		cluster string
+		user    string
+		err     string
+	}{
+		{"token", "", "    token: secret", ""},
+		{"client certificate data", "    certificate-authority-data: " + testPEM, "    client-certificate-data: " + testPEM + "\n    client-key-data: " + testPEM, ""},
+		{"exec plugin", "", "    exec:\n      apiVersion: client.authentication.k8s.io/v1\n      command: sh\n      args: [-c, touch /tmp/pwned]", "exec plugins"},
+		{"auth provider", "", "    auth-provider:\n      name: oidc\n      config:\n        cmd-path: /bin/sh", "auth providers"},
+		{"token file", "", "    tokenFile: /etc/shadow", "token files"},
+		{"client certificate file", "", "    client-certificate: /root/.ssh/id_rsa\n    client-key-data: " + testPEM, "client-certificate files"},
+		{"client key file", "", "    client-certificate-data: " + testPEM + "\n    client-key: /root/.ssh/id_rsa", "client-key files"},
+		{"certificate authority file", "    certificate-authority: /etc/ssl/private/key.pem", "    token: secret", "certificate-authority files"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := KubernetesContextFromKubeconfig("", []byte(kubeconfig(tt.cluster, tt.user)))
+
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// testPEM is the base64 of a placeholder, which is only parsed as a
+// certificate by the transport
+const testPEM = "dGVzdA=="
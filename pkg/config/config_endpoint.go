@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// EndpointConfig associates a Prometheus or Loki with matching contexts
+// instead of the one detected in the cluster: either an in-cluster service
+// or the URL of an endpoint outside of it, e.g. a managed service.
+type EndpointConfig struct {
+	// Context is a Kubernetes context, patterns support the * wildcard
+	Context string `json:"context"`
+
+	// URL of an endpoint outside of the cluster
+	URL string `json:"url,omitempty"`
+
+	// Headers are sent with each request, e.g. the X-Scope-OrgID of
+	// multi-tenant installations or Authorization of URLs; values expand
+	// environment variables
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Namespace, Service and Port of an in-cluster endpoint, the port
+	// defaults to the one of the API (9090 for Prometheus, 3100 for Loki)
+	Namespace string `json:"namespace,omitempty"`
+	Service   string `json:"service,omitempty"`
+	Port      int    `json:"port,omitempty"`
+
+	// Scheme of the service, defaults to https for port 443 and http else
+	Scheme string `json:"scheme,omitempty"`
+
+	// PortForward reaches the service through a port-forward to one of its
+	// pods instead of the service proxy of the API server
+	PortForward bool `json:"portForward,omitempty"`
+}
+
+// ContextPrometheus returns the configured Prometheus of a context.
+func (cfg *Config) ContextPrometheus(context string) (*EndpointConfig, bool) {
+	return matchEndpoint(cfg.Prometheus, context)
+}
+
+// ContextLoki returns the configured Loki of a context.
+func (cfg *Config) ContextLoki(context string) (*EndpointConfig, bool) {
+	return matchEndpoint(cfg.Loki, context)
+}
+
+func matchEndpoint(endpoints []EndpointConfig, context string) (*EndpointConfig, bool) {
+	for i := range endpoints {
+		if matchesPattern(context, endpoints[i].Context) {
+			return &endpoints[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// validateEndpoints validates the endpoints of a kind, e.g. prometheus, and
+// applies the defaults.
+func validateEndpoints(kind string, endpoints []EndpointConfig, port int) error {
+	for i, e := range endpoints {
+		if e.Context == "" {
+			return fmt.Errorf("%s %d has no context", kind, i)
+		}
+
+		if (e.URL == "") == (e.Service == "") {
+			return fmt.Errorf("%s of %s requires either a url or a service", kind, e.Context)
+		}
+
+		for key, value := range e.Headers {
+			e.Headers[key] = os.ExpandEnv(value)
+		}
+
+		if e.URL != "" {
+			u, err := url.Parse(e.URL)
+
+			if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+				return fmt.Errorf("invalid url of %s of %s", kind, e.Context)
+			}
+
+			if e.PortForward {
+				return fmt.Errorf("%s of %s cannot forward to a url", kind, e.Context)
+			}
+
+			endpoints[i].URL = strings.TrimSuffix(e.URL, "/")
+
+			continue
+		}
+
+		if e.Namespace == "" {
+			return fmt.Errorf("%s service of %s requires a namespace", kind, e.Context)
+		}
+
+		// the API server authenticates requests of its service proxy
+		for key := range e.Headers {
+			if strings.EqualFold(key, "Authorization") {
+				return fmt.Errorf("%s service of %s cannot send an Authorization header", kind, e.Context)
+			}
+		}
+
+		if e.Port < 0 || e.Port > 65535 {
+			return fmt.Errorf("invalid port of %s of %s", kind, e.Context)
+		}
+
+		if e.Port == 0 {
+			endpoints[i].Port = port
+		}
+
+		switch e.Scheme {
+		case "", "http", "https":
+		default:
+			return fmt.Errorf("unsupported scheme %q of %s of %s", e.Scheme, kind, e.Context)
+		}
+	}
+
+	return nil
+}
+
+func applyEndpointConfig(cfg *Config, prometheus, loki []EndpointConfig) error {
+	if err := validateEndpoints("prometheus", prometheus, 9090); err != nil {
+		return err
+	}
+
+	if err := validateEndpoints("loki", loki, 3100); err != nil {
+		return err
+	}
+
+	cfg.Prometheus = prometheus
+	cfg.Loki = loki
+
+	return nil
+}
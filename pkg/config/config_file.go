@@ -0,0 +1,106 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/yaml"
+)
+
+type File struct {
+	CrashReports bool `json:"crashReports,omitempty"`
+
+	Contexts        []string `json:"contexts,omitempty"`
+	ExcludeContexts []string `json:"excludeContexts,omitempty"`
+
+	ProtectedNamespaces []string `json:"protectedNamespaces,omitempty"`
+	ReadOnlyNamespaces  []string `json:"readOnlyNamespaces,omitempty"`
+
+	PinnedContexts []string `json:"pinnedContexts,omitempty"`
+
+	NonProductionContexts []string `json:"nonProductionContexts,omitempty"`
+	KeepAliveInterval     string   `json:"keepAliveInterval,omitempty"`
+
+	MaxListLimit    int64 `json:"maxListLimit,omitempty"`
+	MaxLogTailLines int64 `json:"maxLogTailLines,omitempty"`
+	MaxResponseSize int64 `json:"maxResponseSize,omitempty"`
+
+	MaxSessions        int    `json:"maxSessions,omitempty"`
+	MaxSessionsPerUser int    `json:"maxSessionsPerUser,omitempty"`
+	SessionIdleTimeout string `json:"sessionIdleTimeout,omitempty"`
+
+	// MaxStaleCacheSize of 0 disables the responses kept for offline contexts
+	MaxStaleCacheSize *int64 `json:"maxStaleCacheSize,omitempty"`
+
+	// Transcripts records the output of exec and log sessions, which may
+	// contain secrets printed in the terminal, in the local store; off by
+	// default and kept for retention.transcripts (7 days by default)
+	Transcripts bool `json:"transcripts,omitempty"`
+
+	FieldManager string `json:"fieldManager,omitempty"`
+
+	// MaxDisruptionsPerMinute of -1 disables the disruption guard
+	MaxDisruptionsPerMinute int `json:"maxDisruptionsPerMinute,omitempty"`
+
+	Printers []PrinterRule `json:"printers,omitempty"`
+
+	Retention *RetentionFile `json:"retention,omitempty"`
+
+	Auth *AuthConfig `json:"auth,omitempty"`
+
+	Impersonation *ImpersonationConfig `json:"impersonation,omitempty"`
+
+	Cache *CacheConfig `json:"cache,omitempty"`
+
+	Helm *HelmConfig `json:"helm,omitempty"`
+
+	Talos *TalosConfig `json:"talos,omitempty"`
+
+	Tunnels []TunnelConfig `json:"tunnels,omitempty"`
+
+	Upstreams []UpstreamConfig `json:"upstreams,omitempty"`
+
+	Prometheus []EndpointConfig `json:"prometheus,omitempty"`
+	Loki       []EndpointConfig `json:"loki,omitempty"`
+}
+
+func DataDir() string {
+	if dir := os.Getenv("BRIDGE_HOME"); dir != "" {
+		return dir
+	}
+
+	home, err := os.UserHomeDir()
+
+	if err != nil {
+		return ".bridge"
+	}
+
+	return filepath.Join(home, ".bridge")
+}
+
+func loadFile(path string) (*File, error) {
+	explicit := path != ""
+
+	if !explicit {
+		path = filepath.Join(DataDir(), "config.yaml")
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		if !explicit && errors.Is(err, os.ErrNotExist) {
+			return &File{}, nil
+		}
+
+		return nil, err
+	}
+
+	file := &File{}
+
+	if err := yaml.Unmarshal(data, file); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"regexp"
+	"strings"
+)
+
+type ContextFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Allowed reports whether a context name passes the filter. Patterns support
+// the * wildcard; an empty include list allows every context.
+func (f *ContextFilter) Allowed(name string) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.Include) > 0 && !matchesAny(name, f.Include) {
+		return false
+	}
+
+	if matchesAny(name, f.Exclude) {
+		return false
+	}
+
+	return true
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matchesPattern(name, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesPattern(name, pattern string) bool {
+	if !strings.Contains(pattern, "*") {
+		return name == pattern
+	}
+
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+
+	matched, _ := regexp.MatchString(expr, name)
+	return matched
+}
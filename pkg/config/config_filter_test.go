@@ -0,0 +1,148 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestContextFilterAllowed(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *ContextFilter
+		allow  []string
+		deny   []string
+	}{
+		{
+			name:   "nil",
+			filter: nil,
+			allow:  []string{"dev", "prod"},
+		},
+		{
+			name:   "empty",
+			filter: &ContextFilter{},
+			allow:  []string{"dev", "prod"},
+		},
+		{
+			name:   "include",
+			filter: &ContextFilter{Include: []string{"dev-*", "staging"}},
+			allow:  []string{"dev-a", "dev-", "staging"},
+			deny:   []string{"dev", "staging-b", "prod"},
+		},
+		{
+			name:   "exclude",
+			filter: &ContextFilter{Exclude: []string{"*-prod"}},
+			allow:  []string{"dev", "prod"},
+			deny:   []string{"eu-prod", "-prod"},
+		},
+		{
+			name:   "exclude takes precedence over include",
+			filter: &ContextFilter{Include: []string{"dev-*"}, Exclude: []string{"dev-legacy"}},
+			allow:  []string{"dev-a"},
+			deny:   []string{"dev-legacy", "prod"},
+		},
+		{
+			name:   "patterns are literal except the wildcard",
+			filter: &ContextFilter{Include: []string{"arn:aws:eks:*:cluster/a.b"}},
+			allow:  []string{"arn:aws:eks:eu-west-1:cluster/a.b"},
+			deny:   []string{"arn:aws:eks:eu-west-1:cluster/aXb"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range tt.allow {
+				if !tt.filter.Allowed(name) {
+					t.Errorf("expected %q to be allowed", name)
+				}
+			}
+
+			for _, name := range tt.deny {
+				if tt.filter.Allowed(name) {
+					t.Errorf("expected %q to be filtered", name)
+				}
+			}
+		})
+	}
+}
+
+func TestContextFilterSources(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Setenv("BRIDGE_HOME", dir)
+	t.Setenv("KUBECONFIG", filepath.Join(dir, "kubeconfig"))
+
+	data := []byte("contexts: [file-*]\nexcludeContexts: [file-b]\n")
+
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		options Options
+		include []string
+		exclude []string
+	}{
+		{
+			name:    "file",
+			include: []string{"file-*"},
+			exclude: []string{"file-b"},
+		},
+		{
+			name: "environment overrides file",
+			env: map[string]string{
+				"BRIDGE_CONTEXTS": "env-a, env-b",
+			},
+			include: []string{"env-a", "env-b"},
+			exclude: []string{"file-b"},
+		},
+		{
+			name: "options override environment",
+			env: map[string]string{
+				"BRIDGE_CONTEXTS":         "env-a",
+				"BRIDGE_EXCLUDE_CONTEXTS": "env-b",
+			},
+			options: Options{
+				Contexts: []string{"flag-a"},
+			},
+			include: []string{"flag-a"},
+			exclude: []string{"env-b"},
+		},
+		{
+			name: "options override file",
+			options: Options{
+				ExcludeContexts: []string{"flag-b"},
+			},
+			include: []string{"file-*"},
+			exclude: []string{"flag-b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BRIDGE_CONTEXTS", "")
+			t.Setenv("BRIDGE_EXCLUDE_CONTEXTS", "")
+
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, err := New(&tt.options)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(cfg.filter.Include, tt.include) {
+				t.Errorf("include = %v, want %v", cfg.filter.Include, tt.include)
+			}
+
+			if !slices.Equal(cfg.filter.Exclude, tt.exclude) {
+				t.Errorf("exclude = %v, want %v", cfg.filter.Exclude, tt.exclude)
+			}
+		})
+	}
+}
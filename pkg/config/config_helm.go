@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// HelmConfig configures the chart repositories charts are installed from.
+type HelmConfig struct {
+	Repositories []HelmRepository `json:"repositories,omitempty"`
+}
+
+// HelmRepository is a chart repository (https://) or a namespace of an OCI
+// registry (oci://). Private OCI registries use the credentials of helm
+// registry login.
+type HelmRepository struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// IsOCI reports whether the repository is an OCI registry.
+func (r *HelmRepository) IsOCI() bool {
+	return strings.HasPrefix(r.URL, "oci://")
+}
+
+// Repository returns a repository by name.
+func (c *HelmConfig) Repository(name string) (*HelmRepository, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	for i := range c.Repositories {
+		if strings.EqualFold(c.Repositories[i].Name, name) {
+			return &c.Repositories[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func applyHelmConfig(cfg *Config, helm *HelmConfig) error {
+	if helm == nil {
+		return nil
+	}
+
+	for i, r := range helm.Repositories {
+		if r.Name == "" {
+			return fmt.Errorf("helm repository %d has no name", i)
+		}
+
+		u, err := url.Parse(r.URL)
+
+		if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http" && u.Scheme != "oci") {
+			return fmt.Errorf("invalid url of helm repository %s", r.Name)
+		}
+
+		helm.Repositories[i].URL = strings.TrimSuffix(r.URL, "/")
+	}
+
+	cfg.Helm = helm
+
+	return nil
+}
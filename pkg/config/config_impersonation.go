@@ -0,0 +1,59 @@
+package config
+
+import (
+	"errors"
+	"slices"
+)
+
+// ImpersonationConfig lets callers preview what other users see, by sending
+// the Impersonate-User and Impersonate-Group headers. The credentials of the
+// contexts need the impersonate permission of the cluster.
+type ImpersonationConfig struct {
+	// Users and Groups of server mode callers allowed to impersonate; without
+	// server mode auth the local user may always impersonate
+	Users  []string `json:"users,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// ImpersonationAllowed reports whether a caller may impersonate other users.
+func (cfg *Config) ImpersonationAllowed(auth *AuthInfo) bool {
+	i := cfg.Impersonation
+
+	if i == nil {
+		return false
+	}
+
+	if cfg.Auth == nil {
+		return true
+	}
+
+	if auth == nil || auth.User == "" {
+		return false
+	}
+
+	if slices.Contains(i.Users, auth.User) {
+		return true
+	}
+
+	for _, g := range auth.Groups {
+		if slices.Contains(i.Groups, g) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func applyImpersonationConfig(cfg *Config, impersonation *ImpersonationConfig) error {
+	if impersonation == nil {
+		return nil
+	}
+
+	if cfg.Auth != nil && len(impersonation.Users) == 0 && len(impersonation.Groups) == 0 {
+		return errors.New("impersonation in server mode requires users or groups")
+	}
+
+	cfg.Impersonation = impersonation
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package config
+
+import "testing"
+
+func TestImpersonationAllowed(t *testing.T) {
+	impersonation := &ImpersonationConfig{
+		Users:  []string{"alice"},
+		Groups: []string{"admins"},
+	}
+
+	tests := []struct {
+		name          string
+		auth          *AuthConfig
+		impersonation *ImpersonationConfig
+		caller        *AuthInfo
+		allowed       bool
+	}{
+		{"disabled", nil, nil, nil, false},
+		{"disabled in server mode", &AuthConfig{}, nil, &AuthInfo{User: "alice"}, false},
+		{"local user", nil, &ImpersonationConfig{}, nil, true},
+		{"local bearer", nil, &ImpersonationConfig{}, &AuthInfo{Bearer: "token"}, true},
+		{"user", &AuthConfig{}, impersonation, &AuthInfo{User: "alice"}, true},
+		{"group", &AuthConfig{}, impersonation, &AuthInfo{User: "bob", Groups: []string{"devs", "admins"}}, true},
+		{"other user", &AuthConfig{}, impersonation, &AuthInfo{User: "bob", Groups: []string{"devs"}}, false},
+		{"anonymous", &AuthConfig{}, impersonation, nil, false},
+		{"bearer without user", &AuthConfig{}, impersonation, &AuthInfo{Bearer: "token", Groups: []string{"admins"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Auth:          tt.auth,
+				Impersonation: tt.impersonation,
+			}
+
+			if allowed := cfg.ImpersonationAllowed(tt.caller); allowed != tt.allowed {
+				t.Fatalf("allowed = %v, want %v", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestApplyImpersonationConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		auth          *AuthConfig
+		impersonation *ImpersonationConfig
+		valid         bool
+	}{
+		{"none", &AuthConfig{}, nil, true},
+		{"local", nil, &ImpersonationConfig{}, true},
+		{"server mode", &AuthConfig{}, &ImpersonationConfig{Groups: []string{"admins"}}, true},
+		{"server mode without callers", &AuthConfig{}, &ImpersonationConfig{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Auth: tt.auth,
+			}
+
+			err := applyImpersonationConfig(cfg, tt.impersonation)
+
+			if (err == nil) != tt.valid {
+				t.Fatalf("error = %v, want valid %v", err, tt.valid)
+			}
+
+			if err == nil && cfg.Impersonation != tt.impersonation {
+				t.Fatal("expected the impersonation config to be applied")
+			}
+		})
+	}
+}
@@ -2,10 +2,19 @@ package config
 
 import (
 	"context"
-	"errors"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
 type KubernetesConfig struct {
@@ -16,51 +25,253 @@ type KubernetesConfig struct {
 
 	TenancyLabels      []string
 	PlatformNamespaces []string
+
+	// InCluster is set if the bridge runs in a cluster with the credentials
+	// of its service account
+	InCluster bool
+}
+
+// ContextOrigin links a context to the object of a host context it was
+// derived from, e.g. a vcluster or a Cluster API workload cluster.
+type ContextOrigin struct {
+	// Kind is vcluster or capi
+	Kind string
+
+	Context   string
+	Namespace string
+	Name      string
 }
 
 type KubernetesContext struct {
 	Name string
 
+	// Dynamic contexts are registered at runtime rather than read from the kubeconfig
+	Dynamic bool
+
+	// Pinned contexts keep their upstream connection warm
+	Pinned bool
+
+	Owner   string
+	Expires time.Time
+
+	// Origin is set for contexts of clusters running in another context
+	Origin *ContextOrigin
+
+	// Teleport is set for contexts authenticated by tsh
+	Teleport *TeleportContext
+
+	// Upstream is the bridge a federated context is served by
+	Upstream string
+
+	// Fingerprint identifies the kubeconfig entries of a context, so
+	// connections are only dropped on reloads if they changed
+	Fingerprint string
+
 	Config func(ctx context.Context, auth *AuthInfo) (*rest.Config, error)
 }
 
 func applyKubernetesConfig(cfg *Config) error {
-	loader := clientcmd.NewDefaultClientConfigLoadingRules()
+	// start with an empty configuration so the bridge stays usable
+	// (e.g. for docker) without any kubeconfig present
+	cfg.Kubernetes = &KubernetesConfig{}
+
+	k, err := cfg.LoadKubernetes()
+
+	if err != nil {
+		return err
+	}
+
+	cfg.Kubernetes = k
+
+	return nil
+}
+
+// KubernetesFiles returns the kubeconfig files contexts are read from.
+func (cfg *Config) KubernetesFiles() []string {
+	return cfg.loadingRules().GetLoadingPrecedence()
+}
+
+// loadingRules merges the kubeconfig files given as flags or, without them,
+// the path list of KUBECONFIG (or ~/.kube/config). For each context, cluster
+// and user the first file defining it wins, as with kubectl.
+func (cfg *Config) loadingRules() *clientcmd.ClientConfigLoadingRules {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+
+	if len(cfg.kubeconfigs) > 0 {
+		rules.Precedence = cfg.kubeconfigs
+		return rules
+	}
+
+	// KUBECONFIG set outside a shell (e.g. by an IDE) may use a literal ~
+	for i, path := range rules.Precedence {
+		rules.Precedence[i] = expandHome(path)
+	}
+
+	return rules
+}
+
+// kubeconfigFiles resolves the kubeconfig files given as flags. Unlike those
+// of KUBECONFIG, they must exist.
+func kubeconfigFiles(paths []string) ([]string, error) {
+	var result []string
+
+	for _, path := range paths {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+
+		path, err := filepath.Abs(expandHome(path))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("invalid kubeconfig: %w", err)
+		}
+
+		if !slices.Contains(result, path) {
+			result = append(result, path)
+		}
+	}
+
+	return result, nil
+}
+
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, path[1:])
+}
+
+// LoadKubernetes reads the kubeconfig from disk, applying the context filter.
+// It returns an empty configuration if no contexts are available.
+func (cfg *Config) LoadKubernetes() (*KubernetesConfig, error) {
+	if cfg.inCluster {
+		return loadInCluster(cfg)
+	}
+
+	loader := cfg.loadingRules()
 	kubeconfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loader, &clientcmd.ConfigOverrides{})
 
 	config, err := kubeconfig.RawConfig()
 
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// pods without kubeconfig fall back to their service account
+	if len(config.Contexts) == 0 {
+		if result, err := loadInCluster(cfg); err == nil {
+			return result, nil
+		}
 	}
 
 	contexts := make([]KubernetesContext, 0)
 
 	for contextName := range config.Contexts {
+		if !cfg.filter.Allowed(contextName) {
+			continue
+		}
+
 		contextConfig := clientcmd.NewNonInteractiveClientConfig(config, contextName, &clientcmd.ConfigOverrides{}, loader)
 
+		var teleport *TeleportContext
+
+		if user, ok := config.AuthInfos[config.Contexts[contextName].AuthInfo]; ok {
+			teleport = teleportContext(user.Exec)
+		}
+
 		contexts = append(contexts, KubernetesContext{
 			Name: contextName,
 
+			Pinned: matchesAny(contextName, cfg.pinned),
+
+			Teleport: teleport,
+
+			Fingerprint: contextFingerprint(&config, contextName),
+
 			Config: func(ctx context.Context, auth *AuthInfo) (*rest.Config, error) {
 				return contextConfig.ClientConfig()
 			},
 		})
 	}
 
-	if len(contexts) == 0 {
-		return errors.New("no valid kubernetes contexts found in kubeconfig")
+	result := &KubernetesConfig{
+		Contexts: contexts,
 	}
 
-	cfg.Kubernetes = &KubernetesConfig{
-		Contexts: contexts,
+	if _, ok := config.Contexts[config.CurrentContext]; ok && cfg.filter.Allowed(config.CurrentContext) {
+		result.CurrentContext = config.CurrentContext
+	}
 
-		CurrentContext: config.CurrentContext,
+	if c, ok := config.Contexts[result.CurrentContext]; ok && c.Namespace != "" {
+		result.CurrentNamespace = c.Namespace
 	}
 
-	if c, ok := config.Contexts[config.CurrentContext]; ok && c.Namespace != "" {
-		cfg.Kubernetes.CurrentNamespace = c.Namespace
+	return result, nil
+}
+
+// contextFingerprint hashes the context, cluster and user entries of a
+// kubeconfig context.
+func contextFingerprint(config *clientcmdapi.Config, name string) string {
+	c := config.Contexts[name]
+
+	data, _ := json.Marshal([]any{
+		c,
+		config.Clusters[c.Cluster],
+		config.AuthInfos[c.AuthInfo],
+	})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// InClusterContext is the name of the context of the cluster the bridge
+// runs in.
+const InClusterContext = "in-cluster"
+
+const serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// loadInCluster builds the context of the cluster the bridge runs in from
+// the service account of its pod, e.g. if deployed as a shared dashboard.
+func loadInCluster(cfg *Config) (*KubernetesConfig, error) {
+	config, err := rest.InClusterConfig()
+
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	result := &KubernetesConfig{
+		Contexts: []KubernetesContext{
+			{
+				Name: InClusterContext,
+
+				Pinned: matchesAny(InClusterContext, cfg.pinned),
+
+				// the token file is re-read as it is rotated
+				Config: func(ctx context.Context, auth *AuthInfo) (*rest.Config, error) {
+					return rest.CopyConfig(config), nil
+				},
+			},
+		},
+
+		CurrentContext: InClusterContext,
+
+		InCluster: true,
+	}
+
+	if data, err := os.ReadFile(serviceAccountNamespace); err == nil {
+		result.CurrentNamespace = strings.TrimSpace(string(data))
+	}
+
+	return result, nil
 }
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+- name: prod
+  cluster:
+    server: https://prod.example.com
+users:
+- name: dev
+  user:
+    token: %s
+- name: prod
+  user:
+    token: prod-token
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: dev
+- name: prod
+  context:
+    cluster: prod
+    user: prod
+current-context: %s
+`
+
+func TestContextFingerprint(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Setenv("BRIDGE_HOME", dir)
+	t.Setenv("BRIDGE_CONTEXTS", "")
+	t.Setenv("BRIDGE_EXCLUDE_CONTEXTS", "")
+
+	path := filepath.Join(dir, "kubeconfig")
+
+	load := func(token, current string) map[string]string {
+		t.Helper()
+
+		data := []byte(fmt.Sprintf(testKubeconfig, token, current))
+
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg, err := New(&Options{Kubeconfigs: []string{path}})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result := make(map[string]string)
+
+		for _, c := range cfg.Kubernetes.Contexts {
+			result[c.Name] = c.Fingerprint
+		}
+
+		return result
+	}
+
+	before := load("dev-token", "dev")
+
+	if before["dev"] == "" || before["dev"] == before["prod"] {
+		t.Fatalf("unexpected fingerprints %v", before)
+	}
+
+	// switching the current context changes no context
+	if after := load("dev-token", "prod"); after["dev"] != before["dev"] || after["prod"] != before["prod"] {
+		t.Fatalf("fingerprints changed without changes to the contexts: %v, %v", before, after)
+	}
+
+	after := load("rotated-token", "dev")
+
+	if after["dev"] == before["dev"] {
+		t.Error("expected the fingerprint of the changed context to change")
+	}
+
+	if after["prod"] != before["prod"] {
+		t.Error("expected the fingerprint of the unchanged context to stay")
+	}
+}
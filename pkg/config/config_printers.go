@@ -0,0 +1,23 @@
+package config
+
+// PrinterRule defines computed columns and a health expression for a
+// resource, typically a custom resource of an in-house operator.
+type PrinterRule struct {
+	Group    string `json:"group"`
+	Resource string `json:"resource"`
+
+	Columns []PrinterColumn `json:"columns,omitempty"`
+
+	// Health is a CEL expression on self returning Healthy, Progressing,
+	// Degraded or Unknown, or a bool (true is Healthy)
+	Health string `json:"health,omitempty"`
+}
+
+// PrinterColumn is computed either from a JSONPath (e.g. .status.phase) or a
+// CEL expression on self (e.g. self.status.ready + '/' + self.spec.replicas).
+type PrinterColumn struct {
+	Name string `json:"name"`
+
+	JSONPath string `json:"jsonPath,omitempty"`
+	CEL      string `json:"cel,omitempty"`
+}
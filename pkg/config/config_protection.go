@@ -0,0 +1,38 @@
+package config
+
+type Protection int
+
+const (
+	// ProtectionNone allows all operations
+	ProtectionNone Protection = iota
+
+	// ProtectionConfirm requires a confirmation token for mutating operations
+	ProtectionConfirm
+
+	// ProtectionReadOnly blocks all mutating operations
+	ProtectionReadOnly
+)
+
+// NamespaceProtection returns the protection of a namespace. Patterns support
+// the * wildcard; read-only patterns take precedence.
+func (cfg *Config) NamespaceProtection(namespace string) Protection {
+	if namespace == "" {
+		return ProtectionNone
+	}
+
+	if matchesAny(namespace, cfg.ReadOnlyNamespaces) {
+		return ProtectionReadOnly
+	}
+
+	if matchesAny(namespace, cfg.ProtectedNamespaces) {
+		return ProtectionConfirm
+	}
+
+	return ProtectionNone
+}
+
+// NonProduction reports whether a context is tagged as non-production.
+// Patterns support the * wildcard.
+func (cfg *Config) NonProduction(context string) bool {
+	return matchesAny(context, cfg.NonProductionContexts)
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionConfig bounds how long local data is kept per data class. Data
+// older than the retention is pruned automatically; zero keeps it forever.
+type RetentionConfig struct {
+	// Audit entries of changes made through the bridge
+	Audit time.Duration
+
+	// Snapshots of deleted objects kept for restore (trash)
+	Snapshots time.Duration
+
+	// History of monitor results and recently used namespaces
+	History time.Duration
+
+	// CrashReports written to the data directory
+	CrashReports time.Duration
+
+	// Transcripts of exec and log sessions
+	Transcripts time.Duration
+}
+
+// RetentionFile configures retentions as durations, e.g. "720h" or "30d".
+type RetentionFile struct {
+	Audit        string `json:"audit,omitempty"`
+	Snapshots    string `json:"snapshots,omitempty"`
+	History      string `json:"history,omitempty"`
+	CrashReports string `json:"crashReports,omitempty"`
+	Transcripts  string `json:"transcripts,omitempty"`
+}
+
+func applyRetentionConfig(cfg *Config, file *RetentionFile) error {
+	cfg.Retention = RetentionConfig{
+		Snapshots:   7 * 24 * time.Hour,
+		Transcripts: 7 * 24 * time.Hour,
+	}
+
+	if file == nil {
+		return nil
+	}
+
+	for _, r := range []struct {
+		name  string
+		value string
+		field *time.Duration
+	}{
+		{"audit", file.Audit, &cfg.Retention.Audit},
+		{"snapshots", file.Snapshots, &cfg.Retention.Snapshots},
+		{"history", file.History, &cfg.Retention.History},
+		{"crashReports", file.CrashReports, &cfg.Retention.CrashReports},
+		{"transcripts", file.Transcripts, &cfg.Retention.Transcripts},
+	} {
+		if r.value == "" {
+			continue
+		}
+
+		d, err := parseRetention(r.value)
+
+		if err != nil {
+			return fmt.Errorf("invalid retention %s: %w", r.name, err)
+		}
+
+		*r.field = d
+	}
+
+	return nil
+}
+
+// parseRetention parses a duration, which may also be given in days.
+func parseRetention(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", s)
+		}
+
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration %q", s)
+	}
+
+	return d, nil
+}
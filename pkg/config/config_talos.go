@@ -0,0 +1,67 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TalosConfig enables the management of Talos nodes with talosctl. Nodes are
+// managed with the credentials of the talosconfig, not of the caller.
+type TalosConfig struct {
+	// Config is the path of the talosconfig
+	Config string `json:"config"`
+
+	// Clusters enables contexts of Talos clusters; other contexts have no
+	// access to the Talos API
+	Clusters []TalosCluster `json:"clusters"`
+}
+
+type TalosCluster struct {
+	// Context is the Kubernetes context, patterns support the * wildcard
+	Context string `json:"context"`
+
+	// TalosContext of the talosconfig, defaults to its current context
+	TalosContext string `json:"talosContext,omitempty"`
+}
+
+// Cluster returns the Talos cluster of a Kubernetes context.
+func (c *TalosConfig) Cluster(context string) (*TalosCluster, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	for i := range c.Clusters {
+		if matchesPattern(context, c.Clusters[i].Context) {
+			return &c.Clusters[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func applyTalosConfig(cfg *Config, talos *TalosConfig) error {
+	if talos == nil {
+		return nil
+	}
+
+	talos.Config = os.ExpandEnv(talos.Config)
+
+	if talos.Config == "" || len(talos.Clusters) == 0 {
+		return errors.New("talos requires a config and clusters")
+	}
+
+	if _, err := os.Stat(talos.Config); err != nil {
+		return fmt.Errorf("invalid talos config: %w", err)
+	}
+
+	for i, c := range talos.Clusters {
+		if c.Context == "" {
+			return fmt.Errorf("talos cluster %d has no context", i)
+		}
+	}
+
+	cfg.Talos = talos
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// TeleportContext is a context whose credentials are issued by the tsh exec
+// plugin of Teleport (tsh kube credentials).
+type TeleportContext struct {
+	// Proxy is the address of the Teleport proxy, e.g. teleport.example.com:443
+	Proxy string
+
+	// Cluster is the Teleport cluster and KubeCluster the Kubernetes cluster
+	// registered in it
+	Cluster     string
+	KubeCluster string
+
+	// Command and Env of the exec plugin, e.g. TELEPORT_HOME
+	Command string
+	Env     []string
+}
+
+// teleportContext detects the tsh exec plugin of a kubeconfig user.
+func teleportContext(exec *clientcmdapi.ExecConfig) *TeleportContext {
+	if exec == nil {
+		return nil
+	}
+
+	if name := strings.TrimSuffix(filepath.Base(exec.Command), ".exe"); name != "tsh" {
+		return nil
+	}
+
+	if len(exec.Args) < 2 || exec.Args[0] != "kube" || exec.Args[1] != "credentials" {
+		return nil
+	}
+
+	t := &TeleportContext{
+		Command: exec.Command,
+	}
+
+	for i := 2; i < len(exec.Args); i++ {
+		key, value, ok := strings.Cut(exec.Args[i], "=")
+
+		if !ok && i+1 < len(exec.Args) {
+			i++
+			value = exec.Args[i]
+		}
+
+		switch key {
+		case "--proxy":
+			t.Proxy = value
+		case "--teleport-cluster":
+			t.Cluster = value
+		case "--kube-cluster":
+			t.KubeCluster = value
+		}
+	}
+
+	for _, e := range exec.Env {
+		t.Env = append(t.Env, e.Name+"="+e.Value)
+	}
+
+	return t
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+)
+
+// TunnelConfig reaches the private API server or Docker host of matching
+// contexts through a cloud tunnel, using the ambient login of the aws or
+// gcloud CLI.
+type TunnelConfig struct {
+	// Context is a Kubernetes or Docker context, patterns support the *
+	// wildcard
+	Context string `json:"context"`
+
+	// Provider is ssm (AWS Systems Manager) or iap (GCP Identity-Aware Proxy)
+	Provider string `json:"provider"`
+
+	// Target is the instance id (ssm) or instance name (iap) the tunnel
+	// ends at
+	Target string `json:"target"`
+
+	Region  string `json:"region,omitempty"`
+	Profile string `json:"profile,omitempty"`
+
+	Zone    string `json:"zone,omitempty"`
+	Project string `json:"project,omitempty"`
+
+	// Host and Port the tunnel forwards to, defaulting to the address of
+	// the context. IAP tunnels only reach ports of the target itself.
+	Host string `json:"host,omitempty"`
+	Port int    `json:"port,omitempty"`
+}
+
+// Tunnel returns the tunnel of a context.
+func (cfg *Config) Tunnel(context string) (*TunnelConfig, bool) {
+	for i := range cfg.Tunnels {
+		if matchesPattern(context, cfg.Tunnels[i].Context) {
+			return &cfg.Tunnels[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func applyTunnelConfig(cfg *Config, tunnels []TunnelConfig) error {
+	for i, t := range tunnels {
+		if t.Context == "" || t.Target == "" {
+			return fmt.Errorf("tunnel %d requires a context and a target", i)
+		}
+
+		switch t.Provider {
+		case "ssm":
+		case "iap":
+			if t.Zone == "" {
+				return fmt.Errorf("iap tunnel of %s requires a zone", t.Context)
+			}
+
+			if t.Host != "" {
+				return fmt.Errorf("iap tunnel of %s cannot forward to another host", t.Context)
+			}
+
+		default:
+			return fmt.Errorf("unsupported tunnel provider %q", t.Provider)
+		}
+
+		if t.Port < 0 || t.Port > 65535 {
+			return fmt.Errorf("invalid port of tunnel %s", t.Context)
+		}
+	}
+
+	cfg.Tunnels = tunnels
+
+	return nil
+}
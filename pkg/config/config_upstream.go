@@ -0,0 +1,115 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"k8s.io/client-go/rest"
+)
+
+// UpstreamConfig is another bridge in server mode whose Kubernetes contexts
+// are federated into the local contexts, e.g. a central bridge per
+// environment. Federated contexts are named <context>@<upstream> and reach
+// the clusters through the proxy of the upstream.
+type UpstreamConfig struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// Token is a bearer token of the upstream, it may reference an
+	// environment variable (e.g. $BRIDGE_PROD_TOKEN)
+	Token string `json:"token,omitempty"`
+
+	InsecureSkipTLSVerify bool `json:"insecureSkipTLSVerify,omitempty"`
+
+	// ForwardIdentity impersonates the authenticated callers at the
+	// upstream, which must allow the token to impersonate. Otherwise all
+	// callers act as the user of the token.
+	ForwardIdentity bool `json:"forwardIdentity,omitempty"`
+}
+
+func applyUpstreamConfig(cfg *Config, upstreams []UpstreamConfig) error {
+	names := map[string]bool{}
+
+	for i := range upstreams {
+		u := &upstreams[i]
+
+		if u.Name == "" || u.URL == "" {
+			return fmt.Errorf("upstream %d requires a name and a url", i)
+		}
+
+		if strings.ContainsAny(u.Name, "/@") {
+			return fmt.Errorf("upstream name %q must not contain slashes or @", u.Name)
+		}
+
+		if names[strings.ToLower(u.Name)] {
+			return fmt.Errorf("duplicate upstream %q", u.Name)
+		}
+
+		names[strings.ToLower(u.Name)] = true
+
+		target, err := url.Parse(u.URL)
+
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return fmt.Errorf("invalid url of upstream %s", u.Name)
+		}
+
+		u.URL = strings.TrimSuffix(u.URL, "/")
+		u.Token = os.ExpandEnv(u.Token)
+	}
+
+	cfg.Upstreams = upstreams
+
+	return nil
+}
+
+// Upstream returns the upstream of a name.
+func (cfg *Config) Upstream(name string) (*UpstreamConfig, bool) {
+	for i := range cfg.Upstreams {
+		if strings.EqualFold(cfg.Upstreams[i].Name, name) {
+			return &cfg.Upstreams[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// FederatedContextName returns the local name of a context of an upstream.
+func FederatedContextName(upstream, context string) string {
+	return context + "@" + upstream
+}
+
+// KubernetesContextFromUpstream creates a context of an upstream bridge,
+// which proxies the Kubernetes APIs of its contexts.
+func KubernetesContextFromUpstream(u *UpstreamConfig, name string) KubernetesContext {
+	server := u.URL + "/contexts/" + url.PathEscape(name)
+
+	return KubernetesContext{
+		Name: FederatedContextName(u.Name, name),
+
+		Dynamic:  true,
+		Upstream: u.Name,
+
+		Config: func(ctx context.Context, auth *AuthInfo) (*rest.Config, error) {
+			config := &rest.Config{
+				Host:        server,
+				BearerToken: u.Token,
+
+				TLSClientConfig: rest.TLSClientConfig{
+					Insecure: u.InsecureSkipTLSVerify,
+				},
+			}
+
+			if u.ForwardIdentity && auth != nil && auth.User != "" {
+				config.Impersonate = rest.ImpersonationConfig{
+					UserName: auth.User,
+					Groups:   auth.Groups,
+				}
+			}
+
+			return config, nil
+		},
+	}
+}
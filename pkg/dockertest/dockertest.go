@@ -0,0 +1,404 @@
+// Package dockertest provides an in-process Docker Engine API for tests and
+// benchmarks of the docker proxy.
+package dockertest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIVersion is the Docker Engine API version of the server.
+const APIVersion = "1.47"
+
+// Server is a minimal Docker Engine API. It lists, creates, starts, stops
+// and removes containers, and attaches to them with a hijacked connection
+// echoing the input of the client.
+type Server struct {
+	*httptest.Server
+
+	mu sync.Mutex
+
+	containers map[string]*container
+	order      []string
+	next       int
+
+	// list is the encoded container list, reset on changes
+	list []byte
+}
+
+type container struct {
+	ID      string
+	Name    string
+	Image   string
+	Command string
+	Labels  map[string]string
+	Created time.Time
+
+	Running bool
+}
+
+// NewServer starts a server with the given number of running containers.
+func NewServer(containers int) *Server {
+	s := &Server{
+		containers: make(map[string]*container),
+	}
+
+	for i := range containers {
+		s.add(fmt.Sprintf("container-%d", i), "nginx:1.27", map[string]string{
+			"com.docker.compose.project": fmt.Sprintf("project-%d", i%20),
+		}).Running = true
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /_ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Api-Version", APIVersion)
+		w.Write([]byte("OK"))
+	})
+
+	mux.HandleFunc("GET /version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"Version":    "27.0.0",
+			"ApiVersion": APIVersion,
+		})
+	})
+
+	mux.HandleFunc("GET /containers/json", s.handleList)
+	mux.HandleFunc("POST /containers/create", s.handleCreate)
+	mux.HandleFunc("GET /containers/{id}/json", s.handleInspect)
+	mux.HandleFunc("POST /containers/{id}/start", s.handleState(true))
+	mux.HandleFunc("POST /containers/{id}/stop", s.handleState(false))
+	mux.HandleFunc("POST /containers/{id}/attach", s.handleAttach)
+	mux.HandleFunc("DELETE /containers/{id}", s.handleRemove)
+
+	s.Server = httptest.NewServer(stripVersion(mux))
+
+	return s
+}
+
+// Host returns the address of the server as a docker host.
+func (s *Server) Host() string {
+	return "tcp://" + s.Listener.Addr().String()
+}
+
+var versionPrefix = regexp.MustCompile(`^/v[0-9]+\.[0-9]+/`)
+
+// stripVersion serves versioned paths, e.g. /v1.47/containers/json.
+func stripVersion(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if prefix := versionPrefix.FindString(r.URL.Path); prefix != "" {
+			r.URL.Path = r.URL.Path[len(prefix)-1:]
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) add(name, image string, labels map[string]string) *container {
+	c := &container{
+		ID:      fmt.Sprintf("%064x", s.next),
+		Name:    name,
+		Image:   image,
+		Command: "nginx -g 'daemon off;'",
+		Labels:  labels,
+		Created: time.Now(),
+	}
+
+	s.next++
+
+	s.containers[c.ID] = c
+	s.order = append(s.order, c.ID)
+
+	s.list = nil
+
+	return c
+}
+
+// lookup finds a container by its ID, a prefix of the ID or its name.
+func (s *Server) lookup(id string) (*container, bool) {
+	if c, ok := s.containers[id]; ok {
+		return c, true
+	}
+
+	for _, key := range s.order {
+		c := s.containers[key]
+
+		if c.Name == strings.TrimPrefix(id, "/") || (len(id) >= 12 && strings.HasPrefix(c.ID, id)) {
+			return c, true
+		}
+	}
+
+	return nil, false
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	all := r.URL.Query().Get("all")
+
+	s.mu.Lock()
+
+	if all != "1" && all != "true" {
+		items := []any{}
+
+		for _, id := range s.order {
+			if c := s.containers[id]; c.Running {
+				items = append(items, c.summary())
+			}
+		}
+
+		s.mu.Unlock()
+
+		writeJSON(w, http.StatusOK, items)
+		return
+	}
+
+	if s.list == nil {
+		items := make([]any, 0, len(s.order))
+
+		for _, id := range s.order {
+			items = append(items, s.containers[id].summary())
+		}
+
+		s.list, _ = json.Marshal(items)
+	}
+
+	list := s.list
+
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(list)
+}
+
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Image  string            `json:"Image"`
+		Labels map[string]string `json:"Labels"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Image == "" {
+		writeError(w, http.StatusBadRequest, "invalid container config")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := r.URL.Query().Get("name")
+
+	if name == "" {
+		name = fmt.Sprintf("container-%d", s.next)
+	}
+
+	if _, ok := s.lookup(name); ok {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Conflict. The container name %q is already in use", "/"+name))
+		return
+	}
+
+	c := s.add(name, req.Image, req.Labels)
+
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"Id":       c.ID,
+		"Warnings": []string{},
+	})
+}
+
+func (s *Server) handleInspect(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.lookup(r.PathValue("id"))
+
+	if !ok {
+		writeNotFound(w, r.PathValue("id"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"Id":      c.ID,
+		"Name":    "/" + c.Name,
+		"Created": c.Created.Format(time.RFC3339Nano),
+		"State": map[string]any{
+			"Status":  c.state(),
+			"Running": c.Running,
+		},
+		"Config": map[string]any{
+			"Image":  c.Image,
+			"Labels": c.Labels,
+		},
+	})
+}
+
+// handleState starts or stops a container, which is not modified if it
+// already is in the state.
+func (s *Server) handleState(running bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		c, ok := s.lookup(r.PathValue("id"))
+
+		if !ok {
+			writeNotFound(w, r.PathValue("id"))
+			return
+		}
+
+		if c.Running == running {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		c.Running = running
+		s.list = nil
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleRemove(w http.ResponseWriter, r *http.Request) {
+	force := r.URL.Query().Get("force")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.lookup(r.PathValue("id"))
+
+	if !ok {
+		writeNotFound(w, r.PathValue("id"))
+		return
+	}
+
+	if c.Running && force != "1" && force != "true" {
+		writeError(w, http.StatusConflict, "cannot remove container /"+c.Name+": container is running: stop the container before removing or force remove")
+		return
+	}
+
+	delete(s.containers, c.ID)
+
+	for i, id := range s.order {
+		if id == c.ID {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+
+	s.list = nil
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAttach hijacks the connection like the Docker Engine for an upgrade
+// to tcp and echoes the input of the client as the output of a container
+// with a TTY, until the client closes its side.
+func (s *Server) handleAttach(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	c, ok := s.lookup(r.PathValue("id"))
+	running := ok && c.Running
+	s.mu.Unlock()
+
+	if !ok {
+		writeNotFound(w, r.PathValue("id"))
+		return
+	}
+
+	if !running {
+		writeError(w, http.StatusConflict, "You cannot attach to a stopped container, start it first")
+		return
+	}
+
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "tcp") {
+		writeError(w, http.StatusBadRequest, "attach requires an upgrade to tcp")
+		return
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	defer conn.Close()
+
+	fmt.Fprint(rw, "HTTP/1.1 101 UPGRADED\r\n")
+	fmt.Fprint(rw, "Content-Type: application/vnd.docker.raw-stream\r\n")
+	fmt.Fprint(rw, "Connection: Upgrade\r\n")
+	fmt.Fprint(rw, "Upgrade: tcp\r\n")
+	fmt.Fprint(rw, "\r\n")
+
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	echo(rw.Reader, rw.Writer)
+}
+
+func echo(r *bufio.Reader, w *bufio.Writer) {
+	buf := make([]byte, 4096)
+
+	for {
+		n, err := r.Read(buf)
+
+		if n > 0 {
+			w.Write(buf[:n])
+
+			if w.Flush() != nil {
+				return
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *container) state() string {
+	if c.Running {
+		return "running"
+	}
+
+	return "exited"
+}
+
+func (c *container) summary() map[string]any {
+	status := "Exited (0) 1 second ago"
+
+	if c.Running {
+		status = "Up 2 hours"
+	}
+
+	return map[string]any{
+		"Id":      c.ID,
+		"Names":   []string{"/" + c.Name},
+		"Image":   c.Image,
+		"Command": c.Command,
+		"Created": c.Created.Unix(),
+		"State":   c.state(),
+		"Status":  status,
+		"Labels":  c.Labels,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{
+		"message": message,
+	})
+}
+
+func writeNotFound(w http.ResponseWriter, id string) {
+	writeError(w, http.StatusNotFound, "No such container: "+id)
+}
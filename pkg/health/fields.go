@@ -0,0 +1,75 @@
+package health
+
+type objectCondition struct {
+	Type    string
+	Status  string
+	Reason  string
+	Message string
+}
+
+func field(obj map[string]any, path ...string) any {
+	var value any = obj
+
+	for _, key := range path {
+		m, ok := value.(map[string]any)
+
+		if !ok {
+			return nil
+		}
+
+		value = m[key]
+	}
+
+	return value
+}
+
+func stringField(obj map[string]any, path ...string) string {
+	s, _ := field(obj, path...).(string)
+	return s
+}
+
+// intField supports objects decoded with int64 (unstructured) and float64
+// (encoding/json) numbers.
+func intField(obj map[string]any, path ...string) (int64, bool) {
+	switch v := field(obj, path...).(type) {
+	case int64:
+		return v, true
+
+	case int:
+		return int64(v), true
+
+	case float64:
+		return int64(v), true
+	}
+
+	return 0, false
+}
+
+func intFieldOr(obj map[string]any, fallback int64, path ...string) int64 {
+	if v, ok := intField(obj, path...); ok {
+		return v
+	}
+
+	return fallback
+}
+
+func condition(obj map[string]any, conditionType string) (objectCondition, bool) {
+	conditions, _ := field(obj, "status", "conditions").([]any)
+
+	for _, c := range conditions {
+		c, ok := c.(map[string]any)
+
+		if !ok || c["type"] != conditionType {
+			continue
+		}
+
+		return objectCondition{
+			Type:    conditionType,
+			Status:  stringField(c, "status"),
+			Reason:  stringField(c, "reason"),
+			Message: stringField(c, "message"),
+		}, true
+	}
+
+	return objectCondition{}, false
+}
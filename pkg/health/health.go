@@ -0,0 +1,358 @@
+package health
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Status string
+
+const (
+	Healthy     Status = "Healthy"
+	Progressing Status = "Progressing"
+	Degraded    Status = "Degraded"
+	Suspended   Status = "Suspended"
+	Unknown     Status = "Unknown"
+)
+
+type Result struct {
+	Status  Status `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// Check assesses the health of an object of a specific kind. It returns false
+// if it cannot judge the object, which falls back to the generic assessment.
+type Check func(obj map[string]any) (Result, bool)
+
+var checks = map[string]Check{
+	"apps/Deployment":  deploymentHealth,
+	"apps/StatefulSet": statefulSetHealth,
+	"apps/DaemonSet":   daemonSetHealth,
+	"apps/ReplicaSet":  replicaSetHealth,
+
+	"batch/Job":     jobHealth,
+	"batch/CronJob": cronJobHealth,
+
+	"/Pod":                   podHealth,
+	"/PersistentVolumeClaim": pvcHealth,
+	"/Service":               serviceHealth,
+
+	"networking.k8s.io/Ingress":           ingressHealth,
+	"autoscaling/HorizontalPodAutoscaler": hpaHealth,
+
+	"argoproj.io/Application": argoApplicationHealth,
+}
+
+// Register adds or replaces the check of a kind.
+func Register(group, kind string, check Check) {
+	checks[group+"/"+kind] = check
+}
+
+// Assess returns the normalized health of an object, using the check of its
+// kind and otherwise the common status conditions (Ready, Stalled,
+// Reconciling). Objects without status are healthy.
+func Assess(obj map[string]any) Result {
+	group, _, _ := strings.Cut(stringField(obj, "apiVersion"), "/")
+
+	if !strings.Contains(stringField(obj, "apiVersion"), "/") {
+		group = ""
+	}
+
+	if check, ok := checks[group+"/"+stringField(obj, "kind")]; ok {
+		if result, ok := check(obj); ok {
+			return result
+		}
+	}
+
+	return genericHealth(obj)
+}
+
+func genericHealth(obj map[string]any) Result {
+	if generation, ok := intField(obj, "metadata", "generation"); ok {
+		if observed, ok := intField(obj, "status", "observedGeneration"); ok && observed < generation {
+			return Result{Status: Progressing, Message: "waiting for the spec to be observed"}
+		}
+	}
+
+	if c, ok := condition(obj, "Stalled"); ok && c.Status == "True" {
+		return Result{Status: Degraded, Message: c.Message}
+	}
+
+	if c, ok := condition(obj, "Reconciling"); ok && c.Status == "True" {
+		return Result{Status: Progressing, Message: c.Message}
+	}
+
+	for _, t := range []string{"Ready", "Available", "Healthy"} {
+		c, ok := condition(obj, t)
+
+		if !ok {
+			continue
+		}
+
+		switch c.Status {
+		case "True":
+			return Result{Status: Healthy, Message: c.Message}
+
+		case "False":
+			switch c.Reason {
+			case "Progressing", "Reconciling", "Pending", "Issuing", "InProgress", "Provisioning", "Creating", "Updating":
+				return Result{Status: Progressing, Message: c.Message}
+			}
+
+			return Result{Status: Degraded, Message: c.Message}
+		}
+
+		return Result{Status: Progressing, Message: c.Message}
+	}
+
+	return Result{Status: Healthy}
+}
+
+func deploymentHealth(obj map[string]any) (Result, bool) {
+	if paused, _ := field(obj, "spec", "paused").(bool); paused {
+		return Result{Status: Suspended, Message: "deployment is paused"}, true
+	}
+
+	if result, ok := observed(obj); !ok {
+		return result, true
+	}
+
+	if c, ok := condition(obj, "Progressing"); ok && c.Reason == "ProgressDeadlineExceeded" {
+		return Result{Status: Degraded, Message: c.Message}, true
+	}
+
+	replicas := intFieldOr(obj, 1, "spec", "replicas")
+	updated := intFieldOr(obj, 0, "status", "updatedReplicas")
+	total := intFieldOr(obj, 0, "status", "replicas")
+	available := intFieldOr(obj, 0, "status", "availableReplicas")
+
+	switch {
+	case updated < replicas:
+		return Result{Status: Progressing, Message: fmt.Sprintf("%d of %d replicas updated", updated, replicas)}, true
+
+	case total > updated:
+		return Result{Status: Progressing, Message: fmt.Sprintf("%d old replicas pending termination", total-updated)}, true
+
+	case available < updated:
+		return Result{Status: Progressing, Message: fmt.Sprintf("%d of %d updated replicas available", available, updated)}, true
+	}
+
+	return Result{Status: Healthy}, true
+}
+
+func statefulSetHealth(obj map[string]any) (Result, bool) {
+	if result, ok := observed(obj); !ok {
+		return result, true
+	}
+
+	replicas := intFieldOr(obj, 1, "spec", "replicas")
+	ready := intFieldOr(obj, 0, "status", "readyReplicas")
+
+	if ready < replicas {
+		return Result{Status: Progressing, Message: fmt.Sprintf("%d of %d replicas ready", ready, replicas)}, true
+	}
+
+	if stringField(obj, "spec", "updateStrategy", "type") != "OnDelete" {
+		if current, update := stringField(obj, "status", "currentRevision"), stringField(obj, "status", "updateRevision"); update != "" && current != update {
+			updated := intFieldOr(obj, 0, "status", "updatedReplicas")
+			return Result{Status: Progressing, Message: fmt.Sprintf("%d of %d replicas updated", updated, replicas)}, true
+		}
+	}
+
+	return Result{Status: Healthy}, true
+}
+
+func daemonSetHealth(obj map[string]any) (Result, bool) {
+	if result, ok := observed(obj); !ok {
+		return result, true
+	}
+
+	desired := intFieldOr(obj, 0, "status", "desiredNumberScheduled")
+	updated := intFieldOr(obj, 0, "status", "updatedNumberScheduled")
+	available := intFieldOr(obj, 0, "status", "numberAvailable")
+
+	if stringField(obj, "spec", "updateStrategy", "type") != "OnDelete" && updated < desired {
+		return Result{Status: Progressing, Message: fmt.Sprintf("%d of %d pods updated", updated, desired)}, true
+	}
+
+	if available < desired {
+		return Result{Status: Progressing, Message: fmt.Sprintf("%d of %d pods available", available, desired)}, true
+	}
+
+	return Result{Status: Healthy}, true
+}
+
+func replicaSetHealth(obj map[string]any) (Result, bool) {
+	if result, ok := observed(obj); !ok {
+		return result, true
+	}
+
+	if c, ok := condition(obj, "ReplicaFailure"); ok && c.Status == "True" {
+		return Result{Status: Degraded, Message: c.Message}, true
+	}
+
+	replicas := intFieldOr(obj, 1, "spec", "replicas")
+	available := intFieldOr(obj, 0, "status", "availableReplicas")
+
+	if available < replicas {
+		return Result{Status: Progressing, Message: fmt.Sprintf("%d of %d replicas available", available, replicas)}, true
+	}
+
+	return Result{Status: Healthy}, true
+}
+
+func jobHealth(obj map[string]any) (Result, bool) {
+	if c, ok := condition(obj, "Failed"); ok && c.Status == "True" {
+		return Result{Status: Degraded, Message: c.Message}, true
+	}
+
+	if c, ok := condition(obj, "Complete"); ok && c.Status == "True" {
+		return Result{Status: Healthy, Message: c.Message}, true
+	}
+
+	if suspend, _ := field(obj, "spec", "suspend").(bool); suspend {
+		return Result{Status: Suspended, Message: "job is suspended"}, true
+	}
+
+	return Result{Status: Progressing, Message: "job is running"}, true
+}
+
+func cronJobHealth(obj map[string]any) (Result, bool) {
+	if suspend, _ := field(obj, "spec", "suspend").(bool); suspend {
+		return Result{Status: Suspended, Message: "cron job is suspended"}, true
+	}
+
+	return Result{Status: Healthy}, true
+}
+
+// failingReasons of waiting containers that do not resolve by themselves.
+var failingReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"RunContainerError":          true,
+}
+
+func podHealth(obj map[string]any) (Result, bool) {
+	for _, key := range []string{"initContainerStatuses", "containerStatuses"} {
+		statuses, _ := field(obj, "status", key).([]any)
+
+		for _, s := range statuses {
+			s, _ := s.(map[string]any)
+
+			reason := stringField(s, "state", "waiting", "reason")
+
+			if failingReasons[reason] {
+				return Result{Status: Degraded, Message: fmt.Sprintf("%s: %s", stringField(s, "name"), reason)}, true
+			}
+		}
+	}
+
+	switch stringField(obj, "status", "phase") {
+	case "Succeeded":
+		return Result{Status: Healthy, Message: "pod completed"}, true
+
+	case "Failed":
+		message := stringField(obj, "status", "message")
+
+		if message == "" {
+			message = stringField(obj, "status", "reason")
+		}
+
+		return Result{Status: Degraded, Message: message}, true
+
+	case "Pending":
+		if c, ok := condition(obj, "PodScheduled"); ok && c.Status == "False" {
+			return Result{Status: Progressing, Message: c.Message}, true
+		}
+
+		return Result{Status: Progressing, Message: "pod is pending"}, true
+
+	case "Running":
+		if c, ok := condition(obj, "Ready"); ok && c.Status != "True" {
+			return Result{Status: Progressing, Message: "pod is not ready"}, true
+		}
+
+		return Result{Status: Healthy}, true
+	}
+
+	return Result{Status: Unknown}, true
+}
+
+func pvcHealth(obj map[string]any) (Result, bool) {
+	switch stringField(obj, "status", "phase") {
+	case "Bound":
+		return Result{Status: Healthy}, true
+
+	case "Lost":
+		return Result{Status: Degraded, Message: "volume is lost"}, true
+	}
+
+	return Result{Status: Progressing, Message: "waiting for a volume"}, true
+}
+
+func serviceHealth(obj map[string]any) (Result, bool) {
+	if stringField(obj, "spec", "type") != "LoadBalancer" {
+		return Result{Status: Healthy}, true
+	}
+
+	return loadBalancerHealth(obj)
+}
+
+func ingressHealth(obj map[string]any) (Result, bool) {
+	return loadBalancerHealth(obj)
+}
+
+func loadBalancerHealth(obj map[string]any) (Result, bool) {
+	ingress, _ := field(obj, "status", "loadBalancer", "ingress").([]any)
+
+	if len(ingress) == 0 {
+		return Result{Status: Progressing, Message: "waiting for a load balancer address"}, true
+	}
+
+	return Result{Status: Healthy}, true
+}
+
+func hpaHealth(obj map[string]any) (Result, bool) {
+	for _, t := range []string{"AbleToScale", "ScalingActive"} {
+		if c, ok := condition(obj, t); ok && c.Status == "False" {
+			return Result{Status: Degraded, Message: c.Message}, true
+		}
+	}
+
+	return Result{Status: Healthy}, true
+}
+
+func argoApplicationHealth(obj map[string]any) (Result, bool) {
+	status := stringField(obj, "status", "health", "status")
+	message := stringField(obj, "status", "health", "message")
+
+	switch status {
+	case "Healthy", "Progressing", "Degraded", "Suspended":
+		return Result{Status: Status(status), Message: message}, true
+
+	case "Missing":
+		return Result{Status: Degraded, Message: "resources are missing"}, true
+	}
+
+	return Result{Status: Unknown, Message: message}, true
+}
+
+// observed returns false with a progressing result while the controller has
+// not yet observed the latest generation.
+func observed(obj map[string]any) (Result, bool) {
+	generation, ok := intField(obj, "metadata", "generation")
+
+	if !ok {
+		return Result{}, true
+	}
+
+	if observed, ok := intField(obj, "status", "observedGeneration"); ok && observed >= generation {
+		return Result{}, true
+	}
+
+	return Result{Status: Progressing, Message: "waiting for the spec to be observed"}, false
+}
@@ -0,0 +1,120 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Messages are fmt templates by message ID, one catalog per locale. English
+// is the source catalog and the fallback for missing translations.
+//
+//go:embed locales/*.json
+var locales embed.FS
+
+var (
+	catalogs = map[language.Tag]map[string]string{}
+
+	supported []language.Tag
+	matcher   language.Matcher
+)
+
+func init() {
+	files, err := locales.ReadDir("locales")
+
+	if err != nil {
+		panic(err)
+	}
+
+	// English first, as the matcher falls back to the first tag
+	supported = []language.Tag{language.English}
+
+	for _, f := range files {
+		data, err := locales.ReadFile(path.Join("locales", f.Name()))
+
+		if err != nil {
+			panic(err)
+		}
+
+		var messages map[string]string
+
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("invalid catalog %s: %v", f.Name(), err))
+		}
+
+		tag := language.MustParse(strings.TrimSuffix(f.Name(), ".json"))
+		catalogs[tag] = messages
+
+		if tag != language.English {
+			supported = append(supported, tag)
+		}
+	}
+
+	matcher = language.NewMatcher(supported)
+}
+
+// Locales returns the supported locales.
+func Locales() []language.Tag {
+	return supported
+}
+
+// Negotiate returns the locale of a request, given as ?lang= or in the
+// Accept-Language header.
+func Negotiate(r *http.Request) language.Tag {
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		_, index := language.MatchStrings(matcher, lang)
+		return supported[index]
+	}
+
+	_, index := language.MatchStrings(matcher, r.Header.Get("Accept-Language"))
+	return supported[index]
+}
+
+// Translate formats a message in a locale, falling back to English and
+// then to the message ID.
+func Translate(tag language.Tag, id string, args ...any) string {
+	format, ok := catalogs[tag][id]
+
+	if !ok {
+		format, ok = catalogs[language.English][id]
+	}
+
+	if !ok {
+		return id
+	}
+
+	if len(args) == 0 {
+		return format
+	}
+
+	return fmt.Sprintf(format, args...)
+}
+
+// Error is an error with a message of the catalog. Error returns the
+// English message; responses localize it through Translate.
+type Error struct {
+	ID   string
+	Args []any
+}
+
+// NewError returns an error of a message, e.g. for sentinel errors.
+func NewError(id string, args ...any) *Error {
+	return &Error{
+		ID:   id,
+		Args: args,
+	}
+}
+
+func (e *Error) Error() string {
+	return Translate(language.English, e.ID, e.Args...)
+}
+
+// Translate returns the message of the error in a locale.
+func (e *Error) Translate(tag language.Tag) string {
+	return Translate(tag, e.ID, e.Args...)
+}
@@ -0,0 +1,41 @@
+package logging
+
+import (
+	"io"
+	"log"
+	"os"
+)
+
+var recent = NewBuffer(256 * 1024)
+
+// Setup routes the standard logger to stderr, the in-memory buffer of recent
+// entries and, if a path is given, a size-rotated log file.
+func Setup(path string) (io.Closer, error) {
+	writers := []io.Writer{
+		os.Stderr,
+		recent,
+	}
+
+	var closer io.Closer = io.NopCloser(nil)
+
+	if path != "" {
+		f, err := NewFile(path, 10*1024*1024, 3)
+
+		if err != nil {
+			return nil, err
+		}
+
+		writers = append(writers, f)
+		closer = f
+	}
+
+	log.SetOutput(io.MultiWriter(writers...))
+
+	return closer, nil
+}
+
+// Recent returns the last n lines written to the log. A value of n <= 0
+// returns everything that is still buffered.
+func Recent(n int) []byte {
+	return recent.Tail(n)
+}
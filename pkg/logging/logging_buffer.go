@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"bytes"
+	"sync"
+)
+
+type Buffer struct {
+	mu sync.Mutex
+
+	data []byte
+	size int
+}
+
+func NewBuffer(size int) *Buffer {
+	return &Buffer{
+		size: size,
+	}
+}
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.data = append(b.data, p...)
+
+	if over := len(b.data) - b.size; over > 0 {
+		// drop the oldest bytes, keeping the buffer aligned to line starts
+		if i := bytes.IndexByte(b.data[over:], '\n'); i >= 0 {
+			over += i + 1
+		}
+
+		b.data = append(b.data[:0], b.data[over:]...)
+	}
+
+	return len(p), nil
+}
+
+func (b *Buffer) Tail(n int) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data := b.data
+
+	if n > 0 {
+		end := len(data)
+
+		if end > 0 && data[end-1] == '\n' {
+			end--
+		}
+
+		for i := end - 1; i >= 0; i-- {
+			if data[i] != '\n' {
+				continue
+			}
+
+			if n--; n == 0 {
+				data = data[i+1:]
+				break
+			}
+		}
+	}
+
+	return bytes.Clone(data)
+}
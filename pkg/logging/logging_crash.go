@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+var (
+	crashMu  sync.Mutex
+	crashDir string
+)
+
+// EnableCrashReports writes a report file into dir for every recovered panic.
+// Crash reports are opt-in as they may contain request details.
+func EnableCrashReports(dir string) {
+	crashMu.Lock()
+	defer crashMu.Unlock()
+
+	crashDir = dir
+}
+
+// Crash logs a recovered panic value together with its stack trace and, if
+// enabled, persists a crash report. It returns the report path, if any.
+func Crash(v any, details string) string {
+	stack := debug.Stack()
+
+	log.Printf("panic: %v\n%s", v, stack)
+
+	crashMu.Lock()
+	dir := crashDir
+	crashMu.Unlock()
+
+	if dir == "" {
+		return ""
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("failed to create crash report directory: %v", err)
+		return ""
+	}
+
+	now := time.Now()
+	path := filepath.Join(dir, fmt.Sprintf("crash-%s.txt", now.Format("20060102-150405.000")))
+
+	report := fmt.Sprintf("time: %s\nos: %s/%s\ngo: %s\n", now.Format(time.RFC3339), runtime.GOOS, runtime.GOARCH, runtime.Version())
+
+	if details != "" {
+		report += details + "\n"
+	}
+
+	report += fmt.Sprintf("\npanic: %v\n\n%s", v, stack)
+
+	if err := os.WriteFile(path, []byte(report), 0600); err != nil {
+		log.Printf("failed to write crash report: %v", err)
+		return ""
+	}
+
+	return path
+}
@@ -0,0 +1,459 @@
+// Package mdns advertises services in the local network via multicast DNS
+// and DNS service discovery (RFC 6762, RFC 6763).
+package mdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// hostTTL is the TTL of records bound to the host, e.g. its addresses
+	hostTTL = 120
+
+	// serviceTTL is the TTL of the other records
+	serviceTTL = 4500
+
+	// unicastResponse is the bit of the question class requesting a unicast
+	// response
+	unicastResponse = 1 << 15
+)
+
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Service is an instance of a DNS-SD service.
+type Service struct {
+	// Instance is the user visible name (e.g. Bridge on mbp)
+	Instance string
+
+	// Service is the type with protocol (e.g. _bridge._tcp)
+	Service string
+
+	// Host is the name of the host without domain, defaults to the hostname
+	Host string
+	Port int
+
+	// Text holds key=value pairs of the TXT record
+	Text []string
+}
+
+type responder struct {
+	conn *ipv4.PacketConn
+
+	service  dnsmessage.Name
+	instance dnsmessage.Name
+	host     dnsmessage.Name
+	meta     dnsmessage.Name
+
+	port int
+	text []string
+}
+
+// Advertise announces a service and answers queries for it on all multicast
+// interfaces until ctx is done, when the service is withdrawn.
+func Advertise(ctx context.Context, s Service) error {
+	if s.Host == "" {
+		hostname, err := os.Hostname()
+
+		if err != nil {
+			return err
+		}
+
+		s.Host, _, _ = strings.Cut(hostname, ".")
+	}
+
+	if s.Service == "" || s.Instance == "" || s.Port == 0 {
+		return errors.New("service, instance and port are required")
+	}
+
+	service, err := dnsmessage.NewName(s.Service + ".local.")
+
+	if err != nil {
+		return err
+	}
+
+	// dots would split the instance into several labels
+	instance, err := dnsmessage.NewName(strings.ReplaceAll(s.Instance, ".", "-") + "." + s.Service + ".local.")
+
+	if err != nil {
+		return err
+	}
+
+	host, err := dnsmessage.NewName(s.Host + ".local.")
+
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+
+	if err != nil {
+		return err
+	}
+
+	r := &responder{
+		conn: ipv4.NewPacketConn(conn),
+
+		service:  service,
+		instance: instance,
+		host:     host,
+		meta:     dnsmessage.MustNewName("_services._dns-sd._udp.local."),
+
+		port: s.Port,
+		text: s.Text,
+	}
+
+	interfaces, err := multicastInterfaces()
+
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	for _, ifi := range interfaces {
+		// interfaces without IPv4 fail to join
+		r.conn.JoinGroup(&ifi, group)
+	}
+
+	r.conn.SetControlMessage(ipv4.FlagInterface, true)
+
+	// ListenMulticastUDP disables the loopback, which hides the service from
+	// browsers on the same host
+	r.conn.SetMulticastLoopback(true)
+
+	go func() {
+		<-ctx.Done()
+
+		r.announce(interfaces, 0)
+		conn.Close()
+	}()
+
+	go func() {
+		// announcements are sent twice, a second apart
+		for range 2 {
+			r.announce(interfaces, serviceTTL)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+
+	return r.serve()
+}
+
+func (r *responder) serve() error {
+	buf := make([]byte, 9000)
+
+	for {
+		n, cm, src, err := r.conn.ReadFrom(buf)
+
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+
+		var p dnsmessage.Parser
+
+		header, err := p.Start(buf[:n])
+
+		if err != nil || header.Response {
+			continue
+		}
+
+		questions, err := p.AllQuestions()
+
+		if err != nil {
+			continue
+		}
+
+		var ifi *net.Interface
+
+		if cm != nil {
+			ifi, _ = net.InterfaceByIndex(cm.IfIndex)
+		}
+
+		addr, ok := src.(*net.UDPAddr)
+
+		if !ok {
+			continue
+		}
+
+		// legacy resolvers query from other ports and expect a unicast
+		// response with the id and questions
+		legacy := addr.Port != group.Port
+
+		var answers []dnsmessage.Resource
+		var unicast bool
+
+		for _, q := range questions {
+			matched := r.answer(q, ifi)
+
+			if len(matched) == 0 {
+				continue
+			}
+
+			answers = append(answers, matched...)
+
+			if q.Class&unicastResponse != 0 {
+				unicast = true
+			}
+		}
+
+		if len(answers) == 0 {
+			continue
+		}
+
+		response := dnsmessage.Header{
+			Response:      true,
+			Authoritative: true,
+		}
+
+		var echo []dnsmessage.Question
+
+		if legacy {
+			response.ID = header.ID
+
+			for _, q := range questions {
+				q.Class &^= unicastResponse
+				echo = append(echo, q)
+			}
+		}
+
+		msg, err := r.message(response, echo, answers, r.additionals(answers, ifi), !legacy)
+
+		if err != nil {
+			continue
+		}
+
+		var dst net.Addr = group
+
+		if legacy || unicast {
+			dst = addr
+		}
+
+		var out *ipv4.ControlMessage
+
+		if ifi != nil {
+			out = &ipv4.ControlMessage{IfIndex: ifi.Index}
+		}
+
+		r.conn.WriteTo(msg, out, dst)
+	}
+}
+
+// answer returns the records matching a question.
+func (r *responder) answer(q dnsmessage.Question, ifi *net.Interface) []dnsmessage.Resource {
+	name := strings.ToLower(q.Name.String())
+
+	matches := func(n dnsmessage.Name, t dnsmessage.Type) bool {
+		return name == strings.ToLower(n.String()) && (q.Type == t || q.Type == dnsmessage.TypeALL)
+	}
+
+	var result []dnsmessage.Resource
+
+	if matches(r.meta, dnsmessage.TypePTR) {
+		result = append(result, r.metaRecord(serviceTTL))
+	}
+
+	if matches(r.service, dnsmessage.TypePTR) {
+		result = append(result, r.pointer(serviceTTL))
+	}
+
+	if matches(r.instance, dnsmessage.TypeSRV) {
+		result = append(result, r.srv(hostTTL))
+	}
+
+	if matches(r.instance, dnsmessage.TypeTXT) {
+		result = append(result, r.txt(serviceTTL))
+	}
+
+	if matches(r.host, dnsmessage.TypeA) {
+		result = append(result, r.addresses(ifi, hostTTL)...)
+	}
+
+	return result
+}
+
+// additionals returns the records a client needs next, which saves it
+// further queries.
+func (r *responder) additionals(answers []dnsmessage.Resource, ifi *net.Interface) []dnsmessage.Resource {
+	var result []dnsmessage.Resource
+
+	has := func(t dnsmessage.Type) bool {
+		for _, a := range answers {
+			if a.Header.Type == t && a.Header.Name != r.meta {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if has(dnsmessage.TypePTR) {
+		result = append(result, r.srv(hostTTL), r.txt(serviceTTL))
+	}
+
+	if has(dnsmessage.TypePTR) || has(dnsmessage.TypeSRV) {
+		result = append(result, r.addresses(ifi, hostTTL)...)
+	}
+
+	return result
+}
+
+// announce sends all records to all interfaces, a TTL of 0 withdraws them.
+func (r *responder) announce(interfaces []net.Interface, ttl uint32) {
+	header := dnsmessage.Header{
+		Response:      true,
+		Authoritative: true,
+	}
+
+	hostTTL := min(ttl, hostTTL)
+
+	for _, ifi := range interfaces {
+		answers := []dnsmessage.Resource{
+			r.metaRecord(ttl),
+			r.pointer(ttl),
+			r.srv(hostTTL),
+			r.txt(ttl),
+		}
+
+		answers = append(answers, r.addresses(&ifi, hostTTL)...)
+
+		msg, err := r.message(header, nil, answers, nil, true)
+
+		if err != nil {
+			continue
+		}
+
+		r.conn.WriteTo(msg, &ipv4.ControlMessage{IfIndex: ifi.Index}, group)
+	}
+}
+
+func (r *responder) message(header dnsmessage.Header, questions []dnsmessage.Question, answers, additionals []dnsmessage.Resource, flush bool) ([]byte, error) {
+	msg := dnsmessage.Message{
+		Header:    header,
+		Questions: questions,
+
+		Answers:     answers,
+		Additionals: additionals,
+	}
+
+	if flush {
+		// unique records replace cached ones, shared PTR records do not
+		for _, list := range [][]dnsmessage.Resource{msg.Answers, msg.Additionals} {
+			for i := range list {
+				if list[i].Header.Type != dnsmessage.TypePTR {
+					list[i].Header.Class |= unicastResponse
+				}
+			}
+		}
+	}
+
+	return msg.Pack()
+}
+
+func (r *responder) metaRecord(ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: r.meta, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   &dnsmessage.PTRResource{PTR: r.service},
+	}
+}
+
+func (r *responder) pointer(ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: r.service, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   &dnsmessage.PTRResource{PTR: r.instance},
+	}
+}
+
+func (r *responder) srv(ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: r.instance, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   &dnsmessage.SRVResource{Target: r.host, Port: uint16(r.port)},
+	}
+}
+
+func (r *responder) txt(ttl uint32) dnsmessage.Resource {
+	text := r.text
+
+	// a TXT record holds at least one string
+	if len(text) == 0 {
+		text = []string{""}
+	}
+
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: r.instance, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   &dnsmessage.TXTResource{TXT: text},
+	}
+}
+
+// addresses returns the A records of an interface, or of all interfaces if
+// the interface of a query is unknown.
+func (r *responder) addresses(ifi *net.Interface, ttl uint32) []dnsmessage.Resource {
+	var interfaces []net.Interface
+
+	if ifi != nil {
+		interfaces = []net.Interface{*ifi}
+	} else {
+		interfaces, _ = multicastInterfaces()
+	}
+
+	var result []dnsmessage.Resource
+
+	for _, ifi := range interfaces {
+		addrs, _ := ifi.Addrs()
+
+		for _, addr := range addrs {
+			ip, ok := addr.(*net.IPNet)
+
+			if !ok || ip.IP.To4() == nil || ip.IP.IsLoopback() {
+				continue
+			}
+
+			var a [4]byte
+			copy(a[:], ip.IP.To4())
+
+			result = append(result, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: r.host, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: ttl},
+				Body:   &dnsmessage.AResource{A: a},
+			})
+		}
+	}
+
+	return result
+}
+
+// multicastInterfaces returns the interfaces that are up and support
+// multicast, except loopback.
+func multicastInterfaces() ([]net.Interface, error) {
+	interfaces, err := net.Interfaces()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result []net.Interface
+
+	for _, ifi := range interfaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		result = append(result, ifi)
+	}
+
+	return result, nil
+}
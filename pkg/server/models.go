@@ -1,5 +1,7 @@
 package server
 
+import "time"
+
 type Config struct {
 	AI *AIConfig `json:"ai,omitempty"`
 
@@ -17,11 +19,2273 @@ type DockerConfig struct {
 }
 
 type KubernetesConfig struct {
-	Contexts []string `json:"contexts,omitempty"`
+	Contexts []string `json:"contexts"`
 
 	DefaultContext   string `json:"defaultContext,omitempty"`
 	DefaultNamespace string `json:"defaultNamespace,omitempty"`
 
 	TenancyLabels      []string `json:"tenancyLabels,omitempty"`
 	PlatformNamespaces []string `json:"platformNamespaces,omitempty"`
+
+	ProtectedNamespaces []string `json:"protectedNamespaces,omitempty"`
+	ReadOnlyNamespaces  []string `json:"readOnlyNamespaces,omitempty"`
+
+	// NonProductionContexts are patterns of the contexts allowing chaos
+	// experiments
+	NonProductionContexts []string `json:"nonProductionContexts,omitempty"`
+
+	// Impersonation is set if the caller may send Impersonate-User and
+	// Impersonate-Group headers
+	Impersonation bool `json:"impersonation,omitempty"`
+
+	// Features of the reachable contexts by name, once detected (see the
+	// features events)
+	Features map[string]*ContextFeatures `json:"features,omitempty"`
+
+	// Origins of the contexts running in another context by name
+	Origins map[string]*ContextOrigin `json:"origins,omitempty"`
+
+	// Upstreams of the contexts federated from other bridges by name
+	Upstreams map[string]*ContextUpstream `json:"upstreams,omitempty"`
+
+	// Teleport logins of the contexts authenticated by tsh by name, once
+	// checked (see the teleport events)
+	Teleport map[string]*TeleportSession `json:"teleport,omitempty"`
+}
+
+// TeleportSession is the tsh login a context is authenticated with.
+type TeleportSession struct {
+	Proxy       string `json:"proxy,omitempty"`
+	Cluster     string `json:"cluster,omitempty"`
+	KubeCluster string `json:"kubeCluster,omitempty"`
+
+	User string `json:"user,omitempty"`
+
+	// LoggedIn is set until the login expires at ValidUntil
+	LoggedIn   bool       `json:"loggedIn"`
+	ValidUntil *time.Time `json:"validUntil,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// ContextOrigin is the object of a host context a context runs in.
+type ContextOrigin struct {
+	// Kind is vcluster or capi
+	Kind string `json:"kind"`
+
+	Context   string `json:"context"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// ContextUpstream is the bridge a federated context is served by.
+type ContextUpstream struct {
+	Upstream string `json:"upstream"`
+
+	// Context is the name of the context at the upstream
+	Context string `json:"context"`
+}
+
+// ContextFeatures are detected at runtime for the caller. Exec and ReadOnly
+// are checked cluster-wide and in the default namespace of the context.
+type ContextFeatures struct {
+	// Metrics is set if the metrics API (metrics-server) is available
+	Metrics    bool `json:"metrics"`
+	Prometheus bool `json:"prometheus"`
+	Loki       bool `json:"loki"`
+
+	// Ingress is the type of the default ingress controller
+	Ingress     string `json:"ingress,omitempty"`
+	CertManager bool   `json:"certManager"`
+	Argo        bool   `json:"argo"`
+	Mesh        string `json:"mesh,omitempty"`
+	GPU         bool   `json:"gpu"`
+	Batch       bool   `json:"batch"`
+	ClusterAPI  bool   `json:"clusterAPI"`
+
+	// Helm is set if the helm CLI is installed for releases installs
+	Helm bool `json:"helm"`
+
+	// Talos is set if the context is configured for Talos node management
+	// and the talosctl CLI is installed
+	Talos bool `json:"talos"`
+
+	Exec     bool `json:"exec"`
+	ReadOnly bool `json:"readOnly"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// ContextCapabilities are the platform components detected in a context.
+type ContextCapabilities struct {
+	Context string `json:"context"`
+
+	Ingress []IngressController `json:"ingress"`
+
+	CertManager bool `json:"certManager"`
+
+	// Argo lists the installed Argo projects: cd, rollouts, workflows, events
+	Argo []string `json:"argo"`
+
+	// Mesh is the service mesh: istio, linkerd, consul or kuma
+	Mesh string `json:"mesh,omitempty"`
+
+	// GPU lists the vendors of installed GPU operators
+	GPU []string `json:"gpu,omitempty"`
+
+	// Batch lists the installed batch schedulers: kueue, volcano
+	Batch []string `json:"batch,omitempty"`
+
+	// ClusterAPI is set on Cluster API management clusters
+	ClusterAPI bool `json:"clusterAPI"`
+
+	Metrics bool `json:"metrics"`
+
+	Prometheus *ServiceRef `json:"prometheus,omitempty"`
+	Loki       *ServiceRef `json:"loki,omitempty"`
+	ArgoCD     *ServiceRef `json:"argocd,omitempty"`
+
+	Probed time.Time `json:"probed"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type IngressController struct {
+	Class      string `json:"class"`
+	Controller string `json:"controller"`
+
+	// Type of well-known controllers, e.g. ingress-nginx or traefik
+	Type string `json:"type,omitempty"`
+
+	Default bool `json:"default,omitempty"`
+}
+
+// ServiceRef references a service port reachable through the API server proxy.
+type ServiceRef struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+
+	Scheme string `json:"scheme"`
+	Port   int32  `json:"port"`
+}
+
+type ContextInfo struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+type ContextRequest struct {
+	Name string `json:"name,omitempty"`
+
+	// TTL after which the context is removed again, e.g. "2h"
+	TTL string `json:"ttl,omitempty"`
+
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+
+	Server                   string `json:"server,omitempty"`
+	Token                    string `json:"token,omitempty"`
+	CertificateAuthorityData []byte `json:"certificateAuthorityData,omitempty"`
+	InsecureSkipTLSVerify    bool   `json:"insecureSkipTLSVerify,omitempty"`
+
+	Cloud *CloudClusterRequest `json:"cloud,omitempty"`
+}
+
+type CloudClusterRequest struct {
+	Provider string `json:"provider"`
+	Cluster  string `json:"cluster"`
+
+	Region        string `json:"region,omitempty"`
+	Project       string `json:"project,omitempty"`
+	ResourceGroup string `json:"resourceGroup,omitempty"`
+}
+
+type PinInfo struct {
+	Context string `json:"context"`
+	Pinned  bool   `json:"pinned"`
+}
+
+type TransportStats struct {
+	Context string `json:"context"`
+
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"lastUsed"`
+
+	Requests    int64 `json:"requests"`
+	InFlight    int64 `json:"inFlight"`
+	Failures    int64 `json:"failures"`
+	Connections int64 `json:"connections"`
+}
+
+type SessionInfo struct {
+	ID string `json:"id"`
+
+	Kind    string `json:"kind"`
+	Context string `json:"context"`
+	Path    string `json:"path"`
+	Owner   string `json:"owner,omitempty"`
+
+	Started      time.Time `json:"started"`
+	LastActivity time.Time `json:"lastActivity"`
+
+	Bytes int64 `json:"bytes"`
+}
+
+type Transcript struct {
+	ID string `json:"id"`
+
+	Kind    string `json:"kind"`
+	Context string `json:"context"`
+	Path    string `json:"path"`
+	Owner   string `json:"owner,omitempty"`
+
+	Started time.Time  `json:"started"`
+	Ended   *time.Time `json:"ended,omitempty"`
+
+	// Truncated is set if the oldest lines were dropped
+	Truncated bool `json:"truncated,omitempty"`
+
+	Lines []TranscriptLine `json:"lines,omitempty"`
+}
+
+type TranscriptLine struct {
+	Time time.Time `json:"time"`
+
+	// Stream is stdout, stderr or error (the exit status of an exec)
+	Stream string `json:"stream"`
+	Text   string `json:"text"`
+}
+
+type AllowedNamespaces struct {
+	Namespaces []string `json:"namespaces"`
+	Recent     []string `json:"recent,omitempty"`
+
+	// Probed is set if namespaces could not be listed and were probed instead
+	Probed bool `json:"probed,omitempty"`
+}
+
+type ResourceCatalog struct {
+	Groups []ResourceGroup `json:"groups"`
+
+	// Failed lists group versions whose discovery failed (e.g. unavailable aggregated APIs)
+	Failed []string `json:"failed,omitempty"`
+
+	Fetched time.Time `json:"fetched"`
+}
+
+type ResourceGroup struct {
+	Name string `json:"name"`
+
+	PreferredVersion string   `json:"preferredVersion"`
+	Versions         []string `json:"versions,omitempty"`
+
+	Resources []ResourceInfo `json:"resources"`
+}
+
+type ResourceInfo struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Version string `json:"version"`
+
+	Namespaced bool `json:"namespaced"`
+
+	Verbs        []string `json:"verbs,omitempty"`
+	ShortNames   []string `json:"shortNames,omitempty"`
+	Categories   []string `json:"categories,omitempty"`
+	Subresources []string `json:"subresources,omitempty"`
+
+	// CRD is the name of the CustomResourceDefinition defining the resource
+	CRD string `json:"crd,omitempty"`
+}
+
+type BulkMetadataRequest struct {
+	Group    string `json:"group,omitempty"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+
+	Namespace string `json:"namespace,omitempty"`
+
+	LabelSelector string   `json:"labelSelector,omitempty"`
+	FieldSelector string   `json:"fieldSelector,omitempty"`
+	Names         []string `json:"names,omitempty"`
+
+	Labels      MetadataChanges `json:"labels"`
+	Annotations MetadataChanges `json:"annotations"`
+
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+type MetadataChanges struct {
+	Add    map[string]string `json:"add,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+func (c MetadataChanges) empty() bool {
+	return len(c.Add) == 0 && len(c.Remove) == 0
+}
+
+type BulkMetadataResult struct {
+	DryRun bool `json:"dryRun,omitempty"`
+
+	Results []BulkMetadataItem `json:"results"`
+}
+
+type BulkMetadataItem struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+
+	Patch    []jsonPatchOp `json:"patch,omitempty"`
+	Rollback []jsonPatchOp `json:"rollback,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+
+	Context string `json:"context"`
+	Owner   string `json:"owner,omitempty"`
+	Action  string `json:"action"`
+
+	Resource  string `json:"resource,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+
+	Patch    any `json:"patch,omitempty"`
+	Rollback any `json:"rollback,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type ConfirmationRequest struct {
+	Namespace string `json:"namespace"`
+}
+
+type ConfirmationInfo struct {
+	Token   string    `json:"token"`
+	Header  string    `json:"header"`
+	Expires time.Time `json:"expires"`
+}
+
+type TrashEntry struct {
+	ID string `json:"id"`
+
+	Context string `json:"context"`
+	Owner   string `json:"owner,omitempty"`
+
+	Group    string `json:"group,omitempty"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+
+	Deleted time.Time `json:"deleted"`
+
+	Object map[string]any `json:"object,omitempty"`
+}
+
+type RBACComparison struct {
+	Contexts    []RBACContext    `json:"contexts"`
+	Differences []RBACDifference `json:"differences"`
+}
+
+type RBACContext struct {
+	Context string `json:"context"`
+
+	Bindings    []string `json:"bindings"`
+	Permissions []string `json:"permissions"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type RBACDifference struct {
+	Permission string `json:"permission"`
+
+	Present []string `json:"present"`
+	Missing []string `json:"missing"`
+}
+
+type ServiceAccountInfo struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+
+	Created time.Time `json:"created"`
+
+	AutomountToken bool `json:"automountToken"`
+
+	Bindings         []string `json:"bindings"`
+	Permissions      []string `json:"permissions"`
+	PermissionsError string   `json:"permissionsError,omitempty"`
+
+	Pods   []ServiceAccountPod   `json:"pods"`
+	Tokens []ServiceAccountToken `json:"tokens"`
+}
+
+type ServiceAccountPod struct {
+	Name  string `json:"name"`
+	Phase string `json:"phase"`
+
+	// Mounted is set if the pod has a service account token mounted
+	Mounted bool `json:"mounted"`
+
+	TokenExpirationSeconds int64 `json:"tokenExpirationSeconds,omitempty"`
+}
+
+type ServiceAccountToken struct {
+	Secret string `json:"secret"`
+
+	Created time.Time `json:"created"`
+	Age     string    `json:"age"`
+}
+
+type ServiceAccountTokenInfo struct {
+	Token string `json:"token"`
+
+	Expires time.Time `json:"expires"`
+}
+
+type TrafficTestRequest struct {
+	URL    string `json:"url"`
+	Method string `json:"method,omitempty"`
+
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Namespace and Image of the in-cluster debug pod
+	Namespace string `json:"namespace,omitempty"`
+	Image     string `json:"image,omitempty"`
+
+	SkipCluster bool `json:"skipCluster,omitempty"`
+}
+
+type TrafficTestResult struct {
+	Local   *TrafficProbe `json:"local"`
+	Cluster *TrafficProbe `json:"cluster,omitempty"`
+
+	Discrepancies []string `json:"discrepancies,omitempty"`
+}
+
+type TrafficProbe struct {
+	Status   int    `json:"status,omitempty"`
+	Location string `json:"location,omitempty"`
+	Duration string `json:"duration,omitempty"`
+
+	Addresses  []string `json:"addresses,omitempty"`
+	RemoteAddr string   `json:"remoteAddr,omitempty"`
+
+	TLS *TrafficTLS `json:"tls,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type TrafficTLS struct {
+	Verified bool   `json:"verified"`
+	Version  string `json:"version,omitempty"`
+
+	Subject  string     `json:"subject,omitempty"`
+	Issuer   string     `json:"issuer,omitempty"`
+	DNSNames []string   `json:"dnsNames,omitempty"`
+	NotAfter *time.Time `json:"notAfter,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type ConfigData struct {
+	ResourceVersion string `json:"resourceVersion"`
+
+	Data map[string]string `json:"data"`
+
+	// Binary lists keys with binary values, which are not editable
+	Binary []string `json:"binary"`
+
+	Consumers []ConfigConsumer `json:"consumers"`
+}
+
+type ConfigConsumer struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+
+	References []string `json:"references"`
+}
+
+type ConfigUpdateRequest struct {
+	// ResourceVersion guards against overwriting concurrent changes
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+
+	Data map[string]string `json:"data"`
+
+	// Remove lists binary keys to remove
+	Remove []string `json:"remove,omitempty"`
+
+	// Restart triggers a rollout restart of all consumers after saving
+	Restart bool `json:"restart,omitempty"`
+
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+type ConfigUpdateResult struct {
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+
+	Restarted []string `json:"restarted,omitempty"`
+	Problems  []string `json:"problems,omitempty"`
+}
+
+type ObjectConditions struct {
+	Generation         int64 `json:"generation,omitempty"`
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	Conditions []ObjectCondition `json:"conditions"`
+
+	Timeline []TimelineEntry `json:"timeline,omitempty"`
+}
+
+type ObjectCondition struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	ObservedGeneration int64     `json:"observedGeneration,omitempty"`
+	LastTransitionTime time.Time `json:"lastTransitionTime"`
+}
+
+type TimelineEntry struct {
+	Time time.Time `json:"time"`
+
+	// Source is condition or event, and for pods also pod, container or
+	// probe
+	Source string `json:"source"`
+
+	// Container the entry is about, if any
+	Container string `json:"container,omitempty"`
+
+	Type    string `json:"type,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	// Count of repeated events
+	Count int32 `json:"count,omitempty"`
+}
+
+type ConditionWaitResult struct {
+	Met bool `json:"met"`
+
+	Condition string `json:"condition"`
+	Status    string `json:"status"`
+
+	Conditions []ObjectCondition `json:"conditions"`
+}
+
+type DriftResult struct {
+	// Source of the desired state, either helm or argocd
+	Source string `json:"source"`
+	Owner  string `json:"owner"`
+
+	Revision string `json:"revision,omitempty"`
+
+	Drift []DriftEntry `json:"drift"`
+}
+
+type DriftEntry struct {
+	Path string `json:"path"`
+
+	Desired any `json:"desired,omitempty"`
+	Live    any `json:"live,omitempty"`
+}
+
+type BootstrapStack struct {
+	Name        string `json:"name"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+
+	Charts []BootstrapChart `json:"charts"`
+}
+
+type BootstrapChart struct {
+	Release   string `json:"release"`
+	Namespace string `json:"namespace"`
+
+	Repository string `json:"repository"`
+	Chart      string `json:"chart"`
+	Version    string `json:"version"`
+}
+
+type BootstrapCheck struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+type BootstrapProgress struct {
+	Release string `json:"release"`
+
+	// Status is installing, installed or failed
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type BootstrapRelease struct {
+	Release   string `json:"release"`
+	Namespace string `json:"namespace"`
+	Chart     string `json:"chart"`
+	Version   string `json:"version"`
+
+	Installed bool   `json:"installed"`
+	Error     string `json:"error,omitempty"`
+}
+
+type BootstrapResult struct {
+	Stack string `json:"stack"`
+
+	Checks   []BootstrapCheck   `json:"checks"`
+	Releases []BootstrapRelease `json:"releases"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type HelmRepository struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type HelmInstallRequest struct {
+	Release   string `json:"release"`
+	Namespace string `json:"namespace,omitempty"`
+
+	// Repository is the name of a configured repository, unless Chart is an
+	// oci:// reference
+	Repository string `json:"repository,omitempty"`
+	Chart      string `json:"chart"`
+	Version    string `json:"version,omitempty"`
+
+	Values map[string]any `json:"values,omitempty"`
+
+	CreateNamespace bool `json:"createNamespace,omitempty"`
+
+	// Wait for the resources to become ready (default true) within the
+	// Timeout (default 10m)
+	Wait    *bool  `json:"wait,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+}
+
+type HelmProgress struct {
+	Release string `json:"release"`
+
+	// Phase is hook, resource or helm for other output of the install
+	Phase   string `json:"phase"`
+	Message string `json:"message"`
+}
+
+type HelmInstallResult struct {
+	Release   string `json:"release"`
+	Namespace string `json:"namespace"`
+
+	Chart      string `json:"chart,omitempty"`
+	Version    string `json:"version,omitempty"`
+	AppVersion string `json:"appVersion,omitempty"`
+
+	Revision int    `json:"revision,omitempty"`
+	Status   string `json:"status,omitempty"`
+	Notes    string `json:"notes,omitempty"`
+
+	// Manifest is the rendered manifest of a dry run
+	Manifest string `json:"manifest,omitempty"`
+
+	Upgrade bool `json:"upgrade"`
+	DryRun  bool `json:"dryRun,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type RegistryStatus struct {
+	Container string `json:"container"`
+	Image     string `json:"image"`
+
+	// Endpoint to push images to from the host
+	Endpoint string `json:"endpoint"`
+
+	// ClusterEndpoint the nodes pull images from
+	ClusterEndpoint string `json:"clusterEndpoint"`
+
+	Provider string   `json:"provider"`
+	Cluster  string   `json:"cluster"`
+	Nodes    []string `json:"nodes"`
+
+	Exists    bool `json:"exists"`
+	Running   bool `json:"running"`
+	Connected bool `json:"connected"`
+
+	RestartRequired bool `json:"restartRequired,omitempty"`
+}
+
+type RegistryPushRequest struct {
+	Image string `json:"image"`
+}
+
+type RegistryProgress struct {
+	ID       string `json:"id,omitempty"`
+	Status   string `json:"status"`
+	Progress string `json:"progress,omitempty"`
+}
+
+type RegistryPushResult struct {
+	Image string `json:"image"`
+
+	// Reference to use in pod specs
+	Reference string `json:"reference"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type InterceptRequest struct {
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+
+	// Port is the name or number of the service port, optional for single
+	// port services
+	Port string `json:"port,omitempty"`
+
+	// LocalAddress receives the intercepted traffic (e.g. 127.0.0.1:8080)
+	LocalAddress string `json:"localAddress"`
+}
+
+type InterceptInfo struct {
+	ID string `json:"id"`
+
+	Context   string `json:"context"`
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	Pod       string `json:"pod"`
+
+	Port         int    `json:"port"`
+	LocalAddress string `json:"localAddress"`
+
+	Connections int64     `json:"connections"`
+	Created     time.Time `json:"created"`
+}
+
+type PortForwardRequest struct {
+	Namespace string `json:"namespace"`
+
+	// Pod or Service to forward to; services forward to one of their ready
+	// pods
+	Pod     string `json:"pod,omitempty"`
+	Service string `json:"service,omitempty"`
+
+	// Port is the name or number of the container or service port, optional
+	// for single port services
+	Port string `json:"port,omitempty"`
+
+	// LocalPort on the bridge host, a free port is picked if 0
+	LocalPort int `json:"localPort,omitempty"`
+}
+
+type PortForwardInfo struct {
+	ID string `json:"id"`
+
+	// Status is active, or waiting, conflict or error for saved
+	// port-forwards that are not running
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	// Saved port-forwards are restored on the next start
+	Saved bool `json:"saved"`
+
+	Context   string `json:"context"`
+	Namespace string `json:"namespace"`
+	Service   string `json:"service,omitempty"`
+	Pod       string `json:"pod"`
+
+	// Port of the pod the local port forwards to
+	Port         int    `json:"port"`
+	LocalAddress string `json:"localAddress"`
+
+	// URL opens the forwarded port in a browser
+	URL string `json:"url"`
+
+	Connections int64     `json:"connections"`
+	Created     time.Time `json:"created"`
+}
+
+// TunnelInfo is the cloud tunnel of a context.
+type TunnelInfo struct {
+	// Kind is kubernetes or docker
+	Kind    string `json:"kind"`
+	Context string `json:"context"`
+
+	// Provider is ssm or iap
+	Provider string `json:"provider"`
+	Target   string `json:"target"`
+	Remote   string `json:"remote,omitempty"`
+
+	LocalAddress string `json:"localAddress,omitempty"`
+
+	Running bool       `json:"running"`
+	Started *time.Time `json:"started,omitempty"`
+}
+
+type HostMetrics struct {
+	Context string `json:"context"`
+
+	// Source is ssh if host usage was read from the remote host, otherwise docker
+	Source string `json:"source"`
+
+	OperatingSystem string `json:"operatingSystem,omitempty"`
+	KernelVersion   string `json:"kernelVersion,omitempty"`
+	DockerVersion   string `json:"dockerVersion,omitempty"`
+
+	CPUs     int       `json:"cpus"`
+	CPUUsage float64   `json:"cpuUsage,omitempty"`
+	Load     []float64 `json:"load,omitempty"`
+
+	MemoryTotal     int64  `json:"memoryTotal"`
+	MemoryAvailable *int64 `json:"memoryAvailable,omitempty"`
+
+	DiskPath  string `json:"diskPath,omitempty"`
+	DiskTotal int64  `json:"diskTotal,omitempty"`
+	DiskUsed  int64  `json:"diskUsed,omitempty"`
+
+	DockerDisk *DockerDiskUsage `json:"dockerDisk,omitempty"`
+
+	Containers        int `json:"containers"`
+	ContainersRunning int `json:"containersRunning"`
+	Images            int `json:"images"`
+
+	Errors []string `json:"errors,omitempty"`
+}
+
+type DockerDiskUsage struct {
+	Images     int64 `json:"images"`
+	Containers int64 `json:"containers"`
+	Volumes    int64 `json:"volumes"`
+	BuildCache int64 `json:"buildCache"`
+}
+
+type AnalysisIssue struct {
+	Rule string `json:"rule"`
+
+	// Severity is error, warning or info
+	Severity string `json:"severity"`
+
+	Line    int    `json:"line,omitempty"`
+	Object  string `json:"object,omitempty"`
+	Message string `json:"message"`
+}
+
+type DockerfileAnalysisRequest struct {
+	Dockerfile string `json:"dockerfile"`
+
+	// AI requests fix suggestions from the configured AI provider
+	AI bool `json:"ai,omitempty"`
+}
+
+type DockerfileAnalysis struct {
+	Issues []AnalysisIssue `json:"issues"`
+
+	Suggestion string `json:"suggestion,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type ManifestAnalysis struct {
+	Objects int `json:"objects"`
+
+	Issues []AnalysisIssue `json:"issues"`
+}
+
+type SearchQuery struct {
+	Query string `json:"q"`
+	Regex bool   `json:"regex,omitempty"`
+
+	Contexts  []string `json:"contexts"`
+	Namespace string   `json:"namespace,omitempty"`
+
+	// Fields to match: name, labels, annotations, images or health
+	Fields []string `json:"fields,omitempty"`
+}
+
+type SearchResult struct {
+	Items []SearchItem `json:"items"`
+	Total int          `json:"total"`
+
+	// Truncated is set if more objects matched than the limit
+	Truncated bool `json:"truncated,omitempty"`
+
+	Errors []string `json:"errors"`
+}
+
+type SearchItem struct {
+	Context string `json:"context"`
+
+	Group    string `json:"group,omitempty"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+	Kind     string `json:"kind"`
+
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+
+	Matches []SearchMatch `json:"matches"`
+}
+
+type SearchMatch struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+type MonitorRequest struct {
+	Name  string      `json:"name"`
+	Query SearchQuery `json:"query"`
+
+	// Interval between evaluations, defaults to 5m
+	Interval string `json:"interval,omitempty"`
+
+	// Threshold of matches the monitor alerts above
+	Threshold int `json:"threshold"`
+}
+
+type Monitor struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner,omitempty"`
+
+	Name  string      `json:"name"`
+	Query SearchQuery `json:"query"`
+
+	Interval  string `json:"interval"`
+	Threshold int    `json:"threshold"`
+
+	Created time.Time `json:"created"`
+
+	History []MonitorResult `json:"history"`
+}
+
+type MonitorResult struct {
+	Time time.Time `json:"time"`
+
+	Total    int  `json:"total"`
+	Alerting bool `json:"alerting"`
+
+	// Items of the latest result
+	Items []SearchItem `json:"items,omitempty"`
+
+	Errors []string `json:"errors,omitempty"`
+}
+
+type InventoryReport struct {
+	Generated time.Time `json:"generated"`
+
+	Clusters []InventoryCluster `json:"clusters"`
+}
+
+type InventoryCluster struct {
+	Context string `json:"context"`
+
+	Version  string `json:"version,omitempty"`
+	Platform string `json:"platform,omitempty"`
+
+	Nodes           int      `json:"nodes"`
+	NodesReady      int      `json:"nodesReady"`
+	KubeletVersions []string `json:"kubeletVersions,omitempty"`
+
+	Namespaces int            `json:"namespaces"`
+	Workloads  map[string]int `json:"workloads"`
+
+	Images       []InventoryImage       `json:"images"`
+	Certificates []InventoryCertificate `json:"certificates"`
+
+	Findings []InventoryFinding `json:"findings"`
+
+	// Issues are the findings of severity error
+	Issues []AnalysisIssue `json:"issues"`
+
+	Errors []string `json:"errors"`
+}
+
+type InventoryImage struct {
+	Image string `json:"image"`
+	Pods  int    `json:"pods"`
+}
+
+type InventoryCertificate struct {
+	// Source is cert-manager or ingress
+	Source string `json:"source"`
+
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Secret    string `json:"secret"`
+
+	DNSNames []string   `json:"dnsNames,omitempty"`
+	NotAfter *time.Time `json:"notAfter,omitempty"`
+
+	// Ready of cert-manager certificates
+	Ready *bool `json:"ready,omitempty"`
+}
+
+type InventoryFinding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Count    int    `json:"count"`
+}
+
+type FitResult struct {
+	Fits bool `json:"fits"`
+
+	// Blocking is the first constraint the manifest does not fit
+	Blocking *FitConstraint `json:"blocking,omitempty"`
+
+	Constraints []FitConstraint `json:"constraints"`
+
+	Errors []string `json:"errors"`
+}
+
+type FitConstraint struct {
+	// Kind is ResourceQuota, LimitRange or Node
+	Kind string `json:"kind"`
+	Name string `json:"name,omitempty"`
+
+	Resource string `json:"resource,omitempty"`
+	Object   string `json:"object,omitempty"`
+
+	Fits    bool   `json:"fits"`
+	Message string `json:"message"`
+}
+
+type UserInfo struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+type AggregateList struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	// Items carry the name of their context in a context field
+	Items []map[string]any `json:"items"`
+
+	Contexts []AggregateContext `json:"contexts"`
+}
+
+type AggregateContext struct {
+	Context string `json:"context"`
+	Items   int    `json:"items"`
+
+	// Continue is set if the list of the context was truncated by ?limit
+	Continue string `json:"continue,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type ContextConnectivity struct {
+	Context string `json:"context"`
+
+	Offline bool      `json:"offline"`
+	Since   time.Time `json:"since"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type CacheStats struct {
+	Context  string `json:"context"`
+	Resource string `json:"resource"`
+
+	Objects int  `json:"objects"`
+	Synced  bool `json:"synced"`
+
+	Updated  time.Time `json:"updated"`
+	LastUsed time.Time `json:"lastUsed"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// TopResources are CPU in millicores and memory in bytes.
+type TopResources struct {
+	CPU    int64 `json:"cpu"`
+	Memory int64 `json:"memory"`
+}
+
+type TopPodList struct {
+	Items []TopPod `json:"items"`
+}
+
+type TopPod struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Node      string `json:"node,omitempty"`
+
+	Timestamp time.Time `json:"timestamp"`
+	Window    string    `json:"window,omitempty"`
+
+	Usage    TopResources `json:"usage"`
+	Requests TopResources `json:"requests"`
+	Limits   TopResources `json:"limits"`
+
+	Containers []TopContainer `json:"containers"`
+}
+
+type TopContainer struct {
+	Name string `json:"name"`
+
+	Usage    TopResources `json:"usage"`
+	Requests TopResources `json:"requests"`
+	Limits   TopResources `json:"limits"`
+}
+
+type TopNodeList struct {
+	Items []TopNode `json:"items"`
+}
+
+type TopNode struct {
+	Name string `json:"name"`
+
+	Timestamp time.Time `json:"timestamp"`
+	Window    string    `json:"window,omitempty"`
+
+	Usage    TopResources `json:"usage"`
+	Requests TopResources `json:"requests"`
+	Limits   TopResources `json:"limits"`
+
+	Allocatable TopResources `json:"allocatable"`
+	Capacity    TopResources `json:"capacity"`
+
+	Pods        int   `json:"pods"`
+	PodCapacity int64 `json:"podCapacity"`
+}
+
+// DeviceReport shows the extended resources of device plugins, e.g. GPUs.
+type DeviceReport struct {
+	Resources []DeviceResource `json:"resources"`
+	Nodes     []DeviceNode     `json:"nodes"`
+	Pods      []DevicePod      `json:"pods"`
+}
+
+// DeviceResource sums an extended resource over the cluster. Pending are
+// the devices requested by pods the scheduler could not place.
+type DeviceResource struct {
+	Name string `json:"name"`
+
+	Nodes int `json:"nodes"`
+
+	Capacity    int64 `json:"capacity"`
+	Allocatable int64 `json:"allocatable"`
+	Allocated   int64 `json:"allocated"`
+	Pending     int64 `json:"pending"`
+}
+
+type DeviceNode struct {
+	Name string `json:"name"`
+
+	// Product of the devices, e.g. the GPU model
+	Product string `json:"product,omitempty"`
+
+	Unschedulable bool `json:"unschedulable,omitempty"`
+
+	Devices []DeviceAllocation `json:"devices"`
+}
+
+type DeviceAllocation struct {
+	Resource string `json:"resource"`
+
+	Capacity    int64 `json:"capacity"`
+	Allocatable int64 `json:"allocatable"`
+	Allocated   int64 `json:"allocated"`
+}
+
+type DevicePod struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+
+	// Owner is the controller of the pod, e.g. Job/train
+	Owner string `json:"owner,omitempty"`
+
+	Node  string `json:"node,omitempty"`
+	Phase string `json:"phase"`
+
+	Requests map[string]int64 `json:"requests"`
+
+	// Blocked is set if the pod is unschedulable for lack of devices
+	Blocked bool   `json:"blocked,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// DiffResult previews a server-side apply of a manifest.
+type DiffResult struct {
+	Objects []DiffObject `json:"objects"`
+}
+
+type DiffObject struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name,omitempty"`
+
+	// Line of the object in the manifest
+	Line int `json:"line"`
+
+	// Action is create, update, unchanged, conflict or error
+	Action string `json:"action"`
+
+	Changes []DiffChange `json:"changes,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type DiffChange struct {
+	Path string `json:"path"`
+
+	// Op is add, remove or replace
+	Op string `json:"op"`
+
+	Before any `json:"before,omitempty"`
+	After  any `json:"after,omitempty"`
+}
+
+// BatchReport shows the queues and workloads of batch schedulers.
+type BatchReport struct {
+	// Schedulers lists the installed batch schedulers: kueue, volcano
+	Schedulers []string `json:"schedulers"`
+
+	Queues      []BatchQueue    `json:"queues"`
+	Workloads   []BatchWorkload `json:"workloads"`
+	Preemptions []BatchEvent    `json:"preemptions"`
+}
+
+type BatchQueue struct {
+	Scheduler string `json:"scheduler"`
+
+	// Kind is ClusterQueue or LocalQueue (Kueue) or Queue (Volcano)
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+
+	// Parent is the cohort or cluster queue of Kueue, or the parent queue of Volcano
+	Parent string `json:"parent,omitempty"`
+
+	State   string `json:"state,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	Pending  int64 `json:"pending"`
+	Admitted int64 `json:"admitted"`
+
+	Resources []BatchQueueResource `json:"resources,omitempty"`
+}
+
+type BatchQueueResource struct {
+	Flavor   string `json:"flavor,omitempty"`
+	Resource string `json:"resource"`
+
+	Quota string `json:"quota,omitempty"`
+	Used  string `json:"used,omitempty"`
+}
+
+type BatchWorkload struct {
+	Scheduler string `json:"scheduler"`
+
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+
+	// Owner is the controller of the workload, e.g. Job/train
+	Owner string `json:"owner,omitempty"`
+
+	Queue        string `json:"queue,omitempty"`
+	ClusterQueue string `json:"clusterQueue,omitempty"`
+
+	Priority int64 `json:"priority,omitempty"`
+
+	// Status is pending, admitted, evicted or finished
+	Status string `json:"status"`
+
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	Created time.Time `json:"created"`
+}
+
+type BatchEvent struct {
+	Time time.Time `json:"time"`
+
+	Namespace string `json:"namespace,omitempty"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	Count   int32  `json:"count,omitempty"`
+}
+
+// ApplyResult reports the objects of a server-side apply.
+type ApplyResult struct {
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Failed counts the objects that could not be applied
+	Failed int `json:"failed"`
+
+	Objects []ApplyObject `json:"objects"`
+}
+
+type ApplyObject struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name,omitempty"`
+
+	// Line of the object in the manifest
+	Line int `json:"line"`
+
+	// Action is created, configured, unchanged, conflict or error
+	Action string `json:"action"`
+
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type ScaleRequest struct {
+	Replicas int64 `json:"replicas"`
+
+	// Force scales objects targeted by an autoscaler
+	Force bool `json:"force,omitempty"`
+}
+
+type CAPIClusterList struct {
+	Items []CAPICluster `json:"items"`
+}
+
+// CAPICluster is a workload cluster of a Cluster API management cluster.
+type CAPICluster struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+
+	// Phase is Pending, Provisioning, Provisioned, Deleting or Failed
+	Phase   string `json:"phase,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// ControlPlane and Infrastructure are the kinds of the providers, e.g. KubeadmControlPlane
+	ControlPlane   string `json:"controlPlane,omitempty"`
+	Infrastructure string `json:"infrastructure,omitempty"`
+
+	Ready               bool `json:"ready"`
+	ControlPlaneReady   bool `json:"controlPlaneReady"`
+	InfrastructureReady bool `json:"infrastructureReady"`
+
+	Message string `json:"message,omitempty"`
+
+	// Context is the bridge context the cluster is registered as
+	Context string `json:"context,omitempty"`
+
+	MachineDeployments []CAPIMachineDeployment `json:"machineDeployments"`
+
+	Created time.Time `json:"created"`
+}
+
+type CAPIMachineDeployment struct {
+	Name    string `json:"name"`
+	Phase   string `json:"phase,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	Replicas            int64 `json:"replicas"`
+	ReadyReplicas       int64 `json:"readyReplicas"`
+	UpdatedReplicas     int64 `json:"updatedReplicas"`
+	UnavailableReplicas int64 `json:"unavailableReplicas"`
+}
+
+type PermissionRequest struct {
+	Checks []PermissionCheck `json:"checks"`
+}
+
+type PermissionCheck struct {
+	// Key of the check in the result, derived from the attributes if empty
+	Key string `json:"key,omitempty"`
+
+	Verb        string `json:"verb"`
+	Group       string `json:"group,omitempty"`
+	Resource    string `json:"resource"`
+	Subresource string `json:"subresource,omitempty"`
+
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+type PermissionResult struct {
+	Permissions map[string]bool `json:"permissions"`
+
+	// Errors of failed reviews by key, which are reported as denied
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+type VClusterList struct {
+	Items []VCluster `json:"items"`
+}
+
+// VCluster is a virtual cluster running in a host context.
+type VCluster struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+
+	// Workload of the control plane, e.g. StatefulSet/my-vcluster
+	Workload string `json:"workload"`
+	Chart    string `json:"chart,omitempty"`
+
+	Ready         bool  `json:"ready"`
+	Replicas      int64 `json:"replicas"`
+	ReadyReplicas int64 `json:"readyReplicas"`
+
+	// Context is the bridge context the vcluster is registered as
+	Context string `json:"context,omitempty"`
+}
+
+// TalosMachineConfig is the machine config of a Talos node with secrets
+// redacted.
+type TalosMachineConfig struct {
+	Node    string `json:"node"`
+	Address string `json:"address"`
+
+	Documents []map[string]any `json:"documents"`
+}
+
+type TalosRebootRequest struct {
+	// Mode is default or powercycle
+	Mode string `json:"mode,omitempty"`
+}
+
+type TalosUpgradeRequest struct {
+	// Image is the installer image, e.g. ghcr.io/siderolabs/installer:v1.8.0
+	Image string `json:"image"`
+
+	// Stage the upgrade to be performed after the next reboot
+	Stage bool `json:"stage,omitempty"`
+
+	// Force the upgrade, skipping the etcd health check
+	Force bool `json:"force,omitempty"`
+}
+
+// TalosAction is a reboot or upgrade started on a Talos node.
+type TalosAction struct {
+	Node    string `json:"node"`
+	Address string `json:"address"`
+
+	// Action is reboot or upgrade
+	Action string `json:"action"`
+	Output string `json:"output,omitempty"`
+}
+
+type PodFileList struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+
+	Path string `json:"path"`
+
+	Items []PodFile `json:"items"`
+}
+
+type PodFile struct {
+	Name string `json:"name"`
+
+	// Type is file, dir, symlink or other
+	Type string `json:"type"`
+
+	Size int64 `json:"size"`
+
+	// Mode holds the octal permission bits
+	Mode string `json:"mode"`
+
+	Modified time.Time `json:"modified"`
+}
+
+type NodeShellRequest struct {
+	// Namespace of the debug pod, defaults to the current namespace
+	Namespace string `json:"namespace,omitempty"`
+
+	// Image of the debug pod, it needs nsenter and sh
+	Image string `json:"image,omitempty"`
+}
+
+type NodeShellInfo struct {
+	ID string `json:"id"`
+
+	Context   string `json:"context"`
+	Node      string `json:"node"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+
+	// URL of the WebSocket terminal, it takes ?cols=&rows=
+	URL string `json:"url"`
+
+	Created time.Time `json:"created"`
+}
+
+type UpstreamInfo struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// Contexts federated from the upstream by their local name
+	Contexts []string `json:"contexts"`
+
+	Synced time.Time `json:"synced"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Problem is an error response as problem details (RFC 9457). Code is the
+// problem code of the X-Bridge-Error header, Message the ID of a localized
+// message.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type ChaosRequest struct {
+	// Duration of the experiment (e.g. 10m), defaults to 5 minutes
+	Duration string `json:"duration,omitempty"`
+
+	// Latency and Jitter of latency experiments (e.g. 200ms)
+	Latency string `json:"latency,omitempty"`
+	Jitter  string `json:"jitter,omitempty"`
+
+	// GracePeriodSeconds of killed pods
+	GracePeriodSeconds *int64 `json:"gracePeriodSeconds,omitempty"`
+}
+
+// ChaosExperiment is a failure injected into a non-production context.
+type ChaosExperiment struct {
+	ID string `json:"id"`
+
+	Context string `json:"context"`
+
+	// Kind is kill-pod, latency or cordon
+	Kind string `json:"kind"`
+
+	Namespace string `json:"namespace,omitempty"`
+
+	// Target is the deployment or node of the experiment
+	Target string   `json:"target"`
+	Pods   []string `json:"pods,omitempty"`
+
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// ContextsEvent is sent if contexts were added or removed, e.g. after the
+// kubeconfig or the docker contexts changed on disk.
+type ContextsEvent struct {
+	// Type is kubernetes or docker
+	Type string `json:"type"`
+
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+
+	// Contexts and CurrentContext are the state after the change
+	Contexts       []string `json:"contexts"`
+	CurrentContext string   `json:"currentContext,omitempty"`
+
+	Time time.Time `json:"time"`
+}
+
+// FeaturesEvent is sent once the features of a context were detected in
+// the background, as the config leaves out contexts not detected yet.
+type FeaturesEvent struct {
+	Context  string           `json:"context"`
+	Features *ContextFeatures `json:"features"`
+
+	Time time.Time `json:"time"`
+}
+
+// TeleportEvent is sent once the Teleport sessions of the contexts were
+// checked in the background.
+type TeleportEvent struct {
+	Sessions map[string]*TeleportSession `json:"sessions"`
+
+	Time time.Time `json:"time"`
+}
+
+type CloneRequest struct {
+	// Name and Namespace of the copy, the name defaults to <name>-copy and
+	// the namespace to the one of the original
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+
+	// Env sets environment variables of the containers (or of Container
+	// only), null values remove them
+	Env       map[string]*string `json:"env,omitempty"`
+	Container string             `json:"container,omitempty"`
+
+	// Images replaces the images of containers by name
+	Images map[string]string `json:"images,omitempty"`
+
+	Replicas *int64 `json:"replicas,omitempty"`
+
+	// Create creates the copy rather than returning it
+	Create bool `json:"create,omitempty"`
+}
+
+type CanaryRequest struct {
+	// Image of the canary, replacing the one of Container (which can be
+	// omitted for deployments with a single container)
+	Image     string `json:"image"`
+	Container string `json:"container,omitempty"`
+
+	// Weight is the percentage of traffic shifted to the canary, 10 by default
+	Weight int `json:"weight,omitempty"`
+
+	// Route is an HTTPRoute shifting traffic of Service by weight, without
+	// it the services of the deployment select the pods of the canary too
+	Route   string `json:"route,omitempty"`
+	Service string `json:"service,omitempty"`
+
+	// Duration of the analysis (5m by default) and the Interval of its
+	// checks (30s by default)
+	Duration string `json:"duration,omitempty"`
+	Interval string `json:"interval,omitempty"`
+
+	// MaxRestarts of the canary pods before it is rolled back
+	MaxRestarts int64 `json:"maxRestarts,omitempty"`
+
+	// ErrorQuery is a PromQL query for the error rate of the canary, where
+	// $canary and $namespace are replaced by its name and namespace
+	ErrorQuery   string  `json:"errorQuery,omitempty"`
+	MaxErrorRate float64 `json:"maxErrorRate,omitempty"`
+}
+
+// CanaryStep is a step of a canary, streamed as it happens.
+type CanaryStep struct {
+	Time time.Time `json:"time"`
+
+	// Phase is created, ready, traffic, check, promoting, promoted, failed,
+	// rollback or rolledback
+	Phase   string `json:"phase"`
+	Message string `json:"message"`
+
+	Replicas  int64    `json:"replicas,omitempty"`
+	Restarts  int64    `json:"restarts,omitempty"`
+	ErrorRate *float64 `json:"errorRate,omitempty"`
+}
+
+type CanaryResult struct {
+	Deployment string `json:"deployment"`
+	Canary     string `json:"canary"`
+
+	Container string `json:"container"`
+	Image     string `json:"image"`
+	Weight    int    `json:"weight"`
+
+	Promoted bool `json:"promoted"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// MaintenanceRequest scales the workloads of a namespace to zero and
+// optionally serves its ingresses by a static maintenance page.
+type MaintenanceRequest struct {
+	// Selector limits the workloads scaled to zero by a label selector
+	Selector string `json:"selector,omitempty"`
+
+	Page    bool   `json:"page,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+type MaintenanceStatus struct {
+	Namespace string `json:"namespace"`
+	Active    bool   `json:"active"`
+
+	Workloads []MaintenanceWorkload `json:"workloads"`
+	Ingresses []string              `json:"ingresses"`
+
+	Page   bool `json:"page"`
+	DryRun bool `json:"dryRun,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// MaintenanceWorkload is a workload scaled to zero and the replicas it is
+// restored to.
+type MaintenanceWorkload struct {
+	Resource string `json:"resource"`
+	Name     string `json:"name"`
+	Replicas int64  `json:"replicas"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// ImagePromotionRequest promotes the workloads running an image in the
+// source context to it in the target context.
+type ImagePromotionRequest struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+
+	// Image is the reference running in the source, with a tag or digest
+	Image string `json:"image"`
+
+	// Namespace limits the workloads of the source to a namespace, which
+	// is mapped to TargetNamespace if set
+	Namespace       string `json:"namespace,omitempty"`
+	TargetNamespace string `json:"targetNamespace,omitempty"`
+
+	// Apply updates the workloads instead of previewing the updates
+	Apply bool `json:"apply,omitempty"`
+}
+
+type ImagePromotion struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Image  string `json:"image"`
+
+	Applied bool `json:"applied"`
+
+	Workloads []ImagePromotionWorkload `json:"workloads"`
+}
+
+type ImagePromotionWorkload struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+
+	// Containers running the image in the source
+	Containers []string `json:"containers"`
+
+	// Action is update, unchanged, missing, conflict or error
+	Action string `json:"action"`
+
+	Changes []DiffChange `json:"changes,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// PodContainers breaks a pod down into its containers, in the order they
+// are started.
+type PodContainers struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Phase     string `json:"phase"`
+
+	// InitCompleted of InitTotal init containers succeeded (sidecars are
+	// not counted)
+	InitCompleted int `json:"initCompleted"`
+	InitTotal     int `json:"initTotal"`
+
+	Containers []PodContainer `json:"containers"`
+}
+
+type PodContainer struct {
+	Name string `json:"name"`
+
+	// Type is init, sidecar (a native sidecar), app or ephemeral
+	Type string `json:"type"`
+
+	// Order of init containers and sidecars, starting at 1
+	Order int `json:"order,omitempty"`
+
+	// Target is the container an ephemeral container targets
+	Target string `json:"target,omitempty"`
+
+	Image   string `json:"image"`
+	ImageID string `json:"imageID,omitempty"`
+
+	// State is pending, waiting, running or terminated
+	State   string `json:"state"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	ExitCode *int32 `json:"exitCode,omitempty"`
+
+	Ready   bool  `json:"ready"`
+	Started *bool `json:"started,omitempty"`
+
+	RestartCount int32 `json:"restartCount"`
+
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+
+	LastTermination *PodContainerTermination `json:"lastTermination,omitempty"`
+
+	// Blocking marks the init container or sidecar the pod waits for
+	Blocking bool `json:"blocking,omitempty"`
+
+	// Failing containers crashed, failed to start or exited with an error
+	Failing bool `json:"failing,omitempty"`
+
+	// Logs and PreviousLogs are the paths of the logs of the container and
+	// of its previous instance
+	Logs         string `json:"logs"`
+	PreviousLogs string `json:"previousLogs,omitempty"`
+
+	// Tail holds the last lines of the logs of failing containers
+	Tail string `json:"tail,omitempty"`
+}
+
+type PodContainerTermination struct {
+	Reason   string `json:"reason,omitempty"`
+	Message  string `json:"message,omitempty"`
+	ExitCode int32  `json:"exitCode"`
+
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// RolloutRequest selects the revision an undo rolls back to, the previous
+// one if unset.
+type RolloutRequest struct {
+	Revision int64 `json:"revision,omitempty"`
+}
+
+// RolloutStatus is the progress of the rollout of a workload, like kubectl
+// rollout status, and its revision history.
+type RolloutStatus struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+
+	Generation         int64 `json:"generation"`
+	ObservedGeneration int64 `json:"observedGeneration"`
+
+	// Desired, current, updated, ready and available pods
+	Desired   int32 `json:"desired"`
+	Replicas  int32 `json:"replicas"`
+	Updated   int32 `json:"updated"`
+	Ready     int32 `json:"ready"`
+	Available int32 `json:"available"`
+
+	Paused bool `json:"paused,omitempty"`
+	Done   bool `json:"done"`
+	Failed bool `json:"failed,omitempty"`
+
+	Message string `json:"message"`
+
+	Revision int64             `json:"revision,omitempty"`
+	History  []RolloutRevision `json:"history"`
+}
+
+type RolloutRevision struct {
+	Revision int64 `json:"revision"`
+
+	// Name of the replica set or controller revision
+	Name string `json:"name"`
+
+	ChangeCause string   `json:"changeCause,omitempty"`
+	Images      []string `json:"images,omitempty"`
+
+	Created time.Time `json:"created"`
+
+	// Replicas of the replica set of a deployment revision
+	Replicas int32 `json:"replicas,omitempty"`
+
+	Current bool `json:"current,omitempty"`
+}
+
+// PodTimeline is the lifecycle of a pod in chronological order.
+type PodTimeline struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Phase     string `json:"phase"`
+
+	// Startup is the time from the creation of the pod until it last became
+	// ready
+	Startup string `json:"startup,omitempty"`
+
+	Timeline []TimelineEntry `json:"timeline"`
+}
+
+type ScaleResult struct {
+	Replicas int64 `json:"replicas"`
+	Previous int64 `json:"previous"`
+
+	Scaled bool `json:"scaled"`
+
+	// Autoscaler targets the object and reverts changes of its replicas
+	Autoscaler *ScaleAutoscaler `json:"autoscaler,omitempty"`
+	Warning    string           `json:"warning,omitempty"`
+}
+
+// ScaleAutoscaler is a HorizontalPodAutoscaler targeting a scaled object.
+type ScaleAutoscaler struct {
+	Name string `json:"name"`
+
+	MinReplicas int32 `json:"minReplicas"`
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	CurrentReplicas int32 `json:"currentReplicas"`
+	DesiredReplicas int32 `json:"desiredReplicas"`
+}
+
+type NodeCordon struct {
+	Node          string `json:"node"`
+	Unschedulable bool   `json:"unschedulable"`
+
+	// Changed is false if the node already was in the state
+	Changed bool `json:"changed"`
+}
+
+// DrainRequest drains a node. Unmanaged pods and pods with emptyDir volumes
+// block the drain unless allowed.
+type DrainRequest struct {
+	// Force evicts pods not managed by a controller, which are lost
+	Force bool `json:"force,omitempty"`
+
+	// DeleteEmptyDirData evicts pods with emptyDir volumes, whose data is
+	// lost
+	DeleteEmptyDirData bool `json:"deleteEmptyDirData,omitempty"`
+
+	// GracePeriodSeconds overrides the termination grace period of the pods
+	GracePeriodSeconds *int64 `json:"gracePeriodSeconds,omitempty"`
+
+	// Timeout bounds the drain, e.g. 10m (default 5m)
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// DrainStep is a step of a drain, streamed as it happens.
+type DrainStep struct {
+	Time time.Time `json:"time,omitzero"`
+
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+
+	// Phase is cordoned, skipped, throttled, evicting, blocked, evicted,
+	// deleted or failed
+	Phase   string `json:"phase"`
+	Message string `json:"message,omitempty"`
+}
+
+type DrainResult struct {
+	Node string `json:"node"`
+
+	Cordoned bool `json:"cordoned"`
+
+	Evicted int `json:"evicted"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+
+	// Blockers are the pods preventing the drain
+	Blockers []DrainStep `json:"blockers,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type NodeHealthReport struct {
+	Nodes []NodeHealth `json:"nodes"`
+
+	// Problems is the number of nodes with problems
+	Problems int `json:"problems"`
+
+	// KubeletVersions and RuntimeVersions count the nodes per version, to
+	// spot skew
+	KubeletVersions map[string]int `json:"kubeletVersions"`
+	RuntimeVersions map[string]int `json:"runtimeVersions"`
+}
+
+type NodeHealth struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+
+	Ready         bool `json:"ready"`
+	Unschedulable bool `json:"unschedulable"`
+
+	KubeletVersion   string `json:"kubeletVersion"`
+	ContainerRuntime string `json:"containerRuntime"`
+	KernelVersion    string `json:"kernelVersion"`
+	OSImage          string `json:"osImage"`
+
+	Conditions []ObjectCondition `json:"conditions"`
+
+	// Pressure lists the active MemoryPressure, DiskPressure and
+	// PIDPressure conditions
+	Pressure []string `json:"pressure"`
+
+	// Problems are the unhealthy conditions with their messages
+	Problems []string `json:"problems"`
+
+	// Taints are formatted as key=value:Effect
+	Taints []string `json:"taints"`
+
+	// Warnings is the number of warning events of the node
+	Warnings int `json:"warnings"`
+
+	// Timeline is the history of the condition transitions and events
+	Timeline []TimelineEntry `json:"timeline"`
+}
+
+type ControlPlaneHealth struct {
+	Version string `json:"version,omitempty"`
+
+	// Distribution is kubeadm, k3s or rke2, if detected
+	Distribution string `json:"distribution,omitempty"`
+
+	// Managed is set if neither control plane pods nor nodes are visible
+	Managed bool `json:"managed"`
+
+	// Checks are the readiness checks of the API server
+	Checks []ControlPlaneCheck `json:"checks"`
+
+	Components   []ControlPlaneComponent   `json:"components"`
+	Members      []EtcdMember              `json:"members"`
+	Certificates []ControlPlaneCertificate `json:"certificates"`
+
+	Issues []AnalysisIssue `json:"issues"`
+	Errors []string        `json:"errors"`
+}
+
+type ControlPlaneCheck struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+
+	Message string `json:"message,omitempty"`
+}
+
+// ControlPlaneComponent is a control plane pod, or a control plane node if
+// the control plane runs outside of pods.
+type ControlPlaneComponent struct {
+	Component string `json:"component"`
+
+	Pod  string `json:"pod,omitempty"`
+	Node string `json:"node"`
+
+	Version string `json:"version,omitempty"`
+	Ready   bool   `json:"ready"`
+
+	Restarts    int32      `json:"restarts,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	LastRestart *time.Time `json:"lastRestart,omitempty"`
+}
+
+type EtcdMember struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Endpoint string `json:"endpoint,omitempty"`
+
+	Healthy bool   `json:"healthy"`
+	Leader  bool   `json:"leader"`
+	Learner bool   `json:"learner,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	DBSize      int64 `json:"dbSize,omitempty"`
+	DBSizeInUse int64 `json:"dbSizeInUse,omitempty"`
+
+	Took   string   `json:"took,omitempty"`
+	Error  string   `json:"error,omitempty"`
+	Alarms []string `json:"alarms,omitempty"`
+}
+
+type ControlPlaneCertificate struct {
+	// Source is apiserver, etcd, k3s-serving, kubeconfig-ca or
+	// kubeconfig-client
+	Source string `json:"source"`
+
+	Subject  string   `json:"subject"`
+	Issuer   string   `json:"issuer"`
+	DNSNames []string `json:"dnsNames,omitempty"`
+
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+
+	// Days until the certificate expires
+	Days    int  `json:"days"`
+	Expired bool `json:"expired"`
+}
+
+type NamespaceSummary struct {
+	Namespace string `json:"namespace"`
+	Phase     string `json:"phase"`
+
+	// ReadOnly and Protected namespaces block changes or require a
+	// confirmation token at the bridge
+	ReadOnly  bool `json:"readOnly,omitempty"`
+	Protected bool `json:"protected,omitempty"`
+
+	Quotas      []NamespaceQuota `json:"quotas"`
+	LimitRanges []NamespaceLimit `json:"limitRanges"`
+
+	// Usage is the live usage of the pods, if metrics are available
+	Usage *TopResources `json:"usage,omitempty"`
+
+	// Requests and Limits are summed over the pods not completed
+	Requests TopResources `json:"requests"`
+	Limits   TopResources `json:"limits"`
+
+	// Pods counts the pods per phase
+	Pods map[string]int `json:"pods"`
+
+	// Objects counts the objects per kind
+	Objects map[string]int `json:"objects"`
+
+	Errors []string `json:"errors"`
+}
+
+type NamespaceQuota struct {
+	Name      string                   `json:"name"`
+	Resources []NamespaceQuotaResource `json:"resources"`
+}
+
+type NamespaceQuotaResource struct {
+	Resource string `json:"resource"`
+
+	Hard string `json:"hard"`
+	Used string `json:"used"`
+
+	// Percent of the hard limit used
+	Percent *float64 `json:"percent,omitempty"`
+}
+
+// NamespaceLimit is a resource constrained by a LimitRange.
+type NamespaceLimit struct {
+	LimitRange string `json:"limitRange"`
+
+	// Type is Container, Pod or PersistentVolumeClaim
+	Type     string `json:"type"`
+	Resource string `json:"resource"`
+
+	Min            string `json:"min,omitempty"`
+	Max            string `json:"max,omitempty"`
+	Default        string `json:"default,omitempty"`
+	DefaultRequest string `json:"defaultRequest,omitempty"`
+
+	MaxLimitRequestRatio string `json:"maxLimitRequestRatio,omitempty"`
+}
+
+// ExplorerRequest describes a request of the Kubernetes API by resource and
+// verb.
+type ExplorerRequest struct {
+	Group       string `json:"group,omitempty"`
+	Version     string `json:"version"`
+	Resource    string `json:"resource"`
+	Subresource string `json:"subresource,omitempty"`
+
+	// Verb is get, list, watch, create, update, patch, delete or
+	// deletecollection
+	Verb string `json:"verb"`
+
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+
+	Query map[string]string `json:"query,omitempty"`
+
+	// Body and ContentType are sent if executed
+	Body        any    `json:"body,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+
+	// Execute sends the request through the proxy
+	Execute bool `json:"execute,omitempty"`
+}
+
+type ExplorerResult struct {
+	Method string `json:"method"`
+
+	// Path is the path of the API server, URL the path at the bridge
+	Path string `json:"path"`
+	URL  string `json:"url"`
+
+	Kind       string   `json:"kind"`
+	Namespaced bool     `json:"namespaced"`
+	Verbs      []string `json:"verbs"`
+
+	ContentType string              `json:"contentType,omitempty"`
+	Parameters  []ExplorerParameter `json:"parameters"`
+
+	// Example is a body with the fields required by the schema
+	Example any `json:"example,omitempty"`
+
+	Curl    string `json:"curl"`
+	Kubectl string `json:"kubectl,omitempty"`
+
+	Response *ExplorerResponse `json:"response,omitempty"`
+}
+
+type ExplorerParameter struct {
+	Name string `json:"name"`
+
+	// In is query or path
+	In   string `json:"in"`
+	Type string `json:"type,omitempty"`
+
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+type ExplorerResponse struct {
+	Status      int    `json:"status"`
+	Duration    string `json:"duration"`
+	ContentType string `json:"contentType,omitempty"`
+
+	// Body is set for JSON responses, Text for others
+	Body any    `json:"body,omitempty"`
+	Text string `json:"text,omitempty"`
+
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// WebhookTestRequest sends a test payload to a webhook of an admission
+// webhook configuration, or to a service.
+type WebhookTestRequest struct {
+	// Kind is validating or mutating, Configuration and Webhook name the
+	// webhook of a configuration; Webhook may be omitted if it is the only
+	// one
+	Kind          string `json:"kind,omitempty"`
+	Configuration string `json:"configuration,omitempty"`
+	Webhook       string `json:"webhook,omitempty"`
+
+	// Namespace, Service, Port and Path address a service instead
+	Namespace string `json:"namespace,omitempty"`
+	Service   string `json:"service,omitempty"`
+	Scheme    string `json:"scheme,omitempty"`
+	Port      int    `json:"port,omitempty"`
+	Path      string `json:"path,omitempty"`
+
+	// Object is sent in an AdmissionReview, OldObject for updates;
+	// Operation defaults to CREATE, or UPDATE with an old object
+	Object    map[string]any `json:"object,omitempty"`
+	OldObject map[string]any `json:"oldObject,omitempty"`
+	Operation string         `json:"operation,omitempty"`
+
+	// Body is sent as it is instead, strings unencoded
+	Body any `json:"body,omitempty"`
+
+	Method      string            `json:"method,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+type WebhookTestResult struct {
+	Method string `json:"method"`
+
+	// Path is the path of the service proxy of the API server
+	Path string `json:"path"`
+
+	// Request is the payload sent
+	Request any `json:"request"`
+
+	Status      int    `json:"status,omitempty"`
+	Duration    string `json:"duration"`
+	ContentType string `json:"contentType,omitempty"`
+
+	// Body is set for JSON responses, Text for others
+	Body any    `json:"body,omitempty"`
+	Text string `json:"text,omitempty"`
+
+	Truncated bool `json:"truncated,omitempty"`
+
+	Review *WebhookReview `json:"review,omitempty"`
+
+	// Error is set if the webhook could not be reached
+	Error string `json:"error,omitempty"`
+}
+
+// WebhookReview is the response to an AdmissionReview.
+type WebhookReview struct {
+	Allowed bool `json:"allowed"`
+
+	Code    int32  `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Patch is the JSON patch of a mutating webhook
+	Patch any `json:"patch,omitempty"`
+
+	// Problems of the response the API server would reject
+	Problems []string `json:"problems"`
 }
@@ -10,15 +10,62 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/adrianliechti/bridge"
+	"github.com/adrianliechti/bridge/pkg/auth"
 	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/logging"
 )
 
 type Server struct {
 	config *config.Config
 
+	mu sync.RWMutex
+
+	pins map[string]bool
+
+	resourcesMu sync.Mutex
+	resources   map[string]map[io.Closer]struct{}
+
+	transports transportPool
+	informers  informers
+	sessions   sessionManager
+	namespaces namespaceHistory
+	catalogs   resourceCatalogs
+	schemas    openAPISchemas
+	audit      auditLog
+
+	disruptions   disruptionGuard
+	confirmations confirmations
+
+	trash        trash
+	transcripts  transcripts
+	intercepts   intercepts
+	nodeShells   nodeShells
+	chaos        chaosExperiments
+	portForwards portForwards
+	teleport     teleportProfiles
+	tunnels      cloudTunnels
+	upstreams    upstreams
+
+	serviceProxies serviceProxies
+	printers       printers
+	search         searchIndex
+	monitors       monitors
+
+	connectivity  connectivity
+	contextEvents contextEvents
+	configEvents  configEvents
+	stale         staleCache
+	features      contextFeatures
+	capabilities  capabilities
+
+	done      chan struct{}
+	closeOnce sync.Once
+
 	http.Handler
 }
 
@@ -29,36 +76,73 @@ type Context struct {
 }
 
 func New(cfg *config.Config) (*Server, error) {
-	contexts := make(map[string]*Context)
+	mux := http.NewServeMux()
 
-	if cfg.Docker != nil {
-		for _, c := range cfg.Docker.Contexts {
-			contexts[c.Name] = &Context{
-				Type: "docker",
-				Name: c.Name,
-			}
-		}
+	s := &Server{
+		config:  cfg,
+		Handler: ProblemMiddleware(RecoverMiddleware(BearerTokenMiddleware(ImpersonationMiddleware(cfg, mux)))),
+
+		done: make(chan struct{}),
 	}
 
-	if cfg.Kubernetes != nil {
-		for _, c := range cfg.Kubernetes.Contexts {
-			contexts[c.Name] = &Context{
-				Type: "kubernetes",
-				Name: c.Name,
-			}
+	if cfg.Auth != nil {
+		provider, err := auth.New(cfg.Auth)
+
+		if err != nil {
+			return nil, err
 		}
-	}
 
-	mux := http.NewServeMux()
+		s.Handler = ProblemMiddleware(RecoverMiddleware(AuthMiddleware(provider, ImpersonationMiddleware(cfg, mux))))
 
-	s := &Server{
-		config:  cfg,
-		Handler: BearerTokenMiddleware(mux),
+		if p, ok := provider.(auth.LoginProvider); ok {
+			mux.HandleFunc("GET /auth/login", p.Login)
+			mux.HandleFunc("GET /auth/callback", p.Callback)
+		}
+
+		mux.HandleFunc("GET /auth/me", s.handleCurrentUser)
+		mux.HandleFunc("POST /auth/logout", s.handleLogout)
+	}
+
+	s.trash.retention = cfg.Retention.Snapshots
+	s.transcripts.retention = cfg.Retention.Transcripts
+
+	s.stale.maxSize = cfg.Limits.MaxStaleCacheSize
+
+	s.loadPins()
+	s.portForwards.loadSaved()
+	s.intercepts.loadSaved()
+
+	go s.expireContexts(s.done)
+	go s.keepAlive(s.done)
+	go s.transports.reap(s.done)
+	go s.sessions.reap(cfg.Limits.SessionIdleTimeout, s.done)
+	go s.runMonitors(s.done)
+	go s.pruneData(s.done)
+	go s.probeConnectivity(s.done)
+	go s.watchSystem(s.done)
+	go s.probeCapabilities(s.done)
+	go s.restorePortForwards(s.done)
+	go s.restoreIntercepts(s.done)
+	go s.restoreChaosCordons(s.done)
+	go s.syncUpstreams(s.done)
+	go s.refreshCatalogs(s.done)
+	go s.watchConfig(s.done)
+
+	if cfg.Cache != nil {
+		go s.informers.reap(s.done, cfg.Cache.IdleDuration)
 	}
 
 	mux.HandleFunc("GET /config.json", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
+		// served from the caches, missing ones are detected in the
+		// background and sent to the config events
+		features := s.cachedFeatures(AuthInfoFromContext(r.Context()))
+		teleport := s.cachedTeleportSessions()
+
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+
 		config := &Config{}
 
 		if cfg.OpenAI != nil {
@@ -79,33 +163,270 @@ func New(cfg *config.Config) (*Server, error) {
 
 		if cfg.Kubernetes != nil {
 			config.Kubernetes = &KubernetesConfig{
+				Contexts: []string{},
+
 				DefaultContext:   cfg.Kubernetes.CurrentContext,
 				DefaultNamespace: cfg.Kubernetes.CurrentNamespace,
 
 				TenancyLabels:      cfg.Kubernetes.TenancyLabels,
 				PlatformNamespaces: cfg.Kubernetes.PlatformNamespaces,
+
+				ProtectedNamespaces: cfg.ProtectedNamespaces,
+				ReadOnlyNamespaces:  cfg.ReadOnlyNamespaces,
+
+				NonProductionContexts: cfg.NonProductionContexts,
+
+				Impersonation: cfg.ImpersonationAllowed(AuthInfoFromContext(r.Context())),
+
+				Features: features,
+				Teleport: teleport,
 			}
 
 			for _, c := range cfg.Kubernetes.Contexts {
 				config.Kubernetes.Contexts = append(config.Kubernetes.Contexts, c.Name)
+
+				if c.Origin != nil {
+					if config.Kubernetes.Origins == nil {
+						config.Kubernetes.Origins = map[string]*ContextOrigin{}
+					}
+
+					config.Kubernetes.Origins[c.Name] = &ContextOrigin{
+						Kind: c.Origin.Kind,
+
+						Context:   c.Origin.Context,
+						Namespace: c.Origin.Namespace,
+						Name:      c.Origin.Name,
+					}
+				}
+
+				if c.Upstream != "" {
+					if config.Kubernetes.Upstreams == nil {
+						config.Kubernetes.Upstreams = map[string]*ContextUpstream{}
+					}
+
+					config.Kubernetes.Upstreams[c.Name] = &ContextUpstream{
+						Upstream: c.Upstream,
+						Context:  strings.TrimSuffix(c.Name, "@"+c.Upstream),
+					}
+				}
 			}
 		}
 
 		json.NewEncoder(w).Encode(config)
 	})
 
+	mux.HandleFunc("POST /config/reload", func(w http.ResponseWriter, r *http.Request) {
+		if err := s.ReloadKubernetes(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := s.ReloadDocker(); err != nil {
+			log.Printf("unable to reload docker contexts: %v", err)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /config/events", s.handleContextEvents)
+
+	mux.HandleFunc("POST /contexts", s.handleCreateContext)
+	mux.HandleFunc("DELETE /contexts/{context}", s.handleDeleteContext)
+
+	mux.HandleFunc("PUT /contexts/{context}/pin", s.handleSetPinned(true))
+	mux.HandleFunc("DELETE /contexts/{context}/pin", s.handleSetPinned(false))
+
+	mux.HandleFunc("GET /contexts/{context}/namespaces/allowed", s.handleAllowedNamespaces)
+	mux.HandleFunc("GET /contexts/{context}/namespaces/{namespace}/summary", s.handleNamespaceSummary)
+	mux.HandleFunc("GET /contexts/{context}/resources", s.handleResources)
+	mux.HandleFunc("GET /contexts/{context}/discovery", s.handleResources)
+	mux.HandleFunc("GET /contexts/{context}/schemas/{gvk}", s.handleSchema)
+	mux.HandleFunc("POST /contexts/{context}/explorer", s.handleExplorer)
+	mux.HandleFunc("POST /contexts/{context}/metadata", s.handleBulkMetadata)
+	mux.HandleFunc("POST /contexts/{context}/confirmations", s.handleCreateConfirmation)
+
+	mux.HandleFunc("POST /contexts/{context}/traffic/test", s.handleTrafficTest)
+
+	mux.HandleFunc("GET /contexts/{context}/watch/{group}/{version}/{resource}", s.handleWatchEvents)
+
+	mux.HandleFunc("GET /contexts/{context}/objects/{group}/{version}/{resource}/{name}/conditions", s.handleConditions)
+	mux.HandleFunc("GET /contexts/{context}/objects/{group}/{version}/{resource}/{name}/wait", s.handleWaitCondition)
+	mux.HandleFunc("POST /contexts/{context}/objects/{group}/{version}/{resource}/{name}/retrigger", s.handleRetrigger)
+	mux.HandleFunc("GET /contexts/{context}/objects/{group}/{version}/{resource}/{name}/drift", s.handleDrift)
+	mux.HandleFunc("POST /contexts/{context}/objects/{group}/{version}/{resource}/{name}/clone", s.handleCloneObject)
+	mux.HandleFunc("POST /contexts/{context}/objects/{group}/{version}/{resource}/{name}/scale", s.handleScaleObject)
+
+	mux.HandleFunc("GET /contexts/{context}/configs/{namespace}/{resource}/{name}", s.handleGetConfig)
+	mux.HandleFunc("PUT /contexts/{context}/configs/{namespace}/{resource}/{name}", s.handleUpdateConfig)
+
+	mux.HandleFunc("GET /contexts/{context}/serviceaccounts/{namespace}/{name}", s.handleServiceAccount)
+	mux.HandleFunc("POST /contexts/{context}/serviceaccounts/{namespace}/{name}/token", s.handleCreateServiceAccountToken)
+
+	mux.HandleFunc("POST /contexts/{context}/simulate/fit", s.handleSimulateFit)
+
+	mux.HandleFunc("POST /contexts/{context}/diff", s.handleDiff)
+	mux.HandleFunc("POST /contexts/{context}/apply", s.handleApply)
+
+	mux.HandleFunc("GET /capabilities", s.handleListCapabilities)
+	mux.HandleFunc("GET /contexts/{context}/capabilities", s.handleCapabilities)
+	mux.HandleFunc("POST /contexts/{context}/capabilities", s.handleCheckPermissions)
+
+	mux.HandleFunc("GET /contexts/{context}/pods/{namespace}/{name}/containers", s.handlePodContainers)
+	mux.HandleFunc("GET /contexts/{context}/pods/{namespace}/{name}/timeline", s.handlePodTimeline)
+
+	mux.HandleFunc("GET /contexts/{context}/pods/{namespace}/{name}/files", s.handlePodFiles)
+	mux.HandleFunc("PUT /contexts/{context}/pods/{namespace}/{name}/files", s.handleUploadPodFile)
+	mux.HandleFunc("GET /contexts/{context}/pods/{namespace}/{name}/files/download", s.handleDownloadPodFile)
+
+	mux.HandleFunc("POST /contexts/{context}/nodes/{node}/shell", s.handleCreateNodeShell)
+	mux.HandleFunc("GET /contexts/{context}/nodes/{node}/shell/{id}", s.handleNodeShellTerminal)
+
+	mux.HandleFunc("GET /contexts/{context}/nodes/health", s.handleNodeHealth)
+	mux.HandleFunc("GET /contexts/{context}/controlplane", s.handleControlPlane)
+
+	mux.HandleFunc("POST /contexts/{context}/nodes/{node}/cordon", s.handleCordonNode)
+	mux.HandleFunc("POST /contexts/{context}/nodes/{node}/uncordon", s.handleUncordonNode)
+	mux.HandleFunc("POST /contexts/{context}/nodes/{node}/drain", s.handleDrainNode)
+
+	mux.HandleFunc("GET /contexts/{context}/top/pods", s.handleTopPods)
+	mux.HandleFunc("GET /contexts/{context}/top/nodes", s.handleTopNodes)
+
+	mux.HandleFunc("GET /contexts/{context}/devices", s.handleDevices)
+	mux.HandleFunc("GET /contexts/{context}/batch", s.handleBatch)
+
+	mux.HandleFunc("GET /contexts/{context}/vclusters", s.handleVClusters)
+	mux.HandleFunc("POST /contexts/{context}/vclusters/{namespace}/{name}/connect", s.handleConnectVCluster)
+
+	mux.HandleFunc("GET /contexts/{context}/capi/clusters", s.handleCAPIClusters)
+	mux.HandleFunc("GET /contexts/{context}/capi/clusters/{namespace}/{name}/kubeconfig", s.handleCAPIKubeconfig)
+	mux.HandleFunc("POST /contexts/{context}/capi/clusters/{namespace}/{name}/connect", s.handleConnectCAPICluster)
+	mux.HandleFunc("POST /contexts/{context}/capi/machinedeployments/{namespace}/{name}/scale", s.handleScaleCAPIMachineDeployment)
+
+	mux.HandleFunc("GET /contexts/{context}/teleport", s.handleTeleportSession)
+	mux.HandleFunc("POST /contexts/{context}/teleport/login", s.handleTeleportLogin)
+
+	mux.HandleFunc("GET /contexts/{context}/talos/nodes/{node}/machineconfig", s.handleTalosMachineConfig)
+	mux.HandleFunc("POST /contexts/{context}/talos/nodes/{node}/reboot", s.handleTalosReboot)
+	mux.HandleFunc("POST /contexts/{context}/talos/nodes/{node}/upgrade", s.handleTalosUpgrade)
+
+	mux.HandleFunc("GET /contexts/{context}/registry", s.handleRegistry)
+	mux.HandleFunc("POST /contexts/{context}/registry", s.handleCreateRegistry)
+	mux.HandleFunc("POST /contexts/{context}/registry/images", s.handlePushRegistryImage)
+
+	mux.HandleFunc("GET /contexts/{context}/host/terminal", s.handleHostTerminal)
+	mux.HandleFunc("GET /contexts/{context}/host/metrics", s.handleHostMetrics)
+
+	mux.HandleFunc("GET /intercepts", s.handleListIntercepts)
+	mux.HandleFunc("POST /contexts/{context}/intercepts", s.handleCreateIntercept)
+	mux.HandleFunc("DELETE /intercepts/{id}", s.handleDeleteIntercept)
+
+	mux.HandleFunc("GET /chaos", s.handleListChaos)
+	mux.HandleFunc("POST /contexts/{context}/chaos/deployments/{namespace}/{name}/kill", s.handleChaosKillPod)
+	mux.HandleFunc("POST /contexts/{context}/chaos/deployments/{namespace}/{name}/latency", s.handleChaosLatency)
+	mux.HandleFunc("POST /contexts/{context}/chaos/nodes/{node}/cordon", s.handleChaosCordon)
+	mux.HandleFunc("DELETE /chaos/{id}", s.handleStopChaos)
+
+	mux.HandleFunc("GET /contexts/{context}/rollouts/{kind}/{namespace}/{name}", s.handleRolloutStatus)
+	mux.HandleFunc("POST /contexts/{context}/rollouts/{kind}/{namespace}/{name}/{action}", s.handleRolloutAction)
+
+	mux.HandleFunc("POST /contexts/{context}/canary/deployments/{namespace}/{name}", s.handleCanary)
+
+	mux.HandleFunc("GET /contexts/{context}/maintenance/{namespace}", s.handleMaintenance)
+	mux.HandleFunc("POST /contexts/{context}/maintenance/{namespace}", s.handleStartMaintenance)
+	mux.HandleFunc("DELETE /contexts/{context}/maintenance/{namespace}", s.handleStopMaintenance)
+
+	mux.HandleFunc("GET /tunnels", s.handleListTunnels)
+	mux.HandleFunc("GET /upstreams", s.handleListUpstreams)
+
+	mux.HandleFunc("POST /contexts/{context}/webhooks/test", s.handleWebhookTest)
+	mux.HandleFunc("/contexts/{context}/services/{namespace}/{service}/proxy/{path...}", s.handleServiceProxy)
+	mux.HandleFunc("/contexts/{context}/prometheus/api/v1/{path...}", s.handlePrometheusProxy)
+	mux.HandleFunc("/contexts/{context}/loki/api/v1/{path...}", s.handleLokiProxy)
+
+	mux.HandleFunc("GET /portforwards", s.handleListPortForwards)
+	mux.HandleFunc("POST /contexts/{context}/portforwards", s.handleCreatePortForward)
+	mux.HandleFunc("DELETE /portforwards/{id}", s.handleDeletePortForward)
+
+	mux.HandleFunc("GET /bootstrap/stacks", s.handleListBootstrapStacks)
+	mux.HandleFunc("POST /contexts/{context}/bootstrap/{stack}", s.handleBootstrap)
+
+	mux.HandleFunc("GET /helm/repositories", s.handleHelmRepositories)
+	mux.HandleFunc("POST /contexts/{context}/helm/releases", s.handleInstallHelmRelease)
+
+	mux.HandleFunc("POST /analyze/dockerfile", s.handleAnalyzeDockerfile)
+	mux.HandleFunc("POST /analyze/manifest", s.handleAnalyzeManifest)
+
+	mux.HandleFunc("GET /reports/inventory", s.handleInventoryReport)
+
+	mux.HandleFunc("GET /audit", s.handleAudit)
+
+	mux.HandleFunc("GET /rbac/compare", s.handleCompareRBAC)
+
+	mux.HandleFunc("POST /images/promote", s.handlePromoteImage)
+
+	mux.HandleFunc("GET /search", s.handleSearch)
+	mux.HandleFunc("GET /aggregate/{group}/{version}/{resource}", s.handleAggregateList)
+
+	mux.HandleFunc("GET /monitors", s.handleListMonitors)
+	mux.HandleFunc("POST /monitors", s.handleCreateMonitor)
+	mux.HandleFunc("GET /monitors/{id}", s.handleGetMonitor)
+	mux.HandleFunc("PUT /monitors/{id}", s.handleUpdateMonitor)
+	mux.HandleFunc("DELETE /monitors/{id}", s.handleDeleteMonitor)
+	mux.HandleFunc("POST /monitors/{id}/run", s.handleRunMonitor)
+
+	mux.HandleFunc("GET /connectivity", s.handleConnectivity)
+	mux.HandleFunc("GET /connectivity/events", s.handleConnectivityEvents)
+	mux.HandleFunc("POST /reconnect", s.handleReconnect)
+
+	mux.HandleFunc("DELETE /data", s.handleWipeData)
+
+	mux.HandleFunc("GET /trash", s.handleListTrash)
+	mux.HandleFunc("GET /trash/{id}", s.handleGetTrash)
+	mux.HandleFunc("DELETE /trash/{id}", s.handleDeleteTrash)
+	mux.HandleFunc("POST /trash/{id}/restore", s.handleRestoreTrash)
+
+	mux.HandleFunc("GET /debug/transports", s.handleTransportStats)
+	mux.HandleFunc("GET /debug/caches", s.handleCacheStats)
+
+	mux.HandleFunc("GET /sessions", s.handleListSessions)
+	mux.HandleFunc("DELETE /sessions/{id}", s.handleDeleteSession)
+
+	mux.HandleFunc("GET /transcripts", s.handleListTranscripts)
+	mux.HandleFunc("GET /transcripts/{id}", s.handleGetTranscript)
+	mux.HandleFunc("DELETE /transcripts/{id}", s.handleDeleteTranscript)
+
+	mux.HandleFunc("GET /logs/bridge", func(w http.ResponseWriter, r *http.Request) {
+		lines, _ := strconv.Atoi(r.URL.Query().Get("lines"))
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+
+		w.Write(logging.Recent(lines))
+	})
+
 	mux.HandleFunc("/contexts/{context}/{path...}", func(w http.ResponseWriter, r *http.Request) {
 		path := r.PathValue("path")
 
 		auth := AuthInfoFromContext(r.Context())
 
-		context, ok := contexts[r.PathValue("context")]
+		context, ok := s.context(r.PathValue("context"))
 
 		if !ok {
-			http.Error(w, "context not found", http.StatusNotFound)
+			writeError(w, r, errContextNotFound, http.StatusNotFound)
+			return
+		}
+
+		r.URL.Path = "/" + path
+
+		w, r, done, err := s.trackSession(w, r, context, auth)
+
+		if err != nil {
+			writeError(w, r, err, http.StatusTooManyRequests)
 			return
 		}
 
+		defer done()
+
 		switch context.Type {
 		case "docker":
 			proxy, err := s.dockerProxy(r.Context(), context.Name, auth)
@@ -115,7 +436,6 @@ func New(cfg *config.Config) (*Server, error) {
 				return
 			}
 
-			r.URL.Path = "/" + path
 			proxy.ServeHTTP(w, r)
 
 		case "kubernetes":
@@ -126,7 +446,6 @@ func New(cfg *config.Config) (*Server, error) {
 				return
 			}
 
-			r.URL.Path = "/" + path
 			proxy.ServeHTTP(w, r)
 
 		default:
@@ -143,7 +462,7 @@ func New(cfg *config.Config) (*Server, error) {
 		}
 
 		proxy := &httputil.ReverseProxy{
-			ErrorLog: log.New(io.Discard, "", 0),
+			ErrorLog: log.New(log.Writer(), "proxy: ", log.LstdFlags),
 
 			Rewrite: func(r *httputil.ProxyRequest) {
 				r.Out.URL.Path = strings.TrimPrefix(r.Out.URL.Path, "/openai/v1")
@@ -215,9 +534,23 @@ func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
 		srv.Shutdown(context.Background())
 	}()
 
+	defer s.Close()
+
 	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 		return err
 	}
 
 	return nil
 }
+
+// Close stops background work and tears down all tunnels and forwards.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+
+	s.releaseAll()
+	s.namespaces.flush()
+
+	return nil
+}
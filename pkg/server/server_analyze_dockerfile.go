@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"golang.org/x/text/language"
+
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+// secretPattern matches build argument and environment names likely to
+// carry credentials.
+var secretPattern = regexp.MustCompile(`(?i)(password|passwd|secret|token|api_?key|access_?key|private_?key|credential)`)
+
+const dockerfileReviewPrompt = `You review Dockerfiles. For each reported issue, explain the fix in one or two sentences, then print the complete corrected Dockerfile in a single dockerfile code block.`
+
+// handleAnalyzeDockerfile lints a Dockerfile for common best-practice
+// violations. With "ai": true, fix suggestions are requested from the
+// configured AI provider.
+func (s *Server) handleAnalyzeDockerfile(w http.ResponseWriter, r *http.Request) {
+	var req DockerfileAnalysisRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := parser.Parse(strings.NewReader(req.Dockerfile))
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	analysis := &DockerfileAnalysis{
+		Issues: analyzeDockerfile(result.AST, i18n.Negotiate(r)),
+	}
+
+	for _, warning := range result.Warnings {
+		issue := AnalysisIssue{
+			Rule:     "parser",
+			Severity: "warning",
+			Message:  warning.Short,
+		}
+
+		if warning.Location != nil {
+			issue.Line = warning.Location.Start.Line
+		}
+
+		analysis.Issues = append(analysis.Issues, issue)
+	}
+
+	slices.SortStableFunc(analysis.Issues, func(a, b AnalysisIssue) int {
+		return a.Line - b.Line
+	})
+
+	if req.AI && len(analysis.Issues) > 0 {
+		var prompt strings.Builder
+
+		prompt.WriteString("Dockerfile:\n\n")
+		prompt.WriteString(req.Dockerfile)
+		prompt.WriteString("\n\nIssues:\n\n")
+
+		for _, issue := range analysis.Issues {
+			fmt.Fprintf(&prompt, "- line %d (%s): %s\n", issue.Line, issue.Rule, issue.Message)
+		}
+
+		suggestion, err := s.complete(r.Context(), dockerfileReviewPrompt, prompt.String())
+
+		if err != nil {
+			analysis.Error = err.Error()
+		}
+
+		analysis.Suggestion = suggestion
+	}
+
+	writeList(w, r, "dockerfile-issues", analysis, analysis.Issues)
+}
+
+func analyzeDockerfile(ast *parser.Node, tag language.Tag) []AnalysisIssue {
+	issues := []AnalysisIssue{}
+
+	add := func(node *parser.Node, rule, severity, id string, args ...any) {
+		issues = append(issues, AnalysisIssue{
+			Rule:     rule,
+			Severity: severity,
+			Line:     node.StartLine,
+			Message:  i18n.Translate(tag, id, args...),
+		})
+	}
+
+	stages := map[string]bool{}
+
+	// instructions of the final stage
+	var final []*parser.Node
+
+	for _, node := range ast.Children {
+		args := nodeArgs(node)
+
+		switch strings.ToLower(node.Value) {
+		case "from":
+			final = nil
+
+			if len(args) == 0 {
+				break
+			}
+
+			image := args[0]
+
+			if len(args) >= 3 && strings.EqualFold(args[1], "as") {
+				stages[strings.ToLower(args[2])] = true
+			}
+
+			if stages[strings.ToLower(image)] || image == "scratch" || strings.Contains(image, "$") {
+				break
+			}
+
+			// images without tag resolve to latest
+			if _, tag := splitImage(image); !strings.Contains(image, "@") && tag == "latest" {
+				add(node, "unpinned-base", "warning", "analysis.unpinned_base", image)
+			}
+
+		case "arg":
+			for _, arg := range args {
+				name, _, _ := strings.Cut(arg, "=")
+
+				if secretPattern.MatchString(name) {
+					add(node, "secret-in-arg", "error", "analysis.secret_in_arg", name)
+				}
+			}
+
+		case "env":
+			// ENV nodes are triplets of name, value and separator
+			for i := 0; i < len(args); i += 3 {
+				if secretPattern.MatchString(args[i]) {
+					add(node, "secret-in-env", "error", "analysis.secret_in_env", args[i])
+				}
+			}
+
+		case "add":
+			sources := args[:max(len(args)-1, 0)]
+
+			if !slices.ContainsFunc(sources, func(src string) bool {
+				return strings.Contains(src, "://") || strings.HasSuffix(src, ".tar") || strings.Contains(src, ".tar.") || strings.HasSuffix(src, ".tgz")
+			}) {
+				add(node, "add-instead-of-copy", "info", "analysis.add_instead_of_copy")
+			}
+		}
+
+		final = append(final, node)
+	}
+
+	if len(final) == 0 {
+		return issues
+	}
+
+	from := final[0]
+
+	user := ""
+	var userNode *parser.Node
+
+	healthcheck := false
+
+	for _, node := range final {
+		switch strings.ToLower(node.Value) {
+		case "user":
+			if args := nodeArgs(node); len(args) > 0 {
+				user, userNode = args[0], node
+			}
+
+		case "healthcheck":
+			healthcheck = true
+		}
+	}
+
+	name, _, _ := strings.Cut(user, ":")
+
+	switch {
+	case user == "":
+		add(from, "root-user", "warning", "analysis.root_user")
+
+	case name == "root" || name == "0":
+		add(userNode, "root-user", "warning", "analysis.root_user_explicit")
+	}
+
+	if !healthcheck {
+		add(from, "missing-healthcheck", "info", "analysis.missing_healthcheck")
+	}
+
+	return issues
+}
+
+func nodeArgs(node *parser.Node) []string {
+	var args []string
+
+	for n := node.Next; n != nil; n = n.Next {
+		args = append(args, n.Value)
+	}
+
+	return args
+}
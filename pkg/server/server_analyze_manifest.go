@@ -0,0 +1,335 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/text/language"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+// manifestDocument is a document of a multi-document YAML manifest.
+type manifestDocument struct {
+	// Line the document starts at
+	Line int
+
+	Object map[string]any
+	Error  error
+}
+
+// splitManifest splits a YAML stream at document separators. Empty
+// documents are skipped.
+func splitManifest(data string) []manifestDocument {
+	var result []manifestDocument
+
+	start := 1
+
+	var doc strings.Builder
+
+	flush := func() {
+		if strings.TrimSpace(doc.String()) == "" {
+			return
+		}
+
+		var obj map[string]any
+		err := yaml.Unmarshal([]byte(doc.String()), &obj)
+
+		if err == nil && obj == nil {
+			// only comments
+			return
+		}
+
+		result = append(result, manifestDocument{Line: start, Object: obj, Error: err})
+	}
+
+	for i, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(line, "---") {
+			flush()
+
+			doc.Reset()
+			start = i + 2
+
+			continue
+		}
+
+		if doc.Len() == 0 && strings.TrimSpace(line) == "" {
+			start = i + 2
+		}
+
+		doc.WriteString(line)
+		doc.WriteString("\n")
+	}
+
+	flush()
+
+	return result
+}
+
+// manifestWorkload is a workload of a manifest with its pod template.
+type manifestWorkload struct {
+	Kind      string
+	Name      string
+	Namespace string
+	Line      int
+
+	Replicas int
+	Labels   map[string]string
+
+	Spec corev1.PodSpec
+}
+
+// podTemplatePaths locates the pod spec of workload kinds.
+var podTemplatePaths = map[string][]string{
+	"Pod":         {"spec"},
+	"Deployment":  {"spec", "template", "spec"},
+	"StatefulSet": {"spec", "template", "spec"},
+	"DaemonSet":   {"spec", "template", "spec"},
+	"ReplicaSet":  {"spec", "template", "spec"},
+	"Job":         {"spec", "template", "spec"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// handleAnalyzeManifest statically checks kubernetes manifests for
+// reliability and security best practices. The request body is the YAML
+// (or JSON) manifest.
+func (s *Server) handleAnalyzeManifest(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, 10*1024*1024))
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	analysis := &ManifestAnalysis{
+		Issues: []AnalysisIssue{},
+	}
+
+	var workloads []manifestWorkload
+	var budgets []labels.Selector
+
+	for _, doc := range splitManifest(string(data)) {
+		if doc.Error != nil {
+			analysis.Issues = append(analysis.Issues, AnalysisIssue{
+				Rule:     "invalid-yaml",
+				Severity: "error",
+				Line:     doc.Line,
+				Message:  doc.Error.Error(),
+			})
+
+			continue
+		}
+
+		analysis.Objects++
+
+		kind, _ := doc.Object["kind"].(string)
+
+		if kind == "PodDisruptionBudget" {
+			if selector, ok := budgetSelector(doc.Object); ok {
+				budgets = append(budgets, selector)
+			}
+
+			continue
+		}
+
+		workload, ok := parseWorkload(doc)
+
+		if !ok {
+			continue
+		}
+
+		workloads = append(workloads, workload)
+		analysis.Issues = append(analysis.Issues, checkWorkload(workload, i18n.Negotiate(r))...)
+	}
+
+	for _, workload := range workloads {
+		if workload.Replicas < 2 || (workload.Kind != "Deployment" && workload.Kind != "StatefulSet") {
+			continue
+		}
+
+		covered := false
+
+		for _, selector := range budgets {
+			if selector.Matches(labels.Set(workload.Labels)) {
+				covered = true
+			}
+		}
+
+		if !covered {
+			analysis.Issues = append(analysis.Issues, manifestIssue(workload, "", "missing-pdb", "warning", "no PodDisruptionBudget in the manifest covers this workload"))
+		}
+	}
+
+	writeList(w, r, "manifest-issues", analysis, analysis.Issues)
+}
+
+func parseWorkload(doc manifestDocument) (manifestWorkload, bool) {
+	kind, _ := doc.Object["kind"].(string)
+
+	path, ok := podTemplatePaths[kind]
+
+	if !ok {
+		return manifestWorkload{}, false
+	}
+
+	workload := manifestWorkload{
+		Kind:     kind,
+		Line:     doc.Line,
+		Replicas: 1,
+	}
+
+	if metadata, ok := doc.Object["metadata"].(map[string]any); ok {
+		workload.Name, _ = metadata["name"].(string)
+		workload.Namespace, _ = metadata["namespace"].(string)
+	}
+
+	if spec, ok := doc.Object["spec"].(map[string]any); ok {
+		if replicas, ok := spec["replicas"].(float64); ok {
+			workload.Replicas = int(replicas)
+		}
+
+		if template, ok := spec["template"].(map[string]any); ok {
+			metadata, _ := template["metadata"].(map[string]any)
+			labels, _ := metadata["labels"].(map[string]any)
+
+			workload.Labels = map[string]string{}
+
+			for k, v := range labels {
+				workload.Labels[k] = fmt.Sprint(v)
+			}
+		}
+	}
+
+	var value any = doc.Object
+
+	for _, key := range path {
+		m, _ := value.(map[string]any)
+		value = m[key]
+	}
+
+	data, err := json.Marshal(value)
+
+	if err != nil || json.Unmarshal(data, &workload.Spec) != nil {
+		return manifestWorkload{}, false
+	}
+
+	return workload, true
+}
+
+func budgetSelector(obj map[string]any) (labels.Selector, bool) {
+	spec, _ := obj["spec"].(map[string]any)
+	selector, _ := spec["selector"].(map[string]any)
+	matchLabels, _ := selector["matchLabels"].(map[string]any)
+
+	if len(matchLabels) == 0 {
+		return nil, false
+	}
+
+	set := labels.Set{}
+
+	for k, v := range matchLabels {
+		set[k] = fmt.Sprint(v)
+	}
+
+	return labels.SelectorFromSet(set), true
+}
+
+func checkWorkload(workload manifestWorkload, tag language.Tag) []AnalysisIssue {
+	var issues []AnalysisIssue
+
+	add := func(container, rule, severity, id string, args ...any) {
+		issues = append(issues, manifestIssue(workload, container, rule, severity, i18n.Translate(tag, id, args...)))
+	}
+
+	spec := workload.Spec
+
+	batch := workload.Kind == "Job" || workload.Kind == "CronJob"
+
+	if spec.HostNetwork || spec.HostPID || spec.HostIPC {
+		add("", "host-namespaces", "error", "analysis.host_namespaces")
+	}
+
+	if workload.Replicas > 1 && spec.TopologySpreadConstraints == nil && (spec.Affinity == nil || spec.Affinity.PodAntiAffinity == nil) {
+		add("", "missing-anti-affinity", "warning", "analysis.missing_anti_affinity")
+	}
+
+	podNonRoot := spec.SecurityContext != nil && spec.SecurityContext.RunAsNonRoot != nil && *spec.SecurityContext.RunAsNonRoot
+
+	for _, c := range spec.Containers {
+		if !batch {
+			if c.ReadinessProbe == nil {
+				add(c.Name, "missing-readiness-probe", "warning", "analysis.missing_readiness_probe")
+			}
+
+			if c.LivenessProbe == nil {
+				add(c.Name, "missing-liveness-probe", "info", "analysis.missing_liveness_probe")
+			}
+		}
+
+		if _, ok := c.Resources.Requests[corev1.ResourceCPU]; !ok {
+			add(c.Name, "missing-cpu-request", "warning", "analysis.missing_cpu_request")
+		}
+
+		if _, ok := c.Resources.Requests[corev1.ResourceMemory]; !ok {
+			add(c.Name, "missing-memory-request", "warning", "analysis.missing_memory_request")
+		}
+
+		if _, ok := c.Resources.Limits[corev1.ResourceMemory]; !ok {
+			add(c.Name, "missing-memory-limit", "warning", "analysis.missing_memory_limit")
+		}
+
+		if _, tag := splitImage(c.Image); !strings.Contains(c.Image, "@") && tag == "latest" {
+			add(c.Name, "unpinned-image", "warning", "analysis.unpinned_image", c.Image)
+		}
+
+		sc := c.SecurityContext
+
+		if sc == nil {
+			sc = &corev1.SecurityContext{}
+		}
+
+		if sc.Privileged != nil && *sc.Privileged {
+			add(c.Name, "privileged", "error", "analysis.privileged")
+		}
+
+		if !podNonRoot && (sc.RunAsNonRoot == nil || !*sc.RunAsNonRoot) {
+			add(c.Name, "run-as-root", "warning", "analysis.run_as_root")
+		}
+
+		if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+			add(c.Name, "privilege-escalation", "warning", "analysis.privilege_escalation")
+		}
+
+		if sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem {
+			add(c.Name, "writable-root-filesystem", "info", "analysis.writable_root_filesystem")
+		}
+	}
+
+	return issues
+}
+
+func manifestIssue(workload manifestWorkload, container, rule, severity, message string) AnalysisIssue {
+	object := workload.Kind + "/" + workload.Name
+
+	if workload.Namespace != "" {
+		object = workload.Kind + "/" + workload.Namespace + "/" + workload.Name
+	}
+
+	if container != "" {
+		message = fmt.Sprintf("%s: %s", container, message)
+	}
+
+	return AnalysisIssue{
+		Rule:     rule,
+		Severity: severity,
+		Line:     workload.Line,
+		Object:   object,
+		Message:  message,
+	}
+}
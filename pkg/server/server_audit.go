@@ -0,0 +1,94 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditFile is the JSON lines audit log of earlier versions, which is
+// migrated into the store.
+const auditFile = "audit.jsonl"
+
+// auditLog records changes made through bridge endpoints in the local store.
+type auditLog struct{}
+
+func (a *auditLog) record(e *AuditEntry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	db, err := dataStore()
+
+	if err == nil {
+		err = db.Append(auditBucket, e)
+	}
+
+	if err != nil {
+		log.Printf("failed to write audit log: %v", err)
+	}
+}
+
+// entries returns the most recent entries, newest first.
+func (a *auditLog) entries(context string, limit int) ([]AuditEntry, error) {
+	db, err := dataStore()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result []AuditEntry
+
+	err = db.Each(auditBucket, func(_ string, decode func(v any) error) error {
+		var e AuditEntry
+
+		if err := decode(&e); err != nil {
+			return nil
+		}
+
+		if context != "" && !strings.EqualFold(e.Context, context) {
+			return nil
+		}
+
+		result = append(result, e)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if limit > 0 && len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+
+	if result == nil {
+		result = []AuditEntry{}
+	}
+
+	return result, nil
+}
+
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	if limit <= 0 {
+		limit = 100
+	}
+
+	entries, err := s.audit.entries(r.URL.Query().Get("context"), limit)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeList(w, r, "audit", entries, entries)
+}
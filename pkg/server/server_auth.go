@@ -2,21 +2,30 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
 	"strings"
 
+	"github.com/adrianliechti/bridge/pkg/auth"
 	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
 )
 
 type contextKey string
 
 const authInfoKey contextKey = "auth_info"
 
+var errImpersonationForbidden = i18n.NewError("error.impersonation_forbidden")
+
 func BearerTokenMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
-		if token := extractBearerToken(r); token != "" {
+		if token := auth.BearerToken(r); token != "" {
 			authInfo := &config.AuthInfo{
 				Bearer: token,
 			}
@@ -28,15 +37,152 @@ func BearerTokenMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// AuthMiddleware authenticates all requests with the provider of server
+// mode. Browsers without a session are redirected to the login.
+func AuthMiddleware(provider auth.Provider, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/login", "/auth/callback", "/auth/logout":
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := provider.Authenticate(r)
+
+		if err != nil {
+			_, interactive := provider.(auth.LoginProvider)
+
+			if interactive && r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, "/auth/login?redirect="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+				return
+			}
+
+			if !errors.Is(err, auth.ErrUnauthorized) {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+
+			w.Header().Set("WWW-Authenticate", `Bearer realm="bridge"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		authInfo := &config.AuthInfo{
+			Bearer: auth.BearerToken(r),
+
+			User:   user.Name,
+			Groups: user.Groups,
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authInfoKey, authInfo)))
+	})
+}
+
+// ImpersonationMiddleware moves the Impersonate-User and Impersonate-Group
+// headers of callers allowed to impersonate into their AuthInfo. The headers
+// are never forwarded as is, as upstream requests carry the credentials of
+// the contexts.
+func ImpersonationMiddleware(cfg *config.Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.Header.Get("Impersonate-User")
+		groups := r.Header.Values("Impersonate-Group")
+
+		for key := range r.Header {
+			if strings.HasPrefix(key, "Impersonate-") {
+				r.Header.Del(key)
+			}
+		}
+
+		if user == "" && len(groups) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		auth := AuthInfoFromContext(r.Context())
+
+		if !cfg.ImpersonationAllowed(auth) {
+			writeError(w, r, errImpersonationForbidden, http.StatusForbidden)
+			return
+		}
+
+		if user == "" {
+			http.Error(w, "impersonating groups requires a user", http.StatusBadRequest)
+			return
+		}
+
+		authInfo := &config.AuthInfo{}
+
+		if auth != nil {
+			*authInfo = *auth
+		}
+
+		authInfo.ImpersonateUser = user
+		authInfo.ImpersonateGroups = groups
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authInfoKey, authInfo)))
+	})
+}
+
+func (s *Server) handleCurrentUser(w http.ResponseWriter, r *http.Request) {
+	info := AuthInfoFromContext(r.Context())
+
+	if info == nil || info.User == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&UserInfo{
+		Name:   info.User,
+		Groups: info.Groups,
+	})
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	auth.Logout(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func AuthInfoFromContext(ctx context.Context) *config.AuthInfo {
 	authInfo, _ := ctx.Value(authInfoKey).(*config.AuthInfo)
 	return authInfo
 }
 
-func extractBearerToken(r *http.Request) string {
-	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
-		return token
+// ownerID derives a stable, non-secret identifier for the caller, used to
+// bind runtime resources to the session that created them.
+func ownerID(auth *config.AuthInfo) string {
+	if auth == nil {
+		return ""
+	}
+
+	if auth.User != "" {
+		sum := sha256.Sum256([]byte("user:" + auth.User))
+		return hex.EncodeToString(sum[:8])
+	}
+
+	if auth.Bearer == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(auth.Bearer))
+	return hex.EncodeToString(sum[:8])
+}
+
+// credentialID identifies the access of the caller to the Kubernetes APIs,
+// which differs from the owner while impersonating. Caches of upstream
+// responses are keyed by it.
+func credentialID(auth *config.AuthInfo) string {
+	id := ownerID(auth)
+
+	if auth == nil || auth.ImpersonateUser == "" {
+		return id
 	}
 
-	return ""
+	sum := sha256.Sum256([]byte("impersonate:" + auth.ImpersonateUser + "\x00" + strings.Join(auth.ImpersonateGroups, "\x00")))
+	return id + "-" + hex.EncodeToString(sum[:8])
+}
+
+func stripBearerProtocol(h http.Header) {
+	auth.StripBearerProtocol(h)
 }
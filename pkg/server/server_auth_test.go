@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+func TestImpersonationMiddleware(t *testing.T) {
+	cfg := &config.Config{
+		Auth: &config.AuthConfig{},
+
+		Impersonation: &config.ImpersonationConfig{
+			Users: []string{"alice"},
+		},
+	}
+
+	alice := &config.AuthInfo{Bearer: "token", User: "alice"}
+	bob := &config.AuthInfo{Bearer: "token", User: "bob"}
+
+	tests := []struct {
+		name   string
+		caller *config.AuthInfo
+		header http.Header
+		status int
+		user   string
+		groups []string
+	}{
+		{"no impersonation", bob, nil, http.StatusOK, "", nil},
+		{"user", alice, http.Header{"Impersonate-User": {"carol"}}, http.StatusOK, "carol", nil},
+		{"user and groups", alice, http.Header{"Impersonate-User": {"carol"}, "Impersonate-Group": {"devs", "ops"}}, http.StatusOK, "carol", []string{"devs", "ops"}},
+		{"extra only", bob, http.Header{"Impersonate-Extra-Scopes": {"admin"}}, http.StatusOK, "", nil},
+		{"not allowed", bob, http.Header{"Impersonate-User": {"carol"}}, http.StatusForbidden, "", nil},
+		{"anonymous", nil, http.Header{"Impersonate-User": {"carol"}}, http.StatusForbidden, "", nil},
+		{"groups without user", alice, http.Header{"Impersonate-Group": {"devs"}}, http.StatusBadRequest, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var auth *config.AuthInfo
+			var forwarded http.Header
+
+			h := ImpersonationMiddleware(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auth = AuthInfoFromContext(r.Context())
+				forwarded = r.Header.Clone()
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/contexts/dev/api/v1/pods", nil)
+
+			for key, values := range tt.header {
+				r.Header[key] = values
+			}
+
+			if tt.caller != nil {
+				r = r.WithContext(context.WithValue(r.Context(), authInfoKey, tt.caller))
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			for key := range forwarded {
+				if strings.HasPrefix(key, "Impersonate-") {
+					t.Errorf("expected the %s header not to be forwarded", key)
+				}
+			}
+
+			if auth == nil {
+				if tt.user != "" {
+					t.Fatal("expected an impersonating caller")
+				}
+
+				return
+			}
+
+			if auth.ImpersonateUser != tt.user || !slices.Equal(auth.ImpersonateGroups, tt.groups) {
+				t.Fatalf("impersonate = %q %v, want %q %v", auth.ImpersonateUser, auth.ImpersonateGroups, tt.user, tt.groups)
+			}
+
+			if tt.user != "" && (auth.User != tt.caller.User || tt.caller.ImpersonateUser != "") {
+				t.Fatal("expected the caller to be kept and its AuthInfo not to be modified")
+			}
+		})
+	}
+}
+
+func TestCredentialID(t *testing.T) {
+	alice := &config.AuthInfo{User: "alice"}
+
+	tests := []struct {
+		name  string
+		a, b  *config.AuthInfo
+		owner bool
+		same  bool
+	}{
+		{"same caller", alice, &config.AuthInfo{User: "alice", Bearer: "other"}, true, true},
+		{"other caller", alice, &config.AuthInfo{User: "bob"}, false, false},
+		{"impersonating", alice, &config.AuthInfo{User: "alice", ImpersonateUser: "carol"}, true, false},
+		{"impersonated groups", &config.AuthInfo{User: "alice", ImpersonateUser: "carol"}, &config.AuthInfo{User: "alice", ImpersonateUser: "carol", ImpersonateGroups: []string{"devs"}}, true, false},
+		{"bearer", &config.AuthInfo{Bearer: "a"}, &config.AuthInfo{Bearer: "b"}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if owner := ownerID(tt.a) == ownerID(tt.b); owner != tt.owner {
+				t.Errorf("same owner = %v, want %v", owner, tt.owner)
+			}
+
+			if same := credentialID(tt.a) == credentialID(tt.b); same != tt.same {
+				t.Errorf("same credential = %v, want %v", same, tt.same)
+			}
+		})
+	}
+
+	if ownerID(nil) != "" || credentialID(nil) != "" {
+		t.Error("expected the local user to have no owner")
+	}
+}
@@ -0,0 +1,416 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+const (
+	// capabilitiesInterval is the interval of the background probes
+	capabilitiesInterval = 10 * time.Minute
+
+	// capabilitiesDelay lets contexts get registered before the first probe
+	capabilitiesDelay = 15 * time.Second
+
+	// capabilitiesTimeout bounds the probe of a context
+	capabilitiesTimeout = 30 * time.Second
+)
+
+// ingressControllers maps the controllers of IngressClasses to their type.
+var ingressControllers = map[string]string{
+	"k8s.io/ingress-nginx":                "ingress-nginx",
+	"nginx.org/ingress-controller":        "nginx",
+	"traefik.io/ingress-controller":       "traefik",
+	"haproxy.org/ingress-controller":      "haproxy",
+	"istio.io/ingress-controller":         "istio",
+	"ingress-controllers.konghq.com/kong": "kong",
+	"ingress.k8s.aws/alb":                 "aws-alb",
+	"azure/application-gateway":           "azure-application-gateway",
+}
+
+// meshGroups are API groups of service meshes.
+var meshGroups = map[string]string{
+	"networking.istio.io":  "istio",
+	"linkerd.io":           "linkerd",
+	"policy.linkerd.io":    "linkerd",
+	"consul.hashicorp.com": "consul",
+	"kuma.io":              "kuma",
+}
+
+// gpuGroups are API groups of GPU operators.
+var gpuGroups = map[string]string{
+	"nvidia.com":             "nvidia",
+	"amd.com":                "amd",
+	"deviceplugin.intel.com": "intel",
+}
+
+// argoResources are the resources of the Argo projects in argoproj.io.
+var argoResources = map[string]string{
+	"applications": "cd",
+	"rollouts":     "rollouts",
+	"workflows":    "workflows",
+	"eventsources": "events",
+}
+
+// capabilities caches the platform components of contexts, which are
+// probed in the background.
+type capabilities struct {
+	mu      sync.Mutex
+	entries map[string]*ContextCapabilities
+}
+
+func (c *capabilities) get(name string) (*ContextCapabilities, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[strings.ToLower(name)]
+	return e, ok
+}
+
+func (c *capabilities) put(e *ContextCapabilities) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]*ContextCapabilities)
+	}
+
+	c.entries[strings.ToLower(e.Context)] = e
+}
+
+func (c *capabilities) list() []ContextCapabilities {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := []ContextCapabilities{}
+
+	for _, e := range c.entries {
+		result = append(result, *e)
+	}
+
+	slices.SortFunc(result, func(a, b ContextCapabilities) int {
+		return strings.Compare(a.Context, b.Context)
+	})
+
+	return result
+}
+
+// retain drops the entries of contexts that no longer exist.
+func (c *capabilities) retain(names []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.entries {
+		if !slices.ContainsFunc(names, func(name string) bool { return strings.EqualFold(name, key) }) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// probeCapabilities periodically detects the platform components of all
+// reachable contexts with the credentials of the bridge.
+func (s *Server) probeCapabilities(done <-chan struct{}) {
+	timer := time.NewTimer(capabilitiesDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+
+		names := s.kubernetesContextNames()
+
+		s.capabilities.retain(names)
+
+		sem := make(chan struct{}, 4)
+
+		var wg sync.WaitGroup
+
+		for _, name := range names {
+			if s.connectivity.offline(name) {
+				continue
+			}
+
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				ctx, cancel := context.WithTimeout(context.Background(), capabilitiesTimeout)
+				defer cancel()
+
+				if e := s.probeContextCapabilities(ctx, name, nil); e.Error != "" {
+					log.Printf("failed to probe capabilities of context %q: %s", name, e.Error)
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		timer.Reset(capabilitiesInterval)
+	}
+}
+
+// contextCapabilities returns the cached capabilities of a context, or
+// probes them if the background probe has not run yet.
+func (s *Server) contextCapabilities(ctx context.Context, name string, auth *config.AuthInfo) *ContextCapabilities {
+	if e, ok := s.capabilities.get(name); ok && e.Error == "" {
+		return e
+	}
+
+	return s.probeContextCapabilities(ctx, name, auth)
+}
+
+func (s *Server) probeContextCapabilities(ctx context.Context, name string, auth *config.AuthInfo) *ContextCapabilities {
+	result := &ContextCapabilities{
+		Context: name,
+		Probed:  time.Now(),
+
+		Ingress: []IngressController{},
+		Argo:    []string{},
+	}
+
+	client, err := s.kubernetesClient(ctx, name, auth)
+
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if err := detectCapabilities(ctx, client, result); err != nil {
+		result.Error = err.Error()
+
+		// the last complete result stays in place
+		if _, ok := s.capabilities.get(name); !ok {
+			s.capabilities.put(result)
+		}
+
+		return result
+	}
+
+	// configured endpoints take precedence over the detected ones
+	if e, ok := s.config.ContextPrometheus(name); ok && e.Service != "" {
+		result.Prometheus = endpointServiceRef(e)
+	}
+
+	if e, ok := s.config.ContextLoki(name); ok && e.Service != "" {
+		result.Loki = endpointServiceRef(e)
+	}
+
+	s.capabilities.put(result)
+
+	return result
+}
+
+func detectCapabilities(ctx context.Context, client *kubernetesClient, result *ContextCapabilities) error {
+	var groups metav1.APIGroupList
+
+	if err := client.get(ctx, "/apis", nil, &groups); err != nil {
+		return err
+	}
+
+	versions := map[string]string{}
+
+	for _, g := range groups.Groups {
+		versions[g.Name] = g.PreferredVersion.Version
+
+		if mesh, ok := meshGroups[g.Name]; ok {
+			result.Mesh = mesh
+		}
+
+		if gpu, ok := gpuGroups[g.Name]; ok && !slices.Contains(result.GPU, gpu) {
+			result.GPU = append(result.GPU, gpu)
+		}
+
+		if scheduler, ok := batchGroups[g.Name]; ok {
+			result.Batch = append(result.Batch, scheduler)
+		}
+	}
+
+	_, result.CertManager = versions["cert-manager.io"]
+	_, result.ClusterAPI = versions["cluster.x-k8s.io"]
+
+	if version, ok := versions["argoproj.io"]; ok {
+		var list metav1.APIResourceList
+
+		if err := client.get(ctx, "/apis/argoproj.io/"+version, nil, &list); err != nil {
+			return err
+		}
+
+		for _, r := range list.APIResources {
+			if project, ok := argoResources[r.Name]; ok {
+				result.Argo = append(result.Argo, project)
+			}
+		}
+
+		slices.Sort(result.Argo)
+	}
+
+	var classes networkingv1.IngressClassList
+
+	if err := client.get(ctx, "/apis/networking.k8s.io/v1/ingressclasses", nil, &classes); err != nil && statusCode(err) != http.StatusForbidden {
+		return err
+	}
+
+	for _, c := range classes.Items {
+		result.Ingress = append(result.Ingress, IngressController{
+			Class:      c.Name,
+			Controller: c.Spec.Controller,
+			Type:       ingressControllers[c.Spec.Controller],
+
+			Default: c.Annotations[networkingv1.AnnotationIsDefaultIngressClass] == "true",
+		})
+	}
+
+	if _, ok := versions["metrics.k8s.io"]; ok {
+		var discovery metav1.APIResourceList
+
+		// aggregated APIs are listed even while their server is unavailable
+		result.Metrics = client.get(ctx, "/apis/metrics.k8s.io/v1beta1", nil, &discovery) == nil
+	}
+
+	prometheus, err := findPrometheus(ctx, client)
+
+	if err != nil {
+		return err
+	}
+
+	if prometheus != nil {
+		result.Prometheus = serviceRef(prometheus, "web", 9090)
+	}
+
+	loki, err := findLoki(ctx, client)
+
+	if err != nil {
+		return err
+	}
+
+	if loki != nil {
+		result.Loki = serviceRef(loki, "http-metrics", 3100)
+	}
+
+	if slices.Contains(result.Argo, "cd") {
+		argocd, err := findService(ctx, client, "app.kubernetes.io/name=argocd-server")
+
+		if err != nil {
+			return err
+		}
+
+		if argocd != nil {
+			result.ArgoCD = serviceRef(argocd, "https", 443)
+		}
+	}
+
+	return nil
+}
+
+// findService returns the first service matching a label selector in any
+// namespace, or nil. Callers that may not list services find none.
+func findService(ctx context.Context, client *kubernetesClient, selector string) (*corev1.Service, error) {
+	var list corev1.ServiceList
+
+	query := url.Values{
+		"labelSelector": {selector},
+		"limit":         {"1"},
+	}
+
+	if err := client.get(ctx, "/api/v1/services", query, &list); err != nil {
+		if statusCode(err) == http.StatusForbidden {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if len(list.Items) == 0 {
+		return nil, nil
+	}
+
+	return &list.Items[0], nil
+}
+
+// serviceRef references a port of a service, preferring a port by name or
+// number.
+func serviceRef(service *corev1.Service, name string, number int32) *ServiceRef {
+	ref := &ServiceRef{
+		Namespace: service.Namespace,
+		Name:      service.Name,
+	}
+
+	for _, p := range service.Spec.Ports {
+		if p.Name == name || p.Port == number {
+			ref.Port = p.Port
+			ref.Scheme = p.Name
+			break
+		}
+	}
+
+	if ref.Port == 0 && len(service.Spec.Ports) > 0 {
+		ref.Port = service.Spec.Ports[0].Port
+		ref.Scheme = service.Spec.Ports[0].Name
+	}
+
+	if ref.Scheme != "https" {
+		ref.Scheme = "http"
+	}
+
+	if ref.Port == 443 {
+		ref.Scheme = "https"
+	}
+
+	return ref
+}
+
+// proxyPath returns the path of the service in the API server proxy.
+func (r *ServiceRef) proxyPath() string {
+	return "/api/v1/namespaces/" + r.Namespace + "/services/" + r.Scheme + ":" + r.Name + ":" + strconv.Itoa(int(r.Port)) + "/proxy"
+}
+
+func (s *Server) handleListCapabilities(w http.ResponseWriter, r *http.Request) {
+	result := s.capabilities.list()
+
+	writeList(w, r, "capabilities", result, result)
+}
+
+// handleCapabilities returns the platform components of a context. With
+// ?refresh=true the context is probed again.
+func (s *Server) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	c, ok := s.kubernetesContext(r.PathValue("context"))
+
+	if !ok {
+		writeError(w, r, errContextNotFound, http.StatusNotFound)
+		return
+	}
+
+	var result *ContextCapabilities
+
+	if r.URL.Query().Get("refresh") == "true" {
+		result = s.probeContextCapabilities(r.Context(), c.Name, auth)
+	} else {
+		result = s.contextCapabilities(r.Context(), c.Name, auth)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
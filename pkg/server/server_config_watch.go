@@ -0,0 +1,313 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// configWatchDelay debounces changes of the watched files, as tools write
+// them in several steps (e.g. az aks get-credentials, docker context create)
+const configWatchDelay = 500 * time.Millisecond
+
+// contextEvents notifies subscribers about added and removed contexts.
+type contextEvents struct {
+	mu          sync.Mutex
+	subscribers map[chan ContextsEvent]struct{}
+}
+
+func (e *contextEvents) publish(event ContextsEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for ch := range e.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (e *contextEvents) subscribe() (<-chan ContextsEvent, func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.subscribers == nil {
+		e.subscribers = make(map[chan ContextsEvent]struct{})
+	}
+
+	ch := make(chan ContextsEvent, 16)
+	e.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		delete(e.subscribers, ch)
+	}
+}
+
+// configEvents notifies subscribers about changes of the config detected in
+// the background, like features and Teleport sessions.
+type configEvents struct {
+	mu          sync.Mutex
+	subscribers map[chan configEvent]struct{}
+}
+
+type configEvent struct {
+	// name is the name of the server-sent event
+	name string
+	data any
+
+	// scoped events are only sent to callers with the credential, as
+	// features depend on the permissions
+	scoped     bool
+	credential string
+}
+
+func (e *configEvents) publish(event configEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for ch := range e.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (e *configEvents) subscribe() (<-chan configEvent, func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.subscribers == nil {
+		e.subscribers = make(map[chan configEvent]struct{})
+	}
+
+	ch := make(chan configEvent, 16)
+	e.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		delete(e.subscribers, ch)
+	}
+}
+
+// contextsChanged compares the context names before and after a reload.
+func contextsChanged(kind string, before, after []string, current string) (ContextsEvent, bool) {
+	event := ContextsEvent{
+		Type: kind,
+
+		Contexts:       []string{},
+		CurrentContext: current,
+
+		Time: time.Now().UTC(),
+	}
+
+	event.Contexts = append(event.Contexts, after...)
+
+	for _, name := range after {
+		if !slices.Contains(before, name) {
+			event.Added = append(event.Added, name)
+		}
+	}
+
+	for _, name := range before {
+		if !slices.Contains(after, name) {
+			event.Removed = append(event.Removed, name)
+		}
+	}
+
+	return event, len(event.Added) > 0 || len(event.Removed) > 0
+}
+
+// watchConfig reloads the contexts if the kubeconfig files or the docker
+// contexts change on disk. Editors and tools replace files rather than
+// writing them in place, so the directories holding them are watched.
+func (s *Server) watchConfig(done <-chan struct{}) {
+	watcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		log.Printf("unable to watch config: %v", err)
+		return
+	}
+
+	defer watcher.Close()
+
+	kubeconfigs := s.config.KubernetesFiles()
+
+	for i, path := range kubeconfigs {
+		kubeconfigs[i] = filepath.Clean(path)
+	}
+
+	dockerConfig, dockerContexts := config.DockerFiles()
+
+	watch := func() {
+		var dirs []string
+
+		for _, path := range kubeconfigs {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+
+		dirs = append(dirs, filepath.Dir(dockerConfig), dockerContexts)
+
+		// each docker context is stored in a directory of its own
+		entries, _ := os.ReadDir(dockerContexts)
+
+		for _, e := range entries {
+			if e.IsDir() {
+				dirs = append(dirs, filepath.Join(dockerContexts, e.Name()))
+			}
+		}
+
+		for _, dir := range dirs {
+			// missing directories are watched by their nearest parent
+			// until they are created
+			for {
+				if _, err := os.Stat(dir); err == nil {
+					break
+				}
+
+				parent := filepath.Dir(dir)
+
+				if parent == dir {
+					break
+				}
+
+				dir = parent
+			}
+
+			if !slices.Contains(watcher.WatchList(), dir) {
+				watcher.Add(dir)
+			}
+		}
+	}
+
+	watch()
+
+	var kubernetes, docker <-chan time.Time
+
+	for {
+		select {
+		case <-done:
+			return
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			path := filepath.Clean(event.Name)
+
+			if slices.ContainsFunc(kubeconfigs, func(file string) bool { return affects(path, file) }) {
+				kubernetes = time.After(configWatchDelay)
+			}
+
+			if affects(path, dockerConfig) || affects(path, dockerContexts) {
+				docker = time.After(configWatchDelay)
+			}
+
+			if event.Has(fsnotify.Create) {
+				watch()
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+
+			log.Printf("unable to watch config: %v", err)
+
+		case <-kubernetes:
+			kubernetes = nil
+
+			if err := s.ReloadKubernetes(); err != nil {
+				log.Printf("unable to reload kubeconfig: %v", err)
+			}
+
+		case <-docker:
+			docker = nil
+
+			if err := s.ReloadDocker(); err != nil {
+				log.Printf("unable to reload docker contexts: %v", err)
+			}
+		}
+	}
+}
+
+// affects reports whether a change of path affects target: the path is the
+// target, is within it, or is a directory created on the way to it (whose
+// content may be written before it is watched).
+func affects(path, target string) bool {
+	sep := string(filepath.Separator)
+
+	return path == target || strings.HasPrefix(path, target+sep) || strings.HasPrefix(target, strings.TrimSuffix(path, sep)+sep)
+}
+
+// handleContextEvents streams added and removed contexts as server-sent
+// events, so the context list can be refreshed without polling. Features and
+// Teleport sessions detected in the background for the config are streamed
+// as features and teleport events.
+func (s *Server) handleContextEvents(w http.ResponseWriter, r *http.Request) {
+	events, unsubscribe := s.contextEvents.subscribe()
+	defer unsubscribe()
+
+	updates, unsubscribeUpdates := s.configEvents.subscribe()
+	defer unsubscribeUpdates()
+
+	credential := credentialID(AuthInfoFromContext(r.Context()))
+
+	stream := &sseStream{
+		w:  w,
+		rc: http.NewResponseController(w),
+	}
+
+	stream.start()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-heartbeat.C:
+			stream.mu.Lock()
+			w.Write([]byte(": ping\n\n"))
+			stream.rc.Flush()
+			stream.mu.Unlock()
+
+		case event := <-events:
+			data, _ := json.Marshal(event)
+
+			if err := stream.send("", "contexts", data); err != nil {
+				return
+			}
+
+		case update := <-updates:
+			if update.scoped && update.credential != credential {
+				continue
+			}
+
+			data, _ := json.Marshal(update.data)
+
+			if err := stream.send("", update.name, data); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+const (
+	// connectivityProbeInterval is the interval in which offline contexts
+	// are probed for their return
+	connectivityProbeInterval = 5 * time.Second
+
+	// maxStaleSize is the size up to which a response is kept for offline
+	// use, the total size is capped by the limits
+	maxStaleSize = 4 << 20
+)
+
+// connectivity tracks which kubernetes contexts are reachable. Contexts go
+// offline on network errors of their transports (VPN drop, laptop sleep)
+// and online again with the next response, which the prober provokes.
+type connectivity struct {
+	mu sync.Mutex
+
+	states      map[string]*ContextConnectivity
+	subscribers map[chan ContextConnectivity]struct{}
+}
+
+func (c *connectivity) observe(context string, err error) {
+	if err != nil && !isNetworkError(err) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := strings.ToLower(context)
+	state, ok := c.states[key]
+
+	offline := err != nil
+
+	if ok && state.Offline == offline {
+		return
+	}
+
+	if !ok && !offline {
+		return
+	}
+
+	if c.states == nil {
+		c.states = make(map[string]*ContextConnectivity)
+	}
+
+	state = &ContextConnectivity{
+		Context: context,
+
+		Offline: offline,
+		Since:   time.Now().UTC(),
+	}
+
+	if offline {
+		state.Error = err.Error()
+		log.Printf("context %q is offline: %v", context, err)
+	} else {
+		log.Printf("context %q is online again", context)
+	}
+
+	c.states[key] = state
+
+	for ch := range c.subscribers {
+		select {
+		case ch <- *state:
+		default:
+		}
+	}
+}
+
+func (c *connectivity) offline(context string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, ok := c.states[strings.ToLower(context)]
+	return ok && state.Offline
+}
+
+func (c *connectivity) list() []ContextConnectivity {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := []ContextConnectivity{}
+
+	for _, state := range c.states {
+		result = append(result, *state)
+	}
+
+	slices.SortFunc(result, func(a, b ContextConnectivity) int {
+		return strings.Compare(a.Context, b.Context)
+	})
+
+	return result
+}
+
+func (c *connectivity) subscribe() (<-chan ContextConnectivity, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.subscribers == nil {
+		c.subscribers = make(map[chan ContextConnectivity]struct{})
+	}
+
+	ch := make(chan ContextConnectivity, 16)
+	c.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		delete(c.subscribers, ch)
+	}
+}
+
+// isNetworkError reports errors of unreachable upstreams; canceled requests
+// and errors of reachable upstreams (TLS, credentials) are not.
+func isNetworkError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// probeConnectivity pings offline contexts, so they come back online
+// without waiting for user requests.
+func (s *Server) probeConnectivity(done <-chan struct{}) {
+	ticker := time.NewTicker(connectivityProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+
+		case <-ticker.C:
+			for _, state := range s.connectivity.list() {
+				if !state.Offline {
+					continue
+				}
+
+				if c, ok := s.kubernetesContext(state.Context); ok {
+					go s.probe(c)
+				}
+			}
+		}
+	}
+}
+
+// probe requests the version of a context; any response brings it back
+// online through the transport.
+func (s *Server) probe(c config.KubernetesContext) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectivityProbeInterval)
+	defer cancel()
+
+	tr, target, err := s.kubernetesTransport(ctx, c, nil)
+
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(target.String(), "/")+"/version", nil)
+
+	if err != nil {
+		return
+	}
+
+	if resp, err := tr.RoundTrip(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+type staleKey struct{}
+
+// staleCache keeps the last responses of GET requests, which are served
+// marked as stale while their context is offline. The least recently used
+// responses are evicted beyond the size limit in bytes.
+type staleCache struct {
+	mu sync.Mutex
+
+	entries map[string]*list.Element
+	lru     list.List
+
+	size    int64
+	maxSize int64
+}
+
+type staleEntry struct {
+	key string
+
+	header http.Header
+	body   []byte
+
+	stored time.Time
+}
+
+// enabled reports whether responses are kept, which a size limit of zero
+// disables.
+func (c *staleCache) enabled() bool {
+	return c.maxSize > 0
+}
+
+// entryLimit is the size up to which a response is kept.
+func (c *staleCache) entryLimit() int64 {
+	return min(maxStaleSize, c.maxSize)
+}
+
+func (c *staleCache) get(key string) (*staleEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+
+	if !ok {
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+
+	return elem.Value.(*staleEntry), true
+}
+
+func (c *staleCache) put(key string, e *staleEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if int64(len(e.body)) > c.entryLimit() {
+		return
+	}
+
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+
+	e.key = key
+
+	c.entries[key] = c.lru.PushFront(e)
+	c.size += e.cost()
+
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops an entry; c.mu must be held.
+func (c *staleCache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*staleEntry)
+
+	delete(c.entries, e.key)
+	c.size -= e.cost()
+}
+
+// cost approximates the memory held by an entry.
+func (e *staleEntry) cost() int64 {
+	size := len(e.key) + len(e.body)
+
+	for k, values := range e.header {
+		size += len(k)
+
+		for _, v := range values {
+			size += len(v)
+		}
+	}
+
+	return int64(size)
+}
+
+// withStaleKey marks GET requests whose responses are kept for offline use.
+func withStaleKey(r *http.Request, name string, auth *config.AuthInfo) *http.Request {
+	if r.Method != http.MethodGet || isWatchRequest(r) || isStreamingRequest(r) || r.Header.Get("Upgrade") != "" {
+		return r
+	}
+
+	key := strings.Join([]string{strings.ToLower(name), credentialID(auth), r.URL.RequestURI(), r.Header.Get("Accept")}, "\x00")
+
+	return r.WithContext(context.WithValue(r.Context(), staleKey{}, key))
+}
+
+func staleKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(staleKey{}).(string)
+	return key, ok
+}
+
+// captureResponse keeps a copy of the final response body of marked
+// requests once it was read completely.
+func (s *Server) captureResponse(resp *http.Response) {
+	key, ok := staleKeyFromContext(resp.Request.Context())
+
+	if !ok || resp.StatusCode != http.StatusOK || resp.ContentLength > s.stale.entryLimit() {
+		return
+	}
+
+	header := resp.Header.Clone()
+
+	resp.Body = &captureBody{
+		ReadCloser: resp.Body,
+
+		limit: s.stale.entryLimit(),
+
+		done: func(body []byte) {
+			s.stale.put(key, &staleEntry{
+				header: header,
+				body:   body,
+
+				stored: time.Now(),
+			})
+		},
+	}
+}
+
+type captureBody struct {
+	io.ReadCloser
+
+	buf      bytes.Buffer
+	limit    int64
+	overflow bool
+
+	done func(body []byte)
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	if !b.overflow {
+		if int64(b.buf.Len()+n) > b.limit {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+
+	if errors.Is(err, io.EOF) && !b.overflow && b.done != nil {
+		b.done(b.buf.Bytes())
+		b.done = nil
+	}
+
+	return n, err
+}
+
+// serveOffline answers a request of an offline context with its last
+// response, marked as stale, or with a 503 the UI can retry.
+func (s *Server) serveOffline(w http.ResponseWriter, r *http.Request, name string) {
+	if key, ok := staleKeyFromContext(r.Context()); ok {
+		if e, ok := s.stale.get(key); ok {
+			for k, v := range e.header {
+				w.Header()[k] = v
+			}
+
+			w.Header().Del("Content-Length")
+			w.Header().Set("X-Bridge-Stale", "true")
+			w.Header().Set("Age", strconv.Itoa(int(time.Since(e.stored).Seconds())))
+			w.Header().Set("Warning", `110 bridge "Response is Stale"`)
+
+			w.WriteHeader(http.StatusOK)
+			w.Write(e.body)
+
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(connectivityProbeInterval.Seconds())))
+	w.Header().Set("X-Bridge-Error", ProblemContextUnreachable)
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Status",
+		"status":     "Failure",
+		"reason":     "ServiceUnavailable",
+		"message":    i18n.Translate(i18n.Negotiate(r), "error.context_offline", name),
+		"code":       http.StatusServiceUnavailable,
+	})
+}
+
+func (s *Server) handleConnectivity(w http.ResponseWriter, r *http.Request) {
+	result := s.connectivity.list()
+	writeList(w, r, "connectivity", result, result)
+}
+
+// handleConnectivityEvents streams offline and online transitions of
+// contexts as server-sent events, starting with the current state.
+func (s *Server) handleConnectivityEvents(w http.ResponseWriter, r *http.Request) {
+	events, unsubscribe := s.connectivity.subscribe()
+	defer unsubscribe()
+
+	stream := &sseStream{
+		w:  w,
+		rc: http.NewResponseController(w),
+	}
+
+	send := func(state ContextConnectivity) error {
+		event := "online"
+
+		if state.Offline {
+			event = "offline"
+		}
+
+		data, _ := json.Marshal(state)
+		return stream.send("", event, data)
+	}
+
+	stream.start()
+
+	for _, state := range s.connectivity.list() {
+		if state.Offline {
+			send(state)
+		}
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-heartbeat.C:
+			stream.mu.Lock()
+			w.Write([]byte(": ping\n\n"))
+			stream.rc.Flush()
+			stream.mu.Unlock()
+
+		case state := <-events:
+			if err := send(state); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaleCache(t *testing.T) {
+	entry := func(size int) *staleEntry {
+		return &staleEntry{
+			body:   []byte(strings.Repeat("x", size)),
+			stored: time.Now(),
+		}
+	}
+
+	c := &staleCache{maxSize: 300}
+
+	c.put("a", entry(99))
+	c.put("b", entry(99))
+	c.put("c", entry(99))
+
+	// a was used last, so b is evicted for d
+	c.get("a")
+	c.put("d", entry(99))
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if _, ok := c.get(key); ok != want {
+			t.Errorf("%s cached = %v, want %v", key, ok, want)
+		}
+	}
+
+	if c.size > c.maxSize {
+		t.Errorf("size %d exceeds %d", c.size, c.maxSize)
+	}
+
+	// replacing an entry accounts for its previous size, keys count too
+	c.put("a", entry(10))
+
+	if c.size != 100+100+11 {
+		t.Errorf("size = %d, want %d", c.size, 100+100+11)
+	}
+
+	c.put("large", entry(301))
+
+	if _, ok := c.get("large"); ok {
+		t.Error("expected an entry beyond the limit not to be cached")
+	}
+
+	disabled := &staleCache{}
+
+	if disabled.enabled() {
+		t.Error("expected a cache without size to be disabled")
+	}
+
+	disabled.put("a", entry(1))
+
+	if _, ok := disabled.get("a"); ok {
+		t.Error("expected a disabled cache to keep nothing")
+	}
+}
@@ -0,0 +1,454 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+func (s *Server) context(name string) (*Context, bool) {
+	if c, ok := s.kubernetesContext(name); ok {
+		return &Context{
+			Type: "kubernetes",
+			Name: c.Name,
+		}, true
+	}
+
+	if c, ok := s.dockerContext(name); ok {
+		return &Context{
+			Type: "docker",
+			Name: c.Name,
+		}, true
+	}
+
+	return nil, false
+}
+
+func (s *Server) kubernetesContext(name string) (config.KubernetesContext, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.config.Kubernetes == nil {
+		return config.KubernetesContext{}, false
+	}
+
+	for _, c := range s.config.Kubernetes.Contexts {
+		if strings.EqualFold(c.Name, name) {
+			return c, true
+		}
+	}
+
+	return config.KubernetesContext{}, false
+}
+
+// kubernetesContextNames returns the names of all kubernetes contexts.
+func (s *Server) kubernetesContextNames() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.config.Kubernetes == nil {
+		return nil
+	}
+
+	var result []string
+
+	for _, c := range s.config.Kubernetes.Contexts {
+		result = append(result, c.Name)
+	}
+
+	return result
+}
+
+// derivedContext returns the name of the context registered for a cluster
+// running in another context, if any.
+func (s *Server) derivedContext(origin *config.ContextOrigin) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.config.Kubernetes == nil {
+		return ""
+	}
+
+	for _, c := range s.config.Kubernetes.Contexts {
+		if c.Origin != nil && c.Origin.Kind == origin.Kind && strings.EqualFold(c.Origin.Context, origin.Context) && c.Origin.Namespace == origin.Namespace && c.Origin.Name == origin.Name {
+			return c.Name
+		}
+	}
+
+	return ""
+}
+
+func (s *Server) dockerContext(name string) (config.DockerContext, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.config.Docker == nil {
+		return config.DockerContext{}, false
+	}
+
+	for _, c := range s.config.Docker.Contexts {
+		if strings.EqualFold(c.Name, name) {
+			return c, true
+		}
+	}
+
+	return config.DockerContext{}, false
+}
+
+// ReloadKubernetes re-reads the kubeconfig, so clusters added after startup
+// become available without a restart. Connections are dropped for contexts
+// which were removed or changed only.
+func (s *Server) ReloadKubernetes() error {
+	k, err := s.config.LoadKubernetes()
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var before []string
+	var stale []string
+
+	if s.config.Kubernetes != nil {
+		for _, c := range s.config.Kubernetes.Contexts {
+			before = append(before, c.Name)
+
+			if c.Dynamic {
+				continue
+			}
+
+			// connections of removed or changed contexts are no longer valid
+			unchanged := slices.ContainsFunc(k.Contexts, func(n config.KubernetesContext) bool {
+				return n.Name == c.Name && n.Fingerprint == c.Fingerprint
+			})
+
+			if !unchanged {
+				stale = append(stale, c.Name)
+			}
+		}
+
+		k.TenancyLabels = s.config.Kubernetes.TenancyLabels
+		k.PlatformNamespaces = s.config.Kubernetes.PlatformNamespaces
+
+		for _, c := range s.config.Kubernetes.Contexts {
+			if c.Dynamic {
+				k.Contexts = append(k.Contexts, c)
+			}
+		}
+	}
+
+	s.config.Kubernetes = k
+
+	var after []string
+
+	for _, c := range k.Contexts {
+		after = append(after, c.Name)
+	}
+
+	if event, changed := contextsChanged("kubernetes", before, after, k.CurrentContext); changed {
+		for _, name := range event.Removed {
+			go s.release(name)
+		}
+
+		s.contextEvents.publish(event)
+	}
+
+	isStale := func(name string) bool {
+		return slices.ContainsFunc(stale, func(c string) bool {
+			return strings.EqualFold(c, name)
+		})
+	}
+
+	if len(stale) > 0 {
+		go s.transports.evict(func(t *pooledTransport) bool {
+			return strings.HasPrefix(t.key, "kubernetes/") && isStale(t.context)
+		})
+
+		go s.informers.evict(func(inf *informer) bool {
+			return isStale(inf.context)
+		})
+	}
+
+	return nil
+}
+
+// ReloadDocker re-reads the contexts of the docker CLI.
+func (s *Server) ReloadDocker() error {
+	d, err := s.config.LoadDocker()
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var before, after []string
+	var stale []string
+
+	if s.config.Docker != nil {
+		for _, c := range s.config.Docker.Contexts {
+			before = append(before, c.Name)
+
+			// endpoints of removed or changed contexts are no longer valid
+			if !slices.Contains(d.Contexts, c) {
+				stale = append(stale, "docker/"+strings.ToLower(c.Name))
+			}
+		}
+	}
+
+	for _, c := range d.Contexts {
+		after = append(after, c.Name)
+	}
+
+	s.config.Docker = d
+
+	if len(stale) > 0 {
+		go s.transports.evict(func(t *pooledTransport) bool {
+			return slices.Contains(stale, t.key)
+		})
+	}
+
+	if event, changed := contextsChanged("docker", before, after, d.CurrentContext); changed {
+		s.contextEvents.publish(event)
+	}
+
+	return nil
+}
+
+var errContextExists = i18n.NewError("error.context_exists")
+
+// AddKubernetesContext registers a context at runtime.
+func (s *Server) AddKubernetesContext(c config.KubernetesContext) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.config.Kubernetes == nil {
+		s.config.Kubernetes = &config.KubernetesConfig{}
+	}
+
+	for _, existing := range s.config.Kubernetes.Contexts {
+		if strings.EqualFold(existing.Name, c.Name) {
+			return errContextExists
+		}
+	}
+
+	if s.config.Docker != nil {
+		for _, existing := range s.config.Docker.Contexts {
+			if strings.EqualFold(existing.Name, c.Name) {
+				return errContextExists
+			}
+		}
+	}
+
+	s.config.Kubernetes.Contexts = append(s.config.Kubernetes.Contexts, c)
+
+	if s.config.Kubernetes.CurrentContext == "" {
+		s.config.Kubernetes.CurrentContext = c.Name
+	}
+
+	return nil
+}
+
+// RemoveKubernetesContext unregisters a context that was added at runtime.
+func (s *Server) RemoveKubernetesContext(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.config.Kubernetes == nil {
+		return false
+	}
+
+	for i, c := range s.config.Kubernetes.Contexts {
+		if !c.Dynamic || !strings.EqualFold(c.Name, name) {
+			continue
+		}
+
+		s.config.Kubernetes.Contexts = append(s.config.Kubernetes.Contexts[:i], s.config.Kubernetes.Contexts[i+1:]...)
+
+		if strings.EqualFold(s.config.Kubernetes.CurrentContext, name) {
+			s.config.Kubernetes.CurrentContext = ""
+		}
+
+		go s.release(c.Name)
+
+		return true
+	}
+
+	return false
+}
+
+func (s *Server) handleCreateContext(w http.ResponseWriter, r *http.Request) {
+	var req ContextRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if strings.Contains(req.Name, "/") {
+		http.Error(w, "context name must not contain slashes", http.StatusBadRequest)
+		return
+	}
+
+	var c config.KubernetesContext
+	var err error
+
+	switch {
+	case req.Kubeconfig != "":
+		c, err = config.KubernetesContextFromKubeconfig(req.Name, []byte(req.Kubeconfig))
+
+	case req.Server != "":
+		c, err = config.KubernetesContextFromToken(req.Name, req.Server, req.Token, req.CertificateAuthorityData, req.InsecureSkipTLSVerify)
+
+	case req.Cloud != nil:
+		c, err = config.KubernetesContextFromCloud(r.Context(), req.Name, config.CloudCluster{
+			Provider: req.Cloud.Provider,
+
+			Name: req.Cloud.Cluster,
+
+			Region:        req.Cloud.Region,
+			Project:       req.Cloud.Project,
+			ResourceGroup: req.Cloud.ResourceGroup,
+		})
+
+	default:
+		err = errors.New("one of kubeconfig, server or cloud is required")
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.Owner = ownerID(AuthInfoFromContext(r.Context()))
+
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+
+		if err != nil || ttl <= 0 {
+			http.Error(w, "invalid ttl", http.StatusBadRequest)
+			return
+		}
+
+		c.Expires = time.Now().Add(ttl)
+	}
+
+	if err := s.AddKubernetesContext(c); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	info := &ContextInfo{
+		Type: "kubernetes",
+		Name: c.Name,
+	}
+
+	if !c.Expires.IsZero() {
+		info.Expires = &c.Expires
+	}
+
+	json.NewEncoder(w).Encode(info)
+}
+
+// derivedContextRequest reads the optional request to connect to a cluster
+// running in another context, named defaultName unless the request names it.
+func derivedContextRequest(w http.ResponseWriter, r *http.Request, defaultName string) (ContextRequest, bool) {
+	var req ContextRequest
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return req, false
+		}
+	}
+
+	if req.Name == "" {
+		req.Name = defaultName
+	}
+
+	if strings.Contains(req.Name, "/") {
+		http.Error(w, "context name must not contain slashes", http.StatusBadRequest)
+		return req, false
+	}
+
+	if req.TTL != "" {
+		if ttl, err := time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+			http.Error(w, "invalid ttl", http.StatusBadRequest)
+			return req, false
+		}
+	}
+
+	return req, true
+}
+
+// addDerivedContext registers the context of a cluster running in another
+// context for the caller and reports whether it was added.
+func (s *Server) addDerivedContext(w http.ResponseWriter, r *http.Request, c config.KubernetesContext, req ContextRequest) bool {
+	auth := AuthInfoFromContext(r.Context())
+
+	c.Owner = ownerID(auth)
+
+	if req.TTL != "" {
+		ttl, _ := time.ParseDuration(req.TTL)
+		c.Expires = time.Now().Add(ttl)
+	}
+
+	if err := s.AddKubernetesContext(c); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return false
+	}
+
+	s.audit.record(&AuditEntry{
+		Context: c.Origin.Context,
+		Owner:   ownerID(auth),
+		Action:  "connect",
+
+		Resource:  c.Origin.Kind,
+		Namespace: c.Origin.Namespace,
+		Name:      c.Origin.Name,
+	})
+
+	info := &ContextInfo{
+		Type: "kubernetes",
+		Name: c.Name,
+	}
+
+	if !c.Expires.IsZero() {
+		info.Expires = &c.Expires
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	json.NewEncoder(w).Encode(info)
+
+	return true
+}
+
+func (s *Server) handleDeleteContext(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("context")
+
+	if c, ok := s.kubernetesContext(name); ok && c.Owner != "" && c.Owner != ownerID(AuthInfoFromContext(r.Context())) {
+		http.Error(w, "context is owned by another session", http.StatusForbidden)
+		return
+	}
+
+	if !s.RemoveKubernetesContext(name) {
+		http.Error(w, "context not found or not removable", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
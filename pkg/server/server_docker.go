@@ -4,7 +4,6 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"net/http"
@@ -19,19 +18,45 @@ import (
 )
 
 func (s *Server) dockerProxy(ctx context.Context, name string, auth *config.AuthInfo) (http.Handler, error) {
-	for _, c := range s.config.Docker.Contexts {
-		if !strings.EqualFold(c.Name, name) {
-			continue
+	if c, ok := s.dockerContext(name); ok {
+		tr, target, err := s.dockerTransport(c)
+
+		if err != nil {
+			return nil, err
+		}
+
+		proxy := &httputil.ReverseProxy{
+			Transport: tr,
+
+			// flush every write, so followed logs and events stream
+			FlushInterval: -1,
+
+			ErrorLog: log.New(log.Writer(), "proxy: ", log.LstdFlags),
+
+			Rewrite: func(r *httputil.ProxyRequest) {
+				r.SetURL(target)
+				r.Out.Host = target.Host
+			},
 		}
 
+		return proxy, nil
+	}
+
+	return nil, fmt.Errorf("docker context not found")
+}
+
+func (s *Server) dockerTransport(c config.DockerContext) (http.RoundTripper, *url.URL, error) {
+	key := "docker/" + strings.ToLower(c.Name)
+
+	t, err := s.transports.get(key, c.Name, func(t *pooledTransport) error {
 		u, err := url.Parse(c.Host)
 
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		var tr http.RoundTripper
-		var target *url.URL
+		// private hosts are reached through the cloud tunnel of the context
+		dial, _ := s.tunnelDialer("docker", c.Name)
 
 		switch u.Scheme {
 		case "unix":
@@ -42,24 +67,23 @@ func (s *Server) dockerProxy(ctx context.Context, name string, auth *config.Auth
 			}
 
 			if _, err := os.Stat(socketPath); err != nil {
-				return nil, fmt.Errorf("docker socket not found: %w", err)
+				return fmt.Errorf("docker socket not found: %w", err)
 			}
 
-			tr = &http.Transport{
-				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-					return net.Dial("unix", socketPath)
-				},
-			}
+			t.base = newTransport(t, func(ctx context.Context, network, addr string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			}, nil, false)
 
-			target = &url.URL{
+			t.target = &url.URL{
 				Scheme: "http",
 				Host:   "localhost",
 			}
 
 		case "tcp", "http":
-			tr = &http.Transport{}
+			t.base = newTransport(t, dial, nil, false)
 
-			target = &url.URL{
+			t.target = &url.URL{
 				Scheme: "http",
 				Host:   u.Host,
 			}
@@ -74,7 +98,7 @@ func (s *Server) dockerProxy(ctx context.Context, name string, auth *config.Auth
 				)
 
 				if err != nil {
-					return nil, err
+					return err
 				}
 
 				tlsConfig.Certificates = []tls.Certificate{cert}
@@ -84,11 +108,9 @@ func (s *Server) dockerProxy(ctx context.Context, name string, auth *config.Auth
 				}
 			}
 
-			tr = &http.Transport{
-				TLSClientConfig: tlsConfig,
-			}
+			t.base = newTransport(t, dial, tlsConfig, false)
 
-			target = &url.URL{
+			t.target = &url.URL{
 				Scheme: "https",
 				Host:   u.Host,
 			}
@@ -97,37 +119,39 @@ func (s *Server) dockerProxy(ctx context.Context, name string, auth *config.Auth
 			sshClient, err := ssh.New(u)
 
 			if err != nil {
-				return nil, err
+				return err
 			}
 
-			tr = &http.Transport{
-				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-					return sshClient.Dial("unix", "/var/run/docker.sock")
-				},
-			}
+			t.closer = sshClient
+
+			t.base = newTransport(t, func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := sshClient.Dial("unix", "/var/run/docker.sock")
+
+				if err != nil {
+					// the tunnel is likely broken, reconnect on the next request
+					go s.transports.evictPrefix(key)
+				}
+
+				return conn, err
+			}, nil, false)
 
-			target = &url.URL{
+			t.target = &url.URL{
 				Scheme: "http",
 				Host:   "localhost",
 			}
 
 		default:
-			return nil, fmt.Errorf("unsupported docker context scheme: %s", u.Scheme)
+			return fmt.Errorf("unsupported docker context scheme: %s", u.Scheme)
 		}
 
-		proxy := &httputil.ReverseProxy{
-			Transport: tr,
-
-			ErrorLog: log.New(io.Discard, "", 0),
+		t.transport = t.base
 
-			Rewrite: func(r *httputil.ProxyRequest) {
-				r.SetURL(target)
-				r.Out.Host = target.Host
-			},
-		}
+		return nil
+	})
 
-		return proxy, nil
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return nil, fmt.Errorf("docker context not found")
+	return t, t.target, nil
 }
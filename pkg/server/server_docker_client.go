@@ -0,0 +1,159 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// dockerClient issues JSON requests against the daemon of a docker context,
+// sharing the pooled transport of the proxy.
+type dockerClient struct {
+	transport http.RoundTripper
+	target    *url.URL
+}
+
+func (s *Server) dockerClient(name string) (*dockerClient, error) {
+	c, ok := s.dockerContext(name)
+
+	if !ok {
+		return nil, errContextNotFound
+	}
+
+	tr, target, err := s.dockerTransport(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &dockerClient{
+		transport: tr,
+		target:    target,
+	}, nil
+}
+
+// defaultDockerContext returns the current docker context, or the only one.
+func (s *Server) defaultDockerContext() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.config.Docker == nil {
+		return ""
+	}
+
+	if s.config.Docker.CurrentContext != "" {
+		return s.config.Docker.CurrentContext
+	}
+
+	if len(s.config.Docker.Contexts) == 1 {
+		return s.config.Docker.Contexts[0].Name
+	}
+
+	return ""
+}
+
+func (c *dockerClient) get(ctx context.Context, path string, query url.Values, out any) error {
+	return c.do(ctx, http.MethodGet, path, query, nil, nil, out)
+}
+
+func (c *dockerClient) post(ctx context.Context, path string, query url.Values, in, out any) error {
+	var data []byte
+
+	if in != nil {
+		var err error
+
+		if data, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	return c.do(ctx, http.MethodPost, path, query, nil, data, out)
+}
+
+// do sends a request and decodes a JSON response into out (if not nil).
+// Non-2xx responses are returned as *upstreamError.
+func (c *dockerClient) do(ctx context.Context, method, path string, query url.Values, header http.Header, data []byte, out any) error {
+	resp, err := c.open(ctx, method, path, query, header, data)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// open sends a request and returns the response for streaming endpoints.
+func (c *dockerClient) open(ctx context.Context, method, path string, query url.Values, header http.Header, data []byte) (*http.Response, error) {
+	u := *c.target
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+
+	var body io.Reader
+
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.transport.RoundTrip(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+		return nil, &upstreamError{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header,
+			Body:       body,
+		}
+	}
+
+	return resp, nil
+}
+
+// dockerMessage extracts the message of a docker API error.
+func dockerMessage(err error) string {
+	var e *upstreamError
+
+	if errors.As(err, &e) {
+		var body struct {
+			Message string `json:"message"`
+		}
+
+		if json.Unmarshal(e.Body, &body) == nil && body.Message != "" {
+			return body.Message
+		}
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,301 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+// hostMetricsScript samples /proc twice for the CPU usage and reports the
+// usage of the file system holding the docker root directory. Sections are
+// separated by @@ lines.
+const hostMetricsScript = `head -n1 /proc/stat; sleep 0.5; head -n1 /proc/stat
+echo @@; cat /proc/loadavg
+echo @@; cat /proc/meminfo
+echo @@; df -kP %s 2>/dev/null | tail -n1`
+
+// dockerSSHClient returns the ssh connection of the pooled transport of an
+// ssh docker context.
+func (s *Server) dockerSSHClient(c config.DockerContext) (*gossh.Client, error) {
+	tr, _, err := s.dockerTransport(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if t, ok := tr.(*pooledTransport); ok {
+		if client, ok := t.closer.(*gossh.Client); ok {
+			return client, nil
+		}
+	}
+
+	return nil, errors.New("not an ssh docker context")
+}
+
+// handleHostMetrics reports CPU, memory and disk usage of a docker host.
+// Daemon level numbers come from the docker API; for ssh contexts the live
+// host usage is read from /proc on the remote host.
+func (s *Server) handleHostMetrics(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.dockerContext(r.PathValue("context"))
+
+	if !ok {
+		writeError(w, r, errContextNotFound, http.StatusNotFound)
+		return
+	}
+
+	client, err := s.dockerClient(c.Name)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	var info struct {
+		NCPU     int   `json:"NCPU"`
+		MemTotal int64 `json:"MemTotal"`
+
+		Containers        int `json:"Containers"`
+		ContainersRunning int `json:"ContainersRunning"`
+		Images            int `json:"Images"`
+
+		DockerRootDir   string `json:"DockerRootDir"`
+		OperatingSystem string `json:"OperatingSystem"`
+		KernelVersion   string `json:"KernelVersion"`
+		ServerVersion   string `json:"ServerVersion"`
+	}
+
+	if err := client.get(ctx, "/info", nil, &info); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	metrics := &HostMetrics{
+		Context: c.Name,
+		Source:  "docker",
+
+		OperatingSystem: info.OperatingSystem,
+		KernelVersion:   info.KernelVersion,
+		DockerVersion:   info.ServerVersion,
+
+		CPUs:        info.NCPU,
+		MemoryTotal: info.MemTotal,
+
+		Containers:        info.Containers,
+		ContainersRunning: info.ContainersRunning,
+		Images:            info.Images,
+
+		DiskPath: info.DockerRootDir,
+	}
+
+	if usage, err := dockerDiskUsage(ctx, client); err == nil {
+		metrics.DockerDisk = usage
+	} else {
+		metrics.Errors = append(metrics.Errors, "disk usage: "+dockerMessage(err))
+	}
+
+	if u, err := url.Parse(c.Host); err == nil && u.Scheme == "ssh" {
+		if err := s.collectSSHMetrics(c, metrics); err != nil {
+			metrics.Errors = append(metrics.Errors, "host: "+err.Error())
+		} else {
+			metrics.Source = "ssh"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metrics)
+}
+
+func dockerDiskUsage(ctx context.Context, client *dockerClient) (*DockerDiskUsage, error) {
+	var df struct {
+		LayersSize int64 `json:"LayersSize"`
+
+		Containers []struct {
+			SizeRw int64 `json:"SizeRw"`
+		} `json:"Containers"`
+
+		Volumes []struct {
+			UsageData struct {
+				Size int64 `json:"Size"`
+			} `json:"UsageData"`
+		} `json:"Volumes"`
+
+		BuildCache []struct {
+			Size int64 `json:"Size"`
+		} `json:"BuildCache"`
+	}
+
+	if err := client.get(ctx, "/system/df", nil, &df); err != nil {
+		return nil, err
+	}
+
+	usage := &DockerDiskUsage{
+		Images: df.LayersSize,
+	}
+
+	for _, c := range df.Containers {
+		usage.Containers += c.SizeRw
+	}
+
+	for _, v := range df.Volumes {
+		// -1 if the size was not calculated
+		usage.Volumes += max(v.UsageData.Size, 0)
+	}
+
+	for _, b := range df.BuildCache {
+		usage.BuildCache += b.Size
+	}
+
+	return usage, nil
+}
+
+func (s *Server) collectSSHMetrics(c config.DockerContext, metrics *HostMetrics) error {
+	client, err := s.dockerSSHClient(c)
+
+	if err != nil {
+		return err
+	}
+
+	session, err := client.NewSession()
+
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	path := metrics.DiskPath
+
+	if path == "" {
+		path = "/"
+	}
+
+	output, err := session.Output(fmt.Sprintf(hostMetricsScript, shellQuote(path)))
+
+	if err != nil {
+		return err
+	}
+
+	sections := strings.Split(string(output), "@@\n")
+
+	if len(sections) < 4 {
+		return errors.New("unexpected output of metrics script")
+	}
+
+	stat := strings.Split(strings.TrimSpace(sections[0]), "\n")
+
+	if len(stat) == 2 {
+		metrics.CPUUsage = cpuUsage(stat[0], stat[1])
+	}
+
+	if load := strings.Fields(sections[1]); len(load) >= 3 {
+		metrics.Load = []float64{}
+
+		for _, v := range load[:3] {
+			f, _ := strconv.ParseFloat(v, 64)
+			metrics.Load = append(metrics.Load, f)
+		}
+	}
+
+	meminfo := parseMeminfo(sections[2])
+
+	if v, ok := meminfo["MemTotal"]; ok {
+		metrics.MemoryTotal = v
+	}
+
+	if v, ok := meminfo["MemAvailable"]; ok {
+		metrics.MemoryAvailable = &v
+	}
+
+	// Filesystem 1024-blocks Used Available Capacity Mounted-on
+	if df := strings.Fields(sections[3]); len(df) >= 6 {
+		total, _ := strconv.ParseInt(df[1], 10, 64)
+		used, _ := strconv.ParseInt(df[2], 10, 64)
+
+		metrics.DiskTotal = total * 1024
+		metrics.DiskUsed = used * 1024
+		metrics.DiskPath = df[5]
+	}
+
+	return nil
+}
+
+// cpuUsage returns the busy percentage between two "cpu" lines of /proc/stat.
+func cpuUsage(before, after string) float64 {
+	parse := func(line string) (idle, total float64) {
+		fields := strings.Fields(line)
+
+		for i, f := range fields[1:] {
+			v, _ := strconv.ParseFloat(f, 64)
+			total += v
+
+			// idle and iowait
+			if i == 3 || i == 4 {
+				idle += v
+			}
+		}
+
+		return idle, total
+	}
+
+	idle1, total1 := parse(before)
+	idle2, total2 := parse(after)
+
+	if total2 <= total1 {
+		return 0
+	}
+
+	return 100 * (1 - (idle2-idle1)/(total2-total1))
+}
+
+// parseMeminfo returns the /proc/meminfo values in bytes.
+func parseMeminfo(data string) map[string]int64 {
+	result := make(map[string]int64)
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+
+		if !ok {
+			continue
+		}
+
+		fields := strings.Fields(value)
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		v, err := strconv.ParseInt(fields[0], 10, 64)
+
+		if err != nil {
+			continue
+		}
+
+		if len(fields) > 1 && fields[1] == "kB" {
+			v *= 1024
+		}
+
+		result[key] = v
+	}
+
+	return result
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
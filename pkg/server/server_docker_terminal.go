@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/adrianliechti/bridge/pkg/ssh"
+)
+
+var terminalUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// terminalMessage is a control message of the terminal protocol. Terminal
+// input and output are sent as binary messages.
+type terminalMessage struct {
+	Type string `json:"type"`
+
+	Cols int `json:"cols,omitempty"`
+	Rows int `json:"rows,omitempty"`
+}
+
+// handleHostTerminal opens an interactive shell on the host of an ssh docker
+// context over a WebSocket. The initial size is taken from ?cols=&rows=,
+// later changes are sent as {"type":"resize","cols":..,"rows":..}.
+func (s *Server) handleHostTerminal(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	c, ok := s.dockerContext(r.PathValue("context"))
+
+	if !ok {
+		writeError(w, r, errContextNotFound, http.StatusNotFound)
+		return
+	}
+
+	u, err := url.Parse(c.Host)
+
+	if err != nil || u.Scheme != "ssh" {
+		http.Error(w, "host terminals require an ssh docker context", http.StatusBadRequest)
+		return
+	}
+
+	w, r, done, err := s.trackSession(w, r, &Context{Type: "docker", Name: c.Name}, auth)
+
+	if err != nil {
+		writeError(w, r, err, http.StatusTooManyRequests)
+		return
+	}
+
+	defer done()
+
+	client, err := ssh.New(u)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	release := s.track(c.Name, client)
+	defer release()
+
+	session, err := client.NewSession()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	defer session.Close()
+
+	cols, _ := strconv.Atoi(r.URL.Query().Get("cols"))
+	rows, _ := strconv.Atoi(r.URL.Query().Get("rows"))
+
+	if cols <= 0 || rows <= 0 {
+		cols, rows = 80, 24
+	}
+
+	modes := gossh.TerminalModes{
+		gossh.ECHO:          1,
+		gossh.TTY_OP_ISPEED: 14400,
+		gossh.TTY_OP_OSPEED: 14400,
+	}
+
+	if err := session.RequestPty("xterm-256color", rows, cols, modes); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	stdin, err := session.StdinPipe()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := terminalUpgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	var mu sync.Mutex
+
+	output := writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err := conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+			return 0, err
+		}
+
+		return len(p), nil
+	})
+
+	session.Stdout = output
+	session.Stderr = output
+
+	if err := session.Shell(); err != nil {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error()))
+		return
+	}
+
+	s.audit.record(&AuditEntry{
+		Context: c.Name,
+		Owner:   ownerID(auth),
+		Action:  "host-terminal",
+	})
+
+	go func() {
+		// ends the shell if the session is killed or the client disconnects
+		<-r.Context().Done()
+		conn.Close()
+		session.Close()
+	}()
+
+	go func() {
+		defer session.Close()
+
+		for {
+			kind, data, err := conn.ReadMessage()
+
+			if err != nil {
+				return
+			}
+
+			if kind == websocket.BinaryMessage {
+				if _, err := stdin.Write(data); err != nil {
+					return
+				}
+
+				continue
+			}
+
+			var message terminalMessage
+
+			if err := json.Unmarshal(data, &message); err != nil {
+				continue
+			}
+
+			if message.Type == "resize" && message.Cols > 0 && message.Rows > 0 {
+				session.WindowChange(message.Rows, message.Cols)
+			}
+		}
+	}()
+
+	err = session.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	message := "shell exited"
+
+	if err != nil {
+		message = err.Error()
+	}
+
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, message))
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/dockertest"
+)
+
+// dockerTestContext is the name of the docker context of the fake daemon.
+const dockerTestContext = "docker"
+
+// newDockerTestServer returns a server with a docker context of a fake
+// daemon with two running containers.
+func newDockerTestServer(t *testing.T) (*Server, *dockertest.Server) {
+	t.Helper()
+
+	t.Setenv("BRIDGE_HOME", t.TempDir())
+	t.Setenv("BRIDGE_STORE_KEY", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+
+	daemon := dockertest.NewServer(2)
+	t.Cleanup(daemon.Close)
+
+	s, err := New(&config.Config{
+		Docker: &config.DockerConfig{
+			Contexts: []config.DockerContext{
+				{Name: dockerTestContext, Host: daemon.Host()},
+			},
+		},
+
+		KeepAliveInterval: time.Minute,
+
+		Limits: config.LimitsConfig{
+			MaxSessions:        8,
+			MaxSessionsPerUser: 8,
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	return s, daemon
+}
+
+func TestDockerProxy(t *testing.T) {
+	s, _ := newDockerTestServer(t)
+
+	prefix := "/contexts/" + dockerTestContext
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"ping", http.MethodGet, prefix + "/_ping", http.StatusOK, "OK"},
+		{"versioned", http.MethodGet, prefix + "/v1.47/version", http.StatusOK, `"ApiVersion":"1.47"`},
+		{"containers", http.MethodGet, prefix + "/containers/json", http.StatusOK, `"/container-1"`},
+		{"query", http.MethodGet, prefix + "/containers/json?all=1", http.StatusOK, `"/container-0"`},
+		{"container", http.MethodGet, prefix + "/containers/container-0/json", http.StatusOK, `"Running":true`},
+		{"unknown container", http.MethodGet, prefix + "/containers/missing/json", http.StatusNotFound, "No such container"},
+		{"unknown context", http.MethodGet, "/contexts/missing/containers/json", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(s, tt.method, tt.path, "", nil)
+			expectStatus(t, w, tt.status)
+
+			if !strings.Contains(w.Body.String(), tt.body) {
+				t.Fatalf("expected %q in %s", tt.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDockerContainerLifecycle(t *testing.T) {
+	s, _ := newDockerTestServer(t)
+
+	prefix := "/contexts/" + dockerTestContext
+
+	w := serve(s, http.MethodPost, prefix+"/containers/create?name=web", `{"Image":"nginx:1.27"}`, nil)
+	expectStatus(t, w, http.StatusCreated)
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || created.ID == "" {
+		t.Fatalf("unexpected create response %s", w.Body.String())
+	}
+
+	state := func() string {
+		t.Helper()
+
+		w := serve(s, http.MethodGet, prefix+"/containers/"+created.ID+"/json", "", nil)
+
+		if w.Code == http.StatusNotFound {
+			return "removed"
+		}
+
+		expectStatus(t, w, http.StatusOK)
+
+		var container struct {
+			State struct {
+				Status string `json:"Status"`
+			} `json:"State"`
+		}
+
+		json.Unmarshal(w.Body.Bytes(), &container)
+
+		return container.State.Status
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		state  string
+	}{
+		{"duplicate", http.MethodPost, "/containers/create?name=web", http.StatusConflict, "exited"},
+		{"start", http.MethodPost, "/containers/web/start", http.StatusNoContent, "running"},
+		{"start again", http.MethodPost, "/containers/web/start", http.StatusNotModified, "running"},
+		{"remove running", http.MethodDelete, "/containers/web", http.StatusConflict, "running"},
+		{"stop", http.MethodPost, "/containers/web/stop", http.StatusNoContent, "exited"},
+		{"remove", http.MethodDelete, "/containers/web", http.StatusNoContent, "removed"},
+		{"remove again", http.MethodDelete, "/containers/web", http.StatusNotFound, "removed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body string
+
+			if tt.method == http.MethodPost && strings.HasPrefix(tt.path, "/containers/create") {
+				body = `{"Image":"nginx:1.27"}`
+			}
+
+			w := serve(s, tt.method, prefix+tt.path, body, nil)
+			expectStatus(t, w, tt.status)
+
+			if got := state(); got != tt.state {
+				t.Fatalf("state = %s, want %s", got, tt.state)
+			}
+		})
+	}
+}
+
+func TestDockerAttach(t *testing.T) {
+	s, _ := newDockerTestServer(t)
+
+	bridge := httptest.NewServer(s)
+	defer bridge.Close()
+
+	conn, err := net.Dial("tcp", bridge.Listener.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	fmt.Fprintf(conn, "POST /contexts/%s/containers/container-0/attach?stream=1&stdin=1&stdout=1 HTTP/1.1\r\n", dockerTestContext)
+	fmt.Fprintf(conn, "Host: %s\r\n", bridge.Listener.Addr())
+	fmt.Fprint(conn, "Connection: Upgrade\r\n")
+	fmt.Fprint(conn, "Upgrade: tcp\r\n")
+	fmt.Fprint(conn, "\r\n")
+
+	reader := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(reader, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols || !strings.EqualFold(resp.Header.Get("Upgrade"), "tcp") {
+		t.Fatalf("status = %d, upgrade = %q, want a hijacked tcp stream", resp.StatusCode, resp.Header.Get("Upgrade"))
+	}
+
+	// the attached stream is tracked as a session
+	if sessions := s.sessions.list(); len(sessions) != 1 || sessions[0].Kind != "exec" {
+		t.Fatalf("expected an exec session, got %d sessions", len(sessions))
+	}
+
+	for _, input := range []string{"ls\n", "exit\n"} {
+		if _, err := io.WriteString(conn, input); err != nil {
+			t.Fatal(err)
+		}
+
+		output := make([]byte, len(input))
+
+		if _, err := io.ReadFull(reader, output); err != nil {
+			t.Fatal(err)
+		}
+
+		if string(output) != input {
+			t.Fatalf("output = %q, want %q", output, input)
+		}
+	}
+
+	conn.Close()
+
+	// the session ends with the stream
+	deadline := time.Now().Add(5 * time.Second)
+
+	for len(s.sessions.list()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the session to end with the stream")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDockerAttachStopped(t *testing.T) {
+	s, _ := newDockerTestServer(t)
+
+	prefix := "/contexts/" + dockerTestContext
+
+	expectStatus(t, serve(s, http.MethodPost, prefix+"/containers/container-1/stop", "", nil), http.StatusNoContent)
+
+	header := http.Header{
+		"Connection": {"Upgrade"},
+		"Upgrade":    {"tcp"},
+	}
+
+	w := serve(s, http.MethodPost, prefix+"/containers/container-1/attach?stream=1", "", header)
+	expectStatus(t, w, http.StatusConflict)
+
+	if len(s.sessions.list()) != 0 {
+		t.Fatal("expected the session of the failed attach to end")
+	}
+}
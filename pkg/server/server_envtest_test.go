@@ -0,0 +1,441 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+// The integration tests run against the API server and etcd of envtest,
+// which are installed with setup-envtest, e.g.
+//
+//	export KUBEBUILDER_ASSETS=$(setup-envtest use -p path)
+//
+// They are skipped if KUBEBUILDER_ASSETS is not set.
+
+// envtestContext is the name of the context in the kubeconfig of envtest.
+const envtestContext = "envtest"
+
+var envtestKubeconfig []byte
+
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		return m.Run()
+	}
+
+	env := &envtest.Environment{}
+
+	if _, err := env.Start(); err != nil {
+		log.Println("envtest:", err)
+		return 1
+	}
+
+	defer env.Stop()
+
+	user, err := env.AddUser(envtest.User{Name: "bridge", Groups: []string{"system:masters"}}, nil)
+
+	if err != nil {
+		log.Println("envtest:", err)
+		return 1
+	}
+
+	envtestKubeconfig, err = user.KubeConfig()
+
+	if err != nil {
+		log.Println("envtest:", err)
+		return 1
+	}
+
+	return m.Run()
+}
+
+// newEnvtestServer returns a server for the kubeconfig of envtest, which is
+// written to the returned path. configure may adjust the config before the
+// server is created.
+func newEnvtestServer(t *testing.T, configure func(cfg *config.Config)) (*Server, string) {
+	t.Helper()
+
+	if envtestKubeconfig == nil {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+
+	dir := t.TempDir()
+
+	t.Setenv("BRIDGE_HOME", dir)
+	t.Setenv("BRIDGE_CONTEXTS", "")
+	t.Setenv("BRIDGE_EXCLUDE_CONTEXTS", "")
+
+	path := filepath.Join(dir, "kubeconfig")
+
+	if err := os.WriteFile(path, envtestKubeconfig, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.New(&config.Options{
+		Kubeconfigs: []string{path},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if configure != nil {
+		configure(cfg)
+	}
+
+	s, err := New(cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	return s, path
+}
+
+func serve(h http.Handler, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	var reader io.Reader
+
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	r := httptest.NewRequest(method, path, reader)
+
+	for key, values := range header {
+		r.Header[key] = values
+	}
+
+	if body != "" && r.Header.Get("Content-Type") == "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+}
+
+func TestEnvtestProxy(t *testing.T) {
+	s, _ := newEnvtestServer(t, nil)
+
+	prefix := "/contexts/" + envtestContext
+
+	w := serve(s, http.MethodGet, prefix+"/api/v1/namespaces/default", "", nil)
+	expectStatus(t, w, http.StatusOK)
+
+	var namespace struct {
+		Kind string `json:"kind"`
+
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &namespace); err != nil {
+		t.Fatal(err)
+	}
+
+	if namespace.Kind != "Namespace" || namespace.Metadata.Name != "default" {
+		t.Fatalf("unexpected namespace %+v", namespace)
+	}
+
+	configMap := `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"bridge-proxy"},"data":{"key":"value"}}`
+
+	w = serve(s, http.MethodPost, prefix+"/api/v1/namespaces/default/configmaps", configMap, nil)
+	expectStatus(t, w, http.StatusCreated)
+
+	w = serve(s, http.MethodGet, prefix+"/api/v1/namespaces/default/configmaps/bridge-proxy", "", nil)
+	expectStatus(t, w, http.StatusOK)
+
+	if !strings.Contains(w.Body.String(), `"key":"value"`) {
+		t.Fatalf("unexpected config map %s", w.Body.String())
+	}
+
+	w = serve(s, http.MethodGet, prefix+"/apis/apps/v1/namespaces/default/deployments", "", nil)
+	expectStatus(t, w, http.StatusOK)
+
+	if !strings.Contains(w.Body.String(), `"kind":"DeploymentList"`) {
+		t.Fatalf("unexpected list %s", w.Body.String())
+	}
+
+	w = serve(s, http.MethodDelete, prefix+"/api/v1/namespaces/default/configmaps/bridge-proxy", "", nil)
+	expectStatus(t, w, http.StatusOK)
+
+	w = serve(s, http.MethodGet, "/contexts/unknown/api/v1/namespaces", "", nil)
+	expectStatus(t, w, http.StatusNotFound)
+}
+
+func TestEnvtestProtection(t *testing.T) {
+	s, _ := newEnvtestServer(t, func(cfg *config.Config) {
+		cfg.ProtectedNamespaces = []string{"default"}
+		cfg.ReadOnlyNamespaces = []string{"kube-system"}
+	})
+
+	prefix := "/contexts/" + envtestContext
+
+	configMap := `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"bridge-protection"}}`
+
+	w := serve(s, http.MethodGet, prefix+"/api/v1/namespaces/default/configmaps", "", nil)
+	expectStatus(t, w, http.StatusOK)
+
+	w = serve(s, http.MethodPost, prefix+"/api/v1/namespaces/kube-system/configmaps", configMap, nil)
+	expectStatus(t, w, http.StatusForbidden)
+
+	w = serve(s, http.MethodPost, prefix+"/api/v1/namespaces/default/configmaps", configMap, nil)
+	expectStatus(t, w, http.StatusPreconditionRequired)
+
+	w = serve(s, http.MethodPost, prefix+"/confirmations", `{"namespace":"default"}`, nil)
+	expectStatus(t, w, http.StatusOK)
+
+	var confirmation ConfirmationInfo
+
+	if err := json.Unmarshal(w.Body.Bytes(), &confirmation); err != nil {
+		t.Fatal(err)
+	}
+
+	w = serve(s, http.MethodPost, prefix+"/api/v1/namespaces/default/configmaps", configMap, http.Header{
+		confirmation.Header: {confirmation.Token},
+	})
+
+	expectStatus(t, w, http.StatusCreated)
+}
+
+func TestEnvtestExec(t *testing.T) {
+	s, _ := newEnvtestServer(t, func(cfg *config.Config) {
+		cfg.ProtectedNamespaces = []string{"protected"}
+	})
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	key := make([]byte, 16)
+	rand.Read(key)
+
+	exec := func(namespace string) *http.Response {
+		r, err := http.NewRequest(http.MethodGet, srv.URL+"/contexts/"+envtestContext+"/api/v1/namespaces/"+namespace+"/pods/missing/exec?command=sh&stdin=true&stdout=true", nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+		r.Header.Set("Sec-WebSocket-Protocol", "v5.channel.k8s.io")
+
+		resp, err := http.DefaultClient.Do(r)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return resp
+	}
+
+	// the handshake reaches the API server, which answers for the pod
+	resp := exec("default")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d: %s", resp.StatusCode, http.StatusNotFound, body)
+	}
+
+	var status struct {
+		Kind   string `json:"kind"`
+		Reason string `json:"reason"`
+	}
+
+	if err := json.Unmarshal(body, &status); err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Kind != "Status" || status.Reason != "NotFound" {
+		t.Fatalf("unexpected status %s", body)
+	}
+
+	// exec is mutating, even though it is a GET
+	resp = exec("protected")
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPreconditionRequired {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusPreconditionRequired)
+	}
+}
+
+func TestEnvtestAuth(t *testing.T) {
+	s, _ := newEnvtestServer(t, func(cfg *config.Config) {
+		cfg.Auth = &config.AuthConfig{
+			Type: "token",
+
+			Tokens: []config.AuthToken{
+				{Name: "alice", Token: "alice-token", Groups: []string{"dev"}},
+			},
+		}
+	})
+
+	path := "/contexts/" + envtestContext + "/api/v1/namespaces/default"
+
+	w := serve(s, http.MethodGet, path, "", nil)
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Error("expected a WWW-Authenticate challenge")
+	}
+
+	w = serve(s, http.MethodGet, path, "", http.Header{"Authorization": {"Bearer other-token"}})
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	alice := http.Header{"Authorization": {"Bearer alice-token"}}
+
+	w = serve(s, http.MethodGet, path, "", alice)
+	expectStatus(t, w, http.StatusOK)
+
+	// browsers pass the token as websocket subprotocol
+	w = serve(s, http.MethodGet, path, "", http.Header{
+		"Sec-Websocket-Protocol": {"base64url.bearer.authorization.k8s.io." + base64.RawURLEncoding.EncodeToString([]byte("alice-token"))},
+	})
+
+	expectStatus(t, w, http.StatusOK)
+
+	w = serve(s, http.MethodGet, "/auth/me", "", alice)
+	expectStatus(t, w, http.StatusOK)
+
+	var user UserInfo
+
+	if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Name != "alice" || len(user.Groups) != 1 || user.Groups[0] != "dev" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+
+	// upstream requests carry the credentials of the context, not the token
+	// of the caller
+	review := `{"apiVersion":"authentication.k8s.io/v1","kind":"SelfSubjectReview"}`
+
+	w = serve(s, http.MethodPost, "/contexts/"+envtestContext+"/apis/authentication.k8s.io/v1/selfsubjectreviews", review, alice)
+	expectStatus(t, w, http.StatusCreated)
+
+	var result struct {
+		Status struct {
+			UserInfo struct {
+				Username string `json:"username"`
+			} `json:"userInfo"`
+		} `json:"status"`
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Status.UserInfo.Username != "bridge" {
+		t.Fatalf("upstream user = %q, want bridge", result.Status.UserInfo.Username)
+	}
+}
+
+func TestEnvtestContexts(t *testing.T) {
+	s, path := newEnvtestServer(t, nil)
+
+	namespace := func(context string) int {
+		return serve(s, http.MethodGet, "/contexts/"+context+"/api/v1/namespaces/default", "", nil).Code
+	}
+
+	data, _ := json.Marshal(&ContextRequest{
+		Name:       "dynamic",
+		Kubeconfig: string(envtestKubeconfig),
+	})
+
+	w := serve(s, http.MethodPost, "/contexts", string(data), nil)
+	expectStatus(t, w, http.StatusCreated)
+
+	if code := namespace("dynamic"); code != http.StatusOK {
+		t.Fatalf("dynamic context: status = %d", code)
+	}
+
+	w = serve(s, http.MethodPost, "/contexts", string(data), nil)
+	expectStatus(t, w, http.StatusConflict)
+
+	// contexts of the kubeconfig are reloaded from the file
+	kubeconfig, err := clientcmd.Load(envtestKubeconfig)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kubeconfig.Contexts["copy"] = kubeconfig.Contexts[envtestContext]
+
+	if err := clientcmd.WriteToFile(*kubeconfig, path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ReloadKubernetes(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{envtestContext, "copy", "dynamic"} {
+		if code := namespace(name); code != http.StatusOK {
+			t.Fatalf("%s: status = %d after adding a context", name, code)
+		}
+	}
+
+	delete(kubeconfig.Contexts, "copy")
+
+	if err := clientcmd.WriteToFile(*kubeconfig, path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ReloadKubernetes(); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := namespace("copy"); code != http.StatusNotFound {
+		t.Fatalf("copy: status = %d after removing the context", code)
+	}
+
+	if code := namespace("dynamic"); code != http.StatusOK {
+		t.Fatalf("dynamic: status = %d after reloading", code)
+	}
+
+	// only contexts added at runtime can be removed
+	w = serve(s, http.MethodDelete, "/contexts/"+envtestContext, "", nil)
+	expectStatus(t, w, http.StatusNotFound)
+
+	w = serve(s, http.MethodDelete, "/contexts/dynamic", "", nil)
+	expectStatus(t, w, http.StatusNoContent)
+
+	if code := namespace("dynamic"); code != http.StatusNotFound {
+		t.Fatalf("dynamic: status = %d after removing the context", code)
+	}
+}
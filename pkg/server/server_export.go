@@ -0,0 +1,252 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// writeList writes the response of a list endpoint. By default v is encoded
+// as JSON; ?format=csv and ?format=jsonl export the items instead, one row
+// or line per item. ?columns selects fields by their JSON name (nested
+// fields as dotted paths, e.g. columns=context,name,matches.value).
+func writeList(w http.ResponseWriter, r *http.Request, name string, v, items any) {
+	format := r.URL.Query().Get("format")
+
+	if format == "" || format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+		return
+	}
+
+	if format != "csv" && format != "jsonl" {
+		http.Error(w, "format must be json, csv or jsonl", http.StatusBadRequest)
+		return
+	}
+
+	var columns []string
+
+	for _, c := range r.URL.Query()["columns"] {
+		columns = append(columns, splitNames(c)...)
+	}
+
+	rows, err := exportRows(items)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(columns) == 0 && format == "csv" {
+		columns = exportColumns(items, rows)
+	}
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+
+		cw := csv.NewWriter(w)
+		cw.Write(columns)
+
+		for _, row := range rows {
+			record := make([]string, len(columns))
+
+			for i, c := range columns {
+				record[i] = exportCell(exportValue(row, c))
+			}
+
+			cw.Write(record)
+		}
+
+		cw.Flush()
+
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/jsonl; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.jsonl"`)
+
+		enc := json.NewEncoder(w)
+
+		for _, row := range rows {
+			if len(columns) == 0 {
+				enc.Encode(row)
+				continue
+			}
+
+			line := map[string]any{}
+
+			for _, c := range columns {
+				line[c] = exportValue(row, c)
+			}
+
+			enc.Encode(line)
+		}
+	}
+}
+
+// exportRows converts the items to their generic JSON form.
+func exportRows(items any) ([]any, error) {
+	data, err := json.Marshal(items)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []any
+
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// exportColumns returns the JSON fields of struct items in declaration
+// order, or the sorted keys of all rows otherwise.
+func exportColumns(items any, rows []any) []string {
+	t := reflect.TypeOf(items)
+
+	if t != nil && t.Kind() == reflect.Slice {
+		t = t.Elem()
+
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+
+		if t.Kind() == reflect.Struct {
+			var result []string
+
+			for i := range t.NumField() {
+				f := t.Field(i)
+
+				if !f.IsExported() {
+					continue
+				}
+
+				name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+
+				if name == "-" {
+					continue
+				}
+
+				if name == "" {
+					name = f.Name
+				}
+
+				result = append(result, name)
+			}
+
+			return result
+		}
+	}
+
+	var result []string
+
+	for _, row := range rows {
+		m, _ := row.(map[string]any)
+
+		for k := range m {
+			if !slices.Contains(result, k) {
+				result = append(result, k)
+			}
+		}
+	}
+
+	slices.Sort(result)
+
+	return result
+}
+
+// exportValue resolves a dotted path. Segments that are not an index pluck
+// the field from all elements of a list (e.g. matches.value).
+func exportValue(row any, path string) any {
+	p, rest, _ := strings.Cut(path, ".")
+
+	var v any
+
+	switch row := row.(type) {
+	case map[string]any:
+		v = row[p]
+
+	case []any:
+		i, err := strconv.Atoi(p)
+
+		if err != nil {
+			result := make([]any, 0, len(row))
+
+			for _, item := range row {
+				result = append(result, exportValue(item, path))
+			}
+
+			return result
+		}
+
+		if i < 0 || i >= len(row) {
+			return nil
+		}
+
+		v = row[i]
+
+	default:
+		return nil
+	}
+
+	if rest == "" {
+		return v
+	}
+
+	return exportValue(v, rest)
+}
+
+// exportCell formats a value for a CSV cell. Lists of scalars are joined by
+// semicolons, other structured values are written as JSON.
+func exportCell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+
+	case string:
+		return v
+
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+
+	case bool:
+		return strconv.FormatBool(v)
+
+	case []any:
+		var values []string
+
+		for _, item := range v {
+			switch item.(type) {
+			case map[string]any, []any:
+				return exportJSON(v)
+			}
+
+			values = append(values, exportCell(item))
+		}
+
+		return strings.Join(values, ";")
+	}
+
+	return exportJSON(v)
+}
+
+func exportJSON(v any) string {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return strings.TrimSpace(buf.String())
+}
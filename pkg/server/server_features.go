@@ -0,0 +1,335 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+const (
+	// featuresTTL is how long detected features of a context are reused
+	featuresTTL = 5 * time.Minute
+
+	// featuresTimeout bounds the detection of the features of a context,
+	// which runs in the background of the config
+	featuresTimeout = 30 * time.Second
+
+	// featuresRetry is how long a context is not detected again for the
+	// same caller, so failing contexts are not queried on every config
+	featuresRetry = time.Minute
+)
+
+// prometheusSelectors find the Prometheus services of common installations:
+// the prometheus chart, older manifests and the Prometheus operator.
+var prometheusSelectors = []string{
+	"app.kubernetes.io/name=prometheus",
+	"app=prometheus",
+	"operated-prometheus=true",
+}
+
+// lokiSelectors find the Loki services of common installations, preferring
+// the components serving queries: the gateway, query frontend, read path and
+// single binary of the loki chart, and the loki-stack chart.
+var lokiSelectors = []string{
+	"app.kubernetes.io/name=loki,app.kubernetes.io/component=gateway",
+	"app.kubernetes.io/name=loki,app.kubernetes.io/component=query-frontend",
+	"app.kubernetes.io/name=loki,app.kubernetes.io/component=read",
+	"app.kubernetes.io/name=loki,app.kubernetes.io/component=single-binary",
+	"app=loki",
+}
+
+// contextFeatures caches the features of contexts per caller, as exec and
+// write permissions depend on the credentials.
+type contextFeatures struct {
+	mu      sync.Mutex
+	entries map[string]*featuresEntry
+
+	// detections holds the start of the last background detection per key
+	detections map[string]time.Time
+}
+
+type featuresEntry struct {
+	features *ContextFeatures
+	expires  time.Time
+}
+
+func (f *contextFeatures) get(key string) (*ContextFeatures, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	e, ok := f.entries[key]
+
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+
+	return e.features, true
+}
+
+// cached returns the features of a key even if they expired, and whether
+// they are still fresh.
+func (f *contextFeatures) cached(key string) (*ContextFeatures, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	e, ok := f.entries[key]
+
+	if !ok {
+		return nil, false
+	}
+
+	return e.features, time.Now().Before(e.expires)
+}
+
+// begin reports whether a key is to be detected in the background, which it
+// is not while a detection runs or after one failed recently.
+func (f *contextFeatures) begin(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.detections == nil {
+		f.detections = make(map[string]time.Time)
+	}
+
+	for k, started := range f.detections {
+		if time.Since(started) > featuresRetry {
+			delete(f.detections, k)
+		}
+	}
+
+	if _, ok := f.detections[key]; ok {
+		return false
+	}
+
+	f.detections[key] = time.Now()
+
+	return true
+}
+
+func (f *contextFeatures) put(key string, features *ContextFeatures) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.entries == nil {
+		f.entries = make(map[string]*featuresEntry)
+	}
+
+	// expired features are kept for another period, to be served while they
+	// are detected again
+	for k, e := range f.entries {
+		if time.Since(e.expires) > featuresTTL {
+			delete(f.entries, k)
+		}
+	}
+
+	f.entries[key] = &featuresEntry{
+		features: features,
+		expires:  time.Now().Add(featuresTTL),
+	}
+}
+
+// cachedFeatures returns the features of all reachable contexts without
+// waiting for their detection. Contexts not detected yet are left out and,
+// like expired ones, detected in the background and sent as features events.
+// Contexts known to be offline are left out.
+func (s *Server) cachedFeatures(auth *config.AuthInfo) map[string]*ContextFeatures {
+	result := map[string]*ContextFeatures{}
+
+	for _, name := range s.kubernetesContextNames() {
+		if s.connectivity.offline(name) {
+			continue
+		}
+
+		features, fresh := s.features.cached(featuresKey(name, auth))
+
+		if features != nil {
+			result[name] = features
+		}
+
+		if !fresh {
+			s.detectFeatures(name, auth)
+		}
+	}
+
+	return result
+}
+
+// detectFeatures detects the features of a context for a caller in the
+// background and sends them as features event to the config events of the
+// caller.
+func (s *Server) detectFeatures(name string, auth *config.AuthInfo) {
+	if !s.features.begin(featuresKey(name, auth)) {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), featuresTimeout)
+		defer cancel()
+
+		features := s.contextFeatures(ctx, name, auth)
+
+		s.configEvents.publish(configEvent{
+			name: "features",
+
+			data: &FeaturesEvent{
+				Context:  name,
+				Features: features,
+
+				Time: time.Now().UTC(),
+			},
+
+			scoped:     true,
+			credential: credentialID(auth),
+		})
+	}()
+}
+
+func featuresKey(name string, auth *config.AuthInfo) string {
+	return strings.ToLower(name) + "/" + credentialID(auth)
+}
+
+func (s *Server) contextFeatures(ctx context.Context, name string, auth *config.AuthInfo) *ContextFeatures {
+	key := featuresKey(name, auth)
+
+	if features, ok := s.features.get(key); ok {
+		return features
+	}
+
+	features := &ContextFeatures{}
+
+	client, err := s.kubernetesClient(ctx, name, auth)
+
+	if err != nil {
+		return &ContextFeatures{Error: err.Error()}
+	}
+
+	namespace := s.defaultNamespace(name)
+
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	_, err = exec.LookPath("helm")
+	features.Helm = err == nil
+
+	if _, ok := s.config.Talos.Cluster(name); ok {
+		_, err = exec.LookPath("talosctl")
+		features.Talos = err == nil
+	}
+
+	// platform components are probed independent of the caller
+	capabilities := s.contextCapabilities(ctx, name, auth)
+
+	if capabilities.Error != "" {
+		// incomplete results are neither reported nor cached
+		return &ContextFeatures{Error: capabilities.Error}
+	}
+
+	features.Metrics = capabilities.Metrics
+	features.Prometheus = capabilities.Prometheus != nil
+
+	if _, ok := s.config.ContextPrometheus(name); ok {
+		features.Prometheus = true
+	}
+
+	features.Loki = capabilities.Loki != nil
+
+	if _, ok := s.config.ContextLoki(name); ok {
+		features.Loki = true
+	}
+
+	features.CertManager = capabilities.CertManager
+	features.Argo = len(capabilities.Argo) > 0
+	features.Mesh = capabilities.Mesh
+	features.GPU = len(capabilities.GPU) > 0
+	features.Batch = len(capabilities.Batch) > 0
+	features.ClusterAPI = capabilities.ClusterAPI
+
+	for _, c := range capabilities.Ingress {
+		if features.Ingress == "" || c.Default {
+			features.Ingress = cmp.Or(c.Type, c.Controller)
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	var execErr, writeErr error
+
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+
+		features.Exec, execErr = accessAllowedIn(ctx, client, namespace, authorizationv1.ResourceAttributes{Verb: "create", Resource: "pods", Subresource: "exec"})
+	}()
+
+	go func() {
+		defer wg.Done()
+
+		var allowed bool
+		allowed, writeErr = accessAllowedIn(ctx, client, namespace, authorizationv1.ResourceAttributes{Verb: "patch", Group: "apps", Resource: "deployments"})
+
+		features.ReadOnly = !allowed || s.config.NamespaceProtection(namespace) == config.ProtectionReadOnly
+	}()
+
+	wg.Wait()
+
+	for _, err := range []error{execErr, writeErr} {
+		if err != nil {
+			return &ContextFeatures{Error: err.Error()}
+		}
+	}
+
+	s.features.put(key, features)
+
+	return features
+}
+
+// accessAllowedIn checks a permission cluster-wide and, if not granted
+// there, in a namespace.
+func accessAllowedIn(ctx context.Context, client *kubernetesClient, namespace string, attrs authorizationv1.ResourceAttributes) (bool, error) {
+	allowed, err := accessAllowed(ctx, client, attrs)
+
+	if err != nil || allowed {
+		return allowed, err
+	}
+
+	attrs.Namespace = namespace
+
+	return accessAllowed(ctx, client, attrs)
+}
+
+// findPrometheus returns a Prometheus service of a context, or nil if there
+// is none.
+func findPrometheus(ctx context.Context, client *kubernetesClient) (*corev1.Service, error) {
+	for _, selector := range prometheusSelectors {
+		service, err := findService(ctx, client, selector)
+
+		if err != nil || service != nil {
+			return service, err
+		}
+	}
+
+	return nil, nil
+}
+
+// findLoki returns a Loki service of a context, or nil if there is none.
+func findLoki(ctx context.Context, client *kubernetesClient) (*corev1.Service, error) {
+	for _, selector := range lokiSelectors {
+		service, err := findService(ctx, client, selector)
+
+		if err != nil || service != nil {
+			return service, err
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+// keepAlive warms up and periodically pings pinned contexts, so TLS sessions
+// and exec plugin credentials are ready before the first user request. The
+// transports are shared with the proxy through the client-go transport cache.
+func (s *Server) keepAlive(done <-chan struct{}) {
+	interval := s.config.KeepAliveInterval
+
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, c := range s.pinnedContexts() {
+			go s.ping(c, interval)
+		}
+
+		select {
+		case <-done:
+			return
+
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) pinnedContexts() []config.KubernetesContext {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.config.Kubernetes == nil {
+		return nil
+	}
+
+	var result []config.KubernetesContext
+
+	for _, c := range s.config.Kubernetes.Contexts {
+		pinned := c.Pinned
+
+		if v, ok := s.pins[strings.ToLower(c.Name)]; ok {
+			pinned = v
+		}
+
+		if pinned {
+			result = append(result, c)
+		}
+	}
+
+	return result
+}
+
+func (s *Server) ping(c config.KubernetesContext, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	tr, target, err := s.kubernetesTransport(ctx, c, nil)
+
+	if err != nil {
+		log.Printf("keep-alive for context %q failed: %v", c.Name, err)
+		return
+	}
+
+	u := *target
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/version"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+
+	if err != nil {
+		return
+	}
+
+	resp, err := tr.RoundTrip(req)
+
+	if err != nil {
+		log.Printf("keep-alive for context %q failed: %v", c.Name, err)
+		return
+	}
+
+	resp.Body.Close()
+}
+
+// pinsState keeps pins changed at runtime, which override the config file.
+const pinsState = "pins"
+
+func (s *Server) loadPins() {
+	pins := make(map[string]bool)
+
+	if err := loadState(pinsState, &pins); err != nil {
+		log.Printf("failed to load pinned contexts: %v", err)
+	}
+
+	s.mu.Lock()
+	s.pins = pins
+	s.mu.Unlock()
+}
+
+func (s *Server) handleSetPinned(pinned bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := s.kubernetesContext(r.PathValue("context"))
+
+		if !ok {
+			writeError(w, r, errContextNotFound, http.StatusNotFound)
+			return
+		}
+
+		s.mu.Lock()
+
+		if s.pins == nil {
+			s.pins = make(map[string]bool)
+		}
+
+		s.pins[strings.ToLower(c.Name)] = pinned
+		pins := maps.Clone(s.pins)
+
+		s.mu.Unlock()
+
+		if err := saveState(pinsState, pins); err != nil {
+			log.Printf("failed to save pinned contexts: %v", err)
+		}
+
+		if pinned {
+			go s.ping(c, s.config.KeepAliveInterval)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		json.NewEncoder(w).Encode(&PinInfo{
+			Context: c.Name,
+			Pinned:  pinned,
+		})
+	}
+}
@@ -3,55 +3,205 @@ package server
 import (
 	"context"
 	"errors"
-	"io"
 	"log"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"strings"
 
-	"github.com/adrianliechti/bridge/pkg/config"
 	"k8s.io/client-go/rest"
+
+	"github.com/adrianliechti/bridge/pkg/config"
 )
 
-func (s *Server) kubernetesProxy(ctx context.Context, name string, auth *config.AuthInfo) (http.Handler, error) {
-	for _, c := range s.config.Kubernetes.Contexts {
-		if !strings.EqualFold(c.Name, name) {
-			continue
-		}
+// stripUpstreamCredentials removes the credentials of the bridge from a
+// request to the API server, which carries the credentials of the transport
+// instead: the token of the websocket subprotocol, and in server mode the
+// Authorization header, which would take precedence over the credentials of
+// the context.
+func (s *Server) stripUpstreamCredentials(h http.Header) {
+	stripBearerProtocol(h)
 
-		config, err := c.Config(ctx, auth)
+	if s.config.Auth != nil {
+		h.Del("Authorization")
+	}
+}
+
+func (s *Server) kubernetesProxy(ctx context.Context, name string, auth *config.AuthInfo) (http.Handler, error) {
+	if c, ok := s.kubernetesContext(name); ok {
+		tr, target, err := s.kubernetesTransport(ctx, c, auth)
 
 		if err != nil {
 			return nil, err
 		}
 
-		tr, err := rest.TransportFor(config)
+		proxy := &httputil.ReverseProxy{
+			Transport: s.cachedTransport(c, auth, tr, target),
 
-		if err != nil {
-			return nil, err
-		}
+			// flush every write, so followed logs and watches reach the
+			// browser immediately
+			FlushInterval: -1,
 
-		target, path, err := rest.DefaultServerUrlFor(config)
+			ErrorLog: log.New(log.Writer(), "proxy: ", log.LstdFlags),
 
-		if err != nil {
-			return nil, err
-		}
+			Rewrite: func(r *httputil.ProxyRequest) {
+				applyKubernetesLimits(s.config.Limits, r.Out)
+				r.Out = withLogTail(s.config.Limits, r.Out)
 
-		target.Path = path
+				if isStreamingRequest(r.Out) {
+					// compressed streams are held back in gzip buffers
+					r.Out.Header.Set("Accept-Encoding", "identity")
+				}
 
-		proxy := &httputil.ReverseProxy{
-			Transport: tr,
+				_, projected := fieldsFromContext(r.Out.Context())
+				_, printed := printerFromContext(r.Out.Context())
 
-			ErrorLog: log.New(io.Discard, "", 0),
+				if projected || printed {
+					// projection and printers need the plain response body
+					r.Out.Header.Del("Accept-Encoding")
+				}
+
+				s.stripUpstreamCredentials(r.Out.Header)
 
-			Rewrite: func(r *httputil.ProxyRequest) {
 				r.SetURL(target)
 				r.Out.Host = target.Host
 			},
+
+			ModifyResponse: func(resp *http.Response) error {
+				if resp.StatusCode == http.StatusSwitchingProtocols {
+					// upgraded streams (exec, attach, port-forward) are tunneled as is
+					return nil
+				}
+
+				if err := limitResponse(s.config.Limits)(resp); err != nil {
+					return err
+				}
+
+				s.trashResponse(resp)
+
+				if err := printResponse(resp); err != nil {
+					return err
+				}
+
+				if err := projectResponse(resp); err != nil {
+					return err
+				}
+
+				s.captureResponse(resp)
+
+				return nil
+			},
+
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				if isNetworkError(err) {
+					s.serveOffline(w, r, c.Name)
+					return
+				}
+
+				limitErrorHandler(w, r, err)
+			},
 		}
 
-		return proxy, nil
+		discovery := s.discoveryCache(c, target)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.trackNamespace(c.Name, r)
+
+			if !s.guardProtection(w, r, c) {
+				return
+			}
+
+			w, ok := s.guardDisruption(w, r, c, auth)
+
+			if !ok {
+				return
+			}
+
+			if s.stale.enabled() {
+				r = withStaleKey(r, c.Name, auth)
+			}
+
+			if s.connectivity.offline(c.Name) {
+				s.serveOffline(w, r, c.Name)
+				return
+			}
+
+			if isWatchRequest(r) && acceptsJSON(r) {
+				s.stripUpstreamCredentials(r.Header)
+				serveKubernetesWatch(w, r, tr, target)
+				return
+			}
+
+			if isDiscoveryRequest(r) {
+				s.stripUpstreamCredentials(r.Header)
+				serveCachedDiscovery(w, r, tr, target, discovery, auth)
+				return
+			}
+
+			if changesDiscovery(r) {
+				defer discovery.clear()
+				defer s.catalogs.invalidate(c.Name)
+				defer s.schemas.invalidate(c.Name)
+			}
+
+			r = extractFields(r)
+			r = s.applyPrinter(r)
+			r = s.snapshotForTrash(r, c, auth)
+
+			proxy.ServeHTTP(w, r)
+		}), nil
 	}
 
 	return nil, errors.New("kubernetes context not found")
 }
+
+func (s *Server) kubernetesTransport(ctx context.Context, c config.KubernetesContext, auth *config.AuthInfo) (http.RoundTripper, *url.URL, error) {
+	key := "kubernetes/" + strings.ToLower(c.Name) + "/" + credentialID(auth)
+
+	t, err := s.transports.get(key, c.Name, func(t *pooledTransport) error {
+		config, err := s.kubernetesConfig(ctx, c, auth)
+
+		if err != nil {
+			return err
+		}
+
+		t.observe = func(err error) {
+			s.connectivity.observe(c.Name, err)
+		}
+
+		return buildKubernetesTransport(t, config)
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return t, t.target, nil
+}
+
+// kubernetesConfig resolves the client config of a context for a caller,
+// impersonating the user the caller acts as. Private API servers are reached
+// through the cloud tunnel of the context.
+func (s *Server) kubernetesConfig(ctx context.Context, c config.KubernetesContext, auth *config.AuthInfo) (*rest.Config, error) {
+	config, err := c.Config(ctx, auth)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if dial, ok := s.tunnelDialer("kubernetes", c.Name); ok {
+		config = rest.CopyConfig(config)
+		config.Dial = dial
+	}
+
+	if auth != nil && auth.ImpersonateUser != "" {
+		config = rest.CopyConfig(config)
+
+		config.Impersonate = rest.ImpersonationConfig{
+			UserName: auth.ImpersonateUser,
+			Groups:   auth.ImpersonateGroups,
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/health"
+)
+
+// handleAggregateList lists a resource in the contexts of ?contexts (default
+// all) in parallel and merges the items into one list. Every item carries
+// the name of its context in a context field, and with ?health=true its
+// health like lists of the proxy. Contexts that fail are reported without
+// failing the list.
+//
+//	GET /aggregate/{group}/{version}/{resource}?contexts=&namespace=&labelSelector=&fieldSelector=&limit=
+func (s *Server) handleAggregateList(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	var contexts []string
+
+	for _, v := range r.URL.Query()["contexts"] {
+		contexts = append(contexts, splitNames(v)...)
+	}
+
+	if len(contexts) == 0 {
+		contexts = s.kubernetesContextNames()
+	}
+
+	target := objectResource(r)
+
+	query := url.Values{}
+
+	for _, key := range []string{"labelSelector", "fieldSelector", "limit"} {
+		if v := r.URL.Query().Get(key); v != "" {
+			query.Set(key, v)
+		}
+	}
+
+	withHealth := r.URL.Query().Get("health") == "true"
+
+	lists := make([][]map[string]any, len(contexts))
+
+	result := &AggregateList{
+		APIVersion: "v1",
+		Kind:       "List",
+
+		Items:    []map[string]any{},
+		Contexts: make([]AggregateContext, len(contexts)),
+	}
+
+	var wg sync.WaitGroup
+
+	for i, name := range contexts {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			items, cont, err := s.aggregateContext(r.Context(), name, auth, target.Path(), query)
+
+			result.Contexts[i] = AggregateContext{
+				Context: name,
+
+				Items:    len(items),
+				Continue: cont,
+			}
+
+			if err != nil {
+				result.Contexts[i].Error = err.Error()
+				return
+			}
+
+			for _, item := range items {
+				item["context"] = name
+
+				if withHealth {
+					item["health"] = health.Assess(item)
+				}
+			}
+
+			lists[i] = items
+		}()
+	}
+
+	wg.Wait()
+
+	for _, items := range lists {
+		result.Items = append(result.Items, items...)
+	}
+
+	writeList(w, r, "aggregate", result, result.Items)
+}
+
+// aggregateContext lists the items of a context with their type filled in,
+// which items of built-in lists omit.
+func (s *Server) aggregateContext(ctx context.Context, name string, auth *config.AuthInfo, path string, query url.Values) ([]map[string]any, string, error) {
+	client, err := s.kubernetesClient(ctx, name, auth)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	var list struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+
+		Metadata struct {
+			Continue string `json:"continue,omitempty"`
+		} `json:"metadata"`
+
+		Items []map[string]any `json:"items"`
+	}
+
+	if err := client.get(ctx, path, query, &list); err != nil {
+		return nil, "", err
+	}
+
+	for _, item := range list.Items {
+		if _, ok := item["kind"]; !ok {
+			item["apiVersion"] = list.APIVersion
+			item["kind"] = strings.TrimSuffix(list.Kind, "List")
+		}
+	}
+
+	return list.Items, list.Metadata.Continue, nil
+}
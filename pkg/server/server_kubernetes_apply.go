@@ -0,0 +1,264 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+)
+
+// applyOrder applies namespaces and CRDs before the objects depending on
+// them.
+func applyOrder(kind string) int {
+	switch kind {
+	case "Namespace":
+		return 0
+	case "CustomResourceDefinition":
+		return 1
+	}
+
+	return 2
+}
+
+// applyDocument is an object of an apply with its resolved target.
+type applyDocument struct {
+	doc    manifestDocument
+	target *kubernetesRequest
+	err    error
+}
+
+// handleApply applies the objects of a multi-document manifest in the
+// request body with server-side apply, like kubectl apply --server-side.
+// Kinds are resolved through discovery, so custom resources whose CRD is
+// part of the manifest work as well. Every object reports its own result;
+// a failing object does not stop the others. ?fieldManager overrides the
+// configured field manager, ?force=true takes over conflicting fields,
+// ?dryRun=true validates without persisting and ?namespace sets the
+// namespace of objects without one.
+func (s *Server) handleApply(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, 10*1024*1024))
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	namespace := r.URL.Query().Get("namespace")
+
+	if namespace == "" {
+		namespace = s.defaultNamespace(name)
+	}
+
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	manager := s.fieldManager(r)
+
+	force := r.URL.Query().Get("force") == "true"
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	mapper := newManifestMapper(client)
+
+	var docs []*applyDocument
+
+	for _, doc := range splitManifest(string(data)) {
+		d := &applyDocument{doc: doc, err: doc.Error}
+
+		if d.err == nil {
+			d.target, d.err = mapper.resolve(r.Context(), doc.Object, namespace)
+		}
+
+		docs = append(docs, d)
+	}
+
+	if !dryRun {
+		// all namespaces are checked before the first object is applied
+		var namespaces []string
+
+		for _, d := range docs {
+			if d.doc.Error != nil {
+				continue
+			}
+
+			ns := namespace
+
+			if d.target != nil {
+				ns = protectedNamespace(d.target)
+			} else if metadata, ok := d.doc.Object["metadata"].(map[string]any); ok {
+				ns = cmp.Or(stringValue(metadata["namespace"]), namespace)
+			}
+
+			if ns != "" && !slices.Contains(namespaces, ns) {
+				namespaces = append(namespaces, ns)
+			}
+		}
+
+		for _, ns := range namespaces {
+			if err := s.checkProtection(r, name, ns); err != nil {
+				writeProtectionError(w, r, err)
+				return
+			}
+		}
+	}
+
+	order := slices.Clone(docs)
+
+	slices.SortStableFunc(order, func(a, b *applyDocument) int {
+		return cmp.Compare(applyOrder(stringValue(a.doc.Object["kind"])), applyOrder(stringValue(b.doc.Object["kind"])))
+	})
+
+	results := map[*applyDocument]ApplyObject{}
+
+	crds := false
+
+	for _, d := range order {
+		item := s.applyManifestObject(r.Context(), client, mapper, d, namespace, manager, dryRun, force)
+
+		if !dryRun {
+			entry := &AuditEntry{
+				Context: name,
+				Owner:   ownerID(auth),
+				Action:  "apply",
+
+				Namespace: item.Namespace,
+				Name:      item.Name,
+
+				Error: item.Error,
+			}
+
+			if d.target != nil {
+				entry.Resource = d.target.Resource
+			}
+
+			if d.doc.Error == nil {
+				s.audit.record(entry)
+			}
+
+			if item.Kind == "CustomResourceDefinition" && item.Error == "" {
+				crds = true
+			}
+		}
+
+		results[d] = item
+	}
+
+	if crds {
+		s.catalogs.invalidate(name)
+		s.schemas.invalidate(name)
+	}
+
+	result := &ApplyResult{
+		DryRun: dryRun,
+
+		Objects: []ApplyObject{},
+	}
+
+	// results are reported in the order of the manifest
+	for _, d := range docs {
+		item := results[d]
+
+		if item.Error != "" {
+			result.Failed++
+		}
+
+		result.Objects = append(result.Objects, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *Server) applyManifestObject(ctx context.Context, client *kubernetesClient, mapper *manifestMapper, d *applyDocument, namespace, manager string, dryRun, force bool) ApplyObject {
+	item := ApplyObject{
+		Line: d.doc.Line,
+	}
+
+	fail := func(err error) ApplyObject {
+		item.Action = "error"
+
+		if statusCode(err) == http.StatusConflict {
+			item.Action = "conflict"
+		}
+
+		item.Error = statusMessage(err)
+
+		return item
+	}
+
+	if d.doc.Error != nil {
+		return fail(d.doc.Error)
+	}
+
+	item.APIVersion = stringValue(d.doc.Object["apiVersion"])
+	item.Kind = stringValue(d.doc.Object["kind"])
+
+	if d.target == nil {
+		// the kind may be defined by a CRD applied before
+		mapper.forget(item.APIVersion)
+
+		d.target, d.err = mapper.resolve(ctx, d.doc.Object, namespace)
+	}
+
+	if d.err != nil {
+		return fail(d.err)
+	}
+
+	item.Namespace = d.target.Namespace
+	item.Name = d.target.Name
+
+	var live map[string]any
+
+	if err := client.get(ctx, d.target.Path(), nil, &live); err != nil {
+		if statusCode(err) != http.StatusNotFound {
+			return fail(err)
+		}
+
+		live = nil
+	}
+
+	applied, err := serverSideApply(ctx, client, d.target, d.doc.Object, manager, dryRun, force)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	item.ResourceVersion = nestedString(applied, "metadata", "resourceVersion")
+
+	switch {
+	case live == nil:
+		item.Action = "created"
+
+	case dryRun:
+		// dry runs keep the resource version
+		var changes []DiffChange
+		diffValues("", live, applied, &changes)
+
+		item.Action = "configured"
+
+		if len(changes) == 0 {
+			item.Action = "unchanged"
+		}
+
+	case nestedString(live, "metadata", "resourceVersion") == item.ResourceVersion:
+		item.Action = "unchanged"
+
+	default:
+		item.Action = "configured"
+	}
+
+	return item
+}
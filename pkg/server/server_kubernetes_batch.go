@@ -0,0 +1,496 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// batchGroups are the API groups of batch schedulers.
+var batchGroups = map[string]string{
+	"kueue.x-k8s.io":        "kueue",
+	"scheduling.volcano.sh": "volcano",
+}
+
+// preferredVersion returns the preferred version of an API group, or an
+// empty string if the group is not served.
+func preferredVersion(ctx context.Context, client *kubernetesClient, group string) (string, error) {
+	var g metav1.APIGroup
+
+	if err := client.get(ctx, "/apis/"+group, nil, &g); err != nil {
+		if statusCode(err) == http.StatusNotFound {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return g.PreferredVersion.Version, nil
+}
+
+func findCondition(conditions []ObjectCondition, conditionType string) (ObjectCondition, bool) {
+	for _, c := range conditions {
+		if c.Type == conditionType {
+			return c, true
+		}
+	}
+
+	return ObjectCondition{}, false
+}
+
+// listObjects lists custom resources, cluster-wide or in a namespace.
+func listObjects(ctx context.Context, client *kubernetesClient, target kubernetesRequest, namespace string) ([]unstructured.Unstructured, error) {
+	target.Namespace = namespace
+
+	var list unstructured.UnstructuredList
+
+	if err := client.get(ctx, target.Path(), nil, &list); err != nil {
+		return nil, err
+	}
+
+	return list.Items, nil
+}
+
+// handleBatch explains why batch jobs are not starting with Kueue or
+// Volcano: the queues with their usage, the workloads waiting for admission
+// with the reason given by the scheduler, and recent preemptions. Finished
+// workloads are left out unless ?all=true. ?namespace limits workloads,
+// local queues and events to a namespace.
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	client, err := s.kubernetesClient(r.Context(), r.PathValue("context"), auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	namespace := r.URL.Query().Get("namespace")
+	all := r.URL.Query().Get("all") == "true"
+
+	result := &BatchReport{
+		Schedulers:  []string{},
+		Queues:      []BatchQueue{},
+		Workloads:   []BatchWorkload{},
+		Preemptions: []BatchEvent{},
+	}
+
+	for _, group := range []string{"kueue.x-k8s.io", "scheduling.volcano.sh"} {
+		version, err := preferredVersion(r.Context(), client, group)
+
+		if err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+
+		if version == "" {
+			continue
+		}
+
+		scheduler := batchGroups[group]
+		result.Schedulers = append(result.Schedulers, scheduler)
+
+		collect := kueueBatch
+
+		if scheduler == "volcano" {
+			collect = volcanoBatch
+		}
+
+		if err := collect(r.Context(), client, kubernetesRequest{Group: group, Version: version}, namespace, result); err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+	}
+
+	if !all {
+		result.Workloads = slices.DeleteFunc(result.Workloads, func(w BatchWorkload) bool {
+			return w.Status == "finished"
+		})
+	}
+
+	if len(result.Schedulers) > 0 {
+		events, err := preemptionEvents(r.Context(), client, namespace)
+
+		if err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+
+		result.Preemptions = events
+	}
+
+	slices.SortFunc(result.Queues, func(a, b BatchQueue) int {
+		return cmp.Or(
+			strings.Compare(a.Kind, b.Kind),
+			strings.Compare(a.Namespace, b.Namespace),
+			strings.Compare(a.Name, b.Name),
+		)
+	})
+
+	// pending workloads first, by priority and then age
+	slices.SortFunc(result.Workloads, func(a, b BatchWorkload) int {
+		return cmp.Or(
+			cmp.Compare(batchStatusOrder(a.Status), batchStatusOrder(b.Status)),
+			cmp.Compare(b.Priority, a.Priority),
+			a.Created.Compare(b.Created),
+			strings.Compare(a.Namespace+"/"+a.Name, b.Namespace+"/"+b.Name),
+		)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func batchStatusOrder(status string) int {
+	switch status {
+	case "pending":
+		return 0
+	case "evicted":
+		return 1
+	case "finished":
+		return 3
+	}
+
+	return 2
+}
+
+// kueueBatch collects the cluster and local queues and the workloads of
+// Kueue. A workload is pending until quota is reserved and it is admitted.
+func kueueBatch(ctx context.Context, client *kubernetesClient, api kubernetesRequest, namespace string, result *BatchReport) error {
+	api.Resource = "clusterqueues"
+
+	clusterQueues, err := listObjects(ctx, client, api, "")
+
+	if err != nil {
+		return err
+	}
+
+	for _, q := range clusterQueues {
+		queue := BatchQueue{
+			Scheduler: "kueue",
+			Kind:      "ClusterQueue",
+			Name:      q.GetName(),
+
+			Pending:  nestedInt(q.Object, "status", "pendingWorkloads"),
+			Admitted: nestedInt(q.Object, "status", "admittedWorkloads"),
+		}
+
+		queue.Parent, _, _ = unstructured.NestedString(q.Object, "spec", "cohort")
+
+		conditions := objectConditions(&q).Conditions
+
+		if c, ok := findCondition(conditions, "Active"); ok {
+			queue.State = "Inactive"
+
+			if c.Status == "True" {
+				queue.State = "Active"
+			} else {
+				queue.Message = cmp.Or(c.Message, c.Reason)
+			}
+		}
+
+		// nominal quota and usage per flavor and resource
+		groups, _, _ := unstructured.NestedSlice(q.Object, "spec", "resourceGroups")
+
+		for _, g := range groups {
+			group, _ := g.(map[string]any)
+			flavors, _, _ := unstructured.NestedSlice(group, "flavors")
+
+			for _, f := range flavors {
+				flavor, _ := f.(map[string]any)
+				name, _ := flavor["name"].(string)
+
+				resources, _, _ := unstructured.NestedSlice(flavor, "resources")
+
+				for _, r := range resources {
+					resource, _ := r.(map[string]any)
+
+					queue.Resources = append(queue.Resources, BatchQueueResource{
+						Flavor:   name,
+						Resource: stringValue(resource["name"]),
+						Quota:    stringValue(resource["nominalQuota"]),
+					})
+				}
+			}
+		}
+
+		usages, _, _ := unstructured.NestedSlice(q.Object, "status", "flavorsUsage")
+
+		for _, u := range usages {
+			usage, _ := u.(map[string]any)
+			flavor, _ := usage["name"].(string)
+
+			resources, _, _ := unstructured.NestedSlice(usage, "resources")
+
+			for _, r := range resources {
+				resource, _ := r.(map[string]any)
+
+				for i := range queue.Resources {
+					if queue.Resources[i].Flavor == flavor && queue.Resources[i].Resource == stringValue(resource["name"]) {
+						queue.Resources[i].Used = stringValue(resource["total"])
+					}
+				}
+			}
+		}
+
+		result.Queues = append(result.Queues, queue)
+	}
+
+	api.Resource = "localqueues"
+
+	localQueues, err := listObjects(ctx, client, api, namespace)
+
+	if err != nil {
+		return err
+	}
+
+	for _, q := range localQueues {
+		queue := BatchQueue{
+			Scheduler: "kueue",
+			Kind:      "LocalQueue",
+			Namespace: q.GetNamespace(),
+			Name:      q.GetName(),
+
+			Pending:  nestedInt(q.Object, "status", "pendingWorkloads"),
+			Admitted: nestedInt(q.Object, "status", "admittedWorkloads"),
+		}
+
+		queue.Parent, _, _ = unstructured.NestedString(q.Object, "spec", "clusterQueue")
+
+		if c, ok := findCondition(objectConditions(&q).Conditions, "Active"); ok && c.Status != "True" {
+			queue.State = "Inactive"
+			queue.Message = cmp.Or(c.Message, c.Reason)
+		}
+
+		result.Queues = append(result.Queues, queue)
+	}
+
+	api.Resource = "workloads"
+
+	workloads, err := listObjects(ctx, client, api, namespace)
+
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range workloads {
+		workload := BatchWorkload{
+			Scheduler: "kueue",
+			Namespace: obj.GetNamespace(),
+			Name:      obj.GetName(),
+			Created:   obj.GetCreationTimestamp().Time,
+
+			Priority: nestedInt(obj.Object, "spec", "priority"),
+		}
+
+		workload.Queue, _, _ = unstructured.NestedString(obj.Object, "spec", "queueName")
+		workload.ClusterQueue, _, _ = unstructured.NestedString(obj.Object, "status", "admission", "clusterQueue")
+
+		if owner := metav1.GetControllerOfNoCopy(&obj); owner != nil {
+			workload.Owner = owner.Kind + "/" + owner.Name
+		}
+
+		conditions := objectConditions(&obj).Conditions
+
+		switch {
+		case conditionMet(conditions, "Finished", "True"):
+			workload.Status = "finished"
+
+		case conditionMet(conditions, "Admitted", "True"):
+			workload.Status = "admitted"
+
+		case conditionMet(conditions, "Evicted", "True"):
+			c, _ := findCondition(conditions, "Evicted")
+
+			workload.Status = "evicted"
+			workload.Reason = c.Reason
+			workload.Message = c.Message
+
+		default:
+			workload.Status = "pending"
+
+			if c, ok := findCondition(conditions, "QuotaReserved"); ok {
+				workload.Reason = c.Reason
+				workload.Message = c.Message
+			}
+		}
+
+		result.Workloads = append(result.Workloads, workload)
+	}
+
+	return nil
+}
+
+// volcanoBatch collects the queues and pod groups of Volcano. Pod groups
+// wait in the Pending and Inqueue phases until all members can be placed.
+func volcanoBatch(ctx context.Context, client *kubernetesClient, api kubernetesRequest, namespace string, result *BatchReport) error {
+	api.Resource = "queues"
+
+	queues, err := listObjects(ctx, client, api, "")
+
+	if err != nil {
+		return err
+	}
+
+	for _, q := range queues {
+		queue := BatchQueue{
+			Scheduler: "volcano",
+			Kind:      "Queue",
+			Name:      q.GetName(),
+
+			Pending:  nestedInt(q.Object, "status", "pending") + nestedInt(q.Object, "status", "inqueue"),
+			Admitted: nestedInt(q.Object, "status", "running"),
+		}
+
+		queue.State, _, _ = unstructured.NestedString(q.Object, "status", "state")
+		queue.Parent, _, _ = unstructured.NestedString(q.Object, "spec", "parent")
+
+		capability, _, _ := unstructured.NestedMap(q.Object, "spec", "capability")
+
+		for name, quota := range capability {
+			queue.Resources = append(queue.Resources, BatchQueueResource{
+				Resource: name,
+				Quota:    stringValue(quota),
+			})
+		}
+
+		slices.SortFunc(queue.Resources, func(a, b BatchQueueResource) int {
+			return strings.Compare(a.Resource, b.Resource)
+		})
+
+		result.Queues = append(result.Queues, queue)
+	}
+
+	api.Resource = "podgroups"
+
+	groups, err := listObjects(ctx, client, api, namespace)
+
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range groups {
+		workload := BatchWorkload{
+			Scheduler: "volcano",
+			Namespace: obj.GetNamespace(),
+			Name:      obj.GetName(),
+			Created:   obj.GetCreationTimestamp().Time,
+		}
+
+		workload.Queue, _, _ = unstructured.NestedString(obj.Object, "spec", "queue")
+
+		if owner := metav1.GetControllerOfNoCopy(&obj); owner != nil {
+			workload.Owner = owner.Kind + "/" + owner.Name
+		}
+
+		phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+
+		switch phase {
+		case "Completed":
+			workload.Status = "finished"
+
+		case "Running":
+			workload.Status = "admitted"
+
+		default:
+			workload.Status = "pending"
+
+			if c, ok := findCondition(objectConditions(&obj).Conditions, "Unschedulable"); ok && c.Status == "True" {
+				workload.Reason = c.Reason
+				workload.Message = c.Message
+			}
+		}
+
+		result.Workloads = append(result.Workloads, workload)
+	}
+
+	return nil
+}
+
+// preemptionEvents returns the preemptions reported by the schedulers,
+// newest first.
+func preemptionEvents(ctx context.Context, client *kubernetesClient, namespace string) ([]BatchEvent, error) {
+	path := "/api/v1/events"
+
+	if namespace != "" {
+		path = "/api/v1/namespaces/" + namespace + "/events"
+	}
+
+	query := url.Values{
+		"fieldSelector": {"reason=Preempted"},
+	}
+
+	var events corev1.EventList
+
+	if err := client.get(ctx, path, query, &events); err != nil {
+		return nil, err
+	}
+
+	result := []BatchEvent{}
+
+	for _, e := range events.Items {
+		t := e.LastTimestamp.Time
+
+		if t.IsZero() {
+			t = e.EventTime.Time
+		}
+
+		result = append(result, BatchEvent{
+			Time: t,
+
+			Namespace: e.InvolvedObject.Namespace,
+			Kind:      e.InvolvedObject.Kind,
+			Name:      e.InvolvedObject.Name,
+
+			Reason:  e.Reason,
+			Message: e.Message,
+			Count:   e.Count,
+		})
+	}
+
+	slices.SortFunc(result, func(a, b BatchEvent) int {
+		return b.Time.Compare(a.Time)
+	})
+
+	return result, nil
+}
+
+func nestedInt(obj map[string]any, fields ...string) int64 {
+	v, _, _ := unstructured.NestedFieldNoCopy(obj, fields...)
+
+	switch n := v.(type) {
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+
+	return 0
+}
+
+func nestedString(obj map[string]any, fields ...string) string {
+	v, _, _ := unstructured.NestedString(obj, fields...)
+	return v
+}
+
+// stringValue formats quantities, which are strings or numbers in JSON.
+func stringValue(v any) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	}
+
+	data, _ := json.Marshal(v)
+	return string(data)
+}
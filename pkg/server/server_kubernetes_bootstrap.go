@@ -0,0 +1,379 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+)
+
+type bootstrapStack struct {
+	Name        string
+	Title       string
+	Description string
+
+	Charts []bootstrapChart
+}
+
+type bootstrapChart struct {
+	Release   string
+	Namespace string
+
+	Repository string
+	Chart      string
+	Version    string
+
+	Values []string
+}
+
+// bootstrapStacks are curated starter stacks for fresh clusters.
+var bootstrapStacks = []bootstrapStack{
+	{
+		Name:        "ingress",
+		Title:       "Ingress NGINX",
+		Description: "Ingress controller exposing HTTP and HTTPS services",
+
+		Charts: []bootstrapChart{
+			{Release: "ingress-nginx", Namespace: "ingress-nginx", Repository: "https://kubernetes.github.io/ingress-nginx", Chart: "ingress-nginx", Version: "4.13.3"},
+		},
+	},
+	{
+		Name:        "cert-manager",
+		Title:       "cert-manager",
+		Description: "Automated TLS certificates from ACME and private issuers",
+
+		Charts: []bootstrapChart{
+			{Release: "cert-manager", Namespace: "cert-manager", Repository: "https://charts.jetstack.io", Chart: "cert-manager", Version: "v1.19.1", Values: []string{"crds.enabled=true"}},
+		},
+	},
+	{
+		Name:        "metrics",
+		Title:       "Metrics Server",
+		Description: "Resource metrics for kubectl top and horizontal pod autoscaling",
+
+		Charts: []bootstrapChart{
+			{Release: "metrics-server", Namespace: "kube-system", Repository: "https://kubernetes-sigs.github.io/metrics-server", Chart: "metrics-server", Version: "3.13.0"},
+		},
+	},
+	{
+		Name:        "dashboards",
+		Title:       "Prometheus & Grafana",
+		Description: "Cluster monitoring with the default Grafana dashboards",
+
+		Charts: []bootstrapChart{
+			{Release: "kube-prometheus-stack", Namespace: "monitoring", Repository: "https://prometheus-community.github.io/helm-charts", Chart: "kube-prometheus-stack", Version: "79.1.0"},
+		},
+	},
+	{
+		Name:        "starter",
+		Title:       "Starter",
+		Description: "Ingress, certificates and resource metrics",
+
+		Charts: []bootstrapChart{
+			{Release: "ingress-nginx", Namespace: "ingress-nginx", Repository: "https://kubernetes.github.io/ingress-nginx", Chart: "ingress-nginx", Version: "4.13.3"},
+			{Release: "cert-manager", Namespace: "cert-manager", Repository: "https://charts.jetstack.io", Chart: "cert-manager", Version: "v1.19.1", Values: []string{"crds.enabled=true"}},
+			{Release: "metrics-server", Namespace: "kube-system", Repository: "https://kubernetes-sigs.github.io/metrics-server", Chart: "metrics-server", Version: "3.13.0"},
+		},
+	},
+}
+
+// bootstrapPermissions are required to install charts with cluster-wide
+// resources.
+var bootstrapPermissions = []authorizationv1.ResourceAttributes{
+	{Verb: "create", Resource: "namespaces"},
+	{Verb: "create", Group: "rbac.authorization.k8s.io", Resource: "clusterroles"},
+	{Verb: "create", Group: "rbac.authorization.k8s.io", Resource: "clusterrolebindings"},
+	{Verb: "create", Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions"},
+}
+
+func findBootstrapStack(name string) (*bootstrapStack, bool) {
+	for i := range bootstrapStacks {
+		if bootstrapStacks[i].Name == name {
+			return &bootstrapStacks[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func (s *Server) handleListBootstrapStacks(w http.ResponseWriter, r *http.Request) {
+	result := []BootstrapStack{}
+
+	for _, stack := range bootstrapStacks {
+		item := BootstrapStack{
+			Name:        stack.Name,
+			Title:       stack.Title,
+			Description: stack.Description,
+
+			Charts: []BootstrapChart{},
+		}
+
+		for _, c := range stack.Charts {
+			item.Charts = append(item.Charts, BootstrapChart{
+				Release:   c.Release,
+				Namespace: c.Namespace,
+
+				Repository: c.Repository,
+				Chart:      c.Chart,
+				Version:    c.Version,
+			})
+		}
+
+		result = append(result, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleBootstrap installs a starter stack with helm, streaming preflight
+// results and installation progress as server-sent events. With
+// ?dryRun=true only the preflight checks are run.
+func (s *Server) handleBootstrap(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+
+	stack, ok := findBootstrapStack(r.PathValue("stack"))
+
+	if !ok {
+		http.Error(w, "stack not found", http.StatusNotFound)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	var mu sync.Mutex
+
+	send := func(event string, v any) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		data, _ := json.Marshal(v)
+
+		w.Write([]byte("event: " + event + "\ndata: "))
+		w.Write(data)
+		w.Write([]byte("\n\n"))
+
+		rc.Flush()
+	}
+
+	result := &BootstrapResult{
+		Stack: stack.Name,
+
+		Checks:   s.bootstrapPreflight(r, client, stack),
+		Releases: []BootstrapRelease{},
+	}
+
+	for _, check := range result.Checks {
+		send("check", check)
+	}
+
+	for _, check := range result.Checks {
+		if !check.Passed {
+			result.Error = "preflight checks failed"
+		}
+	}
+
+	if result.Error != "" || r.URL.Query().Get("dryRun") == "true" {
+		send("done", result)
+		return
+	}
+
+	for _, chart := range stack.Charts {
+		release := BootstrapRelease{
+			Release:   chart.Release,
+			Namespace: chart.Namespace,
+			Chart:     chart.Chart,
+			Version:   chart.Version,
+		}
+
+		send("progress", &BootstrapProgress{Release: chart.Release, Status: "installing"})
+
+		args := []string{
+			"upgrade", chart.Release, chart.Chart,
+			"--install",
+			"--repo", chart.Repository,
+			"--version", chart.Version,
+			"--namespace", chart.Namespace,
+			"--create-namespace",
+			"--wait",
+			"--timeout", "10m",
+		}
+
+		for _, v := range chart.Values {
+			args = append(args, "--set", v)
+		}
+
+		out := &progressWriter{
+			send: func(line string) {
+				send("progress", &BootstrapProgress{Release: chart.Release, Status: "installing", Message: line})
+			},
+		}
+
+		err := s.runHelm(r.Context(), r, name, out, out, args...)
+		out.Close()
+
+		entry := &AuditEntry{
+			Context: name,
+			Owner:   ownerID(auth),
+			Action:  "bootstrap",
+
+			Resource:  "helmreleases",
+			Namespace: chart.Namespace,
+			Name:      chart.Release,
+		}
+
+		if err != nil {
+			entry.Error = err.Error()
+		}
+
+		s.audit.record(entry)
+
+		if err != nil {
+			release.Error = err.Error()
+			result.Releases = append(result.Releases, release)
+			result.Error = fmt.Sprintf("failed to install %s", chart.Release)
+
+			send("progress", &BootstrapProgress{Release: chart.Release, Status: "failed", Message: err.Error()})
+			break
+		}
+
+		release.Installed = true
+		result.Releases = append(result.Releases, release)
+
+		send("progress", &BootstrapProgress{Release: chart.Release, Status: "installed"})
+	}
+
+	send("done", result)
+}
+
+func (s *Server) bootstrapPreflight(r *http.Request, client *kubernetesClient, stack *bootstrapStack) []BootstrapCheck {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	var checks []BootstrapCheck
+
+	check := func(name string, err error) {
+		c := BootstrapCheck{Name: name, Passed: err == nil}
+
+		if err != nil {
+			c.Message = err.Error()
+		}
+
+		checks = append(checks, c)
+	}
+
+	_, err := exec.LookPath("helm")
+	check("helm is installed", err)
+
+	var version struct {
+		GitVersion string `json:"gitVersion"`
+	}
+
+	err = client.get(ctx, "/version", nil, &version)
+	check("api server is reachable", err)
+
+	if err != nil {
+		return checks
+	}
+
+	for _, attrs := range bootstrapPermissions {
+		allowed, err := accessAllowed(ctx, client, attrs)
+
+		if err == nil && !allowed {
+			err = errors.New("not allowed")
+		}
+
+		check(fmt.Sprintf("can %s %s", attrs.Verb, attrs.Resource), err)
+	}
+
+	for _, chart := range stack.Charts {
+		check("namespace "+chart.Namespace+" is writable", s.checkProtection(r, r.PathValue("context"), chart.Namespace))
+	}
+
+	for _, chart := range stack.Charts {
+		release, err := latestHelmRelease(ctx, client, chart.Namespace, chart.Release)
+
+		if errors.Is(err, errReleaseNotFound) {
+			continue
+		}
+
+		if err == nil && release.Chart.Metadata.Name != "" && release.Chart.Metadata.Name != chart.Chart {
+			// another chart owns the release name
+			err = fmt.Errorf("release %s is an installation of %s", chart.Release, release.Chart.Metadata.Name)
+		}
+
+		check("release "+chart.Release+" can be upgraded", err)
+	}
+
+	return checks
+}
+
+// accessAllowed checks a permission of the caller with a SelfSubjectAccessReview.
+func accessAllowed(ctx context.Context, client *kubernetesClient, attrs authorizationv1.ResourceAttributes) (bool, error) {
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &attrs,
+		},
+	}
+
+	if err := client.create(ctx, "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews", review, review); err != nil {
+		return false, err
+	}
+
+	return review.Status.Allowed, nil
+}
+
+// progressWriter splits command output into lines.
+type progressWriter struct {
+	send func(line string)
+
+	buf []byte
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+
+		if i < 0 {
+			return len(p), nil
+		}
+
+		if line := strings.TrimSpace(string(w.buf[:i])); line != "" {
+			w.send(line)
+		}
+
+		w.buf = w.buf[i+1:]
+	}
+}
+
+func (w *progressWriter) Close() error {
+	if line := strings.TrimSpace(string(w.buf)); line != "" {
+		w.send(line)
+	}
+
+	w.buf = nil
+	return nil
+}
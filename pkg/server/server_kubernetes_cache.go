@@ -0,0 +1,625 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// informerListLimit is the page size of the initial lists
+	informerListLimit = 500
+
+	// informerRetry delays a new attempt of a failed informer
+	informerRetry = time.Minute
+)
+
+// informers keep configured resources of a context in memory, listed once
+// and kept current by a watch. Informers are started by the first request of
+// their resource, shared by requests of the same caller, and stopped when
+// idle.
+type informers struct {
+	mu      sync.Mutex
+	entries map[string]*informer
+}
+
+type informer struct {
+	key string
+
+	context  string
+	resource string
+
+	transport http.RoundTripper
+	url       *url.URL
+
+	cancel context.CancelFunc
+
+	mu sync.RWMutex
+
+	apiVersion string
+	kind       string
+
+	resourceVersion string
+	objects         map[string]*cachedObject
+
+	synced  bool
+	updated time.Time
+	used    time.Time
+
+	err error
+}
+
+type cachedObject struct {
+	kind string
+
+	namespace string
+	name      string
+	labels    labels.Set
+
+	raw json.RawMessage
+}
+
+// get returns the running informer of a key or starts one.
+func (i *informers) get(key string, build func() *informer) *informer {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if inf, ok := i.entries[key]; ok {
+		return inf
+	}
+
+	if i.entries == nil {
+		i.entries = make(map[string]*informer)
+	}
+
+	inf := build()
+	inf.key = key
+	inf.used = time.Now()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	inf.cancel = cancel
+
+	i.entries[key] = inf
+
+	go inf.run(ctx)
+
+	return inf
+}
+
+// reap stops informers that were not used within the idle timeout.
+func (i *informers) reap(done <-chan struct{}, idle func() time.Duration) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			i.evict(func(*informer) bool { return true })
+			return
+
+		case <-ticker.C:
+			timeout := idle()
+
+			i.evict(func(inf *informer) bool {
+				inf.mu.RLock()
+				defer inf.mu.RUnlock()
+
+				return time.Since(inf.used) > timeout
+			})
+		}
+	}
+}
+
+func (i *informers) evict(filter func(*informer) bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for key, inf := range i.entries {
+		if filter(inf) {
+			inf.cancel()
+			delete(i.entries, key)
+		}
+	}
+}
+
+func (i *informers) evictContext(name string) {
+	i.evict(func(inf *informer) bool {
+		return strings.EqualFold(inf.context, name)
+	})
+}
+
+func (inf *informer) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := inf.list(ctx)
+
+		if err == nil {
+			err = inf.watch(ctx)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		inf.mu.Lock()
+		inf.synced = false
+
+		if err != nil {
+			inf.err = err
+
+			log.Printf("cache of %s in context %q failed: %v", inf.resource, inf.context, err)
+		}
+
+		inf.mu.Unlock()
+
+		if err == nil {
+			// expired resource version, relist immediately
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-time.After(informerRetry):
+		}
+	}
+}
+
+// list loads all objects page by page and replaces the cached ones.
+func (inf *informer) list(ctx context.Context) error {
+	objects := make(map[string]*cachedObject)
+
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(informerListLimit))
+
+	var list struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+			Continue        string `json:"continue"`
+		} `json:"metadata"`
+
+		Items []json.RawMessage `json:"items"`
+	}
+
+	for {
+		u := *inf.url
+		u.RawQuery = query.Encode()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := inf.transport.RoundTrip(req)
+
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+
+			return &upstreamError{
+				StatusCode: resp.StatusCode,
+				Header:     resp.Header,
+				Body:       body,
+			}
+		}
+
+		list.Items = nil
+		list.Metadata.Continue = ""
+
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+
+		if err != nil {
+			return err
+		}
+
+		kind := strings.TrimSuffix(list.Kind, "List")
+
+		for _, raw := range list.Items {
+			obj, ok := decodeCachedObject(raw)
+
+			if !ok {
+				continue
+			}
+
+			// items of built-in lists omit their type, which gets need
+			if obj.kind == "" && len(raw) > 1 {
+				obj.raw = fmt.Appendf(nil, `{"apiVersion":%q,"kind":%q,%s`, list.APIVersion, kind, raw[1:])
+			}
+
+			objects[obj.key()] = obj
+		}
+
+		if list.Metadata.Continue == "" {
+			break
+		}
+
+		query.Set("continue", list.Metadata.Continue)
+	}
+
+	inf.mu.Lock()
+	defer inf.mu.Unlock()
+
+	inf.apiVersion = list.APIVersion
+	inf.kind = list.Kind
+
+	inf.resourceVersion = list.Metadata.ResourceVersion
+	inf.objects = objects
+
+	return nil
+}
+
+// watch applies changes to the cache until the resource version expires.
+func (inf *informer) watch(ctx context.Context) error {
+	u := *inf.url
+
+	query := url.Values{}
+	query.Set("resourceVersion", inf.resourceVersion)
+
+	u.RawQuery = query.Encode()
+
+	w := &resumableWatch{
+		Transport: inf.transport,
+
+		URL:    &u,
+		Header: http.Header{},
+
+		Bookmarks: true,
+
+		OnEvent: func(e watchEvent) error {
+			inf.apply(e)
+			return nil
+		},
+
+		OnState: func(state watchState) {
+			inf.mu.Lock()
+			defer inf.mu.Unlock()
+
+			// requests are passed through while changes may be missing
+			inf.synced = state == watchConnected
+
+			if inf.synced {
+				inf.err = nil
+				inf.updated = time.Now()
+			}
+		},
+	}
+
+	return w.Run(ctx)
+}
+
+func (inf *informer) apply(e watchEvent) {
+	if e.Type == "ERROR" {
+		return
+	}
+
+	var meta watchObject
+	json.Unmarshal(e.Object, &meta)
+
+	inf.mu.Lock()
+	defer inf.mu.Unlock()
+
+	inf.updated = time.Now()
+
+	if meta.Metadata.ResourceVersion != "" {
+		inf.resourceVersion = meta.Metadata.ResourceVersion
+	}
+
+	if e.Type == "BOOKMARK" {
+		return
+	}
+
+	obj, ok := decodeCachedObject(e.Object)
+
+	if !ok {
+		return
+	}
+
+	switch e.Type {
+	case "ADDED", "MODIFIED":
+		inf.objects[obj.key()] = obj
+
+	case "DELETED":
+		delete(inf.objects, obj.key())
+	}
+}
+
+func decodeCachedObject(raw json.RawMessage) (*cachedObject, bool) {
+	var obj struct {
+		Kind string `json:"kind"`
+
+		Metadata struct {
+			Namespace string            `json:"namespace"`
+			Name      string            `json:"name"`
+			Labels    map[string]string `json:"labels"`
+		} `json:"metadata"`
+	}
+
+	if err := json.Unmarshal(raw, &obj); err != nil || obj.Metadata.Name == "" {
+		return nil, false
+	}
+
+	return &cachedObject{
+		kind: obj.Kind,
+
+		namespace: obj.Metadata.Namespace,
+		name:      obj.Metadata.Name,
+		labels:    obj.Metadata.Labels,
+
+		raw: raw,
+	}, true
+}
+
+func (o *cachedObject) key() string {
+	return o.namespace + "/" + o.name
+}
+
+// serve answers a request from the cache. It reports false if the cache is
+// not synced or cannot answer the request, e.g. a list exceeding its limit
+// or an object that may not have been seen yet.
+func (inf *informer) serve(req *http.Request, target *kubernetesRequest, selector labels.Selector, limit int) (*http.Response, bool) {
+	inf.mu.Lock()
+	inf.used = time.Now()
+	inf.mu.Unlock()
+
+	inf.mu.RLock()
+	defer inf.mu.RUnlock()
+
+	if !inf.synced {
+		return nil, false
+	}
+
+	var body []byte
+
+	if target.IsList() {
+		var items []*cachedObject
+
+		for _, obj := range inf.objects {
+			if target.Namespace != "" && obj.namespace != target.Namespace {
+				continue
+			}
+
+			if !selector.Matches(obj.labels) {
+				continue
+			}
+
+			items = append(items, obj)
+		}
+
+		if limit > 0 && len(items) > limit {
+			// pages need continue tokens of the upstream
+			return nil, false
+		}
+
+		slices.SortFunc(items, func(a, b *cachedObject) int {
+			return strings.Compare(a.key(), b.key())
+		})
+
+		var buf bytes.Buffer
+
+		fmt.Fprintf(&buf, `{"apiVersion":%q,"kind":%q,"metadata":{"resourceVersion":%q},"items":[`, inf.apiVersion, inf.kind, inf.resourceVersion)
+
+		for i, obj := range items {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			buf.Write(obj.raw)
+		}
+
+		buf.WriteString("]}")
+
+		body = buf.Bytes()
+	} else {
+		obj, ok := inf.objects[target.Namespace+"/"+target.Name]
+
+		if !ok {
+			return nil, false
+		}
+
+		body = obj.raw
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("X-Bridge-Cache", "hit")
+	header.Set("X-Bridge-Cache-Updated", inf.updated.UTC().Format(time.RFC3339))
+
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+
+		Header: header,
+		Body:   io.NopCloser(bytes.NewReader(body)),
+
+		ContentLength: int64(len(body)),
+
+		Request: req,
+	}, true
+}
+
+// cacheTransport answers list and get requests of cached resources from
+// informers and passes all other requests through.
+type cacheTransport struct {
+	http.RoundTripper
+
+	server *Server
+
+	context string
+	owner   string
+
+	target *url.URL
+}
+
+// cachedTransport wraps the transport of a context with the informer cache,
+// if caching is configured.
+func (s *Server) cachedTransport(c config.KubernetesContext, auth *config.AuthInfo, tr http.RoundTripper, target *url.URL) http.RoundTripper {
+	if s.config.Cache == nil {
+		return tr
+	}
+
+	return &cacheTransport{
+		RoundTripper: tr,
+
+		server: s,
+
+		context: c.Name,
+		owner:   credentialID(auth),
+
+		target: target,
+	}
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, selector, limit, ok := t.cacheable(req)
+
+	if !ok {
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	// one informer per resource serves all namespaces
+	collection := *target
+	collection.Namespace = ""
+	collection.Name = ""
+
+	path := collection.Path()
+
+	inf := t.server.informers.get("kubernetes/"+strings.ToLower(t.context)+"/"+t.owner+path, func() *informer {
+		u := *t.target
+		u.Path = strings.TrimSuffix(u.Path, "/") + path
+
+		return &informer{
+			context:  t.context,
+			resource: path,
+
+			transport: t.RoundTripper,
+			url:       &u,
+		}
+	})
+
+	if resp, ok := inf.serve(req, target, selector, limit); ok {
+		return resp, nil
+	}
+
+	resp, err := t.RoundTripper.RoundTrip(req)
+
+	if err == nil {
+		resp.Header.Set("X-Bridge-Cache", "miss")
+	}
+
+	return resp, err
+}
+
+// cacheable reports whether a request can be answered by an informer: a
+// plain JSON list or get of a cached resource, without field selectors or
+// specific resource versions.
+func (t *cacheTransport) cacheable(req *http.Request) (*kubernetesRequest, labels.Selector, int, bool) {
+	if req.Method != http.MethodGet || isWatchRequest(req) || req.Header.Get("Upgrade") != "" {
+		return nil, nil, 0, false
+	}
+
+	if accept := req.Header.Get("Accept"); !acceptsJSON(req) || strings.Contains(accept, "as=") {
+		return nil, nil, 0, false
+	}
+
+	target, ok := parseKubernetesPath(req.URL.Path)
+
+	if !ok || target.Subresource != "" || !t.server.config.Cache.Cached(target.Group, target.Resource) {
+		return nil, nil, 0, false
+	}
+
+	query := req.URL.Query()
+
+	for key := range query {
+		if !slices.Contains([]string{"labelSelector", "limit", "resourceVersion", "pretty"}, key) {
+			return nil, nil, 0, false
+		}
+	}
+
+	if v := query.Get("resourceVersion"); v != "" && v != "0" {
+		return nil, nil, 0, false
+	}
+
+	selector, err := labels.Parse(query.Get("labelSelector"))
+
+	if err != nil {
+		return nil, nil, 0, false
+	}
+
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	return target, selector, limit, true
+}
+
+func (s *Server) handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	s.informers.mu.Lock()
+
+	result := make([]CacheStats, 0, len(s.informers.entries))
+
+	for _, inf := range s.informers.entries {
+		result = append(result, inf.stats())
+	}
+
+	s.informers.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Context != result[j].Context {
+			return result[i].Context < result[j].Context
+		}
+
+		return result[i].Resource < result[j].Resource
+	})
+
+	writeList(w, r, "caches", result, result)
+}
+
+func (inf *informer) stats() CacheStats {
+	inf.mu.RLock()
+	defer inf.mu.RUnlock()
+
+	stats := CacheStats{
+		Context:  inf.context,
+		Resource: inf.resource,
+
+		Objects: len(inf.objects),
+		Synced:  inf.synced,
+
+		Updated:  inf.updated,
+		LastUsed: inf.used,
+	}
+
+	if inf.err != nil {
+		stats.Error = inf.err.Error()
+	}
+
+	return stats
+}
@@ -0,0 +1,712 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// canaryLabel marks the canary deployment and service of a deployment
+	canaryLabel = "bridge/canary"
+
+	// canaryReadyTimeout bounds the rollout of the canary and, when
+	// promoting, of the deployment
+	canaryReadyTimeout = 10 * time.Minute
+
+	// canaryCleanupTimeout bounds the rollback, which also runs after the
+	// client went away
+	canaryCleanupTimeout = time.Minute
+)
+
+// canaryRun is a canary of a deployment and the changes to undo after it.
+type canaryRun struct {
+	client *kubernetesClient
+
+	namespace string
+	name      string
+	canary    string
+
+	container string
+	image     string
+
+	req *CanaryRequest
+
+	// query returns the error rate of the canary, if configured
+	query func(ctx context.Context) (*float64, error)
+
+	send func(step CanaryStep)
+
+	createdDeployment bool
+	createdService    string
+	patchedRoute      []any
+}
+
+// handleCanary runs a canary of a deployment: a copy with a new image takes
+// a share of the traffic of the service, by labels selecting its pods too or
+// by the weights of an HTTPRoute. The canary is monitored for restarts and,
+// if a query is given, for its error rate in Prometheus. Healthy canaries
+// are promoted by rolling out the image to the deployment, failing ones are
+// rolled back. All steps are streamed as server-sent events; if the client
+// goes away, the canary is rolled back.
+func (s *Server) handleCanary(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	namespace := r.PathValue("namespace")
+
+	var req CanaryRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Image == "" {
+		http.Error(w, "image is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Weight == 0 {
+		req.Weight = 10
+	}
+
+	if req.Weight < 1 || req.Weight > 99 {
+		http.Error(w, "weight must be between 1 and 99", http.StatusBadRequest)
+		return
+	}
+
+	if req.Route != "" && req.Service == "" {
+		http.Error(w, "service is required to shift traffic by route", http.StatusBadRequest)
+		return
+	}
+
+	duration, interval := 5*time.Minute, 30*time.Second
+
+	for _, v := range []struct {
+		value string
+		into  *time.Duration
+	}{{req.Duration, &duration}, {req.Interval, &interval}} {
+		if v.value == "" {
+			continue
+		}
+
+		d, err := time.ParseDuration(v.value)
+
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid duration "+v.value, http.StatusBadRequest)
+			return
+		}
+
+		*v.into = d
+	}
+
+	if err := s.checkProtection(r, name, namespace); err != nil {
+		writeProtectionError(w, r, err)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	stable := &unstructured.Unstructured{}
+
+	if err := client.get(r.Context(), "/apis/apps/v1/namespaces/"+namespace+"/deployments/"+r.PathValue("name"), nil, stable); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	run := &canaryRun{
+		client: client,
+
+		namespace: namespace,
+		name:      stable.GetName(),
+		canary:    stable.GetName() + "-canary",
+
+		container: req.Container,
+		image:     req.Image,
+
+		req: &req,
+	}
+
+	containers, _, _ := unstructured.NestedSlice(stable.Object, "spec", "template", "spec", "containers")
+
+	if run.container == "" {
+		if len(containers) != 1 {
+			http.Error(w, "container is required for deployments with several containers", http.StatusBadRequest)
+			return
+		}
+
+		run.container, _, _ = unstructured.NestedString(containers[0].(map[string]any), "name")
+	}
+
+	if req.ErrorQuery != "" {
+		caps := s.contextCapabilities(r.Context(), name, auth)
+
+		if caps.Prometheus == nil {
+			http.Error(w, "prometheus not found in context "+name, http.StatusBadRequest)
+			return
+		}
+
+		query := strings.NewReplacer("$canary", run.canary, "$namespace", namespace).Replace(req.ErrorQuery)
+
+		run.query = func(ctx context.Context) (*float64, error) {
+			return queryPrometheusScalar(ctx, client, caps.Prometheus, query)
+		}
+	}
+
+	stream := &sseStream{
+		w:  w,
+		rc: http.NewResponseController(w),
+	}
+
+	run.send = func(step CanaryStep) {
+		step.Time = time.Now().UTC()
+
+		data, _ := json.Marshal(step)
+		stream.send("", "step", data)
+	}
+
+	stream.start()
+
+	result := &CanaryResult{
+		Deployment: run.name,
+		Canary:     run.canary,
+
+		Container: run.container,
+		Image:     run.image,
+		Weight:    req.Weight,
+	}
+
+	err = run.start(r.Context(), stable)
+
+	if err == nil {
+		err = run.analyze(r.Context(), duration, interval)
+	}
+
+	if err == nil {
+		err = run.promote(r.Context())
+		result.Promoted = err == nil
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+
+		run.send(CanaryStep{Phase: "failed", Message: err.Error()})
+	}
+
+	// rolls back even if the client went away
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), canaryCleanupTimeout)
+	defer cancel()
+
+	if err := run.cleanup(ctx, result.Promoted); err != nil {
+		if result.Error != "" {
+			err = fmt.Errorf("%s, %w", result.Error, err)
+		}
+
+		result.Error = err.Error()
+	}
+
+	entry := &AuditEntry{
+		Context: name,
+		Owner:   ownerID(auth),
+		Action:  "canary",
+
+		Resource:  "deployments",
+		Namespace: namespace,
+		Name:      run.name,
+
+		Error: result.Error,
+	}
+
+	if result.Promoted {
+		entry.Action = "canary-promote"
+	}
+
+	s.audit.record(entry)
+
+	data, _ := json.Marshal(result)
+	stream.send("", "done", data)
+}
+
+// start creates the canary and shifts traffic to it once it is ready.
+func (c *canaryRun) start(ctx context.Context, stable *unstructured.Unstructured) error {
+	replicas, ok, _ := unstructured.NestedInt64(stable.Object, "spec", "replicas")
+
+	if !ok {
+		replicas = 1
+	}
+
+	// the share of the pods, or of the route, of the canary
+	canaryReplicas := max(1, int64(math.Round(float64(replicas)*float64(c.req.Weight)/float64(100-c.req.Weight))))
+
+	obj := stable.DeepCopy()
+	spec := podTemplatePaths["Deployment"]
+
+	selector, _, _ := unstructured.NestedStringMap(stable.Object, "spec", "selector", "matchLabels")
+
+	cleanClone(obj, spec, c.canary)
+
+	labels, _, _ := unstructured.NestedStringMap(obj.Object, "spec", "template", "metadata", "labels")
+
+	if c.req.Route == "" {
+		// the services of the deployment select the pods of the canary too
+		for key, value := range selector {
+			labels[key] = value
+		}
+	} else {
+		var service corev1.Service
+
+		if err := c.client.get(ctx, "/api/v1/namespaces/"+c.namespace+"/services/"+c.req.Service, nil, &service); err != nil {
+			return err
+		}
+
+		for key := range service.Spec.Selector {
+			delete(labels, key)
+		}
+	}
+
+	unstructured.SetNestedStringMap(obj.Object, labels, "spec", "template", "metadata", "labels")
+
+	objLabels := obj.GetLabels()
+
+	if objLabels == nil {
+		objLabels = map[string]string{}
+	}
+
+	objLabels[canaryLabel] = c.name
+	obj.SetLabels(objLabels)
+
+	if err := customizeClone(obj, spec, &CloneRequest{Images: map[string]string{c.container: c.image}, Replicas: &canaryReplicas}); err != nil {
+		return err
+	}
+
+	if err := c.client.create(ctx, "/apis/apps/v1/namespaces/"+c.namespace+"/deployments", obj.Object, nil); err != nil {
+		return err
+	}
+
+	c.createdDeployment = true
+
+	c.send(CanaryStep{Phase: "created", Message: fmt.Sprintf("created %s with %d of %d replicas", c.canary, canaryReplicas, replicas+canaryReplicas), Replicas: canaryReplicas})
+
+	if err := c.waitReady(ctx, c.canary, true); err != nil {
+		return err
+	}
+
+	c.send(CanaryStep{Phase: "ready", Message: c.canary + " is ready"})
+
+	if c.req.Route == "" {
+		share := float64(canaryReplicas) / float64(replicas+canaryReplicas) * 100
+		c.send(CanaryStep{Phase: "traffic", Message: fmt.Sprintf("%s receives about %.0f%% of the traffic of its services", c.canary, share)})
+
+		return nil
+	}
+
+	if err := c.createService(ctx); err != nil {
+		return err
+	}
+
+	if err := c.shiftRoute(ctx); err != nil {
+		return err
+	}
+
+	c.send(CanaryStep{Phase: "traffic", Message: fmt.Sprintf("route %s sends %d%% of the traffic of %s to %s", c.req.Route, c.req.Weight, c.req.Service, c.createdService)})
+
+	return nil
+}
+
+// createService creates a service selecting the pods of the canary only.
+func (c *canaryRun) createService(ctx context.Context) error {
+	var stable corev1.Service
+
+	if err := c.client.get(ctx, "/api/v1/namespaces/"+c.namespace+"/services/"+c.req.Service, nil, &stable); err != nil {
+		return err
+	}
+
+	service := &corev1.Service{}
+	service.APIVersion = "v1"
+	service.Kind = "Service"
+
+	service.Name = stable.Name + "-canary"
+	service.Namespace = c.namespace
+	service.Labels = map[string]string{canaryLabel: c.name}
+
+	service.Spec.Selector = map[string]string{cloneLabel: c.canary}
+
+	for _, p := range stable.Spec.Ports {
+		p.NodePort = 0
+		service.Spec.Ports = append(service.Spec.Ports, p)
+	}
+
+	if err := c.client.create(ctx, "/api/v1/namespaces/"+c.namespace+"/services", service, nil); err != nil {
+		return err
+	}
+
+	c.createdService = service.Name
+
+	return nil
+}
+
+func (c *canaryRun) routePath() string {
+	return "/apis/gateway.networking.k8s.io/v1/namespaces/" + c.namespace + "/httproutes/" + c.req.Route
+}
+
+// shiftRoute splits the weight of the backends of the service in the rules
+// of the route between the service and the canary service.
+func (c *canaryRun) shiftRoute(ctx context.Context) error {
+	route := &unstructured.Unstructured{}
+
+	if err := c.client.get(ctx, c.routePath(), nil, route); err != nil {
+		return err
+	}
+
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+	original := runtime.DeepCopyJSONValue(rules).([]any)
+
+	shifted := false
+
+	for _, r := range rules {
+		rule, _ := r.(map[string]any)
+		refs, _ := rule["backendRefs"].([]any)
+
+		var canaries []any
+
+		for _, b := range refs {
+			ref, _ := b.(map[string]any)
+
+			if !isServiceRef(ref, c.req.Service) {
+				continue
+			}
+
+			weight := int64(1)
+
+			if w, ok := ref["weight"].(int64); ok {
+				weight = w
+			}
+
+			canary := map[string]any{}
+
+			for k, v := range ref {
+				canary[k] = v
+			}
+
+			canary["name"] = c.createdService
+			canary["weight"] = weight * int64(c.req.Weight)
+
+			ref["weight"] = weight * int64(100-c.req.Weight)
+
+			canaries = append(canaries, canary)
+		}
+
+		if len(canaries) > 0 {
+			rule["backendRefs"] = append(refs, canaries...)
+			shifted = true
+		}
+	}
+
+	if !shifted {
+		return fmt.Errorf("route %s has no backend of service %s", c.req.Route, c.req.Service)
+	}
+
+	unstructured.SetNestedSlice(route.Object, rules, "spec", "rules")
+
+	if err := c.client.update(ctx, c.routePath(), nil, route.Object, nil); err != nil {
+		return err
+	}
+
+	c.patchedRoute = original
+
+	return nil
+}
+
+func isServiceRef(ref map[string]any, name string) bool {
+	group, _ := ref["group"].(string)
+	kind, _ := ref["kind"].(string)
+
+	return ref["name"] == name && group == "" && (kind == "" || kind == "Service")
+}
+
+// analyze checks the canary in intervals until the duration passed.
+func (c *canaryRun) analyze(ctx context.Context, duration, interval time.Duration) error {
+	deadline := time.Now().Add(duration)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-ticker.C:
+		}
+
+		step := CanaryStep{Phase: "check"}
+
+		restarts, ready, err := c.pods(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		step.Restarts = restarts
+		step.Replicas = ready
+
+		if c.query != nil {
+			rate, err := c.query(ctx)
+
+			if err != nil {
+				return err
+			}
+
+			step.ErrorRate = rate
+		}
+
+		step.Message = fmt.Sprintf("%d ready pods, %d restarts", ready, restarts)
+
+		if step.ErrorRate != nil {
+			step.Message += fmt.Sprintf(", error rate %.4f", *step.ErrorRate)
+		}
+
+		c.send(step)
+
+		if restarts > c.req.MaxRestarts {
+			return fmt.Errorf("canary pods restarted %d times, at most %d restarts are allowed", restarts, c.req.MaxRestarts)
+		}
+
+		if step.ErrorRate != nil && *step.ErrorRate > c.req.MaxErrorRate {
+			return fmt.Errorf("canary error rate is %.4f, at most %.4f is allowed", *step.ErrorRate, c.req.MaxErrorRate)
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil
+		}
+	}
+}
+
+// pods returns the restarts and the number of ready pods of the canary.
+func (c *canaryRun) pods(ctx context.Context) (int64, int64, error) {
+	var pods corev1.PodList
+
+	query := url.Values{
+		"labelSelector": {cloneLabel + "=" + c.canary},
+	}
+
+	if err := c.client.get(ctx, "/api/v1/namespaces/"+c.namespace+"/pods", query, &pods); err != nil {
+		return 0, 0, err
+	}
+
+	var restarts, ready int64
+
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			restarts += int64(status.RestartCount)
+		}
+
+		if slices.ContainsFunc(pod.Status.Conditions, func(c corev1.PodCondition) bool {
+			return c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue
+		}) {
+			ready++
+		}
+	}
+
+	return restarts, ready, nil
+}
+
+// promote rolls out the image of the canary to the deployment.
+func (c *canaryRun) promote(ctx context.Context) error {
+	c.send(CanaryStep{Phase: "promoting", Message: fmt.Sprintf("rolling out %s to %s", c.image, c.name)})
+
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"template": map[string]any{
+				"spec": map[string]any{
+					"containers": []map[string]any{
+						{"name": c.container, "image": c.image},
+					},
+				},
+			},
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if err := c.client.patch(ctx, "/apis/apps/v1/namespaces/"+c.namespace+"/deployments/"+c.name, nil, "application/strategic-merge-patch+json", patch, nil); err != nil {
+		return err
+	}
+
+	if err := c.waitReady(ctx, c.name, false); err != nil {
+		return err
+	}
+
+	c.send(CanaryStep{Phase: "promoted", Message: c.name + " runs " + c.image})
+
+	return nil
+}
+
+// waitReady waits until all replicas of a deployment are updated and
+// available. Restarts of canary pods fail early.
+func (c *canaryRun) waitReady(ctx context.Context, name string, canary bool) error {
+	ctx, cancel := context.WithTimeout(ctx, canaryReadyTimeout)
+	defer cancel()
+
+	for {
+		var d appsv1.Deployment
+
+		if err := c.client.get(ctx, "/apis/apps/v1/namespaces/"+c.namespace+"/deployments/"+name, nil, &d); err != nil {
+			return err
+		}
+
+		replicas := int32(1)
+
+		if d.Spec.Replicas != nil {
+			replicas = *d.Spec.Replicas
+		}
+
+		if d.Status.ObservedGeneration >= d.Generation && d.Status.UpdatedReplicas == replicas && d.Status.AvailableReplicas == replicas && d.Status.Replicas == replicas {
+			return nil
+		}
+
+		for _, cond := range d.Status.Conditions {
+			if cond.Type == appsv1.DeploymentProgressing && cond.Reason == "ProgressDeadlineExceeded" {
+				return fmt.Errorf("deployment %s: %s", name, cond.Message)
+			}
+		}
+
+		if canary {
+			if restarts, _, err := c.pods(ctx); err == nil && restarts > c.req.MaxRestarts {
+				return fmt.Errorf("canary pods restarted %d times, at most %d restarts are allowed", restarts, c.req.MaxRestarts)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return errors.New("timed out waiting for deployment " + name)
+			}
+
+			return ctx.Err()
+
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// cleanup restores the route and deletes the canary.
+func (c *canaryRun) cleanup(ctx context.Context, promoted bool) error {
+	if !promoted && c.createdDeployment {
+		c.send(CanaryStep{Phase: "rollback", Message: "rolling back " + c.canary})
+	}
+
+	var errs []error
+
+	if c.patchedRoute != nil {
+		route := &unstructured.Unstructured{}
+
+		err := c.client.get(ctx, c.routePath(), nil, route)
+
+		if err == nil {
+			unstructured.SetNestedSlice(route.Object, c.patchedRoute, "spec", "rules")
+			err = c.client.update(ctx, c.routePath(), nil, route.Object, nil)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore route %s: %w", c.req.Route, err))
+		}
+	}
+
+	if c.createdService != "" {
+		if err := c.client.delete(ctx, "/api/v1/namespaces/"+c.namespace+"/services/"+c.createdService, nil); err != nil && statusCode(err) != http.StatusNotFound {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.createdDeployment {
+		if err := c.client.delete(ctx, "/apis/apps/v1/namespaces/"+c.namespace+"/deployments/"+c.canary, url.Values{"propagationPolicy": {"Foreground"}}); err != nil && statusCode(err) != http.StatusNotFound {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	if !promoted && c.createdDeployment {
+		c.send(CanaryStep{Phase: "rolledback", Message: c.canary + " was removed"})
+	}
+
+	return nil
+}
+
+// queryPrometheusScalar runs an instant query and returns its first value,
+// or nil if the query has no result (e.g. no requests yet).
+func queryPrometheusScalar(ctx context.Context, client *kubernetesClient, ref *ServiceRef, query string) (*float64, error) {
+	var resp struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+
+		Data struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+
+	if err := client.get(ctx, ref.proxyPath()+"/api/v1/query", url.Values{"query": {query}}, &resp); err != nil {
+		return nil, err
+	}
+
+	if resp.Status != "success" {
+		return nil, fmt.Errorf("prometheus: %s", resp.Error)
+	}
+
+	var value []any
+
+	switch resp.Data.ResultType {
+	case "scalar":
+		json.Unmarshal(resp.Data.Result, &value)
+
+	case "vector":
+		var vector []struct {
+			Value []any `json:"value"`
+		}
+
+		json.Unmarshal(resp.Data.Result, &vector)
+
+		if len(vector) > 0 {
+			value = vector[0].Value
+		}
+	}
+
+	if len(value) != 2 {
+		return nil, nil
+	}
+
+	s, _ := value[1].(string)
+
+	v, err := strconv.ParseFloat(s, 64)
+
+	if err != nil || math.IsNaN(v) {
+		return nil, nil
+	}
+
+	return &v, nil
+}
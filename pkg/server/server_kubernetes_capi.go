@@ -0,0 +1,359 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+// capiClusterLabel links machines and machine deployments to their cluster.
+const capiClusterLabel = "cluster.x-k8s.io/cluster-name"
+
+// capiAPI returns the Cluster API group version of a management cluster.
+func capiAPI(ctx context.Context, client *kubernetesClient, name string) (kubernetesRequest, error) {
+	version, err := preferredVersion(ctx, client, "cluster.x-k8s.io")
+
+	if err != nil {
+		return kubernetesRequest{}, err
+	}
+
+	if version == "" {
+		return kubernetesRequest{}, i18n.NewError("error.capi_unavailable", name)
+	}
+
+	return kubernetesRequest{Group: "cluster.x-k8s.io", Version: version}, nil
+}
+
+func writeCAPIError(w http.ResponseWriter, r *http.Request, err error) {
+	if _, ok := err.(*i18n.Error); ok {
+		writeError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	writeClientError(w, r, err)
+}
+
+// handleCAPIClusters lists the workload clusters of a Cluster API management
+// cluster with their lifecycle phase, readiness and machine deployments.
+// ?namespace limits the list to a namespace.
+func (s *Server) handleCAPIClusters(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	api, err := capiAPI(r.Context(), client, name)
+
+	if err != nil {
+		writeCAPIError(w, r, err)
+		return
+	}
+
+	namespace := r.URL.Query().Get("namespace")
+
+	api.Resource = "clusters"
+
+	clusters, err := listObjects(r.Context(), client, api, namespace)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	api.Resource = "machinedeployments"
+
+	deployments, err := listObjects(r.Context(), client, api, namespace)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := &CAPIClusterList{
+		Items: []CAPICluster{},
+	}
+
+	for _, obj := range clusters {
+		cluster := capiCluster(&obj)
+		cluster.Context = s.derivedContext(&config.ContextOrigin{Kind: "capi", Context: name, Namespace: cluster.Namespace, Name: cluster.Name})
+
+		for _, d := range deployments {
+			if d.GetNamespace() != cluster.Namespace || cmp.Or(nestedString(d.Object, "spec", "clusterName"), d.GetLabels()[capiClusterLabel]) != cluster.Name {
+				continue
+			}
+
+			cluster.MachineDeployments = append(cluster.MachineDeployments, capiMachineDeployment(&d))
+		}
+
+		slices.SortFunc(cluster.MachineDeployments, func(a, b CAPIMachineDeployment) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+
+		result.Items = append(result.Items, cluster)
+	}
+
+	slices.SortFunc(result.Items, func(a, b CAPICluster) int {
+		return strings.Compare(a.Namespace+"/"+a.Name, b.Namespace+"/"+b.Name)
+	})
+
+	writeList(w, r, "capi-clusters", result, result.Items)
+}
+
+func capiCluster(obj *unstructured.Unstructured) CAPICluster {
+	cluster := CAPICluster{
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Created:   obj.GetCreationTimestamp().Time,
+
+		Phase:   nestedString(obj.Object, "status", "phase"),
+		Version: nestedString(obj.Object, "spec", "topology", "version"),
+
+		ControlPlane:   nestedString(obj.Object, "spec", "controlPlaneRef", "kind"),
+		Infrastructure: nestedString(obj.Object, "spec", "infrastructureRef", "kind"),
+
+		ControlPlaneReady:   nestedBool(obj.Object, "status", "controlPlaneReady"),
+		InfrastructureReady: nestedBool(obj.Object, "status", "infrastructureReady"),
+
+		MachineDeployments: []CAPIMachineDeployment{},
+	}
+
+	// v1beta2 reports readiness as initialization and conditions
+	if v, ok, _ := unstructured.NestedBool(obj.Object, "status", "initialization", "controlPlaneInitialized"); ok {
+		cluster.ControlPlaneReady = v
+	}
+
+	if v, ok, _ := unstructured.NestedBool(obj.Object, "status", "initialization", "infrastructureProvisioned"); ok {
+		cluster.InfrastructureReady = v
+	}
+
+	conditions := objectConditions(obj).Conditions
+
+	if c, ok := findCondition(conditions, "Ready"); ok {
+		cluster.Ready = c.Status == "True"
+
+		if !cluster.Ready {
+			cluster.Message = cmp.Or(c.Message, c.Reason)
+		}
+	}
+
+	if message := nestedString(obj.Object, "status", "failureMessage"); message != "" {
+		cluster.Message = message
+	}
+
+	return cluster
+}
+
+func capiMachineDeployment(obj *unstructured.Unstructured) CAPIMachineDeployment {
+	return CAPIMachineDeployment{
+		Name:    obj.GetName(),
+		Phase:   nestedString(obj.Object, "status", "phase"),
+		Version: nestedString(obj.Object, "spec", "template", "spec", "version"),
+
+		Replicas:            nestedInt(obj.Object, "spec", "replicas"),
+		ReadyReplicas:       nestedInt(obj.Object, "status", "readyReplicas"),
+		UpdatedReplicas:     nestedInt(obj.Object, "status", "updatedReplicas"),
+		UnavailableReplicas: nestedInt(obj.Object, "status", "unavailableReplicas"),
+	}
+}
+
+// handleScaleCAPIMachineDeployment sets the replicas of a machine
+// deployment, which adds or removes worker machines of the cluster.
+func (s *Server) handleScaleCAPIMachineDeployment(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	namespace := r.PathValue("namespace")
+
+	var req ScaleRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Replicas < 0 {
+		http.Error(w, "replicas must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.checkProtection(r, name, namespace); err != nil {
+		writeProtectionError(w, r, err)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	api, err := capiAPI(r.Context(), client, name)
+
+	if err != nil {
+		writeCAPIError(w, r, err)
+		return
+	}
+
+	target := api
+	target.Namespace = namespace
+	target.Resource = "machinedeployments"
+	target.Name = r.PathValue("name")
+
+	patch, _ := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"replicas": req.Replicas,
+		},
+	})
+
+	obj := &unstructured.Unstructured{}
+
+	err = client.patch(r.Context(), target.Path(), nil, "application/merge-patch+json", patch, obj)
+
+	entry := &AuditEntry{
+		Context: name,
+		Owner:   ownerID(auth),
+		Action:  "scale",
+
+		Resource:  target.Resource,
+		Namespace: target.Namespace,
+		Name:      target.Name,
+
+		Patch: map[string]any{"replicas": req.Replicas},
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	s.audit.record(entry)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(capiMachineDeployment(obj))
+}
+
+// capiKubeconfig reads the admin kubeconfig Cluster API stores for a
+// workload cluster in the secret <cluster>-kubeconfig.
+func capiKubeconfig(ctx context.Context, client *kubernetesClient, namespace, cluster string) ([]byte, error) {
+	var secret corev1.Secret
+
+	if err := client.get(ctx, "/api/v1/namespaces/"+namespace+"/secrets/"+cluster+"-kubeconfig", nil, &secret); err != nil {
+		if statusCode(err) == http.StatusNotFound {
+			return nil, i18n.NewError("error.capi_kubeconfig_not_found", cluster)
+		}
+
+		return nil, err
+	}
+
+	data, ok := secret.Data["value"]
+
+	if !ok {
+		return nil, i18n.NewError("error.capi_kubeconfig_not_found", cluster)
+	}
+
+	return data, nil
+}
+
+// handleCAPIKubeconfig downloads the kubeconfig of a workload cluster.
+func (s *Server) handleCAPIKubeconfig(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	namespace := r.PathValue("namespace")
+	cluster := r.PathValue("name")
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	data, err := capiKubeconfig(r.Context(), client, namespace, cluster)
+
+	if err != nil {
+		writeCAPIError(w, r, err)
+		return
+	}
+
+	s.audit.record(&AuditEntry{
+		Context: name,
+		Owner:   ownerID(auth),
+		Action:  "kubeconfig",
+
+		Resource:  "clusters",
+		Namespace: namespace,
+		Name:      cluster,
+	})
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+cluster+`.kubeconfig"`)
+	w.Write(data)
+}
+
+// handleConnectCAPICluster registers a workload cluster as context using
+// its kubeconfig. The context is named after the cluster unless the request
+// names it, and can be removed like other runtime contexts.
+func (s *Server) handleConnectCAPICluster(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	namespace := r.PathValue("namespace")
+	cluster := r.PathValue("name")
+
+	req, ok := derivedContextRequest(w, r, cluster)
+
+	if !ok {
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	data, err := capiKubeconfig(r.Context(), client, namespace, cluster)
+
+	if err != nil {
+		writeCAPIError(w, r, err)
+		return
+	}
+
+	c, err := config.KubernetesContextFromKubeconfig(req.Name, data)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	c.Origin = &config.ContextOrigin{Kind: "capi", Context: name, Namespace: namespace, Name: cluster}
+
+	s.addDerivedContext(w, r, c, req)
+}
+
+func nestedBool(obj map[string]any, fields ...string) bool {
+	v, _, _ := unstructured.NestedBool(obj, fields...)
+	return v
+}
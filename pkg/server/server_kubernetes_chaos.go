@@ -0,0 +1,671 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	chaosUncordonAnnotation = "bridge/chaos-uncordon"
+
+	// chaosLatencyImage ships tc to configure netem in the network namespace
+	// of the pods
+	chaosLatencyImage = "nicolaka/netshoot:v0.13"
+
+	chaosDefaultDuration = 5 * time.Minute
+	chaosMaxDuration     = 24 * time.Hour
+)
+
+// chaosExperiments holds the running experiments, which are reverted when
+// they expire, are stopped or their context is removed.
+type chaosExperiments struct {
+	mu    sync.Mutex
+	items map[string]*chaosExperiment
+}
+
+func (c *chaosExperiments) add(item *chaosExperiment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.items == nil {
+		c.items = make(map[string]*chaosExperiment)
+	}
+
+	c.items[item.id] = item
+}
+
+func (c *chaosExperiments) remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.items, id)
+}
+
+func (c *chaosExperiments) get(id string) (*chaosExperiment, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.items[id]
+	return item, ok
+}
+
+func (c *chaosExperiments) list() []*chaosExperiment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make([]*chaosExperiment, 0, len(c.items))
+
+	for _, item := range c.items {
+		result = append(result, item)
+	}
+
+	return result
+}
+
+// chaosExperiment is a failure injected for a limited time.
+type chaosExperiment struct {
+	id    string
+	owner string
+
+	context   string
+	kind      string
+	namespace string
+	target    string
+	pods      []string
+
+	created time.Time
+	expires time.Time
+
+	// revert undoes the failure
+	revert func(ctx context.Context) error
+
+	timer     *time.Timer
+	release   func()
+	closeOnce sync.Once
+	onClose   func()
+}
+
+func (c *chaosExperiment) info() ChaosExperiment {
+	return ChaosExperiment{
+		ID: c.id,
+
+		Context:   c.context,
+		Kind:      c.kind,
+		Namespace: c.namespace,
+		Target:    c.target,
+		Pods:      c.pods,
+
+		Created: c.created,
+		Expires: c.expires,
+	}
+}
+
+// Close reverts the experiment.
+func (c *chaosExperiment) Close() error {
+	var err error
+
+	c.closeOnce.Do(func() {
+		c.timer.Stop()
+
+		if c.onClose != nil {
+			c.onClose()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err = c.revert(ctx); err != nil {
+			log.Printf("failed to revert chaos experiment %s (%s of %s): %v", c.id, c.kind, c.target, err)
+		}
+	})
+
+	return err
+}
+
+// startChaos registers an experiment, which is reverted after its duration.
+func (s *Server) startChaos(item *chaosExperiment, duration time.Duration) {
+	item.expires = item.created.Add(duration)
+
+	item.onClose = func() {
+		s.chaos.remove(item.id)
+	}
+
+	item.timer = time.AfterFunc(duration, func() {
+		item.release()
+	})
+
+	item.release = s.track(item.context, item)
+
+	s.chaos.add(item)
+}
+
+// checkChaos allows chaos experiments in non-production contexts only.
+func (s *Server) checkChaos(w http.ResponseWriter, r *http.Request, name, namespace string) (config.KubernetesContext, bool) {
+	c, ok := s.kubernetesContext(name)
+
+	if !ok {
+		writeError(w, r, errContextNotFound, http.StatusNotFound)
+		return c, false
+	}
+
+	if !s.config.NonProduction(c.Name) {
+		writeError(w, r, i18n.NewError("error.chaos_not_allowed", c.Name), http.StatusForbidden)
+		return c, false
+	}
+
+	if err := s.checkProtection(r, c.Name, namespace); err != nil {
+		writeProtectionError(w, r, err)
+		return c, false
+	}
+
+	return c, true
+}
+
+func readChaosRequest(w http.ResponseWriter, r *http.Request) (*ChaosRequest, time.Duration, bool) {
+	var req ChaosRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, 0, false
+	}
+
+	duration := chaosDefaultDuration
+
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+
+		if err != nil {
+			http.Error(w, "invalid duration: "+err.Error(), http.StatusBadRequest)
+			return nil, 0, false
+		}
+
+		duration = d
+	}
+
+	if duration < time.Second || duration > chaosMaxDuration {
+		writeError(w, r, i18n.NewError("error.chaos_duration", time.Second, chaosMaxDuration), http.StatusBadRequest)
+		return nil, 0, false
+	}
+
+	return &req, duration, true
+}
+
+func (s *Server) recordChaos(item *chaosExperiment, resource string, err error) {
+	entry := &AuditEntry{
+		Context: item.context,
+		Owner:   item.owner,
+		Action:  "chaos-" + item.kind,
+
+		Namespace: item.namespace,
+		Resource:  resource,
+		Name:      item.target,
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	s.audit.record(entry)
+}
+
+func newChaosExperiment(auth *config.AuthInfo, name, kind, namespace, target string) *chaosExperiment {
+	id := make([]byte, 4)
+	rand.Read(id)
+
+	return &chaosExperiment{
+		id:    hex.EncodeToString(id),
+		owner: ownerID(auth),
+
+		context:   name,
+		kind:      kind,
+		namespace: namespace,
+		target:    target,
+
+		created: time.Now(),
+	}
+}
+
+// handleChaosKillPod deletes a random running pod of a deployment, within
+// the limits of the disruption guard.
+func (s *Server) handleChaosKillPod(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	namespace := r.PathValue("namespace")
+	name := r.PathValue("name")
+
+	c, ok := s.checkChaos(w, r, r.PathValue("context"), namespace)
+
+	if !ok {
+		return
+	}
+
+	req, _, ok := readChaosRequest(w, r)
+
+	if !ok {
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), c.Name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	pods, err := deploymentPods(r.Context(), client, namespace, name)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	if len(pods) == 0 {
+		writeError(w, r, i18n.NewError("error.chaos_no_pods", name), http.StatusConflict)
+		return
+	}
+
+	if limit := s.config.Limits.MaxDisruptionsPerMinute; limit > 0 {
+		if ok, wait := s.disruptions.allow(strings.ToLower(c.Name)+"/"+namespace, limit); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+
+			writeError(w, r, i18n.NewError("error.disruption_rate", namespace, limit), http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	pod := pods[mathrand.IntN(len(pods))]
+
+	item := newChaosExperiment(auth, c.Name, "kill-pod", namespace, name)
+	item.pods = []string{pod.Name}
+
+	query := url.Values{}
+
+	if req.GracePeriodSeconds != nil {
+		query.Set("gracePeriodSeconds", strconv.FormatInt(*req.GracePeriodSeconds, 10))
+	}
+
+	err = client.delete(r.Context(), "/api/v1/namespaces/"+namespace+"/pods/"+pod.Name, query)
+
+	s.recordChaos(item, "deployments", err)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	// killing is instant, there is nothing to revert
+	item.expires = item.created
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item.info())
+}
+
+// handleChaosLatency delays the network of the running pods of a deployment
+// with netem, configured by an ephemeral container in their network
+// namespace. The container reverts the delay when the duration is over,
+// also if the bridge stops meanwhile.
+func (s *Server) handleChaosLatency(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	namespace := r.PathValue("namespace")
+	name := r.PathValue("name")
+
+	c, ok := s.checkChaos(w, r, r.PathValue("context"), namespace)
+
+	if !ok {
+		return
+	}
+
+	req, duration, ok := readChaosRequest(w, r)
+
+	if !ok {
+		return
+	}
+
+	latency, err := time.ParseDuration(req.Latency)
+
+	if err != nil || latency <= 0 {
+		http.Error(w, "latency must be a positive duration (e.g. 200ms)", http.StatusBadRequest)
+		return
+	}
+
+	var jitter time.Duration
+
+	if req.Jitter != "" {
+		if jitter, err = time.ParseDuration(req.Jitter); err != nil || jitter < 0 {
+			http.Error(w, "invalid jitter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	client, err := s.kubernetesClient(r.Context(), c.Name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	pods, err := deploymentPods(r.Context(), client, namespace, name)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	if len(pods) == 0 {
+		writeError(w, r, i18n.NewError("error.chaos_no_pods", name), http.StatusConflict)
+		return
+	}
+
+	item := newChaosExperiment(auth, c.Name, "latency", namespace, name)
+
+	container := "bridge-chaos-" + item.id
+
+	delay := fmt.Sprintf("%dms", latency.Milliseconds())
+
+	if jitter > 0 {
+		delay += fmt.Sprintf(" %dms", jitter.Milliseconds())
+	}
+
+	script := fmt.Sprintf("tc qdisc add dev eth0 root netem delay %s && sleep %d; tc qdisc del dev eth0 root 2>/dev/null; true", delay, int(duration.Seconds()))
+
+	var failed []string
+
+	for _, pod := range pods {
+		if err := addChaosContainer(r.Context(), client, namespace, pod.Name, container, script); err != nil {
+			failed = append(failed, pod.Name+": "+err.Error())
+			continue
+		}
+
+		item.pods = append(item.pods, pod.Name)
+	}
+
+	if len(item.pods) == 0 {
+		err = errors.New(strings.Join(failed, "; "))
+	}
+
+	s.recordChaos(item, "deployments", err)
+
+	if err != nil {
+		writeError(w, r, err, http.StatusBadGateway)
+		return
+	}
+
+	for _, f := range failed {
+		log.Printf("failed to add latency to pod of deployment %s/%s: %s", namespace, name, f)
+	}
+
+	item.revert = func(ctx context.Context) error {
+		// the containers revert the delay themselves when they expire
+		if !time.Now().Before(item.expires) {
+			return nil
+		}
+
+		var errs []error
+
+		for _, pod := range item.pods {
+			if err := s.execChaosContainer(ctx, c.Name, auth, namespace, pod, container, []string{"tc", "qdisc", "del", "dev", "eth0", "root"}); err != nil && statusCode(err) != http.StatusNotFound {
+				errs = append(errs, fmt.Errorf("%s: %w", pod, err))
+			}
+		}
+
+		return errors.Join(errs...)
+	}
+
+	s.startChaos(item, duration)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	json.NewEncoder(w).Encode(item.info())
+}
+
+// addChaosContainer adds an ephemeral container allowed to configure the
+// network of a pod.
+func addChaosContainer(ctx context.Context, client *kubernetesClient, namespace, pod, name, script string) error {
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"ephemeralContainers": []corev1.EphemeralContainer{
+				{
+					EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+						Name:  name,
+						Image: chaosLatencyImage,
+
+						Command: []string{"sh", "-c", script},
+
+						SecurityContext: &corev1.SecurityContext{
+							Capabilities: &corev1.Capabilities{
+								Add: []corev1.Capability{"NET_ADMIN"},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return client.patch(ctx, "/api/v1/namespaces/"+namespace+"/pods/"+pod+"/ephemeralcontainers", nil, "application/strategic-merge-patch+json", patch, nil)
+}
+
+// execChaosContainer runs a command in the ephemeral container of an
+// experiment, once it started.
+func (s *Server) execChaosContainer(ctx context.Context, name string, auth *config.AuthInfo, namespace, pod, container string, command []string) error {
+	executor, err := s.podExecutor(ctx, name, auth, namespace, pod, container, command, false, false)
+
+	if err != nil {
+		return err
+	}
+
+	var output bytes.Buffer
+
+	if err := executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdout: &output, Stderr: &output}); err != nil {
+		if msg := strings.TrimSpace(output.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// handleChaosCordon cordons a node for a duration, after which it is
+// uncordoned again. The node is annotated with the time of the uncordon, so
+// operators see why it is cordoned.
+func (s *Server) handleChaosCordon(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	node := r.PathValue("node")
+
+	c, ok := s.checkChaos(w, r, r.PathValue("context"), "")
+
+	if !ok {
+		return
+	}
+
+	_, duration, ok := readChaosRequest(w, r)
+
+	if !ok {
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), c.Name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	var n corev1.Node
+
+	if err := client.get(r.Context(), "/api/v1/nodes/"+node, nil, &n); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	// cordons of others are not taken over, they would be uncordoned
+	if n.Spec.Unschedulable {
+		writeError(w, r, i18n.NewError("error.chaos_node_cordoned", node), http.StatusConflict)
+		return
+	}
+
+	item := newChaosExperiment(auth, c.Name, "cordon", "", n.Name)
+
+	expires := item.created.Add(duration)
+
+	patch, _ := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]any{
+				chaosUncordonAnnotation: expires.UTC().Format(time.RFC3339),
+			},
+			"resourceVersion": n.ResourceVersion,
+		},
+		"spec": map[string]any{
+			"unschedulable": true,
+		},
+	})
+
+	err = client.patch(r.Context(), "/api/v1/nodes/"+n.Name, nil, "application/merge-patch+json", patch, nil)
+
+	s.recordChaos(item, "nodes", err)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	item.revert = func(ctx context.Context) error {
+		return uncordonChaosNode(ctx, client, n.Name)
+	}
+
+	s.startChaos(item, duration)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	json.NewEncoder(w).Encode(item.info())
+}
+
+// uncordonChaosNode uncordons a node cordoned by a chaos experiment.
+func uncordonChaosNode(ctx context.Context, client *kubernetesClient, name string) error {
+	var current corev1.Node
+
+	if err := client.get(ctx, "/api/v1/nodes/"+name, nil, &current); err != nil {
+		return err
+	}
+
+	// uncordoned or cordoned again by others meanwhile
+	if _, ok := current.Annotations[chaosUncordonAnnotation]; !ok || !current.Spec.Unschedulable {
+		return nil
+	}
+
+	patch, _ := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]any{
+				chaosUncordonAnnotation: nil,
+			},
+			"resourceVersion": current.ResourceVersion,
+		},
+		"spec": map[string]any{
+			"unschedulable": nil,
+		},
+	})
+
+	return client.patch(ctx, "/api/v1/nodes/"+name, nil, "application/merge-patch+json", patch, nil)
+}
+
+// deploymentPods returns the running pods of a deployment.
+func deploymentPods(ctx context.Context, client *kubernetesClient, namespace, name string) ([]corev1.Pod, error) {
+	var deployment appsv1.Deployment
+
+	if err := client.get(ctx, "/apis/apps/v1/namespaces/"+namespace+"/deployments/"+name, nil, &deployment); err != nil {
+		return nil, err
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var list corev1.PodList
+
+	if err := client.get(ctx, "/api/v1/namespaces/"+namespace+"/pods", url.Values{"labelSelector": {selector.String()}}, &list); err != nil {
+		return nil, err
+	}
+
+	var result []corev1.Pod
+
+	for _, pod := range list.Items {
+		if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		result = append(result, pod)
+	}
+
+	return result, nil
+}
+
+func (s *Server) handleListChaos(w http.ResponseWriter, r *http.Request) {
+	owner := ownerID(AuthInfoFromContext(r.Context()))
+
+	result := []ChaosExperiment{}
+
+	for _, item := range s.chaos.list() {
+		if item.owner != owner {
+			continue
+		}
+
+		result = append(result, item.info())
+	}
+
+	slices.SortFunc(result, func(a, b ChaosExperiment) int {
+		return a.Created.Compare(b.Created)
+	})
+
+	writeList(w, r, "chaos", result, result)
+}
+
+// handleStopChaos reverts an experiment before it expires.
+func (s *Server) handleStopChaos(w http.ResponseWriter, r *http.Request) {
+	owner := ownerID(AuthInfoFromContext(r.Context()))
+
+	item, ok := s.chaos.get(r.PathValue("id"))
+
+	if !ok || item.owner != owner {
+		http.Error(w, "chaos experiment not found", http.StatusNotFound)
+		return
+	}
+
+	item.release()
+
+	w.WriteHeader(http.StatusNoContent)
+}
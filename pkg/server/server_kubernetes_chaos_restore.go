@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// chaosRestoreInterval is the interval in which contexts whose cordons
+// could not be restored yet (e.g. while offline) are retried
+const chaosRestoreInterval = 30 * time.Second
+
+// restoreChaosCordons takes over the nodes cordoned by chaos experiments of
+// an earlier run, whose uncordon timers were lost: after a start and when
+// contexts are loaded from the kubeconfig again.
+func (s *Server) restoreChaosCordons(done <-chan struct{}) {
+	events, unsubscribe := s.contextEvents.subscribe()
+	defer unsubscribe()
+
+	ticker := time.NewTicker(chaosRestoreInterval)
+	defer ticker.Stop()
+
+	pending := make(map[string]bool)
+
+	for _, name := range s.kubernetesContextNames() {
+		pending[name] = true
+	}
+
+	for {
+		for name := range pending {
+			c, ok := s.kubernetesContext(name)
+
+			// chaos experiments are limited to non-production contexts
+			if !ok || !s.config.NonProduction(c.Name) {
+				delete(pending, name)
+				continue
+			}
+
+			if s.connectivity.offline(c.Name) {
+				continue
+			}
+
+			if err := s.restoreChaosCordon(c.Name); err != nil {
+				log.Printf("failed to restore chaos cordons of context %q: %v", c.Name, err)
+				continue
+			}
+
+			delete(pending, name)
+		}
+
+		select {
+		case <-done:
+			return
+
+		case event := <-events:
+			if event.Type != "kubernetes" {
+				continue
+			}
+
+			for _, name := range event.Added {
+				pending[name] = true
+			}
+
+		case <-ticker.C:
+		}
+	}
+}
+
+// restoreChaosCordon uncordons the nodes of a context whose chaos cordon
+// expired, and schedules the uncordon of the others.
+func (s *Server) restoreChaosCordon(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := s.kubernetesClient(ctx, name, nil)
+
+	if err != nil {
+		return err
+	}
+
+	var nodes corev1.NodeList
+
+	if err := client.get(ctx, "/api/v1/nodes", nil, &nodes); err != nil {
+		return err
+	}
+
+	for _, n := range nodes.Items {
+		value, ok := n.Annotations[chaosUncordonAnnotation]
+
+		if !ok || !n.Spec.Unschedulable || s.chaosRunning(name, "cordon", n.Name) {
+			continue
+		}
+
+		expires, err := time.Parse(time.RFC3339, value)
+
+		if err != nil {
+			log.Printf("invalid %s annotation of node %s: %v", chaosUncordonAnnotation, n.Name, err)
+			continue
+		}
+
+		if remaining := time.Until(expires); remaining > 0 {
+			item := newChaosExperiment(nil, name, "cordon", "", n.Name)
+
+			item.revert = func(ctx context.Context) error {
+				return uncordonChaosNode(ctx, client, n.Name)
+			}
+
+			s.startChaos(item, remaining)
+
+			log.Printf("restored chaos cordon of node %s, uncordoned at %s", n.Name, expires.Format(time.RFC3339))
+
+			continue
+		}
+
+		if err := uncordonChaosNode(ctx, client, n.Name); err != nil {
+			return err
+		}
+
+		log.Printf("uncordoned node %s, its chaos cordon expired at %s", n.Name, expires.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// chaosRunning reports whether an experiment on a target is running.
+func (s *Server) chaosRunning(context, kind, target string) bool {
+	for _, item := range s.chaos.list() {
+		if strings.EqualFold(item.context, context) && item.kind == kind && item.target == target {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+)
+
+const metadataAccept = "application/json;as=PartialObjectMetadataList;v=v1;g=meta.k8s.io,application/json"
+
+var errContextNotFound = i18n.NewError("error.context_not_found")
+
+// kubernetesClient issues JSON requests against the API server of a context,
+// sharing the pooled transport of the proxy.
+type kubernetesClient struct {
+	transport http.RoundTripper
+	target    *url.URL
+}
+
+func (s *Server) kubernetesClient(ctx context.Context, name string, auth *config.AuthInfo) (*kubernetesClient, error) {
+	c, ok := s.kubernetesContext(name)
+
+	if !ok {
+		return nil, errContextNotFound
+	}
+
+	tr, target, err := s.kubernetesTransport(ctx, c, auth)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &kubernetesClient{
+		transport: tr,
+		target:    target,
+	}, nil
+}
+
+func (c *kubernetesClient) get(ctx context.Context, path string, query url.Values, out any) error {
+	return c.do(ctx, http.MethodGet, path, query, "", nil, out)
+}
+
+// getMetadata lists objects as PartialObjectMetadataList, which avoids
+// transferring full objects when only their metadata is needed.
+func (c *kubernetesClient) getMetadata(ctx context.Context, path string, query url.Values, out *metav1.PartialObjectMetadataList) error {
+	return c.send(ctx, http.MethodGet, path, query, metadataAccept, "", nil, out)
+}
+
+func (c *kubernetesClient) create(ctx context.Context, path string, in, out any) error {
+	data, err := json.Marshal(in)
+
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, http.MethodPost, path, nil, "application/json", data, out)
+}
+
+func (c *kubernetesClient) update(ctx context.Context, path string, query url.Values, in, out any) error {
+	data, err := json.Marshal(in)
+
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, http.MethodPut, path, query, "application/json", data, out)
+}
+
+func (c *kubernetesClient) patch(ctx context.Context, path string, query url.Values, patchType string, data []byte, out any) error {
+	return c.do(ctx, http.MethodPatch, path, query, patchType, data, out)
+}
+
+func (c *kubernetesClient) delete(ctx context.Context, path string, query url.Values) error {
+	return c.do(ctx, http.MethodDelete, path, query, "", nil, nil)
+}
+
+// do sends a request and decodes a JSON response into out (if not nil).
+// A *[]byte receives the raw response body.
+// Non-2xx responses are returned as *upstreamError.
+func (c *kubernetesClient) do(ctx context.Context, method, path string, query url.Values, contentType string, data []byte, out any) error {
+	return c.send(ctx, method, path, query, "application/json", contentType, data, out)
+}
+
+func (c *kubernetesClient) send(ctx context.Context, method, path string, query url.Values, accept, contentType string, data []byte, out any) error {
+	u := *c.target
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+
+	var body io.Reader
+
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", accept)
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.transport.RoundTrip(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+		return &upstreamError{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header,
+			Body:       body,
+		}
+	}
+
+	switch out := out.(type) {
+	case nil:
+		io.Copy(io.Discard, resp.Body)
+		return nil
+
+	case *[]byte:
+		*out, err = io.ReadAll(resp.Body)
+		return err
+
+	case *unstructured.Unstructured:
+		data, err := io.ReadAll(resp.Body)
+
+		if err != nil {
+			return err
+		}
+
+		// decoded without the kind check of unstructured (some aggregated
+		// APIs omit it), but with int64 numbers as unstructured expects
+		return utiljson.Unmarshal(data, &out.Object)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// statusCode returns the upstream status code of err, or 0.
+func statusCode(err error) int {
+	var e *upstreamError
+
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+
+	return 0
+}
+
+// writeClientError maps errors of kubernetesClient requests to a response.
+func writeClientError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errContextNotFound) {
+		writeError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	if code := statusCode(err); code != 0 {
+		writeError(w, r, err, code)
+		return
+	}
+
+	writeError(w, r, err, http.StatusBadGateway)
+}
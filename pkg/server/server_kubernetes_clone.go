@@ -0,0 +1,355 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/adrianliechti/bridge/pkg/i18n"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// cloneLabel selects the pods of a copy instead of the labels of its
+// original, so neither the original nor its services select them
+const cloneLabel = "bridge/clone"
+
+// cloneAnnotations are set by controllers and clients on live objects.
+var cloneAnnotations = []string{
+	"kubectl.kubernetes.io/last-applied-configuration",
+	"deployment.kubernetes.io/revision",
+}
+
+// cloneTemplateLabels are set by controllers on the pods they create.
+var cloneTemplateLabels = []string{
+	"pod-template-hash",
+	"controller-revision-hash",
+	"controller-uid",
+	"job-name",
+	"batch.kubernetes.io/controller-uid",
+	"batch.kubernetes.io/job-name",
+}
+
+// handleCloneObject turns a live workload into a copy ready to apply: server
+// populated fields are stripped, it is renamed and moved, and its pods are
+// selected by a label of their own. The env vars, images and replicas of the
+// copy can be changed, e.g. to run a debug copy of a service. The copy is
+// returned unless the request asks to create it.
+func (s *Server) handleCloneObject(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	source := objectResource(r)
+
+	var req CloneRequest
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.Name == "" {
+		req.Name = source.Name + "-copy"
+	}
+
+	if req.Namespace == "" {
+		req.Namespace = source.Namespace
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	obj := &unstructured.Unstructured{}
+
+	if err := client.get(r.Context(), source.Path(), nil, obj); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	spec, ok := podTemplatePaths[obj.GetKind()]
+
+	if !ok {
+		writeError(w, r, i18n.NewError("error.clone_unsupported", obj.GetKind()), http.StatusBadRequest)
+		return
+	}
+
+	cleanClone(obj, spec, req.Name)
+
+	if source.Namespace != "" {
+		obj.SetNamespace(req.Namespace)
+	}
+
+	if err := customizeClone(obj, spec, &req); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if !req.Create {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(obj.Object)
+		return
+	}
+
+	if err := s.checkProtection(r, name, obj.GetNamespace()); err != nil {
+		writeProtectionError(w, r, err)
+		return
+	}
+
+	target := &kubernetesRequest{
+		Group:     source.Group,
+		Version:   source.Version,
+		Namespace: obj.GetNamespace(),
+		Resource:  source.Resource,
+	}
+
+	var created unstructured.Unstructured
+
+	err = client.create(r.Context(), target.Path(), obj.Object, &created)
+
+	entry := &AuditEntry{
+		Context: name,
+		Owner:   ownerID(auth),
+		Action:  "clone",
+
+		Resource:  source.Resource,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	s.audit.record(entry)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	json.NewEncoder(w).Encode(created.Object)
+}
+
+// cleanClone strips the fields of a live workload that the cluster, its
+// controllers or clients populated, and renames it.
+func cleanClone(obj *unstructured.Unstructured, spec []string, name string) {
+	cleanManifest(obj)
+
+	obj.SetName(name)
+	obj.SetGenerateName("")
+
+	annotations := obj.GetAnnotations()
+
+	for _, key := range cloneAnnotations {
+		delete(annotations, key)
+	}
+
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+
+	obj.SetAnnotations(annotations)
+
+	template := spec[:len(spec)-1]
+
+	labels, _, _ := unstructured.NestedStringMap(obj.Object, append(slices.Clone(template), "metadata", "labels")...)
+
+	for _, key := range cloneTemplateLabels {
+		delete(labels, key)
+	}
+
+	if len(template) > 0 {
+		path := append(slices.Clone(template), "metadata", "annotations")
+
+		unstructured.RemoveNestedField(obj.Object, append(path, "kubectl.kubernetes.io/restartedAt")...)
+
+		if annotations, _, _ := unstructured.NestedMap(obj.Object, path...); len(annotations) == 0 {
+			unstructured.RemoveNestedField(obj.Object, path...)
+		}
+	}
+
+	switch obj.GetKind() {
+	case "Pod":
+		// assigned by the scheduler and admission
+		unstructured.RemoveNestedField(obj.Object, "spec", "nodeName")
+		unstructured.RemoveNestedField(obj.Object, "spec", "ephemeralContainers")
+
+		removeServiceAccountVolumes(obj)
+
+	case "Job", "CronJob":
+		// the job controller generates the selectors of the copy
+		unstructured.RemoveNestedField(obj.Object, "spec", "selector")
+		unstructured.RemoveNestedField(obj.Object, "spec", "manualSelector")
+
+	default:
+		selector, _, _ := unstructured.NestedStringMap(obj.Object, "spec", "selector", "matchLabels")
+
+		for key := range selector {
+			delete(labels, key)
+		}
+
+		if labels == nil {
+			labels = map[string]string{}
+		}
+
+		labels[cloneLabel] = name
+
+		unstructured.SetNestedStringMap(obj.Object, map[string]string{cloneLabel: name}, "spec", "selector", "matchLabels")
+		unstructured.RemoveNestedField(obj.Object, "spec", "selector", "matchExpressions")
+	}
+
+	if labels != nil {
+		unstructured.SetNestedStringMap(obj.Object, labels, append(slices.Clone(template), "metadata", "labels")...)
+	}
+
+	if obj.GetKind() == "StatefulSet" {
+		claims, _, _ := unstructured.NestedSlice(obj.Object, "spec", "volumeClaimTemplates")
+
+		for _, c := range claims {
+			if claim, ok := c.(map[string]any); ok {
+				delete(claim, "status")
+			}
+		}
+
+		if claims != nil {
+			unstructured.SetNestedSlice(obj.Object, claims, "spec", "volumeClaimTemplates")
+		}
+	}
+}
+
+// removeServiceAccountVolumes removes the token volumes admission injects
+// into pods, which it injects into the copy again.
+func removeServiceAccountVolumes(obj *unstructured.Unstructured) {
+	volumes, _, _ := unstructured.NestedSlice(obj.Object, "spec", "volumes")
+
+	var removed []string
+
+	volumes = slices.DeleteFunc(volumes, func(v any) bool {
+		volume, _ := v.(map[string]any)
+		name, _ := volume["name"].(string)
+
+		if _, ok := volume["projected"]; ok && strings.HasPrefix(name, "kube-api-access-") {
+			removed = append(removed, name)
+			return true
+		}
+
+		return false
+	})
+
+	if len(removed) == 0 {
+		return
+	}
+
+	unstructured.SetNestedSlice(obj.Object, volumes, "spec", "volumes")
+
+	for _, field := range []string{"containers", "initContainers"} {
+		containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", field)
+
+		for _, c := range containers {
+			container, _ := c.(map[string]any)
+			mounts, _ := container["volumeMounts"].([]any)
+
+			container["volumeMounts"] = slices.DeleteFunc(mounts, func(m any) bool {
+				mount, _ := m.(map[string]any)
+				name, _ := mount["name"].(string)
+
+				return slices.Contains(removed, name)
+			})
+		}
+
+		if containers != nil {
+			unstructured.SetNestedSlice(obj.Object, containers, "spec", field)
+		}
+	}
+}
+
+// customizeClone applies the changes of a clone request to the copy.
+func customizeClone(obj *unstructured.Unstructured, spec []string, req *CloneRequest) error {
+	if req.Replicas != nil {
+		switch obj.GetKind() {
+		case "Deployment", "StatefulSet", "ReplicaSet":
+			unstructured.SetNestedField(obj.Object, *req.Replicas, "spec", "replicas")
+
+		default:
+			return i18n.NewError("error.clone_replicas", obj.GetKind())
+		}
+	}
+
+	path := append(slices.Clone(spec), "containers")
+
+	containers, _, _ := unstructured.NestedSlice(obj.Object, path...)
+
+	var names []string
+
+	for _, c := range containers {
+		container, _ := c.(map[string]any)
+		name, _ := container["name"].(string)
+
+		names = append(names, name)
+
+		if image, ok := req.Images[name]; ok {
+			container["image"] = image
+		}
+
+		if req.Container != "" && req.Container != name {
+			continue
+		}
+
+		env, _ := container["env"].([]any)
+
+		for key, value := range req.Env {
+			index := slices.IndexFunc(env, func(e any) bool {
+				v, _ := e.(map[string]any)
+				return v["name"] == key
+			})
+
+			if value == nil {
+				if index >= 0 {
+					env = slices.Delete(env, index, index+1)
+				}
+
+				continue
+			}
+
+			// replaces values read from config maps, secrets or fields
+			v := map[string]any{
+				"name":  key,
+				"value": *value,
+			}
+
+			if index >= 0 {
+				env[index] = v
+			} else {
+				env = append(env, v)
+			}
+		}
+
+		if len(env) > 0 {
+			container["env"] = env
+		} else {
+			delete(container, "env")
+		}
+	}
+
+	if req.Container != "" && !slices.Contains(names, req.Container) {
+		return i18n.NewError("error.clone_container", req.Container)
+	}
+
+	for name := range req.Images {
+		if !slices.Contains(names, name) {
+			return i18n.NewError("error.clone_container", name)
+		}
+	}
+
+	return unstructured.SetNestedSlice(obj.Object, containers, path...)
+}
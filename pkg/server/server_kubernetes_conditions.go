@@ -0,0 +1,353 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+)
+
+// retriggerAnnotation is bumped to make controllers reconcile an object again.
+const retriggerAnnotation = "bridge/retrigger"
+
+const maxConditionWait = 30 * time.Minute
+
+// objectResource returns the object addressed by
+// /contexts/{context}/objects/{group}/{version}/{resource}/{name}?namespace=,
+// where the core group is written as "core".
+func objectResource(r *http.Request) *kubernetesRequest {
+	group := r.PathValue("group")
+
+	if group == "core" {
+		group = ""
+	}
+
+	return &kubernetesRequest{
+		Group:     group,
+		Version:   r.PathValue("version"),
+		Namespace: r.URL.Query().Get("namespace"),
+		Resource:  r.PathValue("resource"),
+		Name:      r.PathValue("name"),
+	}
+}
+
+// handleConditions returns the status conditions and events of an object as
+// a timeline.
+func (s *Server) handleConditions(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	target := objectResource(r)
+
+	client, err := s.kubernetesClient(r.Context(), r.PathValue("context"), auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	obj := &unstructured.Unstructured{}
+
+	if err := client.get(r.Context(), target.Path(), nil, obj); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := objectConditions(obj)
+	result.Timeline = []TimelineEntry{}
+
+	for _, c := range result.Conditions {
+		result.Timeline = append(result.Timeline, TimelineEntry{
+			Time:   c.LastTransitionTime,
+			Source: "condition",
+
+			Type:    c.Type,
+			Status:  c.Status,
+			Reason:  c.Reason,
+			Message: c.Message,
+		})
+	}
+
+	if obj.GetNamespace() != "" {
+		query := url.Values{
+			"fieldSelector": {"involvedObject.uid=" + string(obj.GetUID())},
+		}
+
+		var events corev1.EventList
+
+		if err := client.get(r.Context(), "/api/v1/namespaces/"+obj.GetNamespace()+"/events", query, &events); err == nil {
+			for _, e := range events.Items {
+				t := e.LastTimestamp.Time
+
+				if t.IsZero() {
+					t = e.EventTime.Time
+				}
+
+				result.Timeline = append(result.Timeline, TimelineEntry{
+					Time:   t,
+					Source: "event",
+
+					Type:    e.Type,
+					Reason:  e.Reason,
+					Message: e.Message,
+				})
+			}
+		}
+	}
+
+	slices.SortStableFunc(result.Timeline, func(a, b TimelineEntry) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func objectConditions(obj *unstructured.Unstructured) *ObjectConditions {
+	result := &ObjectConditions{
+		Generation: obj.GetGeneration(),
+
+		Conditions: []ObjectCondition{},
+	}
+
+	result.ObservedGeneration, _, _ = unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+
+	items, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+
+	for _, item := range items {
+		data, err := json.Marshal(item)
+
+		if err != nil {
+			continue
+		}
+
+		var c ObjectCondition
+
+		if err := json.Unmarshal(data, &c); err != nil || c.Type == "" {
+			continue
+		}
+
+		result.Conditions = append(result.Conditions, c)
+	}
+
+	return result
+}
+
+func conditionMet(conditions []ObjectCondition, conditionType, status string) bool {
+	for _, c := range conditions {
+		if strings.EqualFold(c.Type, conditionType) {
+			return strings.EqualFold(c.Status, status)
+		}
+	}
+
+	return false
+}
+
+// handleWaitCondition blocks until an object reports a condition, e.g.
+// ?condition=Ready&status=True&timeout=5m. With Accept: text/event-stream,
+// every change of the conditions is streamed as server-sent event.
+func (s *Server) handleWaitCondition(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	target := objectResource(r)
+
+	query := r.URL.Query()
+
+	conditionType := query.Get("condition")
+	status := query.Get("status")
+
+	if conditionType == "" {
+		http.Error(w, "condition is required", http.StatusBadRequest)
+		return
+	}
+
+	if status == "" {
+		status = "True"
+	}
+
+	timeout := 5 * time.Minute
+
+	if v := query.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid timeout", http.StatusBadRequest)
+			return
+		}
+
+		timeout = min(d, maxConditionWait)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	client, err := s.kubernetesClient(ctx, r.PathValue("context"), auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	obj := &unstructured.Unstructured{}
+
+	if err := client.get(ctx, target.Path(), nil, obj); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	stream := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	rc := http.NewResponseController(w)
+
+	if stream {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+	}
+
+	current := objectConditions(obj)
+	met := conditionMet(current.Conditions, conditionType, status)
+
+	send := func(event string, v any) {
+		if !stream {
+			return
+		}
+
+		data, _ := json.Marshal(v)
+
+		w.Write([]byte("event: " + event + "\ndata: "))
+		w.Write(data)
+		w.Write([]byte("\n\n"))
+
+		rc.Flush()
+	}
+
+	send("conditions", current)
+
+	if !met {
+		collection := *target
+		collection.Name = ""
+
+		u := *client.target
+		u.Path = strings.TrimSuffix(u.Path, "/") + collection.Path()
+		u.RawQuery = url.Values{
+			"fieldSelector":   {"metadata.name=" + target.Name},
+			"resourceVersion": {obj.GetResourceVersion()},
+		}.Encode()
+
+		watchCtx, stop := context.WithCancel(ctx)
+		defer stop()
+
+		watch := &resumableWatch{
+			Transport: client.transport,
+
+			URL:    &u,
+			Header: http.Header{},
+
+			OnEvent: func(e watchEvent) error {
+				if e.Type != "ADDED" && e.Type != "MODIFIED" {
+					return nil
+				}
+
+				obj := &unstructured.Unstructured{}
+
+				if err := utiljson.Unmarshal(e.Object, &obj.Object); err != nil {
+					return nil
+				}
+
+				current = objectConditions(obj)
+				send("conditions", current)
+
+				if conditionMet(current.Conditions, conditionType, status) {
+					met = true
+					stop()
+				}
+
+				return nil
+			},
+		}
+
+		watch.Run(watchCtx)
+	}
+
+	result := &ConditionWaitResult{
+		Met: met,
+
+		Condition: conditionType,
+		Status:    status,
+
+		Conditions: current.Conditions,
+	}
+
+	if stream {
+		send("done", result)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if !met {
+		w.WriteHeader(http.StatusRequestTimeout)
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleRetrigger bumps an annotation on an object, which makes most
+// controllers reconcile it again.
+func (s *Server) handleRetrigger(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	target := objectResource(r)
+
+	if err := s.checkProtection(r, name, target.Namespace); err != nil {
+		writeProtectionError(w, r, err)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{
+				retriggerAnnotation: time.Now().Format(time.RFC3339Nano),
+			},
+		},
+	})
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	obj := &unstructured.Unstructured{}
+
+	if err := client.patch(r.Context(), target.Path(), nil, "application/merge-patch+json", patch, obj); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	s.audit.record(&AuditEntry{
+		Context: name,
+		Owner:   ownerID(auth),
+		Action:  "retrigger",
+
+		Resource:  target.Resource,
+		Namespace: target.Namespace,
+		Name:      target.Name,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(obj.Object)
+}
@@ -0,0 +1,497 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// configResource returns the path of a ConfigMap or Secret.
+func configResource(r *http.Request) (*kubernetesRequest, bool) {
+	resource := r.PathValue("resource")
+
+	if resource != "configmaps" && resource != "secrets" {
+		return nil, false
+	}
+
+	return &kubernetesRequest{
+		Version:   "v1",
+		Namespace: r.PathValue("namespace"),
+		Resource:  resource,
+		Name:      r.PathValue("name"),
+	}, true
+}
+
+// handleGetConfig returns the data of a ConfigMap or Secret together with
+// the workloads consuming it.
+func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	target, ok := configResource(r)
+
+	if !ok {
+		http.Error(w, "resource must be configmaps or secrets", http.StatusBadRequest)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), r.PathValue("context"), auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	data, binary, resourceVersion, err := getConfigData(r.Context(), client, target)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	consumers, err := configConsumers(r.Context(), client, target)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	json.NewEncoder(w).Encode(&ConfigData{
+		ResourceVersion: resourceVersion,
+
+		Data:   data,
+		Binary: binary,
+
+		Consumers: consumers,
+	})
+}
+
+// handleUpdateConfig validates and saves the data of a ConfigMap or Secret
+// and optionally restarts the workloads consuming it.
+func (s *Server) handleUpdateConfig(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+
+	target, ok := configResource(r)
+
+	if !ok {
+		http.Error(w, "resource must be configmaps or secrets", http.StatusBadRequest)
+		return
+	}
+
+	var req ConfigUpdateRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Data == nil {
+		http.Error(w, "data is required", http.StatusBadRequest)
+		return
+	}
+
+	if problems := validateConfigData(req.Data); len(problems) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+
+		json.NewEncoder(w).Encode(&ConfigUpdateResult{
+			Problems: problems,
+		})
+
+		return
+	}
+
+	if !req.DryRun {
+		if err := s.checkProtection(r, name, target.Namespace); err != nil {
+			writeProtectionError(w, r, err)
+			return
+		}
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	query := url.Values{}
+
+	if req.DryRun {
+		query.Set("dryRun", "All")
+	}
+
+	var resourceVersion string
+
+	switch target.Resource {
+	case "configmaps":
+		var cm corev1.ConfigMap
+
+		if err := client.get(r.Context(), target.Path(), nil, &cm); err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+
+		if req.ResourceVersion != "" {
+			cm.ResourceVersion = req.ResourceVersion
+		}
+
+		cm.Data = req.Data
+
+		for _, key := range req.Remove {
+			delete(cm.BinaryData, key)
+		}
+
+		if err := client.update(r.Context(), target.Path(), query, &cm, &cm); err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+
+		resourceVersion = cm.ResourceVersion
+
+	case "secrets":
+		var secret corev1.Secret
+
+		if err := client.get(r.Context(), target.Path(), nil, &secret); err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+
+		if req.ResourceVersion != "" {
+			secret.ResourceVersion = req.ResourceVersion
+		}
+
+		data := map[string][]byte{}
+
+		// binary values are not editable and kept unless removed
+		for key, value := range secret.Data {
+			if !utf8.Valid(value) && !slices.Contains(req.Remove, key) {
+				data[key] = value
+			}
+		}
+
+		for key, value := range req.Data {
+			data[key] = []byte(value)
+		}
+
+		secret.Data = data
+
+		if err := client.update(r.Context(), target.Path(), query, &secret, &secret); err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+
+		resourceVersion = secret.ResourceVersion
+	}
+
+	result := &ConfigUpdateResult{
+		ResourceVersion: resourceVersion,
+
+		Restarted: []string{},
+	}
+
+	if !req.DryRun {
+		s.audit.record(&AuditEntry{
+			Context: name,
+			Owner:   ownerID(auth),
+			Action:  "update",
+
+			Resource:  target.Resource,
+			Namespace: target.Namespace,
+			Name:      target.Name,
+		})
+	}
+
+	if req.Restart && !req.DryRun {
+		consumers, err := configConsumers(r.Context(), client, target)
+
+		if err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+
+		for _, c := range consumers {
+			if err := s.restartWorkload(r.Context(), client, name, target.Namespace, c); err != nil {
+				result.Problems = append(result.Problems, fmt.Sprintf("restart %s %s: %v", c.Kind, c.Name, err))
+				continue
+			}
+
+			result.Restarted = append(result.Restarted, c.Kind+"/"+c.Name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func getConfigData(ctx context.Context, client *kubernetesClient, target *kubernetesRequest) (map[string]string, []string, string, error) {
+	data := map[string]string{}
+	binary := []string{}
+
+	switch target.Resource {
+	case "configmaps":
+		var cm corev1.ConfigMap
+
+		if err := client.get(ctx, target.Path(), nil, &cm); err != nil {
+			return nil, nil, "", err
+		}
+
+		for key, value := range cm.Data {
+			data[key] = value
+		}
+
+		for key := range cm.BinaryData {
+			binary = append(binary, key)
+		}
+
+		return data, binary, cm.ResourceVersion, nil
+
+	default:
+		var secret corev1.Secret
+
+		if err := client.get(ctx, target.Path(), nil, &secret); err != nil {
+			return nil, nil, "", err
+		}
+
+		for key, value := range secret.Data {
+			if !utf8.Valid(value) {
+				binary = append(binary, key)
+				continue
+			}
+
+			data[key] = string(value)
+		}
+
+		return data, binary, secret.ResourceVersion, nil
+	}
+}
+
+// configConsumers returns the workloads referencing a ConfigMap or Secret in
+// volumes, projected volumes, env, envFrom or image pull secrets.
+func configConsumers(ctx context.Context, client *kubernetesClient, target *kubernetesRequest) ([]ConfigConsumer, error) {
+	result := []ConfigConsumer{}
+
+	add := func(kind, name string, spec corev1.PodSpec) {
+		if refs := podSpecReferences(spec, target.Resource, target.Name); len(refs) > 0 {
+			result = append(result, ConfigConsumer{
+				Kind: kind,
+				Name: name,
+
+				References: refs,
+			})
+		}
+	}
+
+	base := "/apis/apps/v1/namespaces/" + target.Namespace
+
+	var deployments appsv1.DeploymentList
+
+	if err := client.get(ctx, base+"/deployments", nil, &deployments); err != nil {
+		return nil, err
+	}
+
+	for _, d := range deployments.Items {
+		add("Deployment", d.Name, d.Spec.Template.Spec)
+	}
+
+	var statefulsets appsv1.StatefulSetList
+
+	if err := client.get(ctx, base+"/statefulsets", nil, &statefulsets); err != nil {
+		return nil, err
+	}
+
+	for _, s := range statefulsets.Items {
+		add("StatefulSet", s.Name, s.Spec.Template.Spec)
+	}
+
+	var daemonsets appsv1.DaemonSetList
+
+	if err := client.get(ctx, base+"/daemonsets", nil, &daemonsets); err != nil {
+		return nil, err
+	}
+
+	for _, d := range daemonsets.Items {
+		add("DaemonSet", d.Name, d.Spec.Template.Spec)
+	}
+
+	return result, nil
+}
+
+func podSpecReferences(spec corev1.PodSpec, resource, name string) []string {
+	var refs []string
+
+	secrets := resource == "secrets"
+
+	for _, v := range spec.Volumes {
+		if !secrets && v.ConfigMap != nil && v.ConfigMap.Name == name {
+			refs = append(refs, "volume "+v.Name)
+		}
+
+		if secrets && v.Secret != nil && v.Secret.SecretName == name {
+			refs = append(refs, "volume "+v.Name)
+		}
+
+		if v.Projected != nil {
+			for _, p := range v.Projected.Sources {
+				if (!secrets && p.ConfigMap != nil && p.ConfigMap.Name == name) || (secrets && p.Secret != nil && p.Secret.Name == name) {
+					refs = append(refs, "volume "+v.Name)
+				}
+			}
+		}
+	}
+
+	containers := slices.Concat(spec.InitContainers, spec.Containers)
+
+	for _, c := range containers {
+		for _, e := range c.EnvFrom {
+			if (!secrets && e.ConfigMapRef != nil && e.ConfigMapRef.Name == name) || (secrets && e.SecretRef != nil && e.SecretRef.Name == name) {
+				refs = append(refs, "envFrom in "+c.Name)
+			}
+		}
+
+		for _, e := range c.Env {
+			if e.ValueFrom == nil {
+				continue
+			}
+
+			if (!secrets && e.ValueFrom.ConfigMapKeyRef != nil && e.ValueFrom.ConfigMapKeyRef.Name == name) || (secrets && e.ValueFrom.SecretKeyRef != nil && e.ValueFrom.SecretKeyRef.Name == name) {
+				refs = append(refs, "env "+e.Name+" in "+c.Name)
+			}
+		}
+	}
+
+	if secrets {
+		for _, s := range spec.ImagePullSecrets {
+			if s.Name == name {
+				refs = append(refs, "imagePullSecrets")
+			}
+		}
+	}
+
+	return slices.Compact(refs)
+}
+
+// restartWorkload triggers a rollout restart like kubectl rollout restart,
+// subject to the disruption guard.
+func (s *Server) restartWorkload(ctx context.Context, client *kubernetesClient, context, namespace string, c ConfigConsumer) error {
+	if limit := s.config.Limits.MaxDisruptionsPerMinute; limit > 0 {
+		if ok, _ := s.disruptions.allow(strings.ToLower(context)+"/"+namespace, limit); !ok {
+			return fmt.Errorf("too many disruptions in namespace %q", namespace)
+		}
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"template": map[string]any{
+				"metadata": map[string]any{
+					"annotations": map[string]string{
+						restartAnnotation: time.Now().Format(time.RFC3339),
+					},
+				},
+			},
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	path := "/apis/apps/v1/namespaces/" + namespace + "/" + strings.ToLower(c.Kind) + "s/" + c.Name
+
+	return client.patch(ctx, path, nil, "application/strategic-merge-patch+json", patch, nil)
+}
+
+// validateConfigData checks values against the format implied by their key
+// (.json, .yaml/.yml, .properties).
+func validateConfigData(data map[string]string) []string {
+	var problems []string
+
+	for key, value := range data {
+		var err error
+
+		switch strings.ToLower(path.Ext(key)) {
+		case ".json":
+			var v any
+			err = json.Unmarshal([]byte(value), &v)
+
+		case ".yaml", ".yml":
+			var v any
+			err = yaml.Unmarshal([]byte(value), &v)
+
+		case ".properties":
+			err = validateProperties(value)
+		}
+
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+		}
+	}
+
+	slices.Sort(problems)
+
+	return problems
+}
+
+// validateProperties checks for malformed unicode escapes and a dangling
+// line continuation; any other line is a valid (possibly empty) entry.
+func validateProperties(value string) error {
+	scanner := bufio.NewScanner(strings.NewReader(value))
+
+	continuation := false
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		if !continuation && (strings.HasPrefix(text, "#") || strings.HasPrefix(text, "!")) {
+			continue
+		}
+
+		for i := 0; i < len(text); i++ {
+			if text[i] != '\\' || i+1 >= len(text) {
+				continue
+			}
+
+			if text[i+1] == 'u' {
+				if i+6 > len(text) || !isHex(text[i+2:i+6]) {
+					return fmt.Errorf("line %d: malformed \\uxxxx escape", line)
+				}
+			}
+
+			i++
+		}
+
+		continuation = strings.HasSuffix(text, `\`) && !strings.HasSuffix(text, `\\`)
+	}
+
+	if continuation {
+		return fmt.Errorf("dangling line continuation at end of file")
+	}
+
+	return scanner.Err()
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+
+	return true
+}
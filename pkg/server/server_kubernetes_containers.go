@@ -0,0 +1,255 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// containerStartReasons are the waiting reasons of containers that have not
+// failed (yet).
+var containerStartReasons = []string{"", "PodInitializing", "ContainerCreating"}
+
+// handlePodContainers breaks a pod down into its init containers, native
+// sidecars, app and ephemeral containers, in the order the kubelet starts
+// them, with their states, restarts and where to read their logs. The init
+// container or sidecar the pod waits for is marked as blocking. With ?tail,
+// the last lines of the logs of failing containers are included.
+func (s *Server) handlePodContainers(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	namespace := r.PathValue("namespace")
+	pod := r.PathValue("name")
+
+	var tail int64
+
+	if v := r.URL.Query().Get("tail"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+
+		if err != nil || n < 0 {
+			http.Error(w, "invalid tail", http.StatusBadRequest)
+			return
+		}
+
+		tail = n
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	path := "/api/v1/namespaces/" + namespace + "/pods/" + pod
+
+	var p corev1.Pod
+
+	if err := client.get(r.Context(), path, nil, &p); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := podContainers(&p)
+
+	logs := "/contexts/" + url.PathEscape(name) + path + "/log"
+
+	for i := range result.Containers {
+		c := &result.Containers[i]
+
+		c.Logs = logs + "?container=" + url.QueryEscape(c.Name)
+
+		if c.LastTermination != nil {
+			c.PreviousLogs = c.Logs + "&previous=true"
+		}
+	}
+
+	if tail > 0 {
+		var wg sync.WaitGroup
+
+		for i := range result.Containers {
+			c := &result.Containers[i]
+
+			if !c.Failing {
+				continue
+			}
+
+			query := url.Values{
+				"container": {c.Name},
+				"tailLines": {strconv.FormatInt(tail, 10)},
+			}
+
+			// a crashing container has no logs until it runs again
+			if c.State == "waiting" && c.LastTermination != nil {
+				query.Set("previous", "true")
+			}
+
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				var data []byte
+
+				if err := client.send(r.Context(), http.MethodGet, path+"/log", query, "text/plain", "", nil, &data); err == nil {
+					c.Tail = string(data)
+				}
+			}()
+		}
+
+		wg.Wait()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// podContainers merges the spec and status of the containers of a pod.
+func podContainers(p *corev1.Pod) *PodContainers {
+	result := &PodContainers{
+		Namespace: p.Namespace,
+		Name:      p.Name,
+		Phase:     string(p.Status.Phase),
+
+		Containers: []PodContainer{},
+	}
+
+	statuses := map[string]corev1.ContainerStatus{}
+
+	for _, list := range [][]corev1.ContainerStatus{p.Status.InitContainerStatuses, p.Status.ContainerStatuses, p.Status.EphemeralContainerStatuses} {
+		for _, status := range list {
+			statuses[status.Name] = status
+		}
+	}
+
+	blocked := false
+
+	for i, spec := range p.Spec.InitContainers {
+		status, ok := statuses[spec.Name]
+
+		c := podContainer(spec.Name, spec.Image, status, ok)
+		c.Type = "init"
+		c.Order = i + 1
+
+		sidecar := spec.RestartPolicy != nil && *spec.RestartPolicy == corev1.ContainerRestartPolicyAlways
+
+		if sidecar {
+			c.Type = "sidecar"
+		} else {
+			result.InitTotal++
+		}
+
+		// init containers run in order: a sidecar has to start and an init
+		// container has to succeed before the next one runs
+		done := status.State.Terminated != nil && status.State.Terminated.ExitCode == 0
+
+		if sidecar {
+			done = status.Started != nil && *status.Started
+		} else if done {
+			result.InitCompleted++
+		}
+
+		if !done && !blocked && p.Status.Phase == corev1.PodPending {
+			c.Blocking = true
+			blocked = true
+		}
+
+		result.Containers = append(result.Containers, c)
+	}
+
+	for _, spec := range p.Spec.Containers {
+		status, ok := statuses[spec.Name]
+
+		c := podContainer(spec.Name, spec.Image, status, ok)
+		c.Type = "app"
+
+		result.Containers = append(result.Containers, c)
+	}
+
+	for _, spec := range p.Spec.EphemeralContainers {
+		status, ok := statuses[spec.Name]
+
+		c := podContainer(spec.Name, spec.Image, status, ok)
+		c.Type = "ephemeral"
+		c.Target = spec.TargetContainerName
+
+		result.Containers = append(result.Containers, c)
+	}
+
+	return result
+}
+
+func podContainer(name, image string, status corev1.ContainerStatus, ok bool) PodContainer {
+	c := PodContainer{
+		Name:  name,
+		Image: image,
+
+		State: "pending",
+	}
+
+	if !ok {
+		return c
+	}
+
+	c.ImageID = status.ImageID
+	c.Ready = status.Ready
+	c.Started = status.Started
+	c.RestartCount = status.RestartCount
+
+	switch {
+	case status.State.Running != nil:
+		c.State = "running"
+		c.StartedAt = timePtr(status.State.Running.StartedAt.Time)
+
+	case status.State.Terminated != nil:
+		t := status.State.Terminated
+
+		c.State = "terminated"
+		c.Reason = t.Reason
+		c.Message = strings.TrimSpace(t.Message)
+		c.ExitCode = &t.ExitCode
+		c.StartedAt = timePtr(t.StartedAt.Time)
+		c.FinishedAt = timePtr(t.FinishedAt.Time)
+
+		c.Failing = t.ExitCode != 0
+
+	case status.State.Waiting != nil:
+		c.State = "waiting"
+		c.Reason = status.State.Waiting.Reason
+		c.Message = status.State.Waiting.Message
+
+		c.Failing = !slices.Contains(containerStartReasons, c.Reason)
+	}
+
+	if t := status.LastTerminationState.Terminated; t != nil {
+		c.LastTermination = &PodContainerTermination{
+			Reason:     t.Reason,
+			Message:    strings.TrimSpace(t.Message),
+			ExitCode:   t.ExitCode,
+			FinishedAt: timePtr(t.FinishedAt.Time),
+		}
+
+		// restarts of a container that recovered since are not failures
+		if t.ExitCode != 0 && !status.Ready {
+			c.Failing = true
+		}
+	}
+
+	return c
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
@@ -0,0 +1,647 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// controlPlaneTimeout bounds the queries of etcd through its pods
+	controlPlaneTimeout = 30 * time.Second
+
+	// controlPlaneCertificateWarning is the time before the expiry of a
+	// certificate it is flagged at
+	controlPlaneCertificateWarning = 30 * 24 * time.Hour
+
+	// etcdDefaultQuota is the backend quota of etcd unless set by
+	// --quota-backend-bytes; writes fail with NOSPACE beyond it
+	etcdDefaultQuota = 2 << 30
+)
+
+// handleControlPlane checks the health of the control plane of a context:
+// the health checks of the API server, and for self-managed clusters whose
+// control plane is visible (kubeadm, k3s, rke2) its static pods or nodes,
+// the members of etcd and the expiry of the certificates of the API server,
+// etcd and the kubeconfig. Managed clusters only report the checks of the
+// API server. Risks are returned as issues.
+func (s *Server) handleControlPlane(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	tag := i18n.Negotiate(r)
+
+	c, ok := s.kubernetesContext(name)
+
+	if !ok {
+		writeClientError(w, r, errContextNotFound)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	checks, serving, err := apiServerChecks(r.Context(), client)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := &ControlPlaneHealth{
+		Checks: checks,
+
+		Components:   []ControlPlaneComponent{},
+		Members:      []EtcdMember{},
+		Certificates: []ControlPlaneCertificate{},
+
+		Issues: []AnalysisIssue{},
+		Errors: []string{},
+	}
+
+	add := func(rule, severity, object, id string, args ...any) {
+		result.Issues = append(result.Issues, AnalysisIssue{
+			Rule:     rule,
+			Severity: severity,
+			Object:   object,
+			Message:  i18n.Translate(tag, id, args...),
+		})
+	}
+
+	failed := func(what string, err error) bool {
+		if err == nil {
+			return false
+		}
+
+		result.Errors = append(result.Errors, what+": "+statusMessage(err))
+		return true
+	}
+
+	for _, check := range checks {
+		if !check.OK {
+			add("apiserver-check", "error", check.Name, "analysis.controlplane_check", check.Name)
+		}
+	}
+
+	// proxied contexts present the certificate of the proxy
+	if serving != nil && c.Teleport == nil && c.Upstream == "" {
+		result.Certificates = append(result.Certificates, controlPlaneCertificate("apiserver", serving))
+	}
+
+	var version struct {
+		GitVersion string `json:"gitVersion"`
+	}
+
+	if !failed("version", client.get(r.Context(), "/version", nil, &version)) {
+		result.Version = version.GitVersion
+	}
+
+	query := url.Values{
+		"labelSelector": {"tier=control-plane"},
+	}
+
+	var pods corev1.PodList
+
+	failed("pods", client.get(r.Context(), "/api/v1/namespaces/kube-system/pods", query, &pods))
+
+	var nodes corev1.NodeList
+
+	failed("nodes", client.get(r.Context(), "/api/v1/nodes", nil, &nodes))
+
+	var controlPlaneNodes []corev1.Node
+
+	for _, n := range nodes.Items {
+		if _, ok := n.Labels["node-role.kubernetes.io/control-plane"]; ok {
+			controlPlaneNodes = append(controlPlaneNodes, n)
+		} else if _, ok := n.Labels["node-role.kubernetes.io/master"]; ok {
+			controlPlaneNodes = append(controlPlaneNodes, n)
+		}
+	}
+
+	switch {
+	case strings.Contains(version.GitVersion, "+k3s"):
+		result.Distribution = "k3s"
+	case strings.Contains(version.GitVersion, "+rke2"):
+		result.Distribution = "rke2"
+	case len(pods.Items) > 0:
+		result.Distribution = "kubeadm"
+	}
+
+	// managed control planes run outside of the cluster
+	result.Managed = len(pods.Items) == 0 && len(controlPlaneNodes) == 0
+
+	var etcdPods []corev1.Pod
+
+	for _, p := range pods.Items {
+		component := controlPlaneComponent(&p)
+		result.Components = append(result.Components, component)
+
+		if !component.Ready {
+			add("component-not-ready", "error", p.Name, "analysis.controlplane_not_ready", component.Component, component.Node)
+		} else if component.Restarts > 0 && component.LastRestart != nil && time.Since(*component.LastRestart) < 24*time.Hour {
+			add("component-restarted", "warning", p.Name, "analysis.controlplane_restarted", component.Component, component.Restarts)
+		}
+
+		if component.Component == "etcd" && p.Status.Phase == corev1.PodRunning {
+			etcdPods = append(etcdPods, p)
+		}
+	}
+
+	// k3s and others embed the control plane in the binary on the node
+	if len(pods.Items) == 0 {
+		for _, n := range controlPlaneNodes {
+			component := ControlPlaneComponent{
+				Component: "node",
+				Node:      n.Name,
+				Version:   n.Status.NodeInfo.KubeletVersion,
+			}
+
+			for _, c := range n.Status.Conditions {
+				if c.Type == corev1.NodeReady {
+					component.Ready = c.Status == corev1.ConditionTrue
+				}
+			}
+
+			result.Components = append(result.Components, component)
+
+			if !component.Ready {
+				add("component-not-ready", "error", n.Name, "analysis.controlplane_not_ready", component.Component, component.Node)
+			}
+		}
+	}
+
+	if len(etcdPods) > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), controlPlaneTimeout)
+		defer cancel()
+
+		pod := &etcdPods[0]
+		flags := etcdFlags(pod)
+
+		members, err := s.etcdMembers(ctx, name, auth, pod, flags)
+
+		if !failed("etcd", err) {
+			result.Members = members
+			checkEtcdMembers(members, flags, add)
+		}
+
+		if cert, err := s.etcdCertificate(ctx, name, auth, pod, flags); !failed("etcd certificate", err) {
+			result.Certificates = append(result.Certificates, controlPlaneCertificate("etcd", cert))
+		}
+	}
+
+	if result.Distribution == "k3s" {
+		var secret corev1.Secret
+
+		if !failed("k3s-serving", client.get(r.Context(), "/api/v1/namespaces/kube-system/secrets/k3s-serving", nil, &secret)) {
+			if cert, err := parseCertificate(secret.Data[corev1.TLSCertKey]); !failed("k3s-serving", err) {
+				result.Certificates = append(result.Certificates, controlPlaneCertificate("k3s-serving", cert))
+			}
+		}
+	}
+
+	if cfg, err := s.kubernetesConfig(r.Context(), c, auth); err == nil {
+		for _, v := range []struct {
+			source string
+			data   []byte
+			file   string
+		}{
+			{"kubeconfig-ca", cfg.CAData, cfg.CAFile},
+			{"kubeconfig-client", cfg.CertData, cfg.CertFile},
+		} {
+			data := v.data
+
+			if len(data) == 0 && v.file != "" {
+				data, _ = os.ReadFile(v.file)
+			}
+
+			if len(data) == 0 {
+				continue
+			}
+
+			if cert, err := parseCertificate(data); err == nil {
+				result.Certificates = append(result.Certificates, controlPlaneCertificate(v.source, cert))
+			}
+		}
+	}
+
+	for _, cert := range result.Certificates {
+		switch {
+		case cert.Expired:
+			add("certificate-expired", "error", cert.Source, "analysis.controlplane_certificate_expired", cert.Subject, cert.NotAfter.Format(time.DateOnly))
+		case time.Until(cert.NotAfter) < controlPlaneCertificateWarning:
+			add("certificate-expiring", "warning", cert.Source, "analysis.controlplane_certificate_expiring", cert.Subject, cert.Days)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// apiServerChecks returns the verbose readiness checks of the API server,
+// e.g. [+]etcd ok, and the serving certificate it presented.
+func apiServerChecks(ctx context.Context, client *kubernetesClient) ([]ControlPlaneCheck, *x509.Certificate, error) {
+	u := *client.target
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/readyz"
+	u.RawQuery = "verbose"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := client.transport.RoundTrip(req)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	// failing checks are reported with 500
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusInternalServerError {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+		return nil, nil, &upstreamError{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header,
+			Body:       body,
+		}
+	}
+
+	var cert *x509.Certificate
+
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		cert = resp.TLS.PeerCertificates[0]
+	}
+
+	checks := []ControlPlaneCheck{}
+
+	scanner := bufio.NewScanner(resp.Body)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		var ok bool
+
+		switch {
+		case strings.HasPrefix(line, "[+]"):
+			ok = true
+		case strings.HasPrefix(line, "[-]"):
+			ok = false
+		default:
+			continue
+		}
+
+		// e.g. [-]etcd failed: reason withheld
+		name, message, _ := strings.Cut(line[3:], " ")
+
+		check := ControlPlaneCheck{
+			Name: name,
+			OK:   ok,
+		}
+
+		if !ok {
+			check.Message = message
+		}
+
+		checks = append(checks, check)
+	}
+
+	return checks, cert, scanner.Err()
+}
+
+func controlPlaneComponent(p *corev1.Pod) ControlPlaneComponent {
+	component := ControlPlaneComponent{
+		Component: p.Labels["component"],
+		Pod:       p.Name,
+		Node:      p.Spec.NodeName,
+	}
+
+	if component.Component == "" {
+		component.Component = p.Name
+	}
+
+	if len(p.Spec.Containers) > 0 {
+		_, component.Version = splitImageReference(p.Spec.Containers[0].Image)
+	}
+
+	for _, c := range p.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			component.Ready = c.Status == corev1.ConditionTrue
+		}
+	}
+
+	for _, status := range p.Status.ContainerStatuses {
+		component.Restarts += status.RestartCount
+
+		if t := status.LastTerminationState.Terminated; t != nil {
+			component.Reason = t.Reason
+			component.LastRestart = timePtr(t.FinishedAt.Time)
+		}
+	}
+
+	return component
+}
+
+// etcdFlags returns the flags of the etcd container of a static pod, e.g.
+// cert-file for --cert-file=/etc/kubernetes/pki/etcd/server.crt.
+func etcdFlags(p *corev1.Pod) map[string]string {
+	flags := map[string]string{}
+
+	for _, c := range p.Spec.Containers {
+		for _, arg := range slices.Concat(c.Command, c.Args) {
+			if key, value, ok := strings.Cut(strings.TrimPrefix(arg, "--"), "="); ok && strings.HasPrefix(arg, "--") {
+				flags[key] = value
+			}
+		}
+	}
+
+	return flags
+}
+
+// etcdEndpoint returns the first client URL etcd listens on.
+func etcdEndpoint(flags map[string]string) string {
+	endpoint, _, _ := strings.Cut(flags["listen-client-urls"], ",")
+
+	if endpoint == "" {
+		endpoint = "https://127.0.0.1:2379"
+	}
+
+	return endpoint
+}
+
+// etcdMembers queries the members of etcd with etcdctl in an etcd pod,
+// authenticated by the certificates etcd serves with.
+func (s *Server) etcdMembers(ctx context.Context, name string, auth *config.AuthInfo, pod *corev1.Pod, flags map[string]string) ([]EtcdMember, error) {
+	command := []string{
+		"etcdctl",
+		"--endpoints=" + etcdEndpoint(flags),
+		"--cacert=" + flags["trusted-ca-file"],
+		"--cert=" + flags["cert-file"],
+		"--key=" + flags["key-file"],
+		"--write-out=json",
+	}
+
+	run := func(args ...string) ([]byte, error) {
+		var stdout bytes.Buffer
+
+		err := s.podExec(ctx, name, auth, pod.Namespace, pod.Name, pod.Spec.Containers[0].Name, append(slices.Clone(command), args...), nil, &stdout)
+
+		// unhealthy members make etcdctl fail after writing the others
+		var exec *podExecError
+
+		if errors.As(err, &exec) && stdout.Len() > 0 {
+			err = nil
+		}
+
+		return stdout.Bytes(), err
+	}
+
+	data, err := run("member", "list")
+
+	if err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Members []struct {
+			ID         uint64   `json:"ID"`
+			Name       string   `json:"name"`
+			ClientURLs []string `json:"clientURLs"`
+			IsLearner  bool     `json:"isLearner"`
+		} `json:"members"`
+	}
+
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("unexpected output of etcdctl: %w", err)
+	}
+
+	var health []struct {
+		Endpoint string `json:"endpoint"`
+		Health   bool   `json:"health"`
+		Took     string `json:"took"`
+		Error    string `json:"error"`
+	}
+
+	if data, err := run("endpoint", "health", "--cluster"); err == nil {
+		json.Unmarshal(data, &health)
+	}
+
+	var status []struct {
+		Endpoint string `json:"Endpoint"`
+
+		Status struct {
+			Header struct {
+				MemberID uint64 `json:"member_id"`
+			} `json:"header"`
+
+			Version     string   `json:"version"`
+			DBSize      int64    `json:"dbSize"`
+			DBSizeInUse int64    `json:"dbSizeInUse"`
+			Leader      uint64   `json:"leader"`
+			Errors      []string `json:"errors"`
+		} `json:"Status"`
+	}
+
+	if data, err := run("endpoint", "status", "--cluster"); err == nil {
+		json.Unmarshal(data, &status)
+	}
+
+	result := []EtcdMember{}
+
+	for _, m := range list.Members {
+		member := EtcdMember{
+			ID:      strconv.FormatUint(m.ID, 16),
+			Name:    m.Name,
+			Learner: m.IsLearner,
+		}
+
+		if len(m.ClientURLs) > 0 {
+			member.Endpoint = m.ClientURLs[0]
+		}
+
+		for _, h := range health {
+			if slices.Contains(m.ClientURLs, h.Endpoint) {
+				member.Healthy = h.Health
+				member.Took = h.Took
+				member.Error = h.Error
+			}
+		}
+
+		for _, st := range status {
+			if st.Status.Header.MemberID != m.ID {
+				continue
+			}
+
+			member.Version = st.Status.Version
+			member.DBSize = st.Status.DBSize
+			member.DBSizeInUse = st.Status.DBSizeInUse
+			member.Leader = st.Status.Leader == m.ID
+			member.Alarms = st.Status.Errors
+		}
+
+		result = append(result, member)
+	}
+
+	return result, nil
+}
+
+// checkEtcdMembers flags unhealthy members, a missing leader, member counts
+// without fault tolerance, raised alarms and databases close to their quota.
+func checkEtcdMembers(members []EtcdMember, flags map[string]string, add func(rule, severity, object, id string, args ...any)) {
+	quota := int64(etcdDefaultQuota)
+
+	if v, err := strconv.ParseInt(flags["quota-backend-bytes"], 10, 64); err == nil && v > 0 {
+		quota = v
+	}
+
+	voters := 0
+	leader := false
+
+	for _, m := range members {
+		if !m.Learner {
+			voters++
+		}
+
+		if m.Leader {
+			leader = true
+		}
+
+		if !m.Healthy {
+			add("etcd-member-unhealthy", "error", m.Name, "analysis.controlplane_etcd_unhealthy", m.Name, m.Error)
+		}
+
+		for _, alarm := range m.Alarms {
+			add("etcd-alarm", "error", m.Name, "analysis.controlplane_etcd_alarm", m.Name, alarm)
+		}
+
+		if m.DBSize > quota*8/10 {
+			add("etcd-quota", "warning", m.Name, "analysis.controlplane_etcd_quota", m.Name, m.DBSize*100/quota)
+		}
+	}
+
+	if len(members) > 0 && !leader {
+		add("etcd-no-leader", "error", "", "analysis.controlplane_etcd_no_leader")
+	}
+
+	// an even number of members tolerates no more failures than one less
+	if voters > 1 && voters%2 == 0 {
+		add("etcd-even-members", "warning", "", "analysis.controlplane_etcd_even", voters)
+	}
+}
+
+// etcdCertificate returns the serving certificate of etcd, read from the
+// TLS handshake through a port-forward to an etcd pod. etcd requires client
+// certificates, so the handshake may fail after the certificate was sent.
+func (s *Server) etcdCertificate(ctx context.Context, name string, auth *config.AuthInfo, pod *corev1.Pod, flags map[string]string) (*x509.Certificate, error) {
+	u, err := url.Parse(etcdEndpoint(flags))
+
+	if err != nil {
+		return nil, err
+	}
+
+	port, _ := strconv.Atoi(u.Port())
+
+	if port == 0 {
+		port = 2379
+	}
+
+	dialer, err := s.portForward(ctx, name, auth, pod.Namespace, pod.Name, port)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer dialer.Close()
+
+	conn, err := dialer.Dial()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	var cert *x509.Certificate
+
+	client := tls.Client(conn, &tls.Config{
+		ServerName: u.Hostname(),
+
+		// only the certificate is inspected, nothing is sent
+		InsecureSkipVerify: true,
+
+		VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+			if len(raw) > 0 {
+				cert, _ = x509.ParseCertificate(raw[0])
+			}
+
+			return nil
+		},
+	})
+
+	err = client.HandshakeContext(ctx)
+
+	if cert == nil {
+		if err == nil {
+			err = errors.New("no certificate presented")
+		}
+
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// parseCertificate parses the first certificate of PEM data.
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate found")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func controlPlaneCertificate(source string, cert *x509.Certificate) ControlPlaneCertificate {
+	left := time.Until(cert.NotAfter)
+
+	return ControlPlaneCertificate{
+		Source: source,
+
+		Subject: cert.Subject.CommonName,
+		Issuer:  cert.Issuer.CommonName,
+
+		DNSNames: cert.DNSNames,
+
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+
+		Days:    int(left.Hours() / 24),
+		Expired: left <= 0,
+	}
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// deviceProductLabels describe the devices of nodes, set by the GPU
+// operators and managed node pools.
+var deviceProductLabels = []string{
+	"nvidia.com/gpu.product",
+	"amd.com/gpu.product-name",
+	"cloud.google.com/gke-accelerator",
+	"k8s.amazonaws.com/accelerator",
+}
+
+// extendedResource reports whether a resource is advertised by a device
+// plugin, e.g. nvidia.com/gpu, rather than a native resource.
+func extendedResource(name corev1.ResourceName) bool {
+	domain, _, ok := strings.Cut(string(name), "/")
+
+	if !ok || strings.HasPrefix(string(name), corev1.ResourceHugePagesPrefix) {
+		return false
+	}
+
+	return domain != "kubernetes.io" && !strings.HasSuffix(domain, ".kubernetes.io")
+}
+
+// deviceCounts returns the extended resources of a list as whole devices,
+// optionally limited to one resource.
+func deviceCounts(list corev1.ResourceList, filter string) map[string]int64 {
+	result := map[string]int64{}
+
+	for name, q := range list {
+		if !extendedResource(name) || (filter != "" && string(name) != filter) {
+			continue
+		}
+
+		result[string(name)] = q.Value()
+	}
+
+	return result
+}
+
+// unschedulable returns the message of the scheduler for a pending pod that
+// could not be placed.
+func unschedulable(pod *corev1.Pod) (string, bool) {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionFalse && c.Reason == corev1.PodReasonUnschedulable {
+			return c.Message, true
+		}
+	}
+
+	return "", false
+}
+
+// handleDevices reports the extended resources of device plugins (GPUs and
+// other accelerators) per node and per pod: capacity, allocatable and
+// allocated devices, and pending pods the scheduler cannot place because
+// of insufficient devices. With ?resource=nvidia.com/gpu the report is
+// limited to one resource.
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	client, err := s.kubernetesClient(r.Context(), r.PathValue("context"), auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	filter := r.URL.Query().Get("resource")
+
+	var nodes corev1.NodeList
+
+	if err := client.get(r.Context(), "/api/v1/nodes", nil, &nodes); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	var pods corev1.PodList
+
+	query := url.Values{
+		"fieldSelector": {"status.phase!=Succeeded,status.phase!=Failed"},
+	}
+
+	if err := client.get(r.Context(), "/api/v1/pods", query, &pods); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := &DeviceReport{
+		Resources: []DeviceResource{},
+		Nodes:     []DeviceNode{},
+		Pods:      []DevicePod{},
+	}
+
+	totals := map[string]*DeviceResource{}
+
+	total := func(name string) *DeviceResource {
+		t, ok := totals[name]
+
+		if !ok {
+			t = &DeviceResource{Name: name}
+			totals[name] = t
+		}
+
+		return t
+	}
+
+	allocated := map[string]map[string]int64{}
+
+	for i := range pods.Items {
+		p := &pods.Items[i]
+
+		workload := &fitWorkload{manifestWorkload: manifestWorkload{Spec: p.Spec}}
+		applyLimitRanges(workload, nil)
+
+		requests := deviceCounts(workload.requests, filter)
+
+		if len(requests) == 0 {
+			continue
+		}
+
+		pod := DevicePod{
+			Namespace: p.Namespace,
+			Name:      p.Name,
+			Node:      p.Spec.NodeName,
+			Phase:     string(p.Status.Phase),
+
+			Requests: requests,
+		}
+
+		if owner := metav1.GetControllerOf(p); owner != nil {
+			pod.Owner = owner.Kind + "/" + owner.Name
+		}
+
+		if p.Spec.NodeName != "" {
+			if allocated[p.Spec.NodeName] == nil {
+				allocated[p.Spec.NodeName] = map[string]int64{}
+			}
+
+			for name, n := range requests {
+				allocated[p.Spec.NodeName][name] += n
+				total(name).Allocated += n
+			}
+		} else if message, ok := unschedulable(p); ok {
+			pod.Message = message
+
+			// the scheduler reports e.g. "0/3 nodes are available: 3 Insufficient nvidia.com/gpu."
+			for name := range requests {
+				if strings.Contains(message, "Insufficient "+name) {
+					pod.Blocked = true
+				}
+			}
+
+			for name, n := range requests {
+				total(name).Pending += n
+			}
+		}
+
+		result.Pods = append(result.Pods, pod)
+	}
+
+	for _, n := range nodes.Items {
+		capacity := deviceCounts(n.Status.Capacity, filter)
+
+		if len(capacity) == 0 {
+			continue
+		}
+
+		node := DeviceNode{
+			Name: n.Name,
+
+			Unschedulable: n.Spec.Unschedulable,
+
+			Devices: []DeviceAllocation{},
+		}
+
+		for _, label := range deviceProductLabels {
+			if product, ok := n.Labels[label]; ok {
+				node.Product = product
+				break
+			}
+		}
+
+		allocatable := deviceCounts(n.Status.Allocatable, filter)
+
+		for name, c := range capacity {
+			device := DeviceAllocation{
+				Resource: name,
+
+				Capacity:    c,
+				Allocatable: allocatable[name],
+				Allocated:   allocated[n.Name][name],
+			}
+
+			t := total(name)
+			t.Nodes++
+			t.Capacity += device.Capacity
+			t.Allocatable += device.Allocatable
+
+			node.Devices = append(node.Devices, device)
+		}
+
+		slices.SortFunc(node.Devices, func(a, b DeviceAllocation) int {
+			return strings.Compare(a.Resource, b.Resource)
+		})
+
+		result.Nodes = append(result.Nodes, node)
+	}
+
+	for _, t := range totals {
+		result.Resources = append(result.Resources, *t)
+	}
+
+	slices.SortFunc(result.Resources, func(a, b DeviceResource) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	slices.SortFunc(result.Nodes, func(a, b DeviceNode) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	slices.SortFunc(result.Pods, func(a, b DevicePod) int {
+		return strings.Compare(a.Namespace+"/"+a.Name, b.Namespace+"/"+b.Name)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
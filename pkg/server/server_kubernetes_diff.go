@@ -0,0 +1,375 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// manifestMapper resolves the kinds of manifest objects to resources using
+// discovery, which is fetched once per group version.
+type manifestMapper struct {
+	client *kubernetesClient
+
+	resources map[string][]metav1.APIResource
+}
+
+// forget drops the discovery of a group version, e.g. after its CRD was
+// created.
+func (m *manifestMapper) forget(apiVersion string) {
+	delete(m.resources, apiVersion)
+}
+
+func newManifestMapper(client *kubernetesClient) *manifestMapper {
+	return &manifestMapper{
+		client:    client,
+		resources: map[string][]metav1.APIResource{},
+	}
+}
+
+// resolve returns the request targeting an object of a manifest. Namespaced
+// objects without a namespace are placed in the given one.
+func (m *manifestMapper) resolve(ctx context.Context, obj map[string]any, namespace string) (*kubernetesRequest, error) {
+	apiVersion, _ := obj["apiVersion"].(string)
+	kind, _ := obj["kind"].(string)
+
+	if apiVersion == "" || kind == "" {
+		return nil, errors.New("apiVersion and kind are required")
+	}
+
+	metadata, _ := obj["metadata"].(map[string]any)
+	name, _ := metadata["name"].(string)
+
+	if name == "" {
+		return nil, errors.New("metadata.name is required")
+	}
+
+	gv, err := schema.ParseGroupVersion(apiVersion)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resources, ok := m.resources[apiVersion]
+
+	if !ok {
+		path := "/apis/" + apiVersion
+
+		if gv.Group == "" {
+			path = "/api/" + apiVersion
+		}
+
+		var list metav1.APIResourceList
+
+		if err := m.client.get(ctx, path, nil, &list); err != nil && statusCode(err) != http.StatusNotFound {
+			return nil, err
+		}
+
+		resources = list.APIResources
+		m.resources[apiVersion] = resources
+	}
+
+	for _, r := range resources {
+		if r.Kind != kind || strings.Contains(r.Name, "/") {
+			continue
+		}
+
+		target := &kubernetesRequest{
+			Group:    gv.Group,
+			Version:  gv.Version,
+			Resource: r.Name,
+			Name:     name,
+		}
+
+		if r.Namespaced {
+			target.Namespace, _ = metadata["namespace"].(string)
+
+			if target.Namespace == "" {
+				target.Namespace = namespace
+				metadata["namespace"] = namespace
+			}
+		}
+
+		return target, nil
+	}
+
+	return nil, fmt.Errorf("no resource of kind %s in %s", kind, apiVersion)
+}
+
+// serverSideApply applies an object as a field manager. Conflicts with
+// other field managers fail unless forced.
+func serverSideApply(ctx context.Context, client *kubernetesClient, target *kubernetesRequest, obj map[string]any, manager string, dryRun, force bool) (map[string]any, error) {
+	data, err := json.Marshal(obj)
+
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{
+		"fieldManager": {manager},
+	}
+
+	if dryRun {
+		query.Set("dryRun", "All")
+	}
+
+	if force {
+		query.Set("force", "true")
+	}
+
+	var result map[string]any
+
+	// JSON is valid YAML for the apply patch type
+	if err := client.patch(ctx, target.Path(), query, "application/apply-patch+yaml", data, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// fieldManager returns the field manager of a request, which defaults to
+// the configured one.
+func (s *Server) fieldManager(r *http.Request) string {
+	if manager := r.URL.Query().Get("fieldManager"); manager != "" {
+		return manager
+	}
+
+	return s.config.FieldManager
+}
+
+// statusMessage returns the message of a kubernetes Status error.
+func statusMessage(err error) string {
+	var upstream *upstreamError
+
+	if errors.As(err, &upstream) {
+		var status metav1.Status
+
+		if json.Unmarshal(upstream.Body, &status) == nil && status.Message != "" {
+			return status.Message
+		}
+	}
+
+	return err.Error()
+}
+
+// handleDiff previews a server-side apply of the manifest in the request
+// body: every object is applied as dry-run and compared with the live
+// object, so defaulting, admission and conflicts with other field managers
+// are included. ?force and ?fieldManager match those of the apply, and
+// ?namespace sets the namespace of objects without one.
+func (s *Server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, 10*1024*1024))
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	namespace := r.URL.Query().Get("namespace")
+
+	if namespace == "" {
+		namespace = s.defaultNamespace(name)
+	}
+
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	manager := s.fieldManager(r)
+	force := r.URL.Query().Get("force") == "true"
+
+	mapper := newManifestMapper(client)
+
+	result := &DiffResult{
+		Objects: []DiffObject{},
+	}
+
+	for _, doc := range splitManifest(string(data)) {
+		item := DiffObject{
+			Line: doc.Line,
+		}
+
+		if doc.Error != nil {
+			item.Action = "error"
+			item.Error = doc.Error.Error()
+
+			result.Objects = append(result.Objects, item)
+			continue
+		}
+
+		item.APIVersion, _ = doc.Object["apiVersion"].(string)
+		item.Kind, _ = doc.Object["kind"].(string)
+
+		diffManifestObject(r.Context(), client, mapper, doc.Object, namespace, manager, force, &item)
+
+		result.Objects = append(result.Objects, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func diffManifestObject(ctx context.Context, client *kubernetesClient, mapper *manifestMapper, obj map[string]any, namespace, manager string, force bool, item *DiffObject) {
+	fail := func(err error) {
+		item.Action = "error"
+
+		if statusCode(err) == http.StatusConflict {
+			item.Action = "conflict"
+		}
+
+		item.Error = statusMessage(err)
+	}
+
+	target, err := mapper.resolve(ctx, obj, namespace)
+
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	item.Namespace = target.Namespace
+	item.Name = target.Name
+
+	var live map[string]any
+
+	if err := client.get(ctx, target.Path(), nil, &live); err != nil {
+		if statusCode(err) != http.StatusNotFound {
+			fail(err)
+			return
+		}
+
+		live = nil
+	}
+
+	applied, err := serverSideApply(ctx, client, target, obj, manager, true, force)
+
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	item.Changes = []DiffChange{}
+
+	if live == nil {
+		item.Action = "create"
+
+		// server populated metadata is left out of new objects as well
+		live = map[string]any{"metadata": map[string]any{}}
+	}
+
+	diffValues("", live, applied, &item.Changes)
+
+	slices.SortFunc(item.Changes, func(a, b DiffChange) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	if item.Action == "" {
+		item.Action = "update"
+
+		if len(item.Changes) == 0 {
+			item.Action = "unchanged"
+		}
+	}
+}
+
+// diffValues records the changes from before to after, in both directions
+// unlike compareDesired. Fields maintained by the API server are ignored.
+func diffValues(path string, before, after any, changes *[]DiffChange) {
+	if ignoredDiffField(path) {
+		return
+	}
+
+	switch a := after.(type) {
+	case map[string]any:
+		b, ok := before.(map[string]any)
+
+		if !ok {
+			break
+		}
+
+		for key, value := range a {
+			child := path + "." + key
+
+			if ignoredDiffField(child) {
+				continue
+			}
+
+			old, exists := b[key]
+
+			if !exists {
+				*changes = append(*changes, DiffChange{Path: child, Op: "add", After: value})
+				continue
+			}
+
+			diffValues(child, old, value, changes)
+		}
+
+		for key, value := range b {
+			child := path + "." + key
+
+			if _, exists := a[key]; !exists && !ignoredDiffField(child) {
+				*changes = append(*changes, DiffChange{Path: child, Op: "remove", Before: value})
+			}
+		}
+
+		return
+
+	case []any:
+		b, ok := before.([]any)
+
+		if !ok {
+			break
+		}
+
+		for i := range max(len(a), len(b)) {
+			child := fmt.Sprintf("%s[%d]", path, i)
+
+			switch {
+			case i >= len(b):
+				*changes = append(*changes, DiffChange{Path: child, Op: "add", After: a[i]})
+			case i >= len(a):
+				*changes = append(*changes, DiffChange{Path: child, Op: "remove", Before: b[i]})
+			default:
+				diffValues(child, b[i], a[i], changes)
+			}
+		}
+
+		return
+
+	default:
+		if equalScalar(before, after) {
+			return
+		}
+	}
+
+	*changes = append(*changes, DiffChange{Path: path, Op: "replace", Before: before, After: after})
+}
+
+func ignoredDiffField(path string) bool {
+	switch path {
+	case ".status", ".metadata.managedFields", ".metadata.resourceVersion", ".metadata.generation", ".metadata.uid", ".metadata.creationTimestamp":
+		return true
+	}
+
+	return false
+}
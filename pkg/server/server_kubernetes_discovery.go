@@ -0,0 +1,258 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/store"
+)
+
+const (
+	// discoveryTTL is the time cached discovery documents are served without
+	// revalidating them against the API server
+	discoveryTTL = 10 * time.Minute
+
+	// discoveryRetention is the time cached discovery documents are kept
+	// without being fetched
+	discoveryRetention = 7 * 24 * time.Hour
+)
+
+func isDiscoveryRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "api" || path == "apis" || path == "version":
+		return true
+
+	case parts[0] == "api" && len(parts) == 2:
+		return true
+
+	case parts[0] == "apis" && len(parts) <= 3:
+		return true
+
+	case parts[0] == "openapi":
+		return true
+	}
+
+	return false
+}
+
+// changesDiscovery reports whether a request modifies the served APIs, which
+// invalidates the cached discovery documents.
+func changesDiscovery(r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return false
+	}
+
+	req, ok := parseKubernetesPath(r.URL.Path)
+
+	if !ok {
+		return false
+	}
+
+	return req.Resource == "customresourcedefinitions" || req.Resource == "apiservices"
+}
+
+// discoveryCache keeps the discovery and OpenAPI documents of a context in
+// the store. Documents are kept per caller, so they are only served to
+// callers the API server returned them to.
+type discoveryCache struct {
+	prefix string
+}
+
+type discoveryEntry struct {
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body"`
+
+	ETag string `json:"etag,omitempty"`
+
+	Fetched time.Time `json:"fetched"`
+}
+
+func (s *Server) discoveryCache(c config.KubernetesContext, target *url.URL) *discoveryCache {
+	sum := sha256.Sum256([]byte(strings.ToLower(c.Name) + "\x00" + target.Host))
+
+	return &discoveryCache{
+		prefix: hex.EncodeToString(sum[:8]) + "/",
+	}
+}
+
+func (c *discoveryCache) key(r *http.Request, auth *config.AuthInfo) string {
+	return c.prefix + credentialID(auth) + "/" + r.URL.Path + "?" + r.URL.RawQuery + "|" + r.Header.Get("Accept")
+}
+
+func (c *discoveryCache) get(key string) (*discoveryEntry, bool) {
+	db, err := dataStore()
+
+	if err != nil {
+		return nil, false
+	}
+
+	var e discoveryEntry
+
+	if err := db.Get(discoveryBucket, key, &e); err != nil {
+		return nil, false
+	}
+
+	return &e, true
+}
+
+func (c *discoveryCache) put(key string, e *discoveryEntry) error {
+	db, err := dataStore()
+
+	if err != nil {
+		return err
+	}
+
+	return db.Put(discoveryBucket, key, e)
+}
+
+// clear drops the documents of the context for all callers.
+func (c *discoveryCache) clear() error {
+	db, err := dataStore()
+
+	if err != nil {
+		return err
+	}
+
+	return db.DeleteFunc(discoveryBucket, func(key string, _ func(v any) error) bool {
+		return strings.HasPrefix(key, c.prefix)
+	})
+}
+
+// pruneDiscovery drops cached documents not fetched for discoveryRetention,
+// e.g. of callers that are gone.
+func pruneDiscovery(db *store.Store) {
+	err := db.DeleteFunc(discoveryBucket, func(_ string, decode func(v any) error) bool {
+		var e discoveryEntry
+
+		if err := decode(&e); err != nil {
+			return true
+		}
+
+		return time.Since(e.Fetched) > discoveryRetention
+	})
+
+	if err != nil {
+		log.Printf("failed to prune discovery cache: %v", err)
+	}
+}
+
+// serveCachedDiscovery serves API discovery and OpenAPI documents from the
+// cache of the caller. Stale entries are revalidated using the ETag of the
+// API server; OpenAPI v3 documents addressed by content hash never expire.
+func serveCachedDiscovery(w http.ResponseWriter, r *http.Request, tr http.RoundTripper, target *url.URL, cache *discoveryCache, auth *config.AuthInfo) {
+	key := cache.key(r, auth)
+
+	immutable := strings.HasPrefix(strings.Trim(r.URL.Path, "/"), "openapi/v3/") && r.URL.Query().Get("hash") != ""
+
+	entry, cached := cache.get(key)
+	if cached && (immutable || time.Since(entry.Fetched) < discoveryTTL) {
+		writeCacheEntry(w, entry, "hit")
+		return
+	}
+
+	u := *target
+	u.Path = strings.TrimSuffix(u.Path, "/") + r.URL.Path
+	u.RawQuery = r.URL.RawQuery
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, u.String(), nil)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	req.Header = r.Header.Clone()
+	req.Header.Del("Accept-Encoding")
+	req.Header.Del("If-None-Match")
+
+	if cached && entry.ETag != "" {
+		req.Header.Set("If-None-Match", entry.ETag)
+	}
+
+	resp, err := tr.RoundTrip(req)
+
+	if err != nil {
+		// serve stale data rather than failing if the upstream is unreachable
+		if cached {
+			writeCacheEntry(w, entry, "stale")
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached {
+		entry.Fetched = time.Now()
+
+		if err := cache.put(key, entry); err != nil {
+			log.Printf("failed to update discovery cache: %v", err)
+		}
+
+		writeCacheEntry(w, entry, "revalidated")
+		return
+	}
+
+	body, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		entry := &discoveryEntry{
+			Header: http.Header{},
+			Body:   body,
+
+			ETag: resp.Header.Get("ETag"),
+
+			Fetched: time.Now(),
+		}
+
+		for _, h := range []string{"Content-Type", "ETag"} {
+			if v := resp.Header.Get(h); v != "" {
+				entry.Header.Set(h, v)
+			}
+		}
+
+		if err := cache.put(key, entry); err != nil {
+			log.Printf("failed to write discovery cache: %v", err)
+		}
+	}
+
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("X-Bridge-Cache", "miss")
+
+	w.WriteHeader(resp.StatusCode)
+	w.Write(body)
+}
+
+func writeCacheEntry(w http.ResponseWriter, e *discoveryEntry, status string) {
+	for k, v := range e.Header {
+		w.Header()[k] = v
+	}
+
+	w.Header().Set("X-Bridge-Cache", status)
+	w.Write(e.Body)
+}
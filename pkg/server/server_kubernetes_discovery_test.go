@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+func TestServeCachedDiscovery(t *testing.T) {
+	t.Setenv("BRIDGE_HOME", t.TempDir())
+	t.Setenv("BRIDGE_STORE_KEY", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+
+	if _, err := dataStore(); err != nil {
+		t.Skipf("store not available: %v", err)
+	}
+
+	var requests int
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		if r.Header.Get("Authorization") != "Bearer alice" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"kind":"APIGroupList","groups":[]}`)
+	}))
+
+	defer api.Close()
+
+	target, _ := url.Parse(api.URL)
+
+	s := &Server{}
+	cache := s.discoveryCache(config.KubernetesContext{Name: "dev"}, target)
+
+	defer cache.clear()
+
+	get := func(bearer string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/apis", nil)
+		r.Header.Set("Authorization", "Bearer "+bearer)
+
+		w := httptest.NewRecorder()
+		serveCachedDiscovery(w, r, http.DefaultTransport, target, cache, &config.AuthInfo{Bearer: bearer})
+
+		return w
+	}
+
+	tests := []struct {
+		name     string
+		bearer   string
+		status   int
+		cache    string
+		requests int
+	}{
+		{"miss", "alice", http.StatusOK, "miss", 1},
+		{"hit", "alice", http.StatusOK, "hit", 1},
+		{"other caller", "mallory", http.StatusUnauthorized, "miss", 2},
+		{"other caller again", "mallory", http.StatusUnauthorized, "miss", 3},
+	}
+
+	for _, tt := range tests {
+		w := get(tt.bearer)
+
+		if w.Code != tt.status || w.Header().Get("X-Bridge-Cache") != tt.cache || requests != tt.requests {
+			t.Fatalf("%s: status %d, cache %q, %d requests; want %d, %q, %d", tt.name, w.Code, w.Header().Get("X-Bridge-Cache"), requests, tt.status, tt.cache, tt.requests)
+		}
+	}
+
+	if err := cache.clear(); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := get("alice"); w.Header().Get("X-Bridge-Cache") != "miss" {
+		t.Fatal("expected a cleared cache to fetch the documents again")
+	}
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	restartAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+	// maxRestartBody is the size up to which updates are inspected for
+	// restarts
+	maxRestartBody = 1 << 20
+)
+
+// disruptionGuard rate limits disruptive operations (pod deletions,
+// evictions and rollout restarts) per context and namespace, so a misclick
+// cannot roll an entire namespace at once.
+type disruptionGuard struct {
+	mu     sync.Mutex
+	events map[string][]time.Time
+}
+
+// allow records a disruption and reports whether it is within the limit.
+// If not, it returns the time until the next disruption is allowed.
+func (g *disruptionGuard) allow(key string, limit int) (bool, time.Duration) {
+	ok, wait, _ := g.reserve(key, limit)
+	return ok, wait
+}
+
+// reserve records a disruption like allow, which the returned func takes
+// back, e.g. if the upstream rejects it.
+func (g *disruptionGuard) reserve(key string, limit int) (bool, time.Duration, func()) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.events == nil {
+		g.events = make(map[string][]time.Time)
+	}
+
+	now := time.Now()
+	window := now.Add(-time.Minute)
+
+	events := g.events[key]
+
+	for len(events) > 0 && events[0].Before(window) {
+		events = events[1:]
+	}
+
+	if len(events) >= limit {
+		g.events[key] = events
+		return false, events[0].Sub(window), nil
+	}
+
+	g.events[key] = append(events, now)
+
+	release := func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		events := g.events[key]
+
+		if i := slices.Index(events, now); i >= 0 {
+			g.events[key] = slices.Delete(events, i, i+1)
+		}
+	}
+
+	return true, 0, release
+}
+
+// isDisruption reports whether a request deletes, evicts or restarts pods.
+// Restarts are updates of deployments, statefulsets and daemonsets that
+// change the restartedAt annotation of their pod template, as rollout
+// restarts do; the annotation stays in the template afterwards.
+func (s *Server) isDisruption(r *http.Request, req *kubernetesRequest, c config.KubernetesContext, auth *config.AuthInfo) bool {
+	if dryRun := r.URL.Query().Get("dryRun"); dryRun != "" {
+		return false
+	}
+
+	switch {
+	case req.Group == "" && req.Resource == "pods" && r.Method == http.MethodDelete:
+		return true
+
+	case req.Group == "" && req.Resource == "pods" && req.Subresource == "eviction" && r.Method == http.MethodPost:
+		return true
+
+	case req.Group == "apps" && req.Name != "" && req.Subresource == "" && (r.Method == http.MethodPatch || r.Method == http.MethodPut):
+		switch req.Resource {
+		case "deployments", "statefulsets", "daemonsets":
+			value, ok := requestedRestart(r)
+
+			if !ok {
+				return false
+			}
+
+			return s.restartChanged(r.Context(), c, auth, req, value)
+		}
+	}
+
+	return false
+}
+
+// requestedRestart returns the restartedAt annotation a request sets on the
+// pod template: in objects and merge, strategic merge and apply patches,
+// which share their structure, and in JSON patches. The body is left
+// intact; larger bodies than maxRestartBody are not inspected.
+func requestedRestart(r *http.Request) (*string, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, false
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxRestartBody+1))
+
+	r.Body = &struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+
+	// JSON patches escape the slash of the annotation
+	if err != nil || len(data) > maxRestartBody || !bytes.Contains(data, []byte("restartedAt")) {
+		return nil, false
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json-patch+json") {
+		var ops []struct {
+			Op    string          `json:"op"`
+			Path  string          `json:"path"`
+			Value json.RawMessage `json:"value"`
+		}
+
+		if err := json.Unmarshal(data, &ops); err != nil {
+			return nil, false
+		}
+
+		annotations := "/spec/template/metadata/annotations"
+		annotation := annotations + "/" + strings.ReplaceAll(restartAnnotation, "/", "~1")
+
+		for _, op := range ops {
+			switch {
+			case op.Path == annotation && op.Op == "remove":
+				return nil, true
+
+			case op.Path == annotation && (op.Op == "add" || op.Op == "replace"):
+				var value *string
+				json.Unmarshal(op.Value, &value)
+
+				return value, true
+
+			case op.Path == annotations && (op.Op == "add" || op.Op == "replace"):
+				var values map[string]*string
+				json.Unmarshal(op.Value, &values)
+
+				if value, ok := values[restartAnnotation]; ok {
+					return value, true
+				}
+			}
+		}
+
+		return nil, false
+	}
+
+	// apply patches may be YAML
+	data, err = yaml.YAMLToJSON(data)
+
+	if err != nil {
+		return nil, false
+	}
+
+	var obj podTemplateOwner
+
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, false
+	}
+
+	value, ok := obj.Spec.Template.Metadata.Annotations[restartAnnotation]
+
+	return value, ok
+}
+
+// podTemplateOwner are the annotations of the pod template of deployments,
+// statefulsets and daemonsets.
+type podTemplateOwner struct {
+	Spec struct {
+		Template struct {
+			Metadata struct {
+				Annotations map[string]*string `json:"annotations"`
+			} `json:"metadata"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
+// restartChanged reports whether a restartedAt annotation differs from the
+// one of the live object. Objects that cannot be read count as changed,
+// missing ones not, as there is nothing to restart.
+func (s *Server) restartChanged(ctx context.Context, c config.KubernetesContext, auth *config.AuthInfo, req *kubernetesRequest, value *string) bool {
+	client, err := s.kubernetesClient(ctx, c.Name, auth)
+
+	if err != nil {
+		return true
+	}
+
+	var live podTemplateOwner
+
+	if err := client.get(ctx, req.Path(), nil, &live); err != nil {
+		return statusCode(err) != http.StatusNotFound
+	}
+
+	current := live.Spec.Template.Metadata.Annotations[restartAnnotation]
+
+	if value == nil || current == nil {
+		return (value == nil) != (current == nil)
+	}
+
+	return *value != *current
+}
+
+// guardDisruption enforces the disruption policy. Pod deletions additionally
+// respect PodDisruptionBudgets, which the eviction API enforces upstream.
+// It returns false if the request was rejected, otherwise the writer to
+// respond with, which takes back the disruption if the upstream rejects it.
+func (s *Server) guardDisruption(w http.ResponseWriter, r *http.Request, c config.KubernetesContext, auth *config.AuthInfo) (http.ResponseWriter, bool) {
+	limit := s.config.Limits.MaxDisruptionsPerMinute
+
+	if limit <= 0 {
+		return w, true
+	}
+
+	req, ok := parseKubernetesPath(r.URL.Path)
+
+	if !ok || !s.isDisruption(r, req, c, auth) {
+		return w, true
+	}
+
+	if req.Resource == "pods" && req.Name == "" {
+		writeError(w, r, i18n.NewError("error.disruption_collection"), http.StatusTooManyRequests)
+		return w, false
+	}
+
+	if req.Resource == "pods" && req.Subresource == "" {
+		if err := s.checkDisruptionBudgets(r.Context(), c, auth, req); err != nil {
+			writeError(w, r, err, http.StatusTooManyRequests)
+			return w, false
+		}
+	}
+
+	key := strings.ToLower(c.Name) + "/" + req.Namespace
+
+	ok, wait, release := s.disruptions.reserve(key, limit)
+
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+
+		writeError(w, r, i18n.NewError("error.disruption_rate", req.Namespace, limit), http.StatusTooManyRequests)
+		return w, false
+	}
+
+	return &disruptionWriter{ResponseWriter: w, release: release}, true
+}
+
+// disruptionWriter takes back a disruption if the response is no success.
+type disruptionWriter struct {
+	http.ResponseWriter
+
+	release func()
+	once    sync.Once
+}
+
+func (w *disruptionWriter) WriteHeader(status int) {
+	if status >= http.StatusBadRequest {
+		w.once.Do(w.release)
+	}
+
+	w.once.Do(func() {})
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *disruptionWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {})
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *disruptionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (s *Server) checkDisruptionBudgets(ctx context.Context, c config.KubernetesContext, auth *config.AuthInfo, req *kubernetesRequest) error {
+	client, err := s.kubernetesClient(ctx, c.Name, auth)
+
+	if err != nil {
+		return err
+	}
+
+	var pod metav1.PartialObjectMetadata
+
+	if err := client.get(ctx, req.Path(), nil, &pod); err != nil {
+		// let the upstream report missing pods or permission errors
+		return nil
+	}
+
+	var pdbs policyv1.PodDisruptionBudgetList
+
+	if err := client.get(ctx, "/apis/policy/v1/namespaces/"+req.Namespace+"/poddisruptionbudgets", nil, &pdbs); err != nil {
+		return nil
+	}
+
+	for _, pdb := range pdbs.Items {
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+
+		if err != nil || selector.Empty() || !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+
+		if pdb.Status.DisruptionsAllowed < 1 {
+			return fmt.Errorf("deleting pod %q would violate PodDisruptionBudget %q", pod.Name, pdb.Name)
+		}
+	}
+
+	return nil
+}
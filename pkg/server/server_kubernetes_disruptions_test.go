@@ -0,0 +1,177 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+func TestDisruptionGuardReserve(t *testing.T) {
+	var g disruptionGuard
+
+	ok, _, release := g.reserve("dev/payments", 2)
+
+	if !ok {
+		t.Fatal("expected the first disruption to be allowed")
+	}
+
+	if ok, _ := g.allow("dev/payments", 2); !ok {
+		t.Fatal("expected the second disruption to be allowed")
+	}
+
+	if ok, wait := g.allow("dev/payments", 2); ok || wait <= 0 {
+		t.Fatalf("expected the third disruption to wait, got %v %s", ok, wait)
+	}
+
+	if ok, _ := g.allow("dev/billing", 2); !ok {
+		t.Fatal("expected namespaces to have their own budget")
+	}
+
+	// a disruption taken back frees its budget
+	release()
+
+	if ok, _ := g.allow("dev/payments", 2); !ok {
+		t.Fatal("expected a released disruption not to count")
+	}
+}
+
+func TestRequestedRestart(t *testing.T) {
+	value := func(v string) *string { return &v }
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		value       *string
+		ok          bool
+	}{
+		{"merge patch", "application/merge-patch+json", `{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":"b"}}}}}`, value("b"), true},
+		{"strategic merge patch removal", "application/strategic-merge-patch+json", `{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":null}}}}}`, nil, true},
+		{"apply patch", "application/apply-patch+yaml", "spec:\n  template:\n    metadata:\n      annotations:\n        kubectl.kubernetes.io/restartedAt: b\n", value("b"), true},
+		{"json patch", "application/json-patch+json", `[{"op":"replace","path":"/spec/template/metadata/annotations/kubectl.kubernetes.io~1restartedAt","value":"b"}]`, value("b"), true},
+		{"json patch of annotations", "application/json-patch+json", `[{"op":"add","path":"/spec/template/metadata/annotations","value":{"kubectl.kubernetes.io/restartedAt":"b"}}]`, value("b"), true},
+		{"json patch removal", "application/json-patch+json", `[{"op":"remove","path":"/spec/template/metadata/annotations/kubectl.kubernetes.io~1restartedAt"}]`, nil, true},
+		{"other annotation", "application/merge-patch+json", `{"spec":{"template":{"metadata":{"annotations":{"team":"payments"}}}}}`, nil, false},
+		{"object annotation", "application/merge-patch+json", `{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":"b"}}}`, nil, false},
+		{"scale", "application/merge-patch+json", `{"spec":{"replicas":3}}`, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/apis/apps/v1/namespaces/payments/deployments/web", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+
+			value, ok := requestedRestart(r)
+
+			if ok != tt.ok || (value == nil) != (tt.value == nil) || (value != nil && *value != *tt.value) {
+				t.Fatalf("restart = %v %v, want %v %v", value, ok, tt.value, tt.ok)
+			}
+
+			if data, _ := io.ReadAll(r.Body); string(data) != tt.body {
+				t.Fatal("expected the body to be left intact")
+			}
+		})
+	}
+
+	// larger bodies are forwarded completely
+	body := `{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":"b"}}}},"data":"` + strings.Repeat("x", 2*maxRestartBody) + `"}`
+
+	r := httptest.NewRequest(http.MethodPut, "/apis/apps/v1/namespaces/payments/deployments/web", strings.NewReader(body))
+
+	if _, ok := requestedRestart(r); ok {
+		t.Error("expected a large body not to be inspected")
+	}
+
+	if data, _ := io.ReadAll(r.Body); len(data) != len(body) {
+		t.Fatalf("forwarded %d bytes, want %d", len(data), len(body))
+	}
+}
+
+func TestGuardDisruption(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/apis/apps/v1/namespaces/payments/deployments/web":
+			fmt.Fprint(w, `{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":"a"}}}}}`)
+
+		case "/api/v1/namespaces/payments/pods/web-0":
+			fmt.Fprint(w, `{"metadata":{"name":"web-0"}}`)
+
+		case "/apis/policy/v1/namespaces/payments/poddisruptionbudgets":
+			fmt.Fprint(w, `{"items":[]}`)
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","code":404}`)
+		}
+	}))
+
+	defer api.Close()
+
+	c, err := config.KubernetesContextFromToken("dev", api.URL, "token", nil, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		config: &config.Config{
+			Kubernetes: &config.KubernetesConfig{
+				Contexts: []config.KubernetesContext{c},
+			},
+
+			Limits: config.LimitsConfig{
+				MaxDisruptionsPerMinute: 1,
+			},
+		},
+	}
+
+	restart := func(value string) string {
+		return `{"spec":{"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":"` + value + `"}}}}}`
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		upstream int
+		want     int
+	}{
+		{"update keeping the restart", http.MethodPut, "/apis/apps/v1/namespaces/payments/deployments/web", restart("a"), http.StatusOK, http.StatusOK},
+		{"scale", http.MethodPatch, "/apis/apps/v1/namespaces/payments/deployments/web", `{"spec":{"replicas":3}}`, http.StatusOK, http.StatusOK},
+		{"rejected restart", http.MethodPatch, "/apis/apps/v1/namespaces/payments/deployments/web", restart("b"), http.StatusForbidden, http.StatusForbidden},
+		{"restart", http.MethodPatch, "/apis/apps/v1/namespaces/payments/deployments/web", restart("b"), http.StatusOK, http.StatusOK},
+		{"restart beyond the limit", http.MethodPatch, "/apis/apps/v1/namespaces/payments/deployments/web", restart("c"), http.StatusOK, http.StatusTooManyRequests},
+		{"pod deletion beyond the limit", http.MethodDelete, "/api/v1/namespaces/payments/pods/web-0", "", http.StatusOK, http.StatusTooManyRequests},
+		{"pod deletion in another namespace", http.MethodDelete, "/api/v1/namespaces/billing/pods/web-0", "", http.StatusOK, http.StatusOK},
+		{"pod collection deletion", http.MethodDelete, "/api/v1/namespaces/billing/pods", "", http.StatusOK, http.StatusTooManyRequests},
+		{"dry run", http.MethodDelete, "/api/v1/namespaces/payments/pods/web-0?dryRun=All", "", http.StatusOK, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/merge-patch+json")
+
+			rec := httptest.NewRecorder()
+
+			w, ok := s.guardDisruption(rec, r, c, nil)
+
+			if ok {
+				// the upstream
+				w.WriteHeader(tt.upstream)
+			}
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,472 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// drainTimeout bounds a drain unless the request sets its own timeout
+	drainTimeout = 5 * time.Minute
+
+	// drainRetryInterval is the interval evictions refused by a
+	// PodDisruptionBudget are retried at, and pods are polled at until
+	// they are gone
+	drainRetryInterval = 5 * time.Second
+)
+
+// handleCordonNode marks a node unschedulable.
+func (s *Server) handleCordonNode(w http.ResponseWriter, r *http.Request) {
+	s.setNodeSchedulable(w, r, false)
+}
+
+// handleUncordonNode marks a node schedulable again.
+func (s *Server) handleUncordonNode(w http.ResponseWriter, r *http.Request) {
+	s.setNodeSchedulable(w, r, true)
+}
+
+func (s *Server) setNodeSchedulable(w http.ResponseWriter, r *http.Request, schedulable bool) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	node := r.PathValue("node")
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result, err := cordonNode(r.Context(), client, node, !schedulable)
+
+	if result != nil && result.Changed || err != nil {
+		entry := &AuditEntry{
+			Context: name,
+			Owner:   ownerID(auth),
+			Action:  "cordon",
+
+			Resource: "nodes",
+			Name:     node,
+		}
+
+		if schedulable {
+			entry.Action = "uncordon"
+		}
+
+		if err != nil {
+			entry.Error = err.Error()
+		}
+
+		s.audit.record(entry)
+	}
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// cordonNode sets whether a node is unschedulable. Nodes already in the
+// state are left as they are. Uncordoning a node cordoned by a chaos
+// experiment ends the experiment, so its annotation is removed.
+func cordonNode(ctx context.Context, client *kubernetesClient, node string, unschedulable bool) (*NodeCordon, error) {
+	var n corev1.Node
+
+	if err := client.get(ctx, "/api/v1/nodes/"+node, nil, &n); err != nil {
+		return nil, err
+	}
+
+	result := &NodeCordon{
+		Node:          n.Name,
+		Unschedulable: unschedulable,
+	}
+
+	if n.Spec.Unschedulable == unschedulable {
+		return result, nil
+	}
+
+	metadata := map[string]any{
+		"resourceVersion": n.ResourceVersion,
+	}
+
+	if _, ok := n.Annotations[chaosUncordonAnnotation]; ok && !unschedulable {
+		metadata["annotations"] = map[string]any{
+			chaosUncordonAnnotation: nil,
+		}
+	}
+
+	patch, _ := json.Marshal(map[string]any{
+		"metadata": metadata,
+		"spec": map[string]any{
+			"unschedulable": unschedulable,
+		},
+	})
+
+	if err := client.patch(ctx, "/api/v1/nodes/"+n.Name, nil, "application/merge-patch+json", patch, nil); err != nil {
+		return nil, err
+	}
+
+	result.Changed = true
+
+	return result, nil
+}
+
+// handleDrainNode cordons a node and evicts its pods through the eviction
+// API, like kubectl drain. DaemonSet and mirror pods are skipped, as they
+// cannot be moved. Unmanaged pods, pods with local data and pods in
+// protected namespaces block the drain unless allowed by the request; the
+// blockers are returned with a conflict before anything changes.
+//
+// Evictions refused by a PodDisruptionBudget are retried until the timeout,
+// and evictions exceeding the disruption limit wait for the limit. The
+// progress of each pod is streamed as server-sent events until it is gone.
+func (s *Server) handleDrainNode(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	node := r.PathValue("node")
+
+	var req DrainRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.GracePeriodSeconds != nil && *req.GracePeriodSeconds < 0 {
+		http.Error(w, "gracePeriodSeconds must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	timeout := drainTimeout
+
+	if req.Timeout != "" {
+		d, err := time.ParseDuration(req.Timeout)
+
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid timeout "+req.Timeout, http.StatusBadRequest)
+			return
+		}
+
+		timeout = d
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	var n corev1.Node
+
+	if err := client.get(r.Context(), "/api/v1/nodes/"+node, nil, &n); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	query := url.Values{
+		"fieldSelector": {"spec.nodeName=" + n.Name},
+	}
+
+	var pods corev1.PodList
+
+	if err := client.get(r.Context(), "/api/v1/pods", query, &pods); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := &DrainResult{
+		Node: n.Name,
+	}
+
+	var evictions []corev1.Pod
+	var skipped []DrainStep
+
+	for _, pod := range pods.Items {
+		step := DrainStep{
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+		}
+
+		if reason := drainSkipReason(&pod); reason != "" {
+			step.Phase = "skipped"
+			step.Message = reason
+
+			skipped = append(skipped, step)
+			continue
+		}
+
+		if reason := s.drainBlockReason(r, name, &pod, &req); reason != "" {
+			step.Phase = "blocked"
+			step.Message = reason
+
+			result.Blockers = append(result.Blockers, step)
+			continue
+		}
+
+		evictions = append(evictions, pod)
+	}
+
+	if len(result.Blockers) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	stream := &sseStream{
+		w:  w,
+		rc: http.NewResponseController(w),
+	}
+
+	send := func(step DrainStep) {
+		step.Time = time.Now().UTC()
+
+		data, _ := json.Marshal(step)
+		stream.send("", "step", data)
+	}
+
+	stream.start()
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	if _, err := cordonNode(ctx, client, n.Name, true); err != nil {
+		result.Error = statusMessage(err)
+	} else {
+		result.Cordoned = true
+
+		send(DrainStep{Phase: "cordoned"})
+	}
+
+	if result.Error == "" {
+		for _, step := range skipped {
+			result.Skipped++
+
+			send(step)
+		}
+
+		var evicted, failed atomic.Int64
+		var wg sync.WaitGroup
+
+		for _, pod := range evictions {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				if err := s.evictPod(ctx, client, name, &pod, req.GracePeriodSeconds, send); err != nil {
+					failed.Add(1)
+
+					send(DrainStep{Namespace: pod.Namespace, Name: pod.Name, Phase: "failed", Message: err.Error()})
+					return
+				}
+
+				evicted.Add(1)
+			}()
+		}
+
+		wg.Wait()
+
+		result.Evicted = int(evicted.Load())
+		result.Failed = int(failed.Load())
+
+		if result.Failed > 0 {
+			result.Error = fmt.Sprintf("%d of %d pods were not evicted from node %s", result.Failed, len(evictions), n.Name)
+		}
+	}
+
+	s.audit.record(&AuditEntry{
+		Context: name,
+		Owner:   ownerID(auth),
+		Action:  "drain",
+
+		Resource: "nodes",
+		Name:     n.Name,
+
+		Patch: map[string]any{
+			"evicted": result.Evicted,
+			"force":   req.Force,
+		},
+
+		Error: result.Error,
+	})
+
+	data, _ := json.Marshal(result)
+	stream.send("", "done", data)
+}
+
+// drainSkipReason returns why a pod is left on a drained node, if it is.
+func drainSkipReason(pod *corev1.Pod) string {
+	if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+		return "mirror pod of a static pod"
+	}
+
+	if ref := metav1.GetControllerOf(pod); ref != nil && ref.Kind == "DaemonSet" {
+		return "managed by DaemonSet " + ref.Name
+	}
+
+	return ""
+}
+
+// drainBlockReason returns why a pod prevents draining its node, if it
+// does. Completed pods never block, as nothing is lost by removing them.
+func (s *Server) drainBlockReason(r *http.Request, context string, pod *corev1.Pod, req *DrainRequest) string {
+	if err := s.checkProtection(r, context, pod.Namespace); err != nil {
+		return err.Error()
+	}
+
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return ""
+	}
+
+	if metav1.GetControllerOf(pod) == nil && !req.Force {
+		return "not managed by a controller, it is not recreated elsewhere"
+	}
+
+	if !req.DeleteEmptyDirData {
+		for _, v := range pod.Spec.Volumes {
+			if v.EmptyDir != nil {
+				return fmt.Sprintf("uses emptyDir volume %q, its data is lost", v.Name)
+			}
+		}
+	}
+
+	return ""
+}
+
+// evictPod evicts a pod and waits until it is gone. Evictions are retried
+// while a PodDisruptionBudget refuses them, and wait while the namespace
+// exceeds the disruption limit.
+func (s *Server) evictPod(ctx context.Context, client *kubernetesClient, name string, pod *corev1.Pod, grace *int64, send func(DrainStep)) error {
+	path := "/api/v1/namespaces/" + pod.Namespace + "/pods/" + pod.Name
+
+	step := func(phase, message string) {
+		send(DrainStep{Namespace: pod.Namespace, Name: pod.Name, Phase: phase, Message: message})
+	}
+
+	if limit := s.config.Limits.MaxDisruptionsPerMinute; limit > 0 {
+		for {
+			ok, wait := s.disruptions.allow(strings.ToLower(name)+"/"+pod.Namespace, limit)
+
+			if ok {
+				break
+			}
+
+			step("throttled", fmt.Sprintf("too many disruptions in namespace %q, retry in %ds", pod.Namespace, int(wait.Seconds())+1))
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+	}
+
+	eviction := &policyv1.Eviction{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "policy/v1",
+			Kind:       "Eviction",
+		},
+
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+
+		DeleteOptions: &metav1.DeleteOptions{
+			GracePeriodSeconds: grace,
+
+			// a pod recreated under the same name is not evicted
+			Preconditions: &metav1.Preconditions{
+				UID: &pod.UID,
+			},
+		},
+	}
+
+	step("evicting", "")
+
+	var blocked string
+
+	for {
+		err := client.create(ctx, path+"/eviction", eviction, nil)
+
+		if err == nil {
+			break
+		}
+
+		switch statusCode(err) {
+		case http.StatusNotFound, http.StatusConflict:
+			// gone or replaced meanwhile
+			step("deleted", "")
+			return nil
+
+		case http.StatusTooManyRequests:
+			// refused by a PodDisruptionBudget
+			if message := statusMessage(err); message != blocked {
+				blocked = message
+				step("blocked", message)
+			}
+
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("eviction blocked: %s", blocked)
+			case <-time.After(drainRetryInterval):
+			}
+
+		default:
+			return errors.New(statusMessage(err))
+		}
+	}
+
+	step("evicted", "")
+
+	for {
+		var current metav1.PartialObjectMetadata
+
+		if err := client.get(ctx, path, nil, &current); err != nil {
+			if statusCode(err) == http.StatusNotFound {
+				break
+			}
+
+			if ctx.Err() != nil {
+				return fmt.Errorf("pod not deleted: %w", ctx.Err())
+			}
+
+			return errors.New(statusMessage(err))
+		}
+
+		if current.UID != pod.UID {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("pod not deleted: %w", ctx.Err())
+		case <-time.After(drainRetryInterval):
+		}
+	}
+
+	step("deleted", "")
+
+	return nil
+}
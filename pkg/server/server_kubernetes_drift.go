@@ -0,0 +1,276 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const argoTokenHeader = "X-Argo-Token"
+
+// handleDrift compares a live object with the desired manifest of the Helm
+// release or Argo CD Application owning it. Only fields set in the desired
+// manifest are compared, so server defaults do not show up as drift.
+func (s *Server) handleDrift(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	target := objectResource(r)
+
+	client, err := s.kubernetesClient(r.Context(), r.PathValue("context"), auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	live := &unstructured.Unstructured{}
+
+	if err := client.get(r.Context(), target.Path(), nil, live); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := &DriftResult{
+		Drift: []DriftEntry{},
+	}
+
+	desired, err := desiredFromHelm(r.Context(), client, live, result)
+
+	if errors.Is(err, errReleaseNotFound) || (err == nil && desired == nil) {
+		argocd := s.contextCapabilities(r.Context(), r.PathValue("context"), auth).ArgoCD
+		desired, err = desiredFromArgo(r.Context(), client, argocd, live, r.Header.Get(argoTokenHeader), result)
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if desired == nil {
+		http.Error(w, "object is not managed by a Helm release or Argo CD Application", http.StatusNotFound)
+		return
+	}
+
+	compareDesired("", desired, live.Object, &result.Drift)
+
+	slices.SortFunc(result.Drift, func(a, b DriftEntry) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func desiredFromHelm(ctx context.Context, client *kubernetesClient, live *unstructured.Unstructured, result *DriftResult) (map[string]any, error) {
+	name := live.GetAnnotations()["meta.helm.sh/release-name"]
+	namespace := live.GetAnnotations()["meta.helm.sh/release-namespace"]
+
+	if name == "" {
+		return nil, nil
+	}
+
+	if namespace == "" {
+		namespace = live.GetNamespace()
+	}
+
+	release, err := latestHelmRelease(ctx, client, namespace, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result.Source = "helm"
+	result.Owner = namespace + "/" + name
+	result.Revision = strconv.Itoa(release.Version)
+
+	for _, obj := range manifestObjects(release.Manifest) {
+		u := &unstructured.Unstructured{Object: obj}
+
+		if u.GetKind() == live.GetKind() && u.GetName() == live.GetName() {
+			return obj, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s %s not found in release %s", live.GetKind(), live.GetName(), name)
+}
+
+// desiredFromArgo reads the target state from the Argo CD API server through
+// the kubernetes service proxy. Argo CD does not store rendered manifests in
+// the cluster, so an Argo CD token is required. Without a detected server the
+// default installation in the argocd namespace is assumed.
+func desiredFromArgo(ctx context.Context, client *kubernetesClient, server *ServiceRef, live *unstructured.Unstructured, token string, result *DriftResult) (map[string]any, error) {
+	app, _, _ := strings.Cut(live.GetAnnotations()["argocd.argoproj.io/tracking-id"], ":")
+
+	if app == "" {
+		app = live.GetLabels()["argocd.argoproj.io/instance"]
+	}
+
+	if app == "" {
+		return nil, nil
+	}
+
+	result.Source = "argocd"
+	result.Owner = app
+
+	if token == "" {
+		return nil, fmt.Errorf("object is managed by Argo CD application %q: an Argo CD token (%s header) is required to read its desired state", app, argoTokenHeader)
+	}
+
+	if server == nil {
+		server = &ServiceRef{Namespace: "argocd", Name: "argocd-server", Scheme: "https", Port: 443}
+	}
+
+	// apps live in the namespace of Argo CD by default
+	appNamespace := server.Namespace
+
+	if ns, name, ok := strings.Cut(app, "_"); ok {
+		// apps in any namespace use <namespace>_<name>
+		appNamespace, app = ns, name
+	}
+
+	query := url.Values{
+		"appNamespace": {appNamespace},
+		"kind":         {live.GetKind()},
+		"name":         {live.GetName()},
+		"namespace":    {live.GetNamespace()},
+	}
+
+	path := server.proxyPath() + "/api/v1/applications/" + url.PathEscape(app) + "/managed-resources"
+
+	u := *client.target
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the kubernetes credentials authenticate against the proxy, the cookie against Argo CD
+	req.AddCookie(&http.Cookie{Name: "argocd.token", Value: token})
+
+	resp, err := client.transport.RoundTrip(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("argo cd returned %s", resp.Status)
+	}
+
+	var managed struct {
+		Items []struct {
+			TargetState string `json:"targetState"`
+		} `json:"items"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&managed); err != nil {
+		return nil, err
+	}
+
+	if len(managed.Items) == 0 || managed.Items[0].TargetState == "" || managed.Items[0].TargetState == "null" {
+		return nil, fmt.Errorf("%s %s has no target state in application %s", live.GetKind(), live.GetName(), app)
+	}
+
+	var desired map[string]any
+
+	if err := json.Unmarshal([]byte(managed.Items[0].TargetState), &desired); err != nil {
+		return nil, err
+	}
+
+	return desired, nil
+}
+
+// compareDesired records every field of desired that is missing or differs in live.
+func compareDesired(path string, desired, live any, drift *[]DriftEntry) {
+	switch d := desired.(type) {
+	case map[string]any:
+		l, ok := live.(map[string]any)
+
+		if !ok {
+			*drift = append(*drift, DriftEntry{Path: path, Desired: desired, Live: live})
+			return
+		}
+
+		for key, value := range d {
+			child := path + "." + key
+
+			if ignoredDriftField(child) {
+				continue
+			}
+
+			liveValue, exists := l[key]
+
+			if !exists {
+				if isEmptyValue(value) {
+					continue
+				}
+
+				*drift = append(*drift, DriftEntry{Path: child, Desired: value})
+				continue
+			}
+
+			compareDesired(child, value, liveValue, drift)
+		}
+
+	case []any:
+		l, ok := live.([]any)
+
+		if !ok || len(l) != len(d) {
+			*drift = append(*drift, DriftEntry{Path: path, Desired: desired, Live: live})
+			return
+		}
+
+		for i := range d {
+			compareDesired(fmt.Sprintf("%s[%d]", path, i), d[i], l[i], drift)
+		}
+
+	default:
+		if !equalScalar(desired, live) {
+			*drift = append(*drift, DriftEntry{Path: path, Desired: desired, Live: live})
+		}
+	}
+}
+
+func ignoredDriftField(path string) bool {
+	switch path {
+	case ".status", ".metadata.namespace", ".metadata.creationTimestamp":
+		return true
+	}
+
+	return false
+}
+
+func isEmptyValue(v any) bool {
+	if v == nil {
+		return true
+	}
+
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() {
+	case reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	}
+
+	return false
+}
+
+// equalScalar compares scalars, treating numbers of different types and
+// their string form (e.g. ports) as equal.
+func equalScalar(a, b any) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
@@ -0,0 +1,627 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/i18n"
+
+	apipath "k8s.io/apimachinery/pkg/api/validation/path"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// maxExplorerResponse is the size of executed responses returned
+	maxExplorerResponse = 1 << 20
+
+	// maxExampleDepth bounds the nesting of example bodies
+	maxExampleDepth = 8
+)
+
+// explorerVerbs are the methods of the verbs of the API, and whether they
+// address a single object.
+var explorerVerbs = map[string]struct {
+	method string
+	item   bool
+}{
+	"get":              {http.MethodGet, true},
+	"list":             {http.MethodGet, false},
+	"watch":            {http.MethodGet, false},
+	"create":           {http.MethodPost, false},
+	"update":           {http.MethodPut, true},
+	"patch":            {http.MethodPatch, true},
+	"delete":           {http.MethodDelete, true},
+	"deletecollection": {http.MethodDelete, false},
+}
+
+// handleExplorer builds a request of the Kubernetes API from a resource and
+// a verb: its method, path and URL at the bridge, the query parameters of
+// the operation and an example body from the OpenAPI schema, with the
+// equivalent kubectl and curl commands. With execute, the request is sent
+// through the proxy, so namespace protection and disruption limits apply
+// as for any other request, and its response is returned.
+func (s *Server) handleExplorer(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+
+	var req ExplorerRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Version == "" || req.Resource == "" {
+		http.Error(w, "version and resource are required", http.StatusBadRequest)
+		return
+	}
+
+	verb, ok := explorerVerbs[req.Verb]
+
+	if !ok {
+		http.Error(w, "unsupported verb "+req.Verb, http.StatusBadRequest)
+		return
+	}
+
+	if verb.item && req.Name == "" {
+		http.Error(w, "name is required for "+req.Verb, http.StatusBadRequest)
+		return
+	}
+
+	if req.Execute && req.Verb == "watch" {
+		http.Error(w, "watches cannot be executed, use the proxy", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateExplorerRequest(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	gv := "/apis/" + url.PathEscape(req.Group) + "/" + url.PathEscape(req.Version)
+
+	if req.Group == "" {
+		gv = "/api/" + url.PathEscape(req.Version)
+	}
+
+	var resources metav1.APIResourceList
+
+	if err := client.get(r.Context(), gv, nil, &resources); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	resource := req.Resource
+
+	if req.Subresource != "" {
+		resource += "/" + req.Subresource
+	}
+
+	i := slices.IndexFunc(resources.APIResources, func(res metav1.APIResource) bool {
+		return res.Name == resource
+	})
+
+	if i < 0 {
+		writeError(w, r, i18n.NewError("error.explorer_resource", resource, strings.TrimPrefix(gv, "/")), http.StatusNotFound)
+		return
+	}
+
+	info := resources.APIResources[i]
+
+	if !slices.Contains(info.Verbs, req.Verb) {
+		writeError(w, r, i18n.NewError("error.explorer_verb", resource, req.Verb, strings.Join(info.Verbs, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	if info.Namespaced && verb.item && req.Namespace == "" {
+		http.Error(w, "namespace is required for namespaced resources", http.StatusBadRequest)
+		return
+	}
+
+	kind := info.Kind
+
+	// subresources share the kind of their resource, e.g. Scale
+	group, version := req.Group, req.Version
+
+	if info.Group != "" || info.Version != "" {
+		group, version = info.Group, info.Version
+	}
+
+	// the path and its template in the OpenAPI document
+	path, template := gv, gv
+
+	if info.Namespaced && req.Namespace != "" {
+		path += "/namespaces/" + url.PathEscape(req.Namespace)
+		template += "/namespaces/{namespace}"
+	}
+
+	path += "/" + url.PathEscape(req.Resource)
+	template += "/" + req.Resource
+
+	if verb.item {
+		path += "/" + url.PathEscape(req.Name)
+		template += "/{name}"
+	}
+
+	if req.Subresource != "" {
+		path += "/" + url.PathEscape(req.Subresource)
+		template += "/" + req.Subresource
+	}
+
+	query := url.Values{}
+
+	for k, v := range req.Query {
+		query.Set(k, v)
+	}
+
+	if req.Verb == "watch" {
+		query.Set("watch", "true")
+	}
+
+	result := &ExplorerResult{
+		Method: verb.method,
+		Path:   path,
+
+		Kind:       kind,
+		Namespaced: info.Namespaced,
+		Verbs:      info.Verbs,
+
+		Parameters: []ExplorerParameter{},
+	}
+
+	if len(query) > 0 {
+		result.Path += "?" + query.Encode()
+	}
+
+	result.URL = "/contexts/" + url.PathEscape(name) + result.Path
+
+	switch req.Verb {
+	case "create", "update":
+		result.ContentType = "application/json"
+	case "patch":
+		result.ContentType = "application/merge-patch+json"
+	}
+
+	if req.ContentType != "" && result.ContentType != "" {
+		result.ContentType = req.ContentType
+	}
+
+	if doc, _, _, err := s.loadOpenAPIDocument(r.Context(), name, auth, client, req.Group, req.Version, false); err == nil && doc != nil {
+		result.Parameters = doc.operationParameters(template, strings.ToLower(verb.method))
+
+		switch req.Verb {
+		case "create", "update":
+			if data, ok := doc.schema(group, version, kind); ok {
+				result.Example = exampleBody(data, group, version, kind, req.Namespace, req.Name)
+			}
+
+		case "patch":
+			result.Example = map[string]any{
+				"metadata": map[string]any{
+					"labels": map[string]any{
+						"example": "value",
+					},
+				},
+			}
+		}
+	}
+
+	scheme := "http"
+
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	result.Curl = explorerCurl(result, scheme+"://"+r.Host)
+	result.Kubectl = explorerKubectl(result, &req)
+
+	if req.Execute {
+		var body []byte
+
+		if req.Body != nil {
+			body, _ = json.Marshal(req.Body)
+		}
+
+		if len(body) == 0 && result.ContentType != "" {
+			http.Error(w, "body is required for "+req.Verb, http.StatusBadRequest)
+			return
+		}
+
+		proxy, err := s.kubernetesProxy(r.Context(), name, auth)
+
+		if err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+
+		out, err := http.NewRequestWithContext(r.Context(), verb.method, result.Path, bytes.NewReader(body))
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		out.Header.Set("Accept", "application/json")
+
+		if result.ContentType != "" {
+			out.Header.Set("Content-Type", result.ContentType)
+		}
+
+		// protected namespaces require the confirmation of the caller
+		if token := r.Header.Get(confirmationHeader); token != "" {
+			out.Header.Set(confirmationHeader, token)
+		}
+
+		rec := &explorerRecorder{
+			header: http.Header{},
+			status: http.StatusOK,
+		}
+
+		started := time.Now()
+
+		proxy.ServeHTTP(rec, out)
+
+		response := &ExplorerResponse{
+			Status:      rec.status,
+			Duration:    time.Since(started).Round(time.Millisecond).String(),
+			ContentType: rec.header.Get("Content-Type"),
+
+			Truncated: rec.truncated,
+		}
+
+		if err := json.Unmarshal(rec.body.Bytes(), &response.Body); err != nil {
+			response.Body = nil
+			response.Text = rec.body.String()
+		}
+
+		result.Response = response
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// validateExplorerRequest checks the parts of a request that become segments
+// of its path, so they cannot address other paths of the API: groups and
+// namespaces are DNS names, objects are named by DNS subdomains, except for
+// RBAC, which allows any name that is a path segment (e.g. system:admin).
+func validateExplorerRequest(req *ExplorerRequest) error {
+	invalid := func(field, value string, errs []string) error {
+		return fmt.Errorf("invalid %s %q: %s", field, value, strings.Join(errs, ", "))
+	}
+
+	if req.Group != "" {
+		if errs := validation.IsDNS1123Subdomain(req.Group); len(errs) > 0 {
+			return invalid("group", req.Group, errs)
+		}
+	}
+
+	if errs := validation.IsDNS1123Label(req.Version); len(errs) > 0 {
+		return invalid("version", req.Version, errs)
+	}
+
+	if errs := validation.IsDNS1123Subdomain(req.Resource); len(errs) > 0 {
+		return invalid("resource", req.Resource, errs)
+	}
+
+	if req.Subresource != "" {
+		if errs := validation.IsDNS1123Subdomain(req.Subresource); len(errs) > 0 {
+			return invalid("subresource", req.Subresource, errs)
+		}
+	}
+
+	if req.Namespace != "" {
+		if errs := validation.IsDNS1123Label(req.Namespace); len(errs) > 0 {
+			return invalid("namespace", req.Namespace, errs)
+		}
+	}
+
+	if req.Name != "" {
+		errs := validation.IsDNS1123Subdomain(req.Name)
+
+		if req.Group == "rbac.authorization.k8s.io" {
+			errs = apipath.IsValidPathSegmentName(req.Name)
+		}
+
+		if len(errs) > 0 {
+			return invalid("name", req.Name, errs)
+		}
+	}
+
+	return nil
+}
+
+// explorerRecorder captures the response of a request executed through the
+// proxy, up to maxExplorerResponse bytes.
+type explorerRecorder struct {
+	header http.Header
+	status int
+
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (e *explorerRecorder) Header() http.Header {
+	return e.header
+}
+
+func (e *explorerRecorder) WriteHeader(status int) {
+	e.status = status
+}
+
+func (e *explorerRecorder) Write(p []byte) (int, error) {
+	if n := maxExplorerResponse - e.body.Len(); len(p) > n {
+		e.body.Write(p[:max(n, 0)])
+		e.truncated = true
+
+		return len(p), nil
+	}
+
+	return e.body.Write(p)
+}
+
+// operationParameters returns the parameters of an operation, those of its
+// path included.
+func (d *openAPIDocument) operationParameters(path, method string) []ExplorerParameter {
+	result := []ExplorerParameter{}
+
+	var item map[string]json.RawMessage
+
+	if err := json.Unmarshal(d.paths[path], &item); err != nil {
+		return result
+	}
+
+	type parameter struct {
+		Ref string `json:"$ref"`
+
+		Name        string `json:"name"`
+		In          string `json:"in"`
+		Description string `json:"description"`
+		Required    bool   `json:"required"`
+
+		Schema struct {
+			Type string `json:"type"`
+		} `json:"schema"`
+	}
+
+	var common []parameter
+	json.Unmarshal(item["parameters"], &common)
+
+	var operation struct {
+		Parameters []parameter `json:"parameters"`
+	}
+
+	json.Unmarshal(item[method], &operation)
+
+	for _, p := range slices.Concat(common, operation.Parameters) {
+		if name, ok := strings.CutPrefix(p.Ref, "#/components/parameters/"); ok {
+			json.Unmarshal(d.parameters[name], &p)
+		}
+
+		if p.Name == "" || slices.ContainsFunc(result, func(e ExplorerParameter) bool { return e.Name == p.Name }) {
+			continue
+		}
+
+		result = append(result, ExplorerParameter{
+			Name: p.Name,
+			In:   p.In,
+			Type: p.Schema.Type,
+
+			Description: p.Description,
+			Required:    p.Required,
+		})
+	}
+
+	return result
+}
+
+// exampleBody returns an object of a kind with the fields its schema
+// requires, filled with defaults or empty values.
+func exampleBody(data []byte, group, version, kind, namespace, name string) map[string]any {
+	var schema map[string]any
+
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil
+	}
+
+	components, _ := schema["components"].(map[string]any)
+	schemas, _ := components["schemas"].(map[string]any)
+
+	example, _ := exampleValue(schema, schemas, 0).(map[string]any)
+
+	if example == nil {
+		example = map[string]any{}
+	}
+
+	apiVersion := version
+
+	if group != "" {
+		apiVersion = group + "/" + version
+	}
+
+	if name == "" {
+		name = "example"
+	}
+
+	metadata := map[string]any{
+		"name": name,
+	}
+
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+
+	example["apiVersion"] = apiVersion
+	example["kind"] = kind
+	example["metadata"] = metadata
+
+	// the spec is the point of most kinds, even if optional
+	if properties, _ := schema["properties"].(map[string]any); properties["spec"] != nil && example["spec"] == nil {
+		spec, _ := properties["spec"].(map[string]any)
+		example["spec"] = exampleValue(spec, schemas, 1)
+	}
+
+	delete(example, "status")
+
+	return example
+}
+
+// exampleValue returns the example of a schema: its default, its first
+// enum value, its required properties or an empty value of its type.
+func exampleValue(schema map[string]any, schemas map[string]any, depth int) any {
+	if depth > maxExampleDepth {
+		return nil
+	}
+
+	// references are wrapped in allOf to carry descriptions and defaults
+	if all, ok := schema["allOf"].([]any); ok && len(all) == 1 {
+		if ref, ok := all[0].(map[string]any); ok {
+			merged := map[string]any{}
+
+			for k, v := range ref {
+				merged[k] = v
+			}
+
+			if v, ok := schema["default"]; ok {
+				merged["default"] = v
+			}
+
+			schema = merged
+		}
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved, _ := schemas[strings.TrimPrefix(ref, schemaRefPrefix)].(map[string]any)
+
+		if resolved == nil {
+			return nil
+		}
+
+		if v, ok := schema["default"]; ok {
+			return v
+		}
+
+		return exampleValue(resolved, schemas, depth)
+	}
+
+	if v, ok := schema["default"]; ok {
+		return v
+	}
+
+	if enum, ok := schema["enum"].([]any); ok && len(enum) > 0 {
+		return enum[0]
+	}
+
+	if v, ok := schema["x-kubernetes-int-or-string"].(bool); ok && v {
+		return 0
+	}
+
+	switch schema["type"] {
+	case "string":
+		return ""
+
+	case "integer", "number":
+		return 0
+
+	case "boolean":
+		return false
+
+	case "array":
+		items, _ := schema["items"].(map[string]any)
+
+		if v, ok := exampleValue(items, schemas, depth+1).(map[string]any); ok && len(v) > 0 {
+			return []any{v}
+		}
+
+		return []any{}
+	}
+
+	result := map[string]any{}
+
+	properties, _ := schema["properties"].(map[string]any)
+	required, _ := schema["required"].([]any)
+
+	for _, name := range required {
+		name, _ := name.(string)
+		property, _ := properties[name].(map[string]any)
+
+		if property == nil {
+			continue
+		}
+
+		if v := exampleValue(property, schemas, depth+1); v != nil {
+			result[name] = v
+		}
+	}
+
+	return result
+}
+
+// explorerCurl returns the curl command of a request through the bridge.
+func explorerCurl(result *ExplorerResult, base string) string {
+	command := "curl"
+
+	if result.Method != http.MethodGet {
+		command += " -X " + result.Method
+	}
+
+	if result.ContentType != "" {
+		command += " -H 'Content-Type: " + result.ContentType + "' -d @body.json"
+	}
+
+	return command + " '" + base + result.URL + "'"
+}
+
+// explorerKubectl returns the kubectl command of a request, if kubectl can
+// send it.
+func explorerKubectl(result *ExplorerResult, req *ExplorerRequest) string {
+	switch req.Verb {
+	case "get", "list", "watch":
+		return "kubectl get --raw '" + result.Path + "'"
+
+	case "create":
+		return "kubectl create --raw '" + result.Path + "' -f body.json"
+
+	case "update":
+		return "kubectl replace --raw '" + result.Path + "' -f body.json"
+
+	case "delete", "deletecollection":
+		return "kubectl delete --raw '" + result.Path + "'"
+
+	case "patch":
+		resource := req.Resource
+
+		if req.Group != "" {
+			resource += "." + req.Group
+		}
+
+		command := "kubectl patch " + resource + " " + req.Name + " --type merge --patch-file body.json"
+
+		if req.Subresource != "" {
+			command += " --subresource " + req.Subresource
+		}
+
+		if req.Namespace != "" {
+			command += " -n " + req.Namespace
+		}
+
+		return command
+	}
+
+	return ""
+}
@@ -0,0 +1,30 @@
+package server
+
+import "testing"
+
+func TestValidateExplorerRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		req   ExplorerRequest
+		valid bool
+	}{
+		{"pod", ExplorerRequest{Version: "v1", Resource: "pods", Namespace: "default", Name: "web-0"}, true},
+		{"scale", ExplorerRequest{Group: "apps", Version: "v1", Resource: "deployments", Subresource: "scale", Namespace: "default", Name: "web"}, true},
+		{"cluster role", ExplorerRequest{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles", Name: "system:auth-delegator"}, true},
+		{"name traversal", ExplorerRequest{Version: "v1", Resource: "pods", Namespace: "default", Name: "../../secrets"}, false},
+		{"rbac traversal", ExplorerRequest{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles", Name: ".."}, false},
+		{"namespace traversal", ExplorerRequest{Version: "v1", Resource: "pods", Namespace: "default/secrets/x"}, false},
+		{"query in name", ExplorerRequest{Version: "v1", Resource: "pods", Namespace: "default", Name: "web?watch=true"}, false},
+		{"group traversal", ExplorerRequest{Group: "../api", Version: "v1", Resource: "pods"}, false},
+		{"version traversal", ExplorerRequest{Version: "v1/namespaces", Resource: "pods"}, false},
+		{"subresource traversal", ExplorerRequest{Version: "v1", Resource: "pods", Subresource: "exec/../x", Namespace: "default", Name: "web"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateExplorerRequest(&tt.req); (err == nil) != tt.valid {
+				t.Fatalf("error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type fieldsKey struct{}
+
+// identityFields are always kept, so projected items remain addressable.
+var identityFields = []string{
+	"apiVersion",
+	"kind",
+	"metadata.name",
+	"metadata.namespace",
+	"metadata.uid",
+	"metadata.resourceVersion",
+}
+
+// extractFields removes the bridge specific fields query parameter (e.g.
+// fields=metadata.labels,status.phase) from a list request and stores the
+// requested projection in the request context.
+func extractFields(r *http.Request) *http.Request {
+	if r.Method != http.MethodGet {
+		return r
+	}
+
+	query := r.URL.Query()
+
+	value := query.Get("fields")
+
+	if value == "" {
+		return r
+	}
+
+	query.Del("fields")
+	r.URL.RawQuery = query.Encode()
+
+	tree := fieldTree{}
+
+	for _, f := range append(identityFields, splitFields(value)...) {
+		tree.add(strings.Split(f, "."))
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), fieldsKey{}, tree))
+}
+
+func fieldsFromContext(ctx context.Context) (fieldTree, bool) {
+	tree, ok := ctx.Value(fieldsKey{}).(fieldTree)
+	return tree, ok
+}
+
+// projectResponse reduces list items (or table row objects) to the requested
+// fields.
+func projectResponse(resp *http.Response) error {
+	tree, ok := fieldsFromContext(resp.Request.Context())
+
+	if !ok || resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		return nil
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err != nil {
+		return err
+	}
+
+	var list map[string]any
+
+	if err := json.Unmarshal(data, &list); err != nil {
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		return nil
+	}
+
+	if items, ok := list["items"].([]any); ok {
+		for i, item := range items {
+			items[i] = tree.project(item)
+		}
+	}
+
+	if rows, ok := list["rows"].([]any); ok {
+		for _, row := range rows {
+			if row, ok := row.(map[string]any); ok && row["object"] != nil {
+				row["object"] = tree.project(row["object"])
+			}
+		}
+	}
+
+	result, err := json.Marshal(list)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(result))
+	resp.ContentLength = int64(len(result))
+
+	resp.Header.Set("Content-Length", strconv.Itoa(len(result)))
+	resp.Header.Del("Content-Encoding")
+
+	return nil
+}
+
+// fieldTree is a set of dotted field paths. A nil subtree selects the whole
+// value.
+type fieldTree map[string]fieldTree
+
+func (t fieldTree) add(path []string) {
+	if len(path) == 0 {
+		return
+	}
+
+	sub, ok := t[path[0]]
+
+	if ok && sub == nil {
+		// the whole value is already selected
+		return
+	}
+
+	if len(path) == 1 {
+		t[path[0]] = nil
+		return
+	}
+
+	if sub == nil {
+		sub = fieldTree{}
+		t[path[0]] = sub
+	}
+
+	sub.add(path[1:])
+}
+
+func (t fieldTree) project(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		result := make(map[string]any)
+
+		for key, sub := range t {
+			val, ok := v[key]
+
+			if !ok {
+				continue
+			}
+
+			if sub == nil {
+				result[key] = val
+			} else {
+				result[key] = sub.project(val)
+			}
+		}
+
+		return result
+
+	case []any:
+		result := make([]any, len(v))
+
+		for i, item := range v {
+			result[i] = t.project(item)
+		}
+
+		return result
+	}
+
+	return v
+}
+
+func splitFields(s string) []string {
+	var result []string
+
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			result = append(result, f)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,504 @@
+package server
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+// tarRecordSize is the default record size of tar, which pads archives to
+// a multiple of it.
+const tarRecordSize = 10240
+
+// podExecError is a command that failed in a container.
+type podExecError struct {
+	code   int
+	stderr string
+}
+
+func (e *podExecError) Error() string {
+	if e.stderr != "" {
+		return e.stderr
+	}
+
+	return fmt.Sprintf("command terminated with exit code %d", e.code)
+}
+
+// podExec runs a command in a container, like kubectl exec. Failures of the
+// command are returned as podExecError with its stderr.
+func (s *Server) podExec(ctx context.Context, name string, auth *config.AuthInfo, namespace, pod, container string, command []string, stdin io.Reader, stdout io.Writer) error {
+	executor, err := s.podExecutor(ctx, name, auth, namespace, pod, container, command, stdin != nil, false)
+
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: &stderr,
+	})
+
+	var exit utilexec.ExitError
+
+	if errors.As(err, &exit) {
+		return &podExecError{
+			code:   exit.ExitStatus(),
+			stderr: strings.TrimSpace(stderr.String()),
+		}
+	}
+
+	return err
+}
+
+// podExecutor prepares the exec of a command in a container. Commands with
+// a TTY have no separate stderr.
+func (s *Server) podExecutor(ctx context.Context, name string, auth *config.AuthInfo, namespace, pod, container string, command []string, stdin, tty bool) (remotecommand.Executor, error) {
+	c, ok := s.kubernetesContext(name)
+
+	if !ok {
+		return nil, errContextNotFound
+	}
+
+	config, err := s.kubernetesConfig(ctx, c, auth)
+
+	if err != nil {
+		return nil, err
+	}
+
+	target, base, err := rest.DefaultServerUrlFor(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{
+		"container": {container},
+		"command":   command,
+		"stdout":    {"true"},
+	}
+
+	if stdin {
+		query.Set("stdin", "true")
+	}
+
+	if tty {
+		query.Set("tty", "true")
+	} else {
+		query.Set("stderr", "true")
+	}
+
+	target.Path = strings.TrimSuffix(target.Path, "/") + strings.TrimSuffix(base, "/") + "/api/v1/namespaces/" + namespace + "/pods/" + pod + "/exec"
+	target.RawQuery = query.Encode()
+
+	websocket, err := remotecommand.NewWebSocketExecutor(config, http.MethodGet, target.String())
+
+	if err != nil {
+		return nil, err
+	}
+
+	spdy, err := remotecommand.NewSPDYExecutor(config, http.MethodPost, target)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// API servers before 1.30 only speak SPDY
+	return remotecommand.NewFallbackExecutor(websocket, spdy, func(err error) bool {
+		return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
+	})
+}
+
+// writePodExecError maps errors of podExec to a response.
+func writePodExecError(w http.ResponseWriter, r *http.Request, err error, command, container, file string) {
+	var status *apierrors.StatusError
+
+	if errors.As(err, &status) {
+		writeError(w, r, err, int(status.Status().Code))
+		return
+	}
+
+	var e *podExecError
+
+	if !errors.As(err, &e) {
+		writeClientError(w, r, err)
+		return
+	}
+
+	switch {
+	// shells exit with 127 for unknown commands, runtimes report the
+	// missing executable
+	case e.code == 127 || strings.Contains(e.stderr, "executable file not found"):
+		writeError(w, r, i18n.NewError("error.pod_files_command_missing", container, command), http.StatusNotImplemented)
+
+	case strings.Contains(e.stderr, "No such file or directory"):
+		writeError(w, r, i18n.NewError("error.pod_files_not_found", file), http.StatusNotFound)
+
+	default:
+		writeError(w, r, err, http.StatusBadRequest)
+	}
+}
+
+// podFileTarget resolves the pod, container and path of a file request. The
+// container defaults to the default container of the pod.
+func (s *Server) podFileTarget(r *http.Request) (string, string, string, error) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	namespace := r.PathValue("namespace")
+
+	file := r.URL.Query().Get("path")
+
+	if file == "" {
+		file = "/"
+	}
+
+	if !path.IsAbs(file) {
+		return "", "", "", errors.New("path must be absolute")
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		return "", "", "", err
+	}
+
+	var pod corev1.Pod
+
+	if err := client.get(r.Context(), "/api/v1/namespaces/"+namespace+"/pods/"+r.PathValue("name"), nil, &pod); err != nil {
+		return "", "", "", err
+	}
+
+	container := r.URL.Query().Get("container")
+
+	if container == "" {
+		container = pod.Annotations["kubectl.kubernetes.io/default-container"]
+	}
+
+	if container == "" && len(pod.Spec.Containers) > 0 {
+		container = pod.Spec.Containers[0].Name
+	}
+
+	return pod.Name, container, path.Clean(file), nil
+}
+
+func writePodFileTargetError(w http.ResponseWriter, r *http.Request, err error) {
+	if statusCode(err) == 0 && !errors.Is(err, errContextNotFound) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeClientError(w, r, err)
+}
+
+// podFileType maps the file types of stat to the types of the file list.
+func podFileType(kind string) string {
+	switch kind {
+	case "regular file", "regular empty file":
+		return "file"
+	case "directory":
+		return "dir"
+	case "symbolic link":
+		return "symlink"
+	}
+
+	return "other"
+}
+
+// handlePodFiles lists a directory of a container. It needs find and stat
+// in the container, which are part of busybox and coreutils.
+func (s *Server) handlePodFiles(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	namespace := r.PathValue("namespace")
+
+	pod, container, dir, err := s.podFileTarget(r)
+
+	if err != nil {
+		writePodFileTargetError(w, r, err)
+		return
+	}
+
+	// the trailing slash follows a symlinked directory
+	command := []string{"find", strings.TrimSuffix(dir, "/") + "/", "-mindepth", "1", "-maxdepth", "1", "-exec", "stat", "-c", "%F|%s|%Y|%a|%n", "{}", "+"}
+
+	var stdout bytes.Buffer
+
+	if err := s.podExec(r.Context(), name, auth, namespace, pod, container, command, nil, &stdout); err != nil {
+		writePodExecError(w, r, err, "find", container, dir)
+		return
+	}
+
+	result := &PodFileList{
+		Namespace: namespace,
+		Pod:       pod,
+		Container: container,
+		Path:      dir,
+
+		Items: []PodFile{},
+	}
+
+	scanner := bufio.NewScanner(&stdout)
+
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "|", 5)
+
+		if len(fields) != 5 {
+			continue
+		}
+
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		modified, _ := strconv.ParseInt(fields[2], 10, 64)
+
+		result.Items = append(result.Items, PodFile{
+			Name: path.Base(fields[4]),
+			Type: podFileType(fields[0]),
+
+			Size: size,
+			Mode: fields[3],
+
+			Modified: time.Unix(modified, 0).UTC(),
+		})
+	}
+
+	// directories first
+	slices.SortFunc(result.Items, func(a, b PodFile) int {
+		if (a.Type == "dir") != (b.Type == "dir") {
+			if a.Type == "dir" {
+				return -1
+			}
+
+			return 1
+		}
+
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	writeList(w, r, "pod-files", result, result.Items)
+}
+
+// handleDownloadPodFile downloads a file of a container, or a directory as
+// tar archive, like kubectl cp with tar in the container.
+func (s *Server) handleDownloadPodFile(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	namespace := r.PathValue("namespace")
+
+	pod, container, file, err := s.podFileTarget(r)
+
+	if err != nil {
+		writePodFileTargetError(w, r, err)
+		return
+	}
+
+	if file == "/" {
+		http.Error(w, "path must not be the root directory", http.StatusBadRequest)
+		return
+	}
+
+	command := []string{"tar", "cf", "-", "-C", path.Dir(file), path.Base(file)}
+
+	reader, writer := io.Pipe()
+
+	done := make(chan error, 1)
+
+	go func() {
+		err := s.podExec(r.Context(), name, auth, namespace, pod, container, command, nil, writer)
+		writer.CloseWithError(err)
+
+		done <- err
+	}()
+
+	defer reader.Close()
+
+	// tar writes records of 10 KiB, the first one tells files from
+	// directories
+	data := bufio.NewReaderSize(reader, 64*1024)
+	record, _ := data.Peek(tarRecordSize)
+
+	header, err := tar.NewReader(bytes.NewReader(record)).Next()
+
+	if err != nil {
+		if err := <-done; err != nil {
+			writePodExecError(w, r, err, "tar", container, file)
+			return
+		}
+
+		http.Error(w, "invalid archive of "+file, http.StatusBadGateway)
+		return
+	}
+
+	if header.Typeflag == tar.TypeReg {
+		archive := tar.NewReader(data)
+
+		if _, err := archive.Next(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(file)}))
+		w.Header().Set("Content-Length", strconv.FormatInt(header.Size, 10))
+
+		io.Copy(w, archive)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(file) + ".tar"}))
+
+	io.Copy(w, data)
+}
+
+// handleUploadPodFile writes the request body to a file of a container. The
+// file is packed into a tar archive, which tar extracts in the container.
+func (s *Server) handleUploadPodFile(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	namespace := r.PathValue("namespace")
+
+	if err := s.checkProtection(r, name, namespace); err != nil {
+		writeProtectionError(w, r, err)
+		return
+	}
+
+	pod, container, file, err := s.podFileTarget(r)
+
+	if err != nil {
+		writePodFileTargetError(w, r, err)
+		return
+	}
+
+	if file == "/" {
+		http.Error(w, "path must name a file", http.StatusBadRequest)
+		return
+	}
+
+	// the size of tar entries is written up front
+	spool, err := os.CreateTemp("", "bridge-upload-")
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, r.Body)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	mode := int64(0644)
+
+	if value := r.URL.Query().Get("mode"); value != "" {
+		m, err := strconv.ParseInt(value, 8, 64)
+
+		if err != nil || m < 0 || m > 0777 {
+			http.Error(w, "invalid mode", http.StatusBadRequest)
+			return
+		}
+
+		mode = m
+	}
+
+	reader, writer := io.Pipe()
+
+	go func() {
+		archive := tar.NewWriter(writer)
+
+		err := archive.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path.Base(file),
+			Size:     size,
+			Mode:     mode,
+			ModTime:  time.Now(),
+		})
+
+		if err == nil {
+			_, err = io.Copy(archive, spool)
+		}
+
+		if err == nil {
+			err = archive.Close()
+		}
+
+		writer.CloseWithError(err)
+	}()
+
+	command := []string{"tar", "xmf", "-", "-C", path.Dir(file)}
+
+	err = s.podExec(r.Context(), name, auth, namespace, pod, container, command, reader, io.Discard)
+	reader.Close()
+
+	entry := &AuditEntry{
+		Context: name,
+		Owner:   ownerID(auth),
+		Action:  "file-upload",
+
+		Resource:  "pods",
+		Namespace: namespace,
+		Name:      pod,
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	s.audit.record(entry)
+
+	if err != nil {
+		writePodExecError(w, r, err, "tar", container, path.Dir(file))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	json.NewEncoder(w).Encode(&PodFile{
+		Name: path.Base(file),
+		Type: "file",
+
+		Size: size,
+		Mode: strconv.FormatInt(mode, 8),
+
+		Modified: time.Now().UTC().Truncate(time.Second),
+	})
+}
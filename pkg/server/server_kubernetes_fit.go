@@ -0,0 +1,543 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// fitCountResources maps workload kinds to their object count quota.
+var fitCountResources = map[string]string{
+	"Pod":         "count/pods",
+	"Deployment":  "count/deployments.apps",
+	"StatefulSet": "count/statefulsets.apps",
+	"DaemonSet":   "count/daemonsets.apps",
+	"ReplicaSet":  "count/replicasets.apps",
+	"Job":         "count/jobs.batch",
+	"CronJob":     "count/cronjobs.batch",
+}
+
+// fitWorkload is a workload of the proposed manifest with the resources of
+// one of its pods after LimitRange defaults.
+type fitWorkload struct {
+	manifestWorkload
+
+	requests corev1.ResourceList
+	limits   corev1.ResourceList
+}
+
+func (w fitWorkload) object() string {
+	return w.Kind + "/" + w.Name
+}
+
+// fitNode is the free capacity of a schedulable node.
+type fitNode struct {
+	node *corev1.Node
+
+	cpu    resource.Quantity
+	memory resource.Quantity
+	pods   int64
+}
+
+// handleSimulateFit checks whether the workloads of a proposed manifest
+// would fit the resource quotas, LimitRanges and node capacity of a
+// context without creating them. The request body is the YAML (or JSON)
+// manifest; objects without a namespace use ?namespace (default "default").
+func (s *Server) handleSimulateFit(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, 10*1024*1024))
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	namespace := r.URL.Query().Get("namespace")
+
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	byNamespace := map[string][]*fitWorkload{}
+
+	for _, doc := range splitManifest(string(data)) {
+		if doc.Error != nil {
+			http.Error(w, fmt.Sprintf("line %d: %v", doc.Line, doc.Error), http.StatusBadRequest)
+			return
+		}
+
+		workload, ok := parseWorkload(doc)
+
+		if !ok {
+			continue
+		}
+
+		if workload.Namespace == "" {
+			workload.Namespace = namespace
+		}
+
+		byNamespace[workload.Namespace] = append(byNamespace[workload.Namespace], &fitWorkload{manifestWorkload: workload})
+	}
+
+	if len(byNamespace) == 0 {
+		http.Error(w, "the manifest contains no workloads", http.StatusBadRequest)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), r.PathValue("context"), auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := &FitResult{
+		Fits: true,
+
+		Constraints: []FitConstraint{},
+		Errors:      []string{},
+	}
+
+	add := func(c FitConstraint) {
+		result.Constraints = append(result.Constraints, c)
+
+		if !c.Fits && result.Fits {
+			result.Fits = false
+			result.Blocking = &c
+		}
+	}
+
+	var all []*fitWorkload
+
+	for namespace, workloads := range byNamespace {
+		var ranges corev1.LimitRangeList
+
+		if err := client.get(r.Context(), "/api/v1/namespaces/"+namespace+"/limitranges", nil, &ranges); err != nil {
+			result.Errors = append(result.Errors, "limitranges: "+err.Error())
+		}
+
+		for _, workload := range workloads {
+			for _, c := range applyLimitRanges(workload, ranges.Items) {
+				add(c)
+			}
+		}
+
+		var quotas corev1.ResourceQuotaList
+
+		if err := client.get(r.Context(), "/api/v1/namespaces/"+namespace+"/resourcequotas", nil, &quotas); err != nil {
+			result.Errors = append(result.Errors, "resourcequotas: "+err.Error())
+		}
+
+		for _, q := range quotas.Items {
+			for _, c := range checkQuota(q, workloads) {
+				add(c)
+			}
+		}
+
+		all = append(all, workloads...)
+	}
+
+	constraints, err := checkNodeCapacity(r.Context(), client, all)
+
+	if err != nil {
+		result.Errors = append(result.Errors, "nodes: "+err.Error())
+	}
+
+	for _, c := range constraints {
+		add(c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// applyLimitRanges defaults the container resources of a workload like the
+// LimitRanger admission plugin and checks the container min/max bounds.
+// The pod resources are the sum of the containers, or the largest init
+// container if that is higher.
+func applyLimitRanges(w *fitWorkload, ranges []corev1.LimitRange) []FitConstraint {
+	var result []FitConstraint
+
+	w.requests = corev1.ResourceList{}
+	w.limits = corev1.ResourceList{}
+
+	containers := func(list []corev1.Container, init bool) {
+		for _, c := range list {
+			requests := c.Resources.Requests.DeepCopy()
+			limits := c.Resources.Limits.DeepCopy()
+
+			if requests == nil {
+				requests = corev1.ResourceList{}
+			}
+
+			if limits == nil {
+				limits = corev1.ResourceList{}
+			}
+
+			for _, lr := range ranges {
+				for _, item := range lr.Spec.Limits {
+					if item.Type != corev1.LimitTypeContainer {
+						continue
+					}
+
+					for name, q := range item.Default {
+						if _, ok := limits[name]; !ok {
+							limits[name] = q
+						}
+					}
+
+					for name, q := range item.DefaultRequest {
+						if _, ok := requests[name]; !ok {
+							requests[name] = q
+						}
+					}
+				}
+			}
+
+			for name, q := range limits {
+				if _, ok := requests[name]; !ok {
+					requests[name] = q
+				}
+			}
+
+			for _, lr := range ranges {
+				for _, item := range lr.Spec.Limits {
+					if item.Type != corev1.LimitTypeContainer {
+						continue
+					}
+
+					for name, min := range item.Min {
+						if q, ok := requests[name]; ok && q.Cmp(min) < 0 {
+							result = append(result, limitRangeConstraint(lr.Name, w, c.Name, name, fmt.Sprintf("request %s is below the minimum %s", q.String(), min.String())))
+						}
+					}
+
+					for name, max := range item.Max {
+						if q, ok := limits[name]; !ok {
+							result = append(result, limitRangeConstraint(lr.Name, w, c.Name, name, fmt.Sprintf("a limit is required (maximum %s)", max.String())))
+						} else if q.Cmp(max) > 0 {
+							result = append(result, limitRangeConstraint(lr.Name, w, c.Name, name, fmt.Sprintf("limit %s exceeds the maximum %s", q.String(), max.String())))
+						}
+					}
+				}
+			}
+
+			for name, q := range requests {
+				addResource(w.requests, name, q, init)
+			}
+
+			for name, q := range limits {
+				addResource(w.limits, name, q, init)
+			}
+		}
+	}
+
+	containers(w.Spec.Containers, false)
+	containers(w.Spec.InitContainers, true)
+
+	return result
+}
+
+// addResource sums a container quantity into the pod resources, or raises
+// them to it for init containers, which run one at a time.
+func addResource(list corev1.ResourceList, name corev1.ResourceName, q resource.Quantity, init bool) {
+	current, ok := list[name]
+
+	if !ok {
+		list[name] = q.DeepCopy()
+		return
+	}
+
+	if init {
+		if q.Cmp(current) > 0 {
+			list[name] = q.DeepCopy()
+		}
+
+		return
+	}
+
+	current.Add(q)
+	list[name] = current
+}
+
+func limitRangeConstraint(name string, w *fitWorkload, container string, resource corev1.ResourceName, message string) FitConstraint {
+	return FitConstraint{
+		Kind:     "LimitRange",
+		Name:     name,
+		Resource: string(resource),
+		Object:   w.object(),
+		Message:  container + ": " + message,
+	}
+}
+
+// checkQuota compares the remaining capacity of a quota with the sum of the
+// workloads. Scoped quotas are not evaluated.
+func checkQuota(q corev1.ResourceQuota, workloads []*fitWorkload) []FitConstraint {
+	if len(q.Spec.Scopes) > 0 || q.Spec.ScopeSelector != nil {
+		return nil
+	}
+
+	needed := corev1.ResourceList{}
+
+	need := func(name corev1.ResourceName, v resource.Quantity) {
+		current := needed[name]
+		current.Add(v)
+		needed[name] = current
+	}
+
+	for _, w := range workloads {
+		replicas := int64(w.Replicas)
+
+		if w.Kind == "DaemonSet" {
+			// one pod per node, which is unknown to the quota
+			replicas = 1
+		}
+
+		need("pods", *resource.NewQuantity(replicas, resource.DecimalSI))
+
+		if name, ok := fitCountResources[w.Kind]; ok {
+			need(corev1.ResourceName(name), *resource.NewQuantity(1, resource.DecimalSI))
+		}
+
+		for name, v := range w.requests {
+			v = multiply(v, replicas)
+
+			need("requests."+name, v)
+
+			if name == corev1.ResourceCPU || name == corev1.ResourceMemory {
+				need(name, v)
+			}
+		}
+
+		for name, v := range w.limits {
+			need("limits."+name, multiply(v, replicas))
+		}
+	}
+
+	var result []FitConstraint
+
+	for name, hard := range q.Status.Hard {
+		v, ok := needed[name]
+
+		if !ok {
+			continue
+		}
+
+		used := q.Status.Used[name]
+
+		free := hard.DeepCopy()
+		free.Sub(used)
+
+		c := FitConstraint{
+			Kind:     "ResourceQuota",
+			Name:     q.Name,
+			Resource: string(name),
+			Fits:     v.Cmp(free) <= 0,
+			Message:  fmt.Sprintf("needs %s, %s of %s used", v.String(), used.String(), hard.String()),
+		}
+
+		if !c.Fits {
+			c.Message = fmt.Sprintf("needs %s but only %s of %s is left", v.String(), free.String(), hard.String())
+		}
+
+		result = append(result, c)
+	}
+
+	slices.SortFunc(result, func(a, b FitConstraint) int {
+		return strings.Compare(a.Resource, b.Resource)
+	})
+
+	return result
+}
+
+func multiply(q resource.Quantity, n int64) resource.Quantity {
+	result := resource.Quantity{Format: q.Format}
+
+	for range n {
+		result.Add(q)
+	}
+
+	return result
+}
+
+// checkNodeCapacity places the pods of the workloads on the free capacity
+// of schedulable nodes, first fit. Node affinity beyond nodeSelector and
+// pod (anti-)affinity are not simulated.
+func checkNodeCapacity(ctx context.Context, client *kubernetesClient, workloads []*fitWorkload) ([]FitConstraint, error) {
+	var nodes corev1.NodeList
+
+	if err := client.get(ctx, "/api/v1/nodes", nil, &nodes); err != nil {
+		return nil, err
+	}
+
+	var pods corev1.PodList
+
+	query := url.Values{
+		"fieldSelector": {"status.phase!=Succeeded,status.phase!=Failed"},
+	}
+
+	if err := client.get(ctx, "/api/v1/pods", query, &pods); err != nil {
+		return nil, err
+	}
+
+	free := map[string]*fitNode{}
+
+	var order []*fitNode
+
+	for i := range nodes.Items {
+		n := &nodes.Items[i]
+
+		if n.Spec.Unschedulable {
+			continue
+		}
+
+		node := &fitNode{
+			node: n,
+
+			cpu:    n.Status.Allocatable.Cpu().DeepCopy(),
+			memory: n.Status.Allocatable.Memory().DeepCopy(),
+			pods:   n.Status.Allocatable.Pods().Value(),
+		}
+
+		free[n.Name] = node
+		order = append(order, node)
+	}
+
+	for _, p := range pods.Items {
+		node, ok := free[p.Spec.NodeName]
+
+		if !ok {
+			continue
+		}
+
+		w := &fitWorkload{manifestWorkload: manifestWorkload{Spec: p.Spec}}
+		applyLimitRanges(w, nil)
+
+		node.cpu.Sub(*w.requests.Cpu())
+		node.memory.Sub(*w.requests.Memory())
+		node.pods--
+	}
+
+	var result []FitConstraint
+
+	for _, w := range workloads {
+		var candidates []*fitNode
+
+		for _, node := range order {
+			if schedulable(node.node, w.Spec) {
+				candidates = append(candidates, node)
+			}
+		}
+
+		c := FitConstraint{
+			Kind:   "Node",
+			Object: w.object(),
+			Fits:   true,
+		}
+
+		cpu, memory := w.requests.Cpu(), w.requests.Memory()
+
+		fits := func(node *fitNode) bool {
+			return node.pods > 0 && node.cpu.Cmp(*cpu) >= 0 && node.memory.Cmp(*memory) >= 0
+		}
+
+		place := func(node *fitNode) {
+			node.cpu.Sub(*cpu)
+			node.memory.Sub(*memory)
+			node.pods--
+		}
+
+		if w.Kind == "DaemonSet" {
+			for _, node := range candidates {
+				if !fits(node) {
+					c.Fits = false
+					c.Name = node.node.Name
+					c.Message = fmt.Sprintf("node %s has %s CPU and %s memory free, a pod needs %s CPU and %s memory", node.node.Name, node.cpu.String(), node.memory.String(), cpu.String(), memory.String())
+
+					break
+				}
+
+				place(node)
+			}
+		} else {
+			for i := range w.Replicas {
+				placed := false
+
+				for _, node := range candidates {
+					if fits(node) {
+						place(node)
+						placed = true
+
+						break
+					}
+				}
+
+				if !placed {
+					c.Fits = false
+					c.Message = fmt.Sprintf("only %d of %d replicas fit, a pod needs %s CPU and %s memory on one of %d eligible nodes", i, w.Replicas, cpu.String(), memory.String(), len(candidates))
+
+					break
+				}
+			}
+		}
+
+		if c.Fits {
+			c.Message = fmt.Sprintf("fits on %d eligible nodes", len(candidates))
+		}
+
+		result = append(result, c)
+	}
+
+	return result, nil
+}
+
+// schedulable reports whether a pod spec matches the node selector and
+// tolerates the scheduling taints of a node.
+func schedulable(node *corev1.Node, spec corev1.PodSpec) bool {
+	if !labels.SelectorFromSet(spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return false
+	}
+
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule {
+			continue
+		}
+
+		tolerated := false
+
+		for _, t := range spec.Tolerations {
+			if tolerates(t, taint) {
+				tolerated = true
+				break
+			}
+		}
+
+		if !tolerated {
+			return false
+		}
+	}
+
+	return true
+}
+
+func tolerates(t corev1.Toleration, taint corev1.Taint) bool {
+	if t.Effect != "" && t.Effect != taint.Effect {
+		return false
+	}
+
+	if t.Operator == corev1.TolerationOpExists {
+		return t.Key == "" || t.Key == taint.Key
+	}
+
+	return t.Key == taint.Key && t.Value == taint.Value
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+var errReleaseNotFound = i18n.NewError("error.release_not_found")
+
+// helmRelease is the subset of a Helm release as stored by the Helm storage
+// drivers (sh.helm.release.v1 secrets or config maps).
+type helmRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Version   int    `json:"version"`
+
+	Info struct {
+		Status string `json:"status"`
+		Notes  string `json:"notes"`
+	} `json:"info"`
+
+	Chart struct {
+		Metadata struct {
+			Name       string `json:"name"`
+			Version    string `json:"version"`
+			AppVersion string `json:"appVersion"`
+		} `json:"metadata"`
+	} `json:"chart"`
+
+	Manifest string `json:"manifest"`
+}
+
+// latestHelmRelease returns the deployed revision of a release.
+func latestHelmRelease(ctx context.Context, client *kubernetesClient, namespace, name string) (*helmRelease, error) {
+	query := url.Values{
+		"labelSelector": {"owner=helm,status=deployed,name=" + name},
+	}
+
+	var latest *helmRelease
+
+	consider := func(data []byte) {
+		release, err := decodeHelmRelease(data)
+
+		if err != nil {
+			return
+		}
+
+		if latest == nil || release.Version > latest.Version {
+			latest = release
+		}
+	}
+
+	var secrets corev1.SecretList
+
+	if err := client.get(ctx, "/api/v1/namespaces/"+namespace+"/secrets", query, &secrets); err == nil {
+		for _, s := range secrets.Items {
+			consider(s.Data["release"])
+		}
+	}
+
+	if latest == nil {
+		var configMaps corev1.ConfigMapList
+
+		if err := client.get(ctx, "/api/v1/namespaces/"+namespace+"/configmaps", query, &configMaps); err == nil {
+			for _, c := range configMaps.Items {
+				consider([]byte(c.Data["release"]))
+			}
+		}
+	}
+
+	if latest == nil {
+		return nil, errReleaseNotFound
+	}
+
+	return latest, nil
+}
+
+// decodeHelmRelease decodes base64 encoded, gzip compressed release JSON.
+func decodeHelmRelease(data []byte) (*helmRelease, error) {
+	raw, err := base64.StdEncoding.DecodeString(string(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(raw, []byte{0x1f, 0x8b}) {
+		r, err := gzip.NewReader(bytes.NewReader(raw))
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer r.Close()
+
+		if raw, err = io.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+
+	var release helmRelease
+
+	if err := json.Unmarshal(raw, &release); err != nil {
+		return nil, err
+	}
+
+	return &release, nil
+}
+
+// manifestObjects splits a rendered multi-document manifest into objects.
+func manifestObjects(manifest string) []map[string]any {
+	var result []map[string]any
+
+	for _, doc := range splitManifest(manifest) {
+		if doc.Object != nil {
+			result = append(result, doc.Object)
+		}
+	}
+
+	return result
+}
+
+// runHelm runs the helm CLI against a kubernetes context. Helm talks to a
+// loopback listener serving the bridge proxy of the context, so credentials
+// of the caller and namespace protection apply as to any other request.
+func (s *Server) runHelm(ctx context.Context, r *http.Request, name string, stdout, stderr io.Writer, args ...string) error {
+	auth := AuthInfoFromContext(r.Context())
+
+	proxy, err := s.kubernetesProxy(ctx, name, auth)
+
+	if err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return err
+	}
+
+	confirmation := r.Header.Get(confirmationHeader)
+
+	loopback := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = r.WithContext(context.WithValue(r.Context(), authInfoKey, auth))
+
+			if confirmation != "" {
+				r.Header.Set(confirmationHeader, confirmation)
+			}
+
+			proxy.ServeHTTP(w, r)
+		}),
+	}
+
+	go loopback.Serve(l)
+	defer loopback.Close()
+
+	dir, err := os.MkdirTemp("", "bridge-helm-")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(dir)
+
+	kubeconfig := filepath.Join(dir, "config")
+
+	data := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: bridge
+  cluster:
+    server: http://%s
+contexts:
+- name: bridge
+  context:
+    cluster: bridge
+    user: bridge
+users:
+- name: bridge
+  user: {}
+current-context: bridge
+`, l.Addr().String())
+
+	if err := os.WriteFile(kubeconfig, []byte(data), 0600); err != nil {
+		return err
+	}
+
+	// global flags come first, as args may end with positional arguments
+	cmd := exec.CommandContext(ctx, "helm", append([]string{"--kubeconfig", kubeconfig}, args...)...)
+	// a context selected in the environment does not exist in the loopback config
+	cmd.Env = append(os.Environ(), "HELM_KUBECONTEXT=")
+
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	return cmd.Run()
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/i18n"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+var errHelmNotInstalled = i18n.NewError("error.helm_not_installed")
+
+func (s *Server) handleHelmRepositories(w http.ResponseWriter, r *http.Request) {
+	result := []HelmRepository{}
+
+	if s.config.Helm != nil {
+		for _, repo := range s.config.Helm.Repositories {
+			result = append(result, HelmRepository{
+				Name: repo.Name,
+				URL:  repo.URL,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// helmChart resolves the chart of a request to the chart argument and
+// repository flags of helm.
+func (s *Server) helmChart(req *HelmInstallRequest) (string, []string, error) {
+	if strings.HasPrefix(req.Chart, "oci://") {
+		return req.Chart, nil, nil
+	}
+
+	repo, ok := s.config.Helm.Repository(req.Repository)
+
+	if !ok {
+		return "", nil, i18n.NewError("error.helm_repository_not_found", req.Repository)
+	}
+
+	if repo.IsOCI() {
+		return repo.URL + "/" + req.Chart, nil, nil
+	}
+
+	return req.Chart, []string{"--repo", repo.URL}, nil
+}
+
+// helmReleaseNameMaxLength is the maximum length of release names of helm.
+const helmReleaseNameMaxLength = 53
+
+// validateHelmInstallRequest checks the release and namespace of a request,
+// which helm and the release secrets require to be DNS names.
+func validateHelmInstallRequest(req *HelmInstallRequest) error {
+	invalid := func(field, value string, errs []string) error {
+		return fmt.Errorf("invalid %s %q: %s", field, value, strings.Join(errs, ", "))
+	}
+
+	if errs := validation.IsDNS1123Subdomain(req.Release); len(errs) > 0 {
+		return invalid("release", req.Release, errs)
+	}
+
+	if len(req.Release) > helmReleaseNameMaxLength {
+		return invalid("release", req.Release, []string{validation.MaxLenError(helmReleaseNameMaxLength)})
+	}
+
+	if errs := validation.IsDNS1123Label(req.Namespace); len(errs) > 0 {
+		return invalid("namespace", req.Namespace, errs)
+	}
+
+	return nil
+}
+
+// helmProgressPhase classifies a line of the helm debug output.
+func helmProgressPhase(line string) (string, string) {
+	var source string
+
+	// helm 3 prefixes debug lines with their source, e.g.
+	// "hooks.go:43: [debug] ..."
+	if prefix, message, ok := strings.Cut(line, "[debug] "); ok {
+		source = prefix
+		line = message
+	}
+
+	lower := strings.ToLower(line)
+
+	switch {
+	case strings.HasPrefix(source, "hooks.go") || strings.Contains(lower, "hook"):
+		return "hook", line
+
+	case strings.HasPrefix(source, "ready.go") || strings.HasPrefix(source, "wait.go") || strings.Contains(lower, "ready") || strings.Contains(lower, "resource"):
+		return "resource", line
+	}
+
+	return "helm", line
+}
+
+// handleInstallHelmRelease installs or upgrades a release from a chart of a
+// configured repository or an OCI registry, rendered with the values of the
+// request. Hook and resource progress is streamed as server-sent events,
+// followed by a done event with the result. With ?dryRun=true the chart is
+// only rendered and validated by the API server.
+func (s *Server) handleInstallHelmRelease(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+
+	var req HelmInstallRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Release == "" || req.Chart == "" {
+		http.Error(w, "release and chart are required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Namespace == "" {
+		req.Namespace = "default"
+	}
+
+	timeout := 10 * time.Minute
+
+	if req.Timeout != "" {
+		d, err := time.ParseDuration(req.Timeout)
+
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid timeout", http.StatusBadRequest)
+			return
+		}
+
+		timeout = d
+	}
+
+	if err := validateHelmInstallRequest(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	chart, repo, err := s.helmChart(&req)
+
+	if err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := exec.LookPath("helm"); err != nil {
+		writeError(w, r, errHelmNotInstalled, http.StatusNotImplemented)
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	if !dryRun {
+		if err := s.checkProtection(r, name, req.Namespace); err != nil {
+			writeProtectionError(w, r, err)
+			return
+		}
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	current, err := latestHelmRelease(r.Context(), client, req.Namespace, req.Release)
+
+	if err != nil && !errors.Is(err, errReleaseNotFound) {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := &HelmInstallResult{
+		Release:   req.Release,
+		Namespace: req.Namespace,
+
+		Upgrade: current != nil,
+		DryRun:  dryRun,
+	}
+
+	dir, err := os.MkdirTemp("", "bridge-helm-values-")
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	values := filepath.Join(dir, "values.json")
+
+	data, _ := json.Marshal(req.Values)
+
+	if req.Values == nil {
+		data = []byte("{}")
+	}
+
+	if err := os.WriteFile(values, data, 0600); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	args := append([]string{"upgrade"}, repo...)
+
+	args = append(args,
+		"--install",
+		"--namespace", req.Namespace,
+		"--values", values,
+		"--output", "json",
+		"--debug",
+	)
+
+	if req.Version != "" {
+		args = append(args, "--version", req.Version)
+	}
+
+	if req.CreateNamespace {
+		args = append(args, "--create-namespace")
+	}
+
+	if dryRun {
+		args = append(args, "--dry-run=server")
+	} else if req.Wait == nil || *req.Wait {
+		args = append(args, "--wait", "--timeout", timeout.String())
+	}
+
+	// release and chart are never parsed as flags
+	args = append(args, "--", req.Release, chart)
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	var mu sync.Mutex
+
+	send := func(event string, v any) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		data, _ := json.Marshal(v)
+
+		w.Write([]byte("event: " + event + "\ndata: "))
+		w.Write(data)
+		w.Write([]byte("\n\n"))
+
+		rc.Flush()
+	}
+
+	// the cause of a failure is the last line of helm
+	var failure string
+
+	progress := &progressWriter{
+		send: func(line string) {
+			if message, ok := strings.CutPrefix(line, "Error: "); ok {
+				failure = message
+			}
+
+			phase, message := helmProgressPhase(line)
+			send("progress", &HelmProgress{Release: req.Release, Phase: phase, Message: message})
+		},
+	}
+
+	var stdout bytes.Buffer
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout+time.Minute)
+	defer cancel()
+
+	err = s.runHelm(ctx, r, name, &stdout, progress, args...)
+	progress.Close()
+
+	if !dryRun {
+		action := "helm-install"
+
+		if result.Upgrade {
+			action = "helm-upgrade"
+		}
+
+		entry := &AuditEntry{
+			Context: name,
+			Owner:   ownerID(auth),
+			Action:  action,
+
+			Resource:  "helmreleases",
+			Namespace: req.Namespace,
+			Name:      req.Release,
+		}
+
+		if err != nil {
+			entry.Error = cmp.Or(failure, err.Error())
+		}
+
+		s.audit.record(entry)
+	}
+
+	if err != nil {
+		result.Error = cmp.Or(failure, err.Error())
+
+		send("done", result)
+		return
+	}
+
+	var release helmRelease
+
+	if err := json.Unmarshal(stdout.Bytes(), &release); err == nil {
+		result.Chart = release.Chart.Metadata.Name
+		result.Version = release.Chart.Metadata.Version
+		result.AppVersion = release.Chart.Metadata.AppVersion
+
+		result.Revision = release.Version
+		result.Status = release.Info.Status
+		result.Notes = release.Info.Notes
+
+		if dryRun {
+			result.Manifest = release.Manifest
+		}
+	}
+
+	send("done", result)
+}
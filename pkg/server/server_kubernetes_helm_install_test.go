@@ -0,0 +1,31 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateHelmInstallRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		req   HelmInstallRequest
+		valid bool
+	}{
+		{"release", HelmInstallRequest{Release: "ingress-nginx", Namespace: "ingress"}, true},
+		{"dotted release", HelmInstallRequest{Release: "web.v2", Namespace: "default"}, true},
+		{"flag", HelmInstallRequest{Release: "--post-renderer=/bin/sh", Namespace: "default"}, false},
+		{"short flag", HelmInstallRequest{Release: "-f", Namespace: "default"}, false},
+		{"uppercase", HelmInstallRequest{Release: "Web", Namespace: "default"}, false},
+		{"long release", HelmInstallRequest{Release: strings.Repeat("a", 54), Namespace: "default"}, false},
+		{"empty release", HelmInstallRequest{Namespace: "default"}, false},
+		{"namespace flag", HelmInstallRequest{Release: "web", Namespace: "--kube-token=x"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateHelmInstallRequest(&tt.req); (err == nil) != tt.valid {
+				t.Fatalf("error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,772 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+const (
+	interceptLabel      = "bridge.intercept"
+	interceptAnnotation = "bridge/intercept-selector"
+
+	interceptImage   = "alpine:3.22"
+	interceptSSHPort = 2222
+
+	// interceptReconnectTimeout bounds reconnects of a broken tunnel
+	interceptReconnectTimeout = 2 * time.Minute
+)
+
+// interceptScript starts an sshd accepting the generated key, which allows
+// the bridge to listen on the service port of the agent through a remote
+// port forward.
+const interceptScript = `set -e
+apk add --no-cache openssh-server >/dev/null
+ssh-keygen -A >/dev/null
+mkdir -p /root/.ssh
+echo "$AUTHORIZED_KEY" > /root/.ssh/authorized_keys
+chmod 700 /root/.ssh && chmod 600 /root/.ssh/authorized_keys
+exec /usr/sbin/sshd -D -e -p 2222 -o AllowTcpForwarding=yes -o GatewayPorts=yes -o PasswordAuthentication=no -o PermitRootLogin=prohibit-password`
+
+// intercepts holds the active traffic interceptions.
+type intercepts struct {
+	mu    sync.Mutex
+	items map[string]*intercept
+
+	// saved intercepts, which changed a cluster and were not closed yet
+	saved map[string]*savedIntercept
+}
+
+func (i *intercepts) add(item *intercept) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.items == nil {
+		i.items = make(map[string]*intercept)
+	}
+
+	i.items[item.id] = item
+}
+
+func (i *intercepts) remove(id string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	delete(i.items, id)
+}
+
+func (i *intercepts) get(id string) (*intercept, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	item, ok := i.items[id]
+	return item, ok
+}
+
+func (i *intercepts) list() []*intercept {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	result := make([]*intercept, 0, len(i.items))
+
+	for _, item := range i.items {
+		result = append(result, item)
+	}
+
+	return result
+}
+
+// intercept redirects a service to an agent pod, whose service port is
+// forwarded to a local address through ssh over a port-forward session.
+type intercept struct {
+	id    string
+	owner string
+
+	context   string
+	namespace string
+	service   string
+	pod       string
+
+	port  int
+	local string
+
+	created time.Time
+
+	connections atomic.Int64
+
+	client *kubernetesClient
+
+	// dial opens the tunnel to the agent, again after it broke
+	dial func(ctx context.Context) (*portForwardDialer, *ssh.Client, error)
+
+	mu      sync.Mutex
+	closed  bool
+	forward *portForwardDialer
+	ssh     *ssh.Client
+
+	// selector of the service before the interception
+	selector map[string]string
+
+	release   func()
+	closeOnce sync.Once
+	onClose   func()
+
+	// onRestored is called once the service and agent were cleaned up
+	onRestored func()
+}
+
+func (i *intercept) info() InterceptInfo {
+	return InterceptInfo{
+		ID: i.id,
+
+		Context:   i.context,
+		Namespace: i.namespace,
+		Service:   i.service,
+		Pod:       i.pod,
+
+		Port:         i.port,
+		LocalAddress: i.local,
+
+		Connections: i.connections.Load(),
+		Created:     i.created,
+	}
+}
+
+// Close stops forwarding, restores the service selector and removes the agent.
+func (i *intercept) Close() error {
+	var err error
+
+	i.closeOnce.Do(func() {
+		if i.onClose != nil {
+			i.onClose()
+		}
+
+		i.mu.Lock()
+		i.closed = true
+		i.mu.Unlock()
+
+		i.closeTunnel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if i.selector != nil {
+			err = restoreServiceSelector(ctx, i.client, i.namespace, i.service, i.id, i.selector)
+		}
+
+		deleteErr := i.client.delete(ctx, "/api/v1/namespaces/"+i.namespace+"/pods/"+i.pod, nil)
+
+		if deleteErr != nil && statusCode(deleteErr) == http.StatusNotFound {
+			deleteErr = nil
+		}
+
+		// otherwise the service is restored with the next restore of stale intercepts
+		if err == nil && deleteErr == nil && i.onRestored != nil {
+			i.onRestored()
+		}
+	})
+
+	return err
+}
+
+// connect opens the tunnel to the agent.
+func (i *intercept) connect(ctx context.Context) error {
+	forward, client, err := i.dial(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.closed {
+		client.Close()
+		forward.Close()
+
+		return errors.New("intercept closed")
+	}
+
+	i.forward = forward
+	i.ssh = client
+
+	return nil
+}
+
+func (i *intercept) closeTunnel() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.ssh != nil {
+		i.ssh.Close()
+	}
+
+	if i.forward != nil {
+		i.forward.Close()
+	}
+}
+
+// reconnect replaces a broken tunnel, retrying until the agent is reachable
+// again or the timeout passed.
+func (i *intercept) reconnect() error {
+	ctx, cancel := context.WithTimeout(context.Background(), interceptReconnectTimeout)
+	defer cancel()
+
+	backoff := time.Second
+
+	for {
+		i.closeTunnel()
+
+		err := i.connect(ctx)
+
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, 15*time.Second)
+	}
+}
+
+// serve forwards connections of the agent to the local address. A broken
+// tunnel (e.g. after a sleep) is reconnected; the intercept is released if
+// the agent stays unreachable.
+func (i *intercept) serve() {
+	for {
+		if err := i.accept(); err != nil {
+			log.Printf("intercept %s: failed to listen on agent: %v", i.id, err)
+			i.release()
+			return
+		}
+
+		i.mu.Lock()
+		closed := i.closed
+		i.mu.Unlock()
+
+		if closed {
+			return
+		}
+
+		log.Printf("intercept %s: tunnel lost, reconnecting", i.id)
+
+		if err := i.reconnect(); err != nil {
+			log.Printf("intercept %s: failed to reconnect: %v", i.id, err)
+			i.release()
+			return
+		}
+	}
+}
+
+// accept serves connections until the tunnel ends.
+func (i *intercept) accept() error {
+	i.mu.Lock()
+	client := i.ssh
+	i.mu.Unlock()
+
+	l, err := client.Listen("tcp", net.JoinHostPort("0.0.0.0", strconv.Itoa(i.port)))
+
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		// the tunnel ends if the agent pod is deleted or the connection breaks
+		client.Wait()
+		l.Close()
+	}()
+
+	for {
+		remote, err := l.Accept()
+
+		if err != nil {
+			return nil
+		}
+
+		i.connections.Add(1)
+
+		go func() {
+			defer remote.Close()
+
+			local, err := net.DialTimeout("tcp", i.local, 10*time.Second)
+
+			if err != nil {
+				log.Printf("intercept %s: failed to connect to %s: %v", i.id, i.local, err)
+				return
+			}
+
+			defer local.Close()
+
+			go io.Copy(local, remote)
+			io.Copy(remote, local)
+		}()
+	}
+}
+
+// handleCreateIntercept redirects the traffic of a service to a local
+// address. While intercepted, all ports of the service route to the agent,
+// only the selected port is forwarded.
+func (s *Server) handleCreateIntercept(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+
+	var req InterceptRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Namespace == "" || req.Service == "" || req.LocalAddress == "" {
+		http.Error(w, "namespace, service and localAddress are required", http.StatusBadRequest)
+		return
+	}
+
+	if _, _, err := net.SplitHostPort(req.LocalAddress); err != nil {
+		http.Error(w, "invalid localAddress: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.checkProtection(r, name, req.Namespace); err != nil {
+		writeProtectionError(w, r, err)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	var service corev1.Service
+
+	if err := client.get(r.Context(), "/api/v1/namespaces/"+req.Namespace+"/services/"+req.Service, nil, &service); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	if _, ok := service.Annotations[interceptAnnotation]; ok {
+		restored, err := s.takeOverIntercept(r.Context(), client, name, ownerID(auth), &service)
+
+		if err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+
+		if !restored {
+			http.Error(w, "service is already intercepted", http.StatusConflict)
+			return
+		}
+
+		if err := client.get(r.Context(), "/api/v1/namespaces/"+req.Namespace+"/services/"+req.Service, nil, &service); err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+	}
+
+	if len(service.Spec.Selector) == 0 {
+		http.Error(w, "service has no selector", http.StatusBadRequest)
+		return
+	}
+
+	servicePort, ok := findServicePort(service.Spec.Ports, req.Port)
+
+	if !ok {
+		http.Error(w, "service port not found", http.StatusBadRequest)
+		return
+	}
+
+	id := make([]byte, 4)
+	rand.Read(id)
+
+	item := &intercept{
+		id:    hex.EncodeToString(id),
+		owner: ownerID(auth),
+
+		context:   name,
+		namespace: req.Namespace,
+		service:   req.Service,
+
+		local:   req.LocalAddress,
+		created: time.Now(),
+
+		client: client,
+	}
+
+	item.pod = "bridge-intercept-" + item.id
+
+	item.onRestored = func() {
+		s.intercepts.unsave(item.id)
+	}
+
+	// saved before changing the cluster, so the service is restored even if
+	// the bridge stops before closing the intercept
+	s.saveIntercept(item, auth)
+
+	if err := s.startIntercept(r.Context(), item, auth, servicePort); err != nil {
+		item.Close()
+
+		s.audit.record(&AuditEntry{
+			Context: name,
+			Owner:   item.owner,
+			Action:  "intercept",
+
+			Resource:  "services",
+			Namespace: req.Namespace,
+			Name:      req.Service,
+
+			Error: err.Error(),
+		})
+
+		writeClientError(w, r, err)
+		return
+	}
+
+	item.onClose = func() {
+		s.intercepts.remove(item.id)
+	}
+
+	item.release = s.track(name, item)
+	s.intercepts.add(item)
+
+	go item.serve()
+
+	s.audit.record(&AuditEntry{
+		Context: name,
+		Owner:   item.owner,
+		Action:  "intercept",
+
+		Resource:  "services",
+		Namespace: req.Namespace,
+		Name:      req.Service,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	json.NewEncoder(w).Encode(item.info())
+}
+
+func (s *Server) startIntercept(ctx context.Context, item *intercept, auth *config.AuthInfo, servicePort corev1.ServicePort) error {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		return err
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+
+	if err != nil {
+		return err
+	}
+
+	// the agent listens on the target port, as the service forwards to it
+	port := servicePort.TargetPort.IntValue()
+
+	if servicePort.TargetPort.Type == intstr.String || port == 0 {
+		port = int(servicePort.Port)
+	}
+
+	item.port = port
+
+	containerPort := corev1.ContainerPort{
+		ContainerPort: int32(port),
+		Protocol:      corev1.ProtocolTCP,
+	}
+
+	if servicePort.TargetPort.Type == intstr.String {
+		containerPort.Name = servicePort.TargetPort.StrVal
+	}
+
+	runAsUser := int64(0)
+
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Pod",
+		},
+
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      item.pod,
+			Namespace: item.namespace,
+
+			Labels: map[string]string{
+				interceptLabel:                 item.id,
+				"app.kubernetes.io/managed-by": "bridge",
+			},
+		},
+
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+
+			Containers: []corev1.Container{
+				{
+					Name:  "agent",
+					Image: interceptImage,
+
+					Command: []string{"sh", "-c", interceptScript},
+
+					Env: []corev1.EnvVar{
+						{Name: "AUTHORIZED_KEY", Value: string(ssh.MarshalAuthorizedKey(signer.PublicKey()))},
+					},
+
+					Ports: []corev1.ContainerPort{containerPort},
+
+					ReadinessProbe: &corev1.Probe{
+						ProbeHandler: corev1.ProbeHandler{
+							TCPSocket: &corev1.TCPSocketAction{
+								Port: intstr.FromInt(interceptSSHPort),
+							},
+						},
+
+						PeriodSeconds: 1,
+					},
+
+					SecurityContext: &corev1.SecurityContext{
+						RunAsUser: &runAsUser,
+					},
+				},
+			},
+		},
+	}
+
+	if err := item.client.create(ctx, "/api/v1/namespaces/"+item.namespace+"/pods", pod, nil); err != nil {
+		return err
+	}
+
+	if err := waitPodReady(ctx, item.client, item.namespace, item.pod, 3*time.Minute); err != nil {
+		return err
+	}
+
+	item.dial = func(ctx context.Context) (*portForwardDialer, *ssh.Client, error) {
+		return s.dialIntercept(ctx, item, auth, signer)
+	}
+
+	if err := item.connect(ctx); err != nil {
+		return err
+	}
+
+	return redirectServiceSelector(ctx, item)
+}
+
+// dialIntercept opens an ssh connection to the agent over a port-forward.
+func (s *Server) dialIntercept(ctx context.Context, item *intercept, auth *config.AuthInfo, signer ssh.Signer) (*portForwardDialer, *ssh.Client, error) {
+	forward, err := s.portForward(ctx, item.context, auth, item.namespace, item.pod, interceptSSHPort)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := forward.Dial()
+
+	if err != nil {
+		forward.Close()
+		return nil, nil, err
+	}
+
+	sshConfig := &ssh.ClientConfig{
+		User: "root",
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+
+		// the agent is ephemeral and reached through the authenticated API server
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+
+		Timeout: 30 * time.Second,
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, item.pod, sshConfig)
+
+	if err != nil {
+		conn.Close()
+		forward.Close()
+
+		return nil, nil, err
+	}
+
+	return forward, ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// redirectServiceSelector points the service to the agent pod and stores
+// the original selector in an annotation, so it can be restored even if the
+// bridge stops unexpectedly.
+func redirectServiceSelector(ctx context.Context, item *intercept) error {
+	var service corev1.Service
+
+	if err := item.client.get(ctx, "/api/v1/namespaces/"+item.namespace+"/services/"+item.service, nil, &service); err != nil {
+		return err
+	}
+
+	original, err := json.Marshal(service.Spec.Selector)
+
+	if err != nil {
+		return err
+	}
+
+	selector := map[string]any{
+		interceptLabel: item.id,
+	}
+
+	for key := range service.Spec.Selector {
+		selector[key] = nil
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]any{
+				interceptAnnotation: string(original),
+			},
+		},
+		"spec": map[string]any{
+			"selector": selector,
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if err := item.client.patch(ctx, "/api/v1/namespaces/"+item.namespace+"/services/"+item.service, nil, "application/merge-patch+json", patch, nil); err != nil {
+		return err
+	}
+
+	item.selector = service.Spec.Selector
+
+	return nil
+}
+
+func restoreServiceSelector(ctx context.Context, client *kubernetesClient, namespace, service, id string, original map[string]string) error {
+	selector := map[string]any{
+		interceptLabel: nil,
+	}
+
+	for key, value := range original {
+		selector[key] = value
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]any{
+				interceptAnnotation: nil,
+			},
+		},
+		"spec": map[string]any{
+			"selector": selector,
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return client.patch(ctx, "/api/v1/namespaces/"+namespace+"/services/"+service, nil, "application/merge-patch+json", patch, nil)
+}
+
+func findServicePort(ports []corev1.ServicePort, port string) (corev1.ServicePort, bool) {
+	if port == "" && len(ports) == 1 {
+		return ports[0], true
+	}
+
+	for _, p := range ports {
+		if p.Name == port || strconv.Itoa(int(p.Port)) == port {
+			return p, true
+		}
+	}
+
+	return corev1.ServicePort{}, false
+}
+
+// waitPodReady polls a pod until it is ready.
+func waitPodReady(ctx context.Context, client *kubernetesClient, namespace, name string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		var pod corev1.Pod
+
+		if err := client.get(ctx, "/api/v1/namespaces/"+namespace+"/pods/"+name, nil, &pod); err != nil {
+			return err
+		}
+
+		if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+			return fmt.Errorf("pod %s terminated", name)
+		}
+
+		ready := slices.ContainsFunc(pod.Status.Conditions, func(c corev1.PodCondition) bool {
+			return c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue
+		})
+
+		if ready {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.New("timed out waiting for pod " + name)
+
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (s *Server) handleListIntercepts(w http.ResponseWriter, r *http.Request) {
+	owner := ownerID(AuthInfoFromContext(r.Context()))
+
+	result := []InterceptInfo{}
+
+	for _, item := range s.intercepts.list() {
+		if item.owner != owner {
+			continue
+		}
+
+		result = append(result, item.info())
+	}
+
+	slices.SortFunc(result, func(a, b InterceptInfo) int {
+		return a.Created.Compare(b.Created)
+	})
+
+	writeList(w, r, "intercepts", result, result)
+}
+
+func (s *Server) handleDeleteIntercept(w http.ResponseWriter, r *http.Request) {
+	owner := ownerID(AuthInfoFromContext(r.Context()))
+
+	item, ok := s.intercepts.get(r.PathValue("id"))
+
+	if !ok || item.owner != owner {
+		http.Error(w, "intercept not found", http.StatusNotFound)
+		return
+	}
+
+	item.release()
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+const (
+	// interceptsState keeps the intercepts which changed a cluster, so their
+	// services are restored even if the bridge stopped without closing them
+	interceptsState = "intercepts"
+
+	// interceptRestoreInterval is the interval in which services of
+	// intercepts that were not closed are restored
+	interceptRestoreInterval = 30 * time.Second
+)
+
+// savedIntercept is an intercept kept in the store while its agent and
+// service selector are in the cluster. Intercepts not running after a start
+// are stale; their service is restored and their agent removed.
+type savedIntercept struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner,omitempty"`
+
+	Context   string `json:"context"`
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	Pod       string `json:"pod"`
+
+	// the caller and impersonation the intercept was created with,
+	// without credentials
+	User              string   `json:"user,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+	ImpersonateUser   string   `json:"impersonateUser,omitempty"`
+	ImpersonateGroups []string `json:"impersonateGroups,omitempty"`
+
+	Created time.Time `json:"created"`
+}
+
+func (i *savedIntercept) auth() *config.AuthInfo {
+	if i.User == "" && i.ImpersonateUser == "" {
+		return nil
+	}
+
+	return &config.AuthInfo{
+		User:   i.User,
+		Groups: i.Groups,
+
+		ImpersonateUser:   i.ImpersonateUser,
+		ImpersonateGroups: i.ImpersonateGroups,
+	}
+}
+
+// loadSaved reads the saved intercepts from the store. None of them runs
+// yet, so all are stale.
+func (i *intercepts) loadSaved() {
+	var items []*savedIntercept
+
+	if err := loadState(interceptsState, &items); err != nil {
+		log.Printf("failed to load intercepts: %v", err)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.saved = make(map[string]*savedIntercept)
+
+	for _, item := range items {
+		i.saved[item.ID] = item
+	}
+}
+
+// persist writes the saved intercepts to the store; i.mu must be held.
+func (i *intercepts) persist() {
+	items := make([]*savedIntercept, 0, len(i.saved))
+
+	for _, item := range i.saved {
+		items = append(items, item)
+	}
+
+	slices.SortFunc(items, func(a, b *savedIntercept) int {
+		return a.Created.Compare(b.Created)
+	})
+
+	if err := saveState(interceptsState, items); err != nil {
+		log.Printf("failed to save intercepts: %v", err)
+	}
+}
+
+func (i *intercepts) save(item *savedIntercept) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.saved == nil {
+		i.saved = make(map[string]*savedIntercept)
+	}
+
+	i.saved[item.ID] = item
+	i.persist()
+}
+
+func (i *intercepts) unsave(id string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.saved[id]; !ok {
+		return
+	}
+
+	delete(i.saved, id)
+	i.persist()
+}
+
+// stale returns the saved intercepts that are not running.
+func (i *intercepts) stale() []savedIntercept {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var result []savedIntercept
+
+	for id, item := range i.saved {
+		if _, ok := i.items[id]; ok {
+			continue
+		}
+
+		result = append(result, *item)
+	}
+
+	slices.SortFunc(result, func(a, b savedIntercept) int {
+		return a.Created.Compare(b.Created)
+	})
+
+	return result
+}
+
+func (i *intercepts) staleItem(id string) (savedIntercept, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	item, ok := i.saved[id]
+
+	if !ok {
+		return savedIntercept{}, false
+	}
+
+	if _, running := i.items[id]; running {
+		return savedIntercept{}, false
+	}
+
+	return *item, true
+}
+
+// saveIntercept keeps an intercept in the store until it is closed.
+func (s *Server) saveIntercept(item *intercept, auth *config.AuthInfo) {
+	saved := &savedIntercept{
+		ID:    item.id,
+		Owner: item.owner,
+
+		Context:   item.context,
+		Namespace: item.namespace,
+		Service:   item.service,
+		Pod:       item.pod,
+
+		Created: item.created,
+	}
+
+	if auth != nil {
+		saved.User = auth.User
+		saved.Groups = auth.Groups
+
+		saved.ImpersonateUser = auth.ImpersonateUser
+		saved.ImpersonateGroups = auth.ImpersonateGroups
+	}
+
+	s.intercepts.save(saved)
+}
+
+// restoreIntercepts restores the services of intercepts the bridge did not
+// close (e.g. after a crash) and removes their agents, after a start and
+// once their context is back.
+func (s *Server) restoreIntercepts(done <-chan struct{}) {
+	ticker := time.NewTicker(interceptRestoreInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, saved := range s.intercepts.stale() {
+			c, ok := s.kubernetesContext(saved.Context)
+
+			if !ok || s.connectivity.offline(c.Name) {
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+			client, err := s.kubernetesClient(ctx, c.Name, saved.auth())
+
+			if err == nil {
+				err = restoreIntercept(ctx, client, saved)
+			}
+
+			cancel()
+
+			if err != nil {
+				log.Printf("intercept %s: failed to restore service %s/%s: %v", saved.ID, saved.Namespace, saved.Service, err)
+				continue
+			}
+
+			log.Printf("intercept %s: restored service %s/%s", saved.ID, saved.Namespace, saved.Service)
+
+			s.intercepts.unsave(saved.ID)
+		}
+
+		select {
+		case <-done:
+			return
+
+		case <-ticker.C:
+		}
+	}
+}
+
+// restoreIntercept restores the selector of a service still pointing to the
+// agent of an intercept and removes the agent.
+func restoreIntercept(ctx context.Context, client *kubernetesClient, saved savedIntercept) error {
+	var service corev1.Service
+
+	err := client.get(ctx, "/api/v1/namespaces/"+saved.Namespace+"/services/"+saved.Service, nil, &service)
+
+	if err != nil && statusCode(err) != http.StatusNotFound {
+		return err
+	}
+
+	// the service may have been restored or intercepted again since
+	if value, ok := service.Annotations[interceptAnnotation]; ok && service.Spec.Selector[interceptLabel] == saved.ID {
+		var original map[string]string
+
+		if err := json.Unmarshal([]byte(value), &original); err != nil {
+			return err
+		}
+
+		if err := restoreServiceSelector(ctx, client, saved.Namespace, saved.Service, saved.ID, original); err != nil {
+			return err
+		}
+	}
+
+	if err := client.delete(ctx, "/api/v1/namespaces/"+saved.Namespace+"/pods/"+saved.Pod, nil); err != nil && statusCode(err) != http.StatusNotFound {
+		return err
+	}
+
+	return nil
+}
+
+// takeOverIntercept restores a service intercepted by an intercept that is
+// no longer running, so it can be intercepted again: a stale intercept of
+// the same owner, or one whose agent is gone. It reports whether the
+// service was restored.
+func (s *Server) takeOverIntercept(ctx context.Context, client *kubernetesClient, name, owner string, service *corev1.Service) (bool, error) {
+	id := service.Spec.Selector[interceptLabel]
+
+	if id == "" {
+		return false, nil
+	}
+
+	if _, running := s.intercepts.get(id); running {
+		return false, nil
+	}
+
+	saved, stale := s.intercepts.staleItem(id)
+
+	owned := stale && saved.Owner == owner && strings.EqualFold(saved.Context, name) && saved.Namespace == service.Namespace && saved.Service == service.Name
+
+	if !owned {
+		saved = savedIntercept{
+			ID: id,
+
+			Context:   name,
+			Namespace: service.Namespace,
+			Service:   service.Name,
+			Pod:       "bridge-intercept-" + id,
+		}
+
+		// intercepts of others are only taken over without their agent
+		if err := client.get(ctx, "/api/v1/namespaces/"+saved.Namespace+"/pods/"+saved.Pod, nil, nil); statusCode(err) != http.StatusNotFound {
+			return false, nil
+		}
+	}
+
+	if err := restoreIntercept(ctx, client, saved); err != nil {
+		return false, err
+	}
+
+	s.intercepts.unsave(id)
+
+	return true, nil
+}
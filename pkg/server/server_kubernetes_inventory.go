@@ -0,0 +1,458 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+
+	"golang.org/x/text/language"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// inventoryWorkloads are counted per cluster; those with a pod template are
+// also checked for policy findings.
+var inventoryWorkloads = []struct {
+	Path string
+	Kind string
+}{
+	{"/apis/apps/v1/deployments", "Deployment"},
+	{"/apis/apps/v1/statefulsets", "StatefulSet"},
+	{"/apis/apps/v1/daemonsets", "DaemonSet"},
+	{"/apis/batch/v1/cronjobs", "CronJob"},
+	{"/apis/batch/v1/jobs", "Job"},
+}
+
+// handleInventoryReport returns a consolidated report of the clusters of
+// ?contexts (default all) as JSON, or as a downloadable document with
+// ?format=markdown or ?format=html.
+func (s *Server) handleInventoryReport(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	var contexts []string
+
+	for _, v := range r.URL.Query()["contexts"] {
+		contexts = append(contexts, splitNames(v)...)
+	}
+
+	if len(contexts) == 0 {
+		contexts = s.kubernetesContextNames()
+	}
+
+	format := r.URL.Query().Get("format")
+
+	if format != "" && format != "json" && format != "markdown" && format != "html" {
+		http.Error(w, "format must be json, markdown or html", http.StatusBadRequest)
+		return
+	}
+
+	tag := i18n.Negotiate(r)
+
+	report := &InventoryReport{
+		Generated: time.Now().UTC(),
+		Clusters:  make([]InventoryCluster, len(contexts)),
+	}
+
+	var wg sync.WaitGroup
+
+	for i, name := range contexts {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			report.Clusters[i] = s.inventoryCluster(r.Context(), name, auth, tag)
+		}()
+	}
+
+	wg.Wait()
+
+	switch format {
+	case "markdown":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="inventory.md"`)
+		w.Header().Set("Content-Language", tag.String())
+
+		writeInventoryMarkdown(w, report, tag)
+
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="inventory.html"`)
+		w.Header().Set("Content-Language", tag.String())
+
+		template.Must(inventoryTemplate.Clone()).Funcs(template.FuncMap{
+			"t": func(id string, args ...any) string {
+				return i18n.Translate(tag, id, args...)
+			},
+		}).Execute(w, report)
+
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// inventoryCluster collects the inventory of a context. Parts the caller
+// cannot read are reported as errors without failing the cluster.
+func (s *Server) inventoryCluster(ctx context.Context, name string, auth *config.AuthInfo, tag language.Tag) InventoryCluster {
+	result := InventoryCluster{
+		Context: name,
+
+		Workloads:    map[string]int{},
+		Images:       []InventoryImage{},
+		Certificates: []InventoryCertificate{},
+		Findings:     []InventoryFinding{},
+		Issues:       []AnalysisIssue{},
+		Errors:       []string{},
+	}
+
+	client, err := s.kubernetesClient(ctx, name, auth)
+
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result
+	}
+
+	failed := func(what string, err error) bool {
+		if err == nil {
+			return false
+		}
+
+		result.Errors = append(result.Errors, what+": "+err.Error())
+		return true
+	}
+
+	var version struct {
+		GitVersion string `json:"gitVersion"`
+		Platform   string `json:"platform"`
+	}
+
+	if !failed("version", client.get(ctx, "/version", nil, &version)) {
+		result.Version = version.GitVersion
+		result.Platform = version.Platform
+	}
+
+	var nodes corev1.NodeList
+
+	if !failed("nodes", client.get(ctx, "/api/v1/nodes", nil, &nodes)) {
+		kubelets := map[string]bool{}
+
+		for _, n := range nodes.Items {
+			result.Nodes++
+
+			for _, c := range n.Status.Conditions {
+				if c.Type == corev1.NodeReady && c.Status == corev1.ConditionTrue {
+					result.NodesReady++
+				}
+			}
+
+			kubelets[n.Status.NodeInfo.KubeletVersion] = true
+		}
+
+		result.KubeletVersions = slices.Sorted(maps.Keys(kubelets))
+	}
+
+	var namespaces metav1.PartialObjectMetadataList
+
+	if !failed("namespaces", client.getMetadata(ctx, "/api/v1/namespaces", nil, &namespaces)) {
+		result.Namespaces = len(namespaces.Items)
+	}
+
+	findings := map[[2]string]int{}
+
+	for _, res := range inventoryWorkloads {
+		var list struct {
+			Items []map[string]any `json:"items"`
+		}
+
+		if failed(res.Kind, client.get(ctx, res.Path, nil, &list)) {
+			continue
+		}
+
+		result.Workloads[res.Kind] = len(list.Items)
+
+		if res.Kind == "Job" {
+			continue
+		}
+
+		for _, item := range list.Items {
+			item["kind"] = res.Kind
+
+			workload, ok := parseWorkload(manifestDocument{Object: item})
+
+			if !ok {
+				continue
+			}
+
+			for _, issue := range checkWorkload(workload, tag) {
+				findings[[2]string{issue.Rule, issue.Severity}]++
+
+				if issue.Severity == "error" {
+					result.Issues = append(result.Issues, issue)
+				}
+			}
+		}
+	}
+
+	for key, count := range findings {
+		result.Findings = append(result.Findings, InventoryFinding{
+			Rule:     key[0],
+			Severity: key[1],
+			Count:    count,
+		})
+	}
+
+	slices.SortFunc(result.Findings, func(a, b InventoryFinding) int {
+		return strings.Compare(a.Rule, b.Rule)
+	})
+
+	var pods struct {
+		Items []map[string]any `json:"items"`
+	}
+
+	if !failed("pods", client.get(ctx, "/api/v1/pods", nil, &pods)) {
+		images := map[string]int{}
+
+		for _, item := range pods.Items {
+			for _, image := range objectImages("Pod", item) {
+				images[image]++
+			}
+		}
+
+		for image, count := range images {
+			result.Images = append(result.Images, InventoryImage{
+				Image: image,
+				Pods:  count,
+			})
+		}
+
+		slices.SortFunc(result.Images, func(a, b InventoryImage) int {
+			return strings.Compare(a.Image, b.Image)
+		})
+	}
+
+	result.Certificates = inventoryCertificates(ctx, client, failed)
+
+	return result
+}
+
+// inventoryCertificates lists cert-manager certificates and the TLS secrets
+// of ingresses not managed by cert-manager.
+func inventoryCertificates(ctx context.Context, client *kubernetesClient, failed func(string, error) bool) []InventoryCertificate {
+	result := []InventoryCertificate{}
+
+	var certificates struct {
+		Items []struct {
+			Metadata metav1.ObjectMeta `json:"metadata"`
+
+			Spec struct {
+				SecretName string   `json:"secretName"`
+				DNSNames   []string `json:"dnsNames"`
+			} `json:"spec"`
+
+			Status struct {
+				NotAfter   *time.Time         `json:"notAfter"`
+				Conditions []metav1.Condition `json:"conditions"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+
+	managed := map[string]bool{}
+
+	if err := client.get(ctx, "/apis/cert-manager.io/v1/certificates", nil, &certificates); statusCode(err) != http.StatusNotFound {
+		failed("certificates", err)
+	}
+
+	for _, c := range certificates.Items {
+		managed[c.Metadata.Namespace+"/"+c.Spec.SecretName] = true
+
+		cert := InventoryCertificate{
+			Source: "cert-manager",
+
+			Namespace: c.Metadata.Namespace,
+			Name:      c.Metadata.Name,
+			Secret:    c.Spec.SecretName,
+
+			DNSNames: c.Spec.DNSNames,
+			NotAfter: c.Status.NotAfter,
+		}
+
+		for _, cond := range c.Status.Conditions {
+			if cond.Type == "Ready" {
+				ready := cond.Status == metav1.ConditionTrue
+				cert.Ready = &ready
+			}
+		}
+
+		result = append(result, cert)
+	}
+
+	var ingresses networkingv1.IngressList
+
+	if !failed("ingresses", client.get(ctx, "/apis/networking.k8s.io/v1/ingresses", nil, &ingresses)) {
+		for _, ing := range ingresses.Items {
+			for _, tls := range ing.Spec.TLS {
+				if tls.SecretName == "" || managed[ing.Namespace+"/"+tls.SecretName] {
+					continue
+				}
+
+				result = append(result, InventoryCertificate{
+					Source: "ingress",
+
+					Namespace: ing.Namespace,
+					Name:      ing.Name,
+					Secret:    tls.SecretName,
+
+					DNSNames: tls.Hosts,
+				})
+			}
+		}
+	}
+
+	slices.SortFunc(result, func(a, b InventoryCertificate) int {
+		return strings.Compare(a.Namespace+"/"+a.Name, b.Namespace+"/"+b.Name)
+	})
+
+	return result
+}
+
+func writeInventoryMarkdown(w io.Writer, report *InventoryReport, tag language.Tag) {
+	cell := func(s string) string {
+		return strings.ReplaceAll(s, "|", `\|`)
+	}
+
+	t := func(id string) string {
+		return i18n.Translate(tag, "report."+id)
+	}
+
+	fmt.Fprintf(w, "# %s\n\n%s\n", t("inventory"), i18n.Translate(tag, "report.generated", report.Generated.Format(time.RFC3339)))
+
+	for _, c := range report.Clusters {
+		fmt.Fprintf(w, "\n## %s\n\n", c.Context)
+
+		fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %s |\n", t("version"), t("platform"), t("nodes"), t("ready"), t("kubelets"), t("namespaces"))
+		fmt.Fprintf(w, "|---|---|---|---|---|---|\n")
+		fmt.Fprintf(w, "| %s | %s | %d | %d | %s | %d |\n", c.Version, c.Platform, c.Nodes, c.NodesReady, strings.Join(c.KubeletVersions, ", "), c.Namespaces)
+
+		if len(c.Errors) > 0 {
+			fmt.Fprintf(w, "\n### %s\n\n", t("errors"))
+
+			for _, e := range c.Errors {
+				fmt.Fprintf(w, "- %s\n", e)
+			}
+		}
+
+		fmt.Fprintf(w, "\n### %s\n\n| %s | %s |\n|---|---|\n", t("workloads"), t("kind"), t("count"))
+
+		for _, kind := range slices.Sorted(maps.Keys(c.Workloads)) {
+			fmt.Fprintf(w, "| %s | %d |\n", kind, c.Workloads[kind])
+		}
+
+		fmt.Fprintf(w, "\n### %s\n\n| %s | %s |\n|---|---|\n", t("images"), t("image"), t("pods"))
+
+		for _, i := range c.Images {
+			fmt.Fprintf(w, "| %s | %d |\n", cell(i.Image), i.Pods)
+		}
+
+		fmt.Fprintf(w, "\n### %s\n\n| %s | %s | %s | %s | %s | %s |\n|---|---|---|---|---|---|\n", t("certificates"), t("namespace"), t("name"), t("source"), t("dns_names"), t("expires"), t("ready"))
+
+		for _, cert := range c.Certificates {
+			expires, ready := "", ""
+
+			if cert.NotAfter != nil {
+				expires = cert.NotAfter.Format(time.DateOnly)
+			}
+
+			if cert.Ready != nil {
+				ready = fmt.Sprint(*cert.Ready)
+			}
+
+			fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %s |\n", cert.Namespace, cert.Name, cert.Source, cell(strings.Join(cert.DNSNames, ", ")), expires, ready)
+		}
+
+		fmt.Fprintf(w, "\n### %s\n\n| %s | %s | %s |\n|---|---|---|\n", t("policy_findings"), t("rule"), t("severity"), t("count"))
+
+		for _, f := range c.Findings {
+			fmt.Fprintf(w, "| %s | %s | %d |\n", f.Rule, f.Severity, f.Count)
+		}
+
+		if len(c.Issues) > 0 {
+			fmt.Fprintf(w, "\n#### %s\n\n", t("errors"))
+
+			for _, i := range c.Issues {
+				fmt.Fprintf(w, "- `%s` %s: %s\n", i.Rule, i.Object, i.Message)
+			}
+		}
+	}
+}
+
+var inventoryTemplate = template.Must(template.New("inventory").Funcs(template.FuncMap{
+	"join": strings.Join,
+
+	// t is replaced per request with the locale of the caller
+	"t": func(id string, args ...any) string {
+		return i18n.Translate(language.English, id, args...)
+	},
+	"sorted": func(m map[string]int) []string {
+		return slices.Sorted(maps.Keys(m))
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ t "report.inventory" }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>{{ t "report.inventory" }}</h1>
+<p>{{ t "report.generated" (.Generated.Format "2006-01-02T15:04:05Z07:00") }}</p>
+{{ range .Clusters }}
+<h2>{{ .Context }}</h2>
+<table>
+<tr><th>{{ t "report.version" }}</th><th>{{ t "report.platform" }}</th><th>{{ t "report.nodes" }}</th><th>{{ t "report.ready" }}</th><th>{{ t "report.kubelets" }}</th><th>{{ t "report.namespaces" }}</th></tr>
+<tr><td>{{ .Version }}</td><td>{{ .Platform }}</td><td>{{ .Nodes }}</td><td>{{ .NodesReady }}</td><td>{{ join .KubeletVersions ", " }}</td><td>{{ .Namespaces }}</td></tr>
+</table>
+{{ with .Errors }}<ul class="error">{{ range . }}<li>{{ . }}</li>{{ end }}</ul>{{ end }}
+<h3>{{ t "report.workloads" }}</h3>
+<table>
+<tr><th>{{ t "report.kind" }}</th><th>{{ t "report.count" }}</th></tr>
+{{ $workloads := .Workloads }}{{ range sorted .Workloads }}<tr><td>{{ . }}</td><td>{{ index $workloads . }}</td></tr>
+{{ end }}</table>
+<h3>{{ t "report.images" }}</h3>
+<table>
+<tr><th>{{ t "report.image" }}</th><th>{{ t "report.pods" }}</th></tr>
+{{ range .Images }}<tr><td>{{ .Image }}</td><td>{{ .Pods }}</td></tr>
+{{ end }}</table>
+<h3>{{ t "report.certificates" }}</h3>
+<table>
+<tr><th>{{ t "report.namespace" }}</th><th>{{ t "report.name" }}</th><th>{{ t "report.source" }}</th><th>{{ t "report.dns_names" }}</th><th>{{ t "report.expires" }}</th><th>{{ t "report.ready" }}</th></tr>
+{{ range .Certificates }}<tr><td>{{ .Namespace }}</td><td>{{ .Name }}</td><td>{{ .Source }}</td><td>{{ join .DNSNames ", " }}</td><td>{{ with .NotAfter }}{{ .Format "2006-01-02" }}{{ end }}</td><td>{{ with .Ready }}{{ . }}{{ end }}</td></tr>
+{{ end }}</table>
+<h3>{{ t "report.policy_findings" }}</h3>
+<table>
+<tr><th>{{ t "report.rule" }}</th><th>{{ t "report.severity" }}</th><th>{{ t "report.count" }}</th></tr>
+{{ range .Findings }}<tr><td>{{ .Rule }}</td><td>{{ .Severity }}</td><td>{{ .Count }}</td></tr>
+{{ end }}</table>
+{{ with .Issues }}<ul class="error">{{ range . }}<li><code>{{ .Rule }}</code> {{ .Object }}: {{ .Message }}</li>{{ end }}</ul>{{ end }}
+{{ end }}
+</body>
+</html>
+`))
@@ -0,0 +1,607 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// maintenanceReplicasAnnotation records the replicas of a workload
+	// scaled to zero, so it can be restored even after a restart
+	maintenanceReplicasAnnotation = "bridge/maintenance-replicas"
+
+	// maintenanceSpecAnnotation records the spec of an ingress served by
+	// the maintenance page
+	maintenanceSpecAnnotation = "bridge/maintenance-spec"
+
+	maintenancePage      = "bridge-maintenance"
+	maintenancePageLabel = "bridge/maintenance"
+	maintenancePageImage = "nginxinc/nginx-unprivileged:1.27-alpine"
+
+	defaultMaintenanceMessage = "This service is down for maintenance and will be back shortly."
+)
+
+// maintenanceWorkloads are the kinds scaled to zero.
+var maintenanceWorkloads = []string{"deployments", "statefulsets"}
+
+// maintenanceNginxConfig answers all requests with the page and a 503, so
+// clients and monitors see the outage.
+const maintenanceNginxConfig = `server {
+    listen 8080;
+    root /usr/share/nginx/html;
+
+    error_page 503 /index.html;
+
+    location = /index.html {
+        internal;
+    }
+
+    location / {
+        return 503;
+    }
+}
+`
+
+// handleMaintenance returns the workloads and ingresses of a namespace in
+// maintenance.
+func (s *Server) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	namespace := r.PathValue("namespace")
+
+	client, err := s.kubernetesClient(r.Context(), r.PathValue("context"), auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	status, err := maintenanceStatus(r.Context(), client, namespace)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleStartMaintenance scales the selected workloads of a namespace to
+// zero, recording their replicas on them, and optionally serves all its
+// ingresses by a static maintenance page. Workloads already in maintenance
+// keep their recorded replicas, so it can be extended by further calls.
+func (s *Server) handleStartMaintenance(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	namespace := r.PathValue("namespace")
+
+	var req MaintenanceRequest
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := s.checkProtection(r, name, namespace); err != nil {
+		writeProtectionError(w, r, err)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	// scaling a namespace down counts as a single disruption
+	if limit := s.config.Limits.MaxDisruptionsPerMinute; limit > 0 && !req.DryRun {
+		if ok, wait := s.disruptions.allow(strings.ToLower(name)+"/"+namespace, limit); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+
+			writeError(w, r, i18n.NewError("error.disruption_rate", namespace, limit), http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	result := &MaintenanceStatus{
+		Namespace: namespace,
+		DryRun:    req.DryRun,
+
+		Workloads: []MaintenanceWorkload{},
+		Ingresses: []string{},
+	}
+
+	query := url.Values{}
+
+	if req.Selector != "" {
+		query.Set("labelSelector", req.Selector)
+	}
+
+	for _, resource := range maintenanceWorkloads {
+		path := "/apis/apps/v1/namespaces/" + namespace + "/" + resource
+
+		var list metav1.PartialObjectMetadataList
+
+		if err := client.getMetadata(r.Context(), path, query, &list); err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+
+		for _, item := range list.Items {
+			if _, ok := item.Labels[maintenancePageLabel]; ok {
+				continue
+			}
+
+			if _, ok := item.Annotations[maintenanceReplicasAnnotation]; ok {
+				continue
+			}
+
+			var scale struct {
+				Spec struct {
+					Replicas int64 `json:"replicas"`
+				} `json:"spec"`
+			}
+
+			if err := client.get(r.Context(), path+"/"+item.Name+"/scale", nil, &scale); err != nil {
+				writeClientError(w, r, err)
+				return
+			}
+
+			// nothing to restore
+			if scale.Spec.Replicas == 0 {
+				continue
+			}
+
+			workload := MaintenanceWorkload{
+				Resource: resource,
+				Name:     item.Name,
+				Replicas: scale.Spec.Replicas,
+			}
+
+			if !req.DryRun {
+				patch, _ := json.Marshal(map[string]any{
+					"metadata": map[string]any{
+						"annotations": map[string]any{
+							maintenanceReplicasAnnotation: strconv.FormatInt(scale.Spec.Replicas, 10),
+						},
+						"resourceVersion": item.ResourceVersion,
+					},
+					"spec": map[string]any{
+						"replicas": 0,
+					},
+				})
+
+				if err := client.patch(r.Context(), path+"/"+item.Name, nil, "application/merge-patch+json", patch, nil); err != nil {
+					workload.Error = err.Error()
+				}
+			}
+
+			result.Workloads = append(result.Workloads, workload)
+		}
+	}
+
+	if req.Page {
+		if err := startMaintenancePage(r.Context(), client, namespace, &req, result); err != nil {
+			result.Error = err.Error()
+		}
+	}
+
+	result.Active = len(result.Workloads) > 0 || result.Page
+
+	s.recordMaintenance(name, namespace, "maintenance", auth, result)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// startMaintenancePage deploys the maintenance page and points the backends
+// of all ingresses of the namespace to it.
+func startMaintenancePage(ctx context.Context, client *kubernetesClient, namespace string, req *MaintenanceRequest, result *MaintenanceStatus) error {
+	var ingresses networkingv1.IngressList
+
+	if err := client.get(ctx, "/apis/networking.k8s.io/v1/namespaces/"+namespace+"/ingresses", nil, &ingresses); err != nil {
+		return err
+	}
+
+	result.Page = true
+
+	if req.DryRun {
+		for _, ing := range ingresses.Items {
+			if _, ok := ing.Annotations[maintenanceSpecAnnotation]; !ok {
+				result.Ingresses = append(result.Ingresses, ing.Name)
+			}
+		}
+
+		return nil
+	}
+
+	if err := deployMaintenancePage(ctx, client, namespace, req.Message); err != nil {
+		return err
+	}
+
+	backend := &networkingv1.IngressBackend{
+		Service: &networkingv1.IngressServiceBackend{
+			Name: maintenancePage,
+			Port: networkingv1.ServiceBackendPort{Number: 80},
+		},
+	}
+
+	for _, ing := range ingresses.Items {
+		if _, ok := ing.Annotations[maintenanceSpecAnnotation]; ok {
+			continue
+		}
+
+		spec, err := json.Marshal(ing.Spec)
+
+		if err != nil {
+			return err
+		}
+
+		if ing.Annotations == nil {
+			ing.Annotations = map[string]string{}
+		}
+
+		ing.Annotations[maintenanceSpecAnnotation] = string(spec)
+
+		if ing.Spec.DefaultBackend != nil {
+			ing.Spec.DefaultBackend = backend
+		}
+
+		for i := range ing.Spec.Rules {
+			if ing.Spec.Rules[i].HTTP == nil {
+				continue
+			}
+
+			for j := range ing.Spec.Rules[i].HTTP.Paths {
+				ing.Spec.Rules[i].HTTP.Paths[j].Backend = *backend
+			}
+		}
+
+		ing.APIVersion = "networking.k8s.io/v1"
+		ing.Kind = "Ingress"
+
+		if err := client.update(ctx, "/apis/networking.k8s.io/v1/namespaces/"+namespace+"/ingresses/"+ing.Name, nil, &ing, nil); err != nil {
+			return fmt.Errorf("failed to update ingress %s: %w", ing.Name, err)
+		}
+
+		result.Ingresses = append(result.Ingresses, ing.Name)
+	}
+
+	return nil
+}
+
+// deployMaintenancePage serves a static page with a 503 in a namespace.
+func deployMaintenancePage(ctx context.Context, client *kubernetesClient, namespace, message string) error {
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+
+	labels := map[string]string{
+		maintenancePageLabel: "page",
+	}
+
+	meta := metav1.ObjectMeta{
+		Name:      maintenancePage,
+		Namespace: namespace,
+		Labels:    labels,
+	}
+
+	page := fmt.Sprintf("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Maintenance</title>\n</head>\n<body style=\"font-family: sans-serif; text-align: center; padding-top: 20vh\">\n<h1>Maintenance</h1>\n<p>%s</p>\n</body>\n</html>\n", html.EscapeString(message))
+
+	replicas := int32(1)
+	enabled, disabled := true, false
+
+	cm := &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: meta,
+
+		Data: map[string]string{
+			"default.conf": maintenanceNginxConfig,
+			"index.html":   page,
+		},
+	}
+
+	deployment := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: meta,
+
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+
+				Spec: corev1.PodSpec{
+					AutomountServiceAccountToken: &disabled,
+
+					Containers: []corev1.Container{
+						{
+							Name:  "page",
+							Image: maintenancePageImage,
+
+							Ports: []corev1.ContainerPort{
+								{Name: "http", ContainerPort: 8080},
+							},
+
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "page", MountPath: "/etc/nginx/conf.d/default.conf", SubPath: "default.conf"},
+								{Name: "page", MountPath: "/usr/share/nginx/html/index.html", SubPath: "index.html"},
+							},
+
+							SecurityContext: &corev1.SecurityContext{
+								AllowPrivilegeEscalation: &disabled,
+								RunAsNonRoot:             &enabled,
+
+								Capabilities: &corev1.Capabilities{
+									Drop: []corev1.Capability{"ALL"},
+								},
+
+								SeccompProfile: &corev1.SeccompProfile{
+									Type: corev1.SeccompProfileTypeRuntimeDefault,
+								},
+							},
+						},
+					},
+
+					Volumes: []corev1.Volume{
+						{
+							Name: "page",
+
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{Name: maintenancePage},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	service := &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: meta,
+
+		Spec: corev1.ServiceSpec{
+			Selector: labels,
+
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80, TargetPort: intstr.FromString("http")},
+			},
+		},
+	}
+
+	for _, o := range []struct {
+		path string
+		obj  any
+	}{
+		{"/api/v1/namespaces/" + namespace + "/configmaps", cm},
+		{"/apis/apps/v1/namespaces/" + namespace + "/deployments", deployment},
+		{"/api/v1/namespaces/" + namespace + "/services", service},
+	} {
+		// a page left over from an earlier maintenance is reused
+		if err := client.create(ctx, o.path, o.obj, nil); err != nil && statusCode(err) != http.StatusConflict {
+			return fmt.Errorf("failed to deploy the maintenance page: %w", err)
+		}
+	}
+
+	// the message may have changed
+	return client.update(ctx, "/api/v1/namespaces/"+namespace+"/configmaps/"+maintenancePage, nil, cm, nil)
+}
+
+// handleStopMaintenance scales the workloads of a namespace back to their
+// recorded replicas, restores its ingresses and removes the maintenance page.
+func (s *Server) handleStopMaintenance(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	namespace := r.PathValue("namespace")
+
+	if err := s.checkProtection(r, name, namespace); err != nil {
+		writeProtectionError(w, r, err)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result, err := maintenanceStatus(r.Context(), client, namespace)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	var errs []string
+
+	for i, workload := range result.Workloads {
+		path := "/apis/apps/v1/namespaces/" + namespace + "/" + workload.Resource + "/" + workload.Name
+
+		patch, _ := json.Marshal(map[string]any{
+			"metadata": map[string]any{
+				"annotations": map[string]any{
+					maintenanceReplicasAnnotation: nil,
+				},
+			},
+			"spec": map[string]any{
+				"replicas": workload.Replicas,
+			},
+		})
+
+		if err := client.patch(r.Context(), path, nil, "application/merge-patch+json", patch, nil); err != nil {
+			result.Workloads[i].Error = err.Error()
+			errs = append(errs, workload.Name)
+		}
+	}
+
+	// traffic returns once the workloads are scaled up again
+	for _, name := range result.Ingresses {
+		if err := restoreMaintenanceIngress(r.Context(), client, namespace, name); err != nil {
+			errs = append(errs, name)
+			result.Error = err.Error()
+		}
+	}
+
+	if result.Page {
+		for _, path := range []string{
+			"/api/v1/namespaces/" + namespace + "/services/" + maintenancePage,
+			"/apis/apps/v1/namespaces/" + namespace + "/deployments/" + maintenancePage,
+			"/api/v1/namespaces/" + namespace + "/configmaps/" + maintenancePage,
+		} {
+			if err := client.delete(r.Context(), path, nil); err != nil && statusCode(err) != http.StatusNotFound {
+				errs = append(errs, maintenancePage)
+				result.Error = err.Error()
+			}
+		}
+	}
+
+	if len(errs) > 0 && result.Error == "" {
+		result.Error = "failed to restore " + strings.Join(errs, ", ")
+	}
+
+	result.Active = len(errs) > 0
+
+	s.recordMaintenance(name, namespace, "maintenance-restore", auth, result)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func restoreMaintenanceIngress(ctx context.Context, client *kubernetesClient, namespace, name string) error {
+	path := "/apis/networking.k8s.io/v1/namespaces/" + namespace + "/ingresses/" + name
+
+	var ing networkingv1.Ingress
+
+	if err := client.get(ctx, path, nil, &ing); err != nil {
+		return err
+	}
+
+	data, ok := ing.Annotations[maintenanceSpecAnnotation]
+
+	if !ok {
+		return nil
+	}
+
+	var spec networkingv1.IngressSpec
+
+	if err := json.Unmarshal([]byte(data), &spec); err != nil {
+		return fmt.Errorf("invalid maintenance spec of ingress %s: %w", name, err)
+	}
+
+	ing.Spec = spec
+	delete(ing.Annotations, maintenanceSpecAnnotation)
+
+	ing.APIVersion = "networking.k8s.io/v1"
+	ing.Kind = "Ingress"
+
+	return client.update(ctx, path, nil, &ing, nil)
+}
+
+// maintenanceStatus reads the maintenance state recorded in a namespace.
+func maintenanceStatus(ctx context.Context, client *kubernetesClient, namespace string) (*MaintenanceStatus, error) {
+	result := &MaintenanceStatus{
+		Namespace: namespace,
+
+		Workloads: []MaintenanceWorkload{},
+		Ingresses: []string{},
+	}
+
+	for _, resource := range maintenanceWorkloads {
+		var list metav1.PartialObjectMetadataList
+
+		if err := client.getMetadata(ctx, "/apis/apps/v1/namespaces/"+namespace+"/"+resource, nil, &list); err != nil {
+			return nil, err
+		}
+
+		for _, item := range list.Items {
+			if item.Name == maintenancePage && item.Labels[maintenancePageLabel] != "" {
+				result.Page = true
+			}
+
+			value, ok := item.Annotations[maintenanceReplicasAnnotation]
+
+			if !ok {
+				continue
+			}
+
+			replicas, _ := strconv.ParseInt(value, 10, 64)
+
+			result.Workloads = append(result.Workloads, MaintenanceWorkload{
+				Resource: resource,
+				Name:     item.Name,
+				Replicas: replicas,
+			})
+		}
+	}
+
+	var ingresses metav1.PartialObjectMetadataList
+
+	if err := client.getMetadata(ctx, "/apis/networking.k8s.io/v1/namespaces/"+namespace+"/ingresses", nil, &ingresses); err != nil {
+		return nil, err
+	}
+
+	for _, item := range ingresses.Items {
+		if _, ok := item.Annotations[maintenanceSpecAnnotation]; ok {
+			result.Ingresses = append(result.Ingresses, item.Name)
+		}
+	}
+
+	result.Active = len(result.Workloads) > 0 || len(result.Ingresses) > 0 || result.Page
+
+	return result, nil
+}
+
+func (s *Server) recordMaintenance(context, namespace, action string, auth *config.AuthInfo, result *MaintenanceStatus) {
+	if result.DryRun {
+		return
+	}
+
+	var names []string
+
+	for _, w := range result.Workloads {
+		names = append(names, w.Resource+"/"+w.Name)
+	}
+
+	s.audit.record(&AuditEntry{
+		Context: context,
+		Owner:   ownerID(auth),
+		Action:  action,
+
+		Resource:  "namespaces",
+		Namespace: namespace,
+		Name:      strings.Join(names, ","),
+
+		Error: result.Error,
+	})
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// jsonPatchOp is a single RFC 6902 JSON Patch operation.
+type jsonPatchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+// handleBulkMetadata adds and removes labels and annotations on all objects
+// matching a selector. Each object is changed with a JSON Patch guarded by
+// test operations; the inverse patch is recorded in the audit log.
+func (s *Server) handleBulkMetadata(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	var req BulkMetadataRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Resource == "" || req.Version == "" {
+		http.Error(w, "resource and version are required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Labels.empty() && req.Annotations.empty() {
+		http.Error(w, "no label or annotation changes given", http.StatusBadRequest)
+		return
+	}
+
+	if req.LabelSelector == "" && req.FieldSelector == "" && len(req.Names) == 0 {
+		// refuse to touch every object of a resource by accident
+		http.Error(w, "a selector or names are required", http.StatusBadRequest)
+		return
+	}
+
+	name := r.PathValue("context")
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	target := &kubernetesRequest{
+		Group:     req.Group,
+		Version:   req.Version,
+		Namespace: req.Namespace,
+		Resource:  req.Resource,
+	}
+
+	objects, err := selectObjects(r.Context(), client, target, req.LabelSelector, req.FieldSelector, req.Names)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := &BulkMetadataResult{
+		DryRun:  req.DryRun,
+		Results: []BulkMetadataItem{},
+	}
+
+	for _, obj := range objects {
+		patch, rollback := metadataPatch(obj.ObjectMeta, req.Labels, req.Annotations)
+
+		item := BulkMetadataItem{
+			Namespace: obj.Namespace,
+			Name:      obj.Name,
+
+			Patch:    patch,
+			Rollback: rollback,
+		}
+
+		if len(patch) > 0 && !req.DryRun {
+			if err := s.checkProtection(r, name, obj.Namespace); err != nil {
+				item.Error = err.Error()
+				result.Results = append(result.Results, item)
+
+				continue
+			}
+		}
+
+		if len(patch) > 0 {
+			err := applyJSONPatch(r.Context(), client, target, obj.Namespace, obj.Name, patch, req.DryRun)
+
+			if err != nil {
+				item.Error = err.Error()
+			}
+
+			if !req.DryRun {
+				s.audit.record(&AuditEntry{
+					Context: name,
+					Owner:   ownerID(auth),
+					Action:  "metadata",
+
+					Resource:  target.Resource,
+					Namespace: obj.Namespace,
+					Name:      obj.Name,
+
+					Patch:    patch,
+					Rollback: rollback,
+
+					Error: item.Error,
+				})
+			}
+		}
+
+		result.Results = append(result.Results, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func selectObjects(ctx context.Context, client *kubernetesClient, target *kubernetesRequest, labelSelector, fieldSelector string, names []string) ([]metav1.PartialObjectMetadata, error) {
+	query := url.Values{}
+
+	if labelSelector != "" {
+		query.Set("labelSelector", labelSelector)
+	}
+
+	if fieldSelector != "" {
+		query.Set("fieldSelector", fieldSelector)
+	}
+
+	var list metav1.PartialObjectMetadataList
+
+	if err := client.getMetadata(ctx, target.Path(), query, &list); err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		return list.Items, nil
+	}
+
+	return slices.DeleteFunc(list.Items, func(obj metav1.PartialObjectMetadata) bool {
+		return !slices.Contains(names, obj.Name)
+	}), nil
+}
+
+func applyJSONPatch(ctx context.Context, client *kubernetesClient, target *kubernetesRequest, namespace, name string, patch []jsonPatchOp, dryRun bool) error {
+	data, err := json.Marshal(patch)
+
+	if err != nil {
+		return err
+	}
+
+	object := *target
+	object.Namespace = namespace
+	object.Name = name
+
+	query := url.Values{}
+
+	if dryRun {
+		query.Set("dryRun", "All")
+	}
+
+	err = client.patch(ctx, object.Path(), query, "application/json-patch+json", data, nil)
+
+	var upstream *upstreamError
+
+	if errors.As(err, &upstream) {
+		var status metav1.Status
+
+		if json.Unmarshal(upstream.Body, &status) == nil && status.Message != "" {
+			return errors.New(status.Message)
+		}
+	}
+
+	return err
+}
+
+// metadataPatch computes the JSON Patch applying the changes to an object and
+// the patch reverting them. Unchanged keys produce no operations.
+func metadataPatch(meta metav1.ObjectMeta, labels, annotations MetadataChanges) ([]jsonPatchOp, []jsonPatchOp) {
+	var patch, rollback []jsonPatchOp
+
+	apply := func(field string, current map[string]string, changes MetadataChanges) {
+		if changes.empty() {
+			return
+		}
+
+		base := "/metadata/" + field
+
+		if current == nil {
+			if len(changes.Add) == 0 {
+				return
+			}
+
+			patch = append(patch, jsonPatchOp{Op: "add", Path: base, Value: map[string]string{}})
+			rollback = append(rollback, jsonPatchOp{Op: "remove", Path: base})
+
+			current = map[string]string{}
+		}
+
+		keys := make([]string, 0, len(changes.Add))
+
+		for key := range changes.Add {
+			keys = append(keys, key)
+		}
+
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			value := changes.Add[key]
+			path := base + "/" + escapeJSONPointer(key)
+
+			old, exists := current[key]
+
+			switch {
+			case exists && old == value:
+				continue
+
+			case exists:
+				patch = append(patch, jsonPatchOp{Op: "test", Path: path, Value: old})
+				patch = append(patch, jsonPatchOp{Op: "replace", Path: path, Value: value})
+				rollback = append(rollback, jsonPatchOp{Op: "replace", Path: path, Value: old})
+
+			default:
+				patch = append(patch, jsonPatchOp{Op: "add", Path: path, Value: value})
+				rollback = append(rollback, jsonPatchOp{Op: "remove", Path: path})
+			}
+		}
+
+		for _, key := range changes.Remove {
+			old, exists := current[key]
+
+			if !exists {
+				continue
+			}
+
+			path := base + "/" + escapeJSONPointer(key)
+
+			patch = append(patch, jsonPatchOp{Op: "test", Path: path, Value: old})
+			patch = append(patch, jsonPatchOp{Op: "remove", Path: path})
+			rollback = append(rollback, jsonPatchOp{Op: "add", Path: path, Value: old})
+		}
+	}
+
+	apply("labels", meta.Labels, labels)
+	apply("annotations", meta.Annotations, annotations)
+
+	// revert in reverse order, so created maps are removed last
+	slices.Reverse(rollback)
+
+	return patch, rollback
+}
+
+func escapeJSONPointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
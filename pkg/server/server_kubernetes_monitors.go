@@ -0,0 +1,444 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+const monitorsFile = "monitors.json"
+
+const (
+	defaultMonitorInterval = 5 * time.Minute
+	minMonitorInterval     = 30 * time.Second
+
+	// maxMonitorHistory is the number of results kept per monitor
+	maxMonitorHistory = 100
+
+	// maxMonitorItems is the number of matched objects kept of the latest result
+	maxMonitorItems = 50
+)
+
+var errMonitorCredentials = i18n.NewError("error.monitor_credentials")
+
+// monitors are saved search queries evaluated on a schedule. Definitions and
+// results are persisted; the credentials of bearer token owners are kept in
+// memory only, so their monitors pause after a restart until updated.
+type monitors struct {
+	mu sync.Mutex
+
+	loaded  bool
+	entries map[string]*Monitor
+
+	auths   map[string]*config.AuthInfo
+	running map[string]bool
+}
+
+func (m *monitors) load() {
+	if m.loaded {
+		return
+	}
+
+	m.loaded = true
+	m.entries = make(map[string]*Monitor)
+	m.auths = make(map[string]*config.AuthInfo)
+	m.running = make(map[string]bool)
+
+	if err := loadState(monitorsFile, &m.entries); err != nil {
+		log.Printf("failed to load monitors: %v", err)
+	}
+}
+
+// save persists the monitors; callers hold the lock.
+func (m *monitors) save() {
+	if err := saveState(monitorsFile, m.entries); err != nil {
+		log.Printf("failed to save monitors: %v", err)
+	}
+}
+
+func (m *monitors) get(id, owner string) (*Monitor, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.load()
+
+	e, ok := m.entries[id]
+
+	if !ok || e.Owner != owner {
+		return nil, false
+	}
+
+	return cloneMonitor(e), true
+}
+
+func (m *monitors) list(owner string) []Monitor {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.load()
+
+	result := []Monitor{}
+
+	for _, e := range m.entries {
+		if e.Owner != owner {
+			continue
+		}
+
+		// the listing only carries the latest result
+		e := cloneMonitor(e)
+
+		if n := len(e.History); n > 1 {
+			e.History = e.History[n-1:]
+		}
+
+		result = append(result, *e)
+	}
+
+	slices.SortFunc(result, func(a, b Monitor) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return result
+}
+
+func (m *monitors) put(e *Monitor, auth *config.AuthInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.load()
+
+	m.entries[e.ID] = e
+	m.auths[e.ID] = auth
+
+	m.save()
+}
+
+func (m *monitors) delete(id, owner string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.load()
+
+	e, ok := m.entries[id]
+
+	if !ok || e.Owner != owner {
+		return false
+	}
+
+	delete(m.entries, id)
+	delete(m.auths, id)
+
+	m.save()
+
+	return true
+}
+
+// due returns the monitors to evaluate and marks them running. Monitors
+// skip returns true for are deferred to a later tick.
+func (m *monitors) due(now time.Time, skip func(*Monitor) bool) []*Monitor {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.load()
+
+	var result []*Monitor
+
+	for id, e := range m.entries {
+		if m.running[id] {
+			continue
+		}
+
+		interval, _ := time.ParseDuration(e.Interval)
+
+		if n := len(e.History); n > 0 && now.Sub(e.History[n-1].Time) < interval {
+			continue
+		}
+
+		if skip(e) {
+			continue
+		}
+
+		m.running[id] = true
+
+		result = append(result, cloneMonitor(e))
+	}
+
+	return result
+}
+
+// record appends a result to the history of a monitor.
+func (m *monitors) record(id string, result MonitorResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.running, id)
+
+	e, ok := m.entries[id]
+
+	if !ok {
+		return
+	}
+
+	if n := len(e.History); n > 0 {
+		prev := e.History[n-1]
+
+		if result.Alerting != prev.Alerting {
+			log.Printf("monitor %q: alerting changed to %v (%d matches)", e.Name, result.Alerting, result.Total)
+		}
+
+		e.History[n-1].Items = nil
+	}
+
+	e.History = append(e.History, result)
+
+	if len(e.History) > maxMonitorHistory {
+		e.History = slices.Clone(e.History[len(e.History)-maxMonitorHistory:])
+	}
+
+	m.save()
+}
+
+func (m *monitors) auth(id string) (*config.AuthInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	auth, ok := m.auths[id]
+	return auth, ok
+}
+
+func cloneMonitor(e *Monitor) *Monitor {
+	c := *e
+	c.History = slices.Clone(e.History)
+
+	return &c
+}
+
+// runMonitors evaluates due monitors until done is closed.
+func (s *Server) runMonitors(done <-chan struct{}) {
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+
+		case now := <-ticker.C:
+			for _, e := range s.monitors.due(now, s.monitorOffline) {
+				go func() {
+					s.monitors.record(e.ID, s.evaluateMonitor(e))
+				}()
+			}
+		}
+	}
+}
+
+// monitorOffline reports whether all contexts of a monitor are offline; its
+// evaluation waits for connectivity instead of recording errors.
+func (s *Server) monitorOffline(e *Monitor) bool {
+	contexts := e.Query.Contexts
+
+	if len(contexts) == 0 {
+		contexts = s.kubernetesContextNames()
+	}
+
+	for _, name := range contexts {
+		if !s.connectivity.offline(name) {
+			return false
+		}
+	}
+
+	return len(contexts) > 0
+}
+
+// evaluateMonitor runs the query of a monitor with the credentials of its
+// owner. The monitor alerts if the matches exceed its threshold.
+func (s *Server) evaluateMonitor(e *Monitor) MonitorResult {
+	result := MonitorResult{
+		Time: time.Now().UTC(),
+	}
+
+	auth, ok := s.monitors.auth(e.ID)
+
+	if !ok && e.Owner != "" {
+		result.Errors = []string{errMonitorCredentials.Error()}
+		return result
+	}
+
+	query, err := newSearchQuery(e.Query)
+
+	if err != nil {
+		result.Errors = []string{err.Error()}
+		return result
+	}
+
+	query.Limit = maxMonitorItems
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	search := s.runSearch(ctx, auth, query, true)
+
+	result.Total = search.Total
+	result.Items = search.Items
+	result.Errors = search.Errors
+
+	result.Alerting = search.Total > e.Threshold
+
+	return result
+}
+
+func (s *Server) handleListMonitors(w http.ResponseWriter, r *http.Request) {
+	owner := ownerID(AuthInfoFromContext(r.Context()))
+
+	result := s.monitors.list(owner)
+
+	writeList(w, r, "monitors", result, result)
+}
+
+func (s *Server) handleGetMonitor(w http.ResponseWriter, r *http.Request) {
+	owner := ownerID(AuthInfoFromContext(r.Context()))
+
+	e, ok := s.monitors.get(r.PathValue("id"), owner)
+
+	if !ok {
+		http.Error(w, "monitor not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
+
+func (s *Server) handleCreateMonitor(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	e := &Monitor{
+		ID:    hex.EncodeToString(id),
+		Owner: ownerID(auth),
+
+		Created: time.Now().UTC(),
+		History: []MonitorResult{},
+	}
+
+	if !decodeMonitor(w, r, e) {
+		return
+	}
+
+	s.monitors.put(e, auth)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	json.NewEncoder(w).Encode(e)
+}
+
+// handleUpdateMonitor replaces the definition of a monitor, keeping its
+// history. It also refreshes the credentials the monitor runs with.
+func (s *Server) handleUpdateMonitor(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	e, ok := s.monitors.get(r.PathValue("id"), ownerID(auth))
+
+	if !ok {
+		http.Error(w, "monitor not found", http.StatusNotFound)
+		return
+	}
+
+	if !decodeMonitor(w, r, e) {
+		return
+	}
+
+	s.monitors.put(e, auth)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
+
+func (s *Server) handleDeleteMonitor(w http.ResponseWriter, r *http.Request) {
+	owner := ownerID(AuthInfoFromContext(r.Context()))
+
+	if !s.monitors.delete(r.PathValue("id"), owner) {
+		http.Error(w, "monitor not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRunMonitor evaluates a monitor immediately.
+func (s *Server) handleRunMonitor(w http.ResponseWriter, r *http.Request) {
+	owner := ownerID(AuthInfoFromContext(r.Context()))
+
+	e, ok := s.monitors.get(r.PathValue("id"), owner)
+
+	if !ok {
+		http.Error(w, "monitor not found", http.StatusNotFound)
+		return
+	}
+
+	result := s.evaluateMonitor(e)
+	s.monitors.record(e.ID, result)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// decodeMonitor applies a MonitorRequest to e, writing an error response if
+// it is invalid.
+func decodeMonitor(w http.ResponseWriter, r *http.Request, e *Monitor) bool {
+	var req MonitorRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return false
+	}
+
+	if _, err := newSearchQuery(req.Query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	interval := defaultMonitorInterval
+
+	if req.Interval != "" {
+		d, err := time.ParseDuration(req.Interval)
+
+		if err != nil || d < minMonitorInterval {
+			http.Error(w, "interval must be a duration of at least "+minMonitorInterval.String(), http.StatusBadRequest)
+			return false
+		}
+
+		interval = d
+	}
+
+	if req.Threshold < 0 {
+		http.Error(w, "threshold must not be negative", http.StatusBadRequest)
+		return false
+	}
+
+	e.Name = req.Name
+	e.Query = req.Query
+	e.Interval = interval.String()
+	e.Threshold = req.Threshold
+
+	return true
+}
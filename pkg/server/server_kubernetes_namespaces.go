@@ -0,0 +1,283 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	maxRecentNamespaces = 10
+
+	// namespaceHistoryDelay debounces saving the history, which changes
+	// with proxied requests
+	namespaceHistoryDelay = 2 * time.Second
+)
+
+// namespaceHistory remembers the most recently used namespaces per caller
+// and context and persists them in the bridge data directory.
+type namespaceHistory struct {
+	mu sync.Mutex
+
+	loaded  bool
+	entries map[string][]string
+
+	// pending is the scheduled save of changed entries
+	pending *time.Timer
+}
+
+const namespaceHistoryFile = "namespaces.json"
+
+func (h *namespaceHistory) load() {
+	if h.loaded {
+		return
+	}
+
+	h.loaded = true
+	h.entries = make(map[string][]string)
+
+	if err := loadState(namespaceHistoryFile, &h.entries); err != nil {
+		log.Printf("failed to load namespace history: %v", err)
+	}
+}
+
+// namespaceHistoryKey separates the history of callers in server mode; the
+// history of the local user is kept by context alone.
+func namespaceHistoryKey(owner, context string) string {
+	if owner == "" {
+		return strings.ToLower(context)
+	}
+
+	return owner + "/" + strings.ToLower(context)
+}
+
+func (h *namespaceHistory) touch(owner, context, namespace string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.load()
+
+	key := namespaceHistoryKey(owner, context)
+	recent := h.entries[key]
+
+	if len(recent) > 0 && recent[0] == namespace {
+		return
+	}
+
+	recent = slices.DeleteFunc(slices.Clone(recent), func(s string) bool {
+		return s == namespace
+	})
+
+	recent = append([]string{namespace}, recent...)
+
+	if len(recent) > maxRecentNamespaces {
+		recent = recent[:maxRecentNamespaces]
+	}
+
+	h.entries[key] = recent
+
+	if h.pending == nil {
+		h.pending = time.AfterFunc(namespaceHistoryDelay, h.save)
+	}
+}
+
+// save persists the history outside of the request path.
+func (h *namespaceHistory) save() {
+	h.mu.Lock()
+
+	h.pending = nil
+
+	if !h.loaded {
+		h.mu.Unlock()
+		return
+	}
+
+	entries := maps.Clone(h.entries)
+
+	h.mu.Unlock()
+
+	if err := saveState(namespaceHistoryFile, entries); err != nil {
+		log.Printf("failed to save namespace history: %v", err)
+	}
+}
+
+// flush saves a pending change right away, e.g. on shutdown.
+func (h *namespaceHistory) flush() {
+	h.mu.Lock()
+
+	pending := h.pending != nil && h.pending.Stop()
+
+	h.mu.Unlock()
+
+	if pending {
+		h.save()
+	}
+}
+
+func (h *namespaceHistory) recent(owner, context string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.load()
+
+	return slices.Clone(h.entries[namespaceHistoryKey(owner, context)])
+}
+
+// trackNamespace records the namespace targeted by a kubernetes API request.
+func (s *Server) trackNamespace(context string, r *http.Request) {
+	req, ok := parseKubernetesPath(r.URL.Path)
+
+	if !ok || req.Namespace == "" {
+		return
+	}
+
+	s.namespaces.touch(ownerID(AuthInfoFromContext(r.Context())), context, req.Namespace)
+}
+
+// handleAllowedNamespaces lists the namespaces the caller can access. If
+// listing namespaces is forbidden, known candidates (recently used, default
+// and ?namespaces=) are probed with SelfSubjectRulesReviews instead.
+func (s *Server) handleAllowedNamespaces(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := &AllowedNamespaces{
+		Namespaces: []string{},
+		Recent:     s.namespaces.recent(ownerID(auth), name),
+	}
+
+	var list corev1.NamespaceList
+
+	err = client.get(r.Context(), "/api/v1/namespaces", nil, &list)
+
+	switch {
+	case err == nil:
+		for _, ns := range list.Items {
+			result.Namespaces = append(result.Namespaces, ns.Name)
+		}
+
+	case statusCode(err) == http.StatusForbidden:
+		result.Probed = true
+
+		candidates := slices.Clone(result.Recent)
+
+		for _, v := range r.URL.Query()["namespaces"] {
+			candidates = append(candidates, splitNames(v)...)
+		}
+
+		if ns := s.defaultNamespace(name); ns != "" {
+			candidates = append(candidates, ns)
+		}
+
+		candidates = append(candidates, "default")
+
+		slices.Sort(candidates)
+		candidates = slices.Compact(candidates)
+
+		result.Namespaces = probeNamespaces(r.Context(), client, candidates)
+
+	default:
+		writeClientError(w, r, err)
+		return
+	}
+
+	slices.Sort(result.Namespaces)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *Server) defaultNamespace(context string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.config.Kubernetes == nil || !strings.EqualFold(s.config.Kubernetes.CurrentContext, context) {
+		return ""
+	}
+
+	return s.config.Kubernetes.CurrentNamespace
+}
+
+func probeNamespaces(ctx context.Context, client *kubernetesClient, candidates []string) []string {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	result := []string{}
+	sem := make(chan struct{}, 8)
+
+	for _, ns := range candidates {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			review := &authorizationv1.SelfSubjectRulesReview{
+				Spec: authorizationv1.SelfSubjectRulesReviewSpec{
+					Namespace: ns,
+				},
+			}
+
+			if err := client.create(ctx, "/apis/authorization.k8s.io/v1/selfsubjectrulesreviews", review, review); err != nil {
+				return
+			}
+
+			if !grantsNamespaceAccess(review.Status.ResourceRules) {
+				return
+			}
+
+			mu.Lock()
+			result = append(result, ns)
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	return result
+}
+
+// grantsNamespaceAccess reports whether any rule goes beyond the self-review
+// permissions every authenticated user has.
+func grantsNamespaceAccess(rules []authorizationv1.ResourceRule) bool {
+	for _, rule := range rules {
+		for _, group := range rule.APIGroups {
+			if group != "authorization.k8s.io" && group != "authentication.k8s.io" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func splitNames(s string) []string {
+	var result []string
+
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
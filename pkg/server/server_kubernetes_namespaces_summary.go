@@ -0,0 +1,279 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// namespaceObjects are the kinds counted in the summary of a namespace,
+// besides pods.
+var namespaceObjects = []struct {
+	kind string
+	path string
+}{
+	{"Deployment", "/apis/apps/v1"},
+	{"StatefulSet", "/apis/apps/v1"},
+	{"DaemonSet", "/apis/apps/v1"},
+	{"Job", "/apis/batch/v1"},
+	{"CronJob", "/apis/batch/v1"},
+	{"Service", "/api/v1"},
+	{"Ingress", "/apis/networking.k8s.io/v1"},
+	{"ConfigMap", "/api/v1"},
+	{"Secret", "/api/v1"},
+	{"PersistentVolumeClaim", "/api/v1"},
+	{"ServiceAccount", "/api/v1"},
+}
+
+// handleNamespaceSummary combines the resource quotas, LimitRanges, live
+// usage from the metrics API, the requests and limits of its pods and the
+// number of objects per kind into an overview of a namespace. Parts the
+// caller cannot read, or the usage without metrics-server, are reported as
+// errors without failing the summary.
+func (s *Server) handleNamespaceSummary(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	namespace := r.PathValue("namespace")
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	var ns corev1.Namespace
+
+	if err := client.get(r.Context(), "/api/v1/namespaces/"+namespace, nil, &ns); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := &NamespaceSummary{
+		Namespace: ns.Name,
+		Phase:     string(ns.Status.Phase),
+
+		ReadOnly:  s.config.NamespaceProtection(ns.Name) == config.ProtectionReadOnly,
+		Protected: s.config.NamespaceProtection(ns.Name) == config.ProtectionConfirm,
+
+		Quotas:      []NamespaceQuota{},
+		LimitRanges: []NamespaceLimit{},
+
+		Pods:    map[string]int{},
+		Objects: map[string]int{},
+
+		Errors: []string{},
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	failed := func(what string, err error) bool {
+		if err == nil {
+			return false
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		result.Errors = append(result.Errors, what+": "+statusMessage(err))
+		return true
+	}
+
+	for _, obj := range namespaceObjects {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			resource := strings.ToLower(obj.kind) + "s"
+
+			if obj.kind == "Ingress" {
+				resource = "ingresses"
+			}
+
+			var list metav1.PartialObjectMetadataList
+
+			if failed(resource, client.getMetadata(r.Context(), obj.path+"/namespaces/"+namespace+"/"+resource, nil, &list)) {
+				return
+			}
+
+			mu.Lock()
+			result.Objects[obj.kind] = len(list.Items)
+			mu.Unlock()
+		}()
+	}
+
+	var quotas corev1.ResourceQuotaList
+	var ranges corev1.LimitRangeList
+	var pods corev1.PodList
+
+	var metrics struct {
+		Items []podMetrics `json:"items"`
+	}
+
+	wg.Add(4)
+
+	go func() {
+		defer wg.Done()
+		failed("resourcequotas", client.get(r.Context(), "/api/v1/namespaces/"+namespace+"/resourcequotas", nil, &quotas))
+	}()
+
+	go func() {
+		defer wg.Done()
+		failed("limitranges", client.get(r.Context(), "/api/v1/namespaces/"+namespace+"/limitranges", nil, &ranges))
+	}()
+
+	go func() {
+		defer wg.Done()
+		failed("pods", client.get(r.Context(), "/api/v1/namespaces/"+namespace+"/pods", nil, &pods))
+	}()
+
+	go func() {
+		defer wg.Done()
+		failed("metrics", getMetrics(r.Context(), client, name, "/namespaces/"+namespace+"/pods", nil, &metrics))
+	}()
+
+	wg.Wait()
+
+	for _, q := range quotas.Items {
+		result.Quotas = append(result.Quotas, namespaceQuota(q))
+	}
+
+	for _, lr := range ranges.Items {
+		for _, item := range lr.Spec.Limits {
+			result.LimitRanges = append(result.LimitRanges, namespaceLimits(lr.Name, item)...)
+		}
+	}
+
+	requests := corev1.ResourceList{}
+	limits := corev1.ResourceList{}
+
+	for _, p := range pods.Items {
+		result.Pods[string(p.Status.Phase)]++
+
+		if p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		// pod resources include init containers and defaulted requests
+		workload := &fitWorkload{manifestWorkload: manifestWorkload{Spec: p.Spec}}
+		applyLimitRanges(workload, nil)
+
+		for resource, q := range workload.requests {
+			addResource(requests, resource, q, false)
+		}
+
+		for resource, q := range workload.limits {
+			addResource(limits, resource, q, false)
+		}
+	}
+
+	result.Objects["Pod"] = len(pods.Items)
+
+	result.Requests = topResources(requests)
+	result.Limits = topResources(limits)
+
+	if metrics.Items != nil {
+		usage := corev1.ResourceList{}
+
+		for _, m := range metrics.Items {
+			for _, c := range m.Containers {
+				for resource, q := range c.Usage {
+					addResource(usage, resource, q, false)
+				}
+			}
+		}
+
+		total := topResources(usage)
+		result.Usage = &total
+	}
+
+	slices.Sort(result.Errors)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// namespaceQuota returns the used and hard limits of a quota, per resource.
+func namespaceQuota(q corev1.ResourceQuota) NamespaceQuota {
+	result := NamespaceQuota{
+		Name:      q.Name,
+		Resources: []NamespaceQuotaResource{},
+	}
+
+	for name, hard := range q.Status.Hard {
+		used := q.Status.Used[name]
+
+		item := NamespaceQuotaResource{
+			Resource: string(name),
+			Hard:     hard.String(),
+			Used:     used.String(),
+		}
+
+		if hard.MilliValue() > 0 {
+			percent := math.Round(float64(used.MilliValue())/float64(hard.MilliValue())*1000) / 10
+			item.Percent = &percent
+		}
+
+		result.Resources = append(result.Resources, item)
+	}
+
+	slices.SortFunc(result.Resources, func(a, b NamespaceQuotaResource) int {
+		return strings.Compare(a.Resource, b.Resource)
+	})
+
+	return result
+}
+
+// namespaceLimits flattens an item of a LimitRange to one entry per
+// resource.
+func namespaceLimits(name string, item corev1.LimitRangeItem) []NamespaceLimit {
+	var resources []corev1.ResourceName
+
+	for _, list := range []corev1.ResourceList{item.Min, item.Max, item.Default, item.DefaultRequest, item.MaxLimitRequestRatio} {
+		for resource := range list {
+			if !slices.Contains(resources, resource) {
+				resources = append(resources, resource)
+			}
+		}
+	}
+
+	slices.Sort(resources)
+
+	value := func(list corev1.ResourceList, name corev1.ResourceName) string {
+		if q, ok := list[name]; ok {
+			return q.String()
+		}
+
+		return ""
+	}
+
+	var result []NamespaceLimit
+
+	for _, resource := range resources {
+		result = append(result, NamespaceLimit{
+			LimitRange: name,
+			Type:       string(item.Type),
+			Resource:   string(resource),
+
+			Min:            value(item.Min, resource),
+			Max:            value(item.Max, resource),
+			Default:        value(item.Default, resource),
+			DefaultRequest: value(item.DefaultRequest, resource),
+
+			MaxLimitRequestRatio: value(item.MaxLimitRequestRatio, resource),
+		})
+	}
+
+	return result
+}
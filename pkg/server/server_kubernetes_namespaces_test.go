@@ -0,0 +1,55 @@
+package server
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNamespaceHistory(t *testing.T) {
+	h := &namespaceHistory{
+		loaded:  true,
+		entries: map[string][]string{},
+	}
+
+	defer h.reset()
+
+	h.touch("", "Dev", "payments")
+	h.touch("", "dev", "billing")
+	h.touch("", "dev", "payments")
+
+	h.touch("alice", "dev", "search")
+
+	tests := []struct {
+		owner   string
+		context string
+		want    []string
+	}{
+		{"", "dev", []string{"payments", "billing"}},
+		{"alice", "DEV", []string{"search"}},
+		{"bob", "dev", nil},
+		{"", "prod", nil},
+	}
+
+	for _, tt := range tests {
+		if got := h.recent(tt.owner, tt.context); !slices.Equal(got, tt.want) {
+			t.Errorf("recent(%q, %q) = %v, want %v", tt.owner, tt.context, got, tt.want)
+		}
+	}
+
+	// the local history keeps the keys of earlier versions
+	if _, ok := h.entries["dev"]; !ok {
+		t.Error("expected the local history to be keyed by context")
+	}
+
+	if h.pending == nil {
+		t.Error("expected the history to be saved in the background")
+	}
+
+	for i := range 2 * maxRecentNamespaces {
+		h.touch("", "dev", string(rune('a'+i)))
+	}
+
+	if n := len(h.recent("", "dev")); n != maxRecentNamespaces {
+		t.Errorf("kept %d namespaces, want %d", n, maxRecentNamespaces)
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// nodePressureConditions are the conditions the kubelet reports when it
+// runs short of a resource and starts evicting pods.
+var nodePressureConditions = []corev1.NodeConditionType{
+	corev1.NodeMemoryPressure,
+	corev1.NodeDiskPressure,
+	corev1.NodePIDPressure,
+}
+
+// handleNodeHealth aggregates the health of the nodes of a context: their
+// conditions, including those of the node problem detector, pressure,
+// taints, kubelet and container runtime versions, and a history of their
+// condition transitions and events. Nodes with problems are listed first.
+func (s *Server) handleNodeHealth(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	client, err := s.kubernetesClient(r.Context(), r.PathValue("context"), auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	var nodes corev1.NodeList
+
+	if err := client.get(r.Context(), "/api/v1/nodes", nil, &nodes); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	// the kubelet records node events with the name of the node as uid, so
+	// they are matched by kind and name
+	query := url.Values{
+		"fieldSelector": {"involvedObject.kind=Node"},
+	}
+
+	var events corev1.EventList
+
+	if err := client.get(r.Context(), "/api/v1/events", query, &events); err != nil {
+		events.Items = nil
+	}
+
+	result := &NodeHealthReport{
+		Nodes: []NodeHealth{},
+
+		KubeletVersions: map[string]int{},
+		RuntimeVersions: map[string]int{},
+	}
+
+	for _, n := range nodes.Items {
+		item := nodeHealth(&n)
+
+		for _, e := range events.Items {
+			if e.InvolvedObject.Name != n.Name {
+				continue
+			}
+
+			if e.Type == corev1.EventTypeWarning {
+				item.Warnings++
+			}
+
+			item.Timeline = append(item.Timeline, eventTimelineEntry(e))
+		}
+
+		slices.SortStableFunc(item.Timeline, func(a, b TimelineEntry) int {
+			return a.Time.Compare(b.Time)
+		})
+
+		if len(item.Problems) > 0 {
+			result.Problems++
+		}
+
+		result.KubeletVersions[item.KubeletVersion]++
+		result.RuntimeVersions[item.ContainerRuntime]++
+
+		result.Nodes = append(result.Nodes, item)
+	}
+
+	slices.SortFunc(result.Nodes, func(a, b NodeHealth) int {
+		return cmp.Or(
+			cmp.Compare(len(b.Problems), len(a.Problems)),
+			strings.Compare(a.Name, b.Name),
+		)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// nodeHealth returns the health of a node from its status. Conditions are
+// healthy if Ready is true and all others, e.g. DiskPressure or the
+// KernelDeadlock of the node problem detector, are false.
+func nodeHealth(n *corev1.Node) NodeHealth {
+	info := n.Status.NodeInfo
+
+	item := NodeHealth{
+		Name:  n.Name,
+		Roles: nodeRoles(n),
+
+		Unschedulable: n.Spec.Unschedulable,
+
+		KubeletVersion:   info.KubeletVersion,
+		ContainerRuntime: info.ContainerRuntimeVersion,
+		KernelVersion:    info.KernelVersion,
+		OSImage:          info.OSImage,
+
+		Conditions: []ObjectCondition{},
+		Pressure:   []string{},
+		Problems:   []string{},
+		Taints:     []string{},
+
+		Timeline: []TimelineEntry{},
+	}
+
+	for _, c := range n.Status.Conditions {
+		item.Conditions = append(item.Conditions, ObjectCondition{
+			Type:   string(c.Type),
+			Status: string(c.Status),
+
+			Reason:  c.Reason,
+			Message: c.Message,
+
+			LastTransitionTime: c.LastTransitionTime.Time,
+		})
+
+		if !c.LastTransitionTime.IsZero() {
+			item.Timeline = append(item.Timeline, TimelineEntry{
+				Time:   c.LastTransitionTime.Time,
+				Source: "condition",
+
+				Type:    string(c.Type),
+				Status:  string(c.Status),
+				Reason:  c.Reason,
+				Message: c.Message,
+			})
+		}
+
+		if c.Type == corev1.NodeReady {
+			item.Ready = c.Status == corev1.ConditionTrue
+
+			if !item.Ready {
+				item.Problems = append(item.Problems, nodeProblem("NotReady", c))
+			}
+
+			continue
+		}
+
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+
+		if slices.Contains(nodePressureConditions, c.Type) {
+			item.Pressure = append(item.Pressure, string(c.Type))
+		}
+
+		item.Problems = append(item.Problems, nodeProblem(string(c.Type), c))
+	}
+
+	for _, t := range n.Spec.Taints {
+		taint := t.Key
+
+		if t.Value != "" {
+			taint += "=" + t.Value
+		}
+
+		item.Taints = append(item.Taints, taint+":"+string(t.Effect))
+	}
+
+	return item
+}
+
+func nodeProblem(name string, c corev1.NodeCondition) string {
+	if c.Message == "" {
+		return name
+	}
+
+	return name + ": " + c.Message
+}
+
+// nodeRoles returns the roles of a node from its node-role.kubernetes.io
+// labels.
+func nodeRoles(n *corev1.Node) []string {
+	roles := []string{}
+
+	for key := range n.Labels {
+		if role, ok := strings.CutPrefix(key, "node-role.kubernetes.io/"); ok && role != "" {
+			roles = append(roles, role)
+		}
+	}
+
+	slices.Sort(roles)
+
+	return roles
+}
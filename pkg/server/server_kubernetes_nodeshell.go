@@ -0,0 +1,474 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	nodeShellLabel = "bridge.node-shell"
+	nodeShellImage = "alpine:3.22"
+
+	// nodeShellConnectTimeout removes debug pods no terminal connected to
+	nodeShellConnectTimeout = time.Minute
+)
+
+// nodeShellCommand enters all namespaces of the init process of the node,
+// which gives a shell as if logged in on the host.
+var nodeShellCommand = []string{"nsenter", "-t", "1", "-m", "-u", "-i", "-n", "-p", "--", "sh", "-c", "command -v bash >/dev/null && exec bash -l || exec sh -l"}
+
+// nodeShells holds the debug pods of node shells, from their creation until
+// their terminal closes.
+type nodeShells struct {
+	mu    sync.Mutex
+	items map[string]*nodeShell
+}
+
+func (n *nodeShells) add(item *nodeShell) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.items == nil {
+		n.items = make(map[string]*nodeShell)
+	}
+
+	n.items[item.id] = item
+}
+
+func (n *nodeShells) remove(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.items, id)
+}
+
+// claim hands a node shell to the terminal of its owner, each shell serves
+// one terminal.
+func (n *nodeShells) claim(context, node, id, owner string) (*nodeShell, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	item, ok := n.items[id]
+
+	if !ok || item.claimed || item.owner != owner || !strings.EqualFold(item.context, context) || item.node != node {
+		return nil, false
+	}
+
+	item.claimed = true
+
+	return item, true
+}
+
+// nodeShell is a privileged pod on a node that shares the namespaces of the
+// host.
+type nodeShell struct {
+	id    string
+	owner string
+
+	context   string
+	node      string
+	namespace string
+	pod       string
+
+	created time.Time
+
+	client  *kubernetesClient
+	claimed bool
+
+	release   func()
+	closeOnce sync.Once
+	onClose   func()
+}
+
+func (n *nodeShell) info() NodeShellInfo {
+	return NodeShellInfo{
+		ID: n.id,
+
+		Context:   n.context,
+		Node:      n.node,
+		Namespace: n.namespace,
+		Pod:       n.pod,
+
+		URL: "/contexts/" + url.PathEscape(n.context) + "/nodes/" + n.node + "/shell/" + n.id,
+
+		Created: n.created,
+	}
+}
+
+// Close removes the debug pod.
+func (n *nodeShell) Close() error {
+	var err error
+
+	n.closeOnce.Do(func() {
+		if n.onClose != nil {
+			n.onClose()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		// the shell does not stop on SIGTERM
+		err = n.client.delete(ctx, "/api/v1/namespaces/"+n.namespace+"/pods/"+n.pod, url.Values{"gracePeriodSeconds": {"0"}})
+	})
+
+	return err
+}
+
+// handleCreateNodeShell launches a privileged debug pod on a node, like
+// kubectl debug node. The shell is opened through the returned terminal URL,
+// the pod is removed when the terminal closes, or if none connects within a
+// minute.
+func (s *Server) handleCreateNodeShell(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	node := r.PathValue("node")
+
+	var req NodeShellRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Namespace == "" {
+		req.Namespace = s.defaultNamespace(name)
+	}
+
+	if req.Namespace == "" {
+		req.Namespace = "default"
+	}
+
+	if req.Image == "" {
+		req.Image = nodeShellImage
+	}
+
+	if err := s.checkProtection(r, name, req.Namespace); err != nil {
+		writeProtectionError(w, r, err)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	var n corev1.Node
+
+	if err := client.get(r.Context(), "/api/v1/nodes/"+node, nil, &n); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	id := make([]byte, 4)
+	rand.Read(id)
+
+	item := &nodeShell{
+		id:    hex.EncodeToString(id),
+		owner: ownerID(auth),
+
+		context:   name,
+		node:      n.Name,
+		namespace: req.Namespace,
+
+		created: time.Now(),
+
+		client: client,
+	}
+
+	item.pod = "bridge-node-shell-" + item.id
+
+	err = startNodeShell(r.Context(), item, req.Image)
+
+	entry := &AuditEntry{
+		Context: name,
+		Owner:   item.owner,
+		Action:  "node-shell",
+
+		Resource: "nodes",
+		Name:     item.node,
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	s.audit.record(entry)
+
+	if err != nil {
+		item.Close()
+
+		writeClientError(w, r, err)
+		return
+	}
+
+	item.onClose = func() {
+		s.nodeShells.remove(item.id)
+	}
+
+	item.release = s.track(name, item)
+	s.nodeShells.add(item)
+
+	time.AfterFunc(nodeShellConnectTimeout, func() {
+		if _, ok := s.nodeShells.claim(item.context, item.node, item.id, item.owner); ok {
+			log.Printf("node shell %s on node %q was not connected, removing pod", item.id, item.node)
+			item.release()
+		}
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	json.NewEncoder(w).Encode(item.info())
+}
+
+// startNodeShell creates the debug pod and waits until it runs.
+func startNodeShell(ctx context.Context, item *nodeShell, image string) error {
+	privileged := true
+
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Pod",
+		},
+
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      item.pod,
+			Namespace: item.namespace,
+
+			Labels: map[string]string{
+				nodeShellLabel:                 item.id,
+				"app.kubernetes.io/managed-by": "bridge",
+			},
+		},
+
+		Spec: corev1.PodSpec{
+			NodeName:      item.node,
+			RestartPolicy: corev1.RestartPolicyNever,
+
+			HostPID:     true,
+			HostIPC:     true,
+			HostNetwork: true,
+
+			// runs on tainted nodes, e.g. control planes or cordoned nodes
+			Tolerations: []corev1.Toleration{
+				{Operator: corev1.TolerationOpExists},
+			},
+
+			Containers: []corev1.Container{
+				{
+					Name:  "shell",
+					Image: image,
+
+					Command: []string{"sleep", "infinity"},
+
+					SecurityContext: &corev1.SecurityContext{
+						Privileged: &privileged,
+					},
+				},
+			},
+		},
+	}
+
+	if err := item.client.create(ctx, "/api/v1/namespaces/"+item.namespace+"/pods", pod, nil); err != nil {
+		return err
+	}
+
+	return waitPodReady(ctx, item.client, item.namespace, item.pod, 3*time.Minute)
+}
+
+// handleNodeShellTerminal opens the shell of a node shell over a WebSocket,
+// with the protocol of the host terminal. The debug pod is removed when the
+// shell exits or the client disconnects.
+func (s *Server) handleNodeShellTerminal(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	c, ok := s.kubernetesContext(r.PathValue("context"))
+
+	if !ok {
+		writeError(w, r, errContextNotFound, http.StatusNotFound)
+		return
+	}
+
+	item, ok := s.nodeShells.claim(c.Name, r.PathValue("node"), r.PathValue("id"), ownerID(auth))
+
+	if !ok {
+		http.Error(w, "node shell not found", http.StatusNotFound)
+		return
+	}
+
+	defer item.release()
+
+	w, r, done, err := s.trackSession(w, r, &Context{Type: "kubernetes", Name: c.Name}, auth)
+
+	if err != nil {
+		writeError(w, r, err, http.StatusTooManyRequests)
+		return
+	}
+
+	defer done()
+
+	executor, err := s.podExecutor(r.Context(), c.Name, auth, item.namespace, item.pod, "shell", nodeShellCommand, true, true)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	cols, _ := strconv.Atoi(r.URL.Query().Get("cols"))
+	rows, _ := strconv.Atoi(r.URL.Query().Get("rows"))
+
+	if cols <= 0 || rows <= 0 {
+		cols, rows = 80, 24
+	}
+
+	conn, err := terminalUpgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	sizes := &terminalSizeQueue{
+		sizes: make(chan remotecommand.TerminalSize, 1),
+		done:  ctx.Done(),
+	}
+
+	sizes.sizes <- remotecommand.TerminalSize{Width: uint16(cols), Height: uint16(rows)}
+
+	var mu sync.Mutex
+
+	output := writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err := conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+			return 0, err
+		}
+
+		return len(p), nil
+	})
+
+	stdin, input := io.Pipe()
+
+	go func() {
+		// ends the shell if the session is killed or the client disconnects
+		defer cancel()
+		defer input.Close()
+
+		for {
+			kind, data, err := conn.ReadMessage()
+
+			if err != nil {
+				return
+			}
+
+			if kind == websocket.BinaryMessage {
+				if _, err := input.Write(data); err != nil {
+					return
+				}
+
+				continue
+			}
+
+			var message terminalMessage
+
+			if err := json.Unmarshal(data, &message); err != nil {
+				continue
+			}
+
+			if message.Type == "resize" && message.Cols > 0 && message.Rows > 0 {
+				sizes.push(remotecommand.TerminalSize{Width: uint16(message.Cols), Height: uint16(message.Rows)})
+			}
+		}
+	}()
+
+	s.audit.record(&AuditEntry{
+		Context: c.Name,
+		Owner:   ownerID(auth),
+		Action:  "node-terminal",
+
+		Resource: "nodes",
+		Name:     item.node,
+	})
+
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: output,
+		Tty:    true,
+
+		TerminalSizeQueue: sizes,
+	})
+
+	stdin.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	message := "shell exited"
+
+	if err != nil {
+		message = err.Error()
+	}
+
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, message))
+}
+
+// terminalSizeQueue passes resizes of a terminal to an exec stream; only the
+// latest pending size is kept.
+type terminalSizeQueue struct {
+	sizes chan remotecommand.TerminalSize
+	done  <-chan struct{}
+}
+
+func (q *terminalSizeQueue) push(size remotecommand.TerminalSize) {
+	select {
+	case <-q.sizes:
+	default:
+	}
+
+	select {
+	case q.sizes <- size:
+	default:
+	}
+}
+
+func (q *terminalSizeQueue) Next() *remotecommand.TerminalSize {
+	select {
+	case size := <-q.sizes:
+		return &size
+
+	case <-q.done:
+		return nil
+	}
+}
+
+// isNodeShellTerminal reports whether a path is the terminal of a node shell.
+func isNodeShellTerminal(path string) bool {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	return len(parts) == 6 && parts[0] == "contexts" && parts[2] == "nodes" && parts[4] == "shell"
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+// handlePrometheusProxy proxies the HTTP API of the Prometheus of a context,
+// e.g. /api/v1/query_range, so the UI can render workload metrics. The
+// Prometheus configured for the context is used, an in-cluster service or a
+// URL, or else the one detected in the cluster. The admin APIs, which delete
+// series, are not proxied.
+func (s *Server) handlePrometheusProxy(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	c, ok := s.kubernetesContext(r.PathValue("context"))
+
+	if !ok {
+		writeError(w, r, errContextNotFound, http.StatusNotFound)
+		return
+	}
+
+	if !endpointMethodAllowed(w, r) {
+		return
+	}
+
+	if strings.HasPrefix(r.PathValue("path"), "admin/") {
+		http.Error(w, "the admin APIs of Prometheus are not proxied", http.StatusForbidden)
+		return
+	}
+
+	prefix, path := endpointPath(r, "/prometheus")
+
+	e, configured := s.config.ContextPrometheus(c.Name)
+
+	var ref *ServiceRef
+
+	if !configured {
+		ref = s.contextCapabilities(r.Context(), c.Name, auth).Prometheus
+	}
+
+	if !configured && ref == nil {
+		writeError(w, r, i18n.NewError("error.prometheus_not_found", c.Name), http.StatusNotFound)
+		return
+	}
+
+	s.serveEndpoint(w, r, c, auth, e, ref, prefix, path)
+}
+
+// handleLokiProxy proxies the HTTP API of the Loki of a context, e.g.
+// /api/v1/query_range or the tail websocket, to search logs beyond those of
+// running containers. As with Prometheus, the Loki configured for the
+// context is used, or else the one detected in the cluster. Pushing and
+// deleting logs is not proxied.
+func (s *Server) handleLokiProxy(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	c, ok := s.kubernetesContext(r.PathValue("context"))
+
+	if !ok {
+		writeError(w, r, errContextNotFound, http.StatusNotFound)
+		return
+	}
+
+	if !endpointMethodAllowed(w, r) {
+		return
+	}
+
+	switch strings.TrimSuffix(r.PathValue("path"), "/") {
+	case "push", "delete":
+		http.Error(w, "pushing and deleting logs is not proxied", http.StatusForbidden)
+		return
+	}
+
+	prefix, path := endpointPath(r, "/loki")
+
+	// the API of Loki is served below /loki
+	path = "/loki" + path
+
+	e, configured := s.config.ContextLoki(c.Name)
+
+	var ref *ServiceRef
+
+	if !configured {
+		ref = s.contextCapabilities(r.Context(), c.Name, auth).Loki
+	}
+
+	if !configured && ref == nil {
+		writeError(w, r, i18n.NewError("error.loki_not_found", c.Name), http.StatusNotFound)
+		return
+	}
+
+	s.serveEndpoint(w, r, c, auth, e, ref, prefix, path)
+}
+
+// endpointMethodAllowed allows the methods of queries, which may be posted
+// as forms.
+func endpointMethodAllowed(w http.ResponseWriter, r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost:
+		return true
+	}
+
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+// endpointPath splits the escaped path of a request into the prefix the
+// endpoint is served at, e.g. /contexts/{context}/loki, and the path of its
+// API.
+func endpointPath(r *http.Request, segment string) (string, string) {
+	// the remaining path is forwarded as escaped by the browser
+	escaped := r.URL.EscapedPath()
+
+	context, _, _ := strings.Cut(strings.TrimPrefix(escaped, "/contexts/"), "/")
+
+	prefix := "/contexts/" + context + segment
+
+	return prefix, strings.TrimPrefix(escaped, prefix)
+}
+
+// serveEndpoint serves a request of a Prometheus or Loki, at the URL or
+// service of its config, or at its detected service. Services are reached
+// through the service proxy of the API server, falling back to a
+// port-forward, or always through a port-forward if configured.
+func (s *Server) serveEndpoint(w http.ResponseWriter, r *http.Request, c config.KubernetesContext, auth *config.AuthInfo, e *config.EndpointConfig, ref *ServiceRef, prefix, path string) {
+	stripBridgeCredentials(r.Header)
+
+	if e != nil {
+		for key, value := range e.Headers {
+			r.Header.Set(key, value)
+		}
+
+		if e.URL != "" {
+			serveEndpointURL(w, r, e, prefix, path)
+			return
+		}
+
+		ref = endpointServiceRef(e)
+	}
+
+	port := strconv.Itoa(int(ref.Port))
+	service := ref.Scheme + ":" + ref.Name + ":" + port
+
+	if e != nil && e.PortForward {
+		// port-forwards are subject to the protection of the namespace
+		if err := s.checkProtection(r, c.Name, ref.Namespace); err != nil {
+			writeProtectionError(w, r, err)
+			return
+		}
+
+		forward := s.serviceForward(c.Name, auth, serviceKey(c.Name, auth, ref.Namespace, service), ref.Namespace, ref.Scheme, ref.Name, port, prefix)
+
+		r.URL.RawPath = path
+		r.URL.Path, _ = url.PathUnescape(path)
+
+		forward.proxy.ServeHTTP(w, r)
+		return
+	}
+
+	s.serveService(w, r, c, auth, ref.Namespace, service, prefix, path)
+}
+
+// serveEndpointURL serves a request of an endpoint outside of the cluster.
+func serveEndpointURL(w http.ResponseWriter, r *http.Request, e *config.EndpointConfig, prefix, path string) {
+	// validated with the config
+	target, _ := url.Parse(e.URL)
+
+	proxy := &httputil.ReverseProxy{
+		FlushInterval: -1,
+
+		ErrorLog: log.New(log.Writer(), "proxy: ", log.LstdFlags),
+
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+
+			pr.Out.Host = target.Host
+			pr.Out.Header.Set("X-Forwarded-Prefix", prefix)
+		},
+
+		ModifyResponse: func(resp *http.Response) error {
+			rewriteServiceLocation(resp, prefix, target.Path)
+			return nil
+		},
+
+		ErrorHandler: limitErrorHandler,
+	}
+
+	r.URL.RawPath = path
+	r.URL.Path, _ = url.PathUnescape(path)
+
+	proxy.ServeHTTP(w, r)
+}
+
+// endpointServiceRef references the service of a configured endpoint.
+func endpointServiceRef(e *config.EndpointConfig) *ServiceRef {
+	if e.Service == "" {
+		return nil
+	}
+
+	ref := &ServiceRef{
+		Namespace: e.Namespace,
+		Name:      e.Service,
+
+		Scheme: e.Scheme,
+		Port:   int32(e.Port),
+	}
+
+	if ref.Scheme == "" {
+		ref.Scheme = portForwardScheme("", e.Port)
+	}
+
+	return ref
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"strings"
+)
+
+// kubernetesRequest describes a request against the kubernetes REST API,
+// e.g. /apis/apps/v1/namespaces/default/deployments/web/scale.
+type kubernetesRequest struct {
+	Group   string
+	Version string
+
+	Namespace string
+
+	Resource    string
+	Name        string
+	Subresource string
+}
+
+func parseKubernetesPath(path string) (*kubernetesRequest, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	r := &kubernetesRequest{}
+
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		r.Version = parts[1]
+		parts = parts[2:]
+
+	case len(parts) >= 3 && parts[0] == "apis":
+		r.Group = parts[1]
+		r.Version = parts[2]
+		parts = parts[3:]
+
+	default:
+		return nil, false
+	}
+
+	if len(parts) == 0 {
+		return nil, false
+	}
+
+	// namespaces/{namespace}/{resource}/... except the namespace object itself
+	if parts[0] == "namespaces" && len(parts) >= 3 && parts[2] != "status" && parts[2] != "finalize" {
+		r.Namespace = parts[1]
+		parts = parts[2:]
+	}
+
+	r.Resource = parts[0]
+
+	if len(parts) > 1 {
+		r.Name = parts[1]
+	}
+
+	if len(parts) > 2 {
+		r.Subresource = strings.Join(parts[2:], "/")
+	}
+
+	return r, true
+}
+
+// IsList reports whether the request targets a collection.
+func (r *kubernetesRequest) IsList() bool {
+	return r.Name == ""
+}
+
+// GroupVersion returns the api version in the apiVersion notation.
+func (r *kubernetesRequest) GroupVersion() string {
+	if r.Group == "" {
+		return r.Version
+	}
+
+	return r.Group + "/" + r.Version
+}
+
+// Path returns the REST path of the request, e.g.
+// /apis/apps/v1/namespaces/default/deployments/web/scale.
+func (r *kubernetesRequest) Path() string {
+	path := "/api/" + r.Version
+
+	if r.Group != "" {
+		path = "/apis/" + r.Group + "/" + r.Version
+	}
+
+	if r.Namespace != "" {
+		path += "/namespaces/" + r.Namespace
+	}
+
+	path += "/" + r.Resource
+
+	if r.Name != "" {
+		path += "/" + r.Name
+	}
+
+	if r.Subresource != "" {
+		path += "/" + r.Subresource
+	}
+
+	return path
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestParseKubernetesPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want *kubernetesRequest
+	}{
+		{
+			path: "/api/v1/pods",
+			want: &kubernetesRequest{Version: "v1", Resource: "pods"},
+		},
+		{
+			path: "/api/v1/namespaces/default/pods",
+			want: &kubernetesRequest{Version: "v1", Namespace: "default", Resource: "pods"},
+		},
+		{
+			path: "/api/v1/namespaces/default/pods/web-0/exec",
+			want: &kubernetesRequest{Version: "v1", Namespace: "default", Resource: "pods", Name: "web-0", Subresource: "exec"},
+		},
+		{
+			path: "/api/v1/namespaces/default/services/web:http/proxy/metrics",
+			want: &kubernetesRequest{Version: "v1", Namespace: "default", Resource: "services", Name: "web:http", Subresource: "proxy/metrics"},
+		},
+		{
+			path: "/apis/apps/v1/namespaces/default/deployments/web/scale",
+			want: &kubernetesRequest{Group: "apps", Version: "v1", Namespace: "default", Resource: "deployments", Name: "web", Subresource: "scale"},
+		},
+		{
+			path: "/apis/rbac.authorization.k8s.io/v1/clusterroles/admin/",
+			want: &kubernetesRequest{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles", Name: "admin"},
+		},
+		{
+			path: "/api/v1/namespaces",
+			want: &kubernetesRequest{Version: "v1", Resource: "namespaces"},
+		},
+		{
+			path: "/api/v1/namespaces/default",
+			want: &kubernetesRequest{Version: "v1", Resource: "namespaces", Name: "default"},
+		},
+		{
+			// the namespace object itself, not a resource in the namespace
+			path: "/api/v1/namespaces/default/status",
+			want: &kubernetesRequest{Version: "v1", Resource: "namespaces", Name: "default", Subresource: "status"},
+		},
+		{
+			path: "/api/v1/namespaces/default/finalize",
+			want: &kubernetesRequest{Version: "v1", Resource: "namespaces", Name: "default", Subresource: "finalize"},
+		},
+		{path: "/"},
+		{path: "/api"},
+		{path: "/api/v1"},
+		{path: "/apis/apps"},
+		{path: "/apis/apps/v1"},
+		{path: "/version"},
+		{path: "/healthz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := parseKubernetesPath(tt.path)
+
+			if tt.want == nil {
+				if ok {
+					t.Fatalf("expected no match, got %+v", got)
+				}
+
+				return
+			}
+
+			if !ok {
+				t.Fatal("expected a match")
+			}
+
+			if *got != *tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKubernetesRequestPath(t *testing.T) {
+	paths := []string{
+		"/api/v1/pods",
+		"/api/v1/namespaces/default/pods/web-0/log",
+		"/apis/apps/v1/namespaces/default/deployments/web/scale",
+		"/apis/rbac.authorization.k8s.io/v1/clusterroles/admin",
+	}
+
+	for _, path := range paths {
+		req, ok := parseKubernetesPath(path)
+
+		if !ok {
+			t.Fatalf("%s: expected a match", path)
+		}
+
+		if got := req.Path(); got != path {
+			t.Errorf("%s: path = %s", path, got)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+)
+
+// maxPermissionChecks bounds the reviews of a single request.
+const maxPermissionChecks = 256
+
+// permissionKey identifies a check in the result unless the caller chose a
+// key, e.g. "create pods/exec default" or "patch deployments.apps".
+func permissionKey(c PermissionCheck) string {
+	if c.Key != "" {
+		return c.Key
+	}
+
+	key := c.Verb + " " + c.Resource
+
+	if c.Group != "" {
+		key += "." + c.Group
+	}
+
+	if c.Subresource != "" {
+		key += "/" + c.Subresource
+	}
+
+	if c.Namespace != "" {
+		key += " " + c.Namespace
+	}
+
+	if c.Name != "" {
+		key += " " + c.Name
+	}
+
+	return key
+}
+
+// handleCheckPermissions runs a SelfSubjectAccessReview per check of the
+// request in parallel, so a UI can decide which actions to offer with a
+// single call. Checks without namespace are evaluated cluster-wide.
+func (s *Server) handleCheckPermissions(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	var req PermissionRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Checks) > maxPermissionChecks {
+		http.Error(w, "too many checks", http.StatusBadRequest)
+		return
+	}
+
+	for _, c := range req.Checks {
+		if c.Verb == "" || c.Resource == "" {
+			http.Error(w, "verb and resource are required", http.StatusBadRequest)
+			return
+		}
+	}
+
+	client, err := s.kubernetesClient(r.Context(), r.PathValue("context"), auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := &PermissionResult{
+		Permissions: map[string]bool{},
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	sem := make(chan struct{}, 16)
+
+	seen := map[string]bool{}
+
+	for _, c := range req.Checks {
+		key := permissionKey(c)
+
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			allowed, err := accessAllowed(r.Context(), client, authorizationv1.ResourceAttributes{
+				Verb:        strings.ToLower(c.Verb),
+				Group:       c.Group,
+				Resource:    c.Resource,
+				Subresource: c.Subresource,
+				Namespace:   c.Namespace,
+				Name:        c.Name,
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				if result.Errors == nil {
+					result.Errors = map[string]string{}
+				}
+
+				// unknown permissions are reported as denied
+				result.Errors[key] = statusMessage(err)
+			}
+
+			result.Permissions[key] = allowed
+		}()
+	}
+
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
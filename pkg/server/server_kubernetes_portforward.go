@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// portForwardDialer opens connections to a pod port over a single
+// port-forward session of the API server.
+type portForwardDialer struct {
+	mu   sync.Mutex
+	conn httpstream.Connection
+
+	port      int
+	requestID int
+}
+
+func (s *Server) portForward(ctx context.Context, name string, auth *config.AuthInfo, namespace, pod string, port int) (*portForwardDialer, error) {
+	c, ok := s.kubernetesContext(name)
+
+	if !ok {
+		return nil, errContextNotFound
+	}
+
+	config, err := s.kubernetesConfig(ctx, c, auth)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rt, upgrader, err := spdy.RoundTripperFor(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	target, path, err := rest.DefaultServerUrlFor(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	target.Path = strings.TrimSuffix(target.Path, "/") + strings.TrimSuffix(path, "/") + "/api/v1/namespaces/" + namespace + "/pods/" + pod + "/portforward"
+
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: rt}, http.MethodPost, target)
+
+	conn, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to port-forward to %s/%s: %w", namespace, pod, err)
+	}
+
+	return &portForwardDialer{
+		conn: conn,
+		port: port,
+	}, nil
+}
+
+// Dial opens a new stream to the forwarded port.
+func (d *portForwardDialer) Dial() (net.Conn, error) {
+	d.mu.Lock()
+	id := d.requestID
+	d.requestID++
+	d.mu.Unlock()
+
+	headers := http.Header{}
+	headers.Set(corev1.StreamType, corev1.StreamTypeError)
+	headers.Set(corev1.PortHeader, strconv.Itoa(d.port))
+	headers.Set(corev1.PortForwardRequestIDHeader, strconv.Itoa(id))
+
+	errorStream, err := d.conn.CreateStream(headers)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the error stream is read-only
+	errorStream.Close()
+
+	headers.Set(corev1.StreamType, corev1.StreamTypeData)
+
+	dataStream, err := d.conn.CreateStream(headers)
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &portForwardConn{
+		Stream: dataStream,
+		port:   d.port,
+	}
+
+	go func() {
+		message, _ := io.ReadAll(errorStream)
+
+		if len(message) > 0 {
+			conn.err = fmt.Errorf("port-forward: %s", message)
+			dataStream.Reset()
+		}
+	}()
+
+	return conn, nil
+}
+
+func (d *portForwardDialer) Close() error {
+	return d.conn.Close()
+}
+
+// Done is closed when the port-forward session ends.
+func (d *portForwardDialer) Done() <-chan bool {
+	return d.conn.CloseChan()
+}
+
+// podTunnel dials a port of a pod through a port-forward, which is opened on
+// first use and again after it broke, e.g. when the pod was replaced.
+type podTunnel struct {
+	server *Server
+	auth   *config.AuthInfo
+
+	context   string
+	namespace string
+
+	// target resolves the pod and port to forward to
+	target func(ctx context.Context) (string, int, error)
+
+	mu      sync.Mutex
+	pod     string
+	forward *portForwardDialer
+}
+
+func (t *podTunnel) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.forward != nil {
+		select {
+		case <-t.forward.Done():
+			t.forward = nil
+		default:
+		}
+	}
+
+	if t.forward == nil {
+		pod, port, err := t.target(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		forward, err := t.server.portForward(ctx, t.context, t.auth, t.namespace, pod, port)
+
+		if err != nil {
+			return nil, err
+		}
+
+		t.pod = pod
+		t.forward = forward
+	}
+
+	conn, err := t.forward.Dial()
+
+	if err != nil {
+		t.forward.Close()
+		t.forward = nil
+	}
+
+	return conn, err
+}
+
+// Pod returns the pod of the current port-forward.
+func (t *podTunnel) Pod() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.pod
+}
+
+func (t *podTunnel) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.forward == nil {
+		return nil
+	}
+
+	err := t.forward.Close()
+	t.forward = nil
+
+	return err
+}
+
+// readyPod returns a ready pod matching a label selector, or nil.
+func readyPod(ctx context.Context, client *kubernetesClient, namespace, selector string) (*corev1.Pod, error) {
+	var pods corev1.PodList
+
+	query := url.Values{
+		"labelSelector": {selector},
+	}
+
+	if err := client.get(ctx, "/api/v1/namespaces/"+namespace+"/pods", query, &pods); err != nil {
+		return nil, err
+	}
+
+	for i, p := range pods.Items {
+		if p.Status.Phase != corev1.PodRunning || p.DeletionTimestamp != nil {
+			continue
+		}
+
+		for _, c := range p.Status.Conditions {
+			if c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue {
+				return &pods.Items[i], nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// portForwardConn adapts a port-forward data stream to net.Conn.
+type portForwardConn struct {
+	httpstream.Stream
+
+	port int
+	err  error
+}
+
+func (c *portForwardConn) Read(p []byte) (int, error) {
+	n, err := c.Stream.Read(p)
+
+	if err != nil && c.err != nil {
+		err = c.err
+	}
+
+	return n, err
+}
+
+func (c *portForwardConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+func (c *portForwardConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: c.port}
+}
+
+func (c *portForwardConn) SetDeadline(t time.Time) error {
+	return errors.ErrUnsupported
+}
+
+func (c *portForwardConn) SetReadDeadline(t time.Time) error {
+	return errors.ErrUnsupported
+}
+
+func (c *portForwardConn) SetWriteDeadline(t time.Time) error {
+	return errors.ErrUnsupported
+}
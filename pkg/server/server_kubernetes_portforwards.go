@@ -0,0 +1,456 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+var (
+	errPortForwardNotFound  = i18n.NewError("error.portforward_not_found")
+	errPortForwardPortInUse = errors.New("local port in use")
+)
+
+// portForwards holds the port-forwards exposed on local ports, and those
+// saved in the store, which are restored in the background.
+type portForwards struct {
+	mu    sync.Mutex
+	items map[string]*portForwardTunnel
+	saved map[string]*savedPortForward
+}
+
+func (p *portForwards) add(item *portForwardTunnel) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.items == nil {
+		p.items = make(map[string]*portForwardTunnel)
+	}
+
+	p.items[item.id] = item
+}
+
+func (p *portForwards) remove(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.items, id)
+}
+
+func (p *portForwards) get(id string) (*portForwardTunnel, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	item, ok := p.items[id]
+	return item, ok
+}
+
+func (p *portForwards) list() []*portForwardTunnel {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	result := make([]*portForwardTunnel, 0, len(p.items))
+
+	for _, item := range p.items {
+		result = append(result, item)
+	}
+
+	return result
+}
+
+// portForwardTunnel forwards the connections of a local port to a pod, or to
+// a ready pod of a service, like kubectl port-forward. Pods of services are
+// selected again once the port-forward broke.
+type portForwardTunnel struct {
+	id    string
+	owner string
+
+	context   string
+	namespace string
+	service   string
+
+	port   int
+	scheme string
+
+	listener net.Listener
+	tunnel   *podTunnel
+
+	created time.Time
+
+	connections atomic.Int64
+
+	release   func()
+	closeOnce sync.Once
+	onClose   func()
+}
+
+func (t *portForwardTunnel) info() PortForwardInfo {
+	local := t.listener.Addr().String()
+
+	return PortForwardInfo{
+		ID:     t.id,
+		Status: "active",
+
+		Context:   t.context,
+		Namespace: t.namespace,
+		Service:   t.service,
+		Pod:       t.tunnel.Pod(),
+
+		Port:         t.port,
+		LocalAddress: local,
+		URL:          t.scheme + "://" + local,
+
+		Connections: t.connections.Load(),
+		Created:     t.created,
+	}
+}
+
+// Close stops listening and ends the port-forward.
+func (t *portForwardTunnel) Close() error {
+	t.closeOnce.Do(func() {
+		if t.onClose != nil {
+			t.onClose()
+		}
+
+		t.listener.Close()
+		t.tunnel.Close()
+	})
+
+	return nil
+}
+
+func (t *portForwardTunnel) serve() {
+	for {
+		local, err := t.listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		t.connections.Add(1)
+
+		go func() {
+			defer local.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			remote, err := t.tunnel.DialContext(ctx, "tcp", "")
+			cancel()
+
+			if err != nil {
+				log.Printf("port-forward %s: failed to connect to %s/%s: %v", t.id, t.namespace, t.tunnel.Pod(), err)
+				return
+			}
+
+			defer remote.Close()
+
+			go io.Copy(remote, local)
+			io.Copy(local, remote)
+		}()
+	}
+}
+
+// portForwardTarget returns a function resolving the pod and port of a
+// request, which selects a ready pod for services.
+func (s *Server) portForwardTarget(name string, auth *config.AuthInfo, req *PortForwardRequest) func(ctx context.Context) (string, int, error) {
+	return func(ctx context.Context) (string, int, error) {
+		client, err := s.kubernetesClient(ctx, name, auth)
+
+		if err != nil {
+			return "", 0, err
+		}
+
+		if req.Pod != "" {
+			var pod corev1.Pod
+
+			if err := client.get(ctx, "/api/v1/namespaces/"+req.Namespace+"/pods/"+req.Pod, nil, &pod); err != nil {
+				return "", 0, err
+			}
+
+			port, ok := containerPort(&pod, intstr.Parse(req.Port))
+
+			if !ok {
+				return "", 0, i18n.NewError("error.portforward_port_not_found", req.Port, req.Pod)
+			}
+
+			return pod.Name, port, nil
+		}
+
+		var service corev1.Service
+
+		if err := client.get(ctx, "/api/v1/namespaces/"+req.Namespace+"/services/"+req.Service, nil, &service); err != nil {
+			return "", 0, err
+		}
+
+		servicePort, ok := findServicePort(service.Spec.Ports, req.Port)
+
+		if !ok {
+			return "", 0, i18n.NewError("error.portforward_port_not_found", req.Port, req.Service)
+		}
+
+		if len(service.Spec.Selector) == 0 {
+			return "", 0, i18n.NewError("error.portforward_no_ready_pod", req.Service)
+		}
+
+		pod, err := readyPod(ctx, client, req.Namespace, labels.SelectorFromSet(service.Spec.Selector).String())
+
+		if err != nil {
+			return "", 0, err
+		}
+
+		if pod == nil {
+			return "", 0, i18n.NewError("error.portforward_no_ready_pod", req.Service)
+		}
+
+		target := servicePort.TargetPort
+
+		if target.Type == intstr.Int && target.IntVal == 0 {
+			target = intstr.FromInt32(servicePort.Port)
+		}
+
+		port, ok := containerPort(pod, target)
+
+		if !ok {
+			return "", 0, i18n.NewError("error.portforward_port_not_found", target.String(), pod.Name)
+		}
+
+		return pod.Name, port, nil
+	}
+}
+
+// containerPort resolves a port number or the name of a container port.
+func containerPort(pod *corev1.Pod, port intstr.IntOrString) (int, bool) {
+	if port.Type == intstr.Int {
+		return port.IntValue(), port.IntValue() > 0
+	}
+
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == port.StrVal {
+				return int(p.ContainerPort), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// portForwardScheme guesses the scheme of a port to open it in a browser.
+func portForwardScheme(name string, port int) string {
+	if port == 443 || port == 8443 || strings.HasPrefix(name, "https") {
+		return "https"
+	}
+
+	return "http"
+}
+
+// startPortForward resolves the target of a request and listens on its
+// local port, of which 0 picks a free port.
+func (s *Server) startPortForward(ctx context.Context, id, owner, name string, auth *config.AuthInfo, req *PortForwardRequest, created time.Time) (*portForwardTunnel, error) {
+	target := s.portForwardTarget(name, auth, req)
+
+	// resolved once up front, so invalid requests fail right away
+	pod, port, err := target(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(req.LocalPort)))
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errPortForwardPortInUse, err)
+	}
+
+	item := &portForwardTunnel{
+		id:    id,
+		owner: owner,
+
+		context:   name,
+		namespace: req.Namespace,
+		service:   req.Service,
+
+		port:   port,
+		scheme: portForwardScheme(req.Port, port),
+
+		listener: l,
+
+		tunnel: &podTunnel{
+			server: s,
+			auth:   auth,
+
+			context:   name,
+			namespace: req.Namespace,
+
+			target: target,
+
+			pod: pod,
+		},
+
+		created: created,
+	}
+
+	item.onClose = func() {
+		s.portForwards.remove(item.id)
+	}
+
+	item.release = s.track(name, item)
+	s.portForwards.add(item)
+
+	go item.serve()
+
+	return item, nil
+}
+
+// handleCreatePortForward exposes a port of a pod or service on a local port
+// of the bridge host. A local port of 0 picks a free port. Port-forwards of
+// contexts of the kubeconfig are saved and restored on the next start.
+func (s *Server) handleCreatePortForward(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+
+	var req PortForwardRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Namespace == "" || (req.Pod == "") == (req.Service == "") {
+		http.Error(w, "namespace and either pod or service are required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Pod != "" && req.Port == "" {
+		http.Error(w, "port is required for pods", http.StatusBadRequest)
+		return
+	}
+
+	if req.LocalPort < 0 || req.LocalPort > 65535 {
+		http.Error(w, "invalid localPort", http.StatusBadRequest)
+		return
+	}
+
+	c, ok := s.kubernetesContext(name)
+
+	if !ok {
+		writeError(w, r, errContextNotFound, http.StatusNotFound)
+		return
+	}
+
+	if s.portForwards.conflicts(req.LocalPort) {
+		http.Error(w, errPortForwardPortInUse.Error()+": reserved by a saved port-forward", http.StatusConflict)
+		return
+	}
+
+	id := make([]byte, 4)
+	rand.Read(id)
+
+	item, err := s.startPortForward(r.Context(), hex.EncodeToString(id), ownerID(auth), c.Name, auth, &req, time.Now())
+
+	if err != nil {
+		if errors.Is(err, errPortForwardPortInUse) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		if _, ok := err.(*i18n.Error); ok {
+			writeError(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		writeClientError(w, r, err)
+		return
+	}
+
+	if !c.Dynamic {
+		s.savePortForward(item, auth, &req)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	json.NewEncoder(w).Encode(s.portForwardInfo(item))
+}
+
+func (s *Server) handleListPortForwards(w http.ResponseWriter, r *http.Request) {
+	owner := ownerID(AuthInfoFromContext(r.Context()))
+	context := r.URL.Query().Get("context")
+
+	result := []PortForwardInfo{}
+
+	for _, item := range s.portForwards.list() {
+		if item.owner != owner {
+			continue
+		}
+
+		if context != "" && !strings.EqualFold(item.context, context) {
+			continue
+		}
+
+		result = append(result, s.portForwardInfo(item))
+	}
+
+	// saved port-forwards that are not running (yet)
+	for _, saved := range s.portForwards.pending() {
+		if saved.Owner != owner {
+			continue
+		}
+
+		if context != "" && !strings.EqualFold(saved.Context, context) {
+			continue
+		}
+
+		result = append(result, saved.info())
+	}
+
+	slices.SortFunc(result, func(a, b PortForwardInfo) int {
+		return a.Created.Compare(b.Created)
+	})
+
+	writeList(w, r, "portforwards", result, result)
+}
+
+func (s *Server) handleDeletePortForward(w http.ResponseWriter, r *http.Request) {
+	owner := ownerID(AuthInfoFromContext(r.Context()))
+
+	id := r.PathValue("id")
+
+	item, ok := s.portForwards.get(id)
+	saved, persisted := s.portForwards.savedItem(id)
+
+	if (!ok || item.owner != owner) && (!persisted || saved.Owner != owner) {
+		writeError(w, r, errPortForwardNotFound, http.StatusNotFound)
+		return
+	}
+
+	if persisted {
+		s.portForwards.unsave(id)
+	}
+
+	if ok {
+		item.release()
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+const (
+	// portForwardsState keeps the port-forwards restored on the next start
+	portForwardsState = "portforwards"
+
+	// portForwardRestoreInterval is the interval in which saved
+	// port-forwards that are not running are restored
+	portForwardRestoreInterval = 10 * time.Second
+)
+
+// savedPortForward is a port-forward kept in the store. It keeps the local
+// port first bound, so restored port-forwards keep their address.
+type savedPortForward struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner,omitempty"`
+
+	Context string             `json:"context"`
+	Request PortForwardRequest `json:"request"`
+
+	// the caller and impersonation the port-forward was created with,
+	// without credentials
+	User              string   `json:"user,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+	ImpersonateUser   string   `json:"impersonateUser,omitempty"`
+	ImpersonateGroups []string `json:"impersonateGroups,omitempty"`
+
+	Port    int       `json:"port"`
+	Scheme  string    `json:"scheme"`
+	Created time.Time `json:"created"`
+
+	// status of the last restore
+	status string
+	err    string
+}
+
+func (p *savedPortForward) auth() *config.AuthInfo {
+	if p.User == "" && p.ImpersonateUser == "" {
+		return nil
+	}
+
+	return &config.AuthInfo{
+		User:   p.User,
+		Groups: p.Groups,
+
+		ImpersonateUser:   p.ImpersonateUser,
+		ImpersonateGroups: p.ImpersonateGroups,
+	}
+}
+
+func (p *savedPortForward) info() PortForwardInfo {
+	local := net.JoinHostPort("127.0.0.1", strconv.Itoa(p.Request.LocalPort))
+
+	return PortForwardInfo{
+		ID:     p.ID,
+		Status: p.status,
+		Error:  p.err,
+
+		Saved: true,
+
+		Context:   p.Context,
+		Namespace: p.Request.Namespace,
+		Service:   p.Request.Service,
+		Pod:       p.Request.Pod,
+
+		Port:         p.Port,
+		LocalAddress: local,
+		URL:          p.Scheme + "://" + local,
+
+		Created: p.Created,
+	}
+}
+
+// loadSaved reads the saved port-forwards from the store.
+func (p *portForwards) loadSaved() {
+	var items []*savedPortForward
+
+	if err := loadState(portForwardsState, &items); err != nil {
+		log.Printf("failed to load port-forwards: %v", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.saved = make(map[string]*savedPortForward)
+
+	for _, item := range items {
+		item.status = "waiting"
+		p.saved[item.ID] = item
+	}
+}
+
+// persist writes the saved port-forwards to the store; p.mu must be held.
+func (p *portForwards) persist() {
+	items := make([]*savedPortForward, 0, len(p.saved))
+
+	for _, item := range p.saved {
+		items = append(items, item)
+	}
+
+	slices.SortFunc(items, func(a, b *savedPortForward) int {
+		return a.Created.Compare(b.Created)
+	})
+
+	if err := saveState(portForwardsState, items); err != nil {
+		log.Printf("failed to save port-forwards: %v", err)
+	}
+}
+
+func (p *portForwards) save(item *savedPortForward) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.saved == nil {
+		p.saved = make(map[string]*savedPortForward)
+	}
+
+	p.saved[item.ID] = item
+	p.persist()
+}
+
+func (p *portForwards) unsave(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.saved[id]; !ok {
+		return
+	}
+
+	delete(p.saved, id)
+	p.persist()
+}
+
+// resetSaved forgets the saved port-forwards, running ones keep running.
+func (p *portForwards) resetSaved() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.saved = make(map[string]*savedPortForward)
+}
+
+func (p *portForwards) savedItem(id string) (savedPortForward, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	item, ok := p.saved[id]
+
+	if !ok {
+		return savedPortForward{}, false
+	}
+
+	return *item, true
+}
+
+// pending returns the saved port-forwards that are not running.
+func (p *portForwards) pending() []savedPortForward {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var result []savedPortForward
+
+	for id, item := range p.saved {
+		if _, ok := p.items[id]; ok {
+			continue
+		}
+
+		result = append(result, *item)
+	}
+
+	slices.SortFunc(result, func(a, b savedPortForward) int {
+		return a.Created.Compare(b.Created)
+	})
+
+	return result
+}
+
+func (p *portForwards) setStatus(id, status string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	item, ok := p.saved[id]
+
+	if !ok {
+		return
+	}
+
+	item.status = status
+	item.err = ""
+
+	if err != nil {
+		item.err = err.Error()
+	}
+}
+
+// conflicts reports whether a local port is reserved by a saved
+// port-forward that is not running.
+func (p *portForwards) conflicts(port int) bool {
+	if port == 0 {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id, item := range p.saved {
+		if _, ok := p.items[id]; ok {
+			continue
+		}
+
+		if item.Request.LocalPort == port {
+			return true
+		}
+	}
+
+	return false
+}
+
+// savePortForward keeps a port-forward in the store, pinned to its local
+// port.
+func (s *Server) savePortForward(item *portForwardTunnel, auth *config.AuthInfo, req *PortForwardRequest) {
+	saved := &savedPortForward{
+		ID:    item.id,
+		Owner: item.owner,
+
+		Context: item.context,
+		Request: *req,
+
+		Port:    item.port,
+		Scheme:  item.scheme,
+		Created: item.created,
+	}
+
+	saved.Request.LocalPort = item.listener.Addr().(*net.TCPAddr).Port
+
+	if auth != nil {
+		saved.User = auth.User
+		saved.Groups = auth.Groups
+
+		saved.ImpersonateUser = auth.ImpersonateUser
+		saved.ImpersonateGroups = auth.ImpersonateGroups
+	}
+
+	s.portForwards.save(saved)
+}
+
+func (s *Server) portForwardInfo(item *portForwardTunnel) PortForwardInfo {
+	info := item.info()
+	_, info.Saved = s.portForwards.savedItem(item.id)
+
+	return info
+}
+
+// restorePortForwards re-establishes saved port-forwards after a start, and
+// once their context is back (e.g. after a kubeconfig reload or while it was
+// offline). Local ports taken by other processes are reported as conflict
+// and retried.
+func (s *Server) restorePortForwards(done <-chan struct{}) {
+	ticker := time.NewTicker(portForwardRestoreInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, saved := range s.portForwards.pending() {
+			s.restorePortForward(saved)
+		}
+
+		select {
+		case <-done:
+			return
+
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) restorePortForward(saved savedPortForward) {
+	c, ok := s.kubernetesContext(saved.Context)
+
+	if !ok {
+		s.portForwards.setStatus(saved.ID, "waiting", errContextNotFound)
+		return
+	}
+
+	if s.connectivity.offline(c.Name) {
+		s.portForwards.setStatus(saved.ID, "waiting", errors.New("context is offline"))
+		return
+	}
+
+	auth := saved.auth()
+
+	if auth != nil && auth.ImpersonateUser != "" && !s.config.ImpersonationAllowed(auth) {
+		s.portForwards.setStatus(saved.ID, "error", errImpersonationForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req := saved.Request
+
+	item, err := s.startPortForward(ctx, saved.ID, saved.Owner, c.Name, auth, &req, saved.Created)
+
+	if errors.Is(err, errPortForwardPortInUse) {
+		s.portForwards.setStatus(saved.ID, "conflict", err)
+		return
+	}
+
+	if err != nil {
+		s.portForwards.setStatus(saved.ID, "error", err)
+		return
+	}
+
+	// deleted while being restored
+	if _, ok := s.portForwards.savedItem(saved.ID); !ok {
+		item.release()
+		return
+	}
+
+	s.portForwards.setStatus(saved.ID, "waiting", nil)
+
+	log.Printf("restored port-forward %s of context %q on %s", saved.ID, c.Name, item.listener.Addr())
+}
@@ -0,0 +1,377 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/client-go/util/jsonpath"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/health"
+)
+
+type printerKey struct{}
+
+// printers holds the compiled printer rules of the config.
+type printers struct {
+	once  sync.Once
+	items map[string]*printer
+}
+
+type printer struct {
+	columns []printerColumn
+	health  cel.Program
+}
+
+type printerColumn struct {
+	name string
+
+	path    *jsonpath.JSONPath
+	program cel.Program
+}
+
+// printerRequest is stored in the request context of lists with a printer
+// rule or requested health (?health=true).
+type printerRequest struct {
+	// printer of the resource, if any
+	printer *printer
+
+	table  bool
+	health bool
+
+	// includeObject requested by the client
+	includeObject string
+}
+
+func printerID(group, resource string) string {
+	return strings.ToLower(group + "/" + resource)
+}
+
+func (p *printers) get(rules []config.PrinterRule, group, resource string) *printer {
+	p.once.Do(func() {
+		p.items = make(map[string]*printer)
+
+		for _, rule := range rules {
+			printer, err := compilePrinter(rule)
+
+			if err != nil {
+				log.Printf("invalid printer rule for %s: %v", printerID(rule.Group, rule.Resource), err)
+				continue
+			}
+
+			p.items[printerID(rule.Group, rule.Resource)] = printer
+		}
+	})
+
+	return p.items[printerID(group, resource)]
+}
+
+func compilePrinter(rule config.PrinterRule) (*printer, error) {
+	env, err := cel.NewEnv(cel.Variable("self", cel.DynType))
+
+	if err != nil {
+		return nil, err
+	}
+
+	program := func(expr string) (cel.Program, error) {
+		ast, issues := env.Compile(expr)
+
+		if issues != nil && issues.Err() != nil {
+			return nil, issues.Err()
+		}
+
+		return env.Program(ast)
+	}
+
+	result := &printer{}
+
+	for _, c := range rule.Columns {
+		column := printerColumn{
+			name: c.Name,
+		}
+
+		switch {
+		case c.CEL != "":
+			p, err := program(c.CEL)
+
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", c.Name, err)
+			}
+
+			column.program = p
+
+		case c.JSONPath != "":
+			path := jsonpath.New(c.Name).AllowMissingKeys(true)
+
+			if err := path.Parse("{" + c.JSONPath + "}"); err != nil {
+				return nil, fmt.Errorf("column %s: %w", c.Name, err)
+			}
+
+			column.path = path
+
+		default:
+			return nil, fmt.Errorf("column %s: jsonPath or cel is required", c.Name)
+		}
+
+		result.columns = append(result.columns, column)
+	}
+
+	if rule.Health != "" {
+		p, err := program(rule.Health)
+
+		if err != nil {
+			return nil, fmt.Errorf("health: %w", err)
+		}
+
+		result.health = p
+	}
+
+	return result, nil
+}
+
+func (c *printerColumn) value(obj map[string]any) string {
+	if c.program != nil {
+		out, _, err := c.program.Eval(map[string]any{"self": obj})
+
+		if err != nil {
+			return ""
+		}
+
+		return fmt.Sprint(out.Value())
+	}
+
+	var buf bytes.Buffer
+
+	if err := c.path.Execute(&buf, obj); err != nil {
+		return ""
+	}
+
+	return buf.String()
+}
+
+// healthOf evaluates the health expression; errors (e.g. missing fields)
+// yield Unknown.
+func (p *printer) healthOf(obj map[string]any) health.Result {
+	out, _, err := p.health.Eval(map[string]any{"self": obj})
+
+	if err != nil {
+		return health.Result{Status: health.Unknown, Message: err.Error()}
+	}
+
+	switch v := out.(type) {
+	case types.Bool:
+		if v {
+			return health.Result{Status: health.Healthy}
+		}
+
+		return health.Result{Status: health.Degraded}
+
+	case types.String:
+		return health.Result{Status: health.Status(v)}
+	}
+
+	return health.Result{Status: health.Unknown}
+}
+
+// assess returns the health of an object, preferring the health expression
+// of a printer rule over the built-in checks.
+func (pr *printerRequest) assess(obj map[string]any) health.Result {
+	if pr.printer != nil && pr.printer.health != nil {
+		return pr.printer.healthOf(obj)
+	}
+
+	return health.Assess(obj)
+}
+
+func (pr *printerRequest) healthColumn() bool {
+	return pr.health || (pr.printer != nil && pr.printer.health != nil)
+}
+
+// applyPrinter prepares list requests of resources with a printer rule or
+// with requested health. Table rows need the full object to evaluate rules,
+// which is reduced again to what the client requested in printResponse.
+func (s *Server) applyPrinter(r *http.Request) *http.Request {
+	if r.Method != http.MethodGet || isWatchRequest(r) {
+		return r
+	}
+
+	req, ok := parseKubernetesPath(r.URL.Path)
+
+	if !ok || req.Name != "" {
+		return r
+	}
+
+	query := r.URL.Query()
+
+	pr := &printerRequest{
+		printer: s.printers.get(s.config.Printers, req.Group, req.Resource),
+
+		table:  strings.Contains(r.Header.Get("Accept"), "as=Table"),
+		health: query.Get("health") == "true",
+
+		includeObject: query.Get("includeObject"),
+	}
+
+	query.Del("health")
+
+	if !pr.health && (pr.printer == nil || !pr.table) {
+		return r
+	}
+
+	if pr.table {
+		query.Set("includeObject", "Object")
+	}
+
+	r.URL.RawQuery = query.Encode()
+
+	return r.WithContext(context.WithValue(r.Context(), printerKey{}, pr))
+}
+
+func printerFromContext(ctx context.Context) (*printerRequest, bool) {
+	pr, ok := ctx.Value(printerKey{}).(*printerRequest)
+	return pr, ok
+}
+
+// printResponse adds the computed columns of a printer rule and the health
+// to tables, or a health field to the items of JSON lists.
+func printResponse(resp *http.Response) error {
+	pr, ok := printerFromContext(resp.Request.Context())
+
+	if !ok || resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		return nil
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err != nil {
+		return err
+	}
+
+	var list map[string]any
+
+	if err := utiljson.Unmarshal(data, &list); err != nil {
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		return nil
+	}
+
+	if list["kind"] == "Table" {
+		printTable(pr, list)
+	} else if items, ok := list["items"].([]any); ok && pr.health {
+		apiVersion, _ := list["apiVersion"].(string)
+		kind, _ := list["kind"].(string)
+
+		for _, item := range items {
+			item, ok := item.(map[string]any)
+
+			if !ok {
+				continue
+			}
+
+			// items of built-in lists omit their type, which checks dispatch on
+			if _, ok := item["kind"]; !ok {
+				item["apiVersion"] = apiVersion
+				item["kind"] = strings.TrimSuffix(kind, "List")
+			}
+
+			item["health"] = pr.assess(item)
+		}
+	}
+
+	result, err := json.Marshal(list)
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(result))
+	resp.ContentLength = int64(len(result))
+
+	resp.Header.Set("Content-Length", strconv.Itoa(len(result)))
+	resp.Header.Del("Content-Encoding")
+
+	return nil
+}
+
+func printTable(pr *printerRequest, table map[string]any) {
+	definitions, _ := table["columnDefinitions"].([]any)
+
+	var columns []printerColumn
+
+	if pr.printer != nil {
+		columns = pr.printer.columns
+	}
+
+	for _, c := range columns {
+		definitions = append(definitions, map[string]any{
+			"name":     c.name,
+			"type":     "string",
+			"format":   "",
+			"priority": 0,
+
+			"description": "computed by bridge",
+		})
+	}
+
+	if pr.healthColumn() {
+		definitions = append(definitions, map[string]any{
+			"name":     "Health",
+			"type":     "string",
+			"format":   "",
+			"priority": 0,
+
+			"description": "health computed by bridge",
+		})
+	}
+
+	table["columnDefinitions"] = definitions
+
+	rows, _ := table["rows"].([]any)
+
+	for _, row := range rows {
+		row, ok := row.(map[string]any)
+
+		if !ok {
+			continue
+		}
+
+		obj, _ := row["object"].(map[string]any)
+		cells, _ := row["cells"].([]any)
+
+		for _, c := range columns {
+			cells = append(cells, c.value(obj))
+		}
+
+		if pr.healthColumn() {
+			cells = append(cells, string(pr.assess(obj).Status))
+		}
+
+		row["cells"] = cells
+
+		switch pr.includeObject {
+		case "Object":
+
+		case "None":
+			delete(row, "object")
+
+		default:
+			row["object"] = map[string]any{
+				"kind":       "PartialObjectMetadata",
+				"apiVersion": "meta.k8s.io/v1",
+				"metadata":   obj["metadata"],
+			}
+		}
+	}
+}
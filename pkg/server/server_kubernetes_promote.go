@@ -0,0 +1,339 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// promotionResource is a kind of workload whose images can be promoted.
+type promotionResource struct {
+	group    string
+	resource string
+	kind     string
+}
+
+// promotionResources are the workloads rolled out by updating their pod
+// template. Jobs are left out, as their templates are immutable.
+var promotionResources = []promotionResource{
+	{"apps", "deployments", "Deployment"},
+	{"apps", "statefulsets", "StatefulSet"},
+	{"apps", "daemonsets", "DaemonSet"},
+	{"batch", "cronjobs", "CronJob"},
+}
+
+// handlePromoteImage promotes an image from one context to another: the
+// workloads running the image in the source context are looked up by
+// namespace and name in the target context, and their containers of the same
+// name running another tag of the image are updated. The updates are
+// previewed as dry-run unless the request asks to apply them.
+func (s *Server) handlePromoteImage(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	var req ImagePromotionRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Source == "" || req.Target == "" {
+		http.Error(w, "source and target are required", http.StatusBadRequest)
+		return
+	}
+
+	if strings.EqualFold(req.Source, req.Target) && req.TargetNamespace == "" {
+		http.Error(w, "source and target must differ", http.StatusBadRequest)
+		return
+	}
+
+	repository, tag := splitImageReference(req.Image)
+
+	if repository == "" || tag == "" {
+		http.Error(w, "image must have a tag or digest", http.StatusBadRequest)
+		return
+	}
+
+	if req.TargetNamespace != "" && req.Namespace == "" {
+		http.Error(w, "targetNamespace requires namespace", http.StatusBadRequest)
+		return
+	}
+
+	source, err := s.kubernetesClient(r.Context(), req.Source, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	target, err := s.kubernetesClient(r.Context(), req.Target, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := &ImagePromotion{
+		Source: req.Source,
+		Target: req.Target,
+		Image:  req.Image,
+
+		Applied: req.Apply,
+
+		Workloads: []ImagePromotionWorkload{},
+	}
+
+	for _, res := range promotionResources {
+		path := "/apis/" + res.group + "/v1/" + res.resource
+
+		if req.Namespace != "" {
+			path = "/apis/" + res.group + "/v1/namespaces/" + req.Namespace + "/" + res.resource
+		}
+
+		var list struct {
+			Items []map[string]any `json:"items"`
+		}
+
+		if err := source.get(r.Context(), path, nil, &list); err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+
+		for _, obj := range list.Items {
+			containers := promotionContainers(res.kind, obj, req.Image)
+
+			if len(containers) == 0 {
+				continue
+			}
+
+			metadata, _ := obj["metadata"].(map[string]any)
+
+			namespace, _ := metadata["namespace"].(string)
+			name, _ := metadata["name"].(string)
+
+			if req.TargetNamespace != "" {
+				namespace = req.TargetNamespace
+			}
+
+			item := ImagePromotionWorkload{
+				Kind:      res.kind,
+				Namespace: namespace,
+				Name:      name,
+
+				Containers: containers,
+			}
+
+			s.promoteWorkload(r, target, res, repository, &req, &item)
+
+			result.Workloads = append(result.Workloads, item)
+		}
+	}
+
+	slices.SortFunc(result.Workloads, func(a, b ImagePromotionWorkload) int {
+		return strings.Compare(a.Namespace+"/"+a.Kind+"/"+a.Name, b.Namespace+"/"+b.Kind+"/"+b.Name)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// promoteWorkload previews or applies the promotion of an image to a
+// workload of the target context.
+func (s *Server) promoteWorkload(r *http.Request, client *kubernetesClient, res promotionResource, repository string, req *ImagePromotionRequest, item *ImagePromotionWorkload) {
+	path := "/apis/" + res.group + "/v1/namespaces/" + item.Namespace + "/" + res.resource + "/" + item.Name
+
+	var obj map[string]any
+
+	if err := client.get(r.Context(), path, nil, &obj); err != nil {
+		if statusCode(err) == http.StatusNotFound {
+			item.Action = "missing"
+			return
+		}
+
+		item.Action = "error"
+		item.Error = statusMessage(err)
+		return
+	}
+
+	patch, changes := promotionPatch(res.kind, obj, repository, req.Image, item.Containers)
+
+	item.Changes = changes
+
+	if len(changes) == 0 {
+		item.Action = "unchanged"
+		return
+	}
+
+	item.Action = "update"
+
+	query := url.Values{}
+
+	if !req.Apply {
+		// admission and validation of the target are part of the preview
+		query.Set("dryRun", "All")
+	} else {
+		if err := s.checkProtection(r, req.Target, item.Namespace); err != nil {
+			item.Action = "error"
+			item.Error = err.Error()
+			return
+		}
+
+		// the update rolls out the workload
+		if limit := s.config.Limits.MaxDisruptionsPerMinute; limit > 0 {
+			if ok, wait := s.disruptions.allow(strings.ToLower(req.Target)+"/"+item.Namespace, limit); !ok {
+				item.Action = "error"
+				item.Error = fmt.Sprintf("too many disruptions in namespace %q, retry in %ds", item.Namespace, int(wait.Seconds())+1)
+				return
+			}
+		}
+	}
+
+	data, _ := json.Marshal(patch)
+
+	err := client.patch(r.Context(), path, query, "application/json-patch+json", data, nil)
+
+	if req.Apply {
+		entry := &AuditEntry{
+			Context: req.Target,
+			Owner:   ownerID(AuthInfoFromContext(r.Context())),
+			Action:  "promote-image",
+
+			Resource:  res.resource,
+			Namespace: item.Namespace,
+			Name:      item.Name,
+		}
+
+		if err != nil {
+			entry.Error = err.Error()
+		}
+
+		s.audit.record(entry)
+	}
+
+	if err != nil {
+		item.Action = "error"
+
+		// the workload changed since it was read
+		if statusCode(err) == http.StatusUnprocessableEntity {
+			item.Action = "conflict"
+		}
+
+		item.Error = statusMessage(err)
+	}
+}
+
+// promotionContainers returns the names of the containers of a workload
+// running an image.
+func promotionContainers(kind string, obj map[string]any, image string) []string {
+	var result []string
+
+	for _, c := range templateContainers(kind, obj) {
+		if c.image == image && !slices.Contains(result, c.name) {
+			result = append(result, c.name)
+		}
+	}
+
+	return result
+}
+
+// promotionPatch returns a JSON patch updating the containers of a workload
+// running another tag of the repository. Every replacement is guarded by a
+// test of the image read, so concurrent changes are not overwritten.
+func promotionPatch(kind string, obj map[string]any, repository, image string, names []string) ([]map[string]any, []DiffChange) {
+	var patch []map[string]any
+	var changes []DiffChange
+
+	for _, c := range templateContainers(kind, obj) {
+		if !slices.Contains(names, c.name) || c.image == image {
+			continue
+		}
+
+		if r, _ := splitImageReference(c.image); r != repository {
+			continue
+		}
+
+		pointer := "/" + strings.Join(c.path, "/") + "/image"
+
+		patch = append(patch,
+			map[string]any{"op": "test", "path": pointer, "value": c.image},
+			map[string]any{"op": "replace", "path": pointer, "value": image},
+		)
+
+		changes = append(changes, DiffChange{
+			Path: "." + strings.Join(c.path[:len(c.path)-1], ".") + "[" + c.path[len(c.path)-1] + "].image",
+			Op:   "replace",
+
+			Before: c.image,
+			After:  image,
+		})
+	}
+
+	return patch, changes
+}
+
+type templateContainer struct {
+	name  string
+	image string
+
+	// path of the container in the workload
+	path []string
+}
+
+// templateContainers returns the init and app containers of the pod
+// template of a workload.
+func templateContainers(kind string, obj map[string]any) []templateContainer {
+	path, ok := podTemplatePaths[kind]
+
+	if !ok {
+		return nil
+	}
+
+	var spec any = obj
+
+	for _, p := range path {
+		m, _ := spec.(map[string]any)
+		spec = m[p]
+	}
+
+	podSpec, _ := spec.(map[string]any)
+
+	var result []templateContainer
+
+	for _, key := range []string{"initContainers", "containers"} {
+		containers, _ := podSpec[key].([]any)
+
+		for i, c := range containers {
+			c, _ := c.(map[string]any)
+
+			name, _ := c["name"].(string)
+			image, _ := c["image"].(string)
+
+			result = append(result, templateContainer{
+				name:  name,
+				image: image,
+
+				path: append(slices.Clone(path), key, strconv.Itoa(i)),
+			})
+		}
+	}
+
+	return result
+}
+
+// splitImageReference returns the repository, including the registry host,
+// and the tag or digest of an image reference.
+func splitImageReference(image string) (string, string) {
+	if repository, digest, ok := strings.Cut(image, "@"); ok {
+		return repository, digest
+	}
+
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+
+	return image, ""
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+// confirmationHeader carries the token confirming an operation on a
+// protected namespace.
+const confirmationHeader = "X-Bridge-Confirmation"
+
+const confirmationTTL = 2 * time.Minute
+
+var (
+	errNamespaceReadOnly    = i18n.NewError("error.namespace_read_only")
+	errConfirmationRequired = i18n.NewError("error.confirmation_required")
+)
+
+// confirmations holds server-issued tokens allowing mutating operations on a
+// protected namespace for a short time.
+type confirmations struct {
+	mu     sync.Mutex
+	tokens map[string]confirmation
+}
+
+type confirmation struct {
+	context   string
+	namespace string
+	owner     string
+
+	expires time.Time
+}
+
+func (c *confirmations) issue(context, namespace, owner string) (string, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tokens == nil {
+		c.tokens = make(map[string]confirmation)
+	}
+
+	now := time.Now()
+
+	for token, v := range c.tokens {
+		if now.After(v.expires) {
+			delete(c.tokens, token)
+		}
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	token := hex.EncodeToString(id)
+	expires := now.Add(confirmationTTL)
+
+	c.tokens[token] = confirmation{
+		context:   strings.ToLower(context),
+		namespace: namespace,
+		owner:     owner,
+
+		expires: expires,
+	}
+
+	return token, expires
+}
+
+func (c *confirmations) valid(token, context, namespace, owner string) bool {
+	if token == "" {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.tokens[token]
+
+	if !ok || time.Now().After(v.expires) {
+		return false
+	}
+
+	return v.context == strings.ToLower(context) && v.namespace == namespace && v.owner == owner
+}
+
+// isMutating reports whether a request changes cluster state. Exec, attach
+// and port-forward are treated as mutating regardless of the method.
+func isMutating(r *http.Request, req *kubernetesRequest) bool {
+	if r.URL.Query().Get("dryRun") != "" {
+		return false
+	}
+
+	if req.Resource == "pods" {
+		switch req.Subresource {
+		case "exec", "attach", "portforward":
+			return true
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+
+	// reviews (e.g. SelfSubjectAccessReview) are create-only queries
+	return !strings.HasSuffix(req.Resource, "reviews")
+}
+
+// protectedNamespace returns the namespace a request operates on, including
+// requests on the namespace object itself.
+func protectedNamespace(req *kubernetesRequest) string {
+	if req.Group == "" && req.Resource == "namespaces" {
+		return req.Name
+	}
+
+	return req.Namespace
+}
+
+// checkProtection validates an operation on a namespace against the
+// protection policy and the confirmation token of the request.
+func (s *Server) checkProtection(r *http.Request, context, namespace string) error {
+	switch s.config.NamespaceProtection(namespace) {
+	case config.ProtectionReadOnly:
+		return fmt.Errorf("%w: %s", errNamespaceReadOnly, namespace)
+
+	case config.ProtectionConfirm:
+		owner := ownerID(AuthInfoFromContext(r.Context()))
+
+		if !s.confirmations.valid(r.Header.Get(confirmationHeader), context, namespace, owner) {
+			return fmt.Errorf("%w: namespace %s is protected, request a token via POST /contexts/%s/confirmations", errConfirmationRequired, namespace, context)
+		}
+	}
+
+	return nil
+}
+
+// guardProtection enforces namespace protection on proxied requests.
+// It returns false if the request was rejected.
+func (s *Server) guardProtection(w http.ResponseWriter, r *http.Request, c config.KubernetesContext) bool {
+	req, ok := parseKubernetesPath(r.URL.Path)
+
+	if !ok || !isMutating(r, req) {
+		return true
+	}
+
+	if err := s.checkProtection(r, c.Name, protectedNamespace(req)); err != nil {
+		writeProtectionError(w, r, err)
+		return false
+	}
+
+	return true
+}
+
+func writeProtectionError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errConfirmationRequired) {
+		writeError(w, r, err, http.StatusPreconditionRequired)
+		return
+	}
+
+	writeError(w, r, err, http.StatusForbidden)
+}
+
+func (s *Server) handleCreateConfirmation(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	c, ok := s.kubernetesContext(r.PathValue("context"))
+
+	if !ok {
+		writeError(w, r, errContextNotFound, http.StatusNotFound)
+		return
+	}
+
+	var req ConfirmationRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch s.config.NamespaceProtection(req.Namespace) {
+	case config.ProtectionNone:
+		http.Error(w, "namespace is not protected", http.StatusBadRequest)
+		return
+
+	case config.ProtectionReadOnly:
+		http.Error(w, "namespace is read-only", http.StatusForbidden)
+		return
+	}
+
+	token, expires := s.confirmations.issue(c.Name, req.Namespace, ownerID(auth))
+
+	s.audit.record(&AuditEntry{
+		Context: c.Name,
+		Owner:   ownerID(auth),
+		Action:  "confirm",
+
+		Namespace: req.Namespace,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(&ConfirmationInfo{
+		Token:   token,
+		Header:  confirmationHeader,
+		Expires: expires,
+	})
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+func TestConfirmations(t *testing.T) {
+	var c confirmations
+
+	token, expires := c.issue("Dev", "payments", "alice")
+
+	if token == "" {
+		t.Fatal("expected a token")
+	}
+
+	if d := time.Until(expires); d <= 0 || d > confirmationTTL {
+		t.Fatalf("unexpected expiry in %s", d)
+	}
+
+	if !c.valid(token, "dev", "payments", "alice") {
+		t.Error("expected the token to be valid, context names are case-insensitive")
+	}
+
+	// tokens are bound to the context, namespace and owner they were issued for
+	if c.valid(token, "prod", "payments", "alice") {
+		t.Error("expected the token to be invalid for another context")
+	}
+
+	if c.valid(token, "dev", "billing", "alice") {
+		t.Error("expected the token to be invalid for another namespace")
+	}
+
+	if c.valid(token, "dev", "payments", "bob") {
+		t.Error("expected the token to be invalid for another owner")
+	}
+
+	if c.valid("", "dev", "payments", "alice") || c.valid("unknown", "dev", "payments", "alice") {
+		t.Error("expected missing and unknown tokens to be invalid")
+	}
+
+	c.tokens[token] = confirmation{
+		context:   "dev",
+		namespace: "payments",
+		owner:     "alice",
+
+		expires: time.Now().Add(-time.Second),
+	}
+
+	if c.valid(token, "dev", "payments", "alice") {
+		t.Error("expected an expired token to be invalid")
+	}
+
+	// expired tokens are dropped on the next issue
+	c.issue("dev", "payments", "alice")
+
+	if _, ok := c.tokens[token]; ok {
+		t.Error("expected the expired token to be removed")
+	}
+}
+
+func TestCheckProtection(t *testing.T) {
+	s := &Server{
+		config: &config.Config{
+			ProtectedNamespaces: []string{"prod-*", "payments"},
+			ReadOnlyNamespaces:  []string{"kube-system", "prod-locked"},
+		},
+	}
+
+	alice := &config.AuthInfo{User: "alice"}
+	bob := &config.AuthInfo{User: "bob"}
+
+	request := func(auth *config.AuthInfo, token string) *http.Request {
+		r := httptest.NewRequest(http.MethodDelete, "/", nil)
+
+		if token != "" {
+			r.Header.Set(confirmationHeader, token)
+		}
+
+		return r.WithContext(context.WithValue(r.Context(), authInfoKey, auth))
+	}
+
+	token, _ := s.confirmations.issue("dev", "payments", ownerID(alice))
+
+	tests := []struct {
+		name      string
+		r         *http.Request
+		namespace string
+		want      error
+	}{
+		{"unprotected", request(alice, ""), "default", nil},
+		{"cluster scoped", request(alice, ""), "", nil},
+		{"read-only", request(alice, token), "kube-system", errNamespaceReadOnly},
+		{"read-only takes precedence", request(alice, ""), "prod-locked", errNamespaceReadOnly},
+		{"without token", request(alice, ""), "payments", errConfirmationRequired},
+		{"with token", request(alice, token), "payments", nil},
+		{"token of another namespace", request(alice, token), "prod-eu", errConfirmationRequired},
+		{"token of another owner", request(bob, token), "payments", errConfirmationRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.checkProtection(tt.r, "dev", tt.namespace)
+
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				return
+			}
+
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsMutating(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{http.MethodGet, "/api/v1/namespaces/default/pods", false},
+		{http.MethodDelete, "/api/v1/namespaces/default/pods/web-0", true},
+		{http.MethodPatch, "/apis/apps/v1/namespaces/default/deployments/web/scale", true},
+		{http.MethodDelete, "/api/v1/namespaces/default/pods/web-0?dryRun=All", false},
+		{http.MethodGet, "/api/v1/namespaces/default/pods/web-0/exec", true},
+		{http.MethodGet, "/api/v1/namespaces/default/pods/web-0/portforward", true},
+		{http.MethodPost, "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+
+		req, ok := parseKubernetesPath(r.URL.Path)
+
+		if !ok {
+			t.Fatalf("%s: expected a match", tt.path)
+		}
+
+		if got := isMutating(r, req); got != tt.want {
+			t.Errorf("%s %s: mutating = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// handleCompareRBAC compares the permissions granted to a subject by
+// (Cluster)RoleBindings across contexts, e.g.
+// /rbac/compare?contexts=dev,prod&kind=Group&name=platform-team
+func (s *Server) handleCompareRBAC(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	query := r.URL.Query()
+
+	subject := rbacv1.Subject{
+		Kind:      query.Get("kind"),
+		Name:      query.Get("name"),
+		Namespace: query.Get("namespace"),
+	}
+
+	switch subject.Kind {
+	case rbacv1.UserKind, rbacv1.GroupKind:
+	case rbacv1.ServiceAccountKind:
+		if subject.Namespace == "" {
+			http.Error(w, "namespace is required for service accounts", http.StatusBadRequest)
+			return
+		}
+
+	default:
+		http.Error(w, "kind must be User, Group or ServiceAccount", http.StatusBadRequest)
+		return
+	}
+
+	if subject.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	var contexts []string
+
+	for _, v := range query["contexts"] {
+		contexts = append(contexts, splitNames(v)...)
+	}
+
+	if len(contexts) < 2 {
+		http.Error(w, "at least two contexts are required", http.StatusBadRequest)
+		return
+	}
+
+	result := &RBACComparison{
+		Contexts:    make([]RBACContext, len(contexts)),
+		Differences: []RBACDifference{},
+	}
+
+	var wg sync.WaitGroup
+
+	for i, name := range contexts {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			result.Contexts[i] = s.subjectPermissions(r.Context(), name, auth, subject)
+		}()
+	}
+
+	wg.Wait()
+
+	present := map[string][]string{}
+
+	for _, c := range result.Contexts {
+		if c.Error != "" {
+			continue
+		}
+
+		for _, p := range c.Permissions {
+			present[p] = append(present[p], c.Context)
+		}
+	}
+
+	for p, in := range present {
+		var missing []string
+
+		for _, c := range result.Contexts {
+			if c.Error == "" && !slices.Contains(in, c.Context) {
+				missing = append(missing, c.Context)
+			}
+		}
+
+		if len(missing) == 0 {
+			continue
+		}
+
+		result.Differences = append(result.Differences, RBACDifference{
+			Permission: p,
+			Present:    in,
+			Missing:    missing,
+		})
+	}
+
+	slices.SortFunc(result.Differences, func(a, b RBACDifference) int {
+		return strings.Compare(a.Permission, b.Permission)
+	})
+
+	writeList(w, r, "rbac", result, result.Differences)
+}
+
+// subjectPermissions resolves the bindings of a subject in a context into a
+// sorted list of permissions in the form
+// "<namespace|cluster> <verb> [<group>/]<resource>[/<name>]" or "<verb> <url>".
+func (s *Server) subjectPermissions(ctx context.Context, name string, auth *config.AuthInfo, subject rbacv1.Subject) RBACContext {
+	result := RBACContext{
+		Context: name,
+
+		Bindings:    []string{},
+		Permissions: []string{},
+	}
+
+	client, err := s.kubernetesClient(ctx, name, auth)
+
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	var clusterRoles rbacv1.ClusterRoleList
+
+	if err := client.get(ctx, "/apis/rbac.authorization.k8s.io/v1/clusterroles", nil, &clusterRoles); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	var roles rbacv1.RoleList
+
+	if err := client.get(ctx, "/apis/rbac.authorization.k8s.io/v1/roles", nil, &roles); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	var clusterBindings rbacv1.ClusterRoleBindingList
+
+	if err := client.get(ctx, "/apis/rbac.authorization.k8s.io/v1/clusterrolebindings", nil, &clusterBindings); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	var bindings rbacv1.RoleBindingList
+
+	if err := client.get(ctx, "/apis/rbac.authorization.k8s.io/v1/rolebindings", nil, &bindings); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	rules := func(ref rbacv1.RoleRef, namespace string) []rbacv1.PolicyRule {
+		switch ref.Kind {
+		case "ClusterRole":
+			for _, r := range clusterRoles.Items {
+				if r.Name == ref.Name {
+					return r.Rules
+				}
+			}
+
+		case "Role":
+			for _, r := range roles.Items {
+				if r.Name == ref.Name && r.Namespace == namespace {
+					return r.Rules
+				}
+			}
+		}
+
+		return nil
+	}
+
+	permissions := map[string]bool{}
+
+	for _, b := range clusterBindings.Items {
+		if !hasSubject(b.Subjects, subject) {
+			continue
+		}
+
+		result.Bindings = append(result.Bindings, "ClusterRoleBinding "+b.Name+" -> "+b.RoleRef.Kind+" "+b.RoleRef.Name)
+
+		for _, p := range expandRules(rules(b.RoleRef, ""), "cluster") {
+			permissions[p] = true
+		}
+	}
+
+	for _, b := range bindings.Items {
+		if !hasSubject(b.Subjects, subject) {
+			continue
+		}
+
+		result.Bindings = append(result.Bindings, "RoleBinding "+b.Namespace+"/"+b.Name+" -> "+b.RoleRef.Kind+" "+b.RoleRef.Name)
+
+		for _, p := range expandRules(rules(b.RoleRef, b.Namespace), b.Namespace) {
+			permissions[p] = true
+		}
+	}
+
+	for p := range permissions {
+		result.Permissions = append(result.Permissions, p)
+	}
+
+	slices.Sort(result.Bindings)
+	slices.Sort(result.Permissions)
+
+	return result
+}
+
+func hasSubject(subjects []rbacv1.Subject, subject rbacv1.Subject) bool {
+	for _, s := range subjects {
+		if s.Kind != subject.Kind || s.Name != subject.Name {
+			continue
+		}
+
+		if s.Kind == rbacv1.ServiceAccountKind && s.Namespace != subject.Namespace {
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func expandRules(rules []rbacv1.PolicyRule, scope string) []string {
+	var result []string
+
+	for _, rule := range rules {
+		for _, verb := range rule.Verbs {
+			for _, url := range rule.NonResourceURLs {
+				result = append(result, fmt.Sprintf("%s %s", verb, url))
+			}
+
+			for _, group := range rule.APIGroups {
+				for _, resource := range rule.Resources {
+					target := resource
+
+					if group != "" {
+						target = group + "/" + resource
+					}
+
+					if len(rule.ResourceNames) == 0 {
+						result = append(result, fmt.Sprintf("%s %s %s", scope, verb, target))
+						continue
+					}
+
+					for _, name := range rule.ResourceNames {
+						result = append(result, fmt.Sprintf("%s %s %s/%s", scope, verb, target, name))
+					}
+				}
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,603 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+const (
+	registryContainer = "bridge-registry"
+	registryImage     = "registry:2"
+
+	// registryHost is the address of the registry on the host, which is
+	// also used as image prefix inside the cluster
+	registryHost = "localhost:5001"
+)
+
+var errNotLocalCluster = i18n.NewError("error.not_local_cluster")
+
+// localCluster is a kind or k3d cluster running on a docker daemon.
+type localCluster struct {
+	Provider string
+	Name     string
+
+	// Network the node containers are attached to
+	Network string
+
+	// Label selecting the node containers
+	Label string
+}
+
+// detectLocalCluster derives the cluster from the context name created by
+// kind (kind-<name>) and k3d (k3d-<name>).
+func detectLocalCluster(context string) (*localCluster, error) {
+	if name, ok := strings.CutPrefix(context, "kind-"); ok {
+		return &localCluster{Provider: "kind", Name: name, Network: "kind", Label: "io.x-k8s.kind.cluster=" + name}, nil
+	}
+
+	if name, ok := strings.CutPrefix(context, "k3d-"); ok {
+		return &localCluster{Provider: "k3d", Name: name, Network: "k3d-" + name, Label: "k3d.cluster=" + name}, nil
+	}
+
+	return nil, errNotLocalCluster
+}
+
+type registryTarget struct {
+	cluster *localCluster
+	docker  *dockerClient
+}
+
+func (s *Server) registryTarget(r *http.Request) (*registryTarget, error) {
+	cluster, err := detectLocalCluster(r.PathValue("context"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	name := r.URL.Query().Get("docker")
+
+	if name == "" {
+		name = s.defaultDockerContext()
+	}
+
+	docker, err := s.dockerClient(name)
+
+	if err != nil {
+		return nil, fmt.Errorf("docker context %q: %w", name, err)
+	}
+
+	return &registryTarget{
+		cluster: cluster,
+		docker:  docker,
+	}, nil
+}
+
+func writeRegistryError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errNotLocalCluster) {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	writeClientError(w, r, err)
+}
+
+type containerInspect struct {
+	ID string `json:"Id"`
+
+	State struct {
+		Running bool `json:"Running"`
+	} `json:"State"`
+
+	NetworkSettings struct {
+		Networks map[string]any `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+func (t *registryTarget) inspect(ctx context.Context) (*containerInspect, error) {
+	var info containerInspect
+
+	if err := t.docker.get(ctx, "/containers/"+registryContainer+"/json", nil, &info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+func (t *registryTarget) nodes(ctx context.Context) ([]string, error) {
+	filters, _ := json.Marshal(map[string][]string{
+		"label": {t.cluster.Label},
+	})
+
+	var containers []struct {
+		ID    string   `json:"Id"`
+		Names []string `json:"Names"`
+		Image string   `json:"Image"`
+	}
+
+	if err := t.docker.get(ctx, "/containers/json", url.Values{"filters": {string(filters)}}, &containers); err != nil {
+		return nil, err
+	}
+
+	var result []string
+
+	for _, c := range containers {
+		// k3d also labels its load balancer and tools containers
+		if t.cluster.Provider == "k3d" && !strings.Contains(c.Image, "k3s") {
+			continue
+		}
+
+		if len(c.Names) > 0 {
+			result = append(result, strings.TrimPrefix(c.Names[0], "/"))
+		}
+	}
+
+	return result, nil
+}
+
+func (t *registryTarget) status(ctx context.Context) (*RegistryStatus, error) {
+	status := &RegistryStatus{
+		Container: registryContainer,
+		Image:     registryImage,
+
+		Endpoint:        registryHost,
+		ClusterEndpoint: registryContainer + ":5000",
+
+		Provider: t.cluster.Provider,
+		Cluster:  t.cluster.Name,
+
+		Nodes: []string{},
+	}
+
+	nodes, err := t.nodes(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no %s nodes found for cluster %s", t.cluster.Provider, t.cluster.Name)
+	}
+
+	status.Nodes = nodes
+
+	info, err := t.inspect(ctx)
+
+	if statusCode(err) == http.StatusNotFound {
+		return status, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	status.Exists = true
+	status.Running = info.State.Running
+
+	_, status.Connected = info.NetworkSettings.Networks[t.cluster.Network]
+
+	return status, nil
+}
+
+// handleRegistry reports the local registry of a kind or k3d cluster.
+func (s *Server) handleRegistry(w http.ResponseWriter, r *http.Request) {
+	target, err := s.registryTarget(r)
+
+	if err != nil {
+		writeRegistryError(w, r, err)
+		return
+	}
+
+	status, err := target.status(r.Context())
+
+	if err != nil {
+		writeRegistryError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleCreateRegistry creates (or repairs) a registry container, attaches it
+// to the cluster network and configures the nodes to pull localhost:5001
+// images from it.
+func (s *Server) handleCreateRegistry(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	ctx := r.Context()
+
+	target, err := s.registryTarget(r)
+
+	if err != nil {
+		writeRegistryError(w, r, err)
+		return
+	}
+
+	status, err := target.status(ctx)
+
+	if err != nil {
+		writeRegistryError(w, r, err)
+		return
+	}
+
+	if !status.Exists {
+		if err := target.create(ctx); err != nil {
+			writeRegistryError(w, r, err)
+			return
+		}
+	}
+
+	if !status.Running {
+		if err := target.docker.post(ctx, "/containers/"+registryContainer+"/start", nil, nil, nil); err != nil && statusCode(err) != http.StatusNotModified {
+			writeRegistryError(w, r, err)
+			return
+		}
+	}
+
+	if !status.Connected {
+		body := map[string]any{
+			"Container": registryContainer,
+		}
+
+		if err := target.docker.post(ctx, "/networks/"+target.cluster.Network+"/connect", nil, body, nil); err != nil {
+			writeRegistryError(w, r, err)
+			return
+		}
+	}
+
+	for _, node := range status.Nodes {
+		if err := target.configureNode(ctx, node); err != nil {
+			http.Error(w, fmt.Sprintf("failed to configure node %s: %v", node, err), http.StatusBadGateway)
+			return
+		}
+	}
+
+	// k3s only reads registries.yaml on startup
+	restartRequired := target.cluster.Provider == "k3d"
+
+	if client, err := s.kubernetesClient(ctx, r.PathValue("context"), auth); err == nil {
+		if err := publishRegistryHosting(ctx, client); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+
+	s.audit.record(&AuditEntry{
+		Context: r.PathValue("context"),
+		Owner:   ownerID(auth),
+		Action:  "create-registry",
+
+		Name: registryContainer,
+	})
+
+	status, err = target.status(ctx)
+
+	if err != nil {
+		writeRegistryError(w, r, err)
+		return
+	}
+
+	status.RestartRequired = restartRequired
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (t *registryTarget) create(ctx context.Context) error {
+	name, tag, _ := strings.Cut(registryImage, ":")
+
+	if err := t.docker.post(ctx, "/images/create", url.Values{"fromImage": {name}, "tag": {tag}}, nil, nil); err != nil {
+		return err
+	}
+
+	body := map[string]any{
+		"Image": registryImage,
+
+		"Labels": map[string]string{
+			"bridge.registry": "true",
+		},
+
+		"ExposedPorts": map[string]any{
+			"5000/tcp": struct{}{},
+		},
+
+		"HostConfig": map[string]any{
+			"PortBindings": map[string]any{
+				"5000/tcp": []map[string]string{
+					{"HostIp": "127.0.0.1", "HostPort": strings.TrimPrefix(registryHost, "localhost:")},
+				},
+			},
+
+			"RestartPolicy": map[string]string{
+				"Name": "always",
+			},
+		},
+	}
+
+	return t.docker.post(ctx, "/containers/create", url.Values{"name": {registryContainer}}, body, nil)
+}
+
+// configureNode registers the registry as mirror for localhost:5001 in the
+// container runtime of a node.
+func (t *registryTarget) configureNode(ctx context.Context, node string) error {
+	endpoint := "http://" + registryContainer + ":5000"
+
+	var script string
+
+	switch t.cluster.Provider {
+	case "kind":
+		dir := "/etc/containerd/certs.d/" + registryHost
+		script = fmt.Sprintf("mkdir -p %s && printf '[host.\"%s\"]\\n' > %s/hosts.toml", dir, endpoint, dir)
+
+	case "k3d":
+		script = fmt.Sprintf("mkdir -p /etc/rancher/k3s && printf 'mirrors:\\n  \"%s\":\\n    endpoint:\\n      - %s\\n' > /etc/rancher/k3s/registries.yaml", registryHost, endpoint)
+	}
+
+	return dockerExec(ctx, t.docker, node, "sh", "-c", script)
+}
+
+// publishRegistryHosting documents the registry for other tools (KEP-1755).
+func publishRegistryHosting(ctx context.Context, client *kubernetesClient) error {
+	cm := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "local-registry-hosting",
+			Namespace: "kube-public",
+		},
+
+		Data: map[string]string{
+			"localRegistryHosting.v1": fmt.Sprintf("host: %q\nhostFromContainerRuntime: %q\n", registryHost, registryContainer+":5000"),
+		},
+	}
+
+	err := client.create(ctx, "/api/v1/namespaces/kube-public/configmaps", cm, nil)
+
+	if statusCode(err) == http.StatusConflict {
+		err = client.update(ctx, "/api/v1/namespaces/kube-public/configmaps/local-registry-hosting", nil, cm, nil)
+	}
+
+	return err
+}
+
+// dockerExec runs a command in a container and fails on a non-zero exit code.
+func dockerExec(ctx context.Context, client *dockerClient, container string, cmd ...string) error {
+	var created struct {
+		ID string `json:"Id"`
+	}
+
+	body := map[string]any{
+		"Cmd":          cmd,
+		"AttachStdout": true,
+		"AttachStderr": true,
+	}
+
+	if err := client.post(ctx, "/containers/"+container+"/exec", nil, body, &created); err != nil {
+		return err
+	}
+
+	data, _ := json.Marshal(map[string]any{"Detach": false, "Tty": false})
+
+	resp, err := client.open(ctx, http.MethodPost, "/exec/"+created.ID+"/start", nil, nil, data)
+
+	if err != nil {
+		return err
+	}
+
+	output := demuxDockerStream(resp.Body)
+	resp.Body.Close()
+
+	var result struct {
+		ExitCode int `json:"ExitCode"`
+	}
+
+	if err := client.get(ctx, "/exec/"+created.ID+"/json", nil, &result); err != nil {
+		return err
+	}
+
+	if result.ExitCode != 0 {
+		return fmt.Errorf("exit code %d: %s", result.ExitCode, strings.TrimSpace(output))
+	}
+
+	return nil
+}
+
+// demuxDockerStream reads a multiplexed stdout/stderr stream of a container
+// without TTY.
+func demuxDockerStream(r io.Reader) string {
+	var out bytes.Buffer
+
+	header := make([]byte, 8)
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			break
+		}
+
+		size := binary.BigEndian.Uint32(header[4:])
+
+		if _, err := io.CopyN(&out, r, int64(size)); err != nil {
+			break
+		}
+	}
+
+	return out.String()
+}
+
+// handlePushRegistryImage copies an image of the docker daemon (pulling it
+// first if needed) into the local registry and returns the reference to use
+// in the cluster. Progress is streamed as server-sent events.
+func (s *Server) handlePushRegistryImage(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	ctx := r.Context()
+
+	var req RegistryPushRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Image == "" {
+		http.Error(w, "image is required", http.StatusBadRequest)
+		return
+	}
+
+	target, err := s.registryTarget(r)
+
+	if err != nil {
+		writeRegistryError(w, r, err)
+		return
+	}
+
+	if info, err := target.inspect(ctx); err != nil || !info.State.Running {
+		http.Error(w, "registry is not running, create it first", http.StatusConflict)
+		return
+	}
+
+	repository, tag := splitImage(req.Image)
+	local := registryHost + "/" + repository
+
+	result := &RegistryPushResult{
+		Image:     req.Image,
+		Reference: local + ":" + tag,
+	}
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	var mu sync.Mutex
+
+	send := func(event string, v any) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		data, _ := json.Marshal(v)
+
+		w.Write([]byte("event: " + event + "\ndata: "))
+		w.Write(data)
+		w.Write([]byte("\n\n"))
+
+		rc.Flush()
+	}
+
+	err = func() error {
+		if err := target.docker.get(ctx, "/images/"+req.Image+"/json", nil, nil); err != nil {
+			if statusCode(err) != http.StatusNotFound {
+				return err
+			}
+
+			if err := streamDockerProgress(ctx, target.docker, "/images/create", url.Values{"fromImage": {req.Image}}, nil, send); err != nil {
+				return err
+			}
+		}
+
+		if err := target.docker.post(ctx, "/images/"+req.Image+"/tag", url.Values{"repo": {local}, "tag": {tag}}, nil, nil); err != nil {
+			return err
+		}
+
+		// the local registry does not require credentials
+		header := http.Header{
+			"X-Registry-Auth": {base64.URLEncoding.EncodeToString([]byte("{}"))},
+		}
+
+		return streamDockerProgress(ctx, target.docker, "/images/"+local+"/push", url.Values{"tag": {tag}}, header, send)
+	}()
+
+	entry := &AuditEntry{
+		Context: r.PathValue("context"),
+		Owner:   ownerID(auth),
+		Action:  "push-image",
+
+		Name: result.Reference,
+	}
+
+	if err != nil {
+		result.Error = dockerMessage(err)
+		entry.Error = result.Error
+	}
+
+	s.audit.record(entry)
+
+	send("done", result)
+}
+
+// streamDockerProgress forwards the JSON progress messages of a pull or push.
+func streamDockerProgress(ctx context.Context, client *dockerClient, path string, query url.Values, header http.Header, send func(string, any)) error {
+	resp, err := client.open(ctx, http.MethodPost, path, query, header, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1024*1024)
+
+	for scanner.Scan() {
+		var message struct {
+			ID       string `json:"id"`
+			Status   string `json:"status"`
+			Progress string `json:"progress"`
+			Error    string `json:"error"`
+		}
+
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			continue
+		}
+
+		if message.Error != "" {
+			return errors.New(message.Error)
+		}
+
+		send("progress", &RegistryProgress{
+			ID:       message.ID,
+			Status:   message.Status,
+			Progress: message.Progress,
+		})
+	}
+
+	return scanner.Err()
+}
+
+// splitImage returns the repository path (without registry host) and tag of
+// an image reference.
+func splitImage(image string) (string, string) {
+	image, _, _ = strings.Cut(image, "@")
+
+	tag := "latest"
+
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image, tag = image[:i], image[i+1:]
+	}
+
+	if host, rest, ok := strings.Cut(image, "/"); ok && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		image = rest
+	}
+
+	return image, tag
+}
@@ -0,0 +1,474 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+const (
+	// catalogRefreshInterval is the interval in which cached catalogs are
+	// checked for added or removed groups and CRDs
+	catalogRefreshInterval = time.Minute
+
+	// catalogIdleTimeout drops catalogs that were not requested for a while
+	// instead of refreshing them
+	catalogIdleTimeout = 30 * time.Minute
+
+	// catalogRefreshTimeout bounds the refresh of a catalog
+	catalogRefreshTimeout = 30 * time.Second
+)
+
+// resourceCatalogs caches the resource catalog per context and caller. The
+// catalogs are refreshed in the background while they are in use, and
+// refetched if their groups or CRDs change.
+type resourceCatalogs struct {
+	mu      sync.Mutex
+	entries map[string]*resourceCatalogEntry
+}
+
+type resourceCatalogEntry struct {
+	context string
+	auth    *config.AuthInfo
+
+	catalog     *ResourceCatalog
+	fingerprint string
+
+	fetched time.Time
+	used    time.Time
+}
+
+func (c *resourceCatalogs) get(key string) (*ResourceCatalog, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+
+	if !ok || time.Since(e.fetched) > discoveryTTL {
+		return nil, false
+	}
+
+	e.used = time.Now()
+
+	return e.catalog, true
+}
+
+func (c *resourceCatalogs) put(key string, e *resourceCatalogEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]*resourceCatalogEntry)
+	}
+
+	if prev, ok := c.entries[key]; ok && prev.used.After(e.used) {
+		e.used = prev.used
+	}
+
+	c.entries[key] = e
+}
+
+// revalidate marks an unchanged catalog as fetched.
+func (c *resourceCatalogs) revalidate(key string, e *resourceCatalogEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries[key] == e {
+		e.fetched = time.Now()
+	}
+}
+
+// active returns the catalogs in use and drops idle ones.
+func (c *resourceCatalogs) active() map[string]*resourceCatalogEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := map[string]*resourceCatalogEntry{}
+
+	for key, e := range c.entries {
+		if time.Since(e.used) > catalogIdleTimeout {
+			delete(c.entries, key)
+			continue
+		}
+
+		result[key] = e
+	}
+
+	return result
+}
+
+// invalidate drops all cached catalogs of a context.
+func (c *resourceCatalogs) invalidate(context string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix := strings.ToLower(context) + "/"
+
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// refreshCatalogs keeps the catalogs in use up to date, so the UI gets its
+// navigation from the cache even after CRDs were added or removed by other
+// clients.
+func (s *Server) refreshCatalogs(done <-chan struct{}) {
+	ticker := time.NewTicker(catalogRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+
+		case <-ticker.C:
+		}
+
+		sem := make(chan struct{}, 4)
+
+		var wg sync.WaitGroup
+
+		for key, e := range s.catalogs.active() {
+			if s.connectivity.offline(e.context) {
+				continue
+			}
+
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				ctx, cancel := context.WithTimeout(context.Background(), catalogRefreshTimeout)
+				defer cancel()
+
+				if err := s.refreshCatalog(ctx, key, e); err != nil {
+					log.Printf("failed to refresh resource catalog of context %q: %v", e.context, err)
+				}
+			}()
+		}
+
+		wg.Wait()
+	}
+}
+
+// refreshCatalog refetches a catalog if its groups or CRDs changed, or if it
+// expires.
+func (s *Server) refreshCatalog(ctx context.Context, key string, e *resourceCatalogEntry) error {
+	client, err := s.kubernetesClient(ctx, e.context, e.auth)
+
+	if err != nil {
+		return err
+	}
+
+	index, err := fetchDiscoveryIndex(ctx, client)
+
+	if err != nil {
+		return err
+	}
+
+	fingerprint := index.fingerprint()
+
+	changed := fingerprint != e.fingerprint
+
+	// resources of existing groups only change on upgrades, which the
+	// expiry covers
+	if !changed && time.Since(e.fetched) < discoveryTTL-catalogRefreshInterval {
+		return nil
+	}
+
+	catalog, err := fetchResourceCatalog(ctx, client, index)
+
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		log.Printf("API resources of context %q changed", e.context)
+
+		// changed CRDs are referenced by a new OpenAPI index
+		s.schemas.invalidate(e.context)
+	}
+
+	s.catalogs.put(key, &resourceCatalogEntry{
+		context: e.context,
+		auth:    e.auth,
+
+		catalog:     catalog,
+		fingerprint: fingerprint,
+
+		fetched: catalog.Fetched,
+	})
+
+	return nil
+}
+
+// handleResources returns the discovery catalog of a context grouped by API
+// group, including subresources and the CRDs defining custom resources.
+func (s *Server) handleResources(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	c, ok := s.kubernetesContext(r.PathValue("context"))
+
+	if !ok {
+		writeError(w, r, errContextNotFound, http.StatusNotFound)
+		return
+	}
+
+	key := strings.ToLower(c.Name) + "/" + credentialID(auth)
+
+	catalog, cached := s.catalogs.get(key)
+
+	if cached && r.URL.Query().Get("refresh") != "true" {
+		w.Header().Set("X-Bridge-Cache", "hit")
+	} else {
+		client, err := s.kubernetesClient(r.Context(), c.Name, auth)
+
+		if err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+
+		index, err := fetchDiscoveryIndex(r.Context(), client)
+
+		if err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+
+		catalog, err = fetchResourceCatalog(r.Context(), client, index)
+
+		if err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+
+		s.catalogs.put(key, &resourceCatalogEntry{
+			context: c.Name,
+			auth:    auth,
+
+			catalog:     catalog,
+			fingerprint: index.fingerprint(),
+
+			fetched: catalog.Fetched,
+			used:    time.Now(),
+		})
+
+		w.Header().Set("X-Bridge-Cache", "miss")
+	}
+
+	w.Header().Set("X-Bridge-Cache-Updated", catalog.Fetched.UTC().Format(time.RFC3339))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(catalog)
+}
+
+// discoveryIndex holds the API groups and CRDs of a context, which change if
+// CRDs or aggregated APIs are added or removed.
+type discoveryIndex struct {
+	core   metav1.APIVersions
+	groups metav1.APIGroupList
+
+	crds map[string]bool
+}
+
+func fetchDiscoveryIndex(ctx context.Context, client *kubernetesClient) (*discoveryIndex, error) {
+	index := &discoveryIndex{}
+
+	if err := client.get(ctx, "/api", nil, &index.core); err != nil {
+		return nil, err
+	}
+
+	if err := client.get(ctx, "/apis", nil, &index.groups); err != nil {
+		return nil, err
+	}
+
+	index.crds = fetchCRDNames(ctx, client)
+
+	return index, nil
+}
+
+// fingerprint identifies the group versions and CRDs of the index.
+func (i *discoveryIndex) fingerprint() string {
+	var items []string
+
+	for _, v := range i.core.Versions {
+		items = append(items, "/"+v)
+	}
+
+	for _, g := range i.groups.Groups {
+		for _, v := range g.Versions {
+			items = append(items, v.GroupVersion)
+		}
+	}
+
+	for name := range i.crds {
+		items = append(items, name)
+	}
+
+	slices.Sort(items)
+
+	hash := sha256.Sum256([]byte(strings.Join(items, "\n")))
+
+	return hex.EncodeToString(hash[:])
+}
+
+func fetchResourceCatalog(ctx context.Context, client *kubernetesClient, index *discoveryIndex) (*ResourceCatalog, error) {
+	core := index.core
+	groups := index.groups
+
+	catalog := &ResourceCatalog{
+		Groups: []ResourceGroup{},
+
+		Fetched: time.Now(),
+	}
+
+	if len(core.Versions) > 0 {
+		catalog.Groups = append(catalog.Groups, ResourceGroup{
+			PreferredVersion: core.Versions[0],
+			Versions:         core.Versions,
+
+			Resources: []ResourceInfo{},
+		})
+	}
+
+	for _, g := range groups.Groups {
+		group := ResourceGroup{
+			Name:             g.Name,
+			PreferredVersion: g.PreferredVersion.Version,
+
+			Resources: []ResourceInfo{},
+		}
+
+		for _, v := range g.Versions {
+			group.Versions = append(group.Versions, v.Version)
+		}
+
+		catalog.Groups = append(catalog.Groups, group)
+	}
+
+	crds := index.crds
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	sem := make(chan struct{}, 8)
+
+	for i := range catalog.Groups {
+		group := &catalog.Groups[i]
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			path := "/apis/" + group.Name + "/" + group.PreferredVersion
+
+			if group.Name == "" {
+				path = "/api/" + group.PreferredVersion
+			}
+
+			var list metav1.APIResourceList
+
+			if err := client.get(ctx, path, nil, &list); err != nil {
+				// aggregated APIs may be unavailable without breaking the catalog
+				mu.Lock()
+				catalog.Failed = append(catalog.Failed, strings.TrimPrefix(path, "/apis/"))
+				mu.Unlock()
+
+				return
+			}
+
+			group.Resources = resourceInfos(group.Name, group.PreferredVersion, list.APIResources, crds)
+		}()
+	}
+
+	wg.Wait()
+
+	slices.Sort(catalog.Failed)
+
+	return catalog, nil
+}
+
+func resourceInfos(group, version string, resources []metav1.APIResource, crds map[string]bool) []ResourceInfo {
+	result := []ResourceInfo{}
+	index := map[string]int{}
+
+	for _, r := range resources {
+		if strings.Contains(r.Name, "/") {
+			continue
+		}
+
+		index[r.Name] = len(result)
+
+		result = append(result, ResourceInfo{
+			Name:    r.Name,
+			Kind:    r.Kind,
+			Version: version,
+
+			Namespaced: r.Namespaced,
+
+			Verbs:      r.Verbs,
+			ShortNames: r.ShortNames,
+			Categories: r.Categories,
+		})
+
+		if name := r.Name + "." + group; crds[name] {
+			result[len(result)-1].CRD = name
+		}
+	}
+
+	for _, r := range resources {
+		parent, sub, ok := strings.Cut(r.Name, "/")
+
+		if !ok {
+			continue
+		}
+
+		if i, found := index[parent]; found {
+			result[i].Subresources = append(result[i].Subresources, sub)
+		}
+	}
+
+	slices.SortFunc(result, func(a, b ResourceInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return result
+}
+
+// fetchCRDNames returns the names of all CRDs (plural.group). Callers without
+// permission to list CRDs get an empty set.
+func fetchCRDNames(ctx context.Context, client *kubernetesClient) map[string]bool {
+	result := map[string]bool{}
+
+	var list metav1.PartialObjectMetadataList
+
+	if err := client.getMetadata(ctx, "/apis/apiextensions.k8s.io/v1/customresourcedefinitions", nil, &list); err != nil {
+		return result
+	}
+
+	for _, item := range list.Items {
+		result[item.Name] = true
+	}
+
+	return result
+}
@@ -0,0 +1,567 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/i18n"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	revisionAnnotation    = "deployment.kubernetes.io/revision"
+	changeCauseAnnotation = "kubernetes.io/change-cause"
+)
+
+// rolloutKinds are the workloads rolled out by their controllers.
+var rolloutKinds = []string{"deployments", "statefulsets", "daemonsets"}
+
+// rolloutTarget is a workload addressed by the rollout endpoints.
+type rolloutTarget struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+func (t rolloutTarget) path() string {
+	return "/apis/apps/v1/namespaces/" + t.namespace + "/" + t.kind + "/" + t.name
+}
+
+// rolloutRequest reads the workload of a rollout request and validates its
+// kind.
+func rolloutRequest(w http.ResponseWriter, r *http.Request) (rolloutTarget, bool) {
+	t := rolloutTarget{
+		kind:      r.PathValue("kind"),
+		namespace: r.PathValue("namespace"),
+		name:      r.PathValue("name"),
+	}
+
+	if !slices.Contains(rolloutKinds, t.kind) {
+		http.Error(w, "kind must be deployments, statefulsets or daemonsets", http.StatusBadRequest)
+		return t, false
+	}
+
+	return t, true
+}
+
+// handleRolloutStatus reports the progress of the rollout of a workload,
+// like kubectl rollout status, and its revision history.
+func (s *Server) handleRolloutStatus(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	target, ok := rolloutRequest(w, r)
+
+	if !ok {
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), r.PathValue("context"), auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	status, err := rolloutStatus(r.Context(), client, target)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleRolloutAction restarts, pauses, resumes or undoes the rollout of a
+// workload, patching it the way kubectl rollout does.
+func (s *Server) handleRolloutAction(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	action := r.PathValue("action")
+
+	target, ok := rolloutRequest(w, r)
+
+	if !ok {
+		return
+	}
+
+	var req RolloutRequest
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch action {
+	case "restart", "undo":
+	case "pause", "resume":
+		if target.kind != "deployments" {
+			http.Error(w, "only deployments can be paused and resumed", http.StatusBadRequest)
+			return
+		}
+
+	default:
+		http.Error(w, "action must be restart, pause, resume or undo", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.checkProtection(r, name, target.namespace); err != nil {
+		writeProtectionError(w, r, err)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	// restarts and undos replace all pods of the workload
+	if action == "restart" || action == "undo" {
+		if limit := s.config.Limits.MaxDisruptionsPerMinute; limit > 0 {
+			if ok, wait := s.disruptions.allow(strings.ToLower(name)+"/"+target.namespace, limit); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+
+				writeError(w, r, i18n.NewError("error.disruption_rate", target.namespace, limit), http.StatusTooManyRequests)
+				return
+			}
+		}
+	}
+
+	switch action {
+	case "restart":
+		patch, _ := json.Marshal(map[string]any{
+			"spec": map[string]any{
+				"template": map[string]any{
+					"metadata": map[string]any{
+						"annotations": map[string]any{
+							restartAnnotation: time.Now().Format(time.RFC3339),
+						},
+					},
+				},
+			},
+		})
+
+		err = client.patch(r.Context(), target.path(), nil, "application/strategic-merge-patch+json", patch, nil)
+
+	case "pause", "resume":
+		patch, _ := json.Marshal(map[string]any{
+			"spec": map[string]any{
+				"paused": action == "pause",
+			},
+		})
+
+		err = client.patch(r.Context(), target.path(), nil, "application/merge-patch+json", patch, nil)
+
+	case "undo":
+		err = undoRollout(r.Context(), client, target, req.Revision)
+	}
+
+	entry := &AuditEntry{
+		Context: name,
+		Owner:   ownerID(auth),
+		Action:  "rollout-" + action,
+
+		Resource:  target.kind,
+		Namespace: target.namespace,
+		Name:      target.name,
+	}
+
+	if action == "undo" && req.Revision > 0 {
+		entry.Name += "@" + strconv.FormatInt(req.Revision, 10)
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	s.audit.record(entry)
+
+	if err != nil {
+		// the revision cannot be rolled back to
+		if _, ok := err.(*i18n.Error); ok {
+			writeError(w, r, err, http.StatusConflict)
+			return
+		}
+
+		writeClientError(w, r, err)
+		return
+	}
+
+	status, err := rolloutStatus(r.Context(), client, target)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// undoRollout rolls a workload back to a revision, or to the previous one
+// if revision is 0. Deployments get the pod template of the replica set of
+// the revision; statefulsets and daemonsets get the patch stored in the
+// controller revision.
+func undoRollout(ctx context.Context, client *kubernetesClient, target rolloutTarget, revision int64) error {
+	history, err := rolloutHistory(ctx, client, target)
+
+	if err != nil {
+		return err
+	}
+
+	if revision == 0 {
+		if len(history) < 2 {
+			return i18n.NewError("error.rollout_no_previous", target.name)
+		}
+
+		revision = history[len(history)-2].Revision
+	}
+
+	index := slices.IndexFunc(history, func(r rolloutRevision) bool {
+		return r.Revision == revision
+	})
+
+	if index < 0 {
+		return i18n.NewError("error.rollout_revision", revision, target.name)
+	}
+
+	rev := history[index]
+
+	if target.kind != "deployments" {
+		return client.patch(ctx, target.path(), nil, "application/strategic-merge-patch+json", rev.data, nil)
+	}
+
+	var deployment appsv1.Deployment
+
+	if err := client.get(ctx, target.path(), nil, &deployment); err != nil {
+		return err
+	}
+
+	if deployment.Spec.Paused {
+		return i18n.NewError("error.rollout_paused", target.name)
+	}
+
+	template := rev.template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+
+	// fails if the deployment changed since it was read
+	patch, err := json.Marshal([]map[string]any{
+		{"op": "test", "path": "/metadata/resourceVersion", "value": deployment.ResourceVersion},
+		{"op": "replace", "path": "/spec/template", "value": template},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return client.patch(ctx, target.path(), nil, "application/json-patch+json", patch, nil)
+}
+
+// rolloutRevision is a revision of a workload: a replica set of a
+// deployment, or a controller revision of a statefulset or daemonset.
+type rolloutRevision struct {
+	RolloutRevision
+
+	template corev1.PodTemplateSpec
+
+	// data is the patch of a controller revision
+	data []byte
+}
+
+// rolloutHistory returns the revisions of a workload, oldest first.
+func rolloutHistory(ctx context.Context, client *kubernetesClient, target rolloutTarget) ([]rolloutRevision, error) {
+	var obj struct {
+		metav1.ObjectMeta `json:"metadata"`
+
+		Spec struct {
+			Selector *metav1.LabelSelector `json:"selector"`
+		} `json:"spec"`
+	}
+
+	if err := client.get(ctx, target.path(), nil, &obj); err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+
+	if obj.Spec.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(obj.Spec.Selector)
+
+		if err != nil {
+			return nil, err
+		}
+
+		query.Set("labelSelector", selector.String())
+	}
+
+	owned := func(refs []metav1.OwnerReference) bool {
+		return slices.ContainsFunc(refs, func(ref metav1.OwnerReference) bool {
+			return ref.UID == obj.UID
+		})
+	}
+
+	var result []rolloutRevision
+
+	if target.kind == "deployments" {
+		var list appsv1.ReplicaSetList
+
+		if err := client.get(ctx, "/apis/apps/v1/namespaces/"+target.namespace+"/replicasets", query, &list); err != nil {
+			return nil, err
+		}
+
+		for _, rs := range list.Items {
+			revision, err := strconv.ParseInt(rs.Annotations[revisionAnnotation], 10, 64)
+
+			if err != nil || !owned(rs.OwnerReferences) {
+				continue
+			}
+
+			result = append(result, rolloutRevision{
+				RolloutRevision: RolloutRevision{
+					Revision:    revision,
+					Name:        rs.Name,
+					ChangeCause: rs.Annotations[changeCauseAnnotation],
+					Created:     rs.CreationTimestamp.Time,
+					Replicas:    rs.Status.Replicas,
+				},
+
+				template: rs.Spec.Template,
+			})
+		}
+	} else {
+		var list appsv1.ControllerRevisionList
+
+		if err := client.get(ctx, "/apis/apps/v1/namespaces/"+target.namespace+"/controllerrevisions", query, &list); err != nil {
+			return nil, err
+		}
+
+		for _, cr := range list.Items {
+			if !owned(cr.OwnerReferences) {
+				continue
+			}
+
+			var data struct {
+				Spec struct {
+					Template corev1.PodTemplateSpec `json:"template"`
+				} `json:"spec"`
+			}
+
+			json.Unmarshal(cr.Data.Raw, &data)
+
+			result = append(result, rolloutRevision{
+				RolloutRevision: RolloutRevision{
+					Revision:    cr.Revision,
+					Name:        cr.Name,
+					ChangeCause: cr.Annotations[changeCauseAnnotation],
+					Created:     cr.CreationTimestamp.Time,
+				},
+
+				template: data.Spec.Template,
+				data:     cr.Data.Raw,
+			})
+		}
+	}
+
+	slices.SortFunc(result, func(a, b rolloutRevision) int {
+		return int(a.Revision - b.Revision)
+	})
+
+	for i := range result {
+		for _, c := range result[i].template.Spec.Containers {
+			result[i].Images = append(result[i].Images, c.Image)
+		}
+	}
+
+	// controllers move the revision rolled back to to the top
+	if len(result) > 0 {
+		result[len(result)-1].Current = true
+	}
+
+	return result, nil
+}
+
+// rolloutStatus reports the progress of a rollout with the messages of
+// kubectl rollout status.
+func rolloutStatus(ctx context.Context, client *kubernetesClient, target rolloutTarget) (*RolloutStatus, error) {
+	status := &RolloutStatus{
+		Kind:      target.kind,
+		Namespace: target.namespace,
+		Name:      target.name,
+
+		History: []RolloutRevision{},
+	}
+
+	switch target.kind {
+	case "deployments":
+		var d appsv1.Deployment
+
+		if err := client.get(ctx, target.path(), nil, &d); err != nil {
+			return nil, err
+		}
+
+		status.Generation = d.Generation
+		status.ObservedGeneration = d.Status.ObservedGeneration
+		status.Paused = d.Spec.Paused
+
+		status.Desired = 1
+
+		if d.Spec.Replicas != nil {
+			status.Desired = *d.Spec.Replicas
+		}
+
+		status.Replicas = d.Status.Replicas
+		status.Updated = d.Status.UpdatedReplicas
+		status.Ready = d.Status.ReadyReplicas
+		status.Available = d.Status.AvailableReplicas
+
+		progressing := slices.IndexFunc(d.Status.Conditions, func(c appsv1.DeploymentCondition) bool {
+			return c.Type == appsv1.DeploymentProgressing
+		})
+
+		switch {
+		case d.Generation > d.Status.ObservedGeneration:
+			status.Message = "waiting for the deployment spec update to be observed"
+
+		case progressing >= 0 && d.Status.Conditions[progressing].Reason == "ProgressDeadlineExceeded":
+			status.Failed = true
+			status.Message = fmt.Sprintf("deployment %q exceeded its progress deadline", d.Name)
+
+		case status.Updated < status.Desired:
+			status.Message = fmt.Sprintf("%d out of %d new replicas have been updated", status.Updated, status.Desired)
+
+		case status.Replicas > status.Updated:
+			status.Message = fmt.Sprintf("%d old replicas are pending termination", status.Replicas-status.Updated)
+
+		case status.Available < status.Updated:
+			status.Message = fmt.Sprintf("%d of %d updated replicas are available", status.Available, status.Updated)
+
+		default:
+			status.Done = true
+			status.Message = fmt.Sprintf("deployment %q successfully rolled out", d.Name)
+		}
+
+		if d.Spec.Paused && !status.Done {
+			status.Message += " (paused)"
+		}
+
+	case "statefulsets":
+		var sts appsv1.StatefulSet
+
+		if err := client.get(ctx, target.path(), nil, &sts); err != nil {
+			return nil, err
+		}
+
+		status.Generation = sts.Generation
+		status.ObservedGeneration = sts.Status.ObservedGeneration
+
+		status.Desired = 1
+
+		if sts.Spec.Replicas != nil {
+			status.Desired = *sts.Spec.Replicas
+		}
+
+		status.Replicas = sts.Status.Replicas
+		status.Updated = sts.Status.UpdatedReplicas
+		status.Ready = sts.Status.ReadyReplicas
+		status.Available = sts.Status.AvailableReplicas
+
+		var partition int32
+
+		if u := sts.Spec.UpdateStrategy.RollingUpdate; u != nil && u.Partition != nil {
+			partition = *u.Partition
+		}
+
+		switch {
+		case sts.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType:
+			status.Message = "rollout status is not available for the OnDelete strategy"
+
+		case sts.Generation > sts.Status.ObservedGeneration:
+			status.Message = "waiting for the statefulset spec update to be observed"
+
+		case status.Ready < status.Desired:
+			status.Message = fmt.Sprintf("waiting for %d pods to be ready", status.Desired-status.Ready)
+
+		case partition > 0:
+			if status.Updated < status.Desired-partition {
+				status.Message = fmt.Sprintf("waiting for the partitioned rollout: %d of %d new pods have been updated", status.Updated, status.Desired-partition)
+				break
+			}
+
+			status.Done = true
+			status.Message = fmt.Sprintf("partitioned rollout complete: %d new pods have been updated", status.Updated)
+
+		case sts.Status.UpdateRevision != sts.Status.CurrentRevision:
+			status.Message = fmt.Sprintf("waiting for the rolling update to complete: %d pods at revision %s", status.Updated, sts.Status.UpdateRevision)
+
+		default:
+			status.Done = true
+			status.Message = fmt.Sprintf("statefulset rolling update complete: %d pods at revision %s", status.Ready, sts.Status.CurrentRevision)
+		}
+
+	case "daemonsets":
+		var ds appsv1.DaemonSet
+
+		if err := client.get(ctx, target.path(), nil, &ds); err != nil {
+			return nil, err
+		}
+
+		status.Generation = ds.Generation
+		status.ObservedGeneration = ds.Status.ObservedGeneration
+
+		status.Desired = ds.Status.DesiredNumberScheduled
+		status.Replicas = ds.Status.CurrentNumberScheduled
+		status.Updated = ds.Status.UpdatedNumberScheduled
+		status.Ready = ds.Status.NumberReady
+		status.Available = ds.Status.NumberAvailable
+
+		switch {
+		case ds.Spec.UpdateStrategy.Type == appsv1.OnDeleteDaemonSetStrategyType:
+			status.Message = "rollout status is not available for the OnDelete strategy"
+
+		case ds.Generation > ds.Status.ObservedGeneration:
+			status.Message = "waiting for the daemonset spec update to be observed"
+
+		case status.Updated < status.Desired:
+			status.Message = fmt.Sprintf("%d out of %d new pods have been updated", status.Updated, status.Desired)
+
+		case status.Available < status.Desired:
+			status.Message = fmt.Sprintf("%d of %d updated pods are available", status.Available, status.Desired)
+
+		default:
+			status.Done = true
+			status.Message = fmt.Sprintf("daemonset %q successfully rolled out", ds.Name)
+		}
+	}
+
+	history, err := rolloutHistory(ctx, client, target)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rev := range history {
+		status.History = append(status.History, rev.RolloutRevision)
+
+		if rev.Current {
+			status.Revision = rev.Revision
+		}
+	}
+
+	return status, nil
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/adrianliechti/bridge/pkg/i18n"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// handleScaleObject scales an object through its scale subresource. If a
+// HorizontalPodAutoscaler targets the object, it is not scaled unless forced,
+// as the autoscaler would revert the change: the autoscaler is returned with
+// a conflict instead, so the caller can decide to override it or to change
+// the bounds of the autoscaler. Scaling down counts as a disruption.
+func (s *Server) handleScaleObject(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	target := objectResource(r)
+
+	var req ScaleRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Replicas < 0 {
+		http.Error(w, "replicas must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if target.Namespace == "" {
+		http.Error(w, "namespace is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.checkProtection(r, name, target.Namespace); err != nil {
+		writeProtectionError(w, r, err)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	path := target.Path() + "/scale"
+
+	var scale autoscalingv1.Scale
+
+	if err := client.get(r.Context(), path, nil, &scale); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	var obj metav1.PartialObjectMetadata
+
+	if err := client.get(r.Context(), target.Path(), nil, &obj); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := &ScaleResult{
+		Replicas: req.Replicas,
+		Previous: int64(scale.Spec.Replicas),
+	}
+
+	autoscaler, err := scaleAutoscaler(r.Context(), client, target, obj.Kind)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	if autoscaler != nil {
+		result.Autoscaler = autoscaler
+		result.Warning = i18n.Translate(i18n.Negotiate(r), "error.scale_autoscaler", target.Name, autoscaler.Name, autoscaler.MinReplicas, autoscaler.MaxReplicas)
+
+		if !req.Force {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+
+			json.NewEncoder(w).Encode(result)
+			return
+		}
+	}
+
+	if req.Replicas < result.Previous {
+		if limit := s.config.Limits.MaxDisruptionsPerMinute; limit > 0 {
+			if ok, wait := s.disruptions.allow(strings.ToLower(name)+"/"+target.Namespace, limit); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+
+				writeError(w, r, i18n.NewError("error.disruption_rate", target.Namespace, limit), http.StatusTooManyRequests)
+				return
+			}
+		}
+	}
+
+	patch, _ := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"replicas": req.Replicas,
+		},
+	})
+
+	err = client.patch(r.Context(), path, nil, "application/merge-patch+json", patch, nil)
+
+	audit := map[string]any{"replicas": req.Replicas}
+
+	if autoscaler != nil {
+		audit["autoscaler"] = autoscaler.Name
+	}
+
+	entry := &AuditEntry{
+		Context: name,
+		Owner:   ownerID(auth),
+		Action:  "scale",
+
+		Resource:  target.Resource,
+		Namespace: target.Namespace,
+		Name:      target.Name,
+
+		Patch: audit,
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	s.audit.record(entry)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result.Scaled = true
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// scaleAutoscaler returns the HorizontalPodAutoscaler targeting an object,
+// if any.
+func scaleAutoscaler(ctx context.Context, client *kubernetesClient, target *kubernetesRequest, kind string) (*ScaleAutoscaler, error) {
+	var list autoscalingv2.HorizontalPodAutoscalerList
+
+	if err := client.get(ctx, "/apis/autoscaling/v2/namespaces/"+target.Namespace+"/horizontalpodautoscalers", nil, &list); err != nil {
+		// clusters without autoscaling/v2 have no autoscalers to fight
+		if statusCode(err) == http.StatusNotFound {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	for _, hpa := range list.Items {
+		ref := hpa.Spec.ScaleTargetRef
+
+		gv, _ := schema.ParseGroupVersion(ref.APIVersion)
+
+		if ref.Name != target.Name || ref.Kind != kind || gv.Group != target.Group {
+			continue
+		}
+
+		result := &ScaleAutoscaler{
+			Name: hpa.Name,
+
+			MinReplicas: 1,
+			MaxReplicas: hpa.Spec.MaxReplicas,
+
+			CurrentReplicas: hpa.Status.CurrentReplicas,
+			DesiredReplicas: hpa.Status.DesiredReplicas,
+		}
+
+		if hpa.Spec.MinReplicas != nil {
+			result.MinReplicas = *hpa.Spec.MinReplicas
+		}
+
+		return result, nil
+	}
+
+	return nil, nil
+}
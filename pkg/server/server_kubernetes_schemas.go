@@ -0,0 +1,396 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+const schemaRefPrefix = "#/components/schemas/"
+
+// openAPISchemas caches OpenAPI v3 documents for the schema endpoint. The
+// index of a context maps group versions to document URLs, which include a
+// content hash: it is cached per caller for the discovery TTL, while the
+// documents it references never change and are shared by all callers.
+// Changing CRDs invalidates the index, which then references new hashes.
+type openAPISchemas struct {
+	mu        sync.Mutex
+	indexes   map[string]*openAPIIndex
+	documents map[string]*openAPIDocument
+}
+
+type openAPIIndex struct {
+	paths   map[string]string
+	fetched time.Time
+}
+
+// openAPIDocument holds the components and operations of a group version
+// document, and the self-contained schemas of kinds resolved from them.
+type openAPIDocument struct {
+	components map[string]json.RawMessage
+
+	// paths and parameters describe the operations of the group version
+	paths      map[string]json.RawMessage
+	parameters map[string]json.RawMessage
+
+	mu       sync.Mutex
+	resolved map[string][]byte
+}
+
+func (s *openAPISchemas) index(key string) (map[string]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.indexes[key]
+
+	if !ok || time.Since(e.fetched) > discoveryTTL {
+		return nil, false
+	}
+
+	return e.paths, true
+}
+
+func (s *openAPISchemas) putIndex(key string, paths map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.indexes == nil {
+		s.indexes = make(map[string]*openAPIIndex)
+	}
+
+	s.indexes[key] = &openAPIIndex{
+		paths:   paths,
+		fetched: time.Now(),
+	}
+}
+
+func (s *openAPISchemas) document(key string) (*openAPIDocument, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.documents[key]
+	return d, ok
+}
+
+func (s *openAPISchemas) putDocument(key string, d *openAPIDocument) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.documents == nil {
+		s.documents = make(map[string]*openAPIDocument)
+	}
+
+	// drops former hashes of the group version
+	path, _, _ := strings.Cut(key, "?")
+
+	for k := range s.documents {
+		if p, _, _ := strings.Cut(k, "?"); p == path {
+			delete(s.documents, k)
+		}
+	}
+
+	s.documents[key] = d
+}
+
+// invalidate drops the cached indexes and documents of a context.
+func (s *openAPISchemas) invalidate(context string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prefix := strings.ToLower(context) + "/"
+
+	for key := range s.indexes {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.indexes, key)
+		}
+	}
+
+	for key := range s.documents {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.documents, key)
+		}
+	}
+}
+
+// handleSchema returns the OpenAPI v3 schema of a kind, e.g.
+// /schemas/Deployment.v1.apps or /schemas/Pod.v1 for the core group. The
+// schema is self-contained: the schemas it references are included under
+// components.schemas, so its $refs resolve within the response. Schemas are
+// served from the cache and carry the hash of their document as ETag.
+func (s *Server) handleSchema(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	c, ok := s.kubernetesContext(r.PathValue("context"))
+
+	if !ok {
+		writeError(w, r, errContextNotFound, http.StatusNotFound)
+		return
+	}
+
+	gvk := r.PathValue("gvk")
+
+	kind, gv, ok := strings.Cut(gvk, ".")
+
+	if !ok || kind == "" || gv == "" {
+		writeError(w, r, i18n.NewError("error.schema_invalid_kind", gvk), http.StatusBadRequest)
+		return
+	}
+
+	version, group, _ := strings.Cut(gv, ".")
+
+	client, err := s.kubernetesClient(r.Context(), c.Name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	doc, target, status, err := s.loadOpenAPIDocument(r.Context(), c.Name, auth, client, group, version, r.URL.Query().Get("refresh") == "true")
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	if doc == nil {
+		writeError(w, r, i18n.NewError("error.schema_not_found", gvk), http.StatusNotFound)
+		return
+	}
+
+	schema, ok := doc.schema(group, version, kind)
+
+	if !ok {
+		writeError(w, r, i18n.NewError("error.schema_not_found", gvk), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("X-Bridge-Cache", status)
+
+	if hash := target.Query().Get("hash"); hash != "" {
+		etag := `"` + hash + "-" + kind + `"`
+
+		w.Header().Set("ETag", etag)
+
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(schema)
+}
+
+// loadOpenAPIDocument returns the OpenAPI v3 document of a group version,
+// from the cache unless refreshed, and the URL it was fetched from. The
+// status is hit or miss. The document is nil if the group version is not
+// served.
+func (s *Server) loadOpenAPIDocument(ctx context.Context, name string, auth *config.AuthInfo, client *kubernetesClient, group, version string, refresh bool) (*openAPIDocument, *url.URL, string, error) {
+	path := "apis/" + group + "/" + version
+
+	if group == "" {
+		path = "api/" + version
+	}
+
+	key := strings.ToLower(name) + "/" + credentialID(auth)
+
+	paths, cached := s.schemas.index(key)
+
+	if !cached || refresh {
+		var index struct {
+			Paths map[string]struct {
+				ServerRelativeURL string `json:"serverRelativeURL"`
+			} `json:"paths"`
+		}
+
+		if err := client.get(ctx, "/openapi/v3", nil, &index); err != nil {
+			return nil, nil, "", err
+		}
+
+		paths = map[string]string{}
+
+		for p, v := range index.Paths {
+			paths[p] = v.ServerRelativeURL
+		}
+
+		s.schemas.putIndex(key, paths)
+	}
+
+	ref, ok := paths[path]
+
+	if !ok {
+		return nil, nil, "", nil
+	}
+
+	target, err := url.Parse(ref)
+
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	status := "hit"
+
+	// the document URL includes its hash, so equal URLs hold equal documents
+	docKey := strings.ToLower(name) + "/" + ref
+
+	doc, cached := s.schemas.document(docKey)
+
+	if !cached {
+		var data struct {
+			Paths map[string]json.RawMessage `json:"paths"`
+
+			Components struct {
+				Schemas    map[string]json.RawMessage `json:"schemas"`
+				Parameters map[string]json.RawMessage `json:"parameters"`
+			} `json:"components"`
+		}
+
+		if err := client.get(ctx, target.Path, target.Query(), &data); err != nil {
+			return nil, nil, "", err
+		}
+
+		doc = &openAPIDocument{
+			components: data.Components.Schemas,
+
+			paths:      data.Paths,
+			parameters: data.Components.Parameters,
+		}
+
+		s.schemas.putDocument(docKey, doc)
+
+		status = "miss"
+	}
+
+	return doc, target, status, nil
+}
+
+// schema returns the schema of a kind with all schemas it references.
+func (d *openAPIDocument) schema(group, version, kind string) ([]byte, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if data, ok := d.resolved[kind]; ok {
+		return data, true
+	}
+
+	name, ok := d.find(group, version, kind)
+
+	if !ok {
+		return nil, false
+	}
+
+	var root map[string]any
+
+	if err := json.Unmarshal(d.components[name], &root); err != nil {
+		return nil, false
+	}
+
+	refs := map[string]any{}
+	pending := []any{root}
+
+	for len(pending) > 0 {
+		node := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		for _, ref := range schemaRefs(node) {
+			if _, seen := refs[ref]; seen {
+				continue
+			}
+
+			var schema any
+
+			if err := json.Unmarshal(d.components[ref], &schema); err != nil {
+				continue
+			}
+
+			refs[ref] = schema
+			pending = append(pending, schema)
+		}
+	}
+
+	if len(refs) > 0 {
+		root["components"] = map[string]any{
+			"schemas": refs,
+		}
+	}
+
+	data, err := json.Marshal(root)
+
+	if err != nil {
+		return nil, false
+	}
+
+	if d.resolved == nil {
+		d.resolved = make(map[string][]byte)
+	}
+
+	d.resolved[kind] = data
+
+	return data, true
+}
+
+// find returns the name of the schema declaring a group version kind.
+func (d *openAPIDocument) find(group, version, kind string) (string, bool) {
+	var names []string
+
+	for name, data := range d.components {
+		var schema struct {
+			GVKs []struct {
+				Group   string `json:"group"`
+				Version string `json:"version"`
+				Kind    string `json:"kind"`
+			} `json:"x-kubernetes-group-version-kind"`
+		}
+
+		if err := json.Unmarshal(data, &schema); err != nil {
+			continue
+		}
+
+		for _, gvk := range schema.GVKs {
+			if gvk.Group == group && gvk.Version == version && gvk.Kind == kind {
+				names = append(names, name)
+			}
+		}
+	}
+
+	if len(names) == 0 {
+		return "", false
+	}
+
+	// prefer a stable choice if several schemas declare the kind
+	slices.Sort(names)
+
+	return names[0], true
+}
+
+// schemaRefs returns the names of the component schemas referenced by a
+// schema and its nested schemas.
+func schemaRefs(node any) []string {
+	var result []string
+
+	switch v := node.(type) {
+	case map[string]any:
+		if ref, ok := v["$ref"].(string); ok && strings.HasPrefix(ref, schemaRefPrefix) {
+			result = append(result, strings.TrimPrefix(ref, schemaRefPrefix))
+		}
+
+		for _, child := range v {
+			result = append(result, schemaRefs(child)...)
+		}
+
+	case []any:
+		for _, child := range v {
+			result = append(result, schemaRefs(child)...)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,485 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/health"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// searchTTL is how long the objects of a context are reused between searches.
+const searchTTL = 30 * time.Second
+
+// searchResources are indexed for search. Workloads are listed in full to
+// read their images, everything else as metadata only.
+var searchResources = []struct {
+	Group    string
+	Version  string
+	Resource string
+	Kind     string
+
+	Full bool
+}{
+	{"", "v1", "pods", "Pod", true},
+	{"apps", "v1", "deployments", "Deployment", true},
+	{"apps", "v1", "statefulsets", "StatefulSet", true},
+	{"apps", "v1", "daemonsets", "DaemonSet", true},
+	{"batch", "v1", "jobs", "Job", true},
+	{"batch", "v1", "cronjobs", "CronJob", true},
+	{"", "v1", "services", "Service", false},
+	{"", "v1", "configmaps", "ConfigMap", false},
+	{"", "v1", "secrets", "Secret", false},
+	{"", "v1", "persistentvolumeclaims", "PersistentVolumeClaim", false},
+	{"", "v1", "namespaces", "Namespace", false},
+	{"", "v1", "nodes", "Node", false},
+	{"networking.k8s.io", "v1", "ingresses", "Ingress", false},
+}
+
+var searchFields = []string{"name", "labels", "annotations", "images", "health"}
+
+type searchObject struct {
+	Group    string
+	Version  string
+	Resource string
+	Kind     string
+
+	Namespace string
+	Name      string
+
+	Labels      map[string]string
+	Annotations map[string]string
+
+	Images []string
+
+	// Health of objects listed in full
+	Health *health.Result
+}
+
+// searchIndex caches the objects of a context per caller.
+type searchIndex struct {
+	mu      sync.Mutex
+	entries map[string]*searchIndexEntry
+}
+
+type searchIndexEntry struct {
+	objects []searchObject
+	errors  []string
+	fetched time.Time
+}
+
+func (i *searchIndex) get(key string) (*searchIndexEntry, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	e, ok := i.entries[key]
+
+	if !ok || time.Since(e.fetched) > searchTTL {
+		return nil, false
+	}
+
+	return e, true
+}
+
+func (i *searchIndex) put(key string, e *searchIndexEntry) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.entries == nil {
+		i.entries = make(map[string]*searchIndexEntry)
+	}
+
+	for k, v := range i.entries {
+		if time.Since(v.fetched) > searchTTL {
+			delete(i.entries, k)
+		}
+	}
+
+	i.entries[key] = e
+}
+
+// searchQuery selects and matches indexed objects.
+type searchQuery struct {
+	Contexts  []string
+	Namespace string
+
+	Fields []string
+	Match  func(string) bool
+
+	Limit int
+}
+
+func newSearchQuery(q SearchQuery) (*searchQuery, error) {
+	if q.Query == "" {
+		return nil, errors.New("q is required")
+	}
+
+	if len(q.Contexts) == 0 {
+		return nil, errors.New("at least one context is required")
+	}
+
+	query := &searchQuery{
+		Contexts:  q.Contexts,
+		Namespace: q.Namespace,
+
+		Fields: searchFields,
+
+		Match: func(v string) bool {
+			return strings.Contains(strings.ToLower(v), strings.ToLower(q.Query))
+		},
+
+		Limit: 500,
+	}
+
+	if q.Regex {
+		re, err := regexp.Compile(q.Query)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+
+		query.Match = re.MatchString
+	}
+
+	if len(q.Fields) > 0 {
+		for _, f := range q.Fields {
+			if !slices.Contains(searchFields, f) {
+				return nil, fmt.Errorf("unknown field %s", f)
+			}
+		}
+
+		query.Fields = q.Fields
+	}
+
+	return query, nil
+}
+
+// handleSearch finds objects by name, labels, annotations, container images
+// or health across contexts, e.g.
+// /search?contexts=dev,prod&q=nginx:1.25&fields=images
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	values := r.URL.Query()
+
+	q := SearchQuery{
+		Query:     values.Get("q"),
+		Regex:     values.Get("regex") == "true",
+		Namespace: values.Get("namespace"),
+	}
+
+	for _, v := range values["contexts"] {
+		q.Contexts = append(q.Contexts, splitNames(v)...)
+	}
+
+	if v := values.Get("fields"); v != "" {
+		q.Fields = splitNames(v)
+	}
+
+	query, err := newSearchQuery(q)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+
+		query.Limit = n
+	}
+
+	result := s.runSearch(r.Context(), auth, query, values.Get("refresh") == "true")
+
+	writeList(w, r, "search", result, result.Items)
+}
+
+// runSearch matches the indexed objects of the contexts of a query. Total
+// counts all matches, also beyond the limit.
+func (s *Server) runSearch(ctx context.Context, auth *config.AuthInfo, query *searchQuery, refresh bool) *SearchResult {
+	entries := make([]*searchIndexEntry, len(query.Contexts))
+
+	var wg sync.WaitGroup
+
+	for i, name := range query.Contexts {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			entries[i] = s.searchObjects(ctx, name, auth, refresh)
+		}()
+	}
+
+	wg.Wait()
+
+	result := &SearchResult{
+		Items:  []SearchItem{},
+		Errors: []string{},
+	}
+
+	for i, e := range entries {
+		for _, err := range e.errors {
+			result.Errors = append(result.Errors, query.Contexts[i]+": "+err)
+		}
+
+		for _, o := range e.objects {
+			if query.Namespace != "" && o.Namespace != query.Namespace {
+				continue
+			}
+
+			matches := searchObjectMatches(o, query.Fields, query.Match)
+
+			if len(matches) == 0 {
+				continue
+			}
+
+			result.Total++
+
+			if len(result.Items) >= query.Limit {
+				result.Truncated = true
+				continue
+			}
+
+			result.Items = append(result.Items, SearchItem{
+				Context: query.Contexts[i],
+
+				Group:    o.Group,
+				Version:  o.Version,
+				Resource: o.Resource,
+				Kind:     o.Kind,
+
+				Namespace: o.Namespace,
+				Name:      o.Name,
+
+				Matches: matches,
+			})
+		}
+	}
+
+	return result
+}
+
+func searchObjectMatches(o searchObject, fields []string, match func(string) bool) []SearchMatch {
+	var result []SearchMatch
+
+	pairs := func(field string, m map[string]string) {
+		for _, k := range slices.Sorted(maps.Keys(m)) {
+			if v := k + "=" + m[k]; match(v) {
+				result = append(result, SearchMatch{Field: field, Value: v})
+			}
+		}
+	}
+
+	for _, f := range fields {
+		switch f {
+		case "name":
+			if match(o.Name) {
+				result = append(result, SearchMatch{Field: f, Value: o.Name})
+			}
+
+		case "labels":
+			pairs(f, o.Labels)
+
+		case "annotations":
+			pairs(f, o.Annotations)
+
+		case "health":
+			if o.Health == nil {
+				continue
+			}
+
+			v := string(o.Health.Status)
+
+			if o.Health.Message != "" {
+				v += ": " + o.Health.Message
+			}
+
+			if match(v) {
+				result = append(result, SearchMatch{Field: f, Value: v})
+			}
+
+		case "images":
+			for _, image := range o.Images {
+				if match(image) {
+					result = append(result, SearchMatch{Field: f, Value: image})
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// searchObjects returns the indexed objects of a context, listing them if
+// not cached. Resources the caller cannot list are reported as errors.
+func (s *Server) searchObjects(ctx context.Context, name string, auth *config.AuthInfo, refresh bool) *searchIndexEntry {
+	key := strings.ToLower(name) + "/" + credentialID(auth)
+
+	if e, ok := s.search.get(key); ok && !refresh {
+		return e
+	}
+
+	client, err := s.kubernetesClient(ctx, name, auth)
+
+	if err != nil {
+		return &searchIndexEntry{errors: []string{err.Error()}}
+	}
+
+	entry := &searchIndexEntry{
+		fetched: time.Now(),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, res := range searchResources {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			path := "/apis/" + res.Group + "/" + res.Version + "/" + res.Resource
+
+			if res.Group == "" {
+				path = "/api/" + res.Version + "/" + res.Resource
+			}
+
+			var objects []searchObject
+			var err error
+
+			if res.Full {
+				var list struct {
+					Items []json.RawMessage `json:"items"`
+				}
+
+				err = client.get(ctx, path, nil, &list)
+
+				for _, data := range list.Items {
+					var meta struct {
+						Metadata metav1.ObjectMeta `json:"metadata"`
+					}
+
+					var item map[string]any
+
+					json.Unmarshal(data, &meta)
+					json.Unmarshal(data, &item)
+
+					if item == nil {
+						continue
+					}
+
+					item["apiVersion"] = strings.TrimPrefix(res.Group+"/"+res.Version, "/")
+					item["kind"] = res.Kind
+
+					status := health.Assess(item)
+
+					objects = append(objects, searchObject{
+						Namespace: meta.Metadata.Namespace,
+						Name:      meta.Metadata.Name,
+
+						Labels:      meta.Metadata.Labels,
+						Annotations: meta.Metadata.Annotations,
+
+						Images: objectImages(res.Kind, item),
+						Health: &status,
+					})
+				}
+			} else {
+				var list metav1.PartialObjectMetadataList
+
+				err = client.getMetadata(ctx, path, nil, &list)
+
+				for _, item := range list.Items {
+					objects = append(objects, searchObject{
+						Namespace: item.Namespace,
+						Name:      item.Name,
+
+						Labels:      item.Labels,
+						Annotations: item.Annotations,
+					})
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				entry.errors = append(entry.errors, res.Resource+": "+err.Error())
+				return
+			}
+
+			for _, o := range objects {
+				o.Group = res.Group
+				o.Version = res.Version
+				o.Resource = res.Resource
+				o.Kind = res.Kind
+
+				entry.objects = append(entry.objects, o)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	slices.Sort(entry.errors)
+
+	slices.SortFunc(entry.objects, func(a, b searchObject) int {
+		return strings.Compare(a.Resource+"/"+a.Namespace+"/"+a.Name, b.Resource+"/"+b.Namespace+"/"+b.Name)
+	})
+
+	s.search.put(key, entry)
+
+	return entry
+}
+
+// objectImages returns the container images of a workload object.
+func objectImages(kind string, obj map[string]any) []string {
+	path, ok := podTemplatePaths[kind]
+
+	if !ok {
+		return nil
+	}
+
+	var spec any = obj
+
+	for _, p := range path {
+		m, _ := spec.(map[string]any)
+		spec = m[p]
+	}
+
+	podSpec, _ := spec.(map[string]any)
+
+	var result []string
+
+	for _, key := range []string{"initContainers", "containers", "ephemeralContainers"} {
+		containers, _ := podSpec[key].([]any)
+
+		for _, c := range containers {
+			c, _ := c.(map[string]any)
+
+			if image, ok := c["image"].(string); ok && !slices.Contains(result, image) {
+				result = append(result, image)
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maxTokenTTL bounds tokens minted for testing.
+const maxTokenTTL = 24 * time.Hour
+
+// handleServiceAccount describes a ServiceAccount: its bindings and resolved
+// permissions, the pods running as it and its long-lived token secrets.
+func (s *Server) handleServiceAccount(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	namespace := r.PathValue("namespace")
+	account := r.PathValue("name")
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	var sa corev1.ServiceAccount
+
+	if err := client.get(r.Context(), "/api/v1/namespaces/"+namespace+"/serviceaccounts/"+account, nil, &sa); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := &ServiceAccountInfo{
+		Namespace: namespace,
+		Name:      account,
+
+		Created: sa.CreationTimestamp.Time,
+
+		AutomountToken: sa.AutomountServiceAccountToken == nil || *sa.AutomountServiceAccountToken,
+
+		Pods:   []ServiceAccountPod{},
+		Tokens: []ServiceAccountToken{},
+	}
+
+	permissions := s.subjectPermissions(r.Context(), name, auth, rbacv1.Subject{
+		Kind:      rbacv1.ServiceAccountKind,
+		Namespace: namespace,
+		Name:      account,
+	})
+
+	result.Bindings = permissions.Bindings
+	result.Permissions = permissions.Permissions
+	result.PermissionsError = permissions.Error
+
+	var pods corev1.PodList
+
+	if err := client.get(r.Context(), "/api/v1/namespaces/"+namespace+"/pods", nil, &pods); err == nil {
+		for _, pod := range pods.Items {
+			if serviceAccountName(pod.Spec) != account {
+				continue
+			}
+
+			result.Pods = append(result.Pods, serviceAccountPod(pod, result.AutomountToken))
+		}
+	}
+
+	query := url.Values{
+		"fieldSelector": {"type=" + string(corev1.SecretTypeServiceAccountToken)},
+	}
+
+	// metadata only, the token itself is never read
+	var secrets metav1.PartialObjectMetadataList
+
+	if err := client.getMetadata(r.Context(), "/api/v1/namespaces/"+namespace+"/secrets", query, &secrets); err == nil {
+		for _, secret := range secrets.Items {
+			if secret.Annotations[corev1.ServiceAccountNameKey] != account {
+				continue
+			}
+
+			result.Tokens = append(result.Tokens, ServiceAccountToken{
+				Secret:  secret.Name,
+				Created: secret.CreationTimestamp.Time,
+				Age:     time.Since(secret.CreationTimestamp.Time).Round(time.Second).String(),
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func serviceAccountName(spec corev1.PodSpec) string {
+	if spec.ServiceAccountName != "" {
+		return spec.ServiceAccountName
+	}
+
+	return "default"
+}
+
+func serviceAccountPod(pod corev1.Pod, automount bool) ServiceAccountPod {
+	result := ServiceAccountPod{
+		Name:  pod.Name,
+		Phase: string(pod.Status.Phase),
+
+		Mounted: automount,
+	}
+
+	if pod.Spec.AutomountServiceAccountToken != nil {
+		result.Mounted = *pod.Spec.AutomountServiceAccountToken
+	}
+
+	for _, v := range pod.Spec.Volumes {
+		if v.Projected == nil {
+			continue
+		}
+
+		for _, source := range v.Projected.Sources {
+			if source.ServiceAccountToken == nil {
+				continue
+			}
+
+			result.Mounted = true
+
+			if source.ServiceAccountToken.ExpirationSeconds != nil {
+				result.TokenExpirationSeconds = *source.ServiceAccountToken.ExpirationSeconds
+			}
+		}
+	}
+
+	return result
+}
+
+// handleCreateServiceAccountToken mints a short-lived token via the
+// TokenRequest API, e.g. ?ttl=10m&audience=api.
+func (s *Server) handleCreateServiceAccountToken(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	namespace := r.PathValue("namespace")
+	account := r.PathValue("name")
+
+	ttl := 10 * time.Minute
+
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		d, err := time.ParseDuration(v)
+
+		if err != nil {
+			http.Error(w, "invalid ttl: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ttl = d
+	}
+
+	if ttl < 10*time.Minute || ttl > maxTokenTTL {
+		http.Error(w, "ttl must be between 10m and "+maxTokenTTL.String(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.checkProtection(r, name, namespace); err != nil {
+		writeProtectionError(w, r, err)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	seconds := int64(ttl.Seconds())
+
+	req := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         r.URL.Query()["audience"],
+			ExpirationSeconds: &seconds,
+		},
+	}
+
+	if err := client.create(r.Context(), "/api/v1/namespaces/"+namespace+"/serviceaccounts/"+account+"/token", req, req); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	s.audit.record(&AuditEntry{
+		Context: name,
+		Owner:   ownerID(auth),
+		Action:  "token",
+
+		Resource:  "serviceaccounts",
+		Namespace: namespace,
+		Name:      account,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	json.NewEncoder(w).Encode(&ServiceAccountTokenInfo{
+		Token:   req.Status.Token,
+		Expires: req.Status.ExpirationTimestamp.Time,
+	})
+}
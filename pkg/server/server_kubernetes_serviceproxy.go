@@ -0,0 +1,425 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+// errServiceUnreachable marks responses of the API server proxy that are
+// retried through a port-forward.
+var errServiceUnreachable = errors.New("service not reachable through the API server")
+
+// serviceProxies holds the port-forwards of services the API server could
+// not reach, e.g. with private control planes that have no route to the
+// pod network.
+type serviceProxies struct {
+	mu    sync.Mutex
+	items map[string]*serviceProxy
+}
+
+func (p *serviceProxies) get(key string) (*serviceProxy, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	item, ok := p.items[key]
+	return item, ok
+}
+
+// add registers a proxy unless there is one already, which is returned.
+func (p *serviceProxies) add(key string, item *serviceProxy) (*serviceProxy, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if existing, ok := p.items[key]; ok {
+		return existing, false
+	}
+
+	if p.items == nil {
+		p.items = make(map[string]*serviceProxy)
+	}
+
+	p.items[key] = item
+
+	return item, true
+}
+
+func (p *serviceProxies) remove(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.items, key)
+}
+
+// serviceProxy serves requests of a service through a port-forward to one
+// of its ready pods.
+type serviceProxy struct {
+	proxy     *httputil.ReverseProxy
+	tunnel    *podTunnel
+	transport *http.Transport
+
+	closeOnce sync.Once
+	onClose   func()
+}
+
+func (p *serviceProxy) Close() error {
+	p.closeOnce.Do(func() {
+		if p.onClose != nil {
+			p.onClose()
+		}
+
+		p.transport.CloseIdleConnections()
+		p.tunnel.Close()
+	})
+
+	return nil
+}
+
+// parseServiceRef parses the service of a proxy path like the API server does:
+// [scheme:]name[:port].
+func parseServiceRef(value string) (scheme, name, port string) {
+	parts := strings.Split(value, ":")
+
+	switch len(parts) {
+	case 1:
+		return "", parts[0], ""
+	case 2:
+		return "", parts[0], parts[1]
+	default:
+		return parts[0], parts[1], parts[2]
+	}
+}
+
+// handleServiceProxy reaches an in-cluster service (e.g. the ArgoCD or
+// Grafana UI) through the service proxy of the API server. Services the API
+// server cannot reach are served through a port-forward to a ready pod from
+// then on. Redirects are rewritten to stay below the proxy path.
+func (s *Server) handleServiceProxy(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	c, ok := s.kubernetesContext(r.PathValue("context"))
+
+	if !ok {
+		writeError(w, r, errContextNotFound, http.StatusNotFound)
+		return
+	}
+
+	namespace := r.PathValue("namespace")
+	service := r.PathValue("service")
+
+	scheme, name, _ := parseServiceRef(service)
+
+	if name == "" || (scheme != "" && scheme != "http" && scheme != "https") {
+		http.Error(w, "invalid service, expected [scheme:]name[:port]", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		if err := s.checkProtection(r, c.Name, namespace); err != nil {
+			writeProtectionError(w, r, err)
+			return
+		}
+	}
+
+	// the remaining path is forwarded as escaped by the browser
+	escaped := r.URL.EscapedPath()
+
+	i := strings.Index(escaped, "/services/")
+	j := strings.Index(escaped[i:], "/proxy")
+
+	prefix := escaped[:i+j+len("/proxy")]
+	path := strings.TrimPrefix(escaped, prefix)
+
+	if path == "" {
+		path = "/"
+	}
+
+	stripBridgeCredentials(r.Header)
+
+	s.serveService(w, r, c, auth, namespace, service, prefix, path)
+}
+
+// serveService serves a request of a service at path, escaped, through the
+// service proxy of the API server, or through a port-forward once the API
+// server could not reach it. prefix is the path the service is served at by
+// the bridge.
+func (s *Server) serveService(w http.ResponseWriter, r *http.Request, c config.KubernetesContext, auth *config.AuthInfo, namespace, service, prefix, path string) {
+	scheme, name, port := parseServiceRef(service)
+
+	key := serviceKey(c.Name, auth, namespace, service)
+
+	if p, ok := s.serviceProxies.get(key); ok {
+		r.URL.RawPath = path
+		r.URL.Path, _ = url.PathUnescape(path)
+
+		p.proxy.ServeHTTP(w, r)
+		return
+	}
+
+	tr, target, err := s.kubernetesTransport(r.Context(), c, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	upstream := strings.TrimSuffix(target.Path, "/") + "/api/v1/namespaces/" + namespace + "/services/" + service + "/proxy"
+
+	proxy := &httputil.ReverseProxy{
+		Transport: tr,
+
+		FlushInterval: -1,
+
+		ErrorLog: log.New(log.Writer(), "proxy: ", log.LstdFlags),
+
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+
+			pr.Out.URL.RawPath = upstream + path
+			pr.Out.URL.Path, _ = url.PathUnescape(pr.Out.URL.RawPath)
+
+			pr.Out.Host = target.Host
+			pr.Out.Header.Set("X-Forwarded-Prefix", prefix)
+
+			stripBearerProtocol(pr.Out.Header)
+		},
+
+		ModifyResponse: func(resp *http.Response) error {
+			if serviceUnreachable(resp) && s.serviceFallbackAllowed(r, c.Name, namespace) {
+				return errServiceUnreachable
+			}
+
+			rewriteServiceLocation(resp, prefix, upstream)
+
+			return nil
+		},
+
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			if !errors.Is(err, errServiceUnreachable) {
+				limitErrorHandler(w, req, err)
+				return
+			}
+
+			p := s.serviceForward(c.Name, auth, key, namespace, scheme, name, port, prefix)
+
+			req.URL.RawPath = path
+			req.URL.Path, _ = url.PathUnescape(path)
+
+			p.proxy.ServeHTTP(w, req)
+		},
+	}
+
+	proxy.ServeHTTP(w, r)
+}
+
+// serviceKey identifies the port-forward of a service per context and
+// credentials.
+func serviceKey(context string, auth *config.AuthInfo, namespace, service string) string {
+	return strings.ToLower(context) + "/" + credentialID(auth) + "/" + namespace + "/" + service
+}
+
+// serviceForward returns the port-forward proxy of a service, which stays
+// in place until the context is removed.
+func (s *Server) serviceForward(context string, auth *config.AuthInfo, key, namespace, scheme, name, port, prefix string) *serviceProxy {
+	if p, ok := s.serviceProxies.get(key); ok {
+		return p
+	}
+
+	if scheme == "" {
+		number, _ := strconv.Atoi(port)
+		scheme = portForwardScheme(port, number)
+	}
+
+	tunnel := &podTunnel{
+		server: s,
+		auth:   auth,
+
+		context:   context,
+		namespace: namespace,
+
+		target: s.portForwardTarget(context, auth, &PortForwardRequest{
+			Namespace: namespace,
+			Service:   name,
+			Port:      port,
+		}),
+	}
+
+	transport := &http.Transport{
+		DialContext: tunnel.DialContext,
+
+		// in-cluster certificates are not issued for the forwarded address
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	}
+
+	target := &url.URL{
+		Scheme: scheme,
+		Host:   name + "." + namespace + ".svc",
+	}
+
+	p := &serviceProxy{
+		tunnel:    tunnel,
+		transport: transport,
+
+		proxy: &httputil.ReverseProxy{
+			Transport: transport,
+
+			FlushInterval: -1,
+
+			ErrorLog: log.New(log.Writer(), "proxy: ", log.LstdFlags),
+
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(target)
+				pr.SetXForwarded()
+
+				pr.Out.Header.Set("X-Forwarded-Prefix", prefix)
+
+				stripBearerProtocol(pr.Out.Header)
+			},
+
+			ModifyResponse: func(resp *http.Response) error {
+				rewriteServiceLocation(resp, prefix, "")
+				return nil
+			},
+
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				var e *i18n.Error
+
+				if errors.As(err, &e) {
+					writeError(w, r, e, http.StatusServiceUnavailable)
+					return
+				}
+
+				limitErrorHandler(w, r, err)
+			},
+		},
+	}
+
+	p, added := s.serviceProxies.add(key, p)
+
+	if added {
+		p.onClose = func() {
+			s.serviceProxies.remove(key)
+		}
+
+		s.track(context, p)
+
+		log.Printf("service proxy: %s/%s of context %q is served through a port-forward", namespace, name, context)
+	}
+
+	return p
+}
+
+// serviceFallbackAllowed reports whether a request may be retried through a
+// port-forward. Only requests without body are retried, and port-forwards
+// are subject to the protection of the namespace.
+func (s *Server) serviceFallbackAllowed(r *http.Request, context, namespace string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	return s.checkProtection(r, context, namespace) == nil
+}
+
+// serviceUnreachable detects failures of the API server proxy itself, which
+// answers with a Status object, unlike the errors of the service.
+func serviceUnreachable(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusForbidden, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+	default:
+		return false
+	}
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return false
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	if err != nil {
+		return false
+	}
+
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+
+	var status metav1.Status
+
+	if json.Unmarshal(data, &status) != nil {
+		return false
+	}
+
+	return status.Kind == "Status" && status.APIVersion == "v1"
+}
+
+// rewriteServiceLocation keeps redirects of a service below the proxy path,
+// both absolute paths of the service and paths of the API server proxy.
+func rewriteServiceLocation(resp *http.Response, prefix, upstream string) {
+	location := resp.Header.Get("Location")
+
+	if location == "" {
+		return
+	}
+
+	if upstream != "" {
+		if rest, ok := strings.CutPrefix(location, upstream); ok {
+			resp.Header.Set("Location", prefix+rest)
+			return
+		}
+	}
+
+	if strings.HasPrefix(location, "/") && !strings.HasPrefix(location, "//") {
+		resp.Header.Set("Location", prefix+location)
+	}
+}
+
+// stripBridgeCredentials removes the credentials of the bridge, which must
+// not reach services in the cluster.
+func stripBridgeCredentials(h http.Header) {
+	h.Del("Authorization")
+
+	if len(h.Values("Cookie")) == 0 {
+		return
+	}
+
+	cookies, err := http.ParseCookie(strings.Join(h.Values("Cookie"), "; "))
+
+	if err != nil {
+		return
+	}
+
+	h.Del("Cookie")
+
+	var kept []string
+
+	for _, c := range cookies {
+		if strings.HasPrefix(c.Name, "bridge_") {
+			continue
+		}
+
+		kept = append(kept, c.String())
+	}
+
+	if len(kept) > 0 {
+		h.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sseRetry is the reconnect delay suggested to EventSource clients
+	sseRetry = 3 * time.Second
+
+	// sseHeartbeat keeps idle streams open through proxies
+	sseHeartbeat = 30 * time.Second
+)
+
+// handleWatchEvents streams a kubernetes watch as server-sent events. Every
+// event carries the resourceVersion as its id, so reconnecting EventSource
+// clients resume through Last-Event-ID. Expired resource versions are
+// handled by a "reset" event followed by the current state as ADDED events.
+//
+//	GET /contexts/{context}/watch/{group}/{version}/{resource}?namespace=&labelSelector=&fieldSelector=
+func (s *Server) handleWatchEvents(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	c, ok := s.kubernetesContext(r.PathValue("context"))
+
+	if !ok {
+		writeError(w, r, errContextNotFound, http.StatusNotFound)
+		return
+	}
+
+	target := objectResource(r)
+
+	tr, base, err := s.kubernetesTransport(r.Context(), c, auth)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+
+	session, err := s.sessions.start(s.config.Limits, "watch", c.Name, r.URL.Path, ownerID(auth), cancel)
+
+	if err != nil {
+		writeError(w, r, err, http.StatusTooManyRequests)
+		return
+	}
+
+	defer s.sessions.end(session)
+
+	w = &sessionWriter{
+		ResponseWriter: w,
+		session:        session,
+	}
+
+	u := *base
+	u.Path = strings.TrimSuffix(u.Path, "/") + target.Path()
+
+	query := url.Values{}
+
+	for _, key := range []string{"labelSelector", "fieldSelector"} {
+		if v := r.URL.Query().Get(key); v != "" {
+			query.Set(key, v)
+		}
+	}
+
+	resourceVersion := r.Header.Get("Last-Event-ID")
+
+	if resourceVersion == "" {
+		resourceVersion = r.URL.Query().Get("resourceVersion")
+	}
+
+	stream := &sseStream{
+		w:  w,
+		rc: http.NewResponseController(w),
+	}
+
+	heartbeat, stop := context.WithCancel(ctx)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		stream.heartbeat(heartbeat)
+	}()
+
+	defer func() {
+		stop()
+		wg.Wait()
+	}()
+
+	for {
+		q := query
+
+		if resourceVersion != "" {
+			q = maps.Clone(query)
+			q.Set("resourceVersion", resourceVersion)
+		}
+
+		u.RawQuery = q.Encode()
+
+		expired := false
+
+		watch := &resumableWatch{
+			Transport: tr,
+
+			URL:    &u,
+			Header: http.Header{},
+
+			// bookmarks advance the Last-Event-ID of the client
+			Bookmarks: true,
+
+			OnEvent: func(e watchEvent) error {
+				var obj watchObject
+				json.Unmarshal(e.Object, &obj)
+
+				if e.Type == "ERROR" && obj.Code == http.StatusGone {
+					expired = true
+					return nil
+				}
+
+				return stream.send(obj.Metadata.ResourceVersion, e.Type, e.Object)
+			},
+
+			OnState: func(state watchState) {
+				stream.start()
+
+				if state != watchExpired {
+					data, _ := json.Marshal(map[string]string{"state": string(state)})
+					stream.send("", "state", data)
+				}
+			},
+		}
+
+		err := watch.Run(ctx)
+
+		if expired && ctx.Err() == nil {
+			// the client drops its objects and receives the current state
+			resourceVersion = ""
+
+			if err := stream.send("", "reset", []byte("{}")); err != nil {
+				return
+			}
+
+			continue
+		}
+
+		if err != nil {
+			var upstreamErr *upstreamError
+
+			if errors.As(err, &upstreamErr) && !stream.started {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(upstreamErr.StatusCode)
+				w.Write(upstreamErr.Body)
+				return
+			}
+
+			if !stream.started {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+
+			data, _ := json.Marshal(map[string]string{"message": err.Error()})
+			stream.send("", "error", data)
+		}
+
+		return
+	}
+}
+
+// sseStream writes server-sent events; writes are serialized with the
+// heartbeat.
+type sseStream struct {
+	mu sync.Mutex
+
+	w  http.ResponseWriter
+	rc *http.ResponseController
+
+	started bool
+}
+
+func (s *sseStream) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.startLocked()
+}
+
+func (s *sseStream) startLocked() {
+	if s.started {
+		return
+	}
+
+	s.started = true
+
+	s.w.Header().Set("Content-Type", "text/event-stream")
+	s.w.Header().Set("Cache-Control", "no-cache")
+	s.w.Header().Set("X-Accel-Buffering", "no")
+	s.w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(s.w, "retry: %d\n\n", sseRetry.Milliseconds())
+	s.rc.Flush()
+}
+
+func (s *sseStream) send(id, event string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.startLocked()
+
+	var compact bytes.Buffer
+
+	// event data must not span lines
+	if err := json.Compact(&compact, data); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+
+	if id != "" {
+		b.WriteString("id: " + id + "\n")
+	}
+
+	b.WriteString("event: " + event + "\n")
+	b.WriteString("data: " + compact.String() + "\n\n")
+
+	if _, err := s.w.Write([]byte(b.String())); err != nil {
+		return err
+	}
+
+	return s.rc.Flush()
+}
+
+func (s *sseStream) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(sseHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			s.mu.Lock()
+
+			if s.started {
+				s.w.Write([]byte(": ping\n\n"))
+				s.rc.Flush()
+			}
+
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+const (
+	// talosTimeout bounds talosctl commands, which do not wait for reboots
+	// and upgrades to complete
+	talosTimeout = 2 * time.Minute
+
+	// talosMachineConfig is the path of the machine config on Talos nodes
+	talosMachineConfig = "/system/state/config.yaml"
+)
+
+var errTalosNotInstalled = i18n.NewError("error.talos_not_installed")
+
+// talosSecretKeys are keys of the machine config holding secrets, which
+// are redacted before the config leaves the bridge.
+var talosSecretKeys = []string{"key", "token", "secret", "password"}
+
+// talosNode resolves a node of a context enabled for Talos to the address
+// talosctl connects to. It writes the error and returns false if the node
+// cannot be managed.
+func (s *Server) talosNode(w http.ResponseWriter, r *http.Request, verb string) (*config.TalosCluster, string, bool) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	node := r.PathValue("node")
+
+	cluster, ok := s.config.Talos.Cluster(name)
+
+	if !ok {
+		writeError(w, r, i18n.NewError("error.talos_not_configured", name), http.StatusNotImplemented)
+		return nil, "", false
+	}
+
+	if _, err := exec.LookPath("talosctl"); err != nil {
+		writeError(w, r, errTalosNotInstalled, http.StatusNotImplemented)
+		return nil, "", false
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return nil, "", false
+	}
+
+	// talosctl acts with the credentials of the talosconfig, so callers need
+	// the respective access to the node in Kubernetes
+	allowed, err := accessAllowed(r.Context(), client, authorizationv1.ResourceAttributes{Verb: verb, Resource: "nodes", Name: node})
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return nil, "", false
+	}
+
+	if !allowed {
+		http.Error(w, "access to node "+node+" is forbidden", http.StatusForbidden)
+		return nil, "", false
+	}
+
+	var n corev1.Node
+
+	if err := client.get(r.Context(), "/api/v1/nodes/"+node, nil, &n); err != nil {
+		writeClientError(w, r, err)
+		return nil, "", false
+	}
+
+	if !strings.HasPrefix(n.Status.NodeInfo.OSImage, "Talos") {
+		writeError(w, r, i18n.NewError("error.talos_node_unsupported", node), http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	for _, a := range n.Status.Addresses {
+		if a.Type == corev1.NodeInternalIP {
+			return cluster, a.Address, true
+		}
+	}
+
+	writeError(w, r, i18n.NewError("error.talos_node_unsupported", node), http.StatusBadRequest)
+	return nil, "", false
+}
+
+// runTalos runs talosctl against a node and returns its output. Failures
+// carry the message of talosctl.
+func (s *Server) runTalos(ctx context.Context, cluster *config.TalosCluster, address string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, talosTimeout)
+	defer cancel()
+
+	flags := []string{"--talosconfig", s.config.Talos.Config, "--nodes", address}
+
+	if cluster.TalosContext != "" {
+		flags = append(flags, "--context", cluster.TalosContext)
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "talosctl", append(flags, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, errors.New(message)
+		}
+
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// handleTalosMachineConfig returns the machine config of a Talos node, one
+// entry per document, with secrets redacted.
+func (s *Server) handleTalosMachineConfig(w http.ResponseWriter, r *http.Request) {
+	cluster, address, ok := s.talosNode(w, r, "get")
+
+	if !ok {
+		return
+	}
+
+	data, err := s.runTalos(r.Context(), cluster, address, "read", talosMachineConfig)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	result := &TalosMachineConfig{
+		Node:    r.PathValue("node"),
+		Address: address,
+
+		Documents: []map[string]any{},
+	}
+
+	for _, doc := range splitManifest(string(data)) {
+		if doc.Error != nil {
+			http.Error(w, doc.Error.Error(), http.StatusBadGateway)
+			return
+		}
+
+		redactTalosSecrets(doc.Object)
+
+		result.Documents = append(result.Documents, doc.Object)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func redactTalosSecrets(v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if _, ok := value.(string); ok && talosSecretKey(key) {
+				v[key] = "<redacted>"
+				continue
+			}
+
+			redactTalosSecrets(value)
+		}
+
+	case []any:
+		for _, value := range v {
+			redactTalosSecrets(value)
+		}
+	}
+}
+
+// talosSecretKey matches keys like key, token or secretboxEncryptionSecret.
+func talosSecretKey(key string) bool {
+	key = strings.ToLower(key)
+
+	for _, k := range talosSecretKeys {
+		if key == k || strings.HasSuffix(key, k) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleTalosReboot reboots a Talos node without waiting for it to return.
+func (s *Server) handleTalosReboot(w http.ResponseWriter, r *http.Request) {
+	var req TalosRebootRequest
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	args := []string{"reboot", "--wait=false"}
+
+	switch req.Mode {
+	case "", "default":
+	case "powercycle":
+		args = append(args, "--mode", req.Mode)
+	default:
+		http.Error(w, "invalid mode", http.StatusBadRequest)
+		return
+	}
+
+	s.runTalosAction(w, r, "talos-reboot", args...)
+}
+
+// handleTalosUpgrade upgrades a Talos node to an installer image without
+// waiting for the upgrade to complete.
+func (s *Server) handleTalosUpgrade(w http.ResponseWriter, r *http.Request) {
+	var req TalosUpgradeRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Image == "" {
+		http.Error(w, "image is required", http.StatusBadRequest)
+		return
+	}
+
+	args := []string{"upgrade", "--image", req.Image, "--wait=false"}
+
+	if req.Stage {
+		args = append(args, "--stage")
+	}
+
+	if req.Force {
+		args = append(args, "--force")
+	}
+
+	s.runTalosAction(w, r, "talos-upgrade", args...)
+}
+
+func (s *Server) runTalosAction(w http.ResponseWriter, r *http.Request, action string, args ...string) {
+	auth := AuthInfoFromContext(r.Context())
+
+	cluster, address, ok := s.talosNode(w, r, "patch")
+
+	if !ok {
+		return
+	}
+
+	output, err := s.runTalos(r.Context(), cluster, address, args...)
+
+	entry := &AuditEntry{
+		Context: r.PathValue("context"),
+		Owner:   ownerID(auth),
+		Action:  action,
+
+		Resource: "nodes",
+		Name:     r.PathValue("node"),
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	s.audit.record(entry)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+
+	json.NewEncoder(w).Encode(&TalosAction{
+		Node:    r.PathValue("node"),
+		Address: address,
+		Action:  strings.TrimPrefix(action, "talos-"),
+
+		Output: strings.TrimSpace(string(output)),
+	})
+}
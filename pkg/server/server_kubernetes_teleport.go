@@ -0,0 +1,377 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+const (
+	// teleportStatusTTL is how long the profiles of tsh are reused
+	teleportStatusTTL = 30 * time.Second
+
+	// teleportLoginTimeout bounds logins, which wait for the SSO flow in
+	// the browser
+	teleportLoginTimeout = 5 * time.Minute
+)
+
+var errTeleportLoginUnavailable = i18n.NewError("error.teleport_login_unavailable")
+
+// tshStatus is the output of tsh status --format=json.
+type tshStatus struct {
+	Active   *tshProfile  `json:"active"`
+	Profiles []tshProfile `json:"profiles"`
+}
+
+type tshProfile struct {
+	ProxyURL string `json:"profile_url"`
+	Username string `json:"username"`
+	Cluster  string `json:"cluster"`
+
+	ValidUntil time.Time `json:"valid_until"`
+}
+
+// teleportProfiles caches the login profiles of tsh per installation (the
+// command and environment of the exec plugin).
+type teleportProfiles struct {
+	mu      sync.Mutex
+	entries map[string]*teleportProfilesEntry
+
+	// checked is the start of the last check in the background
+	checked time.Time
+}
+
+type teleportProfilesEntry struct {
+	status  *tshStatus
+	fetched time.Time
+}
+
+func (p *teleportProfiles) get(key string) (*tshStatus, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.entries[key]
+
+	if !ok || time.Since(e.fetched) > teleportStatusTTL {
+		return nil, false
+	}
+
+	return e.status, true
+}
+
+func (p *teleportProfiles) put(key string, status *tshStatus) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.entries == nil {
+		p.entries = make(map[string]*teleportProfilesEntry)
+	}
+
+	p.entries[key] = &teleportProfilesEntry{
+		status:  status,
+		fetched: time.Now(),
+	}
+}
+
+// begin reports whether the profiles are to be checked in the background,
+// which they are not while a check runs or after one recently.
+func (p *teleportProfiles) begin() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Since(p.checked) < teleportStatusTTL {
+		return false
+	}
+
+	p.checked = time.Now()
+
+	return true
+}
+
+func (p *teleportProfiles) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.entries = nil
+}
+
+// runTsh runs tsh with the environment of the exec plugin of a context.
+func runTsh(ctx context.Context, t *config.TeleportContext, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, t.Command, args...)
+	cmd.Env = append(os.Environ(), t.Env...)
+
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, errors.New(message)
+		}
+
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func (s *Server) tshStatus(ctx context.Context, t *config.TeleportContext, refresh bool) (*tshStatus, error) {
+	key := teleportKey(t)
+
+	if status, ok := s.teleport.get(key); ok && !refresh {
+		return status, nil
+	}
+
+	data, err := runTsh(ctx, t, "status", "--format=json")
+
+	status := &tshStatus{}
+
+	if err != nil {
+		// tsh fails without any profile
+		if !strings.Contains(err.Error(), "Not logged in") {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(data, status); err != nil {
+		return nil, err
+	}
+
+	s.teleport.put(key, status)
+
+	return status, nil
+}
+
+// teleportKey identifies the installation of tsh used by a context.
+func teleportKey(t *config.TeleportContext) string {
+	return t.Command + "\x00" + strings.Join(t.Env, "\x00")
+}
+
+// teleportSession returns the login of the Teleport proxy of a context.
+func (s *Server) teleportSession(ctx context.Context, t *config.TeleportContext, refresh bool) *TeleportSession {
+	status, err := s.tshStatus(ctx, t, refresh)
+
+	if err != nil {
+		return &TeleportSession{
+			Proxy:       t.Proxy,
+			Cluster:     t.Cluster,
+			KubeCluster: t.KubeCluster,
+
+			Error: err.Error(),
+		}
+	}
+
+	return newTeleportSession(t, status)
+}
+
+// newTeleportSession returns the login of the Teleport proxy of a context
+// from the profiles of tsh.
+func newTeleportSession(t *config.TeleportContext, status *tshStatus) *TeleportSession {
+	result := &TeleportSession{
+		Proxy:       t.Proxy,
+		Cluster:     t.Cluster,
+		KubeCluster: t.KubeCluster,
+	}
+
+	var profile *tshProfile
+
+	if t.Proxy == "" {
+		// the plugin uses the current profile
+		profile = status.Active
+	} else {
+		for i, p := range status.Profiles {
+			if teleportProxyHost(p.ProxyURL) == teleportProxyHost(t.Proxy) {
+				profile = &status.Profiles[i]
+				break
+			}
+		}
+
+		if profile == nil && status.Active != nil && teleportProxyHost(status.Active.ProxyURL) == teleportProxyHost(t.Proxy) {
+			profile = status.Active
+		}
+	}
+
+	if profile == nil {
+		return result
+	}
+
+	result.User = profile.Username
+
+	if !profile.ValidUntil.IsZero() {
+		result.LoggedIn = time.Now().Before(profile.ValidUntil)
+		result.ValidUntil = &profile.ValidUntil
+	}
+
+	return result
+}
+
+// teleportProxyHost returns the host of a proxy address or profile URL.
+func teleportProxyHost(proxy string) string {
+	if u, err := url.Parse(proxy); err == nil && u.Host != "" {
+		proxy = u.Host
+	}
+
+	if host, _, err := net.SplitHostPort(proxy); err == nil {
+		return host
+	}
+
+	return proxy
+}
+
+// teleportSessions returns the logins of all contexts authenticated by tsh,
+// for the session expiry of the contexts.
+func (s *Server) teleportSessions(ctx context.Context) map[string]*TeleportSession {
+	ctx, cancel := context.WithTimeout(ctx, featuresTimeout)
+	defer cancel()
+
+	var result map[string]*TeleportSession
+
+	for _, name := range s.kubernetesContextNames() {
+		c, ok := s.kubernetesContext(name)
+
+		if !ok || c.Teleport == nil {
+			continue
+		}
+
+		if result == nil {
+			result = map[string]*TeleportSession{}
+		}
+
+		result[c.Name] = s.teleportSession(ctx, c.Teleport, false)
+	}
+
+	return result
+}
+
+// cachedTeleportSessions returns the logins of the contexts authenticated by
+// tsh without waiting for it: contexts whose profiles are not cached are left
+// out, checked in the background and sent as teleport event.
+func (s *Server) cachedTeleportSessions() map[string]*TeleportSession {
+	var result map[string]*TeleportSession
+
+	var missing bool
+
+	for _, name := range s.kubernetesContextNames() {
+		c, ok := s.kubernetesContext(name)
+
+		if !ok || c.Teleport == nil {
+			continue
+		}
+
+		if result == nil {
+			result = map[string]*TeleportSession{}
+		}
+
+		status, ok := s.teleport.get(teleportKey(c.Teleport))
+
+		if !ok {
+			missing = true
+			continue
+		}
+
+		result[c.Name] = newTeleportSession(c.Teleport, status)
+	}
+
+	if missing && s.teleport.begin() {
+		go func() {
+			sessions := s.teleportSessions(context.Background())
+
+			s.configEvents.publish(configEvent{
+				name: "teleport",
+
+				data: &TeleportEvent{
+					Sessions: sessions,
+
+					Time: time.Now().UTC(),
+				},
+			})
+		}()
+	}
+
+	return result
+}
+
+func (s *Server) teleportContext(w http.ResponseWriter, r *http.Request) (config.KubernetesContext, bool) {
+	c, ok := s.kubernetesContext(r.PathValue("context"))
+
+	if !ok {
+		writeError(w, r, errContextNotFound, http.StatusNotFound)
+		return c, false
+	}
+
+	if c.Teleport == nil {
+		writeError(w, r, i18n.NewError("error.teleport_not_used", c.Name), http.StatusNotFound)
+		return c, false
+	}
+
+	return c, true
+}
+
+// handleTeleportSession returns the Teleport login of a context, checked
+// again with ?refresh=true.
+func (s *Server) handleTeleportSession(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.teleportContext(w, r)
+
+	if !ok {
+		return
+	}
+
+	result := s.teleportSession(r.Context(), c.Teleport, r.URL.Query().Get("refresh") == "true")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleTeleportLogin logs in to the Teleport proxy of a context with tsh,
+// which opens the SSO flow in the browser of the bridge host. Logins are not
+// available in server mode, as the host is shared.
+func (s *Server) handleTeleportLogin(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.teleportContext(w, r)
+
+	if !ok {
+		return
+	}
+
+	if s.config.Auth != nil {
+		writeError(w, r, errTeleportLoginUnavailable, http.StatusForbidden)
+		return
+	}
+
+	args := []string{"login"}
+
+	if c.Teleport.Proxy != "" {
+		args = append(args, "--proxy="+c.Teleport.Proxy)
+	}
+
+	if c.Teleport.Cluster != "" {
+		args = append(args, c.Teleport.Cluster)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), teleportLoginTimeout)
+	defer cancel()
+
+	if _, err := runTsh(ctx, c.Teleport, args...); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	// credentials of the exec plugin are issued again for new connections
+	s.teleport.clear()
+	s.transports.evictContext(c.Name)
+
+	result := s.teleportSession(r.Context(), c.Teleport, true)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// probeEventReasons are the reasons of the events the kubelet records for
+// failed probes.
+var probeEventReasons = []string{"Unhealthy", "ProbeWarning"}
+
+// handlePodTimeline merges the lifecycle of a pod into one chronological
+// timeline: its creation, conditions, the starts and terminations of its
+// containers (including previous instances), its events and failed probes.
+func (s *Server) handlePodTimeline(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	namespace := r.PathValue("namespace")
+	name := r.PathValue("name")
+
+	client, err := s.kubernetesClient(r.Context(), r.PathValue("context"), auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	var pod corev1.Pod
+
+	if err := client.get(r.Context(), "/api/v1/namespaces/"+namespace+"/pods/"+name, nil, &pod); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := podTimeline(&pod)
+
+	query := url.Values{
+		"fieldSelector": {"involvedObject.uid=" + string(pod.UID)},
+	}
+
+	var events corev1.EventList
+
+	if err := client.get(r.Context(), "/api/v1/namespaces/"+namespace+"/events", query, &events); err == nil {
+		for _, e := range events.Items {
+			result.Timeline = append(result.Timeline, eventTimelineEntry(e))
+		}
+	}
+
+	slices.SortStableFunc(result.Timeline, func(a, b TimelineEntry) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// podTimeline returns the timeline of a pod from its status.
+func podTimeline(pod *corev1.Pod) *PodTimeline {
+	result := &PodTimeline{
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		Phase:     string(pod.Status.Phase),
+
+		Timeline: []TimelineEntry{},
+	}
+
+	add := func(e TimelineEntry) {
+		if !e.Time.IsZero() {
+			result.Timeline = append(result.Timeline, e)
+		}
+	}
+
+	add(TimelineEntry{
+		Time:   pod.CreationTimestamp.Time,
+		Source: "pod",
+		Reason: "Created",
+	})
+
+	if pod.Status.StartTime != nil {
+		add(TimelineEntry{
+			Time:    pod.Status.StartTime.Time,
+			Source:  "pod",
+			Reason:  "Started",
+			Message: "accepted by the kubelet on " + pod.Spec.NodeName,
+		})
+	}
+
+	if pod.DeletionTimestamp != nil {
+		add(TimelineEntry{
+			Time:   pod.DeletionTimestamp.Time,
+			Source: "pod",
+			Reason: "Deleting",
+		})
+	}
+
+	for _, c := range pod.Status.Conditions {
+		add(TimelineEntry{
+			Time:   c.LastTransitionTime.Time,
+			Source: "condition",
+
+			Type:    string(c.Type),
+			Status:  string(c.Status),
+			Reason:  c.Reason,
+			Message: c.Message,
+		})
+
+		// conditions keep their last transition only, so pods that became
+		// unready since report a longer startup
+		if c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue && !pod.CreationTimestamp.IsZero() {
+			result.Startup = c.LastTransitionTime.Sub(pod.CreationTimestamp.Time).Round(time.Second).String()
+		}
+	}
+
+	for _, list := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses, pod.Status.EphemeralContainerStatuses} {
+		for _, status := range list {
+			for _, e := range containerTimeline(status) {
+				add(e)
+			}
+		}
+	}
+
+	return result
+}
+
+// containerTimeline returns the starts and terminations of the current and
+// the previous instance of a container. Older instances are only recorded
+// by events.
+func containerTimeline(status corev1.ContainerStatus) []TimelineEntry {
+	var result []TimelineEntry
+
+	entries := func(state corev1.ContainerState, restarts int32) {
+		started := TimelineEntry{
+			Source:    "container",
+			Container: status.Name,
+			Reason:    "Started",
+		}
+
+		if restarts > 0 {
+			started.Message = fmt.Sprintf("restart %d", restarts)
+		}
+
+		if s := state.Running; s != nil {
+			started.Time = s.StartedAt.Time
+			result = append(result, started)
+		}
+
+		if t := state.Terminated; t != nil {
+			started.Time = t.StartedAt.Time
+			result = append(result, started)
+
+			message := fmt.Sprintf("exit code %d", t.ExitCode)
+
+			if t.Signal != 0 {
+				message += fmt.Sprintf(", signal %d", t.Signal)
+			}
+
+			if m := strings.TrimSpace(t.Message); m != "" {
+				message += ": " + m
+			}
+
+			result = append(result, TimelineEntry{
+				Time:      t.FinishedAt.Time,
+				Source:    "container",
+				Container: status.Name,
+				Reason:    "Terminated",
+				Status:    t.Reason,
+				Message:   message,
+			})
+		}
+	}
+
+	if status.RestartCount > 0 {
+		entries(status.LastTerminationState, status.RestartCount-1)
+	}
+
+	entries(status.State, status.RestartCount)
+
+	return result
+}
+
+// eventTimelineEntry returns the timeline entry of an event of a pod. Failed
+// probes are entries of their own, with the probe as type.
+func eventTimelineEntry(e corev1.Event) TimelineEntry {
+	t := e.LastTimestamp.Time
+
+	if t.IsZero() {
+		t = e.EventTime.Time
+	}
+
+	if t.IsZero() && e.Series != nil {
+		t = e.Series.LastObservedTime.Time
+	}
+
+	entry := TimelineEntry{
+		Time:   t,
+		Source: "event",
+
+		Type:    e.Type,
+		Reason:  e.Reason,
+		Message: e.Message,
+
+		Container: fieldPathContainer(e.InvolvedObject.FieldPath),
+	}
+
+	if e.Count > 1 {
+		entry.Count = e.Count
+	}
+
+	if e.Series != nil && e.Series.Count > 1 {
+		entry.Count = e.Series.Count
+	}
+
+	if slices.Contains(probeEventReasons, e.Reason) {
+		entry.Source = "probe"
+
+		// e.g. Readiness probe failed: HTTP probe failed with statuscode: 503
+		if probe, _, ok := strings.Cut(e.Message, " probe "); ok && !strings.Contains(probe, " ") {
+			entry.Type = strings.ToLower(probe)
+		}
+	}
+
+	return entry
+}
+
+// fieldPathContainer returns the container of a field path of an event,
+// e.g. spec.containers{app}.
+func fieldPathContainer(path string) string {
+	_, name, ok := strings.Cut(path, "{")
+
+	if !ok {
+		return ""
+	}
+
+	name, _, _ = strings.Cut(name, "}")
+
+	return name
+}
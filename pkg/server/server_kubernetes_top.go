@@ -0,0 +1,338 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+// podMetrics and nodeMetrics are the objects of metrics.k8s.io/v1beta1.
+type podMetrics struct {
+	Metadata metav1.ObjectMeta `json:"metadata"`
+
+	Timestamp metav1.Time `json:"timestamp"`
+	Window    string      `json:"window"`
+
+	Containers []struct {
+		Name  string              `json:"name"`
+		Usage corev1.ResourceList `json:"usage"`
+	} `json:"containers"`
+}
+
+type nodeMetrics struct {
+	Metadata metav1.ObjectMeta `json:"metadata"`
+
+	Timestamp metav1.Time `json:"timestamp"`
+	Window    string      `json:"window"`
+
+	Usage corev1.ResourceList `json:"usage"`
+}
+
+func topResources(list corev1.ResourceList) TopResources {
+	return TopResources{
+		CPU:    list.Cpu().MilliValue(),
+		Memory: list.Memory().Value(),
+	}
+}
+
+// getMetrics reads a list of the metrics API, which is only available if
+// metrics-server (or an adapter) is installed.
+func getMetrics(ctx context.Context, client *kubernetesClient, name, path string, query url.Values, out any) error {
+	err := client.get(ctx, "/apis/metrics.k8s.io/v1beta1"+path, query, out)
+
+	if code := statusCode(err); code == http.StatusNotFound || code == http.StatusServiceUnavailable {
+		return i18n.NewError("error.metrics_unavailable", name)
+	}
+
+	return err
+}
+
+func writeMetricsError(w http.ResponseWriter, r *http.Request, err error) {
+	if _, ok := err.(*i18n.Error); ok {
+		writeError(w, r, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	writeClientError(w, r, err)
+}
+
+// sortTop orders by descending usage of ?sort=cpu|memory, or by name.
+func sortTop[T any](items []T, sort string, usage func(T) TopResources, name func(T) string) {
+	slices.SortFunc(items, func(a, b T) int {
+		switch sort {
+		case "cpu":
+			if c := cmp.Compare(usage(b).CPU, usage(a).CPU); c != 0 {
+				return c
+			}
+
+		case "memory":
+			if c := cmp.Compare(usage(b).Memory, usage(a).Memory); c != 0 {
+				return c
+			}
+		}
+
+		return strings.Compare(name(a), name(b))
+	})
+}
+
+// handleTopPods joins the usage of the metrics API with the requests and
+// limits of the pod specs, per pod and container. Pods without metrics yet
+// (e.g. just started) are omitted like in kubectl top.
+func (s *Server) handleTopPods(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	path := "/pods"
+
+	if namespace := r.URL.Query().Get("namespace"); namespace != "" {
+		path = "/namespaces/" + namespace + "/pods"
+	}
+
+	query := url.Values{}
+
+	if selector := r.URL.Query().Get("labelSelector"); selector != "" {
+		query.Set("labelSelector", selector)
+	}
+
+	var metrics struct {
+		Items []podMetrics `json:"items"`
+	}
+
+	if err := getMetrics(r.Context(), client, name, path, query, &metrics); err != nil {
+		writeMetricsError(w, r, err)
+		return
+	}
+
+	var pods corev1.PodList
+
+	if err := client.get(r.Context(), "/api/v1"+path, query, &pods); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	specs := map[string]*corev1.Pod{}
+
+	for i := range pods.Items {
+		p := &pods.Items[i]
+		specs[p.Namespace+"/"+p.Name] = p
+	}
+
+	result := &TopPodList{
+		Items: []TopPod{},
+	}
+
+	for _, m := range metrics.Items {
+		pod, ok := specs[m.Metadata.Namespace+"/"+m.Metadata.Name]
+
+		if !ok {
+			continue
+		}
+
+		item := TopPod{
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			Node:      pod.Spec.NodeName,
+
+			Timestamp: m.Timestamp.Time,
+			Window:    m.Window,
+
+			Containers: []TopContainer{},
+		}
+
+		usage := corev1.ResourceList{}
+
+		for _, c := range m.Containers {
+			container := TopContainer{
+				Name:  c.Name,
+				Usage: topResources(c.Usage),
+			}
+
+			for _, spec := range pod.Spec.Containers {
+				if spec.Name != c.Name {
+					continue
+				}
+
+				requests := spec.Resources.Requests.DeepCopy()
+
+				if requests == nil {
+					requests = corev1.ResourceList{}
+				}
+
+				// requests default to the limits
+				for resource, q := range spec.Resources.Limits {
+					if _, ok := requests[resource]; !ok {
+						requests[resource] = q
+					}
+				}
+
+				container.Requests = topResources(requests)
+				container.Limits = topResources(spec.Resources.Limits)
+			}
+
+			for resource, q := range c.Usage {
+				addResource(usage, resource, q, false)
+			}
+
+			item.Containers = append(item.Containers, container)
+		}
+
+		// pod resources include init containers and defaulted requests
+		workload := &fitWorkload{manifestWorkload: manifestWorkload{Spec: pod.Spec}}
+		applyLimitRanges(workload, nil)
+
+		item.Usage = topResources(usage)
+		item.Requests = topResources(workload.requests)
+		item.Limits = topResources(workload.limits)
+
+		result.Items = append(result.Items, item)
+	}
+
+	sortTop(result.Items, r.URL.Query().Get("sort"), func(p TopPod) TopResources { return p.Usage }, func(p TopPod) string {
+		return p.Namespace + "/" + p.Name
+	})
+
+	writeList(w, r, "top-pods", result, result.Items)
+}
+
+// handleTopNodes joins the usage of the metrics API with the capacity of the
+// nodes and the summed requests and limits of the pods scheduled on them.
+func (s *Server) handleTopNodes(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	var metrics struct {
+		Items []nodeMetrics `json:"items"`
+	}
+
+	if err := getMetrics(r.Context(), client, name, "/nodes", nil, &metrics); err != nil {
+		writeMetricsError(w, r, err)
+		return
+	}
+
+	var nodes corev1.NodeList
+
+	if err := client.get(r.Context(), "/api/v1/nodes", nil, &nodes); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	var pods corev1.PodList
+
+	query := url.Values{
+		"fieldSelector": {"status.phase!=Succeeded,status.phase!=Failed"},
+	}
+
+	if err := client.get(r.Context(), "/api/v1/pods", query, &pods); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	type allocation struct {
+		requests corev1.ResourceList
+		limits   corev1.ResourceList
+		pods     int
+	}
+
+	allocations := map[string]*allocation{}
+
+	for _, p := range pods.Items {
+		if p.Spec.NodeName == "" {
+			continue
+		}
+
+		a, ok := allocations[p.Spec.NodeName]
+
+		if !ok {
+			a = &allocation{
+				requests: corev1.ResourceList{},
+				limits:   corev1.ResourceList{},
+			}
+
+			allocations[p.Spec.NodeName] = a
+		}
+
+		workload := &fitWorkload{manifestWorkload: manifestWorkload{Spec: p.Spec}}
+		applyLimitRanges(workload, nil)
+
+		for resource, q := range workload.requests {
+			addResource(a.requests, resource, q, false)
+		}
+
+		for resource, q := range workload.limits {
+			addResource(a.limits, resource, q, false)
+		}
+
+		a.pods++
+	}
+
+	usage := map[string]nodeMetrics{}
+
+	for _, m := range metrics.Items {
+		usage[m.Metadata.Name] = m
+	}
+
+	result := &TopNodeList{
+		Items: []TopNode{},
+	}
+
+	for _, n := range nodes.Items {
+		m, ok := usage[n.Name]
+
+		if !ok {
+			// not ready or not yet scraped
+			continue
+		}
+
+		item := TopNode{
+			Name: n.Name,
+
+			Timestamp: m.Timestamp.Time,
+			Window:    m.Window,
+
+			Usage: topResources(m.Usage),
+
+			Allocatable: topResources(n.Status.Allocatable),
+			Capacity:    topResources(n.Status.Capacity),
+
+			PodCapacity: n.Status.Allocatable.Pods().Value(),
+		}
+
+		if a, ok := allocations[n.Name]; ok {
+			item.Requests = topResources(a.requests)
+			item.Limits = topResources(a.limits)
+			item.Pods = a.pods
+		}
+
+		result.Items = append(result.Items, item)
+	}
+
+	sortTop(result.Items, r.URL.Query().Get("sort"), func(n TopNode) TopResources { return n.Usage }, func(n TopNode) string {
+		return n.Name
+	})
+
+	writeList(w, r, "top-nodes", result, result.Items)
+}
@@ -0,0 +1,376 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	trafficTestImage   = "curlimages/curl:8.10.1"
+	trafficTestTimeout = 60 * time.Second
+)
+
+// handleTrafficTest sends a test request to an Ingress, Route or Gateway
+// host from the bridge machine and from a debug pod inside the cluster and
+// reports discrepancies in DNS, TLS and routing between both.
+func (s *Server) handleTrafficTest(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+
+	var req TrafficTestRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	target, err := url.Parse(req.URL)
+
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		http.Error(w, "url must be an absolute http(s) url", http.StatusBadRequest)
+		return
+	}
+
+	if req.Method == "" {
+		req.Method = http.MethodGet
+	}
+
+	if req.Namespace == "" {
+		req.Namespace = "default"
+	}
+
+	if req.Image == "" {
+		req.Image = trafficTestImage
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), trafficTestTimeout)
+	defer cancel()
+
+	result := &TrafficTestResult{
+		Local: probeLocal(ctx, req),
+	}
+
+	if !req.SkipCluster {
+		if err := s.checkProtection(r, name, req.Namespace); err != nil {
+			writeProtectionError(w, r, err)
+			return
+		}
+
+		client, err := s.kubernetesClient(ctx, name, auth)
+
+		if err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+
+		result.Cluster = probeCluster(ctx, client, req)
+		result.Discrepancies = compareProbes(result.Local, result.Cluster)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func probeLocal(ctx context.Context, req TrafficTestRequest) *TrafficProbe {
+	probe := &TrafficProbe{}
+
+	target, _ := url.Parse(req.URL)
+
+	if addrs, err := net.DefaultResolver.LookupHost(ctx, target.Hostname()); err == nil {
+		probe.Addresses = addrs
+	} else {
+		probe.Error = "dns: " + err.Error()
+		return probe
+	}
+
+	send := func(insecure bool) (*http.Response, error) {
+		r, err := http.NewRequestWithContext(ctx, req.Method, req.URL, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range req.Headers {
+			r.Header.Set(k, v)
+		}
+
+		if host := req.Headers["Host"]; host != "" {
+			r.Host = host
+		}
+
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				probe.RemoteAddr = info.Conn.RemoteAddr().String()
+			},
+		}
+
+		r = r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+
+		client := &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+			},
+
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+
+		return client.Do(r)
+	}
+
+	start := time.Now()
+
+	resp, err := send(false)
+
+	var verifyErr *tls.CertificateVerificationError
+
+	if errors.As(err, &verifyErr) {
+		// report the certificate problem, but still test the routing
+		probe.TLS = &TrafficTLS{Error: verifyErr.Error()}
+
+		start = time.Now()
+		resp, err = send(true)
+	}
+
+	probe.Duration = time.Since(start).Round(time.Millisecond).String()
+
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1024*1024))
+
+	probe.Status = resp.StatusCode
+	probe.Location = resp.Header.Get("Location")
+
+	if resp.TLS != nil {
+		if probe.TLS == nil {
+			probe.TLS = &TrafficTLS{Verified: true}
+		}
+
+		probe.TLS.Version = tls.VersionName(resp.TLS.Version)
+
+		if certs := resp.TLS.PeerCertificates; len(certs) > 0 {
+			probe.TLS.Subject = certs[0].Subject.String()
+			probe.TLS.Issuer = certs[0].Issuer.String()
+			probe.TLS.DNSNames = certs[0].DNSNames
+			probe.TLS.NotAfter = &certs[0].NotAfter
+		}
+	}
+
+	return probe
+}
+
+// curlOutput is the subset of curl's --write-out %{json} used by the test.
+type curlOutput struct {
+	HTTPCode      int     `json:"http_code"`
+	RemoteIP      string  `json:"remote_ip"`
+	RemotePort    int     `json:"remote_port"`
+	TimeTotal     float64 `json:"time_total"`
+	RedirectURL   string  `json:"redirect_url"`
+	SSLVerify     int     `json:"ssl_verify_result"`
+	ErrorMessage  string  `json:"errormsg"`
+	ExitCode      int     `json:"exitcode"`
+	Scheme        string  `json:"scheme"`
+	HTTPVersion   string  `json:"http_version"`
+	NumConnects   int     `json:"num_connects"`
+	ContentLength float64 `json:"size_download"`
+}
+
+// probeCluster runs curl in a short-lived pod and collects its results.
+func probeCluster(ctx context.Context, client *kubernetesClient, req TrafficTestRequest) *TrafficProbe {
+	probe := &TrafficProbe{}
+
+	id := make([]byte, 4)
+	rand.Read(id)
+
+	name := "bridge-traffic-" + hex.EncodeToString(id)
+
+	args := []string{"-sS", "-k", "-o", "/dev/null", "--max-time", "20", "-X", req.Method, "-w", "%{json}"}
+
+	for k, v := range req.Headers {
+		args = append(args, "-H", k+": "+v)
+	}
+
+	args = append(args, req.URL)
+
+	nonRoot := true
+	noEscalation := false
+
+	// numeric, so the kubelet can verify runAsNonRoot for named image users
+	user := int64(65532)
+
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Pod",
+		},
+
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: req.Namespace,
+
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "bridge",
+			},
+		},
+
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+
+			AutomountServiceAccountToken: &noEscalation,
+
+			Containers: []corev1.Container{
+				{
+					Name:  "curl",
+					Image: req.Image,
+
+					Command: []string{"curl"},
+					Args:    args,
+
+					SecurityContext: &corev1.SecurityContext{
+						RunAsNonRoot:             &nonRoot,
+						RunAsUser:                &user,
+						AllowPrivilegeEscalation: &noEscalation,
+					},
+				},
+			},
+		},
+	}
+
+	path := "/api/v1/namespaces/" + req.Namespace + "/pods"
+
+	if err := client.create(ctx, path, pod, nil); err != nil {
+		probe.Error = "create pod: " + err.Error()
+		return probe
+	}
+
+	defer func() {
+		// clean up even if the request was cancelled
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+
+		client.delete(ctx, path+"/"+name, nil)
+	}()
+
+	for {
+		var current corev1.Pod
+
+		if err := client.get(ctx, path+"/"+name, nil, &current); err != nil {
+			probe.Error = "get pod: " + err.Error()
+			return probe
+		}
+
+		if current.Status.Phase == corev1.PodSucceeded || current.Status.Phase == corev1.PodFailed {
+			break
+		}
+
+		for _, s := range current.Status.ContainerStatuses {
+			if w := s.State.Waiting; w != nil && (w.Reason == "ErrImagePull" || w.Reason == "ImagePullBackOff") {
+				probe.Error = "pull image " + req.Image + ": " + w.Message
+				return probe
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			probe.Error = "pod did not complete in time"
+			return probe
+
+		case <-time.After(time.Second):
+		}
+	}
+
+	var logs []byte
+
+	if err := client.get(ctx, path+"/"+name+"/log", nil, &logs); err != nil {
+		probe.Error = "get logs: " + err.Error()
+		return probe
+	}
+
+	// curl writes errors before the json output
+	start := strings.LastIndex(string(logs), "{\"")
+
+	var out curlOutput
+
+	if start < 0 || json.Unmarshal(logs[start:], &out) != nil {
+		probe.Error = strings.TrimSpace(string(logs))
+		return probe
+	}
+
+	probe.Status = out.HTTPCode
+	probe.Location = out.RedirectURL
+	probe.Duration = (time.Duration(out.TimeTotal * float64(time.Second))).Round(time.Millisecond).String()
+
+	if out.RemoteIP != "" {
+		probe.Addresses = []string{out.RemoteIP}
+		probe.RemoteAddr = net.JoinHostPort(out.RemoteIP, fmt.Sprint(out.RemotePort))
+	}
+
+	if out.Scheme == "HTTPS" || out.Scheme == "https" {
+		probe.TLS = &TrafficTLS{
+			Verified: out.SSLVerify == 0,
+		}
+
+		if out.SSLVerify != 0 {
+			probe.TLS.Error = fmt.Sprintf("certificate verification failed (code %d)", out.SSLVerify)
+		}
+	}
+
+	if out.ExitCode != 0 {
+		probe.Error = out.ErrorMessage
+	}
+
+	return probe
+}
+
+func compareProbes(local, cluster *TrafficProbe) []string {
+	result := []string{}
+
+	if local == nil || cluster == nil {
+		return result
+	}
+
+	if (local.Error == "") != (cluster.Error == "") {
+		result = append(result, fmt.Sprintf("request failed on one side only: local %q, cluster %q", local.Error, cluster.Error))
+	}
+
+	if local.Status != cluster.Status {
+		result = append(result, fmt.Sprintf("status differs: local %d, cluster %d", local.Status, cluster.Status))
+	}
+
+	if local.Location != cluster.Location {
+		result = append(result, fmt.Sprintf("redirect differs: local %q, cluster %q", local.Location, cluster.Location))
+	}
+
+	if len(cluster.Addresses) > 0 && len(local.Addresses) > 0 && !slices.Contains(local.Addresses, cluster.Addresses[0]) {
+		result = append(result, fmt.Sprintf("dns differs: local %s, cluster %s", strings.Join(local.Addresses, ","), cluster.Addresses[0]))
+	}
+
+	if local.TLS != nil && cluster.TLS != nil && local.TLS.Verified != cluster.TLS.Verified {
+		result = append(result, fmt.Sprintf("tls verification differs: local %t, cluster %t", local.TLS.Verified, cluster.TLS.Verified))
+	}
+
+	return result
+}
@@ -0,0 +1,379 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/store"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// trashableResources are the resources whose final manifest is captured
+// before deletion (secrets are left out on purpose).
+var trashableResources = map[string][]string{
+	"":      {"services", "configmaps", "serviceaccounts", "persistentvolumeclaims"},
+	"apps":  {"deployments", "statefulsets", "daemonsets", "replicasets"},
+	"batch": {"jobs", "cronjobs"},
+
+	"autoscaling":       {"horizontalpodautoscalers"},
+	"networking.k8s.io": {"ingresses", "networkpolicies"},
+	"policy":            {"poddisruptionbudgets"},
+}
+
+type trashKey struct{}
+
+type trashSnapshot struct {
+	context string
+	owner   string
+
+	request *kubernetesRequest
+
+	object *unstructured.Unstructured
+}
+
+// trash keeps the final manifests of deleted objects in the local store for
+// the snapshot retention.
+type trash struct {
+	retention time.Duration
+}
+
+func (t *trash) put(e *TrashEntry) error {
+	db, err := dataStore()
+
+	if err != nil {
+		return err
+	}
+
+	t.purge(db)
+
+	return db.Put(trashBucket, e.ID, e)
+}
+
+func (t *trash) get(id string) (*TrashEntry, error) {
+	db, err := dataStore()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var e TrashEntry
+
+	if err := db.Get(trashBucket, id, &e); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, os.ErrNotExist
+		}
+
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+func (t *trash) delete(id string) error {
+	db, err := dataStore()
+
+	if err != nil {
+		return err
+	}
+
+	return db.Delete(trashBucket, id)
+}
+
+func (t *trash) list() ([]TrashEntry, error) {
+	db, err := dataStore()
+
+	if err != nil {
+		return nil, err
+	}
+
+	t.purge(db)
+
+	result := []TrashEntry{}
+
+	err = db.Each(trashBucket, func(_ string, decode func(v any) error) error {
+		var e TrashEntry
+
+		if err := decode(&e); err != nil {
+			return nil
+		}
+
+		result = append(result, e)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(result, func(a, b TrashEntry) int {
+		return b.Deleted.Compare(a.Deleted)
+	})
+
+	return result, nil
+}
+
+// purge removes entries older than the retention.
+func (t *trash) purge(db *store.Store) {
+	if t.retention <= 0 {
+		return
+	}
+
+	db.DeleteFunc(trashBucket, func(_ string, decode func(v any) error) bool {
+		var e struct {
+			Deleted time.Time `json:"deleted"`
+		}
+
+		if err := decode(&e); err != nil {
+			return false
+		}
+
+		return time.Since(e.Deleted) > t.retention
+	})
+}
+
+func isTrashable(r *http.Request, req *kubernetesRequest) bool {
+	if r.Method != http.MethodDelete || req.Name == "" || req.Subresource != "" {
+		return false
+	}
+
+	if r.URL.Query().Get("dryRun") != "" {
+		return false
+	}
+
+	return slices.Contains(trashableResources[req.Group], req.Resource)
+}
+
+// snapshotForTrash captures the manifest of an object about to be deleted
+// and stores it in the request context; the proxy moves it to the trash
+// once the deletion succeeded.
+func (s *Server) snapshotForTrash(r *http.Request, c config.KubernetesContext, auth *config.AuthInfo) *http.Request {
+	req, ok := parseKubernetesPath(r.URL.Path)
+
+	if !ok || !isTrashable(r, req) {
+		return r
+	}
+
+	client, err := s.kubernetesClient(r.Context(), c.Name, auth)
+
+	if err != nil {
+		return r
+	}
+
+	obj := &unstructured.Unstructured{}
+
+	if err := client.get(r.Context(), req.Path(), nil, obj); err != nil {
+		return r
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), trashKey{}, &trashSnapshot{
+		context: c.Name,
+		owner:   ownerID(auth),
+
+		request: req,
+
+		object: obj,
+	}))
+}
+
+// trashResponse moves the snapshot of a successfully deleted object to the trash.
+func (s *Server) trashResponse(resp *http.Response) {
+	snapshot, ok := resp.Request.Context().Value(trashKey{}).(*trashSnapshot)
+
+	if !ok || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	obj := snapshot.object
+
+	entry := &TrashEntry{
+		ID: hex.EncodeToString(id),
+
+		Context: snapshot.context,
+		Owner:   snapshot.owner,
+
+		Group:    snapshot.request.Group,
+		Version:  snapshot.request.Version,
+		Resource: snapshot.request.Resource,
+
+		Kind:      obj.GetKind(),
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+
+		Deleted: time.Now(),
+
+		Object: obj.Object,
+	}
+
+	if err := s.trash.put(entry); err != nil {
+		log.Printf("failed to move %s %s to trash: %v", obj.GetKind(), obj.GetName(), err)
+	}
+}
+
+func (s *Server) handleListTrash(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.trash.list()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	owner := ownerID(AuthInfoFromContext(r.Context()))
+	context := r.URL.Query().Get("context")
+
+	result := []TrashEntry{}
+
+	for _, e := range entries {
+		if e.Owner != owner {
+			continue
+		}
+
+		if context != "" && !strings.EqualFold(e.Context, context) {
+			continue
+		}
+
+		// the listing only carries the metadata
+		e.Object = nil
+
+		result = append(result, e)
+	}
+
+	writeList(w, r, "trash", result, result)
+}
+
+func (s *Server) trashEntry(w http.ResponseWriter, r *http.Request) (*TrashEntry, bool) {
+	e, err := s.trash.get(r.PathValue("id"))
+
+	if err != nil {
+		http.Error(w, "trash entry not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	if e.Owner != ownerID(AuthInfoFromContext(r.Context())) {
+		http.Error(w, "trash entry not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return e, true
+}
+
+func (s *Server) handleGetTrash(w http.ResponseWriter, r *http.Request) {
+	e, ok := s.trashEntry(w, r)
+
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e)
+}
+
+func (s *Server) handleDeleteTrash(w http.ResponseWriter, r *http.Request) {
+	e, ok := s.trashEntry(w, r)
+
+	if !ok {
+		return
+	}
+
+	if err := s.trash.delete(e.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRestoreTrash re-creates a deleted object from its final manifest.
+func (s *Server) handleRestoreTrash(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	e, ok := s.trashEntry(w, r)
+
+	if !ok {
+		return
+	}
+
+	if err := s.checkProtection(r, e.Context, e.Namespace); err != nil {
+		writeProtectionError(w, r, err)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), e.Context, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	obj := &unstructured.Unstructured{Object: e.Object}
+
+	target := &kubernetesRequest{
+		Group:     e.Group,
+		Version:   e.Version,
+		Namespace: e.Namespace,
+		Resource:  e.Resource,
+	}
+
+	cleanManifest(obj)
+
+	var created unstructured.Unstructured
+
+	if err := client.create(r.Context(), target.Path(), obj.Object, &created); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	s.audit.record(&AuditEntry{
+		Context: e.Context,
+		Owner:   ownerID(auth),
+		Action:  "restore",
+
+		Resource:  target.Resource,
+		Namespace: e.Namespace,
+		Name:      e.Name,
+	})
+
+	if err := s.trash.delete(e.ID); err != nil {
+		log.Printf("failed to remove restored trash entry %s: %v", e.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	json.NewEncoder(w).Encode(created.Object)
+}
+
+// cleanManifest removes server populated fields, so the object can be created again.
+func cleanManifest(obj *unstructured.Unstructured) {
+	obj.SetResourceVersion("")
+	obj.SetUID("")
+	obj.SetGeneration(0)
+	obj.SetCreationTimestamp(metav1.Time{})
+	obj.SetDeletionTimestamp(nil)
+	obj.SetDeletionGracePeriodSeconds(nil)
+	obj.SetManagedFields(nil)
+	obj.SetOwnerReferences(nil)
+
+	unstructured.RemoveNestedField(obj.Object, "status")
+
+	// cluster assigned service addresses must be allocated again
+	if obj.GetKind() == "Service" {
+		unstructured.RemoveNestedField(obj.Object, "spec", "clusterIP")
+		unstructured.RemoveNestedField(obj.Object, "spec", "clusterIPs")
+	}
+}
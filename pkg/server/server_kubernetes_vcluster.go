@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+// vclusterSelector matches the control planes of the vcluster chart.
+const vclusterSelector = "app=vcluster"
+
+// handleVClusters lists the virtual clusters running in a host context,
+// detected by the control plane workloads of the vcluster chart.
+func (s *Server) handleVClusters(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	path := "/apis/apps/v1"
+
+	if namespace := r.URL.Query().Get("namespace"); namespace != "" {
+		path += "/namespaces/" + namespace
+	}
+
+	query := url.Values{
+		"labelSelector": {vclusterSelector},
+	}
+
+	var statefulSets appsv1.StatefulSetList
+
+	if err := client.get(r.Context(), path+"/statefulsets", query, &statefulSets); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	var deployments appsv1.DeploymentList
+
+	if err := client.get(r.Context(), path+"/deployments", query, &deployments); err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	result := &VClusterList{
+		Items: []VCluster{},
+	}
+
+	add := func(kind, namespace, workload string, labels map[string]string, replicas *int32, ready int32) {
+		v := VCluster{
+			Namespace: namespace,
+			Name:      workload,
+			Workload:  kind + "/" + workload,
+
+			Chart: labels["chart"],
+
+			ReadyReplicas: int64(ready),
+		}
+
+		if release := labels["release"]; release != "" {
+			v.Name = release
+		}
+
+		if replicas != nil {
+			v.Replicas = int64(*replicas)
+		}
+
+		v.Ready = v.ReadyReplicas > 0
+		v.Context = s.derivedContext(&config.ContextOrigin{Kind: "vcluster", Context: name, Namespace: v.Namespace, Name: v.Name})
+
+		result.Items = append(result.Items, v)
+	}
+
+	for _, sts := range statefulSets.Items {
+		add("StatefulSet", sts.Namespace, sts.Name, sts.Labels, sts.Spec.Replicas, sts.Status.ReadyReplicas)
+	}
+
+	for _, d := range deployments.Items {
+		add("Deployment", d.Namespace, d.Name, d.Labels, d.Spec.Replicas, d.Status.ReadyReplicas)
+	}
+
+	slices.SortFunc(result.Items, func(a, b VCluster) int {
+		return strings.Compare(a.Namespace+"/"+a.Name, b.Namespace+"/"+b.Name)
+	})
+
+	writeList(w, r, "vclusters", result, result.Items)
+}
+
+// handleConnectVCluster registers a vcluster as context. Its kubeconfig is
+// read from the vc-<name> secret and points to the API server inside the
+// pod, which is reached through a port-forward of the host context.
+func (s *Server) handleConnectVCluster(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+	namespace := r.PathValue("namespace")
+	release := r.PathValue("name")
+
+	req, ok := derivedContextRequest(w, r, release)
+
+	if !ok {
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	var secret corev1.Secret
+
+	if err := client.get(r.Context(), "/api/v1/namespaces/"+namespace+"/secrets/vc-"+release, nil, &secret); err != nil {
+		if statusCode(err) == http.StatusNotFound {
+			writeError(w, r, i18n.NewError("error.vcluster_kubeconfig_not_found", release), http.StatusNotFound)
+			return
+		}
+
+		writeClientError(w, r, err)
+		return
+	}
+
+	data, ok := secret.Data["config"]
+
+	if !ok {
+		writeError(w, r, i18n.NewError("error.vcluster_kubeconfig_not_found", release), http.StatusNotFound)
+		return
+	}
+
+	c, err := config.KubernetesContextFromKubeconfig(req.Name, data)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	base, err := c.Config(r.Context(), nil)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	port := vclusterPort(base)
+
+	// the API server of the vcluster is reached through a port-forward to a
+	// control plane pod of the host context
+	tunnel := &podTunnel{
+		server: s,
+		auth:   auth,
+
+		context:   name,
+		namespace: namespace,
+
+		target: func(ctx context.Context) (string, int, error) {
+			client, err := s.kubernetesClient(ctx, name, auth)
+
+			if err != nil {
+				return "", 0, err
+			}
+
+			pod, err := readyPod(ctx, client, namespace, vclusterSelector+",release="+release)
+
+			if err != nil {
+				return "", 0, err
+			}
+
+			if pod == nil {
+				return "", 0, i18n.NewError("error.vcluster_not_ready", release)
+			}
+
+			return pod.Name, port, nil
+		},
+	}
+
+	load := c.Config
+
+	c.Config = func(ctx context.Context, auth *config.AuthInfo) (*rest.Config, error) {
+		config, err := load(ctx, auth)
+
+		if err != nil {
+			return nil, err
+		}
+
+		config.Dial = tunnel.DialContext
+
+		return config, nil
+	}
+
+	c.Origin = &config.ContextOrigin{Kind: "vcluster", Context: name, Namespace: namespace, Name: release}
+
+	if !s.addDerivedContext(w, r, c, req) {
+		return
+	}
+
+	// the port-forward is closed once the context is removed
+	s.track(c.Name, tunnel)
+}
+
+// vclusterPort returns the port of the API server in the kubeconfig of a
+// vcluster, which targets the port inside the pod (8443 by default).
+func vclusterPort(config *rest.Config) int {
+	u, err := url.Parse(config.Host)
+
+	if err == nil {
+		if port, err := strconv.Atoi(u.Port()); err == nil {
+			return port
+		}
+	}
+
+	return 8443
+}
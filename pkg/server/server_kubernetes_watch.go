@@ -0,0 +1,445 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type watchState string
+
+const (
+	watchConnected    watchState = "connected"
+	watchReconnecting watchState = "reconnecting"
+	watchExpired      watchState = "expired"
+)
+
+// watchStateAnnotation marks synthetic bookmark events that carry the
+// connection state of a resumable watch.
+const watchStateAnnotation = "bridge/watch-state"
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type watchObject struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+
+	Code int `json:"code,omitempty"`
+
+	Metadata struct {
+		ResourceVersion string            `json:"resourceVersion,omitempty"`
+		Annotations     map[string]string `json:"annotations,omitempty"`
+	} `json:"metadata"`
+}
+
+// initialEventsAnnotation marks the bookmark that ends the initial events of
+// watches with sendInitialEvents.
+const initialEventsAnnotation = "k8s.io/initial-events-end"
+
+// upstreamError is returned if the upstream rejects the initial watch request.
+type upstreamError struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("upstream returned %d: %s", e.StatusCode, strings.TrimSpace(string(e.Body)))
+}
+
+// resumableWatch streams a kubernetes watch and transparently re-establishes
+// it after disconnects, resuming from the last seen resourceVersion using
+// bookmarks instead of a full relist. If the resourceVersion expired, an
+// ERROR event with code 410 is emitted and the watch ends, so the client
+// can relist. Errors that retries do not resolve (e.g. revoked credentials
+// or a deleted resource) end the watch with an ERROR event of their status.
+type resumableWatch struct {
+	Transport http.RoundTripper
+
+	URL    *url.URL
+	Header http.Header
+
+	// Bookmarks forwards bookmark events to the client
+	Bookmarks bool
+
+	OnEvent func(e watchEvent) error
+	OnState func(state watchState)
+
+	resourceVersion string
+
+	// initialEvents is set once the initial events of sendInitialEvents
+	// were received, which are not requested again on resume
+	initialEvents bool
+
+	apiVersion string
+	kind       string
+}
+
+func (w *resumableWatch) Run(ctx context.Context) error {
+	query := w.URL.Query()
+
+	w.resourceVersion = query.Get("resourceVersion")
+
+	// a server side timeout ends the watch for good
+	_, hasTimeout := query["timeoutSeconds"]
+
+	backoff := time.Second
+
+	for attempt := 0; ; attempt++ {
+		connected, err := w.watch(ctx)
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if attempt == 0 && !connected && err != nil {
+			return err
+		}
+
+		if errors.Is(err, errWatchExpired) {
+			w.state(watchExpired)
+			return nil
+		}
+
+		var upstreamErr *upstreamError
+
+		if errors.As(err, &upstreamErr) && !retryableStatus(upstreamErr.StatusCode) {
+			w.emitStatus(upstreamErr)
+			return nil
+		}
+
+		if err == nil && hasTimeout {
+			return nil
+		}
+
+		if connected {
+			backoff = time.Second
+		}
+
+		w.state(watchReconnecting)
+
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+var errWatchExpired = errors.New("watch expired")
+
+func (w *resumableWatch) watch(ctx context.Context) (bool, error) {
+	u := *w.URL
+	query := u.Query()
+
+	query.Set("watch", "true")
+	query.Set("allowWatchBookmarks", "true")
+
+	switch {
+	case query.Get("sendInitialEvents") == "true" && w.initialEvents:
+		// resumed from the last event, without the initial events again
+		query.Del("sendInitialEvents")
+		query.Del("resourceVersionMatch")
+
+		query.Set("resourceVersion", w.resourceVersion)
+
+	case query.Get("sendInitialEvents") == "true":
+		// started over until all initial events were received
+
+	case w.resourceVersion != "":
+		query.Set("resourceVersion", w.resourceVersion)
+	}
+
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+
+	if err != nil {
+		return false, err
+	}
+
+	req.Header = w.Header.Clone()
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := w.Transport.RoundTrip(req)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		w.emitExpired()
+		return false, errWatchExpired
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+		return false, &upstreamError{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header,
+			Body:       body,
+		}
+	}
+
+	w.state(watchConnected)
+
+	dec := json.NewDecoder(resp.Body)
+
+	for {
+		var e watchEvent
+
+		if err := dec.Decode(&e); err != nil {
+			if errors.Is(err, io.EOF) {
+				return true, nil
+			}
+
+			return true, err
+		}
+
+		var obj watchObject
+		json.Unmarshal(e.Object, &obj)
+
+		if e.Type == "ERROR" {
+			if obj.Code == http.StatusGone {
+				w.emitExpired()
+				return true, errWatchExpired
+			}
+
+			if err := w.OnEvent(e); err != nil {
+				return true, err
+			}
+
+			continue
+		}
+
+		if obj.Metadata.ResourceVersion != "" {
+			w.resourceVersion = obj.Metadata.ResourceVersion
+		}
+
+		if obj.Kind != "" {
+			w.apiVersion = obj.APIVersion
+			w.kind = obj.Kind
+		}
+
+		if e.Type == "BOOKMARK" && obj.Metadata.Annotations[initialEventsAnnotation] == "true" {
+			w.initialEvents = true
+		}
+
+		if e.Type == "BOOKMARK" && !w.Bookmarks {
+			continue
+		}
+
+		if err := w.OnEvent(e); err != nil {
+			return true, err
+		}
+	}
+}
+
+func (w *resumableWatch) state(state watchState) {
+	if w.OnState != nil {
+		w.OnState(state)
+	}
+}
+
+func (w *resumableWatch) emitExpired() {
+	status, _ := json.Marshal(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Status",
+		"status":     "Failure",
+		"reason":     "Expired",
+		"message":    "resource version expired, relist required",
+		"code":       http.StatusGone,
+	})
+
+	w.OnEvent(watchEvent{
+		Type:   "ERROR",
+		Object: status,
+	})
+}
+
+// emitStatus ends the watch with an ERROR event of an upstream error, using
+// its Status if it returned one.
+func (w *resumableWatch) emitStatus(err *upstreamError) {
+	status := err.Body
+
+	var obj watchObject
+
+	if json.Unmarshal(status, &obj) != nil || obj.Kind != "Status" {
+		message := strings.TrimSpace(string(err.Body))
+
+		if message == "" {
+			message = http.StatusText(err.StatusCode)
+		}
+
+		status, _ = json.Marshal(map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Status",
+			"status":     "Failure",
+			"message":    message,
+			"code":       err.StatusCode,
+		})
+	}
+
+	w.OnEvent(watchEvent{
+		Type:   "ERROR",
+		Object: status,
+	})
+}
+
+// retryableStatus reports whether a watch failing with a status is retried:
+// throttling and server errors, which are usually transient.
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// stateBookmark builds a synthetic bookmark event describing the watch state.
+func (w *resumableWatch) stateBookmark(state watchState) watchEvent {
+	obj, _ := json.Marshal(map[string]any{
+		"apiVersion": w.apiVersion,
+		"kind":       w.kind,
+		"metadata": map[string]any{
+			"resourceVersion": w.resourceVersion,
+			"annotations": map[string]string{
+				watchStateAnnotation: string(state),
+			},
+		},
+	})
+
+	return watchEvent{
+		Type:   "BOOKMARK",
+		Object: obj,
+	}
+}
+
+func isWatchRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+
+	watch := r.URL.Query().Get("watch")
+	return watch == "true" || watch == "1"
+}
+
+// serveKubernetesWatch proxies a JSON watch request as a resumable watch.
+// Clients that request bookmarks additionally receive state bookmarks while
+// the bridge reconnects.
+func serveKubernetesWatch(w http.ResponseWriter, r *http.Request, tr http.RoundTripper, target *url.URL) {
+	u := *target
+	u.Path = strings.TrimSuffix(u.Path, "/") + r.URL.Path
+	u.RawQuery = r.URL.RawQuery
+
+	header := r.Header.Clone()
+	header.Del("Accept-Encoding")
+
+	rc := http.NewResponseController(w)
+
+	started := false
+
+	start := func() {
+		if started {
+			return
+		}
+
+		started = true
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		rc.Flush()
+	}
+
+	write := func(e watchEvent) error {
+		start()
+
+		data, err := json.Marshal(e)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			return err
+		}
+
+		return rc.Flush()
+	}
+
+	bookmarks := r.URL.Query().Get("allowWatchBookmarks") == "true"
+
+	watch := &resumableWatch{
+		Transport: tr,
+
+		URL:    &u,
+		Header: header,
+
+		Bookmarks: bookmarks,
+
+		OnEvent: write,
+	}
+
+	reconnecting := false
+
+	watch.OnState = func(state watchState) {
+		switch state {
+		case watchConnected:
+			start()
+
+			if !reconnecting {
+				return
+			}
+
+			reconnecting = false
+
+		case watchReconnecting:
+			if reconnecting {
+				return
+			}
+
+			reconnecting = true
+
+		default:
+			return
+		}
+
+		if bookmarks {
+			write(watch.stateBookmark(state))
+		}
+	}
+
+	err := watch.Run(r.Context())
+
+	var upstreamErr *upstreamError
+
+	if errors.As(err, &upstreamErr) && !started {
+		if ct := upstreamErr.Header.Get("Content-Type"); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+
+		w.WriteHeader(upstreamErr.StatusCode)
+		w.Write(upstreamErr.Body)
+		return
+	}
+
+	if err != nil && !started {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+func acceptsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return accept == "" || strings.Contains(accept, "json") || strings.Contains(accept, "*/*")
+}
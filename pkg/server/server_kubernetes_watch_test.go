@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResumableWatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		resume int
+		code   int
+	}{
+		{"forbidden", "", http.StatusForbidden, http.StatusForbidden},
+		{"unauthorized", "", http.StatusUnauthorized, http.StatusUnauthorized},
+		{"not found", "", http.StatusNotFound, http.StatusNotFound},
+		{"expired", "", http.StatusGone, http.StatusGone},
+		{"initial events", "sendInitialEvents=true&resourceVersionMatch=NotOlderThan", http.StatusForbidden, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var queries []url.Values
+
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				queries = append(queries, r.URL.Query())
+				attempt := len(queries)
+				mu.Unlock()
+
+				w.Header().Set("Content-Type", "application/json")
+
+				switch attempt {
+				case 1:
+					fmt.Fprintln(w, `{"type":"ADDED","object":{"kind":"Pod","apiVersion":"v1","metadata":{"resourceVersion":"10"}}}`)
+					fmt.Fprintln(w, `{"type":"BOOKMARK","object":{"kind":"Pod","apiVersion":"v1","metadata":{"resourceVersion":"11","annotations":{"k8s.io/initial-events-end":"true"}}}}`)
+
+				case 2:
+					w.WriteHeader(tt.resume)
+					fmt.Fprintf(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","code":%d}`, tt.resume)
+
+				default:
+					t.Error("expected the watch not to be retried")
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			}))
+
+			defer api.Close()
+
+			u, _ := url.Parse(api.URL + "/api/v1/pods?" + tt.query)
+
+			var events []watchEvent
+
+			w := &resumableWatch{
+				Transport: http.DefaultTransport,
+				URL:       u,
+				Header:    http.Header{},
+
+				OnEvent: func(e watchEvent) error {
+					events = append(events, e)
+					return nil
+				},
+			}
+
+			ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+			defer cancel()
+
+			if err := w.Run(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if ctx.Err() != nil {
+				t.Fatal("expected the watch to end")
+			}
+
+			if len(events) != 2 || events[1].Type != "ERROR" {
+				t.Fatalf("expected an ERROR event after the first event, got %d events", len(events))
+			}
+
+			var status watchObject
+			json.Unmarshal(events[1].Object, &status)
+
+			if status.Code != tt.code {
+				t.Fatalf("code = %d, want %d", status.Code, tt.code)
+			}
+
+			// resumed from the last event, without the initial events
+			resumed := queries[1]
+
+			if v := resumed.Get("resourceVersion"); v != "11" {
+				t.Errorf("resourceVersion = %q, want 11", v)
+			}
+
+			if resumed.Has("sendInitialEvents") || resumed.Has("resourceVersionMatch") {
+				t.Errorf("expected the initial events not to be requested again, got %v", resumed)
+			}
+		})
+	}
+}
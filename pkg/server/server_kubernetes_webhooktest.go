@@ -0,0 +1,465 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+const (
+	// webhookTestTimeout bounds test requests unless the webhook sets its
+	// own timeout, like the API server does
+	webhookTestTimeout = 10 * time.Second
+
+	// maxWebhookResponse is the size of responses returned
+	maxWebhookResponse = 1 << 20
+)
+
+// handleWebhookTest sends a test payload to a webhook of an admission
+// webhook configuration, or to any service, through the service proxy of
+// the API server, and returns the response and its latency. Objects are
+// wrapped in an AdmissionReview like the API server would send it, marked
+// as dry run so webhooks skip their side effects; the response is checked
+// for the mistakes the API server rejects, e.g. a mismatching uid. Other
+// payloads are sent as they are.
+func (s *Server) handleWebhookTest(w http.ResponseWriter, r *http.Request) {
+	auth := AuthInfoFromContext(r.Context())
+
+	name := r.PathValue("context")
+
+	var req WebhookTestRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if (req.Object == nil) == (req.Body == nil) {
+		http.Error(w, "either an object or a body is required", http.StatusBadRequest)
+		return
+	}
+
+	client, err := s.kubernetesClient(r.Context(), name, auth)
+
+	if err != nil {
+		writeClientError(w, r, err)
+		return
+	}
+
+	target := &webhookTarget{
+		ref: ServiceRef{
+			Namespace: req.Namespace,
+			Name:      req.Service,
+			Scheme:    req.Scheme,
+			Port:      int32(req.Port),
+		},
+
+		path:    req.Path,
+		timeout: webhookTestTimeout,
+
+		versions: []string{"v1"},
+	}
+
+	if req.Configuration != "" {
+		if req.Kind != "validating" && req.Kind != "mutating" {
+			http.Error(w, "kind must be validating or mutating", http.StatusBadRequest)
+			return
+		}
+
+		webhooks, err := admissionWebhooks(r.Context(), client, req.Kind, req.Configuration)
+
+		if err != nil {
+			writeClientError(w, r, err)
+			return
+		}
+
+		i := slices.IndexFunc(webhooks, func(wh admissionWebhook) bool {
+			return wh.name == req.Webhook || req.Webhook == "" && len(webhooks) == 1
+		})
+
+		if i < 0 {
+			http.Error(w, fmt.Sprintf("webhook %q not found in %s", req.Webhook, req.Configuration), http.StatusNotFound)
+			return
+		}
+
+		wh := webhooks[i]
+
+		if wh.config.Service == nil {
+			http.Error(w, "webhook "+wh.name+" calls a URL, only webhooks of services can be tested", http.StatusBadRequest)
+			return
+		}
+
+		target = webhookTargetOf(&wh)
+	}
+
+	if target.ref.Namespace == "" || target.ref.Name == "" {
+		http.Error(w, "a webhook configuration or a namespace and service are required", http.StatusBadRequest)
+		return
+	}
+
+	if target.ref.Port == 0 {
+		target.ref.Port = 443
+	}
+
+	if target.ref.Scheme == "" {
+		target.ref.Scheme = portForwardScheme("", int(target.ref.Port))
+	}
+
+	// the payload reaches the service as if sent through the proxy
+	if err := s.checkProtection(r, name, target.ref.Namespace); err != nil {
+		writeProtectionError(w, r, err)
+		return
+	}
+
+	method := http.MethodPost
+	contentType := "application/json"
+
+	if req.Method != "" {
+		method = strings.ToUpper(req.Method)
+	}
+
+	if req.ContentType != "" {
+		contentType = req.ContentType
+	}
+
+	result := &WebhookTestResult{
+		Method: method,
+		Path:   target.ref.proxyPath() + "/" + strings.TrimPrefix(target.path, "/"),
+	}
+
+	var review *admissionv1.AdmissionReview
+
+	if req.Object != nil {
+		review, err = webhookReview(r.Context(), client, auth, &req, target.versions)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		result.Request = review
+	} else {
+		result.Request = req.Body
+	}
+
+	var body []byte
+
+	switch v := result.Request.(type) {
+	case string:
+		body = []byte(v)
+	default:
+		body, _ = json.Marshal(v)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), target.timeout)
+	defer cancel()
+
+	u := *client.target
+	u.Path = strings.TrimSuffix(u.Path, "/") + result.Path
+
+	out, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	out.Header.Set("Accept", "application/json")
+	out.Header.Set("Content-Type", contentType)
+
+	for key, value := range req.Headers {
+		out.Header.Set(key, value)
+	}
+
+	started := time.Now()
+
+	resp, err := client.transport.RoundTrip(out)
+
+	result.Duration = time.Since(started).Round(time.Millisecond).String()
+
+	if err != nil {
+		// timeouts and refused connections are results of the test
+		result.Error = err.Error()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	defer resp.Body.Close()
+
+	unreachable := serviceUnreachable(resp)
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponse+1))
+
+	if len(data) > maxWebhookResponse {
+		data = data[:maxWebhookResponse]
+		result.Truncated = true
+	}
+
+	result.Status = resp.StatusCode
+	result.ContentType = resp.Header.Get("Content-Type")
+
+	if err := json.Unmarshal(data, &result.Body); err != nil {
+		result.Body = nil
+		result.Text = string(data)
+	}
+
+	if unreachable {
+		result.Error = errServiceUnreachable.Error() + ": " + statusMessage(&upstreamError{StatusCode: resp.StatusCode, Header: resp.Header, Body: data})
+	}
+
+	if review != nil && !unreachable && resp.StatusCode == http.StatusOK {
+		result.Review = checkWebhookResponse(review, data, target.mutating)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// webhookTarget is the service a webhook is called at.
+type webhookTarget struct {
+	ref  ServiceRef
+	path string
+
+	timeout  time.Duration
+	mutating bool
+
+	// versions of AdmissionReview the webhook accepts
+	versions []string
+}
+
+// admissionWebhook is a webhook of a validating or mutating webhook
+// configuration.
+type admissionWebhook struct {
+	name     string
+	mutating bool
+
+	config   admissionregistrationv1.WebhookClientConfig
+	timeout  *int32
+	versions []string
+}
+
+// admissionWebhooks returns the webhooks of a validating or mutating webhook
+// configuration.
+func admissionWebhooks(ctx context.Context, client *kubernetesClient, kind, name string) ([]admissionWebhook, error) {
+	var result []admissionWebhook
+
+	path := "/apis/admissionregistration.k8s.io/v1/" + kind + "webhookconfigurations/" + name
+
+	if kind == "mutating" {
+		var c admissionregistrationv1.MutatingWebhookConfiguration
+
+		if err := client.get(ctx, path, nil, &c); err != nil {
+			return nil, err
+		}
+
+		for _, wh := range c.Webhooks {
+			result = append(result, admissionWebhook{wh.Name, true, wh.ClientConfig, wh.TimeoutSeconds, wh.AdmissionReviewVersions})
+		}
+
+		return result, nil
+	}
+
+	var c admissionregistrationv1.ValidatingWebhookConfiguration
+
+	if err := client.get(ctx, path, nil, &c); err != nil {
+		return nil, err
+	}
+
+	for _, wh := range c.Webhooks {
+		result = append(result, admissionWebhook{wh.Name, false, wh.ClientConfig, wh.TimeoutSeconds, wh.AdmissionReviewVersions})
+	}
+
+	return result, nil
+}
+
+// webhookTargetOf returns the service a webhook is called at, on port 443
+// unless set, with the timeout of the webhook.
+func webhookTargetOf(wh *admissionWebhook) *webhookTarget {
+	service := wh.config.Service
+
+	target := &webhookTarget{
+		ref: ServiceRef{
+			Namespace: service.Namespace,
+			Name:      service.Name,
+			Scheme:    "https",
+			Port:      443,
+		},
+
+		timeout:  webhookTestTimeout,
+		mutating: wh.mutating,
+		versions: wh.versions,
+	}
+
+	if service.Port != nil {
+		target.ref.Port = *service.Port
+	}
+
+	if service.Path != nil {
+		target.path = *service.Path
+	}
+
+	if wh.timeout != nil {
+		target.timeout = time.Duration(*wh.timeout) * time.Second
+	}
+
+	return target
+}
+
+// webhookReview wraps the object of a request in an AdmissionReview of the
+// first version the webhook accepts, v1 or v1beta1, which share their
+// schema. Objects without a namespace are reviewed in the default
+// namespace.
+func webhookReview(ctx context.Context, client *kubernetesClient, auth *config.AuthInfo, req *WebhookTestRequest, versions []string) (*admissionv1.AdmissionReview, error) {
+	operation := admissionv1.Operation(strings.ToUpper(req.Operation))
+
+	if operation == "" {
+		operation = admissionv1.Create
+
+		if req.OldObject != nil {
+			operation = admissionv1.Update
+		}
+	}
+
+	switch operation {
+	case admissionv1.Create, admissionv1.Update, admissionv1.Delete, admissionv1.Connect:
+	default:
+		return nil, fmt.Errorf("unsupported operation %s", operation)
+	}
+
+	target, err := newManifestMapper(client).resolve(ctx, req.Object, "default")
+
+	if err != nil {
+		return nil, err
+	}
+
+	kind, _ := req.Object["kind"].(string)
+
+	version := "v1"
+
+	if len(versions) > 0 && !slices.Contains(versions, "v1") {
+		version = versions[0]
+	}
+
+	user := authenticationv1.UserInfo{
+		Username: "bridge",
+	}
+
+	if auth != nil && auth.User != "" {
+		user.Username = auth.User
+		user.Groups = auth.Groups
+	}
+
+	object, _ := json.Marshal(req.Object)
+
+	// webhooks skip their side effects for dry runs
+	dryRun := true
+
+	request := &admissionv1.AdmissionRequest{
+		UID: uuid.NewUUID(),
+
+		Kind:     metav1.GroupVersionKind{Group: target.Group, Version: target.Version, Kind: kind},
+		Resource: metav1.GroupVersionResource{Group: target.Group, Version: target.Version, Resource: target.Resource},
+
+		RequestKind:     &metav1.GroupVersionKind{Group: target.Group, Version: target.Version, Kind: kind},
+		RequestResource: &metav1.GroupVersionResource{Group: target.Group, Version: target.Version, Resource: target.Resource},
+
+		Name:      target.Name,
+		Namespace: target.Namespace,
+		Operation: operation,
+		UserInfo:  user,
+
+		Object: runtime.RawExtension{Raw: object},
+		DryRun: &dryRun,
+	}
+
+	// deletions review the old object only
+	if operation == admissionv1.Delete {
+		request.Object = runtime.RawExtension{}
+		request.OldObject = runtime.RawExtension{Raw: object}
+	}
+
+	if req.OldObject != nil {
+		old, _ := json.Marshal(req.OldObject)
+		request.OldObject = runtime.RawExtension{Raw: old}
+	}
+
+	return &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "admission.k8s.io/" + version,
+			Kind:       "AdmissionReview",
+		},
+
+		Request: request,
+	}, nil
+}
+
+// checkWebhookResponse summarizes the response to an AdmissionReview and
+// reports what the API server would reject: a response of another kind or
+// version, without the uid of the request, or with a patch that is not a
+// JSON patch or comes from a validating webhook.
+func checkWebhookResponse(review *admissionv1.AdmissionReview, data []byte, mutating bool) *WebhookReview {
+	result := &WebhookReview{
+		Problems: []string{},
+	}
+
+	var resp admissionv1.AdmissionReview
+
+	if err := json.Unmarshal(data, &resp); err != nil {
+		result.Problems = append(result.Problems, "response is not an AdmissionReview: "+err.Error())
+		return result
+	}
+
+	if resp.APIVersion != review.APIVersion || resp.Kind != review.Kind {
+		result.Problems = append(result.Problems, fmt.Sprintf("response is %s %s, expected %s %s", resp.APIVersion, resp.Kind, review.APIVersion, review.Kind))
+	}
+
+	if resp.Response == nil {
+		result.Problems = append(result.Problems, "response has no response field")
+		return result
+	}
+
+	result.Allowed = resp.Response.Allowed
+	result.Warnings = resp.Response.Warnings
+
+	if status := resp.Response.Result; status != nil {
+		result.Code = status.Code
+		result.Message = status.Message
+	}
+
+	if resp.Response.UID != review.Request.UID {
+		result.Problems = append(result.Problems, fmt.Sprintf("response uid %q does not match the request uid %q", resp.Response.UID, review.Request.UID))
+	}
+
+	if len(resp.Response.Patch) > 0 {
+		if !mutating {
+			result.Problems = append(result.Problems, "validating webhooks must not return a patch")
+		}
+
+		if resp.Response.PatchType == nil || *resp.Response.PatchType != admissionv1.PatchTypeJSONPatch {
+			result.Problems = append(result.Problems, "patchType must be JSONPatch")
+		}
+
+		if err := json.Unmarshal(resp.Response.Patch, &result.Patch); err != nil {
+			result.Problems = append(result.Problems, "patch is not a JSON patch: "+err.Error())
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+var errResponseTooLarge = i18n.NewError("error.response_too_large")
+
+// applyKubernetesLimits bounds list and log requests before they are sent
+// upstream. Watches and followed logs are streamed with natural backpressure
+// as the proxy only reads from upstream as fast as the client consumes.
+func applyKubernetesLimits(limits config.LimitsConfig, r *http.Request) {
+	if r.Method != http.MethodGet {
+		return
+	}
+
+	req, ok := parseKubernetesPath(r.URL.Path)
+
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+
+	if query.Get("watch") == "true" || query.Get("watch") == "1" {
+		return
+	}
+
+	changed := false
+
+	if req.IsList() && limits.MaxListLimit > 0 {
+		limit, _ := strconv.ParseInt(query.Get("limit"), 10, 64)
+
+		if limit <= 0 || limit > limits.MaxListLimit {
+			query.Set("limit", strconv.FormatInt(limits.MaxListLimit, 10))
+			changed = true
+		}
+	}
+
+	if req.Resource == "pods" && req.Subresource == "log" && limits.MaxLogTailLines > 0 {
+		if query.Get("tailLines") == "" && query.Get("sinceSeconds") == "" && query.Get("sinceTime") == "" {
+			query.Set("tailLines", strconv.FormatInt(limits.MaxLogTailLines, 10))
+			changed = true
+		}
+	}
+
+	if changed {
+		r.URL.RawQuery = query.Encode()
+	}
+}
+
+type logTailKey struct{}
+
+// withLogTail marks log requests whose responses are trimmed to their last
+// lines instead of being rejected if they exceed the maximum response size.
+// Their responses are requested uncompressed, so lines can be dropped.
+func withLogTail(limits config.LimitsConfig, r *http.Request) *http.Request {
+	if limits.MaxResponseSize <= 0 || r.Method != http.MethodGet || isStreamingRequest(r) {
+		return r
+	}
+
+	req, ok := parseKubernetesPath(r.URL.Path)
+
+	if !ok || req.Resource != "pods" || req.Subresource != "log" {
+		return r
+	}
+
+	r.Header.Set("Accept-Encoding", "identity")
+
+	return r.WithContext(context.WithValue(r.Context(), logTailKey{}, true))
+}
+
+// limitResponse enforces the configured maximum size of non-streaming
+// responses before they are sent to the client. Responses of unknown length
+// are buffered up to the limit. Log tails keep their last lines, larger
+// responses are rejected.
+func limitResponse(limits config.LimitsConfig) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if limits.MaxResponseSize <= 0 || isStreamingRequest(resp.Request) {
+			return nil
+		}
+
+		if tail, _ := resp.Request.Context().Value(logTailKey{}).(bool); tail && resp.StatusCode == http.StatusOK {
+			return tailResponse(resp, limits.MaxResponseSize)
+		}
+
+		if resp.ContentLength > limits.MaxResponseSize {
+			resp.Body.Close()
+			return errResponseTooLarge
+		}
+
+		if resp.ContentLength >= 0 {
+			return nil
+		}
+
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(io.LimitReader(resp.Body, limits.MaxResponseSize+1))
+
+		if err != nil {
+			return err
+		}
+
+		if int64(len(data)) > limits.MaxResponseSize {
+			return errResponseTooLarge
+		}
+
+		setResponseBody(resp, data)
+
+		return nil
+	}
+}
+
+// tailResponse reads a log to its end and keeps its last lines that fit
+// into max bytes, dropping the oldest ones.
+func tailResponse(resp *http.Response, max int64) error {
+	defer resp.Body.Close()
+
+	var data []byte
+
+	truncated := false
+	chunk := make([]byte, 32*1024)
+
+	for {
+		n, err := resp.Body.Read(chunk)
+		data = append(data, chunk[:n]...)
+
+		if int64(len(data)) > 2*max {
+			data = dropOldestLines(data, max)
+			truncated = true
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if int64(len(data)) > max {
+		data = dropOldestLines(data, max)
+		truncated = true
+	}
+
+	if truncated {
+		resp.Header.Add("Warning", `299 bridge "Log truncated to its last lines"`)
+	}
+
+	setResponseBody(resp, data)
+
+	return nil
+}
+
+// dropOldestLines keeps the last max bytes of data, starting at a line.
+func dropOldestLines(data []byte, max int64) []byte {
+	tail := data[int64(len(data))-max:]
+
+	if i := bytes.IndexByte(tail, '\n'); i >= 0 && i < len(tail)-1 {
+		tail = tail[i+1:]
+	}
+
+	n := copy(data, tail)
+
+	return data[:n]
+}
+
+func setResponseBody(resp *http.Response, data []byte) {
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+
+	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+}
+
+func limitErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errResponseTooLarge) {
+		writeTooLarge(w, r)
+		return
+	}
+
+	log.Printf("proxy: %s %s: %v", r.Method, r.URL.Path, err)
+
+	setProblem(w, problemFor(err))
+
+	http.Error(w, fmt.Sprintf("proxy error: %v", err), http.StatusBadGateway)
+}
+
+func isStreamingRequest(r *http.Request) bool {
+	if r == nil {
+		return false
+	}
+
+	query := r.URL.Query()
+
+	return query.Get("watch") == "true" || query.Get("watch") == "1" || query.Get("follow") == "true" || query.Get("follow") == "1"
+}
+
+// writeTooLarge rejects a response exceeding the maximum size with a
+// kubernetes Status, so kubectl and client-go surface the message.
+func writeTooLarge(w http.ResponseWriter, r *http.Request) {
+	err := i18n.NewError("error.response_too_large_paginate")
+
+	if strings.Contains(r.Header.Get("Accept"), "application/problem+json") {
+		writeError(w, r, err, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	tag := i18n.Negotiate(r)
+
+	setProblem(w, ProblemTooLarge)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", tag.String())
+	w.Header().Set("X-Bridge-Message", err.ID)
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Status",
+		"status":     "Failure",
+		"reason":     "RequestEntityTooLarge",
+		"message":    err.Translate(tag),
+		"code":       http.StatusRequestEntityTooLarge,
+	})
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+func TestLimitResponse(t *testing.T) {
+	limits := config.LimitsConfig{
+		MaxResponseSize: 16,
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		length int64
+		want   string
+		err    error
+	}{
+		{"within the limit", "/api/v1/pods", `{"items":[]}`, 12, `{"items":[]}`, nil},
+		{"beyond the limit", "/api/v1/pods", `{"items":[{},{},{}]}`, 20, "", errResponseTooLarge},
+		{"unknown length within the limit", "/api/v1/pods", `{"items":[]}`, -1, `{"items":[]}`, nil},
+		{"unknown length beyond the limit", "/api/v1/pods", `{"items":[{},{},{}]}`, -1, "", errResponseTooLarge},
+		{"watch", "/api/v1/pods?watch=true", `{"type":"ADDED","object":{}}`, -1, `{"type":"ADDED","object":{}}`, nil},
+		{"log within the limit", "/api/v1/namespaces/default/pods/web/log", "one\ntwo\n", -1, "one\ntwo\n", nil},
+		{"log beyond the limit", "/api/v1/namespaces/default/pods/web/log", "one\ntwo\nthree\nfour\nfive\n", -1, "four\nfive\n", nil},
+		{"log beyond the limit with length", "/api/v1/namespaces/default/pods/web/log", "one\ntwo\nthree\nfour\nfive\n", 24, "four\nfive\n", nil},
+		{"long log", "/api/v1/namespaces/default/pods/web/log", strings.Repeat("line\n", 1000) + "last\n", -1, "line\nline\nlast\n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := withLogTail(limits, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			resp := &http.Response{
+				StatusCode:    http.StatusOK,
+				Header:        http.Header{},
+				Body:          io.NopCloser(strings.NewReader(tt.body)),
+				ContentLength: tt.length,
+				Request:       r,
+			}
+
+			err := limitResponse(limits)(resp)
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			data, _ := io.ReadAll(resp.Body)
+
+			if string(data) != tt.want {
+				t.Fatalf("body = %q, want %q", data, tt.want)
+			}
+
+			if truncated := resp.Header.Get("Warning") != ""; truncated != (tt.body != tt.want) {
+				t.Errorf("warning = %q, want truncated %v", resp.Header.Get("Warning"), tt.body != tt.want)
+			}
+
+			if resp.ContentLength >= 0 && resp.ContentLength != int64(len(data)) {
+				t.Errorf("content length = %d, want %d", resp.ContentLength, len(data))
+			}
+		})
+	}
+}
+
+func TestLimitErrorHandler(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/pods", nil)
+	w := httptest.NewRecorder()
+
+	limitErrorHandler(w, r, errResponseTooLarge)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	var status struct {
+		Kind string `json:"kind"`
+		Code int    `json:"code"`
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || status.Kind != "Status" || status.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected a Status body, got %s", w.Body)
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+// writeError writes an error as plain text. Errors of the message catalog
+// are localized to the locale of the request and carry their message ID in
+// the X-Bridge-Message header, so the UI needs no English strings. Clients
+// accepting application/problem+json receive the error as problem details.
+func writeError(w http.ResponseWriter, r *http.Request, err error, code int) {
+	setProblem(w, problemFor(err))
+	setProblem(w, problemForStatus(r, code))
+
+	message := err.Error()
+
+	if msg, ok := err.(*i18n.Error); ok {
+		tag := i18n.Negotiate(r)
+
+		w.Header().Set("Content-Language", tag.String())
+		w.Header().Set("X-Bridge-Message", msg.ID)
+
+		message = msg.Translate(tag)
+	}
+
+	if !strings.Contains(r.Header.Get("Accept"), "application/problem+json") {
+		http.Error(w, message, code)
+		return
+	}
+
+	problem := w.Header().Get("X-Bridge-Error")
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+
+	json.NewEncoder(w).Encode(&Problem{
+		Type:   "urn:bridge:problem:" + problem,
+		Title:  http.StatusText(code),
+		Status: code,
+		Detail: message,
+
+		Code:    problem,
+		Message: w.Header().Get("X-Bridge-Message"),
+	})
+}
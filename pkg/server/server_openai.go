@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+var errAIDisabled = i18n.NewError("error.ai_disabled")
+
+// complete runs a single chat completion against the configured OpenAI
+// compatible provider.
+func (s *Server) complete(ctx context.Context, system, prompt string) (string, error) {
+	cfg := s.config.OpenAI
+
+	if cfg == nil {
+		return "", errAIDisabled
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"model": cfg.Model,
+
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": prompt},
+		},
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(cfg.URL, "/")+"/chat/completions", bytes.NewReader(body))
+
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("completion failed: %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	if len(result.Choices) == 0 {
+		return "", errors.New("completion returned no choices")
+	}
+
+	return result.Choices[0].Message.Content, nil
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/adrianliechti/bridge/pkg/i18n"
+
+	"k8s.io/apimachinery/pkg/util/httpstream"
+)
+
+// Problem codes classify error responses in the X-Bridge-Error header, so
+// clients can retry or offer fixes without parsing messages. Unlike
+// messages, the codes are stable.
+const (
+	// ProblemContextUnreachable is returned if the API server of a context
+	// cannot be reached, retrying later may succeed
+	ProblemContextUnreachable = "context_unreachable"
+
+	// ProblemAuthExpired is returned if the session at the bridge or the
+	// credentials of a context expired or were rejected, logging in again
+	// may succeed
+	ProblemAuthExpired = "auth_expired"
+
+	// ProblemForbidden is returned if the caller is not allowed to perform a
+	// request, e.g. by RBAC or a protected namespace
+	ProblemForbidden = "forbidden"
+
+	// ProblemUpstreamTimeout is returned if the API server or another
+	// upstream did not respond in time
+	ProblemUpstreamTimeout = "upstream_timeout"
+
+	// ProblemUnsupportedUpgrade is returned if a protocol upgrade (e.g. a
+	// websocket exec) is not supported by the client or the API server
+	ProblemUnsupportedUpgrade = "unsupported_upgrade"
+
+	// ProblemConfirmationRequired is returned for changes of protected
+	// namespaces without a confirmation token
+	ProblemConfirmationRequired = "confirmation_required"
+
+	ProblemNotFound     = "not_found"
+	ProblemConflict     = "conflict"
+	ProblemInvalid      = "invalid"
+	ProblemTooLarge     = "too_large"
+	ProblemRateLimited  = "rate_limited"
+	ProblemUnavailable  = "unavailable"
+	ProblemInternal     = "internal"
+	ProblemUnclassified = "unclassified"
+)
+
+// problemMessages maps messages of the catalog to problem codes that the
+// status code alone does not reveal.
+var problemMessages = map[string]string{
+	"error.context_offline":       ProblemContextUnreachable,
+	"error.confirmation_required": ProblemConfirmationRequired,
+	"error.disruption_collection": ProblemForbidden,
+}
+
+// problemFor classifies an error, it returns an empty code if the status
+// code of its response classifies it.
+func problemFor(err error) string {
+	var msg *i18n.Error
+
+	if errors.As(err, &msg) {
+		if code, ok := problemMessages[msg.ID]; ok {
+			return code
+		}
+	}
+
+	var netErr net.Error
+
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ProblemUpstreamTimeout
+	}
+
+	if isNetworkError(err) {
+		return ProblemContextUnreachable
+	}
+
+	// exec credential plugins (e.g. kubelogin) fail if their login expired
+	if strings.Contains(err.Error(), "getting credentials") {
+		return ProblemAuthExpired
+	}
+
+	return ""
+}
+
+// problemForStatus classifies an error response by its status code.
+func problemForStatus(r *http.Request, code int) string {
+	if httpstream.IsUpgradeRequest(r) {
+		switch code {
+		case http.StatusBadRequest, http.StatusUpgradeRequired, http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+			return ProblemUnsupportedUpgrade
+		}
+	}
+
+	switch code {
+	case http.StatusUnauthorized:
+		return ProblemAuthExpired
+
+	case http.StatusForbidden:
+		return ProblemForbidden
+
+	case http.StatusNotFound, http.StatusGone:
+		return ProblemNotFound
+
+	case http.StatusPreconditionRequired:
+		return ProblemConfirmationRequired
+
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return ProblemConflict
+
+	case http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusNotAcceptable, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity:
+		return ProblemInvalid
+
+	case http.StatusRequestEntityTooLarge:
+		return ProblemTooLarge
+
+	case http.StatusTooManyRequests:
+		return ProblemRateLimited
+
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return ProblemUpstreamTimeout
+
+	case http.StatusBadGateway:
+		return ProblemContextUnreachable
+
+	case http.StatusServiceUnavailable:
+		return ProblemUnavailable
+
+	case http.StatusInternalServerError:
+		return ProblemInternal
+	}
+
+	return ProblemUnclassified
+}
+
+// setProblem sets the problem code of an error response, unless a handler
+// already classified it.
+func setProblem(w http.ResponseWriter, code string) {
+	if code == "" || w.Header().Get("X-Bridge-Error") != "" {
+		return
+	}
+
+	w.Header().Set("X-Bridge-Error", code)
+}
+
+// ProblemMiddleware classifies all error responses by their status code,
+// which keeps the codes set by handlers and upstream bridges.
+func ProblemMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&problemWriter{ResponseWriter: w, request: r}, r)
+	})
+}
+
+type problemWriter struct {
+	http.ResponseWriter
+
+	request *http.Request
+}
+
+func (w *problemWriter) WriteHeader(code int) {
+	if code >= 400 {
+		setProblem(w.ResponseWriter, problemForStatus(w.request, code))
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *problemWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *problemWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *problemWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
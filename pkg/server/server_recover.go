@@ -0,0 +1,31 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/adrianliechti/bridge/pkg/logging"
+)
+
+func RecoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+
+			if v == nil {
+				return
+			}
+
+			// aborted proxy streams are expected, let net/http handle them silently
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			logging.Crash(v, fmt.Sprintf("request: %s %s", r.Method, r.URL.Path))
+
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
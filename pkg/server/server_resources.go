@@ -0,0 +1,104 @@
+package server
+
+import (
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// track binds a closer (e.g. an ssh tunnel or port-forward) to a context, so
+// it is torn down together with the context. The returned function closes and
+// releases the resource early.
+func (s *Server) track(name string, c io.Closer) func() {
+	key := strings.ToLower(name)
+
+	s.resourcesMu.Lock()
+
+	if s.resources == nil {
+		s.resources = make(map[string]map[io.Closer]struct{})
+	}
+
+	if s.resources[key] == nil {
+		s.resources[key] = make(map[io.Closer]struct{})
+	}
+
+	s.resources[key][c] = struct{}{}
+
+	s.resourcesMu.Unlock()
+
+	return func() {
+		s.resourcesMu.Lock()
+		delete(s.resources[key], c)
+		s.resourcesMu.Unlock()
+
+		c.Close()
+	}
+}
+
+// release closes all sessions, resources and pooled transports bound to a context.
+func (s *Server) release(name string) {
+	key := strings.ToLower(name)
+
+	s.sessions.killContext(name)
+	s.transports.evictContext(name)
+	s.informers.evictContext(name)
+	s.catalogs.invalidate(name)
+	s.schemas.invalidate(name)
+
+	s.resourcesMu.Lock()
+	closers := s.resources[key]
+	delete(s.resources, key)
+	s.resourcesMu.Unlock()
+
+	for c := range closers {
+		c.Close()
+	}
+}
+
+func (s *Server) releaseAll() {
+	s.resourcesMu.Lock()
+	resources := s.resources
+	s.resources = nil
+	s.resourcesMu.Unlock()
+
+	for _, closers := range resources {
+		for c := range closers {
+			c.Close()
+		}
+	}
+}
+
+// expireContexts periodically removes dynamic contexts whose TTL has passed.
+func (s *Server) expireContexts(done <-chan struct{}) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+
+		case now := <-ticker.C:
+			var expired []string
+
+			s.mu.RLock()
+
+			if s.config.Kubernetes != nil {
+				for _, c := range s.config.Kubernetes.Contexts {
+					if c.Dynamic && !c.Expires.IsZero() && now.After(c.Expires) {
+						expired = append(expired, c.Name)
+					}
+				}
+			}
+
+			s.mu.RUnlock()
+
+			for _, name := range expired {
+				if s.RemoveKubernetesContext(name) {
+					log.Printf("context %q expired and was removed", name)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+const (
+	// systemCheckInterval is the interval of the sleep and network checks
+	systemCheckInterval = 5 * time.Second
+
+	// sleepThreshold is the clock gap above which the system is considered
+	// to have been suspended
+	sleepThreshold = 15 * time.Second
+)
+
+// watchSystem detects resumes after a system sleep and changes of the
+// network interfaces (VPN, Wi-Fi), after which connections hang silently
+// instead of failing. The monotonic clock stops during a sleep on most
+// systems while the wall clock does not, so a resume shows as a gap between
+// them, or as a late tick where the monotonic clock keeps running.
+func (s *Server) watchSystem(done <-chan struct{}) {
+	ticker := time.NewTicker(systemCheckInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	addrs := networkAddresses()
+
+	for {
+		select {
+		case <-done:
+			return
+
+		case <-ticker.C:
+			now := time.Now()
+
+			elapsed := now.Sub(last)
+			wall := now.Round(0).Sub(last.Round(0))
+
+			last = now
+
+			if max(elapsed, wall) > systemCheckInterval+sleepThreshold {
+				addrs = networkAddresses()
+				s.Reconnect("system resumed after " + wall.Round(time.Second).String())
+
+				continue
+			}
+
+			if current := networkAddresses(); current != addrs {
+				addrs = current
+				s.Reconnect("network changed")
+			}
+		}
+	}
+}
+
+// networkAddresses returns a fingerprint of the addresses of all interfaces
+// that are up.
+func networkAddresses() string {
+	interfaces, err := net.Interfaces()
+
+	if err != nil {
+		return ""
+	}
+
+	var result []string
+
+	for _, i := range interfaces {
+		if i.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		addrs, err := i.Addrs()
+
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			result = append(result, i.Name+"="+addr.String())
+		}
+	}
+
+	slices.Sort(result)
+
+	return strings.Join(result, ",")
+}
+
+// Reconnect tears down all upstream connections, so tunnels, watches and
+// port-forwards are re-established instead of hanging. Hosts with native
+// sleep or network notifications may call it directly.
+func (s *Server) Reconnect(reason string) {
+	log.Printf("reconnecting upstreams: %s", reason)
+
+	// running watches and streams fail and resume on new connections
+	s.transports.reset()
+	s.informers.evict(func(*informer) bool { return true })
+
+	for _, item := range s.intercepts.list() {
+		// the intercept reconnects its tunnel once it ended
+		item.closeTunnel()
+	}
+
+	for _, item := range s.portForwards.list() {
+		// the next connection opens a new port-forward
+		item.tunnel.Close()
+	}
+
+	for _, state := range s.connectivity.list() {
+		if c, ok := s.kubernetesContext(state.Context); ok && state.Offline {
+			go s.probe(c)
+		}
+	}
+}
+
+func (s *Server) handleReconnect(w http.ResponseWriter, r *http.Request) {
+	if s.config.Auth != nil {
+		// streams of all users would be interrupted
+		writeError(w, r, i18n.NewError("error.server_mode_reconnect"), http.StatusForbidden)
+		return
+	}
+
+	s.Reconnect("requested")
+
+	w.WriteHeader(http.StatusNoContent)
+}
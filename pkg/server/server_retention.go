@@ -0,0 +1,220 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+	"github.com/adrianliechti/bridge/pkg/store"
+)
+
+const pruneInterval = time.Hour
+
+// pruneData removes local data older than the configured retentions, on
+// start and then periodically.
+func (s *Server) pruneData(done <-chan struct{}) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		s.prune()
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) prune() {
+	retention := s.config.Retention
+
+	if db, err := dataStore(); err == nil {
+		pruneDiscovery(db)
+	}
+
+	if retention.Audit > 0 || retention.Snapshots > 0 || retention.Transcripts > 0 {
+		db, err := dataStore()
+
+		if err != nil {
+			log.Printf("failed to prune local data: %v", err)
+			return
+		}
+
+		if retention.Audit > 0 {
+			err := db.DeleteFunc(auditBucket, func(_ string, decode func(v any) error) bool {
+				var e AuditEntry
+
+				if err := decode(&e); err != nil {
+					return false
+				}
+
+				return time.Since(e.Time) > retention.Audit
+			})
+
+			if err != nil {
+				log.Printf("failed to prune audit log: %v", err)
+			}
+		}
+
+		s.trash.purge(db)
+		s.transcripts.purge(db)
+	}
+
+	if retention.History > 0 {
+		s.monitors.prune(retention.History)
+	}
+
+	if retention.CrashReports > 0 {
+		pruneFiles(filepath.Join(config.DataDir(), "crashes"), retention.CrashReports)
+	}
+}
+
+// prune drops monitor results older than the retention.
+func (m *monitors) prune(retention time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.load()
+
+	changed := false
+
+	for _, e := range m.entries {
+		n := len(e.History)
+
+		e.History = slices.DeleteFunc(e.History, func(r MonitorResult) bool {
+			return time.Since(r.Time) > retention
+		})
+
+		changed = changed || len(e.History) != n
+	}
+
+	if changed {
+		m.save()
+	}
+}
+
+func pruneFiles(dir string, retention time.Duration) {
+	files, err := os.ReadDir(dir)
+
+	if err != nil {
+		return
+	}
+
+	for _, f := range files {
+		info, err := f.Info()
+
+		if err != nil || time.Since(info.ModTime()) < retention {
+			continue
+		}
+
+		os.Remove(filepath.Join(dir, f.Name()))
+	}
+}
+
+// wipeData deletes all local data: the store, crash reports and caches, as
+// well as the state held in memory.
+func (s *Server) wipeData() error {
+	db, err := dataStore()
+
+	if err != nil {
+		return err
+	}
+
+	if err := db.Wipe(); err != nil {
+		return err
+	}
+
+	if err := removeDataFiles(); err != nil {
+		return err
+	}
+
+	s.namespaces.reset()
+	s.monitors.reset()
+	s.search.reset()
+	s.portForwards.resetSaved()
+
+	s.mu.Lock()
+	s.pins = make(map[string]bool)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// WipeData deletes all local data of a bridge that is not running.
+func WipeData() error {
+	if err := store.Remove(filepath.Join(config.DataDir(), StoreFile)); err != nil {
+		return err
+	}
+
+	return removeDataFiles()
+}
+
+// removeDataFiles deletes the data kept outside of the store, including
+// files of earlier versions that were not migrated yet.
+func removeDataFiles() error {
+	for _, name := range []string{"crashes", "cache", "trash", auditFile, namespaceHistoryFile, monitorsFile} {
+		if err := os.RemoveAll(filepath.Join(config.DataDir(), name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *namespaceHistory) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.pending != nil {
+		h.pending.Stop()
+		h.pending = nil
+	}
+
+	h.loaded = false
+	h.entries = nil
+}
+
+// reset forgets all monitors; running evaluations finish without saving.
+func (m *monitors) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.loaded = false
+	m.load()
+}
+
+func (i *searchIndex) reset() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.entries = nil
+}
+
+func (s *Server) handleWipeData(w http.ResponseWriter, r *http.Request) {
+	if s.config.Auth != nil {
+		// local data of all users would be lost
+		writeError(w, r, i18n.NewError("error.server_mode_wipe"), http.StatusForbidden)
+		return
+	}
+
+	if r.URL.Query().Get("confirm") != "true" {
+		writeError(w, r, i18n.NewError("error.wipe_confirm"), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.wipeData(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("local data wiped")
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,377 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/i18n"
+)
+
+var (
+	errTooManySessions     = i18n.NewError("error.too_many_sessions")
+	errTooManyUserSessions = i18n.NewError("error.too_many_user_sessions")
+	errSessionIdle         = i18n.NewError("error.session_idle")
+	errSessionTerminated   = i18n.NewError("error.session_terminated")
+	errContextReleased     = i18n.NewError("error.context_released")
+)
+
+// sessionManager keeps a registry of long running streams (exec, logs,
+// watches, forwards), enforces limits and cancels idle or killed sessions.
+type sessionManager struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+type session struct {
+	ID string
+
+	Kind    string
+	Context string
+	Path    string
+	Owner   string
+
+	Started time.Time
+
+	lastActivity atomic.Int64
+	bytes        atomic.Int64
+
+	// recorder records the output of exec and log sessions
+	recorder *transcriptRecorder
+
+	cancel context.CancelCauseFunc
+}
+
+// streamKind classifies long running requests; it returns an empty string
+// for regular requests.
+func streamKind(contextType string, r *http.Request) string {
+	query := r.URL.Query()
+
+	switch contextType {
+	case "kubernetes":
+		if isWatchRequest(r) {
+			return "watch"
+		}
+
+		if isNodeShellTerminal(r.URL.Path) {
+			return "exec"
+		}
+
+		req, ok := parseKubernetesPath(r.URL.Path)
+
+		if !ok || req.Resource != "pods" {
+			return ""
+		}
+
+		switch req.Subresource {
+		case "log":
+			if query.Get("follow") == "true" {
+				return "logs"
+			}
+
+		case "exec", "attach":
+			return "exec"
+
+		case "portforward":
+			return "forward"
+		}
+
+	case "docker":
+		path := r.URL.Path
+
+		switch {
+		case strings.HasSuffix(path, "/attach"), strings.HasSuffix(path, "/attach/ws"), strings.Contains(path, "/exec/") && strings.HasSuffix(path, "/start"), strings.HasSuffix(path, "/host/terminal"):
+			return "exec"
+
+		case strings.HasSuffix(path, "/logs") && (query.Get("follow") == "1" || query.Get("follow") == "true"):
+			return "logs"
+
+		case strings.HasSuffix(path, "/events"), strings.HasSuffix(path, "/stats") && query.Get("stream") != "0" && query.Get("stream") != "false":
+			return "watch"
+		}
+	}
+
+	return ""
+}
+
+func (m *sessionManager) start(limits config.LimitsConfig, kind, contextName, path, owner string, cancel context.CancelCauseFunc) (*session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if limits.MaxSessions > 0 && len(m.sessions) >= limits.MaxSessions {
+		return nil, errTooManySessions
+	}
+
+	if limits.MaxSessionsPerUser > 0 {
+		count := 0
+
+		for _, s := range m.sessions {
+			if s.Owner == owner {
+				count++
+			}
+		}
+
+		if count >= limits.MaxSessionsPerUser {
+			return nil, errTooManyUserSessions
+		}
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	s := &session{
+		ID: hex.EncodeToString(id),
+
+		Kind:    kind,
+		Context: contextName,
+		Path:    path,
+		Owner:   owner,
+
+		Started: time.Now(),
+
+		cancel: cancel,
+	}
+
+	s.touch()
+
+	if m.sessions == nil {
+		m.sessions = make(map[string]*session)
+	}
+
+	m.sessions[s.ID] = s
+
+	return s, nil
+}
+
+func (m *sessionManager) end(s *session) {
+	m.mu.Lock()
+	delete(m.sessions, s.ID)
+	m.mu.Unlock()
+
+	s.cancel(nil)
+}
+
+// kill terminates a session of an owner. Sessions of others are reported as
+// not found.
+func (m *sessionManager) kill(id, owner string) bool {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	m.mu.Unlock()
+
+	ok = ok && s.Owner == owner
+
+	if ok {
+		s.cancel(errSessionTerminated)
+	}
+
+	return ok
+}
+
+func (m *sessionManager) killContext(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if strings.EqualFold(s.Context, name) {
+			s.cancel(errContextReleased)
+		}
+	}
+}
+
+func (m *sessionManager) list() []*session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]*session, 0, len(m.sessions))
+
+	for _, s := range m.sessions {
+		result = append(result, s)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Started.Before(result[j].Started)
+	})
+
+	return result
+}
+
+func (m *sessionManager) reap(timeout time.Duration, done <-chan struct{}) {
+	if timeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(min(timeout, time.Minute))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+
+		case now := <-ticker.C:
+			for _, s := range m.list() {
+				if now.Sub(time.Unix(0, s.lastActivity.Load())) > timeout {
+					s.cancel(errSessionIdle)
+				}
+			}
+		}
+	}
+}
+
+func (s *session) touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (s *session) info() SessionInfo {
+	return SessionInfo{
+		ID: s.ID,
+
+		Kind:    s.Kind,
+		Context: s.Context,
+		Path:    s.Path,
+		Owner:   s.Owner,
+
+		Started:      s.Started,
+		LastActivity: time.Unix(0, s.lastActivity.Load()),
+
+		Bytes: s.bytes.Load(),
+	}
+}
+
+// sessionWriter records activity of a session, including streams that are
+// hijacked for protocol upgrades.
+type sessionWriter struct {
+	http.ResponseWriter
+
+	session *session
+}
+
+func (w *sessionWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+
+	w.session.touch()
+	w.session.bytes.Add(int64(n))
+
+	if w.session.recorder != nil {
+		w.session.recorder.write(p[:n], false)
+	}
+
+	return n, err
+}
+
+func (w *sessionWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *sessionWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &sessionConn{Conn: conn, session: w.session}, rw, nil
+}
+
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type sessionConn struct {
+	net.Conn
+
+	session *session
+}
+
+func (c *sessionConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.session.touch()
+
+	return n, err
+}
+
+func (c *sessionConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+
+	c.session.touch()
+	c.session.bytes.Add(int64(n))
+
+	if c.session.recorder != nil {
+		c.session.recorder.write(p[:n], true)
+	}
+
+	return n, err
+}
+
+// trackSession registers long running requests as sessions. The returned
+// writer and request must be used to serve the stream; done ends the session.
+func (s *Server) trackSession(w http.ResponseWriter, r *http.Request, c *Context, auth *config.AuthInfo) (http.ResponseWriter, *http.Request, func(), error) {
+	kind := streamKind(c.Type, r)
+
+	if kind == "" {
+		return w, r, func() {}, nil
+	}
+
+	ctx, cancel := context.WithCancelCause(r.Context())
+
+	session, err := s.sessions.start(s.config.Limits, kind, c.Name, r.URL.Path, ownerID(auth), cancel)
+
+	if err != nil {
+		cancel(nil)
+		return w, r, nil, err
+	}
+
+	// transcripts are opt-in, as sessions may print secrets
+	if format, ok := transcriptFormatOf(c.Type, kind, r); ok && s.config.Transcripts {
+		session.recorder = newTranscriptRecorder(format)
+	}
+
+	w = &sessionWriter{
+		ResponseWriter: w,
+		session:        session,
+	}
+
+	done := func() {
+		s.sessions.end(session)
+		s.saveTranscript(session)
+	}
+
+	return w, r.WithContext(ctx), done, nil
+}
+
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	owner := ownerID(AuthInfoFromContext(r.Context()))
+
+	sessions := s.sessions.list()
+
+	result := make([]SessionInfo, 0, len(sessions))
+
+	for _, s := range sessions {
+		// sessions of other callers are not listed
+		if s.Owner != owner {
+			continue
+		}
+
+		result = append(result, s.info())
+	}
+
+	writeList(w, r, "sessions", result, result)
+}
+
+func (s *Server) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	if !s.sessions.kill(r.PathValue("id"), ownerID(AuthInfoFromContext(r.Context()))) {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+func TestSessionLimits(t *testing.T) {
+	limits := config.LimitsConfig{
+		MaxSessions:        3,
+		MaxSessionsPerUser: 2,
+	}
+
+	var m sessionManager
+
+	start := func(owner string) error {
+		_, err := m.start(limits, "exec", "dev", "/api/v1/namespaces/default/pods/web/exec", owner, func(error) {})
+		return err
+	}
+
+	tests := []struct {
+		name  string
+		owner string
+		err   error
+	}{
+		{"alice", "alice", nil},
+		{"alice again", "alice", nil},
+		{"alice beyond her limit", "alice", errTooManyUserSessions},
+		{"bob", "bob", nil},
+		{"carol beyond the limit", "carol", errTooManySessions},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := start(tt.owner); !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSessionOwner(t *testing.T) {
+	s := &Server{
+		config: &config.Config{},
+	}
+
+	alice := &config.AuthInfo{Bearer: "a", User: "alice"}
+	bob := &config.AuthInfo{Bearer: "b", User: "bob"}
+
+	sessions := map[string]string{}
+	cancelled := map[string]error{}
+
+	for _, auth := range []*config.AuthInfo{alice, bob} {
+		session, err := s.sessions.start(s.config.Limits, "exec", "dev", "/", ownerID(auth), func(err error) {
+			cancelled[auth.User] = err
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sessions[auth.User] = session.ID
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", s.handleListSessions)
+	mux.HandleFunc("DELETE /sessions/{id}", s.handleDeleteSession)
+
+	request := func(auth *config.AuthInfo, method, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r = r.WithContext(context.WithValue(r.Context(), authInfoKey, auth))
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		return w
+	}
+
+	tests := []struct {
+		name   string
+		auth   *config.AuthInfo
+		list   []string
+		delete string
+		status int
+	}{
+		{"alice", alice, []string{sessions["alice"]}, sessions["alice"], http.StatusNoContent},
+		{"bob", bob, []string{sessions["bob"]}, sessions["alice"], http.StatusNotFound},
+		{"local user", nil, []string{}, sessions["bob"], http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(tt.auth, http.MethodGet, "/sessions")
+
+			var list []SessionInfo
+			json.Unmarshal(w.Body.Bytes(), &list)
+
+			ids := []string{}
+
+			for _, info := range list {
+				ids = append(ids, info.ID)
+			}
+
+			if len(ids) != len(tt.list) || (len(ids) > 0 && ids[0] != tt.list[0]) {
+				t.Fatalf("sessions = %v, want %v", ids, tt.list)
+			}
+
+			w = request(tt.auth, http.MethodDelete, "/sessions/"+tt.delete)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+
+	if !errors.Is(cancelled["alice"], errSessionTerminated) {
+		t.Error("expected the session of alice to be terminated by alice")
+	}
+
+	if _, ok := cancelled["bob"]; ok {
+		t.Error("expected the session of bob not to be terminated by others")
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+	"github.com/adrianliechti/bridge/pkg/store"
+)
+
+// StoreFile is the name of the local data store in the bridge data directory.
+const StoreFile = "bridge.db"
+
+const (
+	stateBucket = "state"
+	auditBucket = "audit"
+	trashBucket = "trash"
+
+	discoveryBucket = "discovery"
+
+	transcriptBucket = "transcripts"
+)
+
+var (
+	storeOnce sync.Once
+	storeDB   *store.Store
+	storeErr  error
+)
+
+// dataStore opens the encrypted local store on first use, so it is shared by
+// all servers of the process. Plain files of earlier versions are migrated.
+func dataStore() (*store.Store, error) {
+	storeOnce.Do(func() {
+		storeDB, storeErr = OpenStore()
+
+		if storeErr != nil {
+			return
+		}
+
+		migrateState(storeDB, config.DataDir())
+	})
+
+	return storeDB, storeErr
+}
+
+// OpenStore opens the local data store with the key of the OS keychain.
+func OpenStore() (*store.Store, error) {
+	dir := config.DataDir()
+
+	key, err := store.Key(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return store.Open(filepath.Join(dir, StoreFile), key)
+}
+
+// loadState reads a state value from the local store.
+// A missing value leaves v untouched.
+func loadState(name string, v any) error {
+	db, err := dataStore()
+
+	if err != nil {
+		return err
+	}
+
+	if err := db.Get(stateBucket, name, v); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// saveState writes a state value to the local store.
+func saveState(name string, v any) error {
+	db, err := dataStore()
+
+	if err != nil {
+		return err
+	}
+
+	return db.Put(stateBucket, name, v)
+}
+
+// migrateState moves the plain JSON files of earlier versions into the
+// store and removes them, so no data remains unencrypted.
+func migrateState(db *store.Store, dir string) {
+	for _, name := range []string{namespaceHistoryFile, monitorsFile} {
+		path := filepath.Join(dir, name)
+
+		data, err := os.ReadFile(path)
+
+		if err != nil {
+			continue
+		}
+
+		if err := db.Put(stateBucket, name, json.RawMessage(data)); err != nil {
+			log.Printf("failed to migrate %s: %v", path, err)
+			continue
+		}
+
+		os.Remove(path)
+	}
+
+	if err := migrateAudit(db, filepath.Join(dir, auditFile)); err != nil {
+		log.Printf("failed to migrate audit log: %v", err)
+	}
+
+	if err := migrateTrash(db, filepath.Join(dir, "trash")); err != nil {
+		log.Printf("failed to migrate trash: %v", err)
+	}
+
+	// discovery documents were cached in plain files, shared by all callers
+	os.RemoveAll(filepath.Join(dir, "cache", "discovery"))
+}
+
+func migrateAudit(db *store.Store, path string) error {
+	f, err := os.Open(path)
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	defer f.Close()
+
+	var entries []any
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 4*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		if !json.Valid(line) {
+			continue
+		}
+
+		entries = append(entries, json.RawMessage(append([]byte(nil), line...)))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if err := db.Append(auditBucket, entries...); err != nil {
+		return err
+	}
+
+	f.Close()
+
+	return os.Remove(path)
+}
+
+func migrateTrash(db *store.Store, dir string) error {
+	files, err := os.ReadDir(dir)
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, f := range files {
+		id, ok := strings.CutSuffix(f.Name(), ".json")
+
+		if !ok {
+			continue
+		}
+
+		path := filepath.Join(dir, f.Name())
+
+		data, err := os.ReadFile(path)
+
+		if err != nil || !json.Valid(data) {
+			continue
+		}
+
+		if err := db.Put(trashBucket, id, json.RawMessage(data)); err != nil {
+			return err
+		}
+
+		os.Remove(path)
+	}
+
+	os.Remove(dir)
+
+	return nil
+}
@@ -0,0 +1,838 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/adrianliechti/bridge/pkg/i18n"
+	"github.com/adrianliechti/bridge/pkg/store"
+)
+
+const (
+	// maxTranscriptLines caps a transcript; the oldest lines are dropped
+	maxTranscriptLines = 10000
+
+	// maxTranscriptLineLength wraps longer lines
+	maxTranscriptLineLength = 4096
+
+	// maxTranscriptMessage caps buffered WebSocket messages, larger ones are
+	// not recorded
+	maxTranscriptMessage = 1 << 20
+)
+
+var (
+	errTranscriptNotFound = i18n.NewError("error.transcript_not_found")
+	errTranscriptActive   = i18n.NewError("error.transcript_active")
+)
+
+// transcriptFormat is the framing of the output of a session on the wire.
+type transcriptFormat int
+
+const (
+	// transcriptPlain is a response body, e.g. followed Kubernetes logs
+	transcriptPlain transcriptFormat = iota
+
+	// transcriptDocker is a Docker stream, multiplexed by stdcopy headers
+	// unless the container has a TTY
+	transcriptDocker
+
+	// transcriptWebSocket are WebSocket messages of terminal output
+	transcriptWebSocket
+
+	// transcriptChannels are WebSocket messages of the channel.k8s.io
+	// protocols, prefixed by their stream
+	transcriptChannels
+)
+
+// transcriptFormatOf returns the format of exec and log sessions that can be
+// recorded. Exec over SPDY is not recorded.
+func transcriptFormatOf(contextType, kind string, r *http.Request) (transcriptFormat, bool) {
+	if kind != "exec" && kind != "logs" {
+		return 0, false
+	}
+
+	switch contextType {
+	case "kubernetes":
+		if kind == "logs" {
+			return transcriptPlain, true
+		}
+
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			return 0, false
+		}
+
+		if isNodeShellTerminal(r.URL.Path) {
+			return transcriptWebSocket, true
+		}
+
+		return transcriptChannels, true
+
+	case "docker":
+		if strings.HasSuffix(r.URL.Path, "/host/terminal") || strings.HasSuffix(r.URL.Path, "/attach/ws") {
+			return transcriptWebSocket, true
+		}
+
+		return transcriptDocker, true
+	}
+
+	return 0, false
+}
+
+// transcriptRecorder records the output of a session as plain text lines:
+// terminal escape sequences and control characters are removed, and lines
+// overwritten by carriage returns (progress bars) keep their final state.
+// Input is not recorded, as it may contain passwords typed without echo.
+type transcriptRecorder struct {
+	format transcriptFormat
+
+	mu sync.Mutex
+
+	lines     []TranscriptLine
+	truncated bool
+
+	streams map[string]*transcriptStream
+
+	websocket websocketDecoder
+	docker    dockerDecoder
+}
+
+// transcriptStream is the line of a stream that is being written.
+type transcriptStream struct {
+	line    []byte
+	started time.Time
+
+	escape escapeState
+	cr     bool
+}
+
+type escapeState int
+
+const (
+	escapeNone escapeState = iota
+	escapeStart
+	escapeCSI
+	escapeString
+	escapeStringEnd
+	escapeCharset
+)
+
+func newTranscriptRecorder(format transcriptFormat) *transcriptRecorder {
+	t := &transcriptRecorder{
+		format: format,
+
+		streams: map[string]*transcriptStream{},
+	}
+
+	t.websocket.emit = t.message
+	t.docker.emit = t.record
+
+	return t
+}
+
+// write records output of the session, which is hijacked for upgraded
+// streams. Failed upgrades answer with a regular body, which is skipped.
+func (t *transcriptRecorder) write(p []byte, hijacked bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch t.format {
+	case transcriptPlain:
+		t.record("stdout", p)
+
+	case transcriptDocker:
+		t.docker.write(p)
+
+	case transcriptWebSocket, transcriptChannels:
+		if hijacked {
+			t.websocket.write(p)
+		}
+	}
+}
+
+// message records a WebSocket message.
+func (t *transcriptRecorder) message(opcode byte, data []byte) {
+	if t.format == transcriptWebSocket {
+		t.record("stdout", data)
+		return
+	}
+
+	if len(data) == 0 {
+		return
+	}
+
+	channel := data[0]
+	data = data[1:]
+
+	// the base64.channel.k8s.io protocols send text messages with the
+	// channel as digit
+	if opcode == websocketText {
+		decoded, err := base64.StdEncoding.DecodeString(string(data))
+
+		if err != nil {
+			return
+		}
+
+		channel -= '0'
+		data = decoded
+	}
+
+	switch channel {
+	case 1:
+		t.record("stdout", data)
+
+	case 2:
+		t.record("stderr", data)
+
+	case 3:
+		// the status of the command once it ended
+		var status struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		}
+
+		if err := json.Unmarshal(data, &status); err != nil || status.Status == "Success" {
+			return
+		}
+
+		t.record("error", []byte(status.Message+"\n"))
+	}
+}
+
+func (t *transcriptRecorder) record(name string, p []byte) {
+	s, ok := t.streams[name]
+
+	if !ok {
+		s = &transcriptStream{}
+		t.streams[name] = s
+	}
+
+	for _, c := range p {
+		switch s.escape {
+		case escapeStart:
+			switch c {
+			case '[':
+				s.escape = escapeCSI
+			case ']', 'P', 'X', '^', '_':
+				s.escape = escapeString
+			case '(', ')', '*', '+':
+				s.escape = escapeCharset
+			default:
+				s.escape = escapeNone
+			}
+
+			continue
+
+		case escapeCSI:
+			if c >= 0x40 && c <= 0x7e {
+				s.escape = escapeNone
+			}
+
+			continue
+
+		case escapeString:
+			// strings end with BEL or ESC \
+			if c == 0x07 {
+				s.escape = escapeNone
+			} else if c == 0x1b {
+				s.escape = escapeStringEnd
+			}
+
+			continue
+
+		case escapeStringEnd, escapeCharset:
+			s.escape = escapeNone
+			continue
+		}
+
+		if s.cr && c != '\n' {
+			// a carriage return without newline overwrites the line
+			s.line = s.line[:0]
+		}
+
+		s.cr = false
+
+		switch {
+		case c == 0x1b:
+			s.escape = escapeStart
+
+		case c == '\n':
+			t.flush(name, s)
+
+		case c == '\r':
+			s.cr = true
+
+		case c == '\b':
+			if _, size := utf8.DecodeLastRune(s.line); size > 0 {
+				s.line = s.line[:len(s.line)-size]
+			}
+
+		case c < 0x20 && c != '\t', c == 0x7f:
+			// other control characters
+
+		default:
+			if len(s.line) == 0 {
+				s.started = time.Now()
+			}
+
+			s.line = append(s.line, c)
+
+			if len(s.line) >= maxTranscriptLineLength {
+				t.flush(name, s)
+			}
+		}
+	}
+}
+
+func (t *transcriptRecorder) flush(name string, s *transcriptStream) {
+	started := s.started
+
+	if len(s.line) == 0 {
+		started = time.Now()
+	}
+
+	t.lines = append(t.lines, TranscriptLine{
+		Time: started,
+
+		Stream: name,
+		Text:   strings.ToValidUTF8(string(s.line), "�"),
+	})
+
+	if len(t.lines) > maxTranscriptLines {
+		t.lines = t.lines[len(t.lines)-maxTranscriptLines:]
+		t.truncated = true
+	}
+
+	s.line = s.line[:0]
+}
+
+// snapshot returns the recorded lines, including lines not yet ended.
+func (t *transcriptRecorder) snapshot() ([]TranscriptLine, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := slices.Clone(t.lines)
+
+	for name, s := range t.streams {
+		if len(s.line) > 0 {
+			lines = append(lines, TranscriptLine{
+				Time: s.started,
+
+				Stream: name,
+				Text:   strings.ToValidUTF8(string(s.line), "�"),
+			})
+		}
+	}
+
+	slices.SortStableFunc(lines, func(a, b TranscriptLine) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	return lines, t.truncated
+}
+
+const websocketText = 1
+
+// websocketDecoder decodes the frames a server sends into messages.
+type websocketDecoder struct {
+	buf  []byte
+	skip int64
+
+	// handshake is set once the HTTP response of the upgrade was skipped
+	handshake bool
+
+	opcode  byte
+	message []byte
+
+	emit func(opcode byte, data []byte)
+}
+
+func (d *websocketDecoder) write(p []byte) {
+	if d.skip > 0 {
+		n := min(d.skip, int64(len(p)))
+
+		d.skip -= n
+		p = p[n:]
+	}
+
+	d.buf = append(d.buf, p...)
+
+	offset := 0
+
+	for {
+		n, ok := d.frame(d.buf[offset:])
+
+		if !ok {
+			break
+		}
+
+		offset += n
+	}
+
+	d.buf = d.buf[:copy(d.buf, d.buf[offset:])]
+}
+
+// frame decodes the next frame and returns its size, or false if the frame
+// is incomplete.
+func (d *websocketDecoder) frame(b []byte) (int, bool) {
+	if !d.handshake {
+		if len(b) < 5 {
+			return 0, false
+		}
+
+		d.handshake = true
+
+		// servers upgrading the connection themselves write their
+		// response to the hijacked connection
+		if bytes.HasPrefix(b, []byte("HTTP/")) {
+			i := bytes.Index(b, []byte("\r\n\r\n"))
+
+			if i < 0 {
+				d.handshake = false
+				return 0, false
+			}
+
+			return i + 4, true
+		}
+	}
+
+	if len(b) < 2 {
+		return 0, false
+	}
+
+	fin := b[0]&0x80 != 0
+	opcode := b[0] & 0x0f
+
+	masked := b[1]&0x80 != 0
+	length := int64(b[1] & 0x7f)
+
+	offset := 2
+
+	switch length {
+	case 126:
+		if len(b) < 4 {
+			return 0, false
+		}
+
+		length = int64(binary.BigEndian.Uint16(b[2:]))
+		offset = 4
+
+	case 127:
+		if len(b) < 10 {
+			return 0, false
+		}
+
+		length = int64(binary.BigEndian.Uint64(b[2:]) & (1<<63 - 1))
+		offset = 10
+	}
+
+	var mask []byte
+
+	if masked {
+		if len(b) < offset+4 {
+			return 0, false
+		}
+
+		mask = b[offset : offset+4]
+		offset += 4
+	}
+
+	if length > maxTranscriptMessage {
+		d.opcode = 0
+		d.message = nil
+
+		if available := int64(len(b) - offset); available < length {
+			d.skip = length - available
+			return len(b), true
+		}
+
+		return offset + int(length), true
+	}
+
+	if int64(len(b)-offset) < length {
+		return 0, false
+	}
+
+	payload := b[offset : offset+int(length)]
+
+	if opcode >= 8 {
+		// control frames
+		return offset + int(length), true
+	}
+
+	if opcode != 0 {
+		d.opcode = opcode
+		d.message = d.message[:0]
+	}
+
+	if d.opcode == 0 {
+		// the rest of a dropped message
+		return offset + int(length), true
+	}
+
+	start := len(d.message)
+	d.message = append(d.message, payload...)
+
+	if mask != nil {
+		for i := start; i < len(d.message); i++ {
+			d.message[i] ^= mask[(i-start)%4]
+		}
+	}
+
+	if len(d.message) > maxTranscriptMessage {
+		d.opcode = 0
+		d.message = nil
+	} else if fin {
+		d.emit(d.opcode, d.message)
+		d.opcode = 0
+	}
+
+	return offset + int(length), true
+}
+
+// dockerDecoder demultiplexes Docker streams of containers without TTY,
+// which prefix each chunk by an 8 byte header of stream and size.
+type dockerDecoder struct {
+	detected    bool
+	multiplexed bool
+
+	header    []byte
+	stream    string
+	remaining int
+
+	emit func(stream string, p []byte)
+}
+
+func (d *dockerDecoder) write(p []byte) {
+	if !d.detected && len(p) > 0 {
+		// TTY output hardly starts with one of the stream ids
+		d.detected = true
+		d.multiplexed = p[0] <= 3
+	}
+
+	if !d.multiplexed {
+		d.emit("stdout", p)
+		return
+	}
+
+	for len(p) > 0 {
+		if d.remaining > 0 {
+			n := min(d.remaining, len(p))
+
+			if d.stream != "" {
+				d.emit(d.stream, p[:n])
+			}
+
+			d.remaining -= n
+			p = p[n:]
+
+			continue
+		}
+
+		n := min(8-len(d.header), len(p))
+
+		d.header = append(d.header, p[:n]...)
+		p = p[n:]
+
+		if len(d.header) < 8 {
+			return
+		}
+
+		switch d.header[0] {
+		case 1:
+			d.stream = "stdout"
+		case 2:
+			d.stream = "stderr"
+		case 3:
+			d.stream = "error"
+		default:
+			d.stream = ""
+		}
+
+		d.remaining = int(binary.BigEndian.Uint32(d.header[4:]))
+		d.header = d.header[:0]
+	}
+}
+
+// transcripts keeps the transcripts of ended sessions in the local store for
+// the transcript retention.
+type transcripts struct {
+	retention time.Duration
+}
+
+func (t *transcripts) put(e *Transcript) error {
+	db, err := dataStore()
+
+	if err != nil {
+		return err
+	}
+
+	t.purge(db)
+
+	return db.Put(transcriptBucket, e.ID, e)
+}
+
+func (t *transcripts) get(id string) (*Transcript, error) {
+	db, err := dataStore()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var e Transcript
+
+	if err := db.Get(transcriptBucket, id, &e); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, os.ErrNotExist
+		}
+
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+func (t *transcripts) delete(id string) error {
+	db, err := dataStore()
+
+	if err != nil {
+		return err
+	}
+
+	return db.Delete(transcriptBucket, id)
+}
+
+func (t *transcripts) list() ([]Transcript, error) {
+	db, err := dataStore()
+
+	if err != nil {
+		return nil, err
+	}
+
+	t.purge(db)
+
+	var result []Transcript
+
+	err = db.Each(transcriptBucket, func(_ string, decode func(v any) error) error {
+		var e Transcript
+
+		if err := decode(&e); err != nil {
+			return nil
+		}
+
+		// the listing only carries the metadata
+		e.Lines = nil
+
+		result = append(result, e)
+
+		return nil
+	})
+
+	return result, err
+}
+
+// purge removes transcripts older than the retention.
+func (t *transcripts) purge(db *store.Store) {
+	if t.retention <= 0 {
+		return
+	}
+
+	db.DeleteFunc(transcriptBucket, func(_ string, decode func(v any) error) bool {
+		var e struct {
+			Ended *time.Time `json:"ended"`
+		}
+
+		if err := decode(&e); err != nil || e.Ended == nil {
+			return false
+		}
+
+		return time.Since(*e.Ended) > t.retention
+	})
+}
+
+// transcript returns the transcript of a session while it is running.
+func (s *session) transcript() *Transcript {
+	lines, truncated := s.recorder.snapshot()
+
+	return &Transcript{
+		ID: s.ID,
+
+		Kind:    s.Kind,
+		Context: s.Context,
+		Path:    s.Path,
+		Owner:   s.Owner,
+
+		Started: s.Started,
+
+		Truncated: truncated,
+
+		Lines: lines,
+	}
+}
+
+// saveTranscript stores the transcript of an ended session.
+func (s *Server) saveTranscript(session *session) {
+	if session.recorder == nil {
+		return
+	}
+
+	e := session.transcript()
+
+	if len(e.Lines) == 0 {
+		return
+	}
+
+	ended := time.Now()
+	e.Ended = &ended
+
+	if err := s.transcripts.put(e); err != nil {
+		log.Printf("failed to save transcript of session %s: %v", session.ID, err)
+	}
+}
+
+func (s *Server) handleListTranscripts(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.transcripts.list()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, session := range s.sessions.list() {
+		if session.recorder != nil {
+			e := session.transcript()
+			e.Lines = nil
+
+			entries = append(entries, *e)
+		}
+	}
+
+	owner := ownerID(AuthInfoFromContext(r.Context()))
+	context := r.URL.Query().Get("context")
+
+	result := []Transcript{}
+
+	for _, e := range entries {
+		if e.Owner != owner {
+			continue
+		}
+
+		if context != "" && !strings.EqualFold(e.Context, context) {
+			continue
+		}
+
+		result = append(result, e)
+	}
+
+	slices.SortFunc(result, func(a, b Transcript) int {
+		return b.Started.Compare(a.Started)
+	})
+
+	writeList(w, r, "transcripts", result, result)
+}
+
+// transcript returns the transcript of a running or ended session of the
+// caller.
+func (s *Server) transcript(w http.ResponseWriter, r *http.Request) (*Transcript, bool) {
+	id := r.PathValue("id")
+	owner := ownerID(AuthInfoFromContext(r.Context()))
+
+	for _, session := range s.sessions.list() {
+		if session.ID == id && session.recorder != nil && session.Owner == owner {
+			return session.transcript(), true
+		}
+	}
+
+	e, err := s.transcripts.get(id)
+
+	if err != nil || e.Owner != owner {
+		writeError(w, r, errTranscriptNotFound, http.StatusNotFound)
+		return nil, false
+	}
+
+	return e, true
+}
+
+// handleGetTranscript returns a transcript as plain text with a timestamp
+// per line, or as JSON with ?format=json. ?download=true serves it as an
+// attachment, e.g. for tickets.
+func (s *Server) handleGetTranscript(w http.ResponseWriter, r *http.Request) {
+	e, ok := s.transcript(w, r)
+
+	if !ok {
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	tag := i18n.Negotiate(r)
+
+	if r.URL.Query().Get("download") == "true" {
+		w.Header().Set("Content-Disposition", `attachment; filename="transcript-`+e.ID+`.txt"`)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Language", tag.String())
+
+	fmt.Fprintln(w, i18n.Translate(tag, "transcript.title", e.Kind, e.ID))
+	fmt.Fprintln(w, i18n.Translate(tag, "transcript.context", e.Context))
+	fmt.Fprintln(w, i18n.Translate(tag, "transcript.path", e.Path))
+	fmt.Fprintln(w, i18n.Translate(tag, "transcript.started", e.Started.UTC().Format(time.RFC3339)))
+
+	if e.Ended != nil {
+		fmt.Fprintln(w, i18n.Translate(tag, "transcript.ended", e.Ended.UTC().Format(time.RFC3339)))
+	} else {
+		fmt.Fprintln(w, i18n.Translate(tag, "transcript.active"))
+	}
+
+	if e.Truncated {
+		fmt.Fprintln(w, i18n.Translate(tag, "transcript.truncated", maxTranscriptLines))
+	}
+
+	fmt.Fprintln(w)
+
+	for _, line := range e.Lines {
+		prefix := line.Time.UTC().Format("2006-01-02T15:04:05.000Z")
+
+		if line.Stream != "stdout" {
+			prefix += " [" + line.Stream + "]"
+		}
+
+		fmt.Fprintln(w, prefix, line.Text)
+	}
+}
+
+func (s *Server) handleDeleteTranscript(w http.ResponseWriter, r *http.Request) {
+	e, ok := s.transcript(w, r)
+
+	if !ok {
+		return
+	}
+
+	if e.Ended == nil {
+		writeError(w, r, errTranscriptActive, http.StatusConflict)
+		return
+	}
+
+	if err := s.transcripts.delete(e.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,522 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+)
+
+const (
+	transportMaxIdleConns        = 100
+	transportMaxIdleConnsPerHost = 25
+	transportIdleConnTimeout     = 90 * time.Second
+
+	// pooled transports unused for this long are closed and dropped
+	transportIdleTimeout = 10 * time.Minute
+)
+
+// transportPool shares upstream transports across requests, so connections
+// (and TLS sessions) are reused instead of piling up per request.
+type transportPool struct {
+	mu      sync.Mutex
+	entries map[string]*pooledTransport
+
+	// builds are the transports being built, outside of the lock as they
+	// may dial (e.g. SSH tunnels of docker contexts)
+	builds map[string]*transportBuild
+}
+
+// transportBuild lets concurrent requests of a key wait for a single build.
+type transportBuild struct {
+	transport *pooledTransport
+	done      chan struct{}
+	err       error
+
+	// evicted builds are not pooled, as they may use a stale config
+	evicted bool
+}
+
+type pooledTransport struct {
+	key     string
+	context string
+
+	base      *http.Transport
+	transport http.RoundTripper
+
+	// upgrade carries protocol upgrades (SPDY, websockets), which are not
+	// possible over HTTP/2 connections
+	upgradeBase *http.Transport
+	upgrade     http.RoundTripper
+
+	target *url.URL
+	closer io.Closer
+
+	// observe is called with the result of every round trip
+	observe func(err error)
+
+	connsMu sync.Mutex
+	conns   map[*trackedConn]struct{}
+
+	created  time.Time
+	lastUsed atomic.Int64
+
+	requests    atomic.Int64
+	inflight    atomic.Int64
+	failures    atomic.Int64
+	connections atomic.Int64
+}
+
+func (p *transportPool) get(key, context string, build func(*pooledTransport) error) (*pooledTransport, error) {
+	for {
+		p.mu.Lock()
+
+		if t, ok := p.entries[key]; ok {
+			p.mu.Unlock()
+			return t, nil
+		}
+
+		if b, ok := p.builds[key]; ok {
+			p.mu.Unlock()
+
+			<-b.done
+
+			if b.err != nil {
+				return nil, b.err
+			}
+
+			// look up the pooled transport, or build it again if evicted
+			continue
+		}
+
+		t := &pooledTransport{
+			key:     key,
+			context: context,
+
+			created: time.Now(),
+		}
+
+		t.lastUsed.Store(t.created.UnixNano())
+
+		b := &transportBuild{
+			transport: t,
+			done:      make(chan struct{}),
+		}
+
+		if p.builds == nil {
+			p.builds = make(map[string]*transportBuild)
+		}
+
+		p.builds[key] = b
+
+		p.mu.Unlock()
+
+		err := build(t)
+
+		p.mu.Lock()
+
+		delete(p.builds, key)
+
+		evicted := b.evicted
+
+		if err == nil && !evicted {
+			if p.entries == nil {
+				p.entries = make(map[string]*pooledTransport)
+			}
+
+			p.entries[key] = t
+		}
+
+		b.err = err
+		close(b.done)
+
+		p.mu.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if evicted {
+			t.close()
+			continue
+		}
+
+		return t, nil
+	}
+}
+
+// evict drops and closes all entries matching the filter.
+func (p *transportPool) evict(filter func(t *pooledTransport) bool) {
+	p.mu.Lock()
+
+	var evicted []*pooledTransport
+
+	for key, t := range p.entries {
+		if filter(t) {
+			evicted = append(evicted, t)
+			delete(p.entries, key)
+		}
+	}
+
+	for _, b := range p.builds {
+		if filter(b.transport) {
+			b.evicted = true
+		}
+	}
+
+	p.mu.Unlock()
+
+	for _, t := range evicted {
+		t.close()
+	}
+}
+
+// reset drops all entries and also closes connections of running requests,
+// which hang silently after a sleep or network change.
+func (p *transportPool) reset() {
+	p.mu.Lock()
+
+	entries := p.entries
+	p.entries = nil
+
+	for _, b := range p.builds {
+		b.evicted = true
+	}
+
+	p.mu.Unlock()
+
+	for _, t := range entries {
+		t.close()
+		t.closeConns()
+	}
+}
+
+func (p *transportPool) evictContext(context string) {
+	p.evict(func(t *pooledTransport) bool {
+		return strings.EqualFold(t.context, context)
+	})
+}
+
+func (p *transportPool) evictPrefix(prefix string) {
+	p.evict(func(t *pooledTransport) bool {
+		return strings.HasPrefix(t.key, prefix)
+	})
+}
+
+func (p *transportPool) reap(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			p.evict(func(*pooledTransport) bool { return true })
+			return
+
+		case now := <-ticker.C:
+			p.evict(func(t *pooledTransport) bool {
+				idle := now.Sub(time.Unix(0, t.lastUsed.Load()))
+				return t.inflight.Load() == 0 && idle > transportIdleTimeout
+			})
+		}
+	}
+}
+
+func (t *pooledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	t.inflight.Add(1)
+	t.lastUsed.Store(time.Now().UnixNano())
+
+	rt := t.transport
+
+	if t.upgrade != nil && httpstream.IsUpgradeRequest(req) {
+		rt = t.upgrade
+	}
+
+	resp, err := rt.RoundTrip(req)
+
+	if t.observe != nil {
+		t.observe(err)
+	}
+
+	if err != nil {
+		t.failures.Add(1)
+		t.inflight.Add(-1)
+
+		return nil, err
+	}
+
+	done := func() {
+		t.inflight.Add(-1)
+		t.lastUsed.Store(time.Now().UnixNano())
+	}
+
+	// long running responses (watches, logs, upgraded streams) count as
+	// in-flight until closed
+	if rwc, ok := resp.Body.(io.ReadWriteCloser); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+		resp.Body = &trackedStream{ReadWriteCloser: rwc, done: done}
+	} else {
+		resp.Body = &trackedBody{ReadCloser: resp.Body, done: done}
+	}
+
+	return resp, nil
+}
+
+func (t *pooledTransport) dialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		t.connections.Add(1)
+
+		tc := &trackedConn{
+			Conn: conn,
+		}
+
+		tc.done = func() {
+			t.connections.Add(-1)
+
+			t.connsMu.Lock()
+			delete(t.conns, tc)
+			t.connsMu.Unlock()
+		}
+
+		t.connsMu.Lock()
+
+		if t.conns == nil {
+			t.conns = make(map[*trackedConn]struct{})
+		}
+
+		t.conns[tc] = struct{}{}
+		t.connsMu.Unlock()
+
+		return tc, nil
+	}
+}
+
+func (t *pooledTransport) close() {
+	if t.base != nil {
+		t.base.CloseIdleConnections()
+	}
+
+	if t.upgradeBase != nil {
+		t.upgradeBase.CloseIdleConnections()
+	}
+
+	if t.closer != nil {
+		t.closer.Close()
+	}
+}
+
+func (t *pooledTransport) closeConns() {
+	t.connsMu.Lock()
+
+	conns := make([]*trackedConn, 0, len(t.conns))
+
+	for c := range t.conns {
+		conns = append(conns, c)
+	}
+
+	t.connsMu.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+func (t *pooledTransport) stats() TransportStats {
+	return TransportStats{
+		Context: t.context,
+
+		Created:  t.created,
+		LastUsed: time.Unix(0, t.lastUsed.Load()),
+
+		Requests:    t.requests.Load(),
+		InFlight:    t.inflight.Load(),
+		Failures:    t.failures.Load(),
+		Connections: t.connections.Load(),
+	}
+}
+
+// newTransport returns a tuned HTTP transport, optionally with HTTP/2 enabled
+// where the upstream supports it.
+func newTransport(t *pooledTransport, dial func(ctx context.Context, network, addr string) (net.Conn, error), tlsConfig *tls.Config, http2 bool) *http.Transport {
+	if dial == nil {
+		dial = (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+
+	if !http2 && tlsConfig != nil {
+		// the config may be shared with an HTTP/2 transport, which adds h2
+		// to the negotiated protocols
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.NextProtos = []string{"http/1.1"}
+	}
+
+	tr := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+
+		DialContext:     t.dialer(dial),
+		TLSClientConfig: tlsConfig,
+
+		MaxIdleConns:        transportMaxIdleConns,
+		MaxIdleConnsPerHost: transportMaxIdleConnsPerHost,
+		IdleConnTimeout:     transportIdleConnTimeout,
+
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+
+	if !http2 {
+		// a non-nil, empty map disables HTTP/2
+		tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		return tr
+	}
+
+	tr.ForceAttemptHTTP2 = true
+
+	return utilnet.SetTransportDefaults(tr)
+}
+
+// buildKubernetesTransport mirrors client-go's transport setup (TLS, exec
+// plugins, auth wrappers) on top of a pooled, tuned base transport.
+func buildKubernetesTransport(t *pooledTransport, config *rest.Config) error {
+	tc, err := config.TransportConfig()
+
+	if err != nil {
+		return err
+	}
+
+	target, path, err := rest.DefaultServerUrlFor(config)
+
+	if err != nil {
+		return err
+	}
+
+	// servers behind a proxy keep the path prefix of the host
+	target.Path = strings.TrimSuffix(target.Path, "/") + path
+	t.target = target
+
+	if tc.Transport != nil {
+		rt, err := transport.HTTPWrappersForConfig(tc, tc.Transport)
+
+		if err != nil {
+			return err
+		}
+
+		t.transport = rt
+		return nil
+	}
+
+	tlsConfig, err := transport.TLSConfigFor(tc)
+
+	if err != nil {
+		return err
+	}
+
+	var dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	if tc.DialHolder != nil {
+		dial = tc.DialHolder.Dial
+	}
+
+	base := newTransport(t, dial, tlsConfig, true)
+	base.DisableCompression = tc.DisableCompression
+
+	upgradeBase := newTransport(t, dial, tlsConfig, false)
+
+	if tc.Proxy != nil {
+		base.Proxy = tc.Proxy
+		upgradeBase.Proxy = tc.Proxy
+	}
+
+	rt, err := transport.HTTPWrappersForConfig(tc, base)
+
+	if err != nil {
+		return err
+	}
+
+	upgrade, err := transport.HTTPWrappersForConfig(tc, upgradeBase)
+
+	if err != nil {
+		return err
+	}
+
+	t.base = base
+	t.transport = rt
+
+	t.upgradeBase = upgradeBase
+	t.upgrade = upgrade
+
+	return nil
+}
+
+func (s *Server) handleTransportStats(w http.ResponseWriter, r *http.Request) {
+	s.transports.mu.Lock()
+
+	result := make([]TransportStats, 0, len(s.transports.entries))
+
+	for _, t := range s.transports.entries {
+		result = append(result, t.stats())
+	}
+
+	s.transports.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Context < result[j].Context
+	})
+
+	writeList(w, r, "transports", result, result)
+}
+
+type trackedBody struct {
+	io.ReadCloser
+
+	once sync.Once
+	done func()
+}
+
+func (b *trackedBody) Close() error {
+	b.once.Do(b.done)
+	return b.ReadCloser.Close()
+}
+
+type trackedStream struct {
+	io.ReadWriteCloser
+
+	once sync.Once
+	done func()
+}
+
+func (s *trackedStream) Close() error {
+	s.once.Do(s.done)
+	return s.ReadWriteCloser.Close()
+}
+
+type trackedConn struct {
+	net.Conn
+
+	once sync.Once
+	done func()
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.done)
+	return c.Conn.Close()
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransportPoolReuse(t *testing.T) {
+	var p transportPool
+
+	var builds atomic.Int64
+
+	build := func(*pooledTransport) error {
+		builds.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	}
+
+	var wg sync.WaitGroup
+
+	results := make([]*pooledTransport, 8)
+
+	for i := range results {
+		wg.Go(func() {
+			tr, err := p.get("kubernetes/dev", "dev", build)
+
+			if err != nil {
+				t.Error(err)
+			}
+
+			results[i] = tr
+		})
+	}
+
+	wg.Wait()
+
+	if n := builds.Load(); n != 1 {
+		t.Fatalf("built %d transports, want 1", n)
+	}
+
+	for _, tr := range results {
+		if tr != results[0] {
+			t.Fatal("expected concurrent requests to share the transport")
+		}
+	}
+}
+
+func TestTransportPoolSlowBuild(t *testing.T) {
+	var p transportPool
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	go p.get("docker/remote", "remote", func(*pooledTransport) error {
+		close(started)
+		<-release
+		return nil
+	})
+
+	<-started
+	defer close(release)
+
+	done := make(chan error)
+
+	go func() {
+		_, err := p.get("kubernetes/dev", "dev", func(*pooledTransport) error { return nil })
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+
+	case <-time.After(time.Second):
+		t.Fatal("a slow build blocked the transports of other contexts")
+	}
+}
+
+func TestTransportPoolErrors(t *testing.T) {
+	var p transportPool
+
+	errUnreachable := errors.New("unreachable")
+
+	if _, err := p.get("docker/remote", "remote", func(*pooledTransport) error { return errUnreachable }); !errors.Is(err, errUnreachable) {
+		t.Fatalf("error = %v, want %v", err, errUnreachable)
+	}
+
+	// failed builds are not pooled
+	tr, err := p.get("docker/remote", "remote", func(*pooledTransport) error { return nil })
+
+	if err != nil || tr == nil {
+		t.Fatalf("expected the transport to be built again, got %v", err)
+	}
+}
+
+func TestTransportPoolEvictDuringBuild(t *testing.T) {
+	var p transportPool
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+
+	var builds atomic.Int64
+
+	build := func(*pooledTransport) error {
+		if builds.Add(1) == 1 {
+			started <- struct{}{}
+			<-release
+		}
+
+		return nil
+	}
+
+	result := make(chan *pooledTransport)
+
+	go func() {
+		tr, _ := p.get("kubernetes/dev", "dev", build)
+		result <- tr
+	}()
+
+	<-started
+
+	// e.g. a kubeconfig reload while the first transport is built
+	p.evictContext("dev")
+	close(release)
+
+	tr := <-result
+
+	if n := builds.Load(); n != 2 {
+		t.Fatalf("built %d transports, want 2", n)
+	}
+
+	pooled, _ := p.get("kubernetes/dev", "dev", build)
+
+	if pooled != tr || builds.Load() != 2 {
+		t.Fatal("expected the transport built after the eviction to be pooled")
+	}
+
+	tests := []struct {
+		name   string
+		evict  func()
+		pooled bool
+	}{
+		{"other context", func() { p.evictContext("prod") }, true},
+		{"context", func() { p.evictContext("dev") }, false},
+		{"prefix", func() { p.evictPrefix("kubernetes/") }, false},
+		{"reset", p.reset, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.get("kubernetes/dev", "dev", build)
+
+			tt.evict()
+
+			p.mu.Lock()
+			_, ok := p.entries["kubernetes/dev"]
+			p.mu.Unlock()
+
+			if ok != tt.pooled {
+				t.Fatalf("pooled = %v, want %v", ok, tt.pooled)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+// tunnelStartTimeout bounds the start of a tunnel until its local port
+// accepts connections.
+const tunnelStartTimeout = 30 * time.Second
+
+// cloudTunnels holds the cloud tunnels of contexts, started on first use.
+type cloudTunnels struct {
+	mu    sync.Mutex
+	items map[string]*cloudTunnel
+}
+
+func (c *cloudTunnels) get(key string, create func() *cloudTunnel) *cloudTunnel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t, ok := c.items[key]; ok {
+		return t
+	}
+
+	if c.items == nil {
+		c.items = make(map[string]*cloudTunnel)
+	}
+
+	t := create()
+	c.items[key] = t
+
+	return t
+}
+
+func (c *cloudTunnels) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.items, key)
+}
+
+func (c *cloudTunnels) list() []*cloudTunnel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make([]*cloudTunnel, 0, len(c.items))
+
+	for _, t := range c.items {
+		result = append(result, t)
+	}
+
+	return result
+}
+
+// cloudTunnel forwards a local port to a private endpoint with the aws or
+// gcloud CLI. The CLI is started on first use and again after it exited,
+// e.g. once the session timed out.
+type cloudTunnel struct {
+	config *config.TunnelConfig
+
+	kind    string
+	context string
+
+	mu      sync.Mutex
+	closed  bool
+	remote  string
+	local   string
+	cmd     *exec.Cmd
+	exited  chan struct{}
+	started time.Time
+
+	onClose func()
+}
+
+func (t *cloudTunnel) info() TunnelInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	info := TunnelInfo{
+		Kind:    t.kind,
+		Context: t.context,
+
+		Provider: t.config.Provider,
+		Target:   t.config.Target,
+		Remote:   t.remote,
+
+		LocalAddress: t.local,
+	}
+
+	if t.running() {
+		info.Running = true
+		info.Started = &t.started
+	}
+
+	return info
+}
+
+func (t *cloudTunnel) running() bool {
+	if t.exited == nil {
+		return false
+	}
+
+	select {
+	case <-t.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// DialContext connects to the remote endpoint through the tunnel, which is
+// the dialed address unless configured otherwise. TLS still verifies the
+// name of the dialed address.
+func (t *cloudTunnel) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	t.mu.Lock()
+
+	if t.closed {
+		t.mu.Unlock()
+		return nil, errors.New("tunnel closed")
+	}
+
+	if !t.running() {
+		if err := t.start(address); err != nil {
+			t.mu.Unlock()
+			return nil, fmt.Errorf("failed to start %s tunnel to %s: %w", t.config.Provider, t.config.Target, err)
+		}
+	}
+
+	local := t.local
+
+	t.mu.Unlock()
+
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", local)
+}
+
+func (t *cloudTunnel) start(address string) error {
+	host, port, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return err
+	}
+
+	if t.config.Host != "" {
+		host = t.config.Host
+	}
+
+	if t.config.Port != 0 {
+		port = strconv.Itoa(t.config.Port)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return err
+	}
+
+	local := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	var cmd *exec.Cmd
+
+	switch t.config.Provider {
+	case "ssm":
+		parameters, _ := json.Marshal(map[string][]string{
+			"host":            {host},
+			"portNumber":      {port},
+			"localPortNumber": {strconv.Itoa(local)},
+		})
+
+		args := []string{"ssm", "start-session", "--target", t.config.Target, "--document-name", "AWS-StartPortForwardingSessionToRemoteHost", "--parameters", string(parameters)}
+
+		if t.config.Region != "" {
+			args = append(args, "--region", t.config.Region)
+		}
+
+		if t.config.Profile != "" {
+			args = append(args, "--profile", t.config.Profile)
+		}
+
+		cmd = exec.Command("aws", args...)
+
+	case "iap":
+		args := []string{"compute", "start-iap-tunnel", t.config.Target, port, "--local-host-port=127.0.0.1:" + strconv.Itoa(local), "--zone", t.config.Zone}
+
+		if t.config.Project != "" {
+			args = append(args, "--project", t.config.Project)
+		}
+
+		cmd = exec.Command("gcloud", args...)
+
+	default:
+		return fmt.Errorf("unsupported tunnel provider %q", t.config.Provider)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan struct{})
+
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	t.cmd = cmd
+	t.exited = exited
+	t.remote = net.JoinHostPort(host, port)
+	t.local = net.JoinHostPort("127.0.0.1", strconv.Itoa(local))
+	t.started = time.Now()
+
+	deadline := time.After(tunnelStartTimeout)
+
+	for {
+		if conn, err := net.DialTimeout("tcp", t.local, time.Second); err == nil {
+			conn.Close()
+
+			log.Printf("tunnel: %s to %s via %s started on %s", t.config.Provider, t.remote, t.config.Target, t.local)
+			return nil
+		}
+
+		select {
+		case <-exited:
+			if message := strings.TrimSpace(stderr.String()); message != "" {
+				return errors.New(message)
+			}
+
+			return errors.New("tunnel exited")
+
+		case <-deadline:
+			cmd.Process.Kill()
+			return errors.New("timed out waiting for the tunnel")
+
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// Close stops the tunnel.
+func (t *cloudTunnel) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+
+	t.closed = true
+
+	if t.running() {
+		t.cmd.Process.Kill()
+	}
+
+	if t.onClose != nil {
+		t.onClose()
+	}
+
+	return nil
+}
+
+// tunnelDialer returns the dialer of the cloud tunnel configured for a
+// context. The tunnel is bound to the context, so it stops once the context
+// is removed.
+func (s *Server) tunnelDialer(kind, name string) (func(ctx context.Context, network, address string) (net.Conn, error), bool) {
+	tunnel, ok := s.config.Tunnel(name)
+
+	if !ok {
+		return nil, false
+	}
+
+	key := kind + "/" + strings.ToLower(name)
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		t := s.tunnels.get(key, func() *cloudTunnel {
+			t := &cloudTunnel{
+				config: tunnel,
+
+				kind:    kind,
+				context: name,
+			}
+
+			t.onClose = func() {
+				s.tunnels.remove(key)
+			}
+
+			s.track(name, t)
+
+			return t
+		})
+
+		return t.DialContext(ctx, network, address)
+	}, true
+}
+
+func (s *Server) handleListTunnels(w http.ResponseWriter, r *http.Request) {
+	result := []TunnelInfo{}
+
+	for _, t := range s.tunnels.list() {
+		result = append(result, t.info())
+	}
+
+	slices.SortFunc(result, func(a, b TunnelInfo) int {
+		return strings.Compare(a.Kind+"/"+a.Context, b.Kind+"/"+b.Context)
+	})
+
+	writeList(w, r, "tunnels", result, result)
+}
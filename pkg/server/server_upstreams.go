@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrianliechti/bridge/pkg/config"
+)
+
+const (
+	// upstreamSyncInterval is the interval in which the contexts of
+	// upstream bridges are synced
+	upstreamSyncInterval = 30 * time.Second
+
+	// upstreamSyncTimeout bounds the sync of an upstream
+	upstreamSyncTimeout = 15 * time.Second
+)
+
+// upstreams keeps the state of the last sync of the upstream bridges.
+type upstreams struct {
+	mu    sync.Mutex
+	items map[string]*UpstreamInfo
+}
+
+func (u *upstreams) set(info *UpstreamInfo) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.items == nil {
+		u.items = make(map[string]*UpstreamInfo)
+	}
+
+	u.items[strings.ToLower(info.Name)] = info
+}
+
+func (u *upstreams) list() []UpstreamInfo {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	result := []UpstreamInfo{}
+
+	for _, info := range u.items {
+		result = append(result, *info)
+	}
+
+	slices.SortFunc(result, func(a, b UpstreamInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return result
+}
+
+// syncUpstreams federates the Kubernetes contexts of the upstream bridges:
+// contexts added upstream are registered, removed ones are unregistered.
+// Contexts of unreachable upstreams stay in place.
+func (s *Server) syncUpstreams(done <-chan struct{}) {
+	if len(s.config.Upstreams) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(upstreamSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		var wg sync.WaitGroup
+
+		for i := range s.config.Upstreams {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				ctx, cancel := context.WithTimeout(context.Background(), upstreamSyncTimeout)
+				defer cancel()
+
+				s.syncUpstream(ctx, &s.config.Upstreams[i])
+			}()
+		}
+
+		wg.Wait()
+
+		select {
+		case <-done:
+			return
+
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) syncUpstream(ctx context.Context, u *config.UpstreamConfig) {
+	info := &UpstreamInfo{
+		Name: u.Name,
+		URL:  u.URL,
+
+		Synced: time.Now(),
+	}
+
+	defer s.upstreams.set(info)
+
+	registered := s.upstreamContexts(u.Name)
+
+	remote, err := fetchUpstreamContexts(ctx, u)
+
+	if err != nil {
+		info.Contexts = registered
+		info.Error = err.Error()
+
+		return
+	}
+
+	info.Contexts = []string{}
+
+	for _, name := range remote {
+		local := config.FederatedContextName(u.Name, name)
+
+		if slices.Contains(registered, local) {
+			info.Contexts = append(info.Contexts, local)
+			continue
+		}
+
+		if err := s.AddKubernetesContext(config.KubernetesContextFromUpstream(u, name)); err != nil {
+			if errors.Is(err, errContextExists) {
+				log.Printf("context %q of upstream %q is shadowed by a local context", name, u.Name)
+				continue
+			}
+
+			info.Error = err.Error()
+			continue
+		}
+
+		info.Contexts = append(info.Contexts, local)
+
+		log.Printf("context %q of upstream %q was federated", name, u.Name)
+	}
+
+	for _, local := range registered {
+		if slices.Contains(info.Contexts, local) {
+			continue
+		}
+
+		if s.RemoveKubernetesContext(local) {
+			log.Printf("context %q was removed from upstream %q", local, u.Name)
+		}
+	}
+}
+
+// upstreamContexts returns the local names of the contexts federated from an
+// upstream.
+func (s *Server) upstreamContexts(upstream string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []string{}
+
+	if s.config.Kubernetes == nil {
+		return result
+	}
+
+	for _, c := range s.config.Kubernetes.Contexts {
+		if c.Dynamic && strings.EqualFold(c.Upstream, upstream) {
+			result = append(result, c.Name)
+		}
+	}
+
+	return result
+}
+
+// fetchUpstreamContexts reads the Kubernetes contexts from the config of an
+// upstream bridge.
+func fetchUpstreamContexts(ctx context.Context, u *config.UpstreamConfig) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.URL+"/config.json", nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+u.Token)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: u.InsecureSkipTLSVerify,
+			},
+		},
+
+		// logins are not followed
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("upstream returned %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	var result Config
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid config of upstream: %w", err)
+	}
+
+	if result.Kubernetes == nil {
+		return nil, nil
+	}
+
+	return result.Kubernetes.Contexts, nil
+}
+
+func (s *Server) handleListUpstreams(w http.ResponseWriter, r *http.Request) {
+	result := s.upstreams.list()
+
+	writeList(w, r, "upstreams", result, result)
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/zalando/go-keyring"
+)
+
+const (
+	keyringService = "bridge"
+	keyringUser    = "store"
+
+	keyFile = "store.key"
+)
+
+// Key returns the key of the store. It is kept in the OS keychain (macOS
+// Keychain, Windows Credential Manager, Secret Service on Linux) and created
+// on first use. BRIDGE_STORE_KEY overrides it with a base64 encoded key.
+//
+// Where no keychain is available (e.g. headless servers) the key is kept in
+// a file next to the store instead, if the file exists already or with
+// BRIDGE_STORE_KEY_FILE=true (implied in a pod). Otherwise keychain errors
+// fail, as they may be transient (e.g. a locked keychain at login) and data
+// sealed with the key of the keychain would not be readable with a new one.
+func Key(dir string) ([]byte, error) {
+	if v := os.Getenv("BRIDGE_STORE_KEY"); v != "" {
+		return decodeKey(v)
+	}
+
+	path := filepath.Join(dir, keyFile)
+
+	v, err := keyring.Get(keyringService, keyringUser)
+
+	if err == nil {
+		return decodeKey(v)
+	}
+
+	if !errors.Is(err, keyring.ErrNotFound) {
+		if _, statErr := os.Stat(path); statErr != nil && !fileKeyAllowed() {
+			return nil, fmt.Errorf("OS keychain not available, unlock it or set BRIDGE_STORE_KEY_FILE=true to keep the store key in %s: %w", path, err)
+		}
+
+		log.Printf("OS keychain not available, keeping the store key in %s: %v", path, err)
+		return fileKey(path)
+	}
+
+	// a key file of an earlier run without keychain is moved to the keychain
+	key, err := fileKey(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := keyring.Set(keyringService, keyringUser, base64.StdEncoding.EncodeToString(key)); err != nil {
+		log.Printf("failed to save the store key in the OS keychain, keeping it in %s: %v", path, err)
+		return key, nil
+	}
+
+	os.Remove(path)
+
+	return key, nil
+}
+
+// DeleteKey removes the key from the OS keychain and the key file.
+func DeleteKey(dir string) error {
+	if err := keyring.Delete(keyringService, keyringUser); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		log.Printf("failed to delete the store key from the OS keychain: %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, keyFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// fileKeyAllowed reports whether a new key may be kept in a file if the
+// keychain is not available: if opted in, or in a pod, which has none.
+func fileKeyAllowed() bool {
+	if v, err := strconv.ParseBool(os.Getenv("BRIDGE_STORE_KEY_FILE")); err == nil {
+		return v
+	}
+
+	return os.Getenv("KUBERNETES_SERVICE_HOST") != ""
+}
+
+// fileKey reads the key file, or creates it with a new key.
+func fileKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+
+	if err == nil {
+		return decodeKey(string(data))
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	key := newKey()
+
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)), 0600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func newKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	return key
+}
+
+func decodeKey(v string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(v)
+
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("invalid store key, expected 32 base64 encoded bytes")
+	}
+
+	return key, nil
+}
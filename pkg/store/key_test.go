@@ -0,0 +1,140 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zalando/go-keyring"
+)
+
+func TestKey(t *testing.T) {
+	t.Setenv("BRIDGE_STORE_KEY", "")
+	t.Setenv("BRIDGE_STORE_KEY_FILE", "")
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	errLocked := errors.New("secret service locked")
+
+	t.Run("keychain", func(t *testing.T) {
+		keyring.MockInit()
+		dir := t.TempDir()
+
+		key, err := Key(dir)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		again, err := Key(dir)
+
+		if err != nil || !bytes.Equal(key, again) {
+			t.Fatalf("expected the key of the keychain to be reused, got %v", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, keyFile)); !errors.Is(err, os.ErrNotExist) {
+			t.Error("expected no key file with a keychain")
+		}
+	})
+
+	t.Run("key file moved to the keychain", func(t *testing.T) {
+		keyring.MockInit()
+		dir := t.TempDir()
+
+		want := writeKeyFile(t, dir)
+
+		key, err := Key(dir)
+
+		if err != nil || !bytes.Equal(key, want) {
+			t.Fatalf("expected the key of the file, got %v", err)
+		}
+
+		if v, _ := keyring.Get(keyringService, keyringUser); v != base64.StdEncoding.EncodeToString(want) {
+			t.Error("expected the key to be moved to the keychain")
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, keyFile)); !errors.Is(err, os.ErrNotExist) {
+			t.Error("expected the key file to be removed")
+		}
+	})
+
+	t.Run("unavailable keychain", func(t *testing.T) {
+		keyring.MockInitWithError(errLocked)
+		dir := t.TempDir()
+
+		if _, err := Key(dir); !errors.Is(err, errLocked) {
+			t.Fatalf("error = %v, want %v", err, errLocked)
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, keyFile)); !errors.Is(err, os.ErrNotExist) {
+			t.Error("expected no key file to be created")
+		}
+	})
+
+	t.Run("unavailable keychain with key file", func(t *testing.T) {
+		keyring.MockInitWithError(errLocked)
+		dir := t.TempDir()
+
+		want := writeKeyFile(t, dir)
+
+		if key, err := Key(dir); err != nil || !bytes.Equal(key, want) {
+			t.Fatalf("expected the key of the file, got %v", err)
+		}
+	})
+
+	t.Run("unavailable keychain opted in", func(t *testing.T) {
+		keyring.MockInitWithError(errLocked)
+		t.Setenv("BRIDGE_STORE_KEY_FILE", "true")
+		dir := t.TempDir()
+
+		key, err := Key(dir)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if again, err := Key(dir); err != nil || !bytes.Equal(key, again) {
+			t.Fatalf("expected the key file to be reused, got %v", err)
+		}
+	})
+
+	t.Run("unavailable keychain in a pod", func(t *testing.T) {
+		keyring.MockInitWithError(errLocked)
+		t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+
+		if _, err := Key(t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("environment", func(t *testing.T) {
+		keyring.MockInitWithError(errLocked)
+
+		want := newKey()
+		t.Setenv("BRIDGE_STORE_KEY", base64.StdEncoding.EncodeToString(want))
+
+		if key, err := Key(t.TempDir()); err != nil || !bytes.Equal(key, want) {
+			t.Fatalf("expected the key of the environment, got %v", err)
+		}
+
+		t.Setenv("BRIDGE_STORE_KEY", "short")
+
+		if _, err := Key(t.TempDir()); err == nil {
+			t.Fatal("expected an invalid key to be rejected")
+		}
+	})
+}
+
+func writeKeyFile(t *testing.T, dir string) []byte {
+	t.Helper()
+
+	key := newKey()
+
+	if err := os.WriteFile(filepath.Join(dir, keyFile), []byte(base64.StdEncoding.EncodeToString(key)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
@@ -0,0 +1,363 @@
+// Package store is the local data store of the bridge. Values are kept in a
+// single bbolt database and encrypted at rest with AES-GCM.
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Store is an encrypted bucket/key/value store.
+type Store struct {
+	db   *bolt.DB
+	aead cipher.AEAD
+}
+
+// Open opens (or creates) the store at path with a 32 byte key.
+func Open(path string, key []byte) (*Store, error) {
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openDB(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		db:   db,
+		aead: aead,
+	}, nil
+}
+
+func openDB(path string) (*bolt.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("store %s is in use by another bridge process", path)
+	}
+
+	return db, err
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Get decodes the value of a key into v. It returns ErrNotFound if the key
+// does not exist.
+func (s *Store) Get(bucket, key string, v any) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+
+		if b == nil {
+			return ErrNotFound
+		}
+
+		data := b.Get([]byte(key))
+
+		if data == nil {
+			return ErrNotFound
+		}
+
+		return s.decode(bucket, []byte(key), data, v)
+	})
+}
+
+// Put stores v as the value of a key.
+func (s *Store) Put(bucket, key string, v any) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.put(tx, bucket, []byte(key), v)
+	})
+}
+
+func (s *Store) Delete(bucket, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+
+		if b == nil {
+			return nil
+		}
+
+		return b.Delete([]byte(key))
+	})
+}
+
+// Append stores values under the next sequence numbers of a bucket, so
+// entries are kept in insertion order (e.g. for logs).
+func (s *Store) Append(bucket string, values ...any) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, v := range values {
+			if err := s.append(tx, bucket, v); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Each calls fn for all keys of a bucket in key order; decode decodes the
+// value of the current key. Returning an error from fn stops the iteration.
+func (s *Store) Each(bucket string, fn func(key string, decode func(v any) error) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, data []byte) error {
+			return fn(string(k), func(v any) error {
+				return s.decode(bucket, k, data, v)
+			})
+		})
+	})
+}
+
+// DeleteFunc deletes all keys of a bucket for which fn returns true.
+func (s *Store) DeleteFunc(bucket string, fn func(key string, decode func(v any) error) bool) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+
+		if b == nil {
+			return nil
+		}
+
+		var keys [][]byte
+
+		b.ForEach(func(k, data []byte) error {
+			decode := func(v any) error {
+				return s.decode(bucket, k, data, v)
+			}
+
+			if fn(string(k), decode) {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+
+			return nil
+		})
+
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Wipe deletes all buckets.
+func (s *Store) Wipe() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var names [][]byte
+
+		tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, append([]byte(nil), name...))
+			return nil
+		})
+
+		for _, name := range names {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Export writes all buckets as decrypted JSON lines of bucket, key and value.
+func (s *Store) Export(w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return b.ForEach(func(k, data []byte) error {
+				var value json.RawMessage
+
+				if err := s.decode(string(name), k, data, &value); err != nil {
+					return fmt.Errorf("%s/%s: %w", name, k, err)
+				}
+
+				key := string(k)
+
+				if len(k) == 8 && isSequence(b, k) {
+					key = fmt.Sprint(binary.BigEndian.Uint64(k))
+				}
+
+				return enc.Encode(map[string]any{
+					"bucket": string(name),
+					"key":    key,
+					"value":  value,
+				})
+			})
+		})
+	})
+}
+
+// Compact rewrites the store at path to reclaim the space of deleted
+// values. The store must not be in use.
+func Compact(path string) (before, after int64, err error) {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	src, err := openDB(path)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer src.Close()
+
+	tmp := path + ".compact"
+	os.Remove(tmp)
+
+	dst, err := bolt.Open(tmp, 0600, nil)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := bolt.Compact(dst, src, 64*1024*1024); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+
+		return 0, 0, err
+	}
+
+	dst.Close()
+	src.Close()
+
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, 0, err
+	}
+
+	compacted, err := os.Stat(path)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return info.Size(), compacted.Size(), nil
+}
+
+// Remove deletes the store at path. The store must not be in use.
+func Remove(path string) error {
+	db, err := openDB(path)
+
+	if err != nil {
+		return err
+	}
+
+	db.Close()
+
+	return os.Remove(path)
+}
+
+func (s *Store) put(tx *bolt.Tx, bucket string, key []byte, v any) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+
+	if err != nil {
+		return err
+	}
+
+	data, err := s.encode(bucket, key, v)
+
+	if err != nil {
+		return err
+	}
+
+	return b.Put(key, data)
+}
+
+func (s *Store) append(tx *bolt.Tx, bucket string, v any) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+
+	if err != nil {
+		return err
+	}
+
+	seq, err := b.NextSequence()
+
+	if err != nil {
+		return err
+	}
+
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+
+	return s.put(tx, bucket, key, v)
+}
+
+// encode seals the JSON of v; bucket and key are authenticated, so values
+// cannot be moved to other keys unnoticed.
+func (s *Store) encode(bucket string, key []byte, v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(data)+s.aead.Overhead())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return s.aead.Seal(nonce, nonce, data, additionalData(bucket, key)), nil
+}
+
+func (s *Store) decode(bucket string, key, data []byte, v any) error {
+	size := s.aead.NonceSize()
+
+	if len(data) < size {
+		return errors.New("invalid value")
+	}
+
+	plain, err := s.aead.Open(nil, data[:size], data[size:], additionalData(bucket, key))
+
+	if err != nil {
+		return errors.New("failed to decrypt value, the store key may have changed")
+	}
+
+	return json.Unmarshal(plain, v)
+}
+
+func additionalData(bucket string, key []byte) []byte {
+	return append([]byte(bucket+"/"), key...)
+}
+
+func isSequence(b *bolt.Bucket, k []byte) bool {
+	return b.Sequence() >= binary.BigEndian.Uint64(k)
+}
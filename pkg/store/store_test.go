@@ -0,0 +1,183 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bridge.db")
+	key := newKey()
+
+	s := openStore(t, path, key)
+
+	if err := s.Put("state", "namespaces", map[string]string{"dev": "payments"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var value map[string]string
+
+	if err := s.Get("state", "namespaces", &value); err != nil || value["dev"] != "payments" {
+		t.Fatalf("expected the value to be read back, got %v %v", value, err)
+	}
+
+	if err := s.Get("state", "missing", &value); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("error = %v, want %v", err, ErrNotFound)
+	}
+
+	if err := s.Get("missing", "namespaces", &value); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("error = %v, want %v", err, ErrNotFound)
+	}
+
+	if err := s.Append("audit", "first", "second", "third"); err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []string
+
+	s.Each("audit", func(key string, decode func(v any) error) error {
+		var entry string
+		decode(&entry)
+
+		entries = append(entries, entry)
+		return nil
+	})
+
+	if strings.Join(entries, ",") != "first,second,third" {
+		t.Fatalf("entries = %v, want insertion order", entries)
+	}
+
+	s.DeleteFunc("audit", func(key string, decode func(v any) error) bool {
+		var entry string
+		decode(&entry)
+
+		return entry != "second"
+	})
+
+	entries = nil
+
+	s.Each("audit", func(key string, decode func(v any) error) error {
+		var entry string
+		decode(&entry)
+
+		entries = append(entries, entry)
+		return nil
+	})
+
+	if strings.Join(entries, ",") != "second" {
+		t.Fatalf("entries = %v, want second", entries)
+	}
+
+	var export bytes.Buffer
+
+	if err := s.Export(&export); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(export.String(), `"value":{"dev":"payments"}`) {
+		t.Fatalf("expected a decrypted export, got %s", export.String())
+	}
+
+	s.Close()
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(data, []byte("payments")) {
+		t.Fatal("expected values to be encrypted at rest")
+	}
+}
+
+func TestStoreDecrypt(t *testing.T) {
+	key := newKey()
+
+	tests := []struct {
+		name   string
+		key    []byte
+		modify func(tx *bolt.Tx) error
+	}{
+		{"wrong key", newKey(), nil},
+		{"tampered value", key, func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("state"))
+
+			data := bytes.Clone(b.Get([]byte("token")))
+			data[len(data)-1] ^= 0xff
+
+			return b.Put([]byte("token"), data)
+		}},
+		{"truncated value", key, func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("state")).Put([]byte("token"), []byte("short"))
+		}},
+		{"moved value", key, func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("state"))
+			return b.Put([]byte("token"), bytes.Clone(b.Get([]byte("other"))))
+		}},
+		{"moved bucket", key, func(tx *bolt.Tx) error {
+			other := tx.Bucket([]byte("other"))
+			return tx.Bucket([]byte("state")).Put([]byte("token"), bytes.Clone(other.Get([]byte("token"))))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "bridge.db")
+
+			s := openStore(t, path, key)
+
+			s.Put("state", "token", "secret")
+			s.Put("state", "other", "secret")
+			s.Put("other", "token", "secret")
+
+			if tt.modify != nil {
+				if err := s.db.Update(tt.modify); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s.Close()
+
+			s = openStore(t, path, tt.key)
+
+			var value string
+
+			if err := s.Get("state", "token", &value); err == nil || errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected the value not to be decrypted, got %q %v", value, err)
+			}
+
+			if err := s.Export(&bytes.Buffer{}); err == nil {
+				t.Fatal("expected the export to fail")
+			}
+		})
+	}
+}
+
+func TestStoreInvalidKey(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "bridge.db"), []byte("short")); err == nil {
+		t.Fatal("expected an invalid key to be rejected")
+	}
+}
+
+func openStore(t *testing.T, path string, key []byte) *Store {
+	t.Helper()
+
+	s, err := Open(path, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	return s
+}